@@ -61,7 +61,7 @@ func InitAndExecute(ctx context.Context, streams genericclioptions.IOStreams) {
 
 	if err := RootCmd(ctx, o).Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(cli.ExitCode(err))
 	}
 }
 
@@ -122,15 +122,55 @@ func RootCmd(ctx context.Context, o *krewOptions) *cobra.Command {
 
 	// preq options
 	cmd.Flags().StringVarP(&cli.Options.Action, "action", "a", "", ux.HelpAction)
+	cmd.Flags().StringVarP(&cli.Options.Begin, "begin", "b", "", ux.HelpBegin)
+	cmd.Flags().StringVar(&cli.Options.Baseline, "baseline", "", ux.HelpBaseline)
+	cmd.Flags().StringVar(&cli.Options.Checkpoint, "checkpoint", "", ux.HelpCheckpoint)
+	cmd.Flags().BoolVar(&cli.Options.Collapse, "collapse", false, ux.HelpCollapse)
+	cmd.Flags().StringVar(&cli.Options.ConfigDir, "config-dir", "", ux.HelpConfigDir)
+	cmd.Flags().IntVar(&cli.Options.Context, "context", 0, ux.HelpContext)
+	cmd.Flags().BoolVar(&cli.Options.Coverage, "coverage", false, ux.HelpCoverage)
+	cmd.Flags().DurationVar(&cli.Options.DedupWindow, "dedup-window", 0, ux.HelpDedupWindow)
 	cmd.Flags().BoolVarP(&cli.Options.Disabled, "disabled", "d", false, ux.HelpDisabled)
+	cmd.Flags().StringVarP(&cli.Options.End, "end", "e", "", ux.HelpEnd)
+	cmd.Flags().StringSliceVar(&cli.Options.ExcludeTags, "exclude-tags", nil, ux.HelpExcludeTags)
+	cmd.Flags().StringVar(&cli.Options.Explain, "explain", "", ux.HelpExplain)
+	cmd.Flags().StringVar(&cli.Options.FailOn, "fail-on", "", ux.HelpFailOn)
+	cmd.Flags().BoolVarP(&cli.Options.Follow, "follow", "f", false, ux.HelpFollow)
 	cmd.Flags().BoolVarP(&cli.Options.Cron, "cron", "j", false, ux.HelpCron)
 	cmd.Flags().BoolVarP(&cli.Options.Generate, "generate", "g", false, ux.HelpGenerate)
+	cmd.Flags().Int64Var(&cli.Options.Head, "head", 0, ux.HelpHead)
 	cmd.Flags().StringVarP(&cli.Options.Level, "level", "l", "", ux.HelpLevel)
+	cmd.Flags().DurationVar(&cli.Options.Lookback, "lookback", 0, ux.HelpLookback)
+	cmd.Flags().Int64Var(&cli.Options.MaxLinesPerSource, "max-lines-per-source", 0, ux.HelpMaxLines)
+	cmd.Flags().IntVar(&cli.Options.MemoryLimit, "memory-limit", 0, ux.HelpMemoryLimit)
+	cmd.Flags().StringVar(&cli.Options.MinSeverity, "min-severity", "", ux.HelpMinSeverity)
 	cmd.Flags().StringVarP(&cli.Options.Name, "name", "o", "", ux.HelpName)
+	cmd.Flags().IntVar(&cli.Options.Parallel, "parallel", 0, ux.HelpParallel)
+	cmd.Flags().StringVarP(&cli.Options.Policy, "policy", "p", "", ux.HelpPolicy)
+	cmd.Flags().StringVar(&cli.Options.Profile, "profile", "", ux.HelpProfile)
+	cmd.Flags().StringVar(&cli.Options.Pprof, "pprof", "", ux.HelpPprof)
+	cmd.Flags().BoolVar(&cli.Options.ProfileRules, "profile-rules", false, ux.HelpProfileRules)
+	cmd.Flags().StringVar(&cli.Options.QueuePolicy, "queue-policy", "", ux.HelpQueuePolicy)
+	cmd.Flags().IntVar(&cli.Options.QueueSize, "queue-size", 0, ux.HelpQueueSize)
 	cmd.Flags().BoolVarP(&cli.Options.Quiet, "quiet", "q", false, ux.HelpQuiet)
 	cmd.Flags().StringVarP(&cli.Options.Rules, "rules", "r", "", ux.HelpRules)
+	cmd.Flags().DurationVar(&cli.Options.RuleTimeout, "rule-timeout", 0, ux.HelpRuleTimeout)
+	cmd.Flags().BoolVar(&cli.Options.Rotated, "rotated", false, ux.HelpRotated)
+	cmd.Flags().Float64Var(&cli.Options.SampleRate, "sample-rate", 0, ux.HelpSampleRate)
+	cmd.Flags().StringVar(&cli.Options.StdinFormat, "stdin-format", "", ux.HelpStdinFormat)
+	cmd.Flags().StringSliceVar(&cli.Options.Tags, "tags", nil, ux.HelpTags)
+	cmd.Flags().Int64Var(&cli.Options.Tail, "tail", 0, ux.HelpTail)
+	cmd.Flags().StringVar(&cli.Options.Token, "token", "", ux.HelpToken)
+	cmd.Flags().StringVar(&cli.Options.Trace, "trace", "", ux.HelpTrace)
+	cmd.Flags().StringVar(&cli.Options.Tz, "tz", "", ux.HelpTz)
 	cmd.Flags().BoolVarP(&cli.Options.Version, "version", "v", false, ux.HelpVersion)
+	cmd.Flags().IntVar(&cli.Options.Year, "year", 0, ux.HelpYear)
 	cmd.Flags().BoolVarP(&cli.Options.AcceptUpdates, "accept-updates", "y", false, ux.HelpAcceptUpdates)
+	cmd.Flags().BoolVar(&cli.Options.NoUpdateCheck, "no-update-check", false, ux.HelpNoUpdateCheck)
+	cmd.Flags().BoolVar(&cli.Options.NoStrictConfig, "no-strict-config", false, ux.HelpNoStrict)
+	cmd.Flags().BoolVar(&cli.Options.NoProjectConfig, "no-project-config", false, ux.HelpNoProject)
+	cmd.Flags().BoolVar(&cli.Options.TrustProject, "trust-project", false, ux.HelpTrustProject)
+	cmd.Flags().BoolVar(&cli.Options.Offline, "offline", false, ux.HelpOffline)
 
 	cobra.OnInitialize(initConfig)
 
@@ -348,7 +388,7 @@ func redirectPodLogs(ctx context.Context, clientset *kubernetes.Clientset, names
 
 		if curr, err := clientset.CoreV1().
 			Pods(namespace).
-			GetLogs(pod, &v1.PodLogOptions{}).
+			GetLogs(pod, &v1.PodLogOptions{Follow: cli.Options.Follow}).
 			Stream(ctx); err == nil {
 			_, _ = io.Copy(pw, curr)
 			_ = curr.Close()
@@ -14,17 +14,125 @@ import (
 )
 
 var vars = kong.Vars{
-	"actionHelp":        ux.HelpAction,
-	"disabledHelp":      ux.HelpDisabled,
-	"generateHelp":      ux.HelpGenerate,
-	"cronHelp":          ux.HelpCron,
-	"levelHelp":         ux.HelpLevel,
-	"nameHelp":          ux.HelpName,
-	"quietHelp":         ux.HelpQuiet,
-	"rulesHelp":         ux.HelpRules,
-	"sourceHelp":        ux.HelpSource,
-	"versionHelp":       ux.HelpVersion,
-	"acceptUpdatesHelp": ux.HelpAcceptUpdates,
+	"actionHelp":           ux.HelpAction,
+	"beginHelp":            ux.HelpBegin,
+	"baselineHelp":         ux.HelpBaseline,
+	"disabledHelp":         ux.HelpDisabled,
+	"checkpointHelp":       ux.HelpCheckpoint,
+	"collapseHelp":         ux.HelpCollapse,
+	"configDirHelp":        ux.HelpConfigDir,
+	"contextHelp":          ux.HelpContext,
+	"coverageHelp":         ux.HelpCoverage,
+	"dedupWindowHelp":      ux.HelpDedupWindow,
+	"endHelp":              ux.HelpEnd,
+	"excludeTagsHelp":      ux.HelpExcludeTags,
+	"explainHelp":          ux.HelpExplain,
+	"failOnHelp":           ux.HelpFailOn,
+	"followHelp":           ux.HelpFollow,
+	"generateHelp":         ux.HelpGenerate,
+	"cronHelp":             ux.HelpCron,
+	"headHelp":             ux.HelpHead,
+	"levelHelp":            ux.HelpLevel,
+	"lookbackHelp":         ux.HelpLookback,
+	"maxLinesHelp":         ux.HelpMaxLines,
+	"memoryLimitHelp":      ux.HelpMemoryLimit,
+	"minSeverityHelp":      ux.HelpMinSeverity,
+	"nameHelp":             ux.HelpName,
+	"parallelHelp":         ux.HelpParallel,
+	"policyHelp":           ux.HelpPolicy,
+	"profileHelp":          ux.HelpProfile,
+	"pprofHelp":            ux.HelpPprof,
+	"profileRulesHelp":     ux.HelpProfileRules,
+	"queuePolicyHelp":      ux.HelpQueuePolicy,
+	"queueSizeHelp":        ux.HelpQueueSize,
+	"quietHelp":            ux.HelpQuiet,
+	"daemonHelp":           ux.HelpDaemon,
+	"reportHelp":           ux.HelpReport,
+	"reportGraphHelp":      ux.HelpReportGraph,
+	"reportPathHelp":       ux.HelpReportPath,
+	"rulesCmdHelp":         ux.HelpRulesCmd,
+	"rulesListHelp":        ux.HelpRulesList,
+	"rulesShowHelp":        ux.HelpRulesShow,
+	"rulesSearchHelp":      ux.HelpRulesSearch,
+	"searchWordsHelp":      ux.HelpSearchWords,
+	"searchTechnologyHelp": ux.HelpSearchTech,
+	"searchCategoryHelp":   ux.HelpSearchCat,
+	"searchTagHelp":        ux.HelpSearchTag,
+	"searchSeverityHelp":   ux.HelpSearchSev,
+	"rulesLintHelp":        ux.HelpRulesLint,
+	"lintPathsHelp":        ux.HelpLintPaths,
+	"rulesTestHelp":        ux.HelpRulesTest,
+	"testPathsHelp":        ux.HelpTestPaths,
+	"rulesExportHelp":      ux.HelpRulesExport,
+	"exportBundleHelp":     ux.HelpExportBundle,
+	"rulesImportHelp":      ux.HelpRulesImport,
+	"importBundleHelp":     ux.HelpImportBundle,
+	"rulesUpdateHelp":      ux.HelpRulesUpdate,
+	"updateCheckHelp":      ux.HelpUpdateCheck,
+	"rulesDiffHelp":        ux.HelpRulesDiff,
+	"diffOldHelp":          ux.HelpDiffOld,
+	"diffNewHelp":          ux.HelpDiffNew,
+	"rulesCacheHelp":       ux.HelpRulesCache,
+	"cacheInfoHelp":        ux.HelpCacheInfo,
+	"cachePruneHelp":       ux.HelpCachePrune,
+	"cacheClearHelp":       ux.HelpCacheClear,
+	"cacheKeepHelp":        ux.HelpCacheKeep,
+	"rulesGetHelp":         ux.HelpRulesGet,
+	"getOutputHelp":        ux.HelpGetOutput,
+	"getForceHelp":         ux.HelpGetForce,
+	"creIdHelp":            ux.HelpCreId,
+	"jsonHelp":             ux.HelpJson,
+	"reportSourcesHelp":    ux.HelpReportSources,
+	"sourcesFormatHelp":    ux.HelpSourcesFormat,
+	"graphFormatHelp":      ux.HelpGraphFormat,
+	"graphWindowHelp":      ux.HelpGraphWindow,
+	"rulesHelp":            ux.HelpRules,
+	"ruleTimeoutHelp":      ux.HelpRuleTimeout,
+	"rotatedHelp":          ux.HelpRotated,
+	"sampleRateHelp":       ux.HelpSampleRate,
+	"sourceHelp":           ux.HelpSource,
+	"stdinFormatHelp":      ux.HelpStdinFormat,
+	"tagsHelp":             ux.HelpTags,
+	"tailHelp":             ux.HelpTail,
+	"traceHelp":            ux.HelpTrace,
+	"tzHelp":               ux.HelpTz,
+	"versionHelp":          ux.HelpVersion,
+	"yearHelp":             ux.HelpYear,
+	"acceptUpdatesHelp":    ux.HelpAcceptUpdates,
+	"noUpdateCheckHelp":    ux.HelpNoUpdateCheck,
+	"noStrictConfigHelp":   ux.HelpNoStrict,
+	"noProjectConfigHelp":  ux.HelpNoProject,
+	"trustProjectHelp":     ux.HelpTrustProject,
+	"offlineHelp":          ux.HelpOffline,
+	"tokenHelp":            ux.HelpToken,
+
+	"loginHelp":    ux.HelpLogin,
+	"loginSsoHelp": ux.HelpLoginSso,
+
+	"authCmdHelp":     ux.HelpAuthCmd,
+	"authStatusHelp":  ux.HelpAuthStatus,
+	"authLogoutHelp":  ux.HelpAuthLogout,
+	"authRefreshHelp": ux.HelpAuthRefresh,
+
+	"profileCmdHelp":  ux.HelpProfileCmd,
+	"profileListHelp": ux.HelpProfileList,
+	"profileUseHelp":  ux.HelpProfileUse,
+	"profileNameHelp": ux.HelpProfileName,
+
+	"configCmdHelp":   ux.HelpConfigCmd,
+	"configInitHelp":  ux.HelpConfigInit,
+	"configGetHelp":   ux.HelpConfigGet,
+	"configSetHelp":   ux.HelpConfigSet,
+	"configViewHelp":  ux.HelpConfigView,
+	"configForceHelp": ux.HelpConfigForce,
+	"configKeyHelp":   ux.HelpConfigKey,
+	"configValueHelp": ux.HelpConfigValue,
+	"secretCmdHelp":   ux.HelpSecretCmd,
+	"secretNameHelp":  ux.HelpSecretName,
+
+	"installCompletionsHelp": ux.HelpInstallCompletions,
+	"completionShellHelp":    ux.HelpCompletionShell,
+	"completionPrintHelp":    ux.HelpCompletionPrint,
 }
 
 func main() {
@@ -46,7 +154,7 @@ func main() {
 		kongplete.WithPredictor("file", complete.PredictFiles("*")),
 	)
 
-	kong.Parse(&cli.Options, vars)
+	kctx := kong.Parse(&cli.Options, vars)
 
 	logOpts := []logs.InitOpt{
 		logs.WithLevel(cli.Options.Level),
@@ -56,7 +164,7 @@ func main() {
 	// Initialize logger first before any other logging
 	logs.InitLogger(logOpts...)
 
-	if err = cli.InitAndExecute(ctx); err != nil {
-		os.Exit(1)
+	if err = cli.Execute(ctx, kctx.Command()); err != nil {
+		os.Exit(cli.ExitCode(err))
 	}
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/prequel-dev/preq/internal/pkg/cli"
+	"github.com/prequel-dev/preq/internal/pkg/resolve"
+)
+
+func TestBegin(t *testing.T) {
+
+	now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	tests := map[string][]string{
+		"duration":          {"-b", "24h"},
+		"negative duration": {"--begin=-24h"},
+		"short":             {"-b-24h"},
+	}
+
+	for name, args := range tests {
+		t.Run(name, func(t *testing.T) {
+
+			parser, err := kong.New(&cli.Options, kong.Vars(vars), kong.Exit(func(int) {}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cli.Options.Begin = ""
+			if _, err = parser.Parse(args); err != nil {
+				t.Fatalf("Parse %q: %v", args, err)
+			}
+
+			begin, err := resolve.ParseTime(cli.Options.Begin, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := now.Add(-24 * time.Hour); !begin.Equal(want) {
+				t.Errorf("Expected %v, got %v", want, begin)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+//go:build wasm
+
+package main
+
+import (
+	"errors"
+	"io"
+	"syscall/js"
+)
+
+var (
+	ErrInvalidBlob = errors.New("expected a File or Blob")
+)
+
+// blobReader pulls a browser File/Blob through its ReadableStream so the
+// engine never needs the whole file on the JS or Go heap.
+type blobReader struct {
+	reader   js.Value
+	progress js.Value
+	pending  []byte
+	total    int64
+	read     int64
+	done     bool
+}
+
+func newBlobReader(blob, progress js.Value) (*blobReader, error) {
+	if blob.Type() != js.TypeObject || blob.Get("stream").Type() != js.TypeFunction {
+		return nil, ErrInvalidBlob
+	}
+
+	return &blobReader{
+		reader:   blob.Call("stream").Call("getReader"),
+		progress: progress,
+		total:    int64(blob.Get("size").Float()),
+	}, nil
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+
+	for len(b.pending) == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+func (b *blobReader) fill() error {
+
+	res, err := await(b.reader.Call("read"))
+	if err != nil {
+		return err
+	}
+
+	if res.Get("done").Bool() {
+		b.done = true
+		b.report()
+		return nil
+	}
+
+	chunk := res.Get("value")
+	buf := make([]byte, chunk.Get("byteLength").Int())
+	js.CopyBytesToGo(buf, chunk)
+
+	b.pending = buf
+	b.read += int64(len(buf))
+	b.report()
+
+	return nil
+}
+
+func (b *blobReader) report() {
+	if b.progress.Type() != js.TypeFunction {
+		return
+	}
+	b.progress.Invoke(b.read, b.total)
+}
+
+func (b *blobReader) Close() error {
+	b.reader.Call("releaseLock")
+	return nil
+}
+
+// await blocks the calling goroutine until the promise settles. It must not
+// be called from the JS event loop goroutine.
+func await(promise js.Value) (js.Value, error) {
+
+	var (
+		resCh = make(chan js.Value, 1)
+		errCh = make(chan error, 1)
+	)
+
+	onResolve := js.FuncOf(func(this js.Value, args []js.Value) any {
+		resCh <- args[0]
+		return nil
+	})
+	defer onResolve.Release()
+
+	onReject := js.FuncOf(func(this js.Value, args []js.Value) any {
+		errCh <- errors.New(args[0].Call("toString").String())
+		return nil
+	})
+	defer onReject.Release()
+
+	promise.Call("then", onResolve, onReject)
+
+	select {
+	case res := <-resCh:
+		return res, nil
+	case err := <-errCh:
+		return js.Undefined(), err
+	}
+}
+
+// newPromise runs fn on its own goroutine and settles a JS promise with the
+// returned string.
+func newPromise(fn func() string) js.Value {
+
+	var executor js.Func
+	executor = js.FuncOf(func(this js.Value, args []js.Value) any {
+		resolve := args[0]
+		go func() {
+			defer executor.Release()
+			resolve.Invoke(fn())
+		}()
+		return nil
+	})
+
+	return js.Global().Get("Promise").New(executor)
+}
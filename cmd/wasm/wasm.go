@@ -21,7 +21,8 @@ var (
 )
 
 const (
-	expectedArgs = 3
+	expectedArgs     = 3
+	expectedFileArgs = 2
 )
 
 type ResultT struct {
@@ -94,11 +95,60 @@ func detectWrapper(ctx context.Context) js.Func {
 	return detectFunc
 }
 
+// detectFile accepts a File/Blob, rule data and an optional progress callback
+// invoked as progress(bytesRead, totalBytes). It returns a Promise resolving
+// to the same JSON document as detect.
+func detectFileWrapper(ctx context.Context) js.Func {
+	detectFileFunc := js.FuncOf(func(this js.Value, args []js.Value) any {
+
+		if len(args) < expectedFileArgs {
+			return errJson(ErrInvalidArgs)
+		}
+
+		var (
+			blob     = args[0]
+			ruleData = args[1].String()
+			progress = js.Undefined()
+		)
+
+		if len(args) > expectedFileArgs {
+			progress = args[2]
+		}
+
+		return newPromise(func() string {
+
+			var (
+				rdr       *blobReader
+				reportDoc ux.ReportDocT
+				stats     ux.StatsT
+				err       error
+			)
+
+			if rdr, err = newBlobReader(blob, progress); err != nil {
+				return errJson(err)
+			}
+			defer rdr.Close()
+
+			// Permit events to arrive out of order within a 1 hour window by default
+			cfg := config.DefaultConfig(config.WithWindow(time.Hour))
+
+			if reportDoc, stats, err = eval.DetectReader(ctx, cfg, rdr, ruleData); err != nil {
+				return errJson(err)
+			}
+
+			return respJson(reportDoc, stats)
+		})
+	})
+
+	return detectFileFunc
+}
+
 func main() {
 
 	ctx := context.Background()
 
 	js.Global().Set("detect", detectWrapper(ctx))
+	js.Global().Set("detectFile", detectFileWrapper(ctx))
 
 	select {}
 }
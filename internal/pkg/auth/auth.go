@@ -19,6 +19,7 @@ import (
 
 	"github.com/avast/retry-go/v4"
 	"github.com/golang-jwt/jwt"
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
 	"github.com/prequel-dev/preq/internal/pkg/ux"
 	"github.com/rs/zerolog/log"
 )
@@ -30,6 +31,10 @@ const (
 	emailNotVerified = "email not verified"
 )
 
+const (
+	ApiTokenEnv = "PREQ_API_TOKEN"
+)
+
 const (
 	TokenTypePrequel = "prequel-token"
 	TokenTypeId      = "oidc-id-token"
@@ -43,6 +48,8 @@ var (
 	ErrInvalidJson        = errors.New("invalid JSON")
 	ErrEmailNotVerified   = errors.New("email not verified")
 	ErrAuthFailure        = errors.New("auth failure")
+	ErrNotLoggedIn        = errors.New("not logged in")
+	ErrInvalidApiToken    = errors.New("invalid API token")
 )
 
 type UserClaims struct {
@@ -137,7 +144,7 @@ func startAuth(ctx context.Context, url string) (*DeviceAuth, error) {
 
 	httpRequest.Header.Set("Accept", "application/json")
 
-	client := &http.Client{}
+	client := httpz.New(httpz.WithName("auth"))
 
 	return retry.DoWithData(
 		func() (*DeviceAuth, error) {
@@ -189,7 +196,7 @@ func tokenPoll(ctx context.Context, url string, req *TokenPollRequest) (*TokenPo
 
 	httpRequest.Header.Set("Accept", "application/json")
 
-	client := &http.Client{}
+	client := httpz.New(httpz.WithName("auth"))
 
 	return retry.DoWithData(
 		func() (*TokenPollResponse, error) {
@@ -241,7 +248,7 @@ func exchangeApi(ctx context.Context, url string, req *TokenExchangeRequest) (*T
 
 	httpRequest.Header.Set("Accept", "application/json")
 
-	client := &http.Client{}
+	client := httpz.New(httpz.WithName("auth"))
 
 	return retry.DoWithData(
 		func() (*Token, error) {
@@ -316,13 +323,17 @@ func exchangeApi(ctx context.Context, url string, req *TokenExchangeRequest) (*T
 
 func exchangeRulesToken(ctx context.Context, envUrl string, tpr *TokenPollResponse) (*Token, error) {
 
+	// This is untrusted user input that can be tampered with
+	return exchangeRules(ctx, envUrl, &TokenExchangeRequest{
+		AccessToken: tpr.AccessToken,
+		IdToken:     tpr.IdToken,
+		OrgUuid:     tpr.OrgUuid,
+	})
+}
+
+func exchangeRules(ctx context.Context, envUrl string, req *TokenExchangeRequest) (*Token, error) {
+
 	var (
-		// This is untrusted user input that can be tampered with
-		req = &TokenExchangeRequest{
-			AccessToken: tpr.AccessToken,
-			IdToken:     tpr.IdToken,
-			OrgUuid:     tpr.OrgUuid,
-		}
 		url   = fmt.Sprintf("%s/v1/auth/exchange_rules", envUrl)
 		token *Token
 		email string
@@ -386,20 +397,28 @@ type TokenExchangeRequest struct {
 	AccessToken string `json:"access_token" binding:"required"`
 	IdToken     string `json:"id_token" binding:"required"`
 	OrgUuid     string `json:"org_uuid" binding:"required"`
+	Issuer      string `json:"issuer,omitempty"` // identity provider of an sso login
 }
 
 func saveToken(token, path string) error {
-	if err := os.WriteFile(path, []byte(token), 0644); err != nil {
-		return err
+	return writeSecret(path, []byte(token))
+}
+
+func checkLocalToken(path string) (string, error) {
+
+	token, err := readSecret(path)
+	if err != nil {
+		return "", err
 	}
 
-	return nil
+	return checkToken(string(token))
 }
 
-func checkLocalToken(path string) (string, error) {
+// checkToken validates a token issued by Prequel and checks it has not
+// expired.
+func checkToken(token string) (string, error) {
 
 	var (
-		token          []byte
 		publicKey      *rsa.PublicKey
 		validatedToken *jwt.Token
 		claims         *UserClaims
@@ -407,17 +426,13 @@ func checkLocalToken(path string) (string, error) {
 		err            error
 	)
 
-	if token, err = os.ReadFile(path); err != nil {
-		return "", err
-	}
-
 	if publicKey, err = jwt.ParseRSAPublicKeyFromPEM(publicJwtKeyPEM); err != nil {
 		log.Error().Err(err).Msg("Failed to parse public key")
 		return "", err
 	}
 
 	// Validate and parse the token
-	validatedToken, err = jwt.ParseWithClaims(string(token), &UserClaims{}, func(token *jwt.Token) (any, error) {
+	validatedToken, err = jwt.ParseWithClaims(token, &UserClaims{}, func(token *jwt.Token) (any, error) {
 		return publicKey, nil
 	})
 
@@ -439,20 +454,79 @@ func checkLocalToken(path string) (string, error) {
 	return validatedToken.Raw, nil
 }
 
+// ApiToken returns the service token of the command line, or else of
+// ApiTokenEnv, if either is set.
+func ApiToken(flag string) string {
+	if flag != "" {
+		return strings.TrimSpace(flag)
+	}
+	return strings.TrimSpace(os.Getenv(ApiTokenEnv))
+}
+
+// CheckApiToken validates a service token. Unlike a login it is never
+// saved, and never needs a browser or email verification, so it suits CI
+// pipelines and containers.
+func CheckApiToken(token string) (string, error) {
+	token, err := checkToken(token)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidApiToken, err)
+	}
+	return token, nil
+}
+
+// LocalToken returns the token saved by Login, without logging in.
+func LocalToken(tokenPath string) (string, error) {
+	token, err := checkLocalToken(tokenPath)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrNotLoggedIn, err)
+	}
+	return token, nil
+}
+
+// Login returns the saved token, or else logs in. A login through the
+// identity provider is refreshed, or done again; any other by device auth
+// and email verification.
 func Login(ctx context.Context, baseAddr, tokenPath string) (string, error) {
 
+	apiUri := fmt.Sprintf("https://%s:443", baseAddr)
+
+	if token, err := checkLocalToken(tokenPath); err == nil {
+		return token, nil
+	}
+
+	if s, err := readSsoSession(tokenPath); err == nil && s.Issuer != "" {
+		if s.RefreshToken != "" {
+			if token, err := refresh(ctx, apiUri, tokenPath); err == nil {
+				return token, nil
+			}
+		}
+		return ssoLogin(ctx, apiUri, tokenPath, s)
+	}
+
+	return deviceLogin(ctx, apiUri, tokenPath)
+}
+
+// DeviceLogin logs in by device auth and email verification, whether or
+// not a valid token is saved already, and forgets any login through an
+// identity provider.
+func DeviceLogin(ctx context.Context, baseAddr, tokenPath string) (string, error) {
+
+	token, err := deviceLogin(ctx, fmt.Sprintf("https://%s:443", baseAddr), tokenPath)
+	if err != nil {
+		return "", err
+	}
+
+	return token, removeSsoSession(tokenPath)
+}
+
+func deviceLogin(ctx context.Context, apiUri, tokenPath string) (string, error) {
+
 	var (
 		deviceAuth *DeviceAuth
-		cmd        *exec.Cmd
 		uri        *url.URL
-		apiUri     = fmt.Sprintf("https://%s:443", baseAddr)
 		err        error
 	)
 
-	if token, err := checkLocalToken(tokenPath); err == nil {
-		return token, nil
-	}
-
 	if deviceAuth, err = startAuth(ctx, fmt.Sprintf("%s/v1/auth/rules", apiUri)); err != nil {
 		log.Error().Err(err).Msg("Failed to start device auth")
 		return "", err
@@ -471,17 +545,7 @@ func Login(ctx context.Context, baseAddr, tokenPath string) (string, error) {
 			return "", ErrInvalidDeviceAuth
 		}
 
-		switch runtime.GOOS {
-		case "linux":
-			cmd = exec.Command("xdg-open", uri.String())
-			cmd.Start()
-		case "darwin":
-			cmd = exec.Command("open", uri.String())
-			cmd.Start()
-		case "windows":
-			cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", uri.String())
-			cmd.Start()
-		}
+		openBrowser(uri)
 
 	} else {
 		log.Error().Msg("Invalid deviceAuth")
@@ -512,3 +576,22 @@ func Login(ctx context.Context, baseAddr, tokenPath string) (string, error) {
 
 	return token.Token, nil
 }
+
+// openBrowser opens uri in the default browser, if there is one.
+var openBrowser = func(uri *url.URL) {
+
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "linux":
+		cmd = exec.Command("xdg-open", uri.String())
+	case "darwin":
+		cmd = exec.Command("open", uri.String())
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", uri.String())
+	default:
+		return
+	}
+
+	cmd.Start()
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -16,6 +17,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/zalando/go-keyring"
 )
 
 var (
@@ -25,6 +27,9 @@ var (
 )
 
 func TestMain(m *testing.M) {
+	// Never touch the keychain of the host
+	keyring.MockInit()
+
 	var err error
 	testPrivateKey, err = rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
@@ -182,6 +187,62 @@ func TestLogin_LocalTokenExists(t *testing.T) {
 	}
 }
 
+func TestLocalToken(t *testing.T) {
+	originalKey := publicJwtKeyPEM
+	publicJwtKeyPEM = testPublicKeyPEM
+	t.Cleanup(func() {
+		publicJwtKeyPEM = originalKey
+	})
+
+	tokenPath := filepath.Join(t.TempDir(), "local.token")
+
+	if _, err := LocalToken(tokenPath); !errors.Is(err, ErrNotLoggedIn) {
+		t.Fatalf("Expected %v without a saved token, got %v", ErrNotLoggedIn, err)
+	}
+
+	expectedToken := generateTestToken(&UserClaims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}, t)
+	os.WriteFile(tokenPath, []byte(expectedToken), 0644)
+
+	token, err := LocalToken(tokenPath)
+	if err != nil || token != expectedToken {
+		t.Errorf("Expected the saved token, got %s (%v)", token, err)
+	}
+}
+
+func TestApiToken(t *testing.T) {
+	originalKey := publicJwtKeyPEM
+	publicJwtKeyPEM = testPublicKeyPEM
+	t.Cleanup(func() {
+		publicJwtKeyPEM = originalKey
+	})
+
+	t.Setenv(ApiTokenEnv, " from-env\n")
+	if got := ApiToken(""); got != "from-env" {
+		t.Errorf("Expected the token of the environment, got %q", got)
+	}
+	if got := ApiToken("from-flag"); got != "from-flag" {
+		t.Errorf("Expected the flag to win, got %q", got)
+	}
+
+	valid := generateTestToken(&UserClaims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}, t)
+	if token, err := CheckApiToken(valid); err != nil || token != valid {
+		t.Errorf("Expected the token accepted, got %s (%v)", token, err)
+	}
+
+	expired := generateTestToken(&UserClaims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(-time.Hour).Unix()},
+	}, t)
+	for _, token := range []string{expired, "not-a-token"} {
+		if _, err := CheckApiToken(token); !errors.Is(err, ErrInvalidApiToken) {
+			t.Errorf("Expected %v, got %v", ErrInvalidApiToken, err)
+		}
+	}
+}
+
 func TestAuthenticationFlow_EndToEnd(t *testing.T) {
 	originalKey := publicJwtKeyPEM
 	publicJwtKeyPEM = testPublicKeyPEM
@@ -0,0 +1,390 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/rs/zerolog/log"
+)
+
+// Enterprise users log in through the identity provider of their
+// organization instead of verifying an email, with the OAuth 2.0 device
+// authorization grant of RFC 8628:
+//
+//	sso:
+//	  issuer: https://login.acme.com/realms/eng
+//	  clientId: preq
+//
+// preq login --sso shows a code to enter at the identity provider, then
+// trades the tokens it issues for a rules token. The refresh token is kept
+// with the rules token and used to get a new one, without prompting, once
+// it expires; the provider may rotate it, so the new one is saved. If the
+// provider no longer accepts it, the next interactive run logs in again.
+
+const (
+	ssoSuffix         = ".sso"
+	wellKnownPath     = "/.well-known/openid-configuration"
+	grantDeviceCode   = "urn:ietf:params:oauth:grant-type:device_code"
+	grantRefreshToken = "refresh_token"
+	ssoPending        = "authorization_pending"
+	ssoSlowDown       = "slow_down"
+	ssoInvalidGrant   = "invalid_grant"
+	ssoSlowDownStep   = 5 * time.Second
+	ssoDefaultPoll    = 5 * time.Second
+	ssoMaxResponse    = 1 << 20
+)
+
+var (
+	ErrSsoNotConfigured = errors.New("sso needs an issuer and a clientId in the config")
+	ErrSsoDiscovery     = errors.New("identity provider does not support the device authorization grant")
+	ErrSsoDenied        = errors.New("sso login denied or expired")
+	ErrSsoRefresh       = errors.New("sso session expired; log in again with preq login --sso")
+
+	ssoDefaultScopes = []string{"openid", "email", "profile", "offline_access"}
+)
+
+// ssoSessionT is what a login through the identity provider leaves to
+// refresh the rules token with.
+type ssoSessionT struct {
+	Issuer       string   `json:"issuer"`
+	ClientId     string   `json:"client_id"`
+	Scopes       []string `json:"scopes"`
+	RefreshToken string   `json:"refresh_token"`
+}
+
+type oidcConfigT struct {
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+}
+
+// SsoLogin logs in through the identity provider of sso and saves the
+// rules token to tokenPath, whether or not a valid one is there already.
+func SsoLogin(ctx context.Context, baseAddr, tokenPath string, sso config.Sso) (string, error) {
+	return ssoLogin(ctx, fmt.Sprintf("https://%s:443", baseAddr), tokenPath, newSsoSession(sso))
+}
+
+// Refresh returns the token saved by a login. An expired token of a login
+// through the identity provider is refreshed, without prompting.
+func Refresh(ctx context.Context, baseAddr, tokenPath string) (string, error) {
+	return refresh(ctx, fmt.Sprintf("https://%s:443", baseAddr), tokenPath)
+}
+
+func newSsoSession(sso config.Sso) *ssoSessionT {
+	s := &ssoSessionT{
+		Issuer:   strings.TrimSuffix(sso.Issuer, "/"),
+		ClientId: sso.ClientId,
+		Scopes:   sso.Scopes,
+	}
+	if len(s.Scopes) == 0 {
+		s.Scopes = ssoDefaultScopes
+	}
+	return s
+}
+
+func ssoLogin(ctx context.Context, apiUri, tokenPath string, s *ssoSessionT) (string, error) {
+
+	if s.Issuer == "" || s.ClientId == "" {
+		return "", ErrSsoNotConfigured
+	}
+
+	oc, err := discover(ctx, s.Issuer)
+	if err != nil {
+		log.Error().Err(err).Str("issuer", s.Issuer).Msg("Failed to discover identity provider")
+		return "", err
+	}
+
+	deviceAuth, err := startSsoAuth(ctx, oc.DeviceAuthorizationEndpoint, s)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start sso device auth")
+		return "", err
+	}
+
+	verifyUrl := deviceAuth.VerificationUriComplete
+	if verifyUrl == "" {
+		verifyUrl = deviceAuth.VerificationUri
+	}
+	if verifyUrl == "" {
+		verifyUrl = deviceAuth.VerificationUrl
+	}
+
+	uri, err := url.Parse(verifyUrl)
+	if err != nil || uri.Scheme != "https" {
+		log.Error().Str("url", verifyUrl).Msg("Invalid verification URI")
+		return "", ErrInvalidDeviceAuth
+	}
+
+	ux.PrintSsoDeviceAuth(uri.String(), deviceAuth.UserCode)
+	openBrowser(uri)
+
+	tpr, err := pollSsoToken(ctx, oc.TokenEndpoint, s.ClientId, deviceAuth)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get sso token")
+		return "", err
+	}
+
+	return exchangeSsoToken(ctx, apiUri, tokenPath, s, tpr)
+}
+
+func refresh(ctx context.Context, apiUri, tokenPath string) (string, error) {
+
+	token, err := checkLocalToken(tokenPath)
+	if err == nil {
+		return token, nil
+	}
+
+	s, serr := readSsoSession(tokenPath)
+	if serr != nil || s.RefreshToken == "" {
+		return "", fmt.Errorf("%w: %w", ErrNotLoggedIn, err)
+	}
+
+	return refreshSso(ctx, apiUri, tokenPath, s)
+}
+
+// refreshSso gets a new rules token with the refresh token of s.
+func refreshSso(ctx context.Context, apiUri, tokenPath string, s *ssoSessionT) (string, error) {
+
+	oc, err := discover(ctx, s.Issuer)
+	if err != nil {
+		log.Error().Err(err).Str("issuer", s.Issuer).Msg("Failed to discover identity provider")
+		return "", err
+	}
+
+	tpr, err := ssoTokenRequest(ctx, oc.TokenEndpoint, url.Values{
+		"grant_type":    {grantRefreshToken},
+		"client_id":     {s.ClientId},
+		"refresh_token": {s.RefreshToken},
+	})
+	switch {
+	case err != nil:
+		return "", err
+	case tpr.Error == ssoInvalidGrant:
+		log.Warn().Str("issuer", s.Issuer).Msg("Identity provider refused the refresh token")
+		s.RefreshToken = ""
+		saveSsoSession(s, tokenPath)
+		return "", ErrSsoRefresh
+	case tpr.Error != "" || tpr.AccessToken == "":
+		log.Error().Str("error", tpr.Error).Str("description", tpr.ErrorDescription).Msg("Failed to refresh sso token")
+		return "", ErrSsoRefresh
+	}
+
+	log.Info().Str("issuer", s.Issuer).Msg("Refreshed sso login")
+
+	return exchangeSsoToken(ctx, apiUri, tokenPath, s, tpr)
+}
+
+// exchangeSsoToken trades the tokens of the identity provider for a rules
+// token, and saves both it and the session, with the refresh token the
+// provider rotated it for if any.
+func exchangeSsoToken(ctx context.Context, apiUri, tokenPath string, s *ssoSessionT, tpr *TokenPollResponse) (string, error) {
+
+	token, err := exchangeRules(ctx, apiUri, &TokenExchangeRequest{
+		AccessToken: tpr.AccessToken,
+		IdToken:     tpr.IdToken,
+		OrgUuid:     tpr.OrgUuid,
+		Issuer:      s.Issuer,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Fail exchangeRules")
+		return "", err
+	}
+
+	if tpr.RefreshToken != "" {
+		s.RefreshToken = tpr.RefreshToken
+	}
+	if err := saveSsoSession(s, tokenPath); err != nil {
+		log.Error().Err(err).Msg("Fail saveSsoSession")
+		return "", err
+	}
+
+	if err := saveToken(token.Token, tokenPath); err != nil {
+		log.Error().Err(err).Msg("Fail saveToken")
+		return "", err
+	}
+
+	return token.Token, nil
+}
+
+func discover(ctx context.Context, issuer string) (*oidcConfigT, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+wellKnownPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	var oc oidcConfigT
+	if err := doJson(req, &oc); err != nil {
+		return nil, err
+	}
+
+	if oc.DeviceAuthorizationEndpoint == "" || oc.TokenEndpoint == "" {
+		return nil, ErrSsoDiscovery
+	}
+
+	return &oc, nil
+}
+
+func startSsoAuth(ctx context.Context, endpoint string, s *ssoSessionT) (*DeviceAuth, error) {
+
+	req, err := formRequest(ctx, endpoint, url.Values{
+		"client_id": {s.ClientId},
+		"scope":     {strings.Join(s.Scopes, " ")},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var deviceAuth DeviceAuth
+	if err := doJson(req, &deviceAuth); err != nil {
+		return nil, err
+	}
+
+	if deviceAuth.DeviceCode == "" {
+		return nil, ErrInvalidDeviceAuth
+	}
+
+	return &deviceAuth, nil
+}
+
+// pollSsoToken polls the token endpoint until the user approves the login,
+// slowing down when asked to.
+func pollSsoToken(ctx context.Context, endpoint, clientId string, deviceAuth *DeviceAuth) (*TokenPollResponse, error) {
+
+	var (
+		interval = time.Duration(deviceAuth.Interval) * time.Second
+		deadline = time.Now().Add(time.Duration(deviceAuth.ExpiresIn) * time.Second)
+		form     = url.Values{
+			"grant_type":  {grantDeviceCode},
+			"client_id":   {clientId},
+			"device_code": {deviceAuth.DeviceCode},
+		}
+	)
+
+	if deviceAuth.Interval == 0 && deviceAuth.ExpiresIn == 0 {
+		interval = ssoDefaultPoll
+	}
+
+	for time.Now().Before(deadline) {
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		tpr, err := ssoTokenRequest(ctx, endpoint, form)
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to poll sso token")
+			continue
+		}
+
+		switch tpr.Error {
+		case "":
+			if tpr.AccessToken != "" {
+				return tpr, nil
+			}
+		case ssoPending:
+		case ssoSlowDown:
+			interval += ssoSlowDownStep
+		default:
+			log.Error().Str("error", tpr.Error).Str("description", tpr.ErrorDescription).Msg("Sso login failed")
+			return nil, fmt.Errorf("%w: %s", ErrSsoDenied, tpr.Error)
+		}
+	}
+
+	return nil, ErrSsoDenied
+}
+
+// ssoTokenRequest posts form to the token endpoint. Errors of the grant
+// are in the response, not the error returned.
+func ssoTokenRequest(ctx context.Context, endpoint string, form url.Values) (*TokenPollResponse, error) {
+
+	req, err := formRequest(ctx, endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+
+	var tpr TokenPollResponse
+	if err := doJson(req, &tpr); err != nil {
+		return nil, err
+	}
+
+	return &tpr, nil
+}
+
+func formRequest(ctx context.Context, endpoint string, form url.Values) (*http.Request, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	return req, nil
+}
+
+// doJson sends req and decodes the JSON response into v. Error responses
+// of OAuth endpoints carry JSON too, so only a body that is not JSON fails.
+func doJson(req *http.Request, v any) error {
+
+	resp, err := httpz.New(httpz.WithName("auth")).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	rb, err := io.ReadAll(io.LimitReader(resp.Body, ssoMaxResponse))
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(rb, v); err != nil {
+		log.Error().Err(err).Int("status", resp.StatusCode).Str("url", req.URL.Redacted()).Msg("Fail unmarshal")
+		return fmt.Errorf("%w: %s %s", ErrInvalidJson, req.URL.Redacted(), resp.Status)
+	}
+
+	return nil
+}
+
+func readSsoSession(tokenPath string) (*ssoSessionT, error) {
+
+	data, err := readSecret(tokenPath + ssoSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	var s ssoSessionT
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func saveSsoSession(s *ssoSessionT, tokenPath string) error {
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return writeSecret(tokenPath+ssoSuffix, data)
+}
+
+// removeSsoSession forgets the login through the identity provider, as a
+// login by email replaces it.
+func removeSsoSession(tokenPath string) error {
+	return removeSecret(tokenPath + ssoSuffix)
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/prequel-dev/preq/internal/pkg/config"
+)
+
+// newSsoServer serves an identity provider and the rules token exchange.
+// The first device code poll is pending; refresh tokens are rotated, and
+// only the latest is accepted.
+func newSsoServer(t *testing.T, rulesToken string) *httptest.Server {
+
+	var (
+		srv     *httptest.Server
+		polls   int
+		refresh = "refresh-1"
+	)
+
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case wellKnownPath:
+			json.NewEncoder(w).Encode(oidcConfigT{
+				DeviceAuthorizationEndpoint: srv.URL + "/device",
+				TokenEndpoint:               srv.URL + "/token",
+			})
+		case "/device":
+			if r.FormValue("client_id") != "preq" || r.FormValue("scope") != "openid email profile offline_access" {
+				t.Errorf("Unexpected device request %v", r.Form)
+			}
+			json.NewEncoder(w).Encode(DeviceAuth{
+				DeviceCode:      "device-code",
+				UserCode:        "ABCD-EFGH",
+				VerificationUri: "https://idp.example.com/device",
+				ExpiresIn:       60,
+			})
+		case "/token":
+			switch r.FormValue("grant_type") {
+			case grantDeviceCode:
+				if polls++; polls == 1 {
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(TokenPollResponse{Error: ssoPending})
+					return
+				}
+			case grantRefreshToken:
+				if r.FormValue("refresh_token") != refresh {
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(TokenPollResponse{Error: ssoInvalidGrant})
+					return
+				}
+				refresh += "'"
+			}
+			json.NewEncoder(w).Encode(TokenPollResponse{
+				AccessToken:  "idp-access-token",
+				IdToken:      "idp-id-token",
+				RefreshToken: refresh,
+			})
+		case "/v1/auth/exchange_rules":
+			var req TokenExchangeRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Issuer != srv.URL || req.AccessToken != "idp-access-token" {
+				t.Errorf("Unexpected exchange request %+v", req)
+			}
+			json.NewEncoder(w).Encode(Token{Token: rulesToken})
+		default:
+			http.NotFound(w, r)
+			t.Errorf("Received unexpected request to path: %s", r.URL.Path)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestSsoLogin(t *testing.T) {
+	originalKey, originalOpen := publicJwtKeyPEM, openBrowser
+	publicJwtKeyPEM = testPublicKeyPEM
+	openBrowser = func(*url.URL) {}
+	t.Cleanup(func() {
+		publicJwtKeyPEM, openBrowser = originalKey, originalOpen
+	})
+
+	rulesToken := generateTestToken(&UserClaims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}, t)
+
+	var (
+		srv       = newSsoServer(t, rulesToken)
+		tokenPath = filepath.Join(t.TempDir(), ruleToken)
+		ctx       = context.Background()
+	)
+
+	if _, err := ssoLogin(ctx, srv.URL, tokenPath, newSsoSession(config.Sso{Issuer: srv.URL})); !errors.Is(err, ErrSsoNotConfigured) {
+		t.Errorf("Expected %v, got %v", ErrSsoNotConfigured, err)
+	}
+
+	token, err := ssoLogin(ctx, srv.URL, tokenPath, newSsoSession(config.Sso{Issuer: srv.URL + "/", ClientId: "preq"}))
+	if err != nil || token != rulesToken {
+		t.Fatalf("Expected the rules token, got %q (%v)", token, err)
+	}
+
+	s, err := readSsoSession(tokenPath)
+	if err != nil || s.Issuer != srv.URL || s.RefreshToken != "refresh-1" {
+		t.Fatalf("Expected the sso session saved, got %+v (%v)", s, err)
+	}
+
+	// A valid token is returned as is
+	if token, err := refresh(ctx, srv.URL, tokenPath); err != nil || token != rulesToken {
+		t.Errorf("Expected the saved token, got %q (%v)", token, err)
+	}
+
+	// An expired one is refreshed, rotating the refresh token
+	expired := generateTestToken(&UserClaims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(-time.Hour).Unix()},
+	}, t)
+	for _, want := range []string{"refresh-1'", "refresh-1''"} {
+		if err := saveToken(expired, tokenPath); err != nil {
+			t.Fatal(err)
+		}
+		if token, err := refresh(ctx, srv.URL, tokenPath); err != nil || token != rulesToken {
+			t.Fatalf("Expected the token refreshed, got %q (%v)", token, err)
+		}
+		if s, _ := readSsoSession(tokenPath); s == nil || s.RefreshToken != want {
+			t.Fatalf("Expected refresh token %s, got %+v", want, s)
+		}
+	}
+
+	// A refresh token the provider no longer accepts is dropped
+	s, _ = readSsoSession(tokenPath)
+	s.RefreshToken = "refresh-0"
+	saveSsoSession(s, tokenPath)
+	saveToken(expired, tokenPath)
+	if _, err := refresh(ctx, srv.URL, tokenPath); !errors.Is(err, ErrSsoRefresh) {
+		t.Errorf("Expected %v, got %v", ErrSsoRefresh, err)
+	}
+	if s, _ := readSsoSession(tokenPath); s == nil || s.RefreshToken != "" || s.Issuer != srv.URL {
+		t.Errorf("Expected the refresh token dropped, got %+v", s)
+	}
+	if _, err := refresh(ctx, srv.URL, tokenPath); !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("Expected %v, got %v", ErrNotLoggedIn, err)
+	}
+
+	if err := removeSsoSession(tokenPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSsoSession(tokenPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the sso session removed, got %v", err)
+	}
+}
+
+func TestDiscover_NoDeviceGrant(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcConfigT{TokenEndpoint: "https://idp.example.com/token"})
+	}))
+	t.Cleanup(srv.Close)
+
+	if _, err := discover(context.Background(), srv.URL); !errors.Is(err, ErrSsoDiscovery) {
+		t.Errorf("Expected %v, got %v", ErrSsoDiscovery, err)
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	LoginNone  = "none"
+	LoginEmail = "email"
+	LoginSso   = "sso"
+	LoginApi   = "api-token"
+
+	StoredKeychain = "keychain"
+	StoredFile     = "file"
+)
+
+var (
+	ErrNoRefresh = errors.New("only sso logins refresh; log in again with preq login")
+)
+
+// StatusT describes the token rule updates use: whose it is, until when,
+// and where it is kept.
+type StatusT struct {
+	Login       string    `json:"login"`
+	Email       string    `json:"email,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Org         string    `json:"org,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"` // identity provider of an sso login
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`
+	Valid       bool      `json:"valid"`
+	Refreshable bool      `json:"refreshable"`
+	Stored      string    `json:"stored,omitempty"`
+	Path        string    `json:"path,omitempty"`
+}
+
+// Status describes the login saved at tokenPath. An expired or invalid
+// token is described too, not an error.
+func Status(tokenPath string) (*StatusT, error) {
+
+	st := &StatusT{Login: LoginNone, Path: tokenPath}
+
+	token, err := readSecret(tokenPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return st, nil
+	case err != nil:
+		return nil, err
+	}
+
+	st.Login = LoginEmail
+	st.Stored = StoredKeychain
+	if _, err := os.Stat(tokenPath); err == nil {
+		st.Stored = StoredFile
+	}
+
+	if s, err := readSsoSession(tokenPath); err == nil && s.Issuer != "" {
+		st.Login = LoginSso
+		st.Issuer = s.Issuer
+		st.Refreshable = s.RefreshToken != ""
+	}
+
+	describe(st, string(token))
+
+	return st, nil
+}
+
+// ApiTokenStatus describes an API token of --token or the environment.
+func ApiTokenStatus(token string) *StatusT {
+	st := &StatusT{Login: LoginApi}
+	describe(st, token)
+	return st
+}
+
+// describe fills in st from the claims of token, checking it is valid.
+func describe(st *StatusT, token string) {
+
+	var claims UserClaims
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, &claims); err == nil {
+		st.Email = claims.Email
+		st.Name = claims.Name
+		st.Org = claims.Org
+		if claims.ExpiresAt > 0 {
+			st.ExpiresAt = time.Unix(claims.ExpiresAt, 0).UTC()
+		}
+	}
+
+	_, err := checkToken(token)
+	st.Valid = err == nil
+}
+
+// Logout removes the login saved at tokenPath, and the refresh token of
+// an sso login, wherever they are kept.
+func Logout(tokenPath string) error {
+
+	if err := removeSecret(tokenPath); err != nil {
+		return err
+	}
+
+	return removeSsoSession(tokenPath)
+}
+
+// ForceRefresh gets a new rules token for an sso login, whether or not
+// the saved one has expired.
+func ForceRefresh(ctx context.Context, baseAddr, tokenPath string) (string, error) {
+
+	s, err := readSsoSession(tokenPath)
+	if err != nil || s.RefreshToken == "" {
+		return "", ErrNoRefresh
+	}
+
+	return refreshSso(ctx, fmt.Sprintf("https://%s:443", baseAddr), tokenPath, s)
+}
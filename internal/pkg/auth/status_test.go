@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestStatus(t *testing.T) {
+	originalKey := publicJwtKeyPEM
+	publicJwtKeyPEM = testPublicKeyPEM
+	t.Cleanup(func() {
+		publicJwtKeyPEM = originalKey
+	})
+
+	tokenPath := filepath.Join(t.TempDir(), ruleToken)
+
+	if st, err := Status(tokenPath); err != nil || st.Login != LoginNone {
+		t.Fatalf("Expected no login, got %+v (%v)", st, err)
+	}
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	token := generateTestToken(&UserClaims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: expires.Unix()},
+		Email:          "jane@example.com",
+		Org:            "acme",
+	}, t)
+	if err := saveToken(token, tokenPath); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := Status(tokenPath)
+	if err != nil || st.Login != LoginEmail || st.Email != "jane@example.com" || st.Org != "acme" {
+		t.Fatalf("Expected the email login, got %+v (%v)", st, err)
+	}
+	if !st.Valid || !st.ExpiresAt.Equal(expires) || st.Stored != StoredKeychain || st.Refreshable {
+		t.Errorf("Expected a valid token in the keychain, got %+v", st)
+	}
+
+	if _, err := ForceRefresh(context.Background(), "localhost", tokenPath); !errors.Is(err, ErrNoRefresh) {
+		t.Errorf("Expected %v, got %v", ErrNoRefresh, err)
+	}
+
+	if err := saveSsoSession(&ssoSessionT{Issuer: "https://login.example.com", RefreshToken: "refresh-1"}, tokenPath); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := Status(tokenPath); st.Login != LoginSso || st.Issuer != "https://login.example.com" || !st.Refreshable {
+		t.Errorf("Expected the sso login, got %+v", st)
+	}
+
+	if err := Logout(tokenPath); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := Status(tokenPath); st.Login != LoginNone {
+		t.Errorf("Expected no login once logged out, got %+v", st)
+	}
+	if _, err := readSsoSession(tokenPath); err == nil {
+		t.Error("Expected the sso session removed")
+	}
+
+	expired := generateTestToken(&UserClaims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(-time.Hour).Unix()},
+	}, t)
+	if st := ApiTokenStatus(expired); st.Login != LoginApi || st.Valid || st.ExpiresAt.IsZero() {
+		t.Errorf("Expected an expired API token, got %+v", st)
+	}
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zalando/go-keyring"
+)
+
+// Logins are kept in the keychain of the OS: the macOS Keychain, the
+// Windows Credential Manager, or the Secret Service of the desktop on
+// Linux. Each is an item of the keychain service, named after the path it
+// would have as a file, so config directories keep their logins apart.
+//
+// Where there is no keychain, as in containers and on headless hosts, or
+// it refuses the item, logins are written to their path, readable by the
+// user only. PREQ_TOKEN_STORAGE=file always uses files. A login found in
+// a file, as earlier releases wrote, is moved to the keychain once it
+// works.
+
+const (
+	TokenStorageEnv  = "PREQ_TOKEN_STORAGE"
+	TokenStorageFile = "file"
+
+	keychainService = "preq"
+)
+
+// readSecret returns the login stored for path. A file is newer than the
+// keychain, which writeSecret removes it for, so it is read first.
+func readSecret(path string) ([]byte, error) {
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if useKeychain() {
+			migrateSecret(path, data)
+		}
+		return data, nil
+	}
+
+	if !useKeychain() || !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	secret, kerr := keyring.Get(keychainService, keychainItem(path))
+	if kerr != nil {
+		if !errors.Is(kerr, keyring.ErrNotFound) {
+			log.Debug().Err(kerr).Msg("Failed to read keychain")
+		}
+		return nil, err
+	}
+
+	return []byte(secret), nil
+}
+
+// writeSecret stores the login for path in the keychain, or else in the
+// file.
+func writeSecret(path string, data []byte) error {
+
+	if useKeychain() {
+		err := keyring.Set(keychainService, keychainItem(path), string(data))
+		if err == nil {
+			return removeFile(path)
+		}
+		log.Warn().Err(err).Str("path", path).Msg("Failed to store login in keychain; writing file")
+	}
+
+	// Written aside and renamed, so a rotated token is never lost half
+	// written
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// removeSecret removes the login for path wherever it is stored.
+func removeSecret(path string) error {
+
+	if useKeychain() {
+		if err := keyring.Delete(keychainService, keychainItem(path)); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+			log.Debug().Err(err).Msg("Failed to remove login from keychain")
+		}
+	}
+
+	return removeFile(path)
+}
+
+// migrateSecret moves a login written to a file to the keychain. The file
+// is left be if the keychain refuses it.
+func migrateSecret(path string, data []byte) {
+
+	if err := keyring.Set(keychainService, keychainItem(path), string(data)); err != nil {
+		log.Debug().Err(err).Str("path", path).Msg("Failed to move login to keychain; leaving file")
+		return
+	}
+
+	if err := removeFile(path); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to remove login moved to keychain")
+		return
+	}
+
+	log.Info().Str("path", path).Msg("Moved login to keychain")
+}
+
+func useKeychain() bool {
+	return !strings.EqualFold(strings.TrimSpace(os.Getenv(TokenStorageEnv)), TokenStorageFile)
+}
+
+func keychainItem(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+func TestSecretKeychain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ruleToken)
+
+	if err := writeSecret(path, []byte("token-1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no file with a keychain, got %v", err)
+	}
+	if data, err := readSecret(path); err != nil || string(data) != "token-1" {
+		t.Errorf("Expected token-1, got %q (%v)", data, err)
+	}
+
+	if err := removeSecret(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSecret(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the login removed, got %v", err)
+	}
+}
+
+func TestSecretMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ruleToken)
+
+	if err := os.WriteFile(path, []byte("token-1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := readSecret(path); err != nil || string(data) != "token-1" {
+		t.Fatalf("Expected token-1, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the file removed once moved, got %v", err)
+	}
+	if secret, err := keyring.Get(keychainService, keychainItem(path)); err != nil || secret != "token-1" {
+		t.Errorf("Expected token-1 in the keychain, got %q (%v)", secret, err)
+	}
+}
+
+func TestSecretFileFallback(t *testing.T) {
+	keyring.MockInitWithError(errors.New("no keychain"))
+	t.Cleanup(keyring.MockInit)
+
+	path := filepath.Join(t.TempDir(), ruleToken)
+
+	if err := writeSecret(path, []byte("token-1")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected a file readable by the user only, got %v (%v)", info, err)
+	}
+	if data, err := readSecret(path); err != nil || string(data) != "token-1" {
+		t.Errorf("Expected token-1, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the file kept without a keychain, got %v", err)
+	}
+}
+
+func TestSecretFileStorage(t *testing.T) {
+	t.Setenv(TokenStorageEnv, TokenStorageFile)
+
+	path := filepath.Join(t.TempDir(), ruleToken)
+
+	if err := writeSecret(path, []byte("token-1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.Get(keychainService, keychainItem(path)); !errors.Is(err, keyring.ErrNotFound) {
+		t.Errorf("Expected nothing in the keychain, got %v", err)
+	}
+	if data, err := readSecret(path); err != nil || string(data) != "token-1" {
+		t.Errorf("Expected token-1, got %q (%v)", data, err)
+	}
+}
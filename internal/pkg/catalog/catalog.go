@@ -0,0 +1,275 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/prequel-dev/preq/internal/pkg/deprecz"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"github.com/rs/zerolog/log"
+)
+
+// A catalog is the rule set preq would run, read without compiling it:
+// the community rules package cached in the config directory and the
+// user's rule files, for the rules subcommands to list and inspect. A
+// user rule path may be a directory, read for its YAML files; those that
+// hold no rules, such as data source files, are passed over.
+
+const (
+	fixtureSuffix = ".test"
+)
+
+var (
+	ErrNoRules  = errors.New("no rules installed; run preq once to download the community rules, or pass -r")
+	ErrNotFound = errors.New("CRE not found in the installed rules")
+)
+
+// EntryT is one rule of the catalog and where it was read from.
+type EntryT struct {
+	Rule        parser.ParseRuleT
+	Path        string
+	Type        utils.RuleTypeT
+	Deprecation *deprecz.DeprecationT        // nil unless the CRE is deprecated
+	named       map[string]parser.ParseTermT // terms of the rule's document
+}
+
+// Technology names the applications a rule applies to.
+func (e EntryT) Technology() string {
+	var names []string
+	for _, app := range e.Rule.Cre.Applications {
+		if app.Name != "" && !slices.Contains(names, app.Name) {
+			names = append(names, app.Name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Terms returns the named terms the rule refers to, and those they refer
+// to in turn.
+func (e EntryT) Terms() map[string]parser.ParseTermT {
+
+	out := make(map[string]parser.ParseTermT)
+
+	var walk func(terms []parser.ParseTermT)
+	walk = func(terms []parser.ParseTermT) {
+		for _, t := range terms {
+			if nt, ok := e.named[t.StrValue]; ok && t.StrValue != "" {
+				if _, seen := out[t.StrValue]; seen {
+					continue
+				}
+				out[t.StrValue] = nt
+				t = nt
+			}
+			if t.Set != nil {
+				walk(t.Set.Match)
+				walk(t.Set.Negate)
+			}
+			if t.Sequence != nil {
+				walk(t.Sequence.Order)
+				walk(t.Sequence.Negate)
+			}
+		}
+	}
+
+	if set := e.Rule.Rule.Set; set != nil {
+		walk(set.Match)
+		walk(set.Negate)
+	}
+	if seq := e.Rule.Rule.Sequence; seq != nil {
+		walk(seq.Order)
+		walk(seq.Negate)
+	}
+
+	return out
+}
+
+type CatalogT struct {
+	Entries []EntryT // by CRE id, then path
+}
+
+// Load reads the rules at paths.
+func Load(paths []utils.RulePathT) (*CatalogT, error) {
+
+	var (
+		c       = &CatalogT{}
+		deprecs = deprecz.New()
+	)
+
+	for _, rp := range paths {
+		files, err := Files(rp.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, fn := range files {
+			rules, err := Parse(utils.RulePathT{Path: fn, Type: rp.Type}, utils.WithRewrite(deprecs.Scan))
+			switch {
+			case err != nil && fn != rp.Path:
+				log.Warn().Err(err).Str("path", fn).Msg("Skipping file without rules")
+				continue
+			case err != nil:
+				log.Error().Err(err).Str("path", fn).Msg("Failed to read rules")
+				return nil, err
+			}
+			for _, rule := range rules.Rules {
+				e := EntryT{Rule: rule, Path: fn, Type: rp.Type, named: rules.TermsT}
+				if d, ok := deprecs.Lookup(rule.Cre.Id); ok {
+					e.Deprecation = &d
+				}
+				c.Entries = append(c.Entries, e)
+			}
+		}
+	}
+
+	if len(c.Entries) == 0 {
+		return nil, ErrNoRules
+	}
+
+	slices.SortStableFunc(c.Entries, func(a, b EntryT) int {
+		if c := strings.Compare(a.Rule.Cre.Id, b.Rule.Cre.Id); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return c, nil
+}
+
+// Find returns the rules of the CRE id, ignoring case; more than one if
+// several rule files define it.
+func (c *CatalogT) Find(id string) ([]EntryT, error) {
+	var out []EntryT
+	for _, e := range c.Entries {
+		if strings.EqualFold(e.Rule.Cre.Id, id) {
+			out = append(out, e)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return out, nil
+}
+
+// QueryT selects rules. Every word must be in the text of a rule, and
+// every filter set must hold; all comparisons ignore case.
+type QueryT struct {
+	Words       []string
+	Technology  string
+	Category    string
+	Tags        []string
+	MinSeverity *uint // at least this severe; lower is more severe
+}
+
+// Search returns the rules that match q.
+func (c *CatalogT) Search(q QueryT) []EntryT {
+	var out []EntryT
+	for _, e := range c.Entries {
+		if q.match(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (q QueryT) match(e EntryT) bool {
+
+	cre := e.Rule.Cre
+
+	if q.MinSeverity != nil && cre.Severity > *q.MinSeverity {
+		return false
+	}
+	if q.Category != "" && !strings.EqualFold(cre.Category, q.Category) {
+		return false
+	}
+	if q.Technology != "" && !slices.ContainsFunc(cre.Applications, func(app parser.ParseApplicationT) bool {
+		return strings.EqualFold(app.Name, q.Technology)
+	}) {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !slices.ContainsFunc(cre.Tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			return false
+		}
+	}
+
+	if len(q.Words) == 0 {
+		return true
+	}
+
+	text := strings.ToLower(strings.Join(slices.Concat([]string{
+		cre.Id,
+		cre.Title,
+		cre.Category,
+		cre.Description,
+		cre.Cause,
+		cre.Impact,
+		cre.Mitigation,
+		e.Technology(),
+	}, cre.Tags, cre.References), "\n"))
+
+	for _, w := range q.Words {
+		if !strings.Contains(text, strings.ToLower(w)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse reads the rules of one file as the engine does: a community
+// package is a multi-document bundle and user rules may leave ids out.
+func Parse(rp utils.RulePathT, opts ...utils.ReaderOptT) (*parser.RulesT, error) {
+	switch rp.Type {
+	case utils.RuleTypeCre:
+		return utils.ParseRulesPath(rp.Path, append(opts, utils.WithMultiDoc())...)
+	default:
+		return utils.ParseRulesPath(rp.Path, append(opts, utils.WithGenIds())...)
+	}
+}
+
+// Files returns path, or the YAML files under it if it is a directory.
+func Files(path string) ([]string, error) {
+
+	var files []string
+
+	err := filepath.WalkDir(path, func(fn string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case fn == path && !d.IsDir():
+			files = append(files, fn)
+		case d.IsDir():
+		case IsRuleFile(fn):
+			files = append(files, fn)
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// IsRuleFile reports whether fn is named like a YAML rules file.
+func IsRuleFile(fn string) bool {
+	switch filepath.Ext(fn) {
+	case ".yaml", ".yml":
+		return !IsFixture(fn)
+	}
+	return false
+}
+
+// IsFixture reports whether fn is named like the test fixture of a rules
+// file.
+func IsFixture(fn string) bool {
+	ext := filepath.Ext(fn)
+	return strings.HasSuffix(strings.TrimSuffix(fn, ext), fixtureSuffix)
+}
+
+// FixturePath returns where the test fixture of the rules file fn is:
+// next to it, as rules.test.yaml for rules.yaml.
+func FixturePath(fn string) string {
+	ext := filepath.Ext(fn)
+	return strings.TrimSuffix(fn, ext) + fixtureSuffix + ext
+}
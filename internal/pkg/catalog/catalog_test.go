@@ -0,0 +1,276 @@
+package catalog
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+)
+
+const testRules = `rules:
+  - cre:
+      id: CRE-2025-0002
+      severity: 1
+      title: Broker unreachable
+      category: message-queue-problems
+      tags: [rabbitmq, networking]
+      applications:
+        - name: rabbitmq
+    metadata:
+      id: Q8vXk2LmN4pRt7Yw9ZbC3d
+      hash: Fj5Hs8Kd2Lq9Wx4Pz7Nm3R
+    rule:
+      set:
+        event:
+          source: cre.log.rabbitmq
+        match:
+          - value: "connection refused"
+  - cre:
+      id: CRE-2025-0001
+      title: Redis OOM
+      supersededBy: CRE-2025-0003
+      applications:
+        - name: redis
+        - name: redis
+    metadata:
+      id: Vb3Nq7Tx2Wk9Lm5Rp8Zc4H
+      hash: Gd6Ks9Mw3Xq7Ln2Pb5Rt8Y
+    rule:
+      set:
+        event:
+          source: cre.log.redis
+        match:
+          - value: "OOM command not allowed"
+`
+
+func TestLoad(t *testing.T) {
+
+	dir := t.TempDir()
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("rules.yaml", testRules)
+	write("sources.yaml", "version: 0.0.1\nsources: []\n")
+	write("notes.txt", "not yaml")
+
+	c, err := Load([]utils.RulePathT{{Path: dir, Type: utils.RuleTypeUser}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Entries) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(c.Entries))
+	}
+
+	// Ordered by CRE id
+	first, second := c.Entries[0], c.Entries[1]
+	if first.Rule.Cre.Id != "CRE-2025-0001" || second.Rule.Cre.Id != "CRE-2025-0002" {
+		t.Errorf("Unexpected order: %s, %s", first.Rule.Cre.Id, second.Rule.Cre.Id)
+	}
+	if first.Technology() != "redis" || second.Technology() != "rabbitmq" {
+		t.Errorf("Unexpected technology: %q, %q", first.Technology(), second.Technology())
+	}
+	if first.Deprecation == nil || first.Deprecation.SupersededBy != "CRE-2025-0003" || second.Deprecation != nil {
+		t.Errorf("Unexpected deprecations: %v, %v", first.Deprecation, second.Deprecation)
+	}
+	if second.Path != filepath.Join(dir, "rules.yaml") || second.Type != utils.RuleTypeUser {
+		t.Errorf("Unexpected origin: %s %s", second.Path, second.Type)
+	}
+
+	// A file named outright must hold rules
+	if _, err := Load([]utils.RulePathT{{Path: filepath.Join(dir, "sources.yaml"), Type: utils.RuleTypeUser}}); err == nil {
+		t.Error("Expected a file without rules to fail")
+	}
+
+	if _, err := Load(nil); !errors.Is(err, ErrNoRules) {
+		t.Errorf("Expected ErrNoRules, got %v", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+
+	c, err := Load([]utils.RulePathT{{Path: "../../../examples/41-nested.yaml", Type: utils.RuleTypeUser}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.Find("NESTED-example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Rule.Cre.Id != "nested-example" {
+		t.Fatalf("Unexpected entries: %+v", got)
+	}
+
+	// The terms the rule refers to, directly or not
+	terms := got[0].Terms()
+	for _, name := range []string{"term1", "term2", "term3"} {
+		if _, ok := terms[name]; !ok {
+			t.Errorf("Expected term %s, got %v", name, terms)
+		}
+	}
+
+	if _, err := c.Find("CRE-0000-0000"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+
+	fn := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(fn, []byte(testRules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load([]utils.RulePathT{{Path: fn, Type: utils.RuleTypeUser}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		high = uint(1)
+		crit = uint(0)
+	)
+
+	tests := map[string]struct {
+		q    QueryT
+		want []string
+	}{
+		"all":         {QueryT{}, []string{"CRE-2025-0001", "CRE-2025-0002"}},
+		"words":       {QueryT{Words: []string{"broker", "UNREACHABLE"}}, []string{"CRE-2025-0002"}},
+		"any word":    {QueryT{Words: []string{"broker", "redis"}}, nil},
+		"technology":  {QueryT{Technology: "RabbitMQ"}, []string{"CRE-2025-0002"}},
+		"by tech":     {QueryT{Words: []string{"redis"}}, []string{"CRE-2025-0001"}},
+		"category":    {QueryT{Category: "message-queue-problems"}, []string{"CRE-2025-0002"}},
+		"tags":        {QueryT{Tags: []string{"rabbitmq", "networking"}}, []string{"CRE-2025-0002"}},
+		"missing tag": {QueryT{Tags: []string{"rabbitmq", "storage"}}, nil},
+		"high":        {QueryT{MinSeverity: &high}, []string{"CRE-2025-0001", "CRE-2025-0002"}},
+		"critical":    {QueryT{MinSeverity: &crit}, []string{"CRE-2025-0001"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got []string
+			for _, e := range c.Search(tc.q) {
+				got = append(got, e.Rule.Cre.Id)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+
+	dir := t.TempDir()
+	load := func(name, data string) *CatalogT {
+		fn := filepath.Join(dir, name)
+		if err := os.WriteFile(fn, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c, err := Load([]utils.RulePathT{{Path: fn, Type: utils.RuleTypeUser}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// CRE-2025-0001 is replaced by CRE-2025-0003, and CRE-2025-0002 is
+	// raised to critical and matches more
+	newer := strings.NewReplacer(
+		"id: CRE-2025-0001", "id: CRE-2025-0003",
+		"severity: 1", "severity: 0",
+		`- value: "connection refused"`, `- regex: "connection (refused|reset)"`,
+	).Replace(testRules)
+
+	var (
+		older = load("old.yaml", testRules)
+		d     = older.Diff(load("new.yaml", newer))
+	)
+
+	if len(d.Added) != 1 || d.Added[0].Rule.Cre.Id != "CRE-2025-0003" {
+		t.Errorf("Expected CRE-2025-0003 added, got %v", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].Rule.Cre.Id != "CRE-2025-0001" {
+		t.Errorf("Expected CRE-2025-0001 removed, got %v", d.Removed)
+	}
+	if len(d.Changed) != 1 || d.Changed[0].New.Rule.Cre.Id != "CRE-2025-0002" ||
+		!slices.Equal(d.Changed[0].Fields, []string{FieldSeverity, FieldRule}) {
+		t.Errorf("Expected the severity and rule of CRE-2025-0002 changed, got %+v", d.Changed)
+	}
+
+	if !older.Diff(older).Empty() {
+		t.Errorf("Expected no difference between the same rules")
+	}
+}
+
+func TestFixtures(t *testing.T) {
+
+	tests := []struct {
+		fn      string
+		fixture string
+		rules   bool
+	}{
+		{"rules.yaml", "rules.test.yaml", true},
+		{"dir/rules.yml", "dir/rules.test.yml", true},
+		{"rules.test.yaml", "rules.test.test.yaml", false},
+		{"rules.log", "rules.test.log", false},
+	}
+
+	for _, tc := range tests {
+		if got := FixturePath(tc.fn); got != tc.fixture {
+			t.Errorf("%s: expected fixture %s, got %s", tc.fn, tc.fixture, got)
+		}
+		if !IsFixture(FixturePath(tc.fn)) {
+			t.Errorf("%s: expected %s to be a fixture", tc.fn, FixturePath(tc.fn))
+		}
+		if got := IsRuleFile(tc.fn); got != tc.rules {
+			t.Errorf("%s: expected rule file %v, got %v", tc.fn, tc.rules, got)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+
+	c, err := Load([]utils.RulePathT{{Path: "../../../examples/41-nested.yaml", Type: utils.RuleTypeUser}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := c.Find("nested-example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := Extract(entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := utils.ParseRules(bytes.NewReader(data), utils.WithGenIds())
+	if err != nil {
+		t.Fatalf("Expected the extracted rule to parse, got %v:\n%s", err, data)
+	}
+	if len(rules.Rules) != 1 || rules.Rules[0].Cre.Id != "nested-example" || rules.Rules[0].Metadata.Hash != entries[0].Rule.Metadata.Hash {
+		t.Errorf("Expected only nested-example, got %+v", rules.Rules)
+	}
+	for _, name := range []string{"term1", "term2", "term3"} {
+		if _, ok := rules.TermsT[name]; !ok {
+			t.Errorf("Expected term %s extracted, got %v", name, rules.TermsT)
+		}
+	}
+
+	e := entries[0]
+	e.Rule.Cre.Id = "CRE-0000-0000"
+	if _, err := Extract(e); !errors.Is(err, ErrExtract) {
+		t.Errorf("Expected %v, got %v", ErrExtract, err)
+	}
+}
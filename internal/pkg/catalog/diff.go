@@ -0,0 +1,130 @@
+package catalog
+
+import (
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A diff compares two rule sets by CRE id: the CREs only in the newer set
+// are added, those only in the older set removed, and those in both with
+// any of the fields below different are changed. Where a set defines a
+// CRE more than once, the first rule by path is compared.
+
+const (
+	FieldSeverity    = "severity"
+	FieldTitle       = "title"
+	FieldCategory    = "category"
+	FieldTechnology  = "technology"
+	FieldTags        = "tags"
+	FieldDescription = "description"
+	FieldCause       = "cause"
+	FieldImpact      = "impact"
+	FieldMitigation  = "mitigation"
+	FieldReferences  = "references"
+	FieldRule        = "rule" // the rule definition and its terms
+)
+
+// ChangeT is a CRE in both rule sets, and the fields that differ.
+type ChangeT struct {
+	Old    EntryT
+	New    EntryT
+	Fields []string
+}
+
+// DiffT is the difference between two rule sets, each by CRE id.
+type DiffT struct {
+	Added   []EntryT
+	Removed []EntryT
+	Changed []ChangeT
+}
+
+// Empty tells whether the rule sets define the same CREs alike.
+func (d DiffT) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Diff compares the rules of c with those of newer.
+func (c *CatalogT) Diff(newer *CatalogT) DiffT {
+
+	var (
+		d        DiffT
+		old, cur = c.byId(), newer.byId()
+	)
+
+	for id, n := range cur {
+		if _, ok := old[id]; !ok {
+			d.Added = append(d.Added, *n)
+		}
+	}
+
+	for id, o := range old {
+		n, ok := cur[id]
+		if !ok {
+			d.Removed = append(d.Removed, *o)
+			continue
+		}
+		if fields := changedFields(*o, *n); len(fields) > 0 {
+			d.Changed = append(d.Changed, ChangeT{Old: *o, New: *n, Fields: fields})
+		}
+	}
+
+	byId := func(a, b EntryT) int { return strings.Compare(a.Rule.Cre.Id, b.Rule.Cre.Id) }
+	slices.SortFunc(d.Added, byId)
+	slices.SortFunc(d.Removed, byId)
+	slices.SortFunc(d.Changed, func(a, b ChangeT) int { return byId(a.New, b.New) })
+
+	return d
+}
+
+// byId returns the first entry of each CRE id.
+func (c *CatalogT) byId() map[string]*EntryT {
+	out := make(map[string]*EntryT, len(c.Entries))
+	for i, e := range c.Entries {
+		if _, ok := out[e.Rule.Cre.Id]; !ok {
+			out[e.Rule.Cre.Id] = &c.Entries[i]
+		}
+	}
+	return out
+}
+
+func changedFields(a, b EntryT) []string {
+
+	var (
+		ca, cb = a.Rule.Cre, b.Rule.Cre
+		fields []string
+	)
+
+	diff := func(name string, changed bool) {
+		if changed {
+			fields = append(fields, name)
+		}
+	}
+
+	diff(FieldSeverity, ca.Severity != cb.Severity)
+	diff(FieldTitle, ca.Title != cb.Title)
+	diff(FieldCategory, ca.Category != cb.Category)
+	diff(FieldTechnology, a.Technology() != b.Technology())
+	diff(FieldTags, !slices.Equal(ca.Tags, cb.Tags))
+	diff(FieldDescription, strings.TrimSpace(ca.Description) != strings.TrimSpace(cb.Description))
+	diff(FieldCause, strings.TrimSpace(ca.Cause) != strings.TrimSpace(cb.Cause))
+	diff(FieldImpact, strings.TrimSpace(ca.Impact) != strings.TrimSpace(cb.Impact))
+	diff(FieldMitigation, strings.TrimSpace(ca.Mitigation) != strings.TrimSpace(cb.Mitigation))
+	diff(FieldReferences, !slices.Equal(ca.References, cb.References))
+	diff(FieldRule, definition(a) != definition(b))
+
+	return fields
+}
+
+// definition is the rule of e and the terms it refers to, as YAML.
+func definition(e EntryT) string {
+	def, err := yaml.Marshal(struct {
+		Rule  any `yaml:"rule"`
+		Terms any `yaml:"terms"`
+	}{e.Rule.Rule, e.Terms()})
+	if err != nil {
+		return ""
+	}
+	return string(def)
+}
@@ -0,0 +1,127 @@
+package catalog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrExtract = errors.New("rule not found in its file")
+)
+
+// Extract returns a rules file holding just the rule of e and the named
+// terms it refers to, as written in the file it was read from, keys the
+// parser does not know included. It runs on its own with -r.
+func Extract(e EntryT) ([]byte, error) {
+
+	rdr, closer, err := utils.OpenRulesFile(e.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	var (
+		dec   = yaml.NewDecoder(rdr)
+		want  = e.Terms()
+		rule  *yaml.Node
+		terms = make(map[string]*yaml.Node, len(want))
+	)
+
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Path, err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		root := doc.Content[0]
+
+		if seq := mapValue(root, "rules"); seq != nil && seq.Kind == yaml.SequenceNode && rule == nil {
+			for _, item := range seq.Content {
+				if isRule(item, e) {
+					rule = item
+					break
+				}
+			}
+		}
+
+		if m := mapValue(root, "terms"); m != nil && m.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(m.Content); i += 2 {
+				if _, ok := want[m.Content[i].Value]; ok {
+					terms[m.Content[i].Value] = m.Content[i+1]
+				}
+			}
+		}
+	}
+
+	if rule == nil {
+		return nil, fmt.Errorf("%w: %s in %s", ErrExtract, e.Rule.Cre.Id, e.Path)
+	}
+
+	out := &yaml.Node{Kind: yaml.MappingNode}
+	out.Content = append(out.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: "rules"},
+		&yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{rule}},
+	)
+
+	if len(terms) > 0 {
+		names := make([]string, 0, len(terms))
+		for name := range terms {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		m := &yaml.Node{Kind: yaml.MappingNode}
+		for _, name := range names {
+			m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, terms[name])
+		}
+		out.Content = append(out.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "terms"}, m)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(out); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// isRule tells whether the rules item n is the rule of e: its CRE id, and
+// its hash if the item has one.
+func isRule(n *yaml.Node, e EntryT) bool {
+
+	cre := mapValue(n, "cre")
+	if id := mapValue(cre, "id"); id == nil || id.Value != e.Rule.Cre.Id {
+		return false
+	}
+
+	hash := mapValue(mapValue(n, "metadata"), "hash")
+	return hash == nil || hash.Value == e.Rule.Metadata.Hash
+}
+
+// mapValue returns the value of key in the mapping n, if any.
+func mapValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
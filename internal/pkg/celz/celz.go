@@ -0,0 +1,409 @@
+package celz
+
+// A rule condition may be a CEL expression over the structured fields of
+// an event instead of a value, regex or jq term:
+//
+//	match:
+//	  - cel: 'fields.status >= 500 && fields.latency_ms > 250'
+//
+// The log matchers only know raw, regex and jq terms, so each expression is
+// swapped for a raw term on a marker when the rules are read. The matchers
+// of a rule bind its expressions, and before they see a line the
+// expressions are evaluated against its fields and the markers of those
+// that hold are appended to the line they see. Other rules see the line as
+// it was read, and markers are stripped from reported entries. A jq term
+// will not parse a JSON line a marker was appended to, so a rule that has
+// both is rejected.
+//
+// Fields come from the line's JSON object or, failing that, its logfmt
+// pairs; logfmt values that read as numbers or booleans are typed so that
+// they compare as such. An expression sees:
+//
+//	fields  map(string, dyn)  the parsed fields; empty if none parse
+//	line    string            the raw line
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/prequel-dev/preq/internal/pkg/resolve"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	keyCel       = "cel"
+	keyJq        = "jq"
+	markerPrefix = utils.MarkerSep + "cel:"
+	markerSuffix = utils.MarkerSep
+
+	// Leading tokens skipped when looking for logfmt pairs, e.g. a
+	// timestamp and level written before them
+	logfmtSkip = 3
+)
+
+var (
+	ErrCelCompile = errors.New("invalid cel expression")
+	ErrCelType    = errors.New("cel expression must evaluate to a bool")
+	ErrCelMixed   = errors.New("cel cannot be combined with value, regex or jq")
+	ErrCelJq      = errors.New("cel cannot be used in a rule with jq terms")
+)
+
+// ProgramsT holds the compiled expressions of the rules read so far. A nil
+// *ProgramsT has no expressions.
+type ProgramsT struct {
+	mux   sync.RWMutex
+	env   *cel.Env
+	progs map[string]cel.Program // by marker
+	exprs map[string]string
+}
+
+func New() (*ProgramsT, error) {
+
+	env, err := cel.NewEnv(
+		cel.Variable("fields", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("line", cel.StringType),
+		cel.CrossTypeNumericComparisons(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProgramsT{
+		env:   env,
+		progs: make(map[string]cel.Program),
+		exprs: make(map[string]string),
+	}, nil
+}
+
+// Len returns the number of distinct expressions.
+func (p *ProgramsT) Len() int {
+	if p == nil {
+		return 0
+	}
+
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+	return len(p.progs)
+}
+
+// Rewrite compiles the cel conditions in a rules document and replaces
+// each with a raw term on its marker. Documents without cel conditions are
+// returned as they are.
+func (p *ProgramsT) Rewrite(data []byte) ([]byte, error) {
+
+	if !bytes.Contains(data, []byte(keyCel)) {
+		return data, nil
+	}
+
+	return utils.RewriteDocs(data, func(doc *yaml.Node) (bool, error) {
+		if err := checkJq(doc); err != nil {
+			return false, err
+		}
+		n, err := p.rewriteNode(doc)
+		return n > 0, err
+	})
+}
+
+// checkJq rejects the rules of doc that have both cel and jq terms, their
+// named terms included.
+func checkJq(doc *yaml.Node) error {
+
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		doc = doc.Content[0]
+	}
+
+	var (
+		rules = mapValue(doc, "rules")
+		terms = mapValue(doc, "terms")
+	)
+
+	if rules == nil || rules.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	for _, rule := range rules.Content {
+		if uses(rule, keyCel, terms, nil) && uses(rule, keyJq, terms, nil) {
+			return fmt.Errorf("line %d: %w", rule.Line, ErrCelJq)
+		}
+	}
+
+	return nil
+}
+
+// uses reports whether a term under n has key, following the names of
+// terms. Rate and plugin conditions are decided on the line as read, so
+// their terms are not looked into.
+func uses(n *yaml.Node, key string, terms *yaml.Node, seen map[string]bool) bool {
+
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if t := mapValue(terms, n.Value); t != nil && !seen[n.Value] {
+			if seen == nil {
+				seen = make(map[string]bool)
+			}
+			seen[n.Value] = true
+			return uses(t, key, terms, seen)
+		}
+		return false
+
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			switch n.Content[i].Value {
+			case key:
+				return true
+			case "rate", "plugin":
+				continue
+			}
+			if uses(n.Content[i+1], key, terms, seen) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, c := range n.Content {
+		if uses(c, key, terms, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// mapValue returns the value of key in the mapping n, or nil.
+func mapValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func (p *ProgramsT) rewriteNode(n *yaml.Node) (int, error) {
+
+	var count int
+
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value != keyCel {
+				continue
+			}
+
+			for j := 0; j+1 < len(n.Content); j += 2 {
+				switch n.Content[j].Value {
+				case "value", "regex", "jq":
+					return 0, fmt.Errorf("line %d: %w", n.Line, ErrCelMixed)
+				}
+			}
+
+			var (
+				key = n.Content[i]
+				val = n.Content[i+1]
+			)
+
+			marker, err := p.add(val.Value)
+			if err != nil {
+				return 0, fmt.Errorf("line %d: %w", val.Line, err)
+			}
+
+			key.Value = "value"
+			val.Value = marker
+			val.Tag = "!!str"
+			val.Style = yaml.DoubleQuotedStyle
+			count++
+		}
+	}
+
+	for _, c := range n.Content {
+		nc, err := p.rewriteNode(c)
+		if err != nil {
+			return 0, err
+		}
+		count += nc
+	}
+
+	return count, nil
+}
+
+// add compiles expr and returns its marker.
+func (p *ProgramsT) add(expr string) (string, error) {
+
+	sum := sha256.Sum256([]byte(expr))
+	marker := markerPrefix + utils.MarkerNonce + ":" + hex.EncodeToString(sum[:6]) + markerSuffix
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if _, ok := p.progs[marker]; ok {
+		return marker, nil
+	}
+
+	ast, iss := p.env.Compile(expr)
+	if iss.Err() != nil {
+		return "", fmt.Errorf("%w %q: %w", ErrCelCompile, expr, iss.Err())
+	}
+
+	if t := ast.OutputType(); !t.IsExactType(types.BoolType) && !t.IsExactType(types.DynType) {
+		return "", fmt.Errorf("%w: %q is %s", ErrCelType, expr, t)
+	}
+
+	prg, err := p.env.Program(ast)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %w", ErrCelCompile, expr, err)
+	}
+
+	p.progs[marker] = prg
+	p.exprs[marker] = expr
+
+	return marker, nil
+}
+
+// Expr returns the expression a raw term value stands for, if it is a
+// marker.
+func (p *ProgramsT) Expr(value string) (string, bool) {
+	if p == nil || !strings.HasPrefix(value, markerPrefix) {
+		return "", false
+	}
+
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	expr, ok := p.exprs[value]
+	return expr, ok
+}
+
+// IsMarker reports whether a raw term value stands for an expression.
+func IsMarker(value string) bool {
+	return strings.HasPrefix(value, markerPrefix)
+}
+
+// Retain drops the expressions whose markers keep does not hold, such as
+// those of rules a reload removed.
+func (p *ProgramsT) Retain(keep func(marker string) bool) {
+	if p == nil {
+		return
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for marker := range p.progs {
+		if !keep(marker) {
+			delete(p.progs, marker)
+			delete(p.exprs, marker)
+		}
+	}
+}
+
+// BoundT holds the expressions of one rule.
+type BoundT struct {
+	markers []string
+	progs   []cel.Program
+}
+
+// Bind returns the expressions among markers, or nil if there are none.
+func (p *ProgramsT) Bind(markers []string) *BoundT {
+	if p == nil {
+		return nil
+	}
+
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	var b BoundT
+	for _, marker := range markers {
+		if prg, ok := p.progs[marker]; ok && !slices.Contains(b.markers, marker) {
+			b.markers = append(b.markers, marker)
+			b.progs = append(b.progs, prg)
+		}
+	}
+
+	if len(b.progs) == 0 {
+		return nil
+	}
+	return &b
+}
+
+// Holds appends to dst the markers of the expressions that hold for line
+// and its fields. An expression that fails to evaluate, e.g. on a missing
+// field, does not hold.
+func (b *BoundT) Holds(dst []string, fields map[string]any, line string) []string {
+
+	vars := map[string]any{
+		"fields": fields,
+		"line":   line,
+	}
+
+	for i, prg := range b.progs {
+		if out, _, err := prg.Eval(vars); err == nil && out == types.True {
+			dst = append(dst, b.markers[i])
+		}
+	}
+
+	return dst
+}
+
+// Strip removes the markers appended to line.
+func Strip(line string) string {
+	before, _, _ := strings.Cut(line, " "+markerPrefix)
+	return before
+}
+
+// Fields parses the structured fields of line: the JSON object it holds,
+// or else its logfmt pairs, possibly after a few leading tokens.
+func Fields(line string) map[string]any {
+
+	if idx := strings.IndexByte(line, '{'); idx >= 0 {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(line[idx:]), &fields); err == nil {
+			return fields
+		}
+	}
+
+	rest := line
+	for range logfmtSkip + 1 {
+		if pairs := resolve.ParseLogfmt([]byte(rest)); len(pairs) > 0 {
+			fields := make(map[string]any, len(pairs))
+			for k, v := range pairs {
+				fields[k] = typed(v)
+			}
+			return fields
+		}
+
+		_, after, ok := strings.Cut(strings.TrimSpace(rest), " ")
+		if !ok {
+			break
+		}
+		rest = after
+	}
+
+	return map[string]any{}
+}
+
+// typed reads a logfmt value as a number or bool where it is one.
+func typed(v string) any {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f
+	}
+	if b, err := strconv.ParseBool(v); err == nil {
+		return b
+	}
+	return v
+}
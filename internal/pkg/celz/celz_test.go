@@ -0,0 +1,177 @@
+package celz
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+
+	p, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := `rules:
+  - rule:
+      set:
+        match:
+          - cel: 'fields.status >= 500'
+          - value: "timeout"
+`
+
+	out, err := p.Rewrite([]byte(doc))
+	if err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+	if strings.Contains(string(out), "- cel:") || strings.Count(string(out), "value:") != 2 {
+		t.Fatalf("Expected cel term to be replaced:\n%s", out)
+	}
+	if p.Len() != 1 {
+		t.Fatalf("Expected 1 program, got %d", p.Len())
+	}
+
+	// The same expression shares its marker
+	if _, err = p.Rewrite([]byte(doc)); err != nil || p.Len() != 1 {
+		t.Errorf("Expected expression to be reused, got %d programs: %v", p.Len(), err)
+	}
+
+	// Rate conditions count on the line as read, so their jq terms may sit
+	// with cel
+	rate := "rules:\n  - rule:\n      set:\n        match:\n          - cel: 'true'\n          - rate:\n              jq: '.status'\n"
+	if _, err = p.Rewrite([]byte(rate)); err != nil {
+		t.Errorf("Expected cel beside a rate on jq, got %v", err)
+	}
+
+	// Documents without cel conditions are untouched
+	plain := []byte("rules:\n  - rule:\n      set:\n        match:\n          - value: cancelled\n")
+	if out, err = p.Rewrite(plain); err != nil || string(out) != string(plain) {
+		t.Errorf("Expected document unchanged, got %q: %v", out, err)
+	}
+}
+
+func TestRewriteErrors(t *testing.T) {
+
+	p, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		doc  string
+		want error
+	}{
+		"syntax": {
+			doc:  "match:\n  - cel: 'fields.status >='\n",
+			want: ErrCelCompile,
+		},
+		"not bool": {
+			doc:  "match:\n  - cel: '1 + 2'\n",
+			want: ErrCelType,
+		},
+		"mixed": {
+			doc:  "match:\n  - cel: 'true'\n    value: x\n",
+			want: ErrCelMixed,
+		},
+		"jq": {
+			doc:  "rules:\n  - rule:\n      set:\n        match:\n          - cel: 'true'\n          - jq: '.status'\n",
+			want: ErrCelJq,
+		},
+		"named jq": {
+			doc:  "terms:\n  status:\n    jq: '.status'\nrules:\n  - rule:\n      sequence:\n        order:\n          - cel: 'true'\n          - status\n",
+			want: ErrCelJq,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := p.Rewrite([]byte(tc.doc)); !errors.Is(err, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestBind(t *testing.T) {
+
+	p, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := p.add("fields.status >= 500 && fields.latency_ms > 250")
+	if err != nil {
+		t.Fatal(err)
+	}
+	level, err := p.add(`fields.level == "error"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		line string
+		want []string
+	}{
+		"json": {
+			line: `2025-03-11T14:00:00Z {"status": 503, "latency_ms": 900, "level": "error"}`,
+			want: []string{status, level},
+		},
+		"json below threshold": {
+			line: `{"status": 503, "latency_ms": 12}`,
+		},
+		"logfmt": {
+			line: `ts=2025-03-11T14:00:00Z level=error status=502 latency_ms=251.5`,
+			want: []string{status, level},
+		},
+		"logfmt after prefix": {
+			line: `2025-03-11T14:00:00Z INFO status=500 latency_ms=300`,
+			want: []string{status},
+		},
+		"missing field": {
+			line: `level=info msg=ok`,
+		},
+		"unstructured": {
+			line: `connection reset by peer`,
+		},
+	}
+
+	b := p.Bind([]string{status, level, "x"})
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := b.Holds(nil, Fields(tc.line), tc.line)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("Expected %q, got %q", tc.want, got)
+			}
+
+			line := strings.Join(append([]string{tc.line}, got...), " ")
+			if Strip(line) != tc.line {
+				t.Errorf("Expected Strip to restore %q, got %q", tc.line, Strip(line))
+			}
+		})
+	}
+
+	// A rule binds only its own expressions
+	if got := p.Bind([]string{level}).Holds(nil, Fields(`{"status": 503, "latency_ms": 900}`), ""); len(got) != 0 {
+		t.Errorf("Expected only the bound expression, got %q", got)
+	}
+
+	// Expressions of rules a reload removed are dropped
+	p.Retain(func(marker string) bool { return marker == level })
+	if p.Len() != 1 || p.Bind([]string{status}) != nil {
+		t.Errorf("Expected only %q kept", level)
+	}
+}
+
+func TestNilPrograms(t *testing.T) {
+	var p *ProgramsT
+
+	if p.Len() != 0 || p.Bind([]string{markerPrefix + "00" + markerSuffix}) != nil {
+		t.Error("Expected nil programs to bind nothing")
+	}
+	if _, ok := p.Expr(markerPrefix + "00" + markerSuffix); ok {
+		t.Error("Expected no expression")
+	}
+}
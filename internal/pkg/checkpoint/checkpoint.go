@@ -0,0 +1,241 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/dirz"
+	"github.com/rs/zerolog/log"
+)
+
+// A checkpoint records how far each source of a run has been scanned, so
+// an interrupted or scheduled run resumes where the last one stopped. The
+// position is the timestamp of the last entry scanned and how many entries
+// stamped with it were scanned, which survives log rotation where a byte
+// offset would not. Partial matches held by the engine are not saved;
+// instead a resumed run re-reads the longest rule window before the
+// position to rebuild them, and drops what those lines alone complete, as
+// the run that read them reported it.
+
+const (
+	saveInterval = 5 * time.Second
+	fileMode     = 0600
+)
+
+var (
+	ErrInvalidName = errors.New("invalid checkpoint name")
+
+	// DefaultDir holds named checkpoints, in the data directory.
+	DefaultDir = filepath.Join(dirz.Data(), "checkpoints")
+
+	validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+)
+
+// SourceT is the progress of one source.
+type SourceT struct {
+	Timestamp int64     `json:"timestamp"`      // last entry scanned, unix nanoseconds
+	Seen      int64     `json:"seen,omitempty"` // entries scanned stamped at Timestamp
+	Lines     int64     `json:"lines"`          // scanned by every run so far
+	Updated   time.Time `json:"updated"`
+}
+
+type fileT struct {
+	Replay  int64              `json:"replay,omitempty"` // nanoseconds re-read before each position
+	Sources map[string]SourceT `json:"sources"`
+}
+
+// StoreT keeps a checkpoint in memory and writes it out periodically and
+// on Close. A nil *StoreT is valid and records nothing, so callers need not
+// check whether checkpointing is on.
+type StoreT struct {
+	mux     sync.Mutex
+	path    string
+	sources map[string]SourceT
+	base    map[string]int64 // lines scanned before this run
+	replay  int64
+	dirty   bool
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// Open loads the checkpoint called name from DefaultDir, or starts an
+// empty one.
+func Open(name string) (*StoreT, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return OpenPath(filepath.Join(DefaultDir, name+".json"))
+}
+
+// OpenPath loads the checkpoint at path, or starts an empty one.
+func OpenPath(path string) (*StoreT, error) {
+
+	s := &StoreT{
+		path:    path,
+		sources: make(map[string]SourceT),
+		base:    make(map[string]int64),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		var f fileT
+		if err = json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+		}
+		s.replay = f.Replay
+		for k, v := range f.Sources {
+			s.sources[k] = v
+			s.base[k] = v.Lines
+		}
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+// Resume returns the time to resume each source from: its position, less
+// the replay recorded by the run that wrote it.
+func (s *StoreT) Resume() map[string]int64 {
+	if s == nil {
+		return nil
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	out := make(map[string]int64, len(s.sources))
+	for k, v := range s.sources {
+		out[k] = v.Timestamp - s.replay
+	}
+	return out
+}
+
+// Position returns the timestamp key was scanned up to and how many
+// entries stamped with it were scanned.
+func (s *StoreT) Position(key string) (ts, seen int64, ok bool) {
+	if s == nil {
+		return 0, 0, false
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	cur, ok := s.sources[key]
+	return cur.Timestamp, cur.Seen, ok
+}
+
+// SetReplay records how far before each position the next run re-reads,
+// i.e. the longest window of the rules.
+func (s *StoreT) SetReplay(replay time.Duration) {
+	if s == nil {
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if int64(replay) != s.replay {
+		s.replay = int64(replay)
+		s.dirty = true
+	}
+}
+
+// Mark records that key has been scanned up to ts, of which seen entries
+// were stamped ts, with lines scanned so far in this run.
+func (s *StoreT) Mark(key string, ts, seen, lines int64) {
+	if s == nil {
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	// Never move backwards, e.g. when a later run starts earlier
+	if cur, ok := s.sources[key]; ok {
+		switch {
+		case ts < cur.Timestamp:
+			ts, seen = cur.Timestamp, cur.Seen
+		case ts == cur.Timestamp:
+			seen = max(seen, cur.Seen)
+		}
+	}
+
+	s.sources[key] = SourceT{
+		Timestamp: ts,
+		Seen:      seen,
+		Lines:     s.base[key] + lines,
+		Updated:   time.Now().UTC(),
+	}
+	s.dirty = true
+}
+
+func (s *StoreT) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				log.Warn().Err(err).Str("path", s.path).Msg("Failed to save checkpoint")
+			}
+		}
+	}
+}
+
+// Save writes the checkpoint if it changed, replacing the file atomically.
+func (s *StoreT) Save() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mux.Lock()
+	if !s.dirty {
+		s.mux.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(fileT{Replay: s.replay, Sources: s.sources}, "", "  ")
+	s.dirty = false
+	s.mux.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, data, fileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Close stops the periodic save and writes the final state.
+func (s *StoreT) Close() error {
+	if s == nil {
+		return nil
+	}
+	close(s.quit)
+	<-s.done
+	return s.Save()
+}
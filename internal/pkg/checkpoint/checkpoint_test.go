@@ -0,0 +1,72 @@
+package checkpoint
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nightly.json")
+
+	s, err := OpenPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Resume()) != 0 {
+		t.Errorf("Expected an empty checkpoint, got %v", s.Resume())
+	}
+
+	s.Mark("app/api", 100, 1, 10)
+	s.Mark("app/api", 200, 2, 20)
+	s.SetReplay(50)
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next run resumes a replay before the position and keeps counting
+	// lines
+	if s, err = OpenPath(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Resume()["app/api"]; got != 150 {
+		t.Errorf("Expected to resume from 150, got %d", got)
+	}
+	if ts, seen, ok := s.Position("app/api"); !ok || ts != 200 || seen != 2 {
+		t.Errorf("Expected position 200 after 2 entries, got %d, %d, %v", ts, seen, ok)
+	}
+
+	s.Mark("app/api", 150, 1, 5)
+	if got := s.sources["app/api"]; got.Timestamp != 200 || got.Seen != 2 || got.Lines != 25 {
+		t.Errorf("Expected timestamp 200, 2 seen and 25 lines, got %+v", got)
+	}
+
+	// More entries stamped at the position move it on
+	s.Mark("app/api", 200, 3, 6)
+	if got := s.sources["app/api"]; got.Seen != 3 {
+		t.Errorf("Expected 3 seen, got %+v", got)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckpointNil(t *testing.T) {
+	var s *StoreT
+	s.Mark("app/api", 1, 1, 1)
+	s.SetReplay(1)
+	if _, _, ok := s.Position("app/api"); ok {
+		t.Error("Expected no position")
+	}
+	if s.Resume() != nil || s.Save() != nil || s.Close() != nil {
+		t.Errorf("Expected a nil checkpoint to do nothing")
+	}
+}
+
+func TestCheckpointName(t *testing.T) {
+	for _, name := range []string{"", "../etc", "a/b", ".hidden"} {
+		if _, err := Open(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Open(%q): expected ErrInvalidName, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/auth"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/rs/zerolog/log"
+)
+
+// authStatus prints the token rule updates use: the API token if set, else
+// the saved login.
+func authStatus() error {
+
+	var (
+		st  *auth.StatusT
+		err error
+	)
+
+	if apiToken := auth.ApiToken(Options.Token); apiToken != "" {
+		st = auth.ApiTokenStatus(apiToken)
+	} else if st, err = auth.Status(ruleToken); err != nil {
+		log.Error().Err(err).Msg("Failed to read login")
+		ux.AuthError(err)
+		return err
+	}
+
+	if Options.AuthCmd.Status.Json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+
+	profile, _ := currentProfile()
+	printAuthStatus(os.Stdout, st, profile, time.Now())
+
+	return nil
+}
+
+func printAuthStatus(w io.Writer, st *auth.StatusT, profile string, now time.Time) {
+
+	fmt.Fprintf(w, "Profile:  %s\n", profile)
+
+	switch st.Login {
+	case auth.LoginNone:
+		fmt.Fprintf(w, "Login:    none; preq login or the next run logs in\n")
+		return
+	case auth.LoginSso:
+		fmt.Fprintf(w, "Login:    sso through %s\n", st.Issuer)
+	case auth.LoginApi:
+		fmt.Fprintf(w, "Login:    API token of --token or %s\n", auth.ApiTokenEnv)
+	default:
+		fmt.Fprintf(w, "Login:    %s\n", st.Login)
+	}
+
+	account := st.Email
+	if st.Name != "" {
+		account = fmt.Sprintf("%s <%s>", st.Name, st.Email)
+	}
+	if st.Org != "" {
+		account += ", org " + st.Org
+	}
+	if account != "" {
+		fmt.Fprintf(w, "Account:  %s\n", account)
+	}
+
+	var state string
+	switch {
+	case st.Valid:
+		state = "valid, expires in " + until(st.ExpiresAt.Sub(now))
+	case st.Refreshable:
+		state = "expired; the next run refreshes it"
+	case !st.ExpiresAt.IsZero() && st.ExpiresAt.Before(now):
+		state = "expired"
+	default:
+		state = "invalid"
+	}
+	if !st.ExpiresAt.IsZero() {
+		state = st.ExpiresAt.Format(time.RFC3339) + " (" + state + ")"
+	}
+	fmt.Fprintf(w, "Token:    %s\n", state)
+
+	switch st.Stored {
+	case auth.StoredKeychain:
+		fmt.Fprintf(w, "Stored:   OS keychain\n")
+	case auth.StoredFile:
+		fmt.Fprintf(w, "Stored:   %s\n", st.Path)
+	}
+}
+
+// authLogout removes the saved login of the profile.
+func authLogout() error {
+
+	if err := auth.Logout(ruleToken); err != nil {
+		log.Error().Err(err).Msg("Failed to log out")
+		ux.AuthError(err)
+		return err
+	}
+
+	profile, _ := currentProfile()
+	fmt.Fprintf(os.Stderr, "Logged out of profile %s\n", profile)
+
+	return nil
+}
+
+// authRefresh gets a new token for an sso login before it expires.
+func authRefresh(ctx context.Context) error {
+
+	c, err := loadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	if err := setProxy(c.Proxy); err != nil {
+		log.Error().Err(err).Msg("Invalid proxy")
+		ux.ConfigError(err)
+		return err
+	}
+
+	if _, err := auth.ForceRefresh(ctx, baseAddr, ruleToken); err != nil {
+		log.Error().Err(err).Msg("Failed to refresh login")
+		ux.AuthError(err)
+		return err
+	}
+
+	st, err := auth.Status(ruleToken)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Refreshed login; expires %s\n", st.ExpiresAt.Format(time.RFC3339))
+
+	return nil
+}
+
+// until formats d in days once it is more than two, else in minutes.
+func until(d time.Duration) string {
+	const day = 24 * time.Hour
+	if d > 2*day {
+		return fmt.Sprintf("%d days", d/day)
+	}
+	return d.Round(time.Minute).String()
+}
@@ -2,41 +2,348 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/Masterminds/semver"
 	"github.com/prequel-dev/preq/internal/pkg/auth"
+	"github.com/prequel-dev/preq/internal/pkg/checkpoint"
 	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/debugz"
+	"github.com/prequel-dev/preq/internal/pkg/decisionz"
+	"github.com/prequel-dev/preq/internal/pkg/deprecz"
+	"github.com/prequel-dev/preq/internal/pkg/dirz"
 	"github.com/prequel-dev/preq/internal/pkg/engine"
+	"github.com/prequel-dev/preq/internal/pkg/envz"
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
+	"github.com/prequel-dev/preq/internal/pkg/pluginz"
+	"github.com/prequel-dev/preq/internal/pkg/policy"
+	"github.com/prequel-dev/preq/internal/pkg/queuez"
 	"github.com/prequel-dev/preq/internal/pkg/resolve"
 	"github.com/prequel-dev/preq/internal/pkg/rules"
 	"github.com/prequel-dev/preq/internal/pkg/runbook"
+	"github.com/prequel-dev/preq/internal/pkg/statz"
+	"github.com/prequel-dev/preq/internal/pkg/suppress"
 	"github.com/prequel-dev/preq/internal/pkg/utils"
 	"github.com/prequel-dev/preq/internal/pkg/ux"
-	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+	"github.com/prequel-dev/preq/pkg/preq"
 	"github.com/prequel-dev/prequel-logmatch/pkg/timez"
 	"github.com/rs/zerolog/log"
 )
 
 var Options struct {
-	Action        string `short:"a" help:"${actionHelp}"`
-	Disabled      bool   `short:"d" help:"${disabledHelp}"`
-	Generate      bool   `short:"g" help:"${generateHelp}"`
-	Cron          bool   `short:"j" help:"${cronHelp}"`
-	Level         string `short:"l" help:"${levelHelp}"`
-	Name          string `short:"o" help:"${nameHelp}"`
-	Quiet         bool   `short:"q" help:"${quietHelp}"`
-	Rules         string `short:"r" help:"${rulesHelp}"`
-	Source        string `short:"s" help:"${sourceHelp}"`
-	Version       bool   `short:"v" help:"${versionHelp}"`
-	AcceptUpdates bool   `short:"y" help:"${acceptUpdatesHelp}"`
+	Action            string        `short:"a" help:"${actionHelp}"`
+	Begin             string        `short:"b" help:"${beginHelp}"`
+	Baseline          string        `help:"${baselineHelp}"`
+	Checkpoint        string        `help:"${checkpointHelp}"`
+	Collapse          bool          `help:"${collapseHelp}"`
+	ConfigDir         string        `help:"${configDirHelp}"`
+	Context           int           `help:"${contextHelp}"`
+	Coverage          bool          `help:"${coverageHelp}"`
+	DedupWindow       time.Duration `help:"${dedupWindowHelp}"`
+	Disabled          bool          `short:"d" help:"${disabledHelp}"`
+	End               string        `short:"e" help:"${endHelp}"`
+	ExcludeTags       []string      `help:"${excludeTagsHelp}"`
+	Explain           string        `help:"${explainHelp}"`
+	FailOn            string        `help:"${failOnHelp}"`
+	Follow            bool          `short:"f" help:"${followHelp}"`
+	Generate          bool          `short:"g" help:"${generateHelp}"`
+	Head              int64         `help:"${headHelp}"`
+	Cron              bool          `short:"j" help:"${cronHelp}"`
+	Level             string        `short:"l" help:"${levelHelp}"`
+	Lookback          time.Duration `help:"${lookbackHelp}"`
+	MaxLinesPerSource int64         `help:"${maxLinesHelp}"`
+	MemoryLimit       int           `help:"${memoryLimitHelp}"`
+	MinSeverity       string        `help:"${minSeverityHelp}"`
+	Name              string        `short:"o" help:"${nameHelp}"`
+	Parallel          int           `help:"${parallelHelp}"`
+	Policy            string        `short:"p" help:"${policyHelp}"`
+	Profile           string        `help:"${profileHelp}"`
+	Pprof             string        `help:"${pprofHelp}"`
+	ProfileRules      bool          `help:"${profileRulesHelp}"`
+	QueuePolicy       string        `help:"${queuePolicyHelp}"`
+	QueueSize         int           `help:"${queueSizeHelp}"`
+	Quiet             bool          `short:"q" help:"${quietHelp}"`
+	Rules             string        `short:"r" help:"${rulesHelp}"`
+	RuleTimeout       time.Duration `help:"${ruleTimeoutHelp}"`
+	Rotated           bool          `help:"${rotatedHelp}"`
+	SampleRate        float64       `help:"${sampleRateHelp}"`
+	Source            string        `short:"s" help:"${sourceHelp}"`
+	StdinFormat       string        `help:"${stdinFormatHelp}"`
+	Tags              []string      `help:"${tagsHelp}"`
+	Tail              int64         `help:"${tailHelp}"`
+	Token             string        `help:"${tokenHelp}"`
+	Trace             string        `help:"${traceHelp}"`
+	Tz                string        `help:"${tzHelp}"`
+	Version           bool          `short:"v" help:"${versionHelp}"`
+	Year              int           `help:"${yearHelp}"`
+	AcceptUpdates     bool          `short:"y" help:"${acceptUpdatesHelp}"`
+	NoUpdateCheck     bool          `help:"${noUpdateCheckHelp}"`
+	NoStrictConfig    bool          `help:"${noStrictConfigHelp}"`
+	NoProjectConfig   bool          `help:"${noProjectConfigHelp}"`
+	TrustProject      bool          `help:"${trustProjectHelp}"`
+	Offline           bool          `help:"${offlineHelp}"`
+
+	Scan       struct{}   `cmd:"" default:"1" hidden:""`
+	Daemon     struct{}   `cmd:"" help:"${daemonHelp}"`
+	Report     ReportCmd  `cmd:"" help:"${reportHelp}"`
+	RulesCmd   RulesCmd   `cmd:"" name:"rules" help:"${rulesCmdHelp}"`
+	Login      LoginCmd   `cmd:"" help:"${loginHelp}"`
+	AuthCmd    AuthCmd    `cmd:"" name:"auth" help:"${authCmdHelp}"`
+	ProfileCmd ProfileCmd `cmd:"" name:"profile" help:"${profileCmdHelp}"`
+	ConfigCmd  ConfigCmd  `cmd:"" name:"config" help:"${configCmdHelp}"`
+
+	InstallCompletions InstallCompletionsCmd `cmd:"" help:"${installCompletionsHelp}"`
+}
+
+type ReportCmd struct {
+	Graph   ReportGraphCmd   `cmd:"" help:"${reportGraphHelp}"`
+	Sources ReportSourcesCmd `cmd:"" help:"${reportSourcesHelp}"`
+}
+
+type ReportGraphCmd struct {
+	Path   string        `arg:"" type:"existingfile" help:"${reportPathHelp}"`
+	Format string        `enum:"mermaid,dot" default:"mermaid" help:"${graphFormatHelp}"`
+	Window time.Duration `default:"1m" help:"${graphWindowHelp}"`
+}
+
+type ReportSourcesCmd struct {
+	Path   string `arg:"" type:"existingfile" help:"${reportPathHelp}"`
+	Format string `enum:"text,json" default:"text" help:"${sourcesFormatHelp}"`
+}
+
+type RulesCmd struct {
+	List   RulesListCmd   `cmd:"" help:"${rulesListHelp}"`
+	Show   RulesShowCmd   `cmd:"" help:"${rulesShowHelp}"`
+	Search RulesSearchCmd `cmd:"" help:"${rulesSearchHelp}"`
+	Lint   RulesLintCmd   `cmd:"" help:"${rulesLintHelp}"`
+	Test   RulesTestCmd   `cmd:"" help:"${rulesTestHelp}"`
+	Export RulesExportCmd `cmd:"" help:"${rulesExportHelp}"`
+	Import RulesImportCmd `cmd:"" help:"${rulesImportHelp}"`
+	Update RulesUpdateCmd `cmd:"" help:"${rulesUpdateHelp}"`
+	Diff   RulesDiffCmd   `cmd:"" help:"${rulesDiffHelp}"`
+	Cache  RulesCacheCmd  `cmd:"" help:"${rulesCacheHelp}"`
+	Get    RulesGetCmd    `cmd:"" help:"${rulesGetHelp}"`
+}
+
+type RulesShowCmd struct {
+	Id string `arg:"" help:"${creIdHelp}"`
+}
+
+type RulesListCmd struct {
+	Json bool `help:"${jsonHelp}"`
+}
+
+type RulesSearchCmd struct {
+	Words      []string `arg:"" optional:"" help:"${searchWordsHelp}"`
+	Technology string   `help:"${searchTechnologyHelp}"`
+	Category   string   `help:"${searchCategoryHelp}"`
+	Tag        []string `help:"${searchTagHelp}"`
+	Severity   string   `help:"${searchSeverityHelp}"`
+	Json       bool     `help:"${jsonHelp}"`
+}
+
+type RulesLintCmd struct {
+	Paths []string `arg:"" optional:"" help:"${lintPathsHelp}"`
+	Json  bool     `help:"${jsonHelp}"`
+}
+
+type RulesTestCmd struct {
+	Paths []string `arg:"" optional:"" help:"${testPathsHelp}"`
+	Json  bool     `help:"${jsonHelp}"`
+}
+
+type RulesExportCmd struct {
+	Bundle string `required:"" type:"path" help:"${exportBundleHelp}"`
+	Json   bool   `help:"${jsonHelp}"`
+}
+
+type RulesImportCmd struct {
+	Bundle string `arg:"" type:"existingfile" help:"${importBundleHelp}"`
+	Json   bool   `help:"${jsonHelp}"`
+}
+
+type RulesUpdateCmd struct {
+	Check bool `help:"${updateCheckHelp}"`
+	Json  bool `help:"${jsonHelp}"`
+}
+
+type RulesDiffCmd struct {
+	Old  string `arg:"" help:"${diffOldHelp}"`
+	New  string `arg:"" help:"${diffNewHelp}"`
+	Json bool   `help:"${jsonHelp}"`
+}
+
+type RulesGetCmd struct {
+	Id     string `arg:"" help:"${creIdHelp}"`
+	Output string `type:"path" help:"${getOutputHelp}"`
+	Force  bool   `help:"${getForceHelp}"`
+}
+
+type RulesCacheCmd struct {
+	Info  RulesCacheInfoCmd  `cmd:"" help:"${cacheInfoHelp}"`
+	Prune RulesCachePruneCmd `cmd:"" help:"${cachePruneHelp}"`
+	Clear RulesCacheClearCmd `cmd:"" help:"${cacheClearHelp}"`
+}
+
+type RulesCacheInfoCmd struct {
+	Keep int  `default:"1" help:"${cacheKeepHelp}"`
+	Json bool `help:"${jsonHelp}"`
+}
+
+type RulesCachePruneCmd struct {
+	Keep int  `default:"1" help:"${cacheKeepHelp}"`
+	Json bool `help:"${jsonHelp}"`
+}
+
+type RulesCacheClearCmd struct {
+	Json bool `help:"${jsonHelp}"`
+}
+
+type LoginCmd struct {
+	Sso bool `help:"${loginSsoHelp}"`
+}
+
+type AuthCmd struct {
+	Status  AuthStatusCmd `cmd:"" help:"${authStatusHelp}"`
+	Logout  struct{}      `cmd:"" help:"${authLogoutHelp}"`
+	Refresh struct{}      `cmd:"" help:"${authRefreshHelp}"`
+}
+
+type AuthStatusCmd struct {
+	Json bool `help:"${jsonHelp}"`
+}
+
+type ProfileCmd struct {
+	List ProfileListCmd `cmd:"" help:"${profileListHelp}"`
+	Use  ProfileUseCmd  `cmd:"" help:"${profileUseHelp}"`
+}
+
+type ProfileListCmd struct{}
+
+type ProfileUseCmd struct {
+	Name string `arg:"" help:"${profileNameHelp}"`
+}
+
+type ConfigCmd struct {
+	Init   ConfigInitCmd   `cmd:"" help:"${configInitHelp}"`
+	Get    ConfigGetCmd    `cmd:"" help:"${configGetHelp}"`
+	Set    ConfigSetCmd    `cmd:"" help:"${configSetHelp}"`
+	View   struct{}        `cmd:"" help:"${configViewHelp}"`
+	Secret ConfigSecretCmd `cmd:"" help:"${secretCmdHelp}"`
+}
+
+type ConfigInitCmd struct {
+	Force bool `help:"${configForceHelp}"`
+}
+
+type ConfigGetCmd struct {
+	Key string `arg:"" help:"${configKeyHelp}"`
+}
+
+type ConfigSetCmd struct {
+	Key   string `arg:"" help:"${configKeyHelp}"`
+	Value string `arg:"" help:"${configValueHelp}"`
+}
+
+type ConfigSecretCmd struct {
+	Name string `arg:"" help:"${secretNameHelp}"`
+}
+
+type InstallCompletionsCmd struct {
+	Shell string `enum:",bash,zsh,fish,powershell" default:"" help:"${completionShellHelp}"`
+	Print bool   `help:"${completionPrintHelp}"`
+}
+
+const (
+	cmdDaemon             = "daemon"
+	cmdReportGraph        = "report graph <path>"
+	cmdReportSources      = "report sources <path>"
+	cmdRulesList          = "rules list"
+	cmdRulesShow          = "rules show <id>"
+	cmdRulesSearch        = "rules search"
+	cmdRulesSearchWords   = "rules search <words>"
+	cmdRulesLint          = "rules lint"
+	cmdRulesLintPaths     = "rules lint <paths>"
+	cmdRulesTest          = "rules test"
+	cmdRulesTestPaths     = "rules test <paths>"
+	cmdRulesExport        = "rules export"
+	cmdRulesImport        = "rules import <bundle>"
+	cmdRulesUpdate        = "rules update"
+	cmdRulesDiff          = "rules diff <old> <new>"
+	cmdRulesCacheInfo     = "rules cache info"
+	cmdRulesCachePrune    = "rules cache prune"
+	cmdRulesCacheClear    = "rules cache clear"
+	cmdRulesGet           = "rules get <id>"
+	cmdLogin              = "login"
+	cmdAuthStatus         = "auth status"
+	cmdAuthLogout         = "auth logout"
+	cmdAuthRefresh        = "auth refresh"
+	cmdProfileList        = "profile list"
+	cmdProfileUse         = "profile use <name>"
+	cmdConfigInit         = "config init"
+	cmdConfigGet          = "config get <key>"
+	cmdConfigSet          = "config set <key> <value>"
+	cmdConfigView         = "config view"
+	cmdConfigSecret       = "config secret <name>"
+	cmdInstallCompletions = "install-completions"
+)
+
+var (
+	ErrHeadAndTail    = errors.New("--head and --tail are mutually exclusive")
+	ErrBeginAfterEnd  = errors.New("--begin is after --end")
+	ErrSampleRate     = errors.New("--sample-rate must be between 0 and 1")
+	ErrMaxLines       = errors.New("--max-lines-per-source must be positive")
+	ErrOfflineRules   = errors.New("--offline needs local rules with -r")
+	ErrOfflinePlugins = errors.New("--offline cannot run matcher plugins, which may reach the network")
+	ErrMemoryLimit    = errors.New("--memory-limit must be positive")
+	ErrContext        = errors.New("--context must be positive")
+	ErrDedupWindow    = errors.New("--dedup-window must be positive")
+	ErrQueueSize      = errors.New("--queue-size must be positive")
+	ErrRuleTimeout    = errors.New("--rule-timeout must be positive")
+	ErrLookback       = errors.New("--lookback must be positive")
+	ErrAuthorPaths    = errors.New("no rules given; pass rule paths or -r")
+	ErrLintFailed     = errors.New("rules have lint errors")
+	ErrRuleTests      = errors.New("rules failed their tests")
+	ErrOutputExists   = errors.New("output file exists; pass --force to overwrite it")
+)
+
+const (
+	ExitError      = 1 // operational error
+	ExitDetections = 2 // detections at or above --fail-on
+)
+
+// ExitErrorT requests a specific process exit code, e.g. a policy failure.
+type ExitErrorT struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitErrorT) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitErrorT) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code for an error returned by Execute.
+func ExitCode(err error) int {
+	var ee *ExitErrorT
+	if errors.As(err, &ee) {
+		return ee.Code
+	}
+	return ExitError
 }
 
 var (
-	// https://specifications.freedesktop.org/basedir-spec/latest/
-	defaultConfigDir = filepath.Join(os.Getenv("HOME"), ".config", "preq")
+	defaultConfigDir = dirz.Config("")
 	ruleToken        = filepath.Join(defaultConfigDir, ".ruletoken")
 	ruleUpdateFile   = filepath.Join(defaultConfigDir, ".ruleupdate")
 )
@@ -49,6 +356,9 @@ var (
 	loginUserFunc = func(ctx context.Context, baseAddr, ruleToken string) (string, error) {
 		return auth.Login(ctx, baseAddr, ruleToken)
 	}
+	refreshTokenFunc = func(ctx context.Context, baseAddr, ruleToken string) (string, error) {
+		return auth.Refresh(ctx, baseAddr, ruleToken)
+	}
 )
 
 const (
@@ -68,23 +378,226 @@ func tsOpts(c *config.Config) []resolve.OptT {
 	return opts
 }
 
-func parseSources(fn string, opts ...resolve.OptT) ([]*resolve.LogData, error) {
+// parseSampling validates --sample-rate and --max-lines-per-source. It
+// returns nil when every line is scanned.
+func parseSampling(rate float64, maxLines int64) (*ux.SamplingT, error) {
+	switch {
+	case rate < 0 || rate > 1:
+		return nil, ErrSampleRate
+	case maxLines < 0:
+		return nil, ErrMaxLines
+	case (rate == 0 || rate == 1) && maxLines == 0:
+		return nil, nil
+	}
+
+	s := &ux.SamplingT{MaxLinesPerSource: maxLines}
+	if rate < 1 {
+		s.Rate = rate
+	}
+	return s, nil
+}
+
+// parallelism maps the --parallel flag to a worker count; unset or
+// negative uses every CPU.
+func parallelism(n int) int {
+	if n <= 0 {
+		return runtime.NumCPU()
+	}
+	return n
+}
+
+// parseTimeRange parses the --begin and --end flags; unset bounds are zero.
+func parseTimeRange(b, e string, now time.Time) (begin, end time.Time, err error) {
+
+	if b != "" {
+		if begin, err = resolve.ParseTime(b, now); err != nil {
+			return begin, end, fmt.Errorf("--begin: %w", err)
+		}
+	}
+
+	if e != "" {
+		if end, err = resolve.ParseTime(e, now); err != nil {
+			return begin, end, fmt.Errorf("--end: %w", err)
+		}
+	}
+
+	if !begin.IsZero() && !end.IsZero() && begin.After(end) {
+		return begin, end, ErrBeginAfterEnd
+	}
+
+	return begin, end, nil
+}
+
+// Execute runs the subcommand selected on the command line; the default
+// is a scan.
+func Execute(ctx context.Context, command string) error {
+
+	// Profile commands run before a profile is selected, so that preq
+	// profile use can switch away from one that no longer exists
+	switch command {
+	case cmdProfileList:
+		return profileList()
+	case cmdProfileUse:
+		return profileUse()
+	}
+
+	if err := selectProfile(); err != nil {
+		log.Error().Err(err).Msg("Failed to select profile")
+		ux.ConfigError(err)
+		return err
+	}
+
+	if Options.Offline {
+		httpz.SetOffline(true)
+	}
+
+	switch command {
+	case cmdDaemon:
+		return runDaemon(ctx)
+	case cmdReportGraph:
+		return reportGraph()
+	case cmdReportSources:
+		return reportSources()
+	case cmdRulesList:
+		return rulesList()
+	case cmdRulesShow:
+		return rulesShow()
+	case cmdRulesSearch, cmdRulesSearchWords:
+		return rulesSearch()
+	case cmdRulesLint, cmdRulesLintPaths:
+		return rulesLint()
+	case cmdRulesTest, cmdRulesTestPaths:
+		return rulesTest(ctx)
+	case cmdRulesExport:
+		return rulesExport()
+	case cmdRulesImport:
+		return rulesImport()
+	case cmdRulesUpdate:
+		return rulesUpdate(ctx)
+	case cmdRulesDiff:
+		return rulesDiff()
+	case cmdRulesCacheInfo:
+		return rulesCacheInfo()
+	case cmdRulesCachePrune:
+		return rulesCachePrune()
+	case cmdRulesCacheClear:
+		return rulesCacheClear()
+	case cmdRulesGet:
+		return rulesGet()
+	case cmdLogin:
+		return login(ctx)
+	case cmdAuthStatus:
+		return authStatus()
+	case cmdAuthLogout:
+		return authLogout()
+	case cmdAuthRefresh:
+		return authRefresh(ctx)
+	case cmdConfigInit:
+		return configInit()
+	case cmdConfigGet:
+		return configGet()
+	case cmdConfigSet:
+		return configSet()
+	case cmdConfigView:
+		return configView()
+	case cmdConfigSecret:
+		return configSecret(os.Stdin)
+	case cmdInstallCompletions:
+		return installCompletions()
+	}
+	return execute(ctx, false)
+}
+
+// login logs in again, through the identity provider of the config with
+// --sso, and saves the login for the runs to come.
+func login(ctx context.Context) error {
+
+	c, err := loadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	if err := setProxy(c.Proxy); err != nil {
+		log.Error().Err(err).Msg("Invalid proxy")
+		ux.ConfigError(err)
+		return err
+	}
 
-	ds, err := datasrc.ParseFile(fn)
+	if Options.Login.Sso {
+		if c.Sso.Issuer == "" || c.Sso.ClientId == "" {
+			ux.ConfigError(auth.ErrSsoNotConfigured)
+			return auth.ErrSsoNotConfigured
+		}
+		_, err = auth.SsoLogin(ctx, baseAddr, ruleToken, c.Sso)
+	} else {
+		_, err = auth.DeviceLogin(ctx, baseAddr, ruleToken)
+	}
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to parse data sources file")
-		return nil, err
+		log.Error().Err(err).Msg("Failed to log in")
+		ux.AuthError(err)
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "Logged in; rule updates use this login until it expires")
+
+	return nil
+}
+
+func installCompletions() error {
+	opts := Options.InstallCompletions
+	if err := ux.InstallCompletions(os.Stdout, opts.Shell, opts.Print); err != nil {
+		log.Error().Err(err).Msg("Failed to install completions")
+		ux.ConfigError(err)
+		return err
 	}
+	return nil
+}
 
-	if err := datasrc.Validate(ds); err != nil {
-		log.Error().Err(err).Msg("Failed to validate data sources")
-		return nil, err
+func reportGraph() error {
+	opts := Options.Report.Graph
+	if err := ux.GraphReport(os.Stdout, opts.Path, opts.Format, opts.Window); err != nil {
+		log.Error().Err(err).Str("path", opts.Path).Msg("Failed to graph report")
+		ux.DataError(err)
+		return err
 	}
+	return nil
+}
 
-	return resolve.Resolve(ds, opts...), nil
+func reportSources() error {
+	opts := Options.Report.Sources
+	if err := ux.SourcesReport(os.Stdout, opts.Path, opts.Format); err != nil {
+		log.Error().Err(err).Str("path", opts.Path).Msg("Failed to group report by source")
+		ux.DataError(err)
+		return err
+	}
+	return nil
+}
+
+// runDaemon keeps the engine resident: sources are followed, rules are
+// applied as lines arrive, and each detection is handed to the --action
+// runbook as soon as it is found. Rules are reloaded as they change. It
+// runs until interrupted.
+func runDaemon(ctx context.Context) error {
+	Options.Follow = true
+	return execute(ctx, true)
 }
 
+// InitAndExecute selects the profile and scans, for front-ends that do
+// not go through Execute.
 func InitAndExecute(ctx context.Context) error {
+
+	if err := selectProfile(); err != nil {
+		log.Error().Err(err).Msg("Failed to select profile")
+		ux.ConfigError(err)
+		return err
+	}
+
+	return execute(ctx, false)
+}
+
+func execute(ctx context.Context, daemon bool) error {
 	var (
 		c          *config.Config
 		token      string
@@ -92,6 +605,32 @@ func InitAndExecute(ctx context.Context) error {
 		err        error
 	)
 
+	defer httpz.LogStats()
+
+	if Options.Pprof != "" {
+		stop, err := debugz.Serve(Options.Pprof)
+		if err != nil {
+			log.Error().Err(err).Str("addr", Options.Pprof).Msg("Failed to serve pprof")
+			ux.ConfigError(err)
+			return err
+		}
+		defer stop()
+	}
+
+	if Options.Trace != "" {
+		stop, err := debugz.Trace(Options.Trace)
+		if err != nil {
+			log.Error().Err(err).Str("path", Options.Trace).Msg("Failed to start execution trace")
+			ux.ConfigError(err)
+			return err
+		}
+		defer func() {
+			if err := stop(); err != nil {
+				log.Warn().Err(err).Str("path", Options.Trace).Msg("Failed to write execution trace")
+			}
+		}()
+	}
+
 	switch {
 	case Options.Version:
 
@@ -108,15 +647,35 @@ func InitAndExecute(ctx context.Context) error {
 		return nil
 	}
 
-	if c, err = config.LoadConfig(defaultConfigDir, configFile); err != nil {
+	if c, err = loadConfig(); err != nil {
 		log.Error().Err(err).Msg("Failed to load config")
 		ux.ConfigError(err)
 		return err
 	}
 
+	if err = rules.ValidateRegistries(c); err != nil {
+		log.Error().Err(err).Msg("Invalid rule registries")
+		ux.ConfigError(err)
+		return err
+	}
+
+	if err = setProxy(c.Proxy); err != nil {
+		log.Error().Err(err).Msg("Invalid proxy")
+		ux.ConfigError(err)
+		return err
+	}
+
+	if err = applyFlags(c); err != nil {
+		log.Error().Err(err).Msg("Invalid offline run")
+		ux.ConfigError(err)
+		return err
+	}
+
 	// Log in for community rule updates
 	// Mockable function variable to allow for testing without real network calls
-	if token, err = loginUserFunc(ctx, baseAddr, ruleToken); err != nil {
+	if c.NoUpdateCheck {
+		log.Info().Msg("Update checks are off")
+	} else if token, err = authToken(ctx, true); err != nil {
 		log.Error().Err(err).Msg("Failed to login")
 
 		// A notice will be printed if the email is not verified
@@ -127,18 +686,6 @@ func InitAndExecute(ctx context.Context) error {
 		return err
 	}
 
-	if Options.AcceptUpdates {
-		c.AcceptUpdates = true
-	}
-
-	if Options.Disabled {
-		c.Rules.Disabled = true
-	}
-
-	if c.Skip == 0 {
-		c.Skip = timez.DefaultSkip
-	}
-
 	// Mockable function variable to allow for testing without real network calls
 	rulesPaths, err = getRulesFunc(ctx, c, defaultConfigDir, Options.Rules, token, ruleUpdateFile, baseAddr, tlsPort, udpPort)
 	if err != nil {
@@ -148,13 +695,169 @@ func InitAndExecute(ctx context.Context) error {
 	}
 
 	var (
-		topts    = tsOpts(c)
-		sources  []*engine.LogData
+		topts    = append(tsOpts(c), resolve.WithContext(ctx))
+		sources  []*preq.Source
 		useStdin = len(Options.Source) == 0 && c.DataSources == ""
 	)
 
+	if Options.Follow {
+		topts = append(topts, resolve.WithFollow(ctx))
+	}
+
+	if Options.Rotated {
+		topts = append(topts, resolve.WithRotated())
+	}
+
+	if Options.Year != 0 {
+		topts = append(topts, resolve.WithYear(Options.Year))
+	}
+
+	if Options.Tz != "" {
+		loc, err := resolve.ParseTimezone(Options.Tz)
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid timezone")
+			ux.DataError(err)
+			return err
+		}
+		topts = append(topts, resolve.WithTimezone(loc))
+	}
+
+	switch {
+	case Options.Head > 0 && Options.Tail > 0:
+		err = ErrHeadAndTail
+		log.Error().Err(err).Msg("Invalid range")
+		ux.DataError(err)
+		return err
+	case Options.Head > 0:
+		topts = append(topts, resolve.WithRange(resolve.HeadLines(Options.Head)))
+	case Options.Tail > 0:
+		topts = append(topts, resolve.WithRange(resolve.TailLines(Options.Tail)))
+	}
+
+	var (
+		stop       = utils.GetStopTime()
+		begin, end time.Time
+		sampling   *ux.SamplingT
+	)
+
+	if sampling, err = parseSampling(Options.SampleRate, Options.MaxLinesPerSource); err != nil {
+		log.Error().Err(err).Msg("Invalid sampling")
+		ux.DataError(err)
+		return err
+	}
+
+	if Options.Context < 0 {
+		log.Error().Err(ErrContext).Msg("Invalid context")
+		ux.DataError(ErrContext)
+		return ErrContext
+	}
+
+	var minSev *uint
+	if Options.MinSeverity != "" {
+		sev, err := ux.ParseSeverity(Options.MinSeverity)
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid minimum severity")
+			ux.DataError(err)
+			return err
+		}
+		minSev = &sev
+	}
+
+	var failOn *uint
+	if Options.FailOn != "" {
+		sev, err := ux.ParseSeverity(Options.FailOn)
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid fail-on severity")
+			ux.DataError(err)
+			return err
+		}
+		failOn = &sev
+	}
+
+	if Options.DedupWindow < 0 {
+		log.Error().Err(ErrDedupWindow).Msg("Invalid dedup window")
+		ux.DataError(ErrDedupWindow)
+		return ErrDedupWindow
+	}
+
+	if Options.QueueSize < 0 {
+		log.Error().Err(ErrQueueSize).Msg("Invalid queue size")
+		ux.DataError(ErrQueueSize)
+		return ErrQueueSize
+	}
+
+	if Options.Lookback < 0 {
+		log.Error().Err(ErrLookback).Msg("Invalid lookback")
+		ux.DataError(ErrLookback)
+		return ErrLookback
+	}
+
+	if Options.RuleTimeout < 0 {
+		log.Error().Err(ErrRuleTimeout).Msg("Invalid rule timeout")
+		ux.DataError(ErrRuleTimeout)
+		return ErrRuleTimeout
+	}
+
+	queuePolicy, err := queuez.ParsePolicy(Options.QueuePolicy)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid queue policy")
+		ux.DataError(err)
+		return err
+	}
+
+	if Options.MemoryLimit < 0 {
+		log.Error().Err(ErrMemoryLimit).Msg("Invalid memory limit")
+		ux.DataError(ErrMemoryLimit)
+		return ErrMemoryLimit
+	}
+
+	if begin, end, err = parseTimeRange(Options.Begin, Options.End, time.Now()); err != nil {
+		log.Error().Err(err).Msg("Invalid time range")
+		ux.DataError(err)
+		return err
+	}
+
+	if !begin.IsZero() {
+		topts = append(topts, resolve.WithBegin(begin))
+	}
+	if !end.IsZero() {
+		stop = end.UnixNano()
+	}
+
+	if Options.StdinFormat != "" {
+		if err = resolve.ValidateFormat(Options.StdinFormat); err != nil {
+			log.Error().Err(err).Msg("Invalid stdin format")
+			ux.DataError(err)
+			return err
+		}
+		if useStdin {
+			topts = append(topts, resolve.WithFormat(Options.StdinFormat))
+		} else {
+			log.Warn().Msg("Ignoring --stdin-format for configured data sources")
+		}
+	}
+
+	var cp *checkpoint.StoreT
+	switch {
+	case Options.Checkpoint == "":
+	case useStdin:
+		log.Warn().Msg("Ignoring --checkpoint for piped input")
+	default:
+		if cp, err = checkpoint.Open(Options.Checkpoint); err != nil {
+			log.Error().Err(err).Str("name", Options.Checkpoint).Msg("Failed to open checkpoint")
+			ux.ConfigError(err)
+			return err
+		}
+		defer func() {
+			if err := cp.Close(); err != nil {
+				log.Error().Err(err).Str("name", Options.Checkpoint).Msg("Failed to save checkpoint")
+			}
+		}()
+		topts = append(topts, resolve.WithResume(cp.Resume()))
+	}
+
 	if useStdin {
-		sources, err = resolve.PipeStdin(topts...)
+		sources, err = preq.ResolveStdin(preq.WithResolve(topts...))
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to read stdin")
 			ux.DataError(err)
@@ -166,8 +869,7 @@ func InitAndExecute(ctx context.Context) error {
 		if Options.Source != "" {
 			source = Options.Source
 		}
-		sources, err = parseSources(source, topts...)
-		if err != nil {
+		if sources, err = preq.ResolveFile(source, preq.WithResolve(topts...)); err != nil {
 			log.Error().Err(err).Msg("Failed to parse data sources")
 			ux.DataError(err)
 			return err
@@ -175,22 +877,88 @@ func InitAndExecute(ctx context.Context) error {
 	}
 
 	var (
-		pw           = ux.RootProgress(!useStdin)
-		renderExit   = make(chan struct{})
-		r            = engine.New(utils.GetStopTime(), ux.NewUxCmd(pw))
-		report       = ux.NewReport(pw)
-		reportPath   string
-		ruleMatchers *engine.RuleMatchersT
+		pw         = ux.RootProgress(!useStdin && !Options.Follow)
+		renderExit = make(chan struct{})
+		r          = engine.New(stop, ux.NewUxCmd(pw))
+		report     = ux.NewReport(pw)
+		reportPath string
+		session    *preq.Session
 	)
 
 	defer r.Close()
+	defer report.Close()
+
+	if minSev != nil {
+		r.SetMinSeverity(*minSev)
+	}
+
+	if len(Options.Tags) > 0 || len(Options.ExcludeTags) > 0 {
+		r.SetTags(Options.Tags, Options.ExcludeTags)
+	}
+
+	if len(c.Rules.Only) > 0 || len(c.Rules.Disable) > 0 {
+		r.SetCres(c.Rules.Only, c.Rules.Disable)
+	}
 
-	if ruleMatchers, err = r.LoadRulesPaths(report, rulesPaths); err != nil {
+	r.SetMapDeprecated(c.Rules.MapDeprecated)
+
+	r.SetRuleTimeout(Options.RuleTimeout)
+
+	if len(c.MatcherPlugins) > 0 {
+		plugins, err := pluginz.New(c.MatcherPlugins)
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid matcher plugins")
+			ux.ConfigError(err)
+			return err
+		}
+		r.SetMatcherPlugins(plugins)
+	}
+
+	if Options.DedupWindow > 0 {
+		report.SetDedupWindow(Options.DedupWindow)
+	}
+
+	// One budget, shared out so that reorder buffers, correlation state and
+	// detection hits stay within it together
+	if Options.MemoryLimit > 0 {
+		var (
+			limit = int64(Options.MemoryLimit) << 20
+			share = limit / 4
+		)
+		r.SetMemoryLimit(share, share, "")
+		report.SetMemoryLimit(limit-2*share, "")
+	}
+
+	// Hits are dropped as they are handed over in daemon mode
+	var explain *engine.ExplainT
+	switch {
+	case Options.Explain == "":
+	case daemon:
+		log.Warn().Msg("Ignoring --explain in daemon mode")
+	default:
+		explain = engine.NewExplain(Options.Explain)
+		r.SetExplain(explain)
+	}
+
+	if session, err = preq.NewSession(r, report, preq.RulesFromPaths(rulesPaths)); err != nil {
 		log.Error().Err(err).Msg("Failed to load rules")
 		ux.RulesError(err)
 		return err
 	}
 
+	if !Options.Quiet {
+		for _, d := range requestedDeprecations(r, c) {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", d)
+		}
+	}
+
+	if explain != nil && explain.Rules() == 0 {
+		err = fmt.Errorf("%w %s", engine.ErrExplainUnknownCre, Options.Explain)
+		log.Error().Err(err).Msg("Failed to explain")
+		ux.RulesError(err)
+		return err
+	}
+
 	if Options.Cron {
 		if err := ux.PrintCronJobTemplate(Options.Name, defaultConfigDir, rulesPaths[0].Path); err != nil {
 			log.Error().Err(err).Msg("Failed to write cronjob template")
@@ -212,7 +980,7 @@ func InitAndExecute(ctx context.Context) error {
 			log.Error().Err(err).Msg("Failed to get current rules version")
 		}
 
-		if template, err = ruleMatchers.DataSourceTemplate(currRulesVer); err != nil {
+		if template, err = session.Matchers.DataSourceTemplate(currRulesVer); err != nil {
 			log.Error().Err(err).Msg("Failed to generate data source template")
 			ux.RulesError(err)
 			return err
@@ -236,6 +1004,107 @@ func InitAndExecute(ctx context.Context) error {
 		return nil
 	}
 
+	if Options.Follow {
+		report.Stream()
+		r.SetFollow(true)
+		r.SetQueue(Options.QueueSize, queuePolicy)
+		r.SetLookback(Options.Lookback)
+	}
+
+	// Detections are dropped once handed over, so memory stays flat
+	switch {
+	case daemon && Options.Action != "":
+		stream, err := runbook.NewStream(ctx, Options.Action, runbook.WithBacklogPolicy(queuePolicy))
+		if err != nil {
+			log.Error().Err(err).Str("path", Options.Action).Msg("Failed to load action")
+			ux.ConfigError(err)
+			return err
+		}
+		defer stream.Close()
+		report.Notify(stream.Submit)
+	case daemon:
+		report.Notify(func(ux.ReportDocT) {})
+	}
+
+	if c.CaptureEnv {
+		report.SetEnvironment(envz.Capture(ctx))
+	}
+
+	suppressions, err := loadSuppressions(c)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load suppressions")
+		ux.ConfigError(err)
+		return err
+	}
+
+	// Detections of the baseline are known; only new ones surface
+	if Options.Baseline != "" {
+		known, err := ux.ReportedCres(Options.Baseline)
+		if err != nil {
+			log.Error().Err(err).Str("path", Options.Baseline).Msg("Failed to read baseline report")
+			ux.DataError(err)
+			return err
+		}
+		log.Info().Int("cres", len(known)).Str("path", Options.Baseline).Msg("Loaded baseline")
+		suppressions = suppressions.Merge(suppress.Known(known, "in baseline "+filepath.Base(Options.Baseline)))
+	}
+
+	report.SetSuppressions(suppressions)
+
+	if c.DecisionLog.Path != "" {
+		dl, err := decisionz.Create(c.DecisionLog.Path, c.DecisionLog.Salt)
+		if err != nil {
+			log.Error().Err(err).Str("path", c.DecisionLog.Path).Msg("Failed to create decision log")
+			ux.ConfigError(err)
+			return err
+		}
+		defer dl.Close()
+		r.SetDecisionLog(dl)
+	}
+
+	if sampling != nil {
+		r.SetSampling(*sampling)
+		report.SetSampling(*sampling)
+	}
+
+	if Options.Collapse {
+		r.SetCollapse(true)
+	}
+
+	if Options.Context > 0 {
+		r.SetContext(Options.Context)
+	}
+
+	r.SetCheckpoint(cp)
+
+	var profile *engine.ProfileT
+	if Options.ProfileRules {
+		profile = engine.NewProfile()
+		r.SetProfile(profile)
+	}
+
+	var coverage *engine.CoverageT
+	switch {
+	case !Options.Coverage:
+	case daemon:
+		log.Warn().Msg("Ignoring --coverage in daemon mode")
+	default:
+		coverage = engine.NewCoverage()
+		r.SetCoverage(coverage)
+	}
+
+	// A followed log never ends, so it cannot be merged with its siblings
+	if !Options.Follow {
+		r.SetParallel(parallelism(Options.Parallel))
+	}
+
+	if c.StatsPush.Endpoint != "" {
+		start := time.Now()
+		defer func() {
+			pushStats(c.StatsPush, r, report, start)
+		}()
+	}
+
 	if !Options.Quiet {
 		go func() {
 			pw.Render()
@@ -243,16 +1112,23 @@ func InitAndExecute(ctx context.Context) error {
 		}()
 	}
 
-	if err = r.Run(ctx, ruleMatchers, sources, report); err != nil {
+	if daemon {
+		go watchRules(ctx, r, report, rulesPaths, c, defaultConfigDir)
+	}
+
+	if err = session.Run(ctx, sources); err != nil {
 		log.Error().Err(err).Msg("Failed to run runtime")
 		ux.RulesError(err)
 		return err
 	}
 
-	if err = report.DisplayCREs(); err != nil {
-		log.Error().Err(err).Msg("Failed to display CREs")
-		ux.RulesError(err)
-		return err
+	// Detections were already displayed as they occurred in follow mode
+	if !Options.Follow {
+		if err = report.DisplayCREs(); err != nil {
+			log.Error().Err(err).Msg("Failed to display CREs")
+			ux.RulesError(err)
+			return err
+		}
 	}
 
 	pw.Stop()
@@ -272,10 +1148,40 @@ LOOP:
 		}
 	}
 
+	// Printed once progress rendering has stopped
+	if profile != nil {
+		profile.Fprint(os.Stderr)
+	}
+
+	if coverage != nil {
+		coverage.Fprint(os.Stderr)
+	}
+
+	if explain != nil {
+		explain.Fprint(os.Stderr, report)
+	}
+
+	if conflicts := r.Conflicts(); len(conflicts) > 0 && !Options.Quiet {
+		fmt.Fprintln(os.Stderr, "\nRule conflicts resolved by registry priority:")
+		for _, c := range conflicts {
+			fmt.Fprintf(os.Stderr, "  %s\n", c)
+		}
+	}
+
+	if deprecated := r.Deprecated(); len(deprecated) > 0 && !Options.Quiet {
+		fmt.Fprintln(os.Stderr, "\nDeprecated CREs detected:")
+		for _, d := range deprecated {
+			fmt.Fprintf(os.Stderr, "  %s\n", d)
+		}
+	}
+
+	if n := report.Spilled() + r.Spilled(); n > 0 && !Options.Quiet {
+		fmt.Fprintf(os.Stderr, "\nMemory limit reached: spilled %d MiB of detection hits and correlation state to disk\n", max(n>>20, 1))
+	}
+
 	switch {
 	case report.Size() == 0:
 		log.Debug().Msg("No CREs found")
-		return nil
 
 	case Options.Action != "":
 		log.Debug().Str("path", Options.Action).Msg("Running action")
@@ -312,5 +1218,238 @@ LOOP:
 		}
 	}
 
+	if Options.Policy != "" {
+		if err := evalPolicy(Options.Policy, report); err != nil {
+			return err
+		}
+	}
+
+	if failOn != nil {
+		return evalFailOn(*failOn, report)
+	}
+
+	return nil
+}
+
+// pushStats sends the run summary. Failures are logged and never affect
+// the outcome of the run.
+func pushStats(cfg config.StatsPush, r *engine.RuntimeT, report *ux.ReportT, start time.Time) {
+
+	var (
+		stats   = r.Stats()
+		summary = statz.NewSummary(start)
+		opts    []statz.OptT
+	)
+
+	summary.DurationMs = stats.Duration.Milliseconds()
+	summary.Sources = stats.Sources
+	summary.Rules = stats.Rules
+	summary.Lines = stats.Lines
+	summary.Collapsed = stats.Collapsed
+	summary.Dropped = stats.Dropped
+	summary.Spilled = report.Spilled() + r.Spilled()
+	summary.Detections = report.Size()
+
+	if ver, _, err := rules.GetCurrentRulesVersion(defaultConfigDir); err == nil && ver != nil {
+		summary.RulesVersion = ver.String()
+	}
+
+	if secret := cfg.TokenSecret(); !secret.IsZero() {
+		token, err := secret.Get()
+		if err != nil {
+			log.Warn().Err(err).Str("endpoint", cfg.Endpoint).Msg("Failed to push run stats")
+			return
+		}
+		opts = append(opts, statz.WithToken(token))
+	}
+	if cfg.Timeout > 0 {
+		opts = append(opts, statz.WithTimeout(cfg.Timeout))
+	}
+
+	// The run context may already be cancelled, e.g. on interrupt in follow mode
+	if err := statz.Push(context.Background(), cfg.Endpoint, summary, opts...); err != nil {
+		log.Warn().Err(err).Str("endpoint", cfg.Endpoint).Msg("Failed to push run stats")
+		return
+	}
+
+	log.Debug().Str("endpoint", cfg.Endpoint).Msg("Pushed run stats")
+}
+
+// evalPolicy applies the CI policy to the detections. A non-zero exit code
+// for the outcome is returned as an *ExitErrorT.
+func evalPolicy(fn string, report *ux.ReportT) error {
+
+	p, err := policy.ParseFile(fn)
+	if err != nil {
+		log.Error().Err(err).Str("path", fn).Msg("Failed to load policy")
+		ux.ConfigError(err)
+		return err
+	}
+
+	res := p.Evaluate(report.Detections())
+
+	if !Options.Quiet || res.Outcome != policy.OutcomePass {
+		res.Fprint(os.Stderr)
+	}
+
+	if code := p.ExitCode(res.Outcome); code != 0 {
+		return &ExitErrorT{
+			Code: code,
+			Err:  fmt.Errorf("policy outcome: %s", res.Outcome),
+		}
+	}
+
+	return nil
+}
+
+// evalFailOn fails the run with ExitDetections when any detection is at
+// least as severe as sev.
+func evalFailOn(sev uint, report *ux.ReportT) error {
+
+	var ids []string
+	for _, cre := range report.Detections() {
+		// Lower values are more severe
+		if cre.Severity <= sev {
+			ids = append(ids, cre.Id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("%d detections at or above %s: %s", len(ids), ux.SeverityName(sev), strings.Join(ids, ", "))
+	if !Options.Quiet {
+		fmt.Fprintf(os.Stderr, "\nFailing: %s\n", err)
+	}
+
+	return &ExitErrorT{Code: ExitDetections, Err: err}
+}
+
+// applyFlags lays the flags of the run over the config.
+func applyFlags(c *config.Config) error {
+
+	if Options.NoUpdateCheck {
+		c.NoUpdateCheck = true
+	}
+
+	if Options.AcceptUpdates {
+		c.AcceptUpdates = true
+	}
+
+	if Options.Disabled {
+		c.Rules.Disabled = true
+	}
+
+	if c.Skip == 0 {
+		c.Skip = timez.DefaultSkip
+	}
+
+	if Options.Offline {
+		return goOffline(c, Options.Rules)
+	}
+
 	return nil
 }
+
+// goOffline limits a run to the local rules of -r and the config, without
+// login, updates, registries to fetch or stats pushes. Any request left,
+// as of an action, fails.
+func goOffline(c *config.Config, cmdLineRules string) error {
+
+	if cmdLineRules == "" || rules.IsRulesUrl(cmdLineRules) {
+		return ErrOfflineRules
+	}
+
+	if len(c.MatcherPlugins) > 0 {
+		return ErrOfflinePlugins
+	}
+
+	httpz.SetOffline(true)
+
+	c.NoUpdateCheck = true
+	c.Rules.Disabled = true
+	c.Rules.Registries = slices.DeleteFunc(c.Rules.Registries, func(reg config.Registry) bool {
+		return reg.Url != ""
+	})
+	c.StatsPush.Endpoint = ""
+
+	log.Info().Str("rules", cmdLineRules).Msg("Offline; scanning with local rules only")
+
+	return nil
+}
+
+// authToken returns the token for rule updates: the API token of --token
+// or the environment if set, else the saved login, refreshed if through an
+// identity provider. Without a valid saved login, interactive logs in, and
+// otherwise fails.
+func authToken(ctx context.Context, interactive bool) (string, error) {
+
+	if apiToken := auth.ApiToken(Options.Token); apiToken != "" {
+		return auth.CheckApiToken(apiToken)
+	}
+
+	if !interactive {
+		token, err := refreshTokenFunc(ctx, baseAddr, ruleToken)
+		if err != nil {
+			return "", fmt.Errorf("%w; run preq rules update to log in, or set %s", err, auth.ApiTokenEnv)
+		}
+		return token, nil
+	}
+
+	return loginUserFunc(ctx, baseAddr, ruleToken)
+}
+
+// loadSuppressions reads the ignore file in the working directory and the
+// ignore section of the configuration.
+func loadSuppressions(c *config.Config) (*suppress.ListT, error) {
+
+	file, err := suppress.Load(suppress.DefaultFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", suppress.DefaultFile, err)
+	}
+
+	conf, err := suppress.New(c.Ignore)
+	if err != nil {
+		return nil, fmt.Errorf("config ignore: %w", err)
+	}
+
+	l := file.Merge(conf)
+	for _, r := range l.Expired(time.Now()) {
+		log.Warn().
+			Str("id", r.Id).
+			Str("expires", r.Expires).
+			Str("reason", r.Reason).
+			Msg("Suppression expired; its detections are reported again")
+	}
+
+	return l, nil
+}
+
+// requestedDeprecations returns the deprecations of the CREs the config
+// or the command line name explicitly, once each.
+func requestedDeprecations(r *engine.RuntimeT, c *config.Config) []deprecz.DeprecationT {
+
+	ids := slices.Concat(c.Rules.Only, c.Rules.Disable, []string{Options.Explain})
+	for _, rule := range c.Ignore {
+		ids = append(ids, rule.Id)
+	}
+
+	var (
+		out  []deprecz.DeprecationT
+		seen = make(map[string]struct{})
+	)
+	for _, id := range ids {
+		d, ok := r.Deprecation(id)
+		if !ok {
+			continue
+		}
+		if _, dup := seen[d.CreId]; dup {
+			continue
+		}
+		seen[d.CreId] = struct{}{}
+		out = append(out, d)
+	}
+
+	return out
+}
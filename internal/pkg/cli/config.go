@@ -0,0 +1,256 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/secretz"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http/httpproxy"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrConfigExists = errors.New("config file exists; --force overwrites it")
+	ErrNoSecret     = errors.New("no secret on stdin")
+)
+
+// loadConfig loads the config of the profile, with the project config and
+// the settings of its profile of the config over it, refusing keys that are
+// not settings unless --no-strict-config.
+func loadConfig() (*config.Config, error) {
+
+	c, err := config.LoadConfig(defaultConfigDir, configFile,
+		config.WithStrict(!Options.NoStrictConfig),
+		config.WithProfile(configProfile),
+		config.WithProject(projectConfig()),
+		config.WithProjectTrust(Options.TrustProject),
+	)
+	switch {
+	case errors.Is(err, config.ErrUnknownKey):
+		err = fmt.Errorf("%w\n--no-strict-config ignores unknown keys", err)
+	case errors.Is(err, config.ErrProjectTrust):
+		err = fmt.Errorf("%w\n--trust-project trusts it, --no-project-config ignores it", err)
+	}
+
+	return c, err
+}
+
+// projectConfig returns the .preq.yaml of the working directory or the
+// nearest one above it, unless --no-project-config.
+func projectConfig() string {
+
+	if Options.NoProjectConfig {
+		return ""
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+
+	return config.FindProject(wd)
+}
+
+// configInit writes the commented default config of the profile.
+func configInit() error {
+
+	fn := filepath.Join(defaultConfigDir, configFile)
+
+	if _, err := os.Stat(fn); err == nil && !Options.ConfigCmd.Init.Force {
+		err = fmt.Errorf("%w: %s", ErrConfigExists, fn)
+		ux.ConfigError(err)
+		return err
+	}
+
+	data, err := config.Template()
+	if err == nil {
+		err = writeConfig(fn, data)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("file", fn).Msg("Failed to write config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", fn)
+
+	return nil
+}
+
+// configGet prints the value of a setting as a run would use it.
+func configGet() error {
+
+	c, err := effectiveConfig()
+	if err != nil {
+		ux.ConfigError(err)
+		return err
+	}
+
+	value, err := config.Get(c, Options.ConfigCmd.Get.Key)
+	if err != nil {
+		ux.ConfigError(err)
+		return err
+	}
+
+	fmt.Fprintln(os.Stdout, value)
+
+	return nil
+}
+
+// configSet sets a setting in the config file of the profile, starting from
+// the default config if there is none.
+func configSet() error {
+
+	var (
+		opts = Options.ConfigCmd.Set
+		fn   = filepath.Join(defaultConfigDir, configFile)
+	)
+
+	data, err := os.ReadFile(fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = config.Template()
+	}
+	if err != nil {
+		log.Error().Err(err).Str("file", fn).Msg("Failed to read config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	if data, err = config.Set(data, opts.Key, opts.Value); err != nil {
+		ux.ConfigError(err)
+		return err
+	}
+
+	if err := writeConfig(fn, data); err != nil {
+		log.Error().Err(err).Str("file", fn).Msg("Failed to write config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	return nil
+}
+
+// configView prints the configuration a run uses.
+func configView() error {
+
+	c, err := effectiveConfig()
+	if err != nil {
+		ux.ConfigError(err)
+		return err
+	}
+
+	profile, _ := currentProfile()
+
+	return printConfig(os.Stdout, c, profile, filepath.Join(defaultConfigDir, configFile), projectConfig())
+}
+
+func printConfig(w io.Writer, c *config.Config, profile, fn, project string) error {
+
+	source := fn
+	if _, err := os.Stat(fn); err != nil {
+		source = "defaults, no " + fn
+	}
+
+	fmt.Fprintf(w, "# profile: %s\n# file: %s\n", profile, source)
+	if project != "" {
+		fmt.Fprintf(w, "# project: %s\n", project)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+// effectiveConfig loads the config of the profile with the environment and
+// flags of the run over it, as a run would, for printing.
+func effectiveConfig() (*config.Config, error) {
+
+	c, err := loadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load config")
+		return nil, err
+	}
+
+	if err := applyFlags(c); err != nil {
+		return nil, err
+	}
+
+	// Without a proxy of its own, a run uses that of the environment
+	if c.Proxy.Url == "" {
+		env := httpproxy.FromEnvironment()
+		c.Proxy.Url = env.HTTPSProxy
+		if c.Proxy.Url == "" {
+			c.Proxy.Url = env.HTTPProxy
+		}
+		if c.Proxy.Url != "" && env.NoProxy != "" {
+			c.Proxy.NoProxy = strings.Split(env.NoProxy, ",")
+		}
+	}
+
+	// Printed, so without the password a proxy url may hold
+	if u, err := url.Parse(c.Proxy.Url); err == nil && u.User != nil {
+		c.Proxy.Url = u.Redacted()
+	}
+
+	return c, nil
+}
+
+// configSecret stores the first line of rd in the OS keychain, for
+// !secret keychain:<name> references.
+func configSecret(rd io.Reader) error {
+
+	name := Options.ConfigCmd.Secret.Name
+
+	line, err := bufio.NewReader(rd).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Error().Err(err).Msg("Failed to read secret")
+		ux.ConfigError(err)
+		return err
+	}
+
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		ux.ConfigError(ErrNoSecret)
+		return ErrNoSecret
+	}
+
+	if err := secretz.Store(name, secret); err != nil {
+		log.Error().Err(err).Str("name", name).Msg("Failed to store secret")
+		ux.ConfigError(err)
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Stored %s; reference it as %s keychain:%s\n", name, secretz.Tag, name)
+
+	return nil
+}
+
+// writeConfig writes the config file aside and renames it, so a run never
+// reads it half written.
+func writeConfig(fn string, data []byte) error {
+
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+
+	tmp := fn + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fn)
+}
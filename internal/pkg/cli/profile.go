@@ -0,0 +1,281 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	profileEnv     = "PREQ_PROFILE"
+	profilesDir    = "profiles"
+	profileFile    = ".profile"
+	defaultProfile = "default"
+)
+
+var (
+	ErrProfileName = errors.New("profile names are letters, digits, '.', '_' and '-'")
+	ErrNoProfile   = errors.New("no such profile; create it with preq profile use, or under profiles in the config")
+	ErrProfileBoth = errors.New("profile is both a directory and a section of the config; rename one")
+
+	profileRe     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	baseConfigDir = defaultConfigDir
+	configProfile string // profile of the config file the run uses, if any
+)
+
+// selectProfile points the config directory, login and update stamp at the
+// profile of the run. A profile is either a directory under profiles in the
+// config directory, with a config, login and rules of its own, or a section
+// under profiles in the config file, laid over the rest of it.
+func selectProfile() error {
+
+	useConfigDir()
+
+	configProfile = ""
+
+	name, err := currentProfile()
+	if err != nil {
+		return err
+	}
+
+	dir, err := profileDir(name)
+	if err != nil {
+		return err
+	}
+
+	if name != defaultProfile {
+		isConfig, err := isConfigProfile(name, dir)
+		switch {
+		case err != nil:
+			return err
+		case isConfig:
+			configProfile, dir = name, baseConfigDir
+			log.Info().Str("profile", name).Msg("Using profile of config")
+		default:
+			log.Info().Str("profile", name).Str("dir", dir).Msg("Using profile")
+		}
+	}
+
+	defaultConfigDir = dir
+	ruleToken = filepath.Join(dir, ".ruletoken")
+	ruleUpdateFile = filepath.Join(dir, ".ruleupdate")
+
+	return nil
+}
+
+// useConfigDir makes the directory of --config-dir the config directory.
+func useConfigDir() {
+	if Options.ConfigDir != "" {
+		baseConfigDir = Options.ConfigDir
+	}
+}
+
+// isConfigProfile reports whether the profile of dir is a section of the
+// config file rather than the directory. It fails if it is neither, or both.
+func isConfigProfile(name, dir string) (bool, error) {
+
+	_, err := os.Stat(dir)
+	hasDir := !errors.Is(err, fs.ErrNotExist)
+
+	names, err := configProfiles()
+	switch {
+	case err != nil && hasDir:
+		// A directory profile does not depend on the default config
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	switch inConfig := slices.Contains(names, name); {
+	case hasDir && inConfig:
+		return false, fmt.Errorf("%w: %s", ErrProfileBoth, name)
+	case hasDir:
+		return false, nil
+	case !inConfig:
+		return false, fmt.Errorf("%w: %s", ErrNoProfile, name)
+	}
+
+	return true, nil
+}
+
+// configProfiles lists the profiles of the config file of the default
+// profile and of the project config, by name.
+func configProfiles() ([]string, error) {
+
+	c, err := config.LoadConfig(baseConfigDir, configFile, config.WithProject(projectConfig()), config.WithProjectTrust(Options.TrustProject))
+	if err != nil {
+		return nil, err
+	}
+
+	names := slices.Sorted(maps.Keys(c.Profiles))
+
+	return slices.DeleteFunc(names, func(name string) bool {
+		return name == defaultProfile || !profileRe.MatchString(name)
+	}), nil
+}
+
+// currentProfile returns the profile of --profile, PREQ_PROFILE or the one
+// saved, in that order.
+func currentProfile() (string, error) {
+
+	if Options.Profile != "" {
+		return Options.Profile, nil
+	}
+
+	if name := strings.TrimSpace(os.Getenv(profileEnv)); name != "" {
+		return name, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(baseConfigDir, profileFile))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return defaultProfile, nil
+	case err != nil:
+		return "", err
+	}
+
+	if name := strings.TrimSpace(string(data)); name != "" {
+		return name, nil
+	}
+
+	return defaultProfile, nil
+}
+
+func profileDir(name string) (string, error) {
+	if name == defaultProfile {
+		return baseConfigDir, nil
+	}
+	if !profileRe.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrProfileName, name)
+	}
+	return filepath.Join(baseConfigDir, profilesDir, name), nil
+}
+
+// profiles lists the default profile and those created, by name.
+func profiles() ([]string, error) {
+
+	names := []string{defaultProfile}
+
+	entries, err := os.ReadDir(filepath.Join(baseConfigDir, profilesDir))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() && profileRe.MatchString(e.Name()) && e.Name() != defaultProfile {
+			names = append(names, e.Name())
+		}
+	}
+
+	slices.Sort(names[1:])
+
+	return names, nil
+}
+
+// profileList prints the profiles, the current one marked.
+func profileList() error {
+
+	useConfigDir()
+
+	names, err := profiles()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list profiles")
+		ux.ConfigError(err)
+		return err
+	}
+
+	current, err := currentProfile()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read current profile")
+		ux.ConfigError(err)
+		return err
+	}
+
+	inConfig, err := configProfiles()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list profiles of config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	where := make(map[string]string)
+	for _, name := range names {
+		where[name], _ = profileDir(name)
+	}
+	for _, name := range inConfig {
+		if _, ok := where[name]; !ok {
+			names = append(names, name)
+			where[name] = fmt.Sprintf("%s, profiles.%s", filepath.Join(baseConfigDir, configFile), name)
+		}
+	}
+
+	for _, name := range names {
+		mark := " "
+		if name == current {
+			mark = "*"
+		}
+		fmt.Fprintf(os.Stdout, "%s %-20s %s\n", mark, name, where[name])
+	}
+
+	return nil
+}
+
+// profileUse saves the profile runs use by default, creating it if need be.
+func profileUse() error {
+
+	useConfigDir()
+
+	name := Options.ProfileCmd.Use.Name
+
+	dir, err := profileDir(name)
+	if err != nil {
+		ux.ConfigError(err)
+		return err
+	}
+
+	// A profile of the config is used as is; any other is created
+	isConfig := false
+	if name != defaultProfile {
+		if isConfig, err = isConfigProfile(name, dir); errors.Is(err, ErrProfileBoth) {
+			ux.ConfigError(err)
+			return err
+		}
+	}
+
+	if isConfig {
+		dir = fmt.Sprintf("%s, profiles.%s", filepath.Join(baseConfigDir, configFile), name)
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Error().Err(err).Str("dir", dir).Msg("Failed to create profile")
+		ux.ConfigError(err)
+		return err
+	}
+
+	fn := filepath.Join(baseConfigDir, profileFile)
+	if name == defaultProfile {
+		err = os.Remove(fn)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+	} else {
+		err = os.WriteFile(fn, []byte(name+"\n"), 0644)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save profile")
+		ux.ConfigError(err)
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Using profile %s, in %s\n", name, dir)
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
+	"github.com/rs/zerolog/log"
+)
+
+// The proxy section of the config routes the login, rule downloads and
+// updates through a corporate proxy:
+//
+//	proxy:
+//	  url: http://proxy.acme.internal:3128
+//	  username: svc-preq
+//	  passwordEnv: PROXY_PASSWORD
+//	  caFile: /etc/ssl/certs/acme-root.pem
+//	  noProxy: [localhost, .acme.internal]
+//
+// Without a url, HTTPS_PROXY, HTTP_PROXY and NO_PROXY still apply, and a
+// caFile alone trusts the CA of a proxy set there.
+
+var (
+	ErrProxy      = errors.New("proxy url must be http, https or socks5, with a host")
+	ErrProxyCreds = errors.New("proxy password environment variable is not set")
+	ErrProxyCa    = errors.New("no certificates in proxy caFile")
+)
+
+// setProxy applies the proxy of the config to the HTTP clients of the run.
+func setProxy(p config.Proxy) error {
+
+	if p.Url == "" && p.CaFile == "" {
+		return nil
+	}
+
+	var proc httpz.ProxyT
+
+	if p.Url != "" {
+		u, err := url.Parse(p.Url)
+		if err != nil || u.Host == "" {
+			return ErrProxy
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return ErrProxy
+		}
+
+		if p.Username != "" {
+			if secret := p.PasswordSecret(); secret.IsZero() {
+				u.User = url.User(p.Username)
+			} else if password, err := secret.Get(); err == nil {
+				u.User = url.UserPassword(p.Username, password)
+			} else {
+				return fmt.Errorf("%w: %w", ErrProxyCreds, err)
+			}
+		}
+
+		proc.Url = u
+		proc.NoProxy = strings.Join(p.NoProxy, ",")
+	}
+
+	if p.CaFile != "" {
+		pem, err := os.ReadFile(p.CaFile)
+		if err != nil {
+			return err
+		}
+		pool := httpz.RootCAs()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%w: %s", ErrProxyCa, p.CaFile)
+		}
+		proc.Roots = pool
+	}
+
+	httpz.SetProxy(proc)
+
+	ev := log.Info().Str("ca", p.CaFile)
+	if proc.Url != nil {
+		ev = ev.Str("proxy", proc.Url.Redacted())
+	}
+	ev.Msg("Using outbound proxy")
+
+	return nil
+}
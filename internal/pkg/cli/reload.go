@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/engine"
+	"github.com/prequel-dev/preq/internal/pkg/rules"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	reloadInterval = 5 * time.Second
+)
+
+// watchRules reloads the rules in daemon mode when a rule path changes, a
+// newer community rules package lands in the config directory or a remote
+// registry is updated on its cadence. It returns when ctx is done.
+func watchRules(ctx context.Context, r *engine.RuntimeT, report *ux.ReportT, paths []utils.RulePathT, conf *config.Config, configDir string) {
+
+	var (
+		ticker = time.NewTicker(reloadInterval)
+		stamp  = rulesStamp(paths)
+	)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		next := withRegistries(currentRulePaths(paths, configDir), rules.RegistryPaths(ctx, conf, configDir))
+		nextStamp := rulesStamp(next)
+		if nextStamp == stamp {
+			continue
+		}
+
+		log.Info().Any("paths", next).Msg("Rules changed; reloading")
+
+		// A rule file caught mid-write fails to compile; it is retried on
+		// the next change
+		stamp = nextStamp
+		if err := r.ReloadRulesPaths(report, next); err != nil {
+			log.Error().Err(err).Msg("Failed to reload rules; keeping previous rules")
+			continue
+		}
+		paths = next
+	}
+}
+
+// currentRulePaths swaps the community rules package in paths for the
+// newest one in configDir.
+func currentRulePaths(paths []utils.RulePathT, configDir string) []utils.RulePathT {
+
+	i := slices.IndexFunc(paths, func(rp utils.RulePathT) bool {
+		return rp.Type == utils.RuleTypeCre
+	})
+	if i < 0 {
+		return paths
+	}
+
+	_, path, err := rules.GetCurrentRulesVersion(configDir)
+	if err != nil || path == "" || path == paths[i].Path {
+		return paths
+	}
+
+	out := slices.Clone(paths)
+	out[i].Path = path
+	return out
+}
+
+// withRegistries adds the registry paths that paths lacks, such as those of
+// a remote registry first fetched since the rules were loaded.
+func withRegistries(paths, regs []utils.RulePathT) []utils.RulePathT {
+
+	out := paths
+	for _, rp := range regs {
+		if !slices.Contains(paths, rp) {
+			out = append(slices.Clip(out), rp)
+		}
+	}
+	return out
+}
+
+// rulesStamp summarizes the names, sizes and modification times of the
+// files under paths, so that a change to any of them changes the stamp.
+func rulesStamp(paths []utils.RulePathT) string {
+
+	var sb strings.Builder
+
+	for _, rp := range paths {
+		err := filepath.WalkDir(rp.Path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			fmt.Fprintf(&sb, "%s\x00%d\x00%d\n", path, info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(&sb, "%s\x00%v\n", rp.Path, err)
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,748 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/auth"
+	"github.com/prequel-dev/preq/internal/pkg/catalog"
+	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/engine"
+	"github.com/prequel-dev/preq/internal/pkg/pluginz"
+	"github.com/prequel-dev/preq/internal/pkg/rules"
+	"github.com/prequel-dev/preq/internal/pkg/ruletest"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/prequel-dev/preq/pkg/prefix"
+	"github.com/rs/zerolog/log"
+)
+
+const releaseCurrent = "current"
+
+// The rules subcommands work offline on the rules a scan would run: the
+// community rules package last downloaded to the config directory, the
+// -r rules and the rule paths of the config file.
+
+// localRulePaths returns the installed rule paths without checking for
+// updates.
+func localRulePaths() ([]utils.RulePathT, error) {
+
+	c, err := loadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load config")
+		ux.ConfigError(err)
+		return nil, err
+	}
+
+	var paths []utils.RulePathT
+
+	if !Options.Disabled && !c.Rules.Disabled {
+		if _, path, err := rules.GetCurrentRulesVersion(defaultConfigDir); err != nil {
+			log.Warn().Err(err).Msg("Failed to find community rules")
+		} else if path != "" {
+			paths = append(paths, utils.RulePathT{Path: path, Type: utils.RuleTypeCre, Registry: rules.CommunityRegistry})
+		}
+	}
+
+	if Options.Rules != "" {
+		paths = append(paths, utils.RulePathT{Path: Options.Rules, Type: utils.RuleTypeUser})
+	}
+
+	for _, path := range c.Rules.Paths {
+		paths = append(paths, utils.RulePathT{Path: path, Type: utils.RuleTypeUser})
+	}
+
+	paths = append(paths, rules.CachedRegistryPaths(c, defaultConfigDir)...)
+
+	return paths, nil
+}
+
+// loadCatalog reads the installed rules.
+func loadCatalog() (*catalog.CatalogT, error) {
+
+	paths, err := localRulePaths()
+	if err != nil {
+		return nil, err
+	}
+
+	cat, err := catalog.Load(paths)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load rules")
+		ux.RulesError(err)
+		return nil, err
+	}
+
+	return cat, nil
+}
+
+func rulesList() error {
+
+	cat, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+
+	return ux.PrintRules(os.Stdout, cat.Entries, Options.RulesCmd.List.Json)
+}
+
+func rulesShow() error {
+
+	cat, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+
+	id := Options.RulesCmd.Show.Id
+	entries, err := cat.Find(id)
+	if err != nil {
+		log.Error().Err(err).Str("id", id).Msg("Failed to find CRE")
+		ux.RulesError(err)
+		return err
+	}
+
+	for i, e := range entries {
+		if i > 0 {
+			fmt.Fprintln(os.Stdout, "\n---")
+		}
+		if err := ux.PrintRule(os.Stdout, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func rulesSearch() error {
+
+	var (
+		opts = Options.RulesCmd.Search
+		q    = catalog.QueryT{
+			Words:      opts.Words,
+			Technology: opts.Technology,
+			Category:   opts.Category,
+			Tags:       opts.Tag,
+		}
+	)
+
+	if opts.Severity != "" {
+		sev, err := ux.ParseSeverity(opts.Severity)
+		if err != nil {
+			log.Error().Err(err).Str("severity", opts.Severity).Msg("Invalid severity")
+			ux.DataError(err)
+			return err
+		}
+		q.MinSeverity = &sev
+	}
+
+	cat, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+
+	return ux.PrintRules(os.Stdout, cat.Search(q), opts.Json)
+}
+
+// authorRules returns the rules an author is working on: the paths given,
+// or else the -r rules and the rule paths of the config file.
+func authorRules(c *config.Config, args []string) ([]utils.RulePathT, error) {
+
+	var paths []utils.RulePathT
+	for _, path := range args {
+		paths = append(paths, utils.RulePathT{Path: path, Type: utils.RuleTypeUser})
+	}
+	if len(paths) == 0 {
+		if Options.Rules != "" {
+			paths = append(paths, utils.RulePathT{Path: Options.Rules, Type: utils.RuleTypeUser})
+		}
+		for _, path := range c.Rules.Paths {
+			paths = append(paths, utils.RulePathT{Path: path, Type: utils.RuleTypeUser})
+		}
+	}
+	if len(paths) == 0 {
+		ux.RulesError(ErrAuthorPaths)
+		return nil, ErrAuthorPaths
+	}
+
+	return paths, nil
+}
+
+// matcherPlugins starts the plugins of the config file, if any, for rules
+// that use their conditions.
+func matcherPlugins(c *config.Config) (*pluginz.HostT, error) {
+
+	if len(c.MatcherPlugins) == 0 {
+		return nil, nil
+	}
+
+	plugins, err := pluginz.New(c.MatcherPlugins)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid matcher plugins")
+		ux.ConfigError(err)
+		return nil, err
+	}
+
+	return plugins, nil
+}
+
+// rulesLint checks the rules an author is working on. It fails if any rule
+// has errors.
+func rulesLint() error {
+
+	c, err := loadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	paths, err := authorRules(c, Options.RulesCmd.Lint.Paths)
+	if err != nil {
+		return err
+	}
+
+	plugins, err := matcherPlugins(c)
+	if err != nil {
+		return err
+	}
+
+	r := engine.New(0, ux.NewUxEval())
+	defer r.Close()
+
+	if plugins != nil {
+		r.SetMatcherPlugins(plugins)
+	}
+
+	issues, err := r.Lint(paths)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to lint rules")
+		ux.RulesError(err)
+		return err
+	}
+
+	if err := printLint(os.Stdout, issues, Options.RulesCmd.Lint.Json); err != nil {
+		return err
+	}
+
+	if slices.ContainsFunc(issues, func(i engine.LintIssueT) bool { return i.Level == engine.LintError }) {
+		return ErrLintFailed
+	}
+
+	return nil
+}
+
+func printLint(w io.Writer, issues []engine.LintIssueT, asJson bool) error {
+
+	if asJson {
+		if issues == nil {
+			issues = []engine.LintIssueT{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(issues)
+	}
+
+	var nErr, nWarn int
+	for _, i := range issues {
+		fmt.Fprintln(w, i)
+		if i.Level == engine.LintError {
+			nErr++
+		} else {
+			nWarn++
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%d errors, %d warnings\n", nErr, nWarn)
+	return err
+}
+
+// rulesTest runs the fixtures of the rules an author is working on. It
+// fails if any rule fails its tests.
+func rulesTest(ctx context.Context) error {
+
+	c, err := loadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	paths, err := authorRules(c, Options.RulesCmd.Test.Paths)
+	if err != nil {
+		return err
+	}
+
+	opts := []ruletest.OptT{
+		ruletest.WithResolveOpts(tsOpts(c)...),
+	}
+
+	plugins, err := matcherPlugins(c)
+	if err != nil {
+		return err
+	}
+	if plugins != nil {
+		defer plugins.Close()
+		opts = append(opts, ruletest.WithMatcherPlugins(plugins))
+	}
+
+	sum, err := ruletest.Run(ctx, paths, opts...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to run rule tests")
+		ux.RulesError(err)
+		return err
+	}
+
+	if err := printRuleTests(os.Stdout, sum, Options.RulesCmd.Test.Json); err != nil {
+		return err
+	}
+
+	if sum.Failed > 0 {
+		return ErrRuleTests
+	}
+
+	return nil
+}
+
+func printRuleTests(w io.Writer, sum *ruletest.SummaryT, asJson bool) error {
+
+	if asJson {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sum)
+	}
+
+	for _, res := range sum.Results {
+		status := "PASS"
+		if !res.Pass() {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "%s  %s  %s (%d tests)\n", status, res.Cre, res.Path, res.Tests)
+		for _, f := range res.Failures {
+			fmt.Fprintf(w, "      %s\n", f)
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%d passed, %d failed, %d rule files without a fixture\n", sum.Passed, sum.Failed, len(sum.Untested))
+	return err
+}
+
+// rulesExport bundles the rules downloaded to the config directory, to be
+// imported where preq cannot reach the rule registries.
+func rulesExport() error {
+
+	opts := Options.RulesCmd.Export
+
+	c, err := loadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	f, err := os.Create(opts.Bundle)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create bundle")
+		return err
+	}
+
+	b, err := rules.ExportBundle(f, c, defaultConfigDir)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(opts.Bundle)
+		log.Error().Err(err).Msg("Failed to export rules")
+		ux.RulesError(err)
+		return err
+	}
+
+	return printBundle(os.Stdout, b, opts.Json)
+}
+
+// rulesImport installs the rules of a bundle made by rulesExport.
+func rulesImport() error {
+
+	opts := Options.RulesCmd.Import
+
+	c, err := loadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	f, err := os.Open(opts.Bundle)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to open bundle")
+		return err
+	}
+	defer f.Close()
+
+	b, err := rules.ImportBundle(f, c, defaultConfigDir)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to import rules")
+		ux.RulesError(err)
+		return err
+	}
+
+	return printBundle(os.Stdout, b, opts.Json)
+}
+
+func printBundle(w io.Writer, b *rules.BundleT, asJson bool) error {
+
+	if asJson {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(b)
+	}
+
+	for _, f := range b.Files {
+		ver := f.Version
+		if ver == "" {
+			ver = "-"
+		}
+		fmt.Fprintf(w, "%-12s  %-10s  %s  %s\n", f.Registry, ver, f.Sha256, f.Name)
+	}
+
+	_, err := fmt.Fprintf(w, "%d files, created %s by preq %s\n", len(b.Files), b.Created.Format(time.RFC3339), b.PreqVersion)
+	return err
+}
+
+// rulesUpdate checks for updates, or installs them, apart from a scan. A
+// check uses the saved login and never prompts.
+func rulesUpdate(ctx context.Context) error {
+
+	opts := Options.RulesCmd.Update
+
+	c, err := loadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	if err := rules.ValidateRegistries(c); err != nil {
+		log.Error().Err(err).Msg("Invalid rule registries")
+		ux.ConfigError(err)
+		return err
+	}
+
+	if err := setProxy(c.Proxy); err != nil {
+		log.Error().Err(err).Msg("Invalid proxy")
+		ux.ConfigError(err)
+		return err
+	}
+
+	if Options.AcceptUpdates {
+		c.AcceptUpdates = true
+	}
+
+	var (
+		token string
+		check *rules.UpdateCheckT
+	)
+
+	if token, err = authToken(ctx, !opts.Check); err != nil {
+		log.Error().Err(err).Msg("Failed to login")
+		if err != auth.ErrEmailNotVerified {
+			ux.AuthError(err)
+		}
+		return err
+	}
+
+	if opts.Check {
+		check, err = rules.CheckUpdates(ctx, c, defaultConfigDir, token, baseAddr, tlsPort)
+	} else {
+		check, err = rules.Update(ctx, c, defaultConfigDir, token, ruleUpdateFile, baseAddr, tlsPort)
+	}
+	if check != nil {
+		if perr := printUpdateCheck(os.Stdout, check, opts.Json); perr != nil {
+			return perr
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update rules")
+		ux.RulesError(err)
+		return err
+	}
+
+	return nil
+}
+
+func printUpdateCheck(w io.Writer, check *rules.UpdateCheckT, asJson bool) error {
+
+	if asJson {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(check)
+	}
+
+	status := func(v rules.VersionCheckT) string {
+		if v.Update {
+			return "update available"
+		}
+		return "up to date"
+	}
+
+	fmt.Fprintf(w, "%-12s  %-10s  %-10s  %s\n", "preq", check.Preq.Current, check.Preq.Latest, status(check.Preq))
+	fmt.Fprintf(w, "%-12s  %-10s  %-10s  %s\n", rules.CommunityRegistry, check.Rules.Current, check.Rules.Latest, status(check.Rules))
+
+	for _, reg := range check.Registries {
+		var (
+			checked = "never"
+			state   = "not due"
+		)
+		if reg.Checked != nil {
+			checked = reg.Checked.Format(time.RFC3339)
+		}
+		if reg.Due {
+			state = "due"
+		}
+		fmt.Fprintf(w, "%-12s  last tried %s  %s\n", reg.Name, checked, state)
+	}
+
+	return nil
+}
+
+func rulesDiff() error {
+
+	opts := Options.RulesCmd.Diff
+
+	load := func(release string) (*catalog.CatalogT, string, error) {
+		rp, err := releaseRulePath(release)
+		if err != nil {
+			log.Error().Err(err).Str("release", release).Msg("Failed to find rules")
+			ux.RulesError(err)
+			return nil, "", err
+		}
+		cat, err := catalog.Load([]utils.RulePathT{rp})
+		if err != nil {
+			log.Error().Err(err).Str("path", rp.Path).Msg("Failed to load rules")
+			ux.RulesError(err)
+			return nil, "", err
+		}
+		return cat, rp.Path, nil
+	}
+
+	older, oldPath, err := load(opts.Old)
+	if err != nil {
+		return err
+	}
+
+	newer, newPath, err := load(opts.New)
+	if err != nil {
+		return err
+	}
+
+	return ux.PrintRulesDiff(os.Stdout, older.Diff(newer), oldPath, newPath, opts.Json)
+}
+
+// releaseRulePath returns the rules of a release named on the command
+// line: a rules file or directory, current for the installed community
+// rules, or a version of the community rules in the config directory.
+func releaseRulePath(release string) (utils.RulePathT, error) {
+
+	if _, err := os.Stat(release); err == nil {
+		typ := utils.RuleTypeUser
+		if strings.HasPrefix(filepath.Base(release), prefix.PrequelPublicRulesPrefix) {
+			typ = utils.RuleTypeCre
+		}
+		return utils.RulePathT{Path: release, Type: typ}, nil
+	}
+
+	var (
+		path string
+		err  error
+	)
+
+	if release == releaseCurrent {
+		_, path, err = rules.GetCurrentRulesVersion(defaultConfigDir)
+	} else {
+		path, err = rules.ReleasePath(defaultConfigDir, release)
+	}
+	if err != nil {
+		return utils.RulePathT{}, err
+	}
+
+	return utils.RulePathT{Path: path, Type: utils.RuleTypeCre, Registry: rules.CommunityRegistry}, nil
+}
+
+func rulesCacheInfo() error {
+
+	opts := Options.RulesCmd.Cache.Info
+
+	c, err := loadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	cache, err := rules.CacheInfo(c, defaultConfigDir, opts.Keep)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read rules cache")
+		return err
+	}
+
+	if opts.Json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cache)
+	}
+
+	printCacheEntries(os.Stdout, cache.Entries)
+
+	_, err = fmt.Fprintf(os.Stdout, "%d entries, %s in %s\n", len(cache.Entries), byteSize(cache.Size), cache.Dir)
+	return err
+}
+
+func rulesCachePrune() error {
+
+	opts := Options.RulesCmd.Cache.Prune
+
+	c, err := loadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	removed, err := rules.PruneCache(c, defaultConfigDir, opts.Keep)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to prune rules cache")
+		return err
+	}
+
+	return printRemoved(os.Stdout, removed, opts.Json)
+}
+
+func rulesCacheClear() error {
+
+	opts := Options.RulesCmd.Cache.Clear
+
+	c, err := loadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load config")
+		ux.ConfigError(err)
+		return err
+	}
+
+	removed, err := rules.ClearCache(c, defaultConfigDir, ruleUpdateFile)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to clear rules cache")
+		return err
+	}
+
+	return printRemoved(os.Stdout, removed, opts.Json)
+}
+
+func printRemoved(w io.Writer, removed []rules.CacheEntryT, asJson bool) error {
+
+	if asJson {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(append([]rules.CacheEntryT{}, removed...))
+	}
+
+	printCacheEntries(w, removed)
+
+	var size int64
+	for _, e := range removed {
+		size += e.Size
+	}
+
+	_, err := fmt.Fprintf(w, "Removed %d entries, %s\n", len(removed), byteSize(size))
+	return err
+}
+
+func printCacheEntries(w io.Writer, entries []rules.CacheEntryT) {
+	for _, e := range entries {
+		var state []string
+		if e.InUse {
+			state = append(state, "in use")
+		}
+		if e.Stale {
+			state = append(state, "stale")
+		}
+		fmt.Fprintf(w, "%-8s  %-16s  %8s  %-12s  %s\n", e.Kind, e.Name, byteSize(e.Size), strings.Join(state, ","), e.Path)
+	}
+}
+
+// byteSize formats n bytes in the largest binary unit it fills.
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// rulesGet writes the rule of a CRE of the installed rules to a file of
+// its own, for a scan for just that problem.
+func rulesGet() error {
+
+	opts := Options.RulesCmd.Get
+
+	cat, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+
+	entries, err := cat.Find(opts.Id)
+	if err != nil {
+		err = fmt.Errorf("%w; run preq rules update for the latest rules", err)
+		log.Error().Err(err).Str("id", opts.Id).Msg("Failed to find CRE")
+		ux.RulesError(err)
+		return err
+	}
+
+	e := entries[0]
+	if len(entries) > 1 {
+		log.Warn().Str("id", opts.Id).Str("path", e.Path).Msg("CRE defined by several rule files; using the first")
+	}
+
+	data, err := catalog.Extract(e)
+	if err != nil {
+		log.Error().Err(err).Str("id", opts.Id).Msg("Failed to extract rule")
+		ux.RulesError(err)
+		return err
+	}
+
+	fn := opts.Output
+	switch {
+	case fn == "-":
+		_, err = os.Stdout.Write(data)
+		return err
+	case fn == "":
+		fn = e.Rule.Cre.Id + ".yaml"
+	}
+
+	if _, err := os.Stat(fn); err == nil && !opts.Force {
+		err = fmt.Errorf("%w: %s", ErrOutputExists, fn)
+		log.Error().Err(err).Msg("Failed to write rule")
+		ux.RulesError(err)
+		return err
+	}
+
+	if err := os.WriteFile(fn, data, 0644); err != nil {
+		log.Error().Err(err).Str("path", fn).Msg("Failed to write rule")
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %s to %s; scan for it with preq -r %s\n", e.Rule.Cre.Id, fn, fn)
+
+	return nil
+}
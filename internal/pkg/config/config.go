@@ -7,26 +7,168 @@ import (
 	"strings"
 	"time"
 
+	"github.com/prequel-dev/preq/internal/pkg/pluginz"
 	"github.com/prequel-dev/preq/internal/pkg/resolve"
+	"github.com/prequel-dev/preq/internal/pkg/secretz"
+	"github.com/prequel-dev/preq/internal/pkg/suppress"
 	"github.com/prequel-dev/prequel-logmatch/pkg/timez"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	TimestampRegexes []Regex        `yaml:"timestamps"`
-	Rules            Rules          `yaml:"rules"`
-	UpdateFrequency  *time.Duration `yaml:"updateFrequency"`
-	RulesVersion     string         `yaml:"rulesVersion"`
-	AcceptUpdates    bool           `yaml:"acceptUpdates"`
-	DataSources      string         `yaml:"dataSources"`
-	Window           time.Duration  `yaml:"window"`
-	Skip             int            `yaml:"skip"`
+	TimestampRegexes []Regex                  `yaml:"timestamps"`
+	Rules            Rules                    `yaml:"rules"`
+	UpdateFrequency  *time.Duration           `yaml:"updateFrequency"`
+	RulesVersion     string                   `yaml:"rulesVersion"`
+	AcceptUpdates    bool                     `yaml:"acceptUpdates"`
+	NoUpdateCheck    bool                     `yaml:"noUpdateCheck"`
+	DataSources      string                   `yaml:"dataSources"`
+	Window           time.Duration            `yaml:"window"`
+	SourceWindows    map[string]time.Duration `yaml:"sourceWindows"`
+	Skip             int                      `yaml:"skip"`
+	CaptureEnv       bool                     `yaml:"captureEnvironment"`
+	Downloads        Downloads                `yaml:"downloads"`
+	Verify           Verify                   `yaml:"verify"`
+	DecisionLog      DecisionLog              `yaml:"decisionLog"`
+	StatsPush        StatsPush                `yaml:"statsPush"`
+	Sso              Sso                      `yaml:"sso"`
+	Proxy            Proxy                    `yaml:"proxy"`
+	Ignore           []suppress.RuleT         `yaml:"ignore"`
+	MatcherPlugins   []pluginz.SpecT          `yaml:"matcherPlugins"`
+	Profiles         map[string]*Config       `yaml:"profiles"`
+	Include          []string                 `yaml:"include"`
 }
 
+// Rules are the rule sources of a scan. Only, if set, restricts a scan to
+// the rules of the listed CRE ids, and Disable drops the rules of the
+// listed ones, whatever source they come from. MapDeprecated reports the
+// detections of a deprecated CRE under the CRE that supersedes it.
 type Rules struct {
-	Paths    []string `yaml:"paths"`
-	Disabled bool     `yaml:"disableCommunityRules"`
+	Paths         []string   `yaml:"paths"`
+	Disabled      bool       `yaml:"disableCommunityRules"`
+	Registries    []Registry `yaml:"registries"`
+	Only          []string   `yaml:"only"`
+	Disable       []string   `yaml:"disable"`
+	MapDeprecated bool       `yaml:"mapDeprecated"`
+}
+
+// Registry is a source of rules besides the community rules: a rules file
+// served at Url, fetched into the config directory once UpdateFrequency
+// has passed, or local Paths. A registry named community with neither sets
+// the priority and update frequency of the community rules. Where two
+// registries define the same CRE the rule of the higher Priority is kept.
+type Registry struct {
+	Name            string         `yaml:"name"`
+	Url             string         `yaml:"url"`
+	Paths           []string       `yaml:"paths"`
+	Priority        int            `yaml:"priority"`
+	UpdateFrequency *time.Duration `yaml:"updateFrequency"`
+	Auth            RegistryAuth   `yaml:"auth"`
+	PublicKey       string         `yaml:"publicKey"` // cosign public key the rules must be signed with
+}
+
+// RegistryAuth authenticates to a private registry with a token, basic
+// auth or a client certificate. Secrets are read from the environment, as
+// named by TokenEnv and PasswordEnv, or from !secret references in Token
+// and Password, never from the config file itself. The token is sent as a
+// bearer token unless TokenHeader names the header to send it in.
+type RegistryAuth struct {
+	Token       secretz.SecretT `yaml:"token"`
+	TokenEnv    string          `yaml:"tokenEnv"`
+	TokenHeader string          `yaml:"tokenHeader"`
+	Username    string          `yaml:"username"`
+	Password    secretz.SecretT `yaml:"password"`
+	PasswordEnv string          `yaml:"passwordEnv"`
+	CertFile    string          `yaml:"certFile"`
+	KeyFile     string          `yaml:"keyFile"`
+	CaFile      string          `yaml:"caFile"` // trusts a private CA besides the system roots
+}
+
+// TokenSecret returns the token of Token, or else of TokenEnv.
+func (a RegistryAuth) TokenSecret() secretz.SecretT {
+	return orEnv(a.Token, a.TokenEnv)
+}
+
+// PasswordSecret returns the password of Password, or else of PasswordEnv.
+func (a RegistryAuth) PasswordSecret() secretz.SecretT {
+	return orEnv(a.Password, a.PasswordEnv)
+}
+
+// Downloads caps the size in bytes of remote artifacts. Zero uses the
+// built-in default; a negative value disables the cap.
+type Downloads struct {
+	MaxRulesSize  int64 `yaml:"maxRulesSize"`
+	MaxUpdateSize int64 `yaml:"maxUpdateSize"`
+}
+
+// Verify controls the signatures checked before downloads are installed.
+// Rule and binary updates from Prequel are always checked against the key
+// built into preq, whatever Require says. Remote registries are checked
+// against their PublicKey; with Require set, a remote registry without one
+// is a config error, as is a -r url without a sha256 pin.
+type Verify struct {
+	Require bool `yaml:"require"`
+}
+
+// DecisionLog opts in to exporting rule evaluations as gzip compressed
+// NDJSON for offline analysis. Disabled unless Path is set. Source names are
+// hashed with Salt; an empty salt picks a random one per run.
+type DecisionLog struct {
+	Path string `yaml:"path"`
+	Salt string `yaml:"salt"`
+}
+
+// StatsPush opts in to posting an anonymized run summary (durations, counts
+// and versions, never log content) to Endpoint after each run. Token, or
+// the environment variable TokenEnv, holds a bearer token.
+type StatsPush struct {
+	Endpoint string          `yaml:"endpoint"`
+	Token    secretz.SecretT `yaml:"token"`
+	TokenEnv string          `yaml:"tokenEnv"`
+	Timeout  time.Duration   `yaml:"timeout"`
+}
+
+// TokenSecret returns the token of Token, or else of TokenEnv.
+func (s StatsPush) TokenSecret() secretz.SecretT {
+	return orEnv(s.Token, s.TokenEnv)
+}
+
+// Sso logs in to rule updates through the identity provider at Issuer, an
+// OpenID Connect issuer supporting the device authorization grant, as the
+// public client ClientId. Scopes default to openid, email, profile and
+// offline_access, the last for a refresh token.
+type Sso struct {
+	Issuer   string   `yaml:"issuer"`
+	ClientId string   `yaml:"clientId"`
+	Scopes   []string `yaml:"scopes"`
+}
+
+// Proxy routes the login, rule downloads and updates through the outbound
+// proxy at Url, instead of the one of HTTPS_PROXY and HTTP_PROXY. Username,
+// with the password of Password or the environment variable PasswordEnv,
+// authenticates to it. CaFile trusts the CA of a proxy that inspects TLS,
+// besides the system roots. NoProxy lists the hosts reached directly, as
+// in NO_PROXY.
+type Proxy struct {
+	Url         string          `yaml:"url"`
+	Username    string          `yaml:"username"`
+	Password    secretz.SecretT `yaml:"password"`
+	PasswordEnv string          `yaml:"passwordEnv"`
+	CaFile      string          `yaml:"caFile"`
+	NoProxy     []string        `yaml:"noProxy"`
+}
+
+// PasswordSecret returns the password of Password, or else of PasswordEnv.
+func (p Proxy) PasswordSecret() secretz.SecretT {
+	return orEnv(p.Password, p.PasswordEnv)
+}
+
+func orEnv(secret secretz.SecretT, env string) secretz.SecretT {
+	if secret.IsZero() && env != "" {
+		return secretz.Ref("env:" + env)
+	}
+	return secret
 }
 
 type Regex struct {
@@ -37,7 +179,12 @@ type Regex struct {
 type OptT func(*optsT)
 
 type optsT struct {
-	window time.Duration
+	window  time.Duration
+	strict  bool
+	profile string
+	project string
+
+	trustProject bool
 }
 
 func WithWindow(window time.Duration) func(*optsT) {
@@ -46,6 +193,13 @@ func WithWindow(window time.Duration) func(*optsT) {
 	}
 }
 
+// WithStrict refuses config files with keys that are not settings.
+func WithStrict(strict bool) func(*optsT) {
+	return func(o *optsT) {
+		o.strict = strict
+	}
+}
+
 func parseOpts(opts ...OptT) *optsT {
 	o := &optsT{}
 	for _, opt := range opts {
@@ -59,38 +213,92 @@ func parseOpts(opts ...OptT) *optsT {
 
 func LoadConfig(dir, file string, opts ...OptT) (*Config, error) {
 
-	spec := filepath.Join(dir, file)
+	var (
+		o    = parseOpts(opts...)
+		spec = filepath.Join(dir, file)
+		root = &yaml.Node{}
+	)
+
 	_, err := os.Stat(spec)
 
 	switch {
-	case err == nil: // NOOP
+	case err == nil:
+		log.Info().Str("file", spec).Msg("Loading configuration file")
+		if root, err = readFile(spec, o); err != nil {
+			return nil, err
+		}
 	case os.IsNotExist(err):
 		log.Info().
 			Str("file", spec).
 			Msg("Configuration file does not exist, using default configuration")
-		return DefaultConfig(opts...), nil
+		if err := root.Encode(DefaultConfig(opts...)); err != nil {
+			return nil, err
+		}
 	default:
 		return nil, err
 	}
 
-	log.Info().Str("file", spec).Msg("Loading configuration file")
-	fh, err := os.OpenFile(spec, os.O_RDONLY, 0644)
+	if o.project != "" {
+		log.Info().Str("file", o.project).Msg("Loading project configuration file")
+		proj, err := readFile(o.project, o)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkProject(proj, o.project, o.trustProject); err != nil {
+			return nil, err
+		}
+		resolvePaths(proj, o.project)
+		mergeLists(root, proj)
+	}
+
+	return decodeConfig(root, spec, o)
+}
+
+func ReadConfig(rd io.Reader, opts ...OptT) (*Config, error) {
+	o := parseOpts(opts...)
+
+	data, err := io.ReadAll(rd)
 	if err != nil {
 		return nil, err
 	}
-	defer fh.Close()
 
-	return ReadConfig(fh)
+	root, err := readDoc(data, "", o, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeConfig(root, "", o)
 }
 
-func ReadConfig(rd io.Reader) (*Config, error) {
-	data, err := io.ReadAll(rd)
+// readFile reads the config file fn and those it includes, merged.
+func readFile(fn string, o *optsT) (*yaml.Node, error) {
+
+	data, err := os.ReadFile(fn)
 	if err != nil {
 		return nil, err
 	}
+
+	var stack []string
+	if abs, err := filepath.Abs(fn); err == nil {
+		stack = append(stack, abs)
+	}
+
+	return readDoc(data, fn, o, stack)
+}
+
+// decodeConfig decodes the merged settings of root, with those of the
+// profile over them.
+func decodeConfig(root *yaml.Node, file string, o *optsT) (*Config, error) {
+
+	if o.profile != "" {
+		if err := applyProfile(root, o.profile); err != nil {
+			return nil, err
+		}
+	}
+
 	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, err
+	if err := root.Decode(&config); err != nil {
+		return nil, fileErr(file, err)
 	}
 	return &config, nil
 }
@@ -123,6 +331,10 @@ func (c *Config) ResolveOpts() (opts []resolve.OptT) {
 		opts = append(opts, resolve.WithStampRegex(specs...))
 	}
 
+	if len(c.SourceWindows) > 0 {
+		opts = append(opts, resolve.WithSourceWindows(c.SourceWindows))
+	}
+
 	return
 
 }
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/secretz"
 )
 
 func TestLoadConfig_FileDoesNotExist(t *testing.T) {
@@ -33,6 +34,7 @@ func TestLoadConfig_FileExists(t *testing.T) {
 skip: 10
 dataSources: "test-source"
 acceptUpdates: true
+noUpdateCheck: true
 rulesVersion: "v1.0"
 timestamps:
   - pattern: "test-pattern"
@@ -41,6 +43,11 @@ rules:
   paths:
     - "/path/to/rules"
   disableCommunityRules: true
+  only:
+    - CRE-2025-0025
+  disable:
+    - CRE-2024-0001
+  mapDeprecated: true
 `
 	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
 		t.Fatalf("Failed to write config file: %v", err)
@@ -62,6 +69,15 @@ rules:
 	if !cfg.AcceptUpdates {
 		t.Fatalf("expected acceptUpdates true")
 	}
+	if !cfg.NoUpdateCheck {
+		t.Fatalf("expected noUpdateCheck true")
+	}
+	if len(cfg.Rules.Only) != 1 || cfg.Rules.Only[0] != "CRE-2025-0025" || len(cfg.Rules.Disable) != 1 || cfg.Rules.Disable[0] != "CRE-2024-0001" {
+		t.Fatalf("expected rules only and disable, got %v %v", cfg.Rules.Only, cfg.Rules.Disable)
+	}
+	if !cfg.Rules.MapDeprecated {
+		t.Fatalf("expected rules mapDeprecated true")
+	}
 	if cfg.RulesVersion != "v1.0" {
 		t.Fatalf("expected rulesVersion 'v1.0' got %v", cfg.RulesVersion)
 	}
@@ -240,3 +256,123 @@ func TestWithWindow(t *testing.T) {
 		t.Fatalf("expected window %v got %v", duration, cfg.Window)
 	}
 }
+
+func TestReadConfig_SourceWindows(t *testing.T) {
+	cfg, err := config.ReadConfig(strings.NewReader("sourceWindows:\n  cre.log.kafka: 30s\n"))
+	if err != nil {
+		t.Fatalf("ReadConfig error: %v", err)
+	}
+	if cfg.SourceWindows["cre.log.kafka"] != 30*time.Second {
+		t.Fatalf("expected kafka window 30s got %v", cfg.SourceWindows["cre.log.kafka"])
+	}
+	if len(cfg.ResolveOpts()) != 1 {
+		t.Fatalf("expected source windows resolve opt")
+	}
+}
+
+func TestReadConfig_Registries(t *testing.T) {
+	yaml := `rules:
+  registries:
+    - name: acme
+      url: https://rules.example.com/rules.yaml
+      auth:
+        tokenEnv: ACME_TOKEN
+        tokenHeader: X-Api-Key
+        certFile: /etc/preq/acme.crt
+        keyFile: /etc/preq/acme.key
+      priority: 10
+      updateFrequency: 1h
+      publicKey: /etc/preq/acme-cosign.pub
+    - name: team
+      paths:
+        - /etc/preq/team.yaml
+verify:
+  require: true
+`
+	cfg, err := config.ReadConfig(strings.NewReader(yaml))
+	if err != nil {
+		t.Fatalf("ReadConfig error: %v", err)
+	}
+	regs := cfg.Rules.Registries
+	if len(regs) != 2 {
+		t.Fatalf("expected 2 registries got %v", len(regs))
+	}
+	if regs[0].Url != "https://rules.example.com/rules.yaml" || regs[0].Priority != 10 {
+		t.Fatalf("unexpected registry %+v", regs[0])
+	}
+	if auth := regs[0].Auth; auth.TokenEnv != "ACME_TOKEN" || auth.TokenHeader != "X-Api-Key" || auth.CertFile != "/etc/preq/acme.crt" || auth.KeyFile != "/etc/preq/acme.key" {
+		t.Fatalf("unexpected registry %+v", regs[0])
+	}
+	if regs[0].PublicKey != "/etc/preq/acme-cosign.pub" || !cfg.Verify.Require {
+		t.Fatalf("expected signed registries, got %+v %+v", regs[0], cfg.Verify)
+	}
+	if regs[0].UpdateFrequency == nil || *regs[0].UpdateFrequency != time.Hour {
+		t.Fatalf("expected update frequency 1h got %v", regs[0].UpdateFrequency)
+	}
+	if len(regs[1].Paths) != 1 || regs[1].UpdateFrequency != nil {
+		t.Fatalf("unexpected registry %+v", regs[1])
+	}
+}
+
+func TestReadConfig_SsoAndProxy(t *testing.T) {
+	yaml := `sso:
+  issuer: https://login.example.com/realms/eng
+  clientId: preq
+  scopes: [openid, email, offline_access]
+proxy:
+  url: http://proxy.example.com:3128
+  username: svc-preq
+  passwordEnv: PROXY_PASSWORD
+  caFile: /etc/ssl/certs/acme-root.pem
+  noProxy: [localhost, .example.internal]
+`
+	cfg, err := config.ReadConfig(strings.NewReader(yaml))
+	if err != nil {
+		t.Fatalf("ReadConfig error: %v", err)
+	}
+	if cfg.Sso.Issuer != "https://login.example.com/realms/eng" || cfg.Sso.ClientId != "preq" || len(cfg.Sso.Scopes) != 3 {
+		t.Fatalf("unexpected sso %+v", cfg.Sso)
+	}
+	if p := cfg.Proxy; p.Url != "http://proxy.example.com:3128" || p.PasswordEnv != "PROXY_PASSWORD" || p.CaFile == "" || len(p.NoProxy) != 2 {
+		t.Fatalf("unexpected proxy %+v", p)
+	}
+}
+
+func TestReadConfig_Secrets(t *testing.T) {
+	yaml := `rules:
+  registries:
+    - name: acme
+      url: https://rules.example.com/rules.yaml
+      auth:
+        token: !secret keychain:acme-rules
+statsPush:
+  endpoint: https://stats.example.com/preq
+  tokenEnv: STATS_TOKEN
+proxy:
+  url: http://proxy.example.com:3128
+  username: svc-preq
+  password: !secret file:/run/secrets/proxy
+`
+	cfg, err := config.ReadConfig(strings.NewReader(yaml))
+	if err != nil {
+		t.Fatalf("ReadConfig error: %v", err)
+	}
+	if auth := cfg.Rules.Registries[0].Auth; auth.TokenSecret() != secretz.Ref("keychain:acme-rules") || !auth.PasswordSecret().IsZero() {
+		t.Fatalf("unexpected registry auth %+v", auth)
+	}
+	if s := cfg.StatsPush.TokenSecret(); s != secretz.Ref("env:STATS_TOKEN") {
+		t.Fatalf("expected the token of tokenEnv, got %v", s)
+	}
+	if s := cfg.Proxy.PasswordSecret(); s != secretz.Ref("file:/run/secrets/proxy") {
+		t.Fatalf("unexpected proxy password %v", s)
+	}
+
+	// Printed, secrets keep their references
+	token, err := config.Get(cfg, "rules.registries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(token, "token: !secret keychain:acme-rules") {
+		t.Errorf("expected the reference printed, got:\n%s", token)
+	}
+}
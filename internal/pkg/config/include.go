@@ -0,0 +1,199 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A config can include others, so that an org-wide config is shared and
+// each team lays its own settings over it:
+//
+//	include:
+//	  - /etc/preq/org.yaml
+//	  - team-registries.yaml   # relative to this config
+//	rules:
+//	  paths: [/etc/preq/team-rules.yaml]
+//
+// Included configs are merged in order, each over the ones before it, and
+// the including config over them all. Sections merge key by key, and
+// values replace those included. Lists, such as timestamps, rules.paths
+// and rules.registries, are joined: the entries of the including config
+// come first, so its timestamp formats are tried before those included,
+// then those of the includes, in reverse order, without repeats. Named
+// entries, such as registries, replace those of the same name included.
+// Includes may nest, but not loop.
+//
+// Relative rules.paths, registry paths and dataSources of an included
+// config are relative to it, as its includes are.
+
+var (
+	ErrIncludeLoop = errors.New("config includes itself")
+)
+
+// readDoc reads the config data of file and those it includes, merged,
+// as a mapping of settings.
+func readDoc(data []byte, file string, o *optsT, stack []string) (*yaml.Node, error) {
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fileErr(file, err)
+	}
+
+	if o.strict {
+		if err := errors.Join(checkKeys(&doc, reflect.TypeOf(Config{}), "", file)...); err != nil {
+			return nil, err
+		}
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+
+	// Type errors are reported for the file they are in
+	var c Config
+	if err := root.Decode(&c); err != nil {
+		return nil, fileErr(file, err)
+	}
+
+	if len(c.Include) == 0 {
+		return root, nil
+	}
+
+	var (
+		merged = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		incs   = child(root, "include")
+	)
+
+	for i, inc := range c.Include {
+
+		pos := incs
+		if incs.Kind == yaml.SequenceNode && i < len(incs.Content) {
+			pos = incs.Content[i]
+		}
+
+		path := inc
+		if !filepath.IsAbs(path) && file != "" {
+			path = filepath.Join(filepath.Dir(file), path)
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+
+		if slices.Contains(stack, path) {
+			return nil, fmt.Errorf("%s: %w: %s", position(file, pos), ErrIncludeLoop, path)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", position(file, pos), err)
+		}
+
+		sub, err := readDoc(data, path, o, append(stack, path))
+		if err != nil {
+			return nil, err
+		}
+		resolvePaths(sub, path)
+
+		mergeLists(merged, sub)
+	}
+
+	mergeLists(merged, root)
+
+	return merged, nil
+}
+
+// mergeLists lays the settings of src over those of dst as merge does,
+// but joins lists, those of src first.
+func mergeLists(dst, src *yaml.Node) {
+
+	for i := 0; i+1 < len(src.Content); i += 2 {
+
+		key, val := src.Content[i], src.Content[i+1]
+
+		d := child(dst, key.Value)
+		switch {
+		case d == nil:
+			dst.Content = append(dst.Content, key, val)
+		case d.Kind == yaml.MappingNode && val.Kind == yaml.MappingNode:
+			mergeLists(d, val)
+		case d.Kind == yaml.SequenceNode && val.Kind == yaml.SequenceNode:
+			joined := *val
+			joined.Content = append([]*yaml.Node{}, val.Content...)
+			for _, n := range d.Content {
+				if !containsNode(joined.Content, n) && !containsName(joined.Content, nameOf(n)) {
+					joined.Content = append(joined.Content, n)
+				}
+			}
+			*d = joined
+		default:
+			*d = *val
+		}
+	}
+}
+
+// nameOf returns the name of an entry of a list, if it has one.
+func nameOf(n *yaml.Node) string {
+	if name := child(n, "name"); name != nil && name.Kind == yaml.ScalarNode {
+		return name.Value
+	}
+	return ""
+}
+
+func containsName(nodes []*yaml.Node, name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, m := range nodes {
+		if nameOf(m) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsNode(nodes []*yaml.Node, n *yaml.Node) bool {
+	for _, m := range nodes {
+		if equalNodes(m, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalNodes reports whether a and b hold the same values, wherever they
+// were written.
+func equalNodes(a, b *yaml.Node) bool {
+
+	if a.Kind != b.Kind || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+
+	for i := range a.Content {
+		if !equalNodes(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func position(file string, n *yaml.Node) string {
+	if file == "" {
+		return fmt.Sprintf("line %d, column %d", n.Line, n.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", file, n.Line, n.Column)
+}
+
+func fileErr(file string, err error) error {
+	if file != "" {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return err
+}
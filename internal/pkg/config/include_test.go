@@ -0,0 +1,179 @@
+package config_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/config"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		fn := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadConfig_Include(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFiles(t, dir, map[string]string{
+		"org/org.yaml": `window: 2m
+timestamps:
+  - pattern: '^(\d{4}) '
+    format: "2006"
+rules:
+  paths: [/etc/preq/org-rules.yaml]
+  registries:
+    - name: org
+      url: https://rules.example.com/rules.yaml
+proxy:
+  url: http://proxy:3128
+  noProxy: [localhost]
+profiles:
+  staging:
+    window: 5m
+`,
+		"org/more.yaml": `rules:
+  paths: [/etc/preq/more-rules.yaml, /etc/preq/org-rules.yaml]
+`,
+		"config.yaml": `include:
+  - org/org.yaml
+  - org/more.yaml
+timestamps:
+  - pattern: '^(\d{2}) '
+    format: "06"
+rules:
+  paths: [/etc/preq/team-rules.yaml]
+proxy:
+  noProxy: [.team.internal]
+`,
+	})
+
+	cfg, err := config.LoadConfig(dir, "config.yaml", config.WithStrict(true))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+
+	if cfg.Window != 2*time.Minute || cfg.Proxy.Url != "http://proxy:3128" || len(cfg.Rules.Registries) != 1 {
+		t.Fatalf("expected the included settings, got %+v", cfg)
+	}
+	if len(cfg.TimestampRegexes) != 2 || cfg.TimestampRegexes[0].Format != "06" {
+		t.Fatalf("expected the timestamps of the config first, got %+v", cfg.TimestampRegexes)
+	}
+	paths := []string{"/etc/preq/team-rules.yaml", "/etc/preq/more-rules.yaml", "/etc/preq/org-rules.yaml"}
+	if !slices.Equal(cfg.Rules.Paths, paths) {
+		t.Fatalf("expected rule paths %v, got %v", paths, cfg.Rules.Paths)
+	}
+	if !slices.Equal(cfg.Proxy.NoProxy, []string{".team.internal", "localhost"}) {
+		t.Fatalf("expected the lists of sections joined, got %v", cfg.Proxy.NoProxy)
+	}
+
+	// Profiles of included configs apply too
+	cfg, err = config.LoadConfig(dir, "config.yaml", config.WithProfile("staging"))
+	if err != nil || cfg.Window != 5*time.Minute {
+		t.Fatalf("expected the included profile, got %+v (%v)", cfg, err)
+	}
+}
+
+func TestLoadConfig_IncludeNamesPaths(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFiles(t, dir, map[string]string{
+		"org/org.yaml": `dataSources: sources.yaml
+rules:
+  paths: [org-rules.yaml, /etc/preq/abs.yaml]
+  registries:
+    - name: acme
+      url: https://rules.example.com/org.yaml
+    - name: local
+      paths: [local.yaml]
+profiles:
+  staging:
+    rules:
+      paths: [staging.yaml]
+`,
+		"team/team.yaml": `rules:
+  registries:
+    - name: acme
+      url: https://rules.example.com/team.yaml
+`,
+		"config.yaml": `include: [org/org.yaml, team/team.yaml]
+rules:
+  paths: [team-rules.yaml]
+`,
+	})
+
+	cfg, err := config.LoadConfig(dir, "config.yaml")
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+
+	// A registry named again replaces the one included before
+	regs := cfg.Rules.Registries
+	if len(regs) != 2 || regs[0].Name != "acme" || regs[0].Url != "https://rules.example.com/team.yaml" || regs[1].Name != "local" {
+		t.Fatalf("expected the later acme registry, got %+v", regs)
+	}
+
+	org := filepath.Join(dir, "org")
+	if want := filepath.Join(org, "local.yaml"); !slices.Equal(regs[1].Paths, []string{want}) {
+		t.Errorf("expected registry paths relative to the include, got %v", regs[1].Paths)
+	}
+	if want := filepath.Join(org, "sources.yaml"); cfg.DataSources != want {
+		t.Errorf("expected %s, got %s", want, cfg.DataSources)
+	}
+	paths := []string{"team-rules.yaml", filepath.Join(org, "org-rules.yaml"), "/etc/preq/abs.yaml"}
+	if !slices.Equal(cfg.Rules.Paths, paths) {
+		t.Errorf("expected rule paths %v, got %v", paths, cfg.Rules.Paths)
+	}
+
+	cfg, err = config.LoadConfig(dir, "config.yaml", config.WithProfile("staging"))
+	if err != nil || !slices.Contains(cfg.Rules.Paths, filepath.Join(org, "staging.yaml")) {
+		t.Errorf("expected profile paths relative to the include, got %v (%v)", cfg.Rules.Paths, err)
+	}
+}
+
+func TestLoadConfig_IncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFiles(t, dir, map[string]string{
+		"loop.yaml":    "include: [loop2.yaml]\n",
+		"loop2.yaml":   "include: [loop.yaml]\n",
+		"missing.yaml": "window: 1m\ninclude:\n  - nope.yaml\n",
+		"strict.yaml":  "include: [bad.yaml]\n",
+		"bad.yaml":     "window: 1m\nwindw: 2m\n",
+		"type.yaml":    "include: [badtype.yaml]\n",
+		"badtype.yaml": "window: soon\n",
+	})
+
+	if _, err := config.LoadConfig(dir, "loop.yaml"); !errors.Is(err, config.ErrIncludeLoop) {
+		t.Errorf("expected %v, got %v", config.ErrIncludeLoop, err)
+	}
+
+	_, err := config.LoadConfig(dir, "missing.yaml")
+	if !errors.Is(err, fs.ErrNotExist) || !strings.HasPrefix(err.Error(), filepath.Join(dir, "missing.yaml")+":3:5: ") {
+		t.Errorf("expected the include missing at its position, got %v", err)
+	}
+
+	_, err = config.LoadConfig(dir, "strict.yaml", config.WithStrict(true))
+	if !errors.Is(err, config.ErrUnknownKey) || !strings.HasPrefix(err.Error(), filepath.Join(dir, "bad.yaml")+":2:1: ") {
+		t.Errorf("expected the unknown key in the included config, got %v", err)
+	}
+
+	_, err = config.LoadConfig(dir, "type.yaml")
+	if err == nil || !strings.HasPrefix(err.Error(), filepath.Join(dir, "badtype.yaml")+": ") {
+		t.Errorf("expected the invalid value in the included config, got %v", err)
+	}
+}
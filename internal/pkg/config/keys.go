@@ -0,0 +1,191 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Keys name settings by their path in the config file, dotted:
+//
+//	window
+//	proxy.url
+//	rules.disableCommunityRules
+//	profiles.staging.window
+//
+// Values are YAML, so lists and sections are set whole:
+//
+//	preq config set rules.disable '[CRE-2024-0007, CRE-2024-0008]'
+
+var (
+	ErrUnknownKey = errors.New("unknown config key")
+	ErrKeyValue   = errors.New("invalid config value")
+	ErrNotMapping = errors.New("config file is not a mapping of settings")
+)
+
+// Get returns the value of key in c: a scalar as is, a list or section as
+// YAML.
+func Get(c *Config, key string) (string, error) {
+
+	path, err := parseKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	var root yaml.Node
+	if err := root.Encode(c); err != nil {
+		return "", err
+	}
+
+	n := lookup(&root, path)
+	if n == nil {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKey, key)
+	}
+
+	if n.Kind == yaml.ScalarNode {
+		if n.Tag == "!!null" {
+			return "", nil
+		}
+		return n.Value, nil
+	}
+
+	data, err := yaml.Marshal(n)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Set sets key to value, read as YAML, in the config file data, keeping its
+// comments and creating the sections on the way. The result must still
+// load as a config.
+func Set(data []byte, key, value string) ([]byte, error) {
+
+	path, err := parseKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// The settings of a profile are those of the config
+	setting := path
+	if len(path) > 2 && path[0] == "profiles" {
+		setting = path[2:]
+	}
+
+	var known yaml.Node
+	if err := known.Encode(&Config{}); err != nil {
+		return nil, err
+	}
+	if lookup(&known, setting) == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, key)
+	}
+
+	val, err := parseValue(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrKeyValue, key, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	if doc.Kind == 0 {
+		doc.Kind = yaml.DocumentNode
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, ErrNotMapping
+	}
+
+	n := doc.Content[0]
+	for i, name := range path {
+
+		next := child(n, name)
+		if next == nil {
+			next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			n.Content = append(n.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name},
+				next,
+			)
+		}
+
+		if i == len(path)-1 {
+			val.LineComment = next.LineComment
+			*next = *val
+			break
+		}
+
+		// A section left empty, such as "proxy:", is null
+		if next.Kind != yaml.MappingNode {
+			*next = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", LineComment: next.LineComment}
+		}
+		n = next
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	if _, err := ReadConfig(bytes.NewReader(buf.Bytes())); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrKeyValue, key, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func parseKey(key string) ([]string, error) {
+	path := strings.Split(key, ".")
+	for _, name := range path {
+		if name == "" {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownKey, key)
+		}
+	}
+	return path, nil
+}
+
+// parseValue reads value as YAML; an empty value is an empty string.
+func parseValue(value string) (*yaml.Node, error) {
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}, nil
+	}
+
+	return doc.Content[0], nil
+}
+
+func lookup(n *yaml.Node, path []string) *yaml.Node {
+	for _, name := range path {
+		if n = child(n, name); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+func child(n *yaml.Node, name string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == name {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package config_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/config"
+)
+
+func TestTemplate(t *testing.T) {
+	data, err := config.Template()
+	if err != nil {
+		t.Fatalf("Template error: %v", err)
+	}
+	cfg, err := config.ReadConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadConfig error: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, config.DefaultConfig()) {
+		t.Fatalf("expected the template to load as the defaults, got %+v", cfg)
+	}
+	if !bytes.Contains(data, []byte("# proxy:")) {
+		t.Fatalf("expected commented settings in the template")
+	}
+}
+
+func TestGet(t *testing.T) {
+	window := 2 * time.Minute
+	cfg := &config.Config{Window: window, Proxy: config.Proxy{Url: "http://proxy:3128"}}
+	cfg.Rules.Disable = []string{"CRE-2024-0007"}
+
+	tests := map[string]string{
+		"window":         "2m0s",
+		"proxy.url":      "http://proxy:3128",
+		"proxy.username": "",
+		"rules.disable":  "- CRE-2024-0007",
+		"sso.scopes":     "[]",
+	}
+	for key, want := range tests {
+		if got, err := config.Get(cfg, key); err != nil || got != want {
+			t.Errorf("%s: expected %q, got %q (%v)", key, want, got, err)
+		}
+	}
+
+	for _, key := range []string{"nope", "proxy.nope", "proxy..url", "window.x"} {
+		if _, err := config.Get(cfg, key); !errors.Is(err, config.ErrUnknownKey) {
+			t.Errorf("%s: expected %v, got %v", key, config.ErrUnknownKey, err)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	data := []byte(`# our config
+window: 1m # kept short
+proxy:
+`)
+
+	data, err := config.Set(data, "window", "5m")
+	if err != nil {
+		t.Fatalf("Set error: %v", err)
+	}
+	if data, err = config.Set(data, "proxy.url", "http://proxy:3128"); err != nil {
+		t.Fatalf("Set error: %v", err)
+	}
+	if data, err = config.Set(data, "rules.disable", "[CRE-2024-0007, CRE-2024-0008]"); err != nil {
+		t.Fatalf("Set error: %v", err)
+	}
+
+	s := string(data)
+	if !strings.Contains(s, "# our config") || !strings.Contains(s, "# kept short") {
+		t.Errorf("expected the comments kept, got\n%s", s)
+	}
+
+	cfg, err := config.ReadConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadConfig error: %v", err)
+	}
+	if cfg.Window != 5*time.Minute || cfg.Proxy.Url != "http://proxy:3128" || len(cfg.Rules.Disable) != 2 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	if _, err := config.Set(data, "proxy.nope", "x"); !errors.Is(err, config.ErrUnknownKey) {
+		t.Errorf("expected %v, got %v", config.ErrUnknownKey, err)
+	}
+	if _, err := config.Set(data, "window", "soon"); !errors.Is(err, config.ErrKeyValue) {
+		t.Errorf("expected %v, got %v", config.ErrKeyValue, err)
+	}
+	if _, err := config.Set([]byte("- a\n"), "window", "1m"); !errors.Is(err, config.ErrNotMapping) {
+		t.Errorf("expected %v, got %v", config.ErrNotMapping, err)
+	}
+
+	// A new file
+	if data, err = config.Set(nil, "sso.clientId", "preq"); err != nil || string(data) != "sso:\n  clientId: preq\n" {
+		t.Errorf("unexpected new config %q (%v)", data, err)
+	}
+
+	// A secret reference is kept as written
+	if data, err = config.Set(nil, "proxy.password", "!secret keychain:proxy"); err != nil || string(data) != "proxy:\n  password: !secret keychain:proxy\n" {
+		t.Errorf("unexpected secret config %q (%v)", data, err)
+	}
+}
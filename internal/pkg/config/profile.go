@@ -0,0 +1,71 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A config can hold profiles, each settings laid over the rest of the
+// config for the runs that select it, as with --profile staging:
+//
+//	dataSources: /etc/preq/prod-sources.yaml
+//	window: 2m
+//	profiles:
+//	  staging:
+//	    dataSources: /etc/preq/staging-sources.yaml
+//	    rules:
+//	      disable: [CRE-2024-0007]
+//
+// Sections merge key by key; lists and values replace those of the config.
+
+var (
+	ErrNoProfile = errors.New("no such profile in config")
+)
+
+// WithProfile lays the settings of the named profile of the config over
+// the rest of it.
+func WithProfile(name string) func(*optsT) {
+	return func(o *optsT) {
+		o.profile = name
+	}
+}
+
+func applyProfile(root *yaml.Node, name string) error {
+
+	var prof *yaml.Node
+	if profiles := child(root, "profiles"); profiles != nil {
+		prof = child(profiles, name)
+	}
+
+	if prof == nil {
+		return fmt.Errorf("%w: %s", ErrNoProfile, name)
+	}
+
+	// A profile left empty changes nothing
+	if prof.Kind == yaml.MappingNode {
+		merge(root, prof)
+	}
+
+	return nil
+}
+
+// merge lays the settings of src over those of dst.
+func merge(dst, src *yaml.Node) {
+
+	for i := 0; i+1 < len(src.Content); i += 2 {
+
+		key, val := src.Content[i], src.Content[i+1]
+
+		d := child(dst, key.Value)
+		switch {
+		case d == nil:
+			dst.Content = append(dst.Content, key, val)
+		case d.Kind == yaml.MappingNode && val.Kind == yaml.MappingNode:
+			merge(d, val)
+		default:
+			*d = *val
+		}
+	}
+}
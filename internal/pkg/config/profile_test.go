@@ -0,0 +1,75 @@
+package config_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/config"
+)
+
+const withProfiles = `dataSources: /etc/preq/prod-sources.yaml
+window: 2m
+rules:
+  disable: [CRE-2024-0007]
+  mapDeprecated: true
+profiles:
+  staging:
+    dataSources: /etc/preq/staging-sources.yaml
+    rules:
+      disable: [CRE-2024-0008, CRE-2024-0009]
+  quiet:
+`
+
+func TestReadConfig_Profile(t *testing.T) {
+
+	cfg, err := config.ReadConfig(strings.NewReader(withProfiles), config.WithProfile("staging"))
+	if err != nil {
+		t.Fatalf("ReadConfig error: %v", err)
+	}
+	if cfg.DataSources != "/etc/preq/staging-sources.yaml" || cfg.Window != 2*time.Minute {
+		t.Fatalf("expected the profile over the config, got %+v", cfg)
+	}
+	if len(cfg.Rules.Disable) != 2 || !cfg.Rules.MapDeprecated {
+		t.Fatalf("expected the rules section merged, got %+v", cfg.Rules)
+	}
+
+	cfg, err = config.ReadConfig(strings.NewReader(withProfiles), config.WithProfile("quiet"))
+	if err != nil || cfg.DataSources != "/etc/preq/prod-sources.yaml" || len(cfg.Rules.Disable) != 1 {
+		t.Fatalf("expected an empty profile to change nothing, got %+v (%v)", cfg, err)
+	}
+
+	cfg, err = config.ReadConfig(strings.NewReader(withProfiles))
+	if err != nil || cfg.DataSources != "/etc/preq/prod-sources.yaml" || len(cfg.Profiles) != 2 {
+		t.Fatalf("expected the config without a profile, got %+v (%v)", cfg, err)
+	}
+
+	if _, err := config.ReadConfig(strings.NewReader(withProfiles), config.WithProfile("prod")); !errors.Is(err, config.ErrNoProfile) {
+		t.Fatalf("expected %v, got %v", config.ErrNoProfile, err)
+	}
+	if _, err := config.LoadConfig(t.TempDir(), "config.yaml", config.WithProfile("staging")); !errors.Is(err, config.ErrNoProfile) {
+		t.Fatalf("expected %v, got %v", config.ErrNoProfile, err)
+	}
+
+	// Profiles hold settings only
+	bad := "profiles:\n  staging:\n    windw: 1m\n"
+	_, err = config.ReadConfig(strings.NewReader(bad), config.WithStrict(true))
+	if err == nil || !strings.Contains(err.Error(), `"profiles.staging.windw"; did you mean "profiles.staging.window"?`) {
+		t.Fatalf("expected an unknown key in the profile, got %v", err)
+	}
+}
+
+func TestSet_Profile(t *testing.T) {
+	data, err := config.Set([]byte(withProfiles), "profiles.staging.window", "5m")
+	if err != nil {
+		t.Fatalf("Set error: %v", err)
+	}
+	cfg, err := config.ReadConfig(strings.NewReader(string(data)), config.WithProfile("staging"))
+	if err != nil || cfg.Window != 5*time.Minute {
+		t.Fatalf("expected the window of the profile set, got %+v (%v)", cfg, err)
+	}
+	if _, err := config.Set(data, "profiles.staging.windw", "5m"); !errors.Is(err, config.ErrUnknownKey) {
+		t.Fatalf("expected %v, got %v", config.ErrUnknownKey, err)
+	}
+}
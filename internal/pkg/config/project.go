@@ -0,0 +1,153 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A repository can check in a project config, .preq.yaml, with the data
+// sources and rules its team scans with. It is found in the working
+// directory or the nearest one above it, and laid over the user config as
+// the user config is over those it includes. Its relative paths, of rules
+// and data sources, are relative to it, so they hold from any directory of
+// the repository.
+//
+// A project config comes with the code it is checked in with, which may
+// not be trusted, so it cannot set what runs programs or sends data and
+// secrets elsewhere, nor the signatures downloads are checked with; those
+// settings stay in the user config. Its data sources, which may run
+// commands or read any file, are only read when the user trusts it.
+
+var (
+	ProjectFiles = []string{".preq.yaml", ".preq.yml"}
+
+	ErrProjectSetting = errors.New("setting is not allowed in a project config; set it in the user config")
+	ErrProjectTrust   = errors.New("setting of a project config is only read when the project config is trusted")
+
+	// Settings a project config cannot set, also within its profiles
+	projectRefused = []string{"matcherPlugins", "statsPush", "decisionLog", "proxy", "sso", "verify"}
+
+	// Settings a project config can only set when trusted
+	projectTrusted = []string{"dataSources"}
+)
+
+// WithProject lays the project config fn over the config.
+func WithProject(fn string) func(*optsT) {
+	return func(o *optsT) {
+		o.project = fn
+	}
+}
+
+// WithProjectTrust lets the project config set the settings that need
+// trust, such as its data sources.
+func WithProjectTrust(trust bool) func(*optsT) {
+	return func(o *optsT) {
+		o.trustProject = trust
+	}
+}
+
+// FindProject returns the project config of dir or the nearest directory
+// above it, or "" if there is none.
+func FindProject(dir string) string {
+
+	for {
+		for _, name := range ProjectFiles {
+			fn := filepath.Join(dir, name)
+			if fi, err := os.Stat(fn); err == nil && fi.Mode().IsRegular() {
+				return fn
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// resolvePaths makes the relative paths of the project or included config
+// at file relative to its directory.
+func resolvePaths(root *yaml.Node, file string) {
+
+	dir := filepath.Dir(file)
+
+	resolve := func(n *yaml.Node) {
+		if n != nil && n.Kind == yaml.ScalarNode && n.Value != "" && !filepath.IsAbs(n.Value) && !strings.Contains(n.Value, "://") {
+			n.Value = filepath.Join(dir, n.Value)
+		}
+	}
+
+	resolveAll := func(n *yaml.Node) {
+		if n != nil && n.Kind == yaml.SequenceNode {
+			for _, c := range n.Content {
+				resolve(c)
+			}
+		}
+	}
+
+	fix := func(n *yaml.Node) {
+		resolve(child(n, "dataSources"))
+		resolveAll(lookup(n, []string{"rules", "paths"}))
+		if regs := lookup(n, []string{"rules", "registries"}); regs != nil {
+			for _, reg := range regs.Content {
+				resolveAll(child(reg, "paths"))
+			}
+		}
+	}
+
+	fix(root)
+
+	if profiles := child(root, "profiles"); profiles != nil && profiles.Kind == yaml.MappingNode {
+		for i := 1; i < len(profiles.Content); i += 2 {
+			fix(profiles.Content[i])
+		}
+	}
+}
+
+// checkProject refuses the settings of a project config that are not its
+// to set, and unless trusted those that need trust.
+func checkProject(root *yaml.Node, file string, trusted bool) error {
+
+	var errs []error
+
+	check := func(n *yaml.Node, prefix string) {
+
+		for _, name := range projectRefused {
+			if child(n, name) != nil {
+				errs = append(errs, fmt.Errorf("%s: %w: %s", file, ErrProjectSetting, join(prefix, name)))
+			}
+		}
+
+		for _, name := range projectTrusted {
+			if !trusted && child(n, name) != nil {
+				errs = append(errs, fmt.Errorf("%s: %w: %s", file, ErrProjectTrust, join(prefix, name)))
+			}
+		}
+
+		// Registry credentials, which a registry of the project's choosing
+		// would receive
+		if regs := lookup(n, []string{"rules", "registries"}); regs != nil {
+			for i, reg := range regs.Content {
+				if child(reg, "auth") != nil {
+					errs = append(errs, fmt.Errorf("%s: %w: %s", file, ErrProjectSetting, join(prefix, fmt.Sprintf("rules.registries[%d].auth", i))))
+				}
+			}
+		}
+	}
+
+	check(root, "")
+
+	if profiles := child(root, "profiles"); profiles != nil && profiles.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(profiles.Content); i += 2 {
+			check(profiles.Content[i+1], "profiles."+profiles.Content[i].Value)
+		}
+	}
+
+	return errors.Join(errs...)
+}
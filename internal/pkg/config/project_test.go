@@ -0,0 +1,118 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/config"
+)
+
+func TestFindProject(t *testing.T) {
+	root := t.TempDir()
+	deep := filepath.Join(root, "repo", "svc", "api")
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if fn := config.FindProject(deep); fn != "" && strings.HasPrefix(fn, root) {
+		t.Fatalf("expected no project config, got %s", fn)
+	}
+
+	writeFiles(t, root, map[string]string{"repo/.preq.yml": "window: 1m\n"})
+	if fn := config.FindProject(deep); fn != filepath.Join(root, "repo", ".preq.yml") {
+		t.Fatalf("expected the project config above, got %s", fn)
+	}
+
+	writeFiles(t, root, map[string]string{"repo/svc/.preq.yaml": "window: 1m\n"})
+	if fn := config.FindProject(deep); fn != filepath.Join(root, "repo", "svc", ".preq.yaml") {
+		t.Fatalf("expected the nearest project config, got %s", fn)
+	}
+}
+
+func TestLoadConfig_Project(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFiles(t, dir, map[string]string{
+		"user/config.yaml": `window: 2m
+rules:
+  paths: [/etc/preq/my-rules.yaml]
+proxy:
+  url: http://proxy:3128
+`,
+		"repo/.preq.yaml": `include: [preq/sources.yaml]
+rules:
+  paths: [rules/team.yaml]
+profiles:
+  ci:
+    window: 10m
+`,
+		"repo/preq/sources.yaml": "dataSources: /srv/app/sources.yaml\n",
+	})
+
+	project := filepath.Join(dir, "repo", ".preq.yaml")
+
+	cfg, err := config.LoadConfig(filepath.Join(dir, "user"), "config.yaml", config.WithProject(project), config.WithProjectTrust(true), config.WithStrict(true))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if cfg.Window != 2*time.Minute || cfg.Proxy.Url != "http://proxy:3128" || cfg.DataSources != "/srv/app/sources.yaml" {
+		t.Fatalf("expected the project over the user config, got %+v", cfg)
+	}
+	if !slices.Equal(cfg.Rules.Paths, []string{filepath.Join(dir, "repo", "rules", "team.yaml"), "/etc/preq/my-rules.yaml"}) {
+		t.Fatalf("expected the rule paths joined, got %v", cfg.Rules.Paths)
+	}
+
+	cfg, err = config.LoadConfig(filepath.Join(dir, "user"), "config.yaml", config.WithProject(project), config.WithProjectTrust(true), config.WithProfile("ci"))
+	if err != nil || cfg.Window != 10*time.Minute {
+		t.Fatalf("expected the profile of the project, got %+v (%v)", cfg, err)
+	}
+
+	// Without a user config, over the defaults
+	cfg, err = config.LoadConfig(filepath.Join(dir, "none"), "config.yaml", config.WithProject(project), config.WithProjectTrust(true))
+	if err != nil || cfg.DataSources != "/srv/app/sources.yaml" || len(cfg.TimestampRegexes) == 0 {
+		t.Fatalf("expected the project over the defaults, got %+v (%v)", cfg, err)
+	}
+
+	// Its data sources are only read when it is trusted
+	if _, err = config.LoadConfig(filepath.Join(dir, "user"), "config.yaml", config.WithProject(project)); !errors.Is(err, config.ErrProjectTrust) {
+		t.Fatalf("expected %v, got %v", config.ErrProjectTrust, err)
+	}
+}
+
+func TestLoadConfig_ProjectRefused(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFiles(t, dir, map[string]string{
+		".preq.yaml": `matcherPlugins:
+  - name: x
+    command: /tmp/x
+rules:
+  registries:
+    - name: evil
+      url: https://rules.example.com/rules.yaml
+      auth:
+        tokenEnv: AWS_SECRET_ACCESS_KEY
+verify:
+  require: false
+profiles:
+  ci:
+    statsPush:
+      endpoint: https://stats.example.com
+`,
+	})
+
+	_, err := config.LoadConfig(filepath.Join(dir, "none"), "config.yaml", config.WithProject(filepath.Join(dir, ".preq.yaml")))
+	if !errors.Is(err, config.ErrProjectSetting) {
+		t.Fatalf("expected %v, got %v", config.ErrProjectSetting, err)
+	}
+	for _, key := range []string{": matcherPlugins", ": rules.registries[0].auth", ": verify", ": profiles.ci.statsPush"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("expected %s refused, got %v", key, err)
+		}
+	}
+}
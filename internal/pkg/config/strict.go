@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A strict config refuses keys that are not settings, which would be
+// ignored otherwise, so a misspelled setting is never silently off. Each is
+// reported at its line and column, with the setting it is closest to:
+//
+//	config.yaml:4:3: unknown config key "rules.disabled"; did you mean "rules.disable"?
+
+var (
+	unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+)
+
+// checkKeys reports the keys of n that are not fields of t.
+func checkKeys(n *yaml.Node, t reflect.Type, path, file string) (errs []error) {
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return nil
+	}
+
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			errs = append(errs, checkKeys(c, t, path, file)...)
+		}
+
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return nil
+		}
+		for i, c := range n.Content {
+			errs = append(errs, checkKeys(c, t.Elem(), fmt.Sprintf("%s[%d]", path, i), file)...)
+		}
+
+	case yaml.MappingNode:
+		switch t.Kind() {
+		case reflect.Map:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				errs = append(errs, checkKeys(n.Content[i+1], t.Elem(), join(path, n.Content[i].Value), file)...)
+			}
+
+		case reflect.Struct:
+			fields := yamlFields(t)
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				key := n.Content[i]
+				ft, ok := fields[key.Value]
+				if !ok {
+					errs = append(errs, unknownKey(key, path, file, fields))
+					continue
+				}
+				errs = append(errs, checkKeys(n.Content[i+1], ft, join(path, key.Value), file)...)
+			}
+		}
+	}
+
+	return errs
+}
+
+func unknownKey(key *yaml.Node, path, file string, fields map[string]reflect.Type) error {
+
+	err := fmt.Errorf("%s: %w %q", position(file, key), ErrUnknownKey, join(path, key.Value))
+
+	if near := nearest(key.Value, fields); near != "" {
+		err = fmt.Errorf("%w; did you mean %q?", err, join(path, near))
+	}
+
+	return err
+}
+
+// yamlFields maps the keys of struct t to the types of their fields, as
+// yaml.v3 decodes them.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+
+	fields := make(map[string]reflect.Type)
+
+	for i := 0; i < t.NumField(); i++ {
+
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name, flags, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		switch {
+		case name == "-":
+			continue
+		case strings.Contains(flags, "inline"):
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for k, v := range yamlFields(ft) {
+					fields[k] = v
+				}
+			}
+			continue
+		case name == "":
+			name = strings.ToLower(f.Name)
+		}
+
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+// nearest returns the key of fields closest to name, if close enough to be
+// what was meant.
+func nearest(name string, fields map[string]reflect.Type) string {
+
+	var (
+		best  string
+		bestD = len(name)/3 + 2
+	)
+
+	for key := range fields {
+		d := distance(strings.ToLower(name), strings.ToLower(key))
+		if d < bestD || (d == bestD && best != "" && key < best) {
+			best, bestD = key, d
+		}
+	}
+
+	return best
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,76 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prequel-dev/preq/internal/pkg/config"
+)
+
+const misspelled = `windw: 1m
+sourceWindows:
+  k8s: 5m
+rules:
+  disabled:
+    - CRE-2024-0007
+  registries:
+    - name: acme
+      url: https://rules.example.com/rules.yaml
+      auth:
+        tokenenv: ACME_TOKEN
+matcherPlugins:
+  - name: fraud
+    command: /usr/local/bin/preq-fraud
+    env:
+      MODE: strict
+proxy:
+  zzz: 1
+`
+
+func TestReadConfig_Strict(t *testing.T) {
+
+	// Loose by default
+	if _, err := config.ReadConfig(strings.NewReader(misspelled)); err != nil {
+		t.Fatalf("ReadConfig error: %v", err)
+	}
+
+	_, err := config.ReadConfig(strings.NewReader(misspelled), config.WithStrict(true))
+	if !errors.Is(err, config.ErrUnknownKey) {
+		t.Fatalf("expected %v, got %v", config.ErrUnknownKey, err)
+	}
+
+	want := []string{
+		`line 1, column 1: unknown config key "windw"; did you mean "window"?`,
+		`line 5, column 3: unknown config key "rules.disabled"; did you mean "rules.disable"?`,
+		`line 11, column 9: unknown config key "rules.registries[0].auth.tokenenv"; did you mean "rules.registries[0].auth.tokenEnv"?`,
+		`line 18, column 3: unknown config key "proxy.zzz"`,
+	}
+	if got := strings.Split(err.Error(), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected errors:\n%s", err)
+	}
+
+	good := "window: 1m\nsourceWindows:\n  k8s: 5m\n"
+	if _, err := config.ReadConfig(strings.NewReader(good), config.WithStrict(true)); err != nil {
+		t.Fatalf("ReadConfig error: %v", err)
+	}
+}
+
+func TestLoadConfig_Strict(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("window: 1m\nnoUpdateChek: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := config.LoadConfig(dir, "config.yaml", config.WithStrict(true))
+	want := filepath.Join(dir, "config.yaml") + `:2:1: unknown config key "noUpdateChek"; did you mean "noUpdateCheck"?`
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected %s, got %v", want, err)
+	}
+
+	if _, err := config.LoadConfig(dir, "config.yaml"); err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"bytes"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The config preq config init writes: the default timestamp formats, which
+// a config file replaces, and every other setting commented out with an
+// example.
+
+const templateHeader = `# preq configuration
+#
+# Written by preq config init. Every setting is optional; uncomment and
+# edit the ones you need, or set them with preq config set <key> <value>.
+# preq config view prints the configuration a run uses.
+#
+# See https://docs.prequel.dev for more information.
+
+# Configs this one is laid over, such as one shared across an org; paths
+# are relative to this config
+# include:
+#   - /etc/preq/org.yaml
+
+# Timestamp formats tried, in order, on sources whose format is not
+# detected. These are the defaults; a config without them tries none.
+`
+
+const templateBody = `
+# Length of the matching window, e.g. 2m (default: that of each rule)
+# window: 0s
+
+# Matching windows of sources, by source type
+# sourceWindows:
+#   k8s: 5m
+
+# Lines tried when detecting the timestamp format of a source
+# skip: 0
+
+# Data sources read when -s is not given, such as a file written by preq -g
+# dataSources: /etc/preq/data-sources.yaml
+
+# Rule updates
+# acceptUpdates: false
+# noUpdateCheck: false
+
+# rules:
+#   paths:
+#     - /etc/preq/rules.yaml
+#   disableCommunityRules: false
+#   only: []
+#   disable: []
+#   mapDeprecated: false
+#   registries:
+#     - name: acme
+#       url: https://rules.acme.internal/preq/rules.yaml
+#       priority: 10
+#       updateFrequency: 1h
+#       auth:
+#         tokenEnv: ACME_RULES_TOKEN # or token: !secret keychain:acme-rules
+#       publicKey: /etc/preq/acme-cosign.pub
+
+# Size caps of downloads in bytes; 0 is the default, -1 no cap
+# downloads:
+#   maxRulesSize: 0
+#   maxUpdateSize: 0
+
+# Refuse remote registries without a publicKey and -r urls without a pin;
+# updates from Prequel are always verified
+# verify:
+#   require: false
+
+# Record the environment of the host in reports
+# captureEnvironment: false
+
+# decisionLog:
+#   path: /var/log/preq/decisions.ndjson.gz
+#   salt: ""
+
+# statsPush:
+#   endpoint: https://stats.acme.internal/preq
+#   tokenEnv: STATS_TOKEN # or token: !secret env:STATS_TOKEN
+#   timeout: 5s
+
+# sso:
+#   issuer: https://login.acme.com/realms/eng
+#   clientId: preq
+
+# proxy:
+#   url: http://proxy.acme.internal:3128
+#   username: svc-preq
+#   passwordEnv: PROXY_PASSWORD # or password: !secret file:/run/secrets/proxy
+#   caFile: /etc/ssl/certs/acme-root.pem
+#   noProxy: [localhost, .acme.internal]
+
+# ignore:
+#   - id: CRE-2025-0025
+#     expires: 2025-12-31
+#     reason: Accepted until the queue migration
+
+# matcherPlugins:
+#   - name: fraud
+#     command: /usr/local/bin/preq-fraud
+
+# Settings laid over the rest for runs with --profile staging
+# profiles:
+#   staging:
+#     dataSources: /etc/preq/staging-sources.yaml
+#     window: 5m
+`
+
+// Template returns the commented default config.
+func Template() ([]byte, error) {
+
+	var (
+		buf   bytes.Buffer
+		enc   = yaml.NewEncoder(&buf)
+		stamp = struct {
+			Timestamps []Regex `yaml:"timestamps"`
+		}{DefaultConfig().TimestampRegexes}
+	)
+
+	buf.WriteString(templateHeader)
+
+	enc.SetIndent(2)
+	if err := enc.Encode(stamp); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	buf.WriteString(templateBody)
+
+	return buf.Bytes(), nil
+}
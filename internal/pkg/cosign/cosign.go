@@ -0,0 +1,129 @@
+package cosign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Signatures are checked the way cosign makes them with a key pair:
+//
+//	cosign generate-key-pair
+//	cosign sign-blob --key cosign.key --output-signature rules.yaml.sig rules.yaml
+//	cosign sign --key cosign.key registry.acme.internal/preq/cres:1.4.0
+//
+// A blob signature is over the SHA-256 of the blob, base64 encoded as
+// sign-blob writes it or raw DER. An OCI artifact is signed through a
+// simple signing payload naming the digest of its manifest. Keyless
+// signatures, checked against Fulcio and Rekor, are not supported.
+
+var (
+	ErrKey       = errors.New("unsupported public key")
+	ErrSignature = errors.New("signature does not verify")
+	ErrPayload   = errors.New("signature payload is for another artifact")
+)
+
+// LoadKey reads a PEM encoded public key, as cosign.pub.
+func LoadKey(fn string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	return key, nil
+}
+
+// ParseKey parses a PEM encoded ECDSA, RSA or Ed25519 public key.
+func ParseKey(data []byte) (crypto.PublicKey, error) {
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block", ErrKey)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrKey, err)
+	}
+
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrKey, key)
+	}
+}
+
+// VerifyBlob checks sig over data with key.
+func VerifyBlob(key crypto.PublicKey, data, sig []byte) error {
+
+	sig = decodeSig(sig)
+	digest := sha256.Sum256(data)
+
+	var ok bool
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(k, digest[:], sig)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(k, data, sig)
+	default:
+		return fmt.Errorf("%w: %T", ErrKey, key)
+	}
+
+	if !ok {
+		return ErrSignature
+	}
+	return nil
+}
+
+// VerifyPayload checks sig over a simple signing payload with key, and
+// that the payload names the manifest digest.
+func VerifyPayload(key crypto.PublicKey, payload, sig []byte, digest string) error {
+
+	if err := VerifyBlob(key, payload, sig); err != nil {
+		return err
+	}
+
+	var p struct {
+		Critical struct {
+			Image struct {
+				Digest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return fmt.Errorf("%w: %w", ErrPayload, err)
+	}
+	if p.Critical.Image.Digest != digest {
+		return fmt.Errorf("%w: %s", ErrPayload, p.Critical.Image.Digest)
+	}
+
+	return nil
+}
+
+// decodeSig returns the raw signature of a base64 encoded one; raw
+// signatures are binary and in practice never valid base64.
+func decodeSig(sig []byte) []byte {
+	text := bytes.TrimSpace(sig)
+	raw := make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+	n, err := base64.StdEncoding.Decode(raw, text)
+	if err != nil {
+		return sig
+	}
+	return raw[:n]
+}
@@ -0,0 +1,101 @@
+package cosign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func pemKey(t *testing.T, pub crypto.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestVerifyBlob(t *testing.T) {
+
+	var (
+		data   = []byte("rules: []\n")
+		digest = sha256.Sum256(data)
+	)
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	ecSig, _ := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	rsaSig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	edSig := ed25519.Sign(edKey, data)
+
+	testCases := []struct {
+		name string
+		pub  crypto.PublicKey
+		sig  []byte
+	}{
+		{"ecdsa raw", &ecKey.PublicKey, ecSig},
+		{"ecdsa base64", &ecKey.PublicKey, []byte(base64.StdEncoding.EncodeToString(ecSig) + "\n")},
+		{"rsa", &rsaKey.PublicKey, rsaSig},
+		{"ed25519", edPub, []byte(base64.StdEncoding.EncodeToString(edSig))},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			key, err := ParseKey(pemKey(t, tc.pub))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := VerifyBlob(key, data, tc.sig); err != nil {
+				t.Errorf("Expected the signature to verify, got %v", err)
+			}
+			if err := VerifyBlob(key, []byte("rules: {}\n"), tc.sig); !errors.Is(err, ErrSignature) {
+				t.Errorf("Expected %v for other data, got %v", ErrSignature, err)
+			}
+		})
+	}
+
+	if _, err := ParseKey([]byte("not a key")); !errors.Is(err, ErrKey) {
+		t.Errorf("Expected %v, got %v", ErrKey, err)
+	}
+}
+
+func TestVerifyPayload(t *testing.T) {
+
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	sign := func(payload []byte) []byte {
+		sum := sha256.Sum256(payload)
+		sig, _ := ecdsa.SignASN1(rand.Reader, key, sum[:])
+		return []byte(base64.StdEncoding.EncodeToString(sig))
+	}
+
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"registry.acme.internal/preq/cres"},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, digest))
+
+	if err := VerifyPayload(&key.PublicKey, payload, sign(payload), digest); err != nil {
+		t.Errorf("Expected the payload to verify, got %v", err)
+	}
+
+	other := "sha256:" + fmt.Sprintf("%064d", 0)
+	if err := VerifyPayload(&key.PublicKey, payload, sign(payload), other); !errors.Is(err, ErrPayload) {
+		t.Errorf("Expected %v, got %v", ErrPayload, err)
+	}
+
+	if err := VerifyPayload(&key.PublicKey, payload, sign([]byte("{}")), digest); !errors.Is(err, ErrSignature) {
+		t.Errorf("Expected %v, got %v", ErrSignature, err)
+	}
+}
@@ -0,0 +1,117 @@
+package debugz
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/trace"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Profiles for performance bug reports. Serve exposes the standard pprof
+// endpoints and runtime metrics while preq runs:
+//
+//	go tool pprof http://localhost:6060/debug/pprof/profile?seconds=30
+//	go tool pprof http://localhost:6060/debug/pprof/heap
+//	curl http://localhost:6060/debug/vars
+//
+// and Trace records an execution trace of the whole run, for go tool trace.
+//
+// Profiles show what preq is reading, so an address without a host, such
+// as ":6060", is served on the loopback address only; other hosts must be
+// named. The command line, which may hold tokens, is not served.
+
+const (
+	shutdownTimeout = 2 * time.Second
+)
+
+var (
+	ErrEmptyAddr = errors.New("pprof address is empty")
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// Handler returns the pprof and expvar endpoints.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// Serve listens on addr, e.g. ":6060" or "localhost:6060", and serves
+// Handler until stop is called.
+func Serve(addr string) (stop func(), err error) {
+
+	if addr == "" {
+		return nil, ErrEmptyAddr
+	}
+
+	ln, err := net.Listen("tcp", listenAddr(addr))
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &http.Server{
+		Handler:           Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Info().Str("addr", ln.Addr().String()).Msg("Serving pprof")
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("Pprof server failed")
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}, nil
+}
+
+// listenAddr returns addr on the loopback address if it names no host.
+func listenAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// Trace records an execution trace to path until stop is called.
+func Trace(path string) (stop func() error, err error) {
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = trace.Start(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	log.Info().Str("path", path).Msg("Recording execution trace")
+
+	return func() error {
+		trace.Stop()
+		return f.Close()
+	}, nil
+}
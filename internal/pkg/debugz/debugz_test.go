@@ -0,0 +1,84 @@
+package debugz
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+
+	for path, want := range map[string]string{
+		"/debug/pprof/":     "heap",
+		"/debug/vars":       `"goroutines"`,
+		"/debug/pprof/heap": "",
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK || !strings.Contains(string(body), want) {
+			t.Errorf("%s: expected %d with %q, got %d: %v", path, http.StatusOK, want, resp.StatusCode, err)
+		}
+	}
+	// The command line is not served
+	resp, err := http.Get(srv.URL + "/debug/pprof/cmdline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Errorf("Expected the command line not to be served")
+	}
+}
+
+func TestServe(t *testing.T) {
+	if _, err := Serve(""); err != ErrEmptyAddr {
+		t.Errorf("Expected %v, got %v", ErrEmptyAddr, err)
+	}
+
+	stop, err := Serve("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+}
+
+func TestListenAddr(t *testing.T) {
+	for addr, want := range map[string]string{
+		":6060":          "127.0.0.1:6060",
+		"localhost:6060": "localhost:6060",
+		"0.0.0.0:6060":   "0.0.0.0:6060",
+		"[::1]:6060":     "[::1]:6060",
+	} {
+		if got := listenAddr(addr); got != want {
+			t.Errorf("%s: expected %s, got %s", addr, want, got)
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+
+	fn := filepath.Join(t.TempDir(), "out.trace")
+
+	stop, err := Trace(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(fn); err != nil || info.Size() == 0 {
+		t.Errorf("Expected a trace to be written: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package decisionz
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	KindMatch   = "match"
+	KindSummary = "summary"
+)
+
+// RecordT is one line of the decision log. Raw log content and source
+// names never leave the process; only derived features and salted hashes.
+type RecordT struct {
+	Kind     string    `json:"kind"`
+	RuleHash string    `json:"rule_hash"`
+	CreId    string    `json:"cre_id,omitempty"`
+	SrcType  string    `json:"src_type"`
+	Source   string    `json:"source,omitempty"`
+	Time     int64     `json:"ts,omitempty"`
+	Features FeaturesT `json:"features"`
+}
+
+type FeaturesT struct {
+	Count   uint32 `json:"count,omitempty"`
+	Entries int    `json:"entries,omitempty"`
+	SpanNs  int64  `json:"span_ns,omitempty"`
+	MeanLen int    `json:"mean_len,omitempty"`
+	MaxLen  int    `json:"max_len,omitempty"`
+	Tokens  int    `json:"tokens,omitempty"`
+	Origin  bool   `json:"origin,omitempty"`
+	Lines   int64  `json:"lines,omitempty"`
+	Hits    int64  `json:"hits,omitempty"`
+}
+
+// WriterT appends gzip compressed NDJSON records. A nil *WriterT is valid
+// and discards everything, so callers need not check whether the log is on.
+type WriterT struct {
+	mux  sync.Mutex
+	fh   io.WriteCloser
+	gz   *gzip.Writer
+	enc  *json.Encoder
+	salt []byte
+}
+
+// Create opens path for writing. With an empty salt a random one is used,
+// so hashed source names cannot be joined across runs.
+func Create(path, salt string) (*WriterT, error) {
+
+	fh, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(fh, salt), nil
+}
+
+func New(wr io.WriteCloser, salt string) *WriterT {
+
+	w := &WriterT{
+		fh:   wr,
+		gz:   gzip.NewWriter(wr),
+		salt: []byte(salt),
+	}
+
+	if len(w.salt) == 0 {
+		w.salt = make([]byte, 16)
+		rand.Read(w.salt)
+	}
+
+	w.enc = json.NewEncoder(w.gz)
+	return w
+}
+
+// Anonymize returns a salted hash of s.
+func (w *WriterT) Anonymize(s string) string {
+	if w == nil || s == "" {
+		return ""
+	}
+	h := sha256.New()
+	h.Write(w.salt)
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+func (w *WriterT) Write(rec RecordT) {
+	if w == nil {
+		return
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if err := w.enc.Encode(rec); err != nil {
+		log.Warn().Err(err).Msg("Failed to write decision record")
+	}
+}
+
+// Match records a rule firing on hits.
+func (w *WriterT) Match(ruleHash, creId, srcType string, hits matchz.HitsT) {
+	if w == nil {
+		return
+	}
+
+	rec := RecordT{
+		Kind:     KindMatch,
+		RuleHash: ruleHash,
+		CreId:    creId,
+		SrcType:  srcType,
+		Features: Features(hits),
+	}
+
+	if len(hits.Entries) > 0 {
+		rec.Time = hits.Entries[0].Timestamp
+	}
+
+	w.Write(rec)
+}
+
+// Summary records how many lines a rule evaluated on a source and how often it fired.
+func (w *WriterT) Summary(ruleHash, srcType, srcName string, lines, hits int64) {
+	if w == nil {
+		return
+	}
+
+	w.Write(RecordT{
+		Kind:     KindSummary,
+		RuleHash: ruleHash,
+		SrcType:  srcType,
+		Source:   w.Anonymize(srcName),
+		Features: FeaturesT{
+			Lines: lines,
+			Hits:  hits,
+		},
+	})
+}
+
+func (w *WriterT) Close() error {
+	if w == nil {
+		return nil
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if err := w.gz.Close(); err != nil {
+		w.fh.Close()
+		return err
+	}
+	return w.fh.Close()
+}
+
+// Features derives content-free shape features from the matched entries.
+func Features(hits matchz.HitsT) FeaturesT {
+
+	f := FeaturesT{
+		Count:   hits.Count,
+		Entries: len(hits.Entries),
+		Origin:  hits.Entity.Origin,
+	}
+
+	if len(hits.Entries) == 0 {
+		return f
+	}
+
+	var total int
+	for _, e := range hits.Entries {
+		total += len(e.Entry)
+		f.MaxLen = max(f.MaxLen, len(e.Entry))
+		f.Tokens += len(bytes.Fields(e.Entry))
+	}
+	f.MeanLen = total / len(hits.Entries)
+	f.SpanNs = hits.Entries[len(hits.Entries)-1].Timestamp - hits.Entries[0].Timestamp
+
+	return f
+}
@@ -0,0 +1,82 @@
+package decisionz
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prequel-dev/preq/internal/pkg/matchz"
+)
+
+func TestWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.ndjson.gz")
+
+	w, err := Create(path, "salt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	w.Match("hash1", "CRE-1", "cre.log.app", matchz.HitsT{
+		Count: 2,
+		Entries: []matchz.EntryT{
+			{Timestamp: 10, Entry: []byte("secret user=alice failed")},
+			{Timestamp: 30, Entry: []byte("secret")},
+		},
+	})
+	w.Summary("hash1", "cre.log.app", "/var/log/app.log", 100, 1)
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	fh, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer fh.Close()
+
+	gz, err := gzip.NewReader(fh)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+
+	var recs []RecordT
+	sc := bufio.NewScanner(gz)
+	for sc.Scan() {
+		if strings.Contains(sc.Text(), "alice") || strings.Contains(sc.Text(), "app.log") {
+			t.Errorf("record leaks raw content: %s", sc.Text())
+		}
+		var rec RecordT
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		recs = append(recs, rec)
+	}
+
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(recs))
+	}
+
+	m := recs[0].Features
+	if recs[0].Kind != KindMatch || m.Entries != 2 || m.SpanNs != 20 || m.MaxLen != 24 || m.Tokens != 4 {
+		t.Errorf("unexpected match record: %+v", recs[0])
+	}
+
+	s := recs[1]
+	if s.Kind != KindSummary || s.Features.Lines != 100 || s.Features.Hits != 1 || s.Source != w.Anonymize("/var/log/app.log") {
+		t.Errorf("unexpected summary record: %+v", s)
+	}
+}
+
+func TestNilWriter(t *testing.T) {
+	var w *WriterT
+	w.Match("hash", "CRE-1", "src", matchz.HitsT{})
+	w.Summary("hash", "src", "name", 1, 0)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close on nil writer: %v", err)
+	}
+}
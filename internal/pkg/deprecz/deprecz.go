@@ -0,0 +1,131 @@
+package deprecz
+
+// A CRE may be deprecated, and name the CRE that supersedes it:
+//
+//	- cre:
+//	    id: CRE-2024-0007
+//	    deprecated: true
+//	    supersededBy: CRE-2025-0100
+//
+// A CRE with supersededBy is deprecated even without deprecated. The rule
+// of a deprecated CRE still runs, so configs pinned to its id keep working
+// while they are moved over. Its detections are noted, and may be reported
+// under the superseding CRE instead.
+//
+// The rules parser does not know these keys, so they are picked out of
+// each rules document as it is read, and the document is left as it is.
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	keyDeprecated   = "deprecated"
+	keySupersededBy = "supersededBy"
+)
+
+// DeprecationT is the deprecation of a CRE.
+type DeprecationT struct {
+	CreId        string `json:"cre_id"`
+	SupersededBy string `json:"superseded_by,omitempty"`
+}
+
+func (d DeprecationT) String() string {
+	if d.SupersededBy == "" {
+		return d.CreId + " is deprecated"
+	}
+	return d.CreId + " is deprecated; superseded by " + d.SupersededBy
+}
+
+// SetT holds the deprecations of the rules read so far. A nil *SetT has
+// none.
+type SetT struct {
+	mux   sync.RWMutex
+	byCre map[string]DeprecationT // by upper case CRE id
+}
+
+func New() *SetT {
+	return &SetT{byCre: make(map[string]DeprecationT)}
+}
+
+// Scan records the deprecations in a rules document and returns it as is.
+// It suits utils.WithRewrite.
+func (s *SetT) Scan(data []byte) ([]byte, error) {
+
+	if s == nil || (!bytes.Contains(data, []byte(keyDeprecated)) && !bytes.Contains(data, []byte(keySupersededBy))) {
+		return data, nil
+	}
+
+	type docT struct {
+		Rules []struct {
+			Cre struct {
+				Id           string `yaml:"id"`
+				Deprecated   bool   `yaml:"deprecated"`
+				SupersededBy string `yaml:"supersededBy"`
+			} `yaml:"cre"`
+		} `yaml:"rules"`
+	}
+
+	return utils.RewriteDocs(data, func(n *yaml.Node) (bool, error) {
+
+		var doc docT
+		if err := n.Decode(&doc); err != nil {
+			// Not shaped as rules; the rules parser reports it
+			return false, nil
+		}
+
+		for _, rule := range doc.Rules {
+			cre := rule.Cre
+			if cre.Id == "" || (!cre.Deprecated && cre.SupersededBy == "") {
+				continue
+			}
+			s.add(DeprecationT{CreId: cre.Id, SupersededBy: cre.SupersededBy})
+		}
+
+		return false, nil
+	})
+}
+
+func (s *SetT) add(d DeprecationT) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.byCre[strings.ToUpper(d.CreId)] = d
+}
+
+// Lookup returns the deprecation of creId, ignoring case.
+func (s *SetT) Lookup(creId string) (DeprecationT, bool) {
+	if s == nil {
+		return DeprecationT{}, false
+	}
+
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	d, ok := s.byCre[strings.ToUpper(creId)]
+	return d, ok
+}
+
+// Successor follows the supersededBy chain from creId to the first CRE
+// that is not superseded. It returns creId if it is not superseded, and
+// stops at a CRE seen before should the chain loop.
+func (s *SetT) Successor(creId string) string {
+
+	seen := make(map[string]struct{})
+
+	for {
+		d, ok := s.Lookup(creId)
+		if !ok || d.SupersededBy == "" {
+			return creId
+		}
+		seen[strings.ToUpper(creId)] = struct{}{}
+		if _, loop := seen[strings.ToUpper(d.SupersededBy)]; loop {
+			return creId
+		}
+		creId = d.SupersededBy
+	}
+}
@@ -0,0 +1,73 @@
+package deprecz
+
+import (
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+
+	s := New()
+
+	doc := `rules:
+  - cre:
+      id: CRE-2024-0007
+      deprecated: true
+  - cre:
+      id: CRE-2024-0008
+      supersededBy: CRE-2025-0100
+  - cre:
+      id: CRE-2025-0100
+---
+rules:
+  - cre:
+      id: CRE-2025-0100
+      supersededBy: CRE-2026-0001
+`
+
+	out, err := s.Scan([]byte(doc))
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if string(out) != doc {
+		t.Fatalf("Expected the document as is, got:\n%s", out)
+	}
+
+	if d, ok := s.Lookup("cre-2024-0007"); !ok || d.SupersededBy != "" {
+		t.Errorf("Expected CRE-2024-0007 deprecated, got %v %v", d, ok)
+	}
+	if d, ok := s.Lookup("CRE-2024-0008"); !ok || d.String() != "CRE-2024-0008 is deprecated; superseded by CRE-2025-0100" {
+		t.Errorf("Expected CRE-2024-0008 superseded, got %q %v", d, ok)
+	}
+	if _, ok := s.Lookup("CRE-2026-0001"); ok {
+		t.Errorf("Expected CRE-2026-0001 current")
+	}
+
+	// The chain is followed to its end
+	if got := s.Successor("CRE-2024-0008"); got != "CRE-2026-0001" {
+		t.Errorf("Expected CRE-2026-0001, got %s", got)
+	}
+	if got := s.Successor("CRE-2024-0007"); got != "CRE-2024-0007" {
+		t.Errorf("Expected CRE-2024-0007 without a successor, got %s", got)
+	}
+
+	// Unparsable documents are left to the rules parser
+	if _, err := s.Scan([]byte("rules: [deprecated\n")); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	var none *SetT
+	if _, ok := none.Lookup("CRE-2024-0007"); ok {
+		t.Errorf("Expected no deprecations in a nil set")
+	}
+}
+
+func TestSuccessorLoop(t *testing.T) {
+
+	s := New()
+	s.add(DeprecationT{CreId: "CRE-A", SupersededBy: "CRE-B"})
+	s.add(DeprecationT{CreId: "CRE-B", SupersededBy: "cre-a"})
+
+	if got := s.Successor("CRE-A"); got != "CRE-B" {
+		t.Errorf("Expected the chain to stop at CRE-B, got %s", got)
+	}
+}
@@ -0,0 +1,105 @@
+package dirz
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// preq keeps its config, login and rules in the config directory, and
+// state such as checkpoints in the data directory. Both follow the XDG
+// base directory spec where its variables are set, and otherwise keep the
+// places of earlier releases. Without a home directory, as for services
+// and containers run without HOME, they fall back to the directories of
+// the OS, then to a private directory of the user under the temp one,
+// rather than to the working one.
+//
+//	config: --config-dir, $XDG_CONFIG_HOME/preq, ~/.config/preq
+//	data:   $XDG_DATA_HOME/preq, ~/.prequel
+//
+// https://specifications.freedesktop.org/basedir-spec/latest/
+
+const (
+	appName    = "preq"
+	configHome = "XDG_CONFIG_HOME"
+	dataHome   = "XDG_DATA_HOME"
+	legacyData = ".prequel"
+)
+
+// Config returns the config directory, dir if set.
+func Config(dir string) string {
+
+	if dir != "" {
+		return dir
+	}
+
+	if base := xdg(configHome); base != "" {
+		return filepath.Join(base, appName)
+	}
+
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		return filepath.Join(home, ".config", appName)
+	}
+
+	if base, err := os.UserConfigDir(); err == nil && base != "" {
+		return filepath.Join(base, appName)
+	}
+
+	return tempDir()
+}
+
+// Data returns the data directory.
+func Data() string {
+
+	if base := xdg(dataHome); base != "" {
+		return filepath.Join(base, appName)
+	}
+
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		return filepath.Join(home, legacyData)
+	}
+
+	if base, err := os.UserCacheDir(); err == nil && base != "" {
+		return filepath.Join(base, appName)
+	}
+
+	return tempDir()
+}
+
+// tempDir returns a directory under the temp one that only the user may
+// use, created if needed. One left there by another user, or open to
+// others, is not trusted; a new one is made for the run instead.
+// Should that fail too, the directory returned cannot be written.
+func tempDir() string {
+
+	dir := filepath.Join(os.TempDir(), appName+"-"+strconv.Itoa(os.Getuid()))
+
+	err := os.Mkdir(dir, 0700)
+	if err == nil || errors.Is(err, fs.ErrExist) {
+		if fi, err := os.Lstat(dir); err == nil && fi.IsDir() && fi.Mode().Perm() == 0700 && owned(fi) {
+			return dir
+		}
+	}
+
+	tmp, err := os.MkdirTemp("", appName+"-")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create a private temp directory")
+		return filepath.Join(os.DevNull, appName) // fails every write
+	}
+
+	log.Warn().Str("dir", dir).Str("using", tmp).Msg("Temp directory is not private; using one for this run")
+	return tmp
+}
+
+// xdg returns the directory of the XDG variable name. Relative paths are
+// invalid and ignored, as the spec asks.
+func xdg(name string) string {
+	if dir := os.Getenv(name); filepath.IsAbs(dir) {
+		return dir
+	}
+	return ""
+}
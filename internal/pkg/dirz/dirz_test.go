@@ -0,0 +1,81 @@
+package dirz
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(configHome, "")
+
+	if got := Config("/etc/preq"); got != "/etc/preq" {
+		t.Errorf("Expected the dir given, got %s", got)
+	}
+	if got, want := Config(""), filepath.Join(home, ".config", "preq"); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	t.Setenv(configHome, "/srv/config")
+	if got := Config(""); got != filepath.Join("/srv/config", "preq") {
+		t.Errorf("Expected the XDG config dir, got %s", got)
+	}
+
+	t.Setenv(configHome, "relative")
+	if got, want := Config(""), filepath.Join(home, ".config", "preq"); got != want {
+		t.Errorf("Expected a relative XDG dir ignored, got %s", got)
+	}
+
+	// Without a home, never the working directory
+	t.Setenv("HOME", "")
+	t.Setenv(configHome, "")
+	if got := Config(""); !filepath.IsAbs(got) {
+		t.Errorf("Expected an absolute dir without HOME, got %s", got)
+	}
+}
+
+func TestData(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(dataHome, "")
+
+	if got, want := Data(), filepath.Join(home, ".prequel"); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	t.Setenv(dataHome, "/srv/data")
+	if got := Data(); got != filepath.Join("/srv/data", "preq") {
+		t.Errorf("Expected the XDG data dir, got %s", got)
+	}
+
+	t.Setenv("HOME", "")
+	t.Setenv(dataHome, "")
+	t.Setenv("XDG_CACHE_HOME", "")
+	if got := Data(); !filepath.IsAbs(got) || got == os.Getenv("PWD") {
+		t.Errorf("Expected an absolute dir without HOME, got %s", got)
+	}
+}
+
+func TestTempDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not unix modes on windows")
+	}
+	t.Setenv("TMPDIR", t.TempDir())
+
+	dir := tempDir()
+	if fi, err := os.Stat(dir); err != nil || fi.Mode().Perm() != 0700 {
+		t.Fatalf("Expected a private dir, got %s (%v)", dir, err)
+	}
+	if got := tempDir(); got != dir {
+		t.Errorf("Expected the dir reused, got %s", got)
+	}
+
+	// One open to others is not used
+	os.Chmod(dir, 0777)
+	if got := tempDir(); got == dir || filepath.Dir(got) != os.TempDir() {
+		t.Errorf("Expected a new dir under the temp one, got %s", got)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package dirz
+
+import "io/fs"
+
+// The temp directory of other systems is the user's own.
+func owned(fs.FileInfo) bool {
+	return true
+}
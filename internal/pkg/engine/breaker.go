@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/rs/zerolog/log"
+)
+
+// A rule whose matcher costs more CPU time than the rule timeout per line,
+// on average over a window of lines, is disabled for the rest of the run on
+// every source and noted in the report, so one catastrophic pattern or huge
+// window cannot stall the scan. The cost is the CPU time of the matching
+// thread rather than the time that passed, and is averaged over the window,
+// so that garbage collection, scheduling or a loaded host do not disable
+// rules that are not at fault. A matcher cannot be interrupted, so the line
+// that ends the window is still matched to the end.
+
+const (
+	breakerWindow = 1000 // lines
+)
+
+type breakerT struct {
+	timeout time.Duration
+	window  int64
+	mux     sync.Mutex
+	rules   map[string]*ruleBreakerT // by rule hash
+	report  *ux.ReportT
+}
+
+type ruleBreakerT struct {
+	off atomic.Bool
+
+	mux   sync.Mutex
+	lines int64         // of the current window
+	cost  time.Duration // of the current window
+	mean  time.Duration // cost per line of the window that tripped
+}
+
+// SetRuleTimeout disables a rule whose matcher costs more than timeout of
+// CPU time per line over a window of lines. A timeout of zero never
+// disables rules.
+func (r *RuntimeT) SetRuleTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		r.breaker = nil
+		return
+	}
+	r.breaker = &breakerT{timeout: timeout, window: breakerWindow}
+}
+
+// reset starts a run that notes the rules it disables in report.
+func (b *breakerT) reset(report *ux.ReportT) {
+	if b == nil {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.rules = make(map[string]*ruleBreakerT)
+	b.report = report
+}
+
+// rule returns the breaker of a rule, shared by the sources it matches,
+// or nil if rules are never disabled.
+func (b *breakerT) rule(ruleHash string) *ruleBreakerT {
+	if b == nil {
+		return nil
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	rb, ok := b.rules[ruleHash]
+	if !ok {
+		rb = &ruleBreakerT{}
+		if b.rules == nil {
+			b.rules = make(map[string]*ruleBreakerT)
+		}
+		b.rules[ruleHash] = rb
+	}
+	return rb
+}
+
+func (rb *ruleBreakerT) disabled() bool {
+	return rb != nil && rb.off.Load()
+}
+
+// measure runs match with the goroutine held to its thread, and returns
+// the CPU time it cost the thread.
+func measure[T any](match func() T) (T, time.Duration) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	start := threadTime()
+	out := match()
+	return out, threadTime() - start
+}
+
+// observe counts a line that cost spent to match, and at the end of a
+// window disables the rule if it cost more than the timeout per line. It
+// reports whether this call disabled it.
+func (b *breakerT) observe(rb *ruleBreakerT, spent time.Duration) bool {
+
+	if rb == nil {
+		return false
+	}
+
+	rb.mux.Lock()
+	defer rb.mux.Unlock()
+
+	rb.lines++
+	rb.cost += spent
+	if rb.lines < b.window {
+		return false
+	}
+
+	mean := rb.cost / time.Duration(rb.lines)
+	rb.lines, rb.cost = 0, 0
+	if mean <= b.timeout {
+		return false
+	}
+
+	rb.mean = mean
+	return rb.off.CompareAndSwap(false, true)
+}
+
+// trip notes the rule disabled at ts in the report.
+func (r *RuntimeT) trip(rb *ruleBreakerT, ruleHash, src string, ts int64) {
+
+	cre, _ := r.getCre(ruleHash)
+
+	rb.mux.Lock()
+	mean := rb.mean
+	rb.mux.Unlock()
+
+	log.Warn().
+		Str("cre", cre.Id).
+		Str("rule", ruleHash).
+		Str("src", src).
+		Dur("timeout", r.breaker.timeout).
+		Dur("cost", mean).
+		Int64("window", r.breaker.window).
+		Msg("Rule exceeded the rule timeout; disabled for the rest of the run")
+
+	r.breaker.mux.Lock()
+	report := r.breaker.report
+	r.breaker.mux.Unlock()
+
+	if report != nil && cre.Id != "" {
+		report.DisableRule(cre.Id, time.Unix(0, ts), ux.DisabledT{
+			Reason:  "rule timeout exceeded",
+			Timeout: r.breaker.timeout.String(),
+			Cost:    mean.String(),
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package engine
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"github.com/prequel-dev/prequel-logmatch/pkg/entry"
+	"github.com/prequel-dev/prequel-logmatch/pkg/scanner"
+)
+
+// SetCollapse collapses runs of identical lines before matching. The first
+// lines of each run, as many as any rule can match in one detection, and
+// its last line reach the matchers; the lines between them are counted
+// and dropped.
+func (r *RuntimeT) SetCollapse(on bool) {
+	r.collapse = on
+}
+
+// collapseT sits in front of the matchers of one source. Nothing else in
+// the source falls between the lines of a run, so keeping its first and
+// last line preserves how close the run is to every other line, which is
+// all that windowed rules look at. Rules that count repeats of the same
+// line also see enough of the run to match: one line for each term.
+type collapseT struct {
+	next    scanner.ScanFuncT
+	key     string
+	stream  string
+	started bool
+	keep    int             // lines of a run passed on before holding back
+	run     int             // lines of the current run passed on
+	held    *entry.LogEntry // newest duplicate, emitted when the run ends
+	dropped *atomic.Int64
+	n       int64
+}
+
+func newCollapse(next scanner.ScanFuncT, dropped *atomic.Int64, keep int) *collapseT {
+	return &collapseT{next: next, dropped: dropped, keep: max(keep, 1)}
+}
+
+func (c *collapseT) Append(e entry.LogEntry) bool {
+
+	key := collapseKey(e.Line)
+
+	if c.started && key == c.key && e.Stream == c.stream {
+		if c.run < c.keep {
+			c.run++
+			return c.next(e)
+		}
+		if c.held != nil {
+			c.n++
+			c.dropped.Add(1)
+		}
+		c.held = &e
+		return false
+	}
+
+	if c.Flush() {
+		return true
+	}
+
+	c.key, c.stream, c.started, c.run = key, e.Stream, true, 1
+	return c.next(e)
+}
+
+// Flush emits the last line of the current run, if held back.
+func (c *collapseT) Flush() bool {
+	if c.held == nil {
+		return false
+	}
+	held := *c.held
+	c.held = nil
+	return c.next(held)
+}
+
+// Collapsed returns the number of lines dropped so far.
+func (c *collapseT) Collapsed() int64 {
+	return c.n
+}
+
+// addRepeats records how many lines of one run each rule may need for a
+// match: one per term, counted as often as the term repeats.
+func (r *RuntimeT) addRepeats(rules *parser.RulesT) {
+
+	for _, rule := range rules.Rules {
+
+		var n int
+		switch {
+		case rule.Rule.Sequence != nil:
+			n = repeats(rules.TermsT, rule.Rule.Sequence.Order)
+		case rule.Rule.Set != nil:
+			n = repeats(rules.TermsT, rule.Rule.Set.Match)
+		}
+
+		if r.repeats == nil {
+			r.repeats = make(map[string]int)
+		}
+		r.repeats[rule.Metadata.Hash] = n
+	}
+}
+
+func repeats(named map[string]parser.ParseTermT, terms []parser.ParseTermT) int {
+
+	var n int
+	for _, t := range terms {
+
+		if nt, ok := named[t.StrValue]; ok && t.StrValue != "" {
+			t = nt
+		}
+
+		switch {
+		case t.Sequence != nil:
+			n += repeats(named, t.Sequence.Order)
+		case t.Set != nil:
+			n += repeats(named, t.Set.Match)
+		default:
+			n += max(t.Count, 1)
+		}
+	}
+	return n
+}
+
+// maxRepeats returns the most lines of one run any rule may need.
+func (r *RuntimeT) maxRepeats() int {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	var n int
+	for _, v := range r.repeats {
+		n = max(n, v)
+	}
+	return n
+}
+
+// collapseKey strips the leading timestamp so lines that differ only in
+// when they were written compare equal. Leading date and time fields are
+// skipped, along with month and weekday names. A bare number counts only
+// as an epoch or as the day after a month name, so lines led by a status
+// code or a counter keep it.
+func collapseKey(line string) string {
+
+	var (
+		rest  = strings.TrimLeft(line, " ")
+		named bool
+	)
+
+	for range maxStampFields {
+		field, tail, ok := strings.Cut(rest, " ")
+		if !ok {
+			break
+		}
+		isName := isStampName(field)
+		if !isName && !isStampField(field, named) {
+			break
+		}
+		named = isName
+		rest = strings.TrimLeft(tail, " ")
+	}
+
+	return rest
+}
+
+const (
+	maxStampFields = 5
+	minEpochDigits = 10
+)
+
+var stampNames = map[string]struct{}{
+	"Jan": {}, "Feb": {}, "Mar": {}, "Apr": {}, "May": {}, "Jun": {},
+	"Jul": {}, "Aug": {}, "Sep": {}, "Oct": {}, "Nov": {}, "Dec": {},
+	"Mon": {}, "Tue": {}, "Wed": {}, "Thu": {}, "Fri": {}, "Sat": {}, "Sun": {},
+}
+
+func isStampName(field string) bool {
+	_, ok := stampNames[strings.Trim(field, "[],")]
+	return ok
+}
+
+func isStampField(field string, afterName bool) bool {
+
+	var digits, separators int
+
+	for _, ch := range strings.Trim(field, "[](),") {
+		switch {
+		case ch >= '0' && ch <= '9':
+			digits++
+		case ch == '-' || ch == ':' || ch == '/':
+			separators++
+		case ch == '.' || ch == ',' || ch == '+' || ch == 'T' || ch == 'Z':
+		default:
+			return false
+		}
+	}
+
+	switch {
+	case digits == 0:
+		return false
+	case separators > 0, afterName:
+		return true
+	}
+	return digits >= minEpochDigits
+}
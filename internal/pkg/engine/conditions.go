@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/prequel-dev/preq/internal/pkg/celz"
+	"github.com/prequel-dev/preq/internal/pkg/pluginz"
+	"github.com/prequel-dev/preq/internal/pkg/ratez"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"github.com/prequel-dev/prequel-logmatch/pkg/entry"
+)
+
+// Cel, plugin and rate conditions are read as raw terms on markers, since
+// the log matchers only know raw, regex and jq terms. They are decided in
+// the matchers of the rule they belong to: before those see a line, the
+// rule's conditions are evaluated, and its rates counted, and the markers of
+// those that hold are appended to the line they see. Every other rule sees
+// the line as it was read.
+
+// lineT is a line as the conditions of the rules on a source see it. What
+// they learn of it, such as its fields or the replies of plugins, is worked
+// out once for them all.
+type lineT struct {
+	entry   entry.LogEntry
+	fields  map[string]any
+	parsed  bool
+	replies pluginz.RepliesT
+}
+
+func (l *lineT) Fields() map[string]any {
+	if !l.parsed {
+		l.fields, l.parsed = celz.Fields(l.entry.Line), true
+	}
+	return l.fields
+}
+
+// condsT decides the marker conditions of one rule on one source. A nil
+// *condsT has none.
+type condsT struct {
+	source  string
+	cel     *celz.BoundT
+	plugins *pluginz.BoundT
+	rates   *ratez.CounterT
+	buf     []string
+}
+
+// bindConds returns the conditions of a rule for one source, or nil if it
+// has none.
+func (r *RuntimeT) bindConds(ruleHash, source string) *condsT {
+
+	marks := r.ruleMarkers(ruleHash)
+	if len(marks) == 0 {
+		return nil
+	}
+
+	c := &condsT{
+		source:  source,
+		cel:     r.cel.Bind(marks),
+		plugins: r.plugins.Bind(marks),
+		rates:   r.rates.Bind(marks),
+	}
+	if c.cel == nil && c.plugins == nil && c.rates == nil {
+		return nil
+	}
+
+	return c
+}
+
+// entry returns the entry of l as the rule sees it: with the markers of its
+// conditions that hold on the line appended.
+func (c *condsT) entry(l *lineT) entry.LogEntry {
+
+	if c == nil {
+		return l.entry
+	}
+
+	held := c.buf[:0]
+	if c.cel != nil {
+		held = c.cel.Holds(held, l.Fields(), l.entry.Line)
+	}
+	if c.plugins != nil {
+		held = c.plugins.Holds(held, &l.replies, c.source, l.entry.Timestamp, l.entry.Line)
+	}
+	if c.rates != nil {
+		held = c.rates.Holds(held, l.entry.Timestamp, l.entry.Line)
+	}
+	c.buf = held
+
+	e := l.entry
+	if len(held) > 0 {
+		e.Line += " " + strings.Join(held, " ")
+	}
+
+	return e
+}
+
+// isMarker reports whether a raw term value stands for a cel, plugin or
+// rate condition.
+func isMarker(value string) bool {
+	return celz.IsMarker(value) || pluginz.IsMarker(value) || ratez.IsMarker(value)
+}
+
+// addMarkers records the markers of the conditions of each rule. Called
+// with the lock held.
+func (r *RuntimeT) addMarkers(rules *parser.RulesT) {
+
+	for _, rule := range rules.Rules {
+
+		var marks []string
+		switch {
+		case rule.Rule.Sequence != nil:
+			seq := rule.Rule.Sequence
+			marks = markers(rules.TermsT, marks, seq.Order, seq.Negate)
+		case rule.Rule.Set != nil:
+			set := rule.Rule.Set
+			marks = markers(rules.TermsT, marks, set.Match, set.Negate)
+		}
+
+		if len(marks) == 0 {
+			delete(r.markers, rule.Metadata.Hash)
+			continue
+		}
+
+		if r.markers == nil {
+			r.markers = make(map[string][]string)
+		}
+		r.markers[rule.Metadata.Hash] = marks
+	}
+}
+
+// markers appends the markers of terms to out, descending into nested sets
+// and sequences.
+func markers(named map[string]parser.ParseTermT, out []string, terms ...[]parser.ParseTermT) []string {
+
+	for _, list := range terms {
+		for _, t := range list {
+
+			if nt, ok := named[t.StrValue]; ok && t.StrValue != "" {
+				t = nt
+			}
+
+			switch {
+			case t.Sequence != nil:
+				out = markers(named, out, t.Sequence.Order, t.Sequence.Negate)
+			case t.Set != nil:
+				out = markers(named, out, t.Set.Match, t.Set.Negate)
+			case isMarker(t.StrValue):
+				out = append(out, t.StrValue)
+			}
+		}
+	}
+
+	return out
+}
+
+// ruleMarkers returns the markers of the conditions of a rule.
+func (r *RuntimeT) ruleMarkers(ruleHash string) []string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.markers[ruleHash]
+}
+
+// retainMarkers drops the conditions no rule has any more. Called with the
+// lock held.
+func (r *RuntimeT) retainMarkers() {
+
+	live := make(map[string]struct{})
+	for _, marks := range r.markers {
+		for _, m := range marks {
+			live[m] = struct{}{}
+		}
+	}
+
+	keep := func(marker string) bool {
+		_, ok := live[marker]
+		return ok
+	}
+
+	r.cel.Retain(keep)
+	r.rates.Retain(keep)
+}
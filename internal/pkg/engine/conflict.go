@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"github.com/rs/zerolog/log"
+)
+
+// Rule paths of named registries are merged by priority: the registries
+// of higher priority are read first, and a rule of a later registry whose
+// CRE, rule id or hash is already defined by another registry is dropped
+// and reported as a conflict. Rules outside any registry, and rules
+// defined twice within one, are still rejected as duplicates.
+
+// RuleConflictT is a rule dropped for a registry of higher priority.
+type RuleConflictT struct {
+	Cre     string
+	Id      string // the CRE, rule id or hash defined twice
+	Kept    string // registry of the rule kept
+	Dropped string // registry of the rule dropped
+	Path    string // where the dropped rule was read
+}
+
+func (c RuleConflictT) String() string {
+	if c.Id == c.Cre {
+		return fmt.Sprintf("%s of %s overrides %s (%s)", c.Cre, c.Kept, c.Dropped, c.Path)
+	}
+	return fmt.Sprintf("%s of %s overrides %s, which shares its id %s (%s)", c.Cre, c.Kept, c.Dropped, c.Id, c.Path)
+}
+
+// Conflicts returns the rules dropped for registries of higher priority
+// the last time rules were loaded.
+func (r *RuntimeT) Conflicts() []RuleConflictT {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return slices.Clone(r.conflicts)
+}
+
+type mergeT struct {
+	owners    map[string]string // CRE, rule id and hash -> registry
+	conflicts []RuleConflictT
+}
+
+func newMerge() *mergeT {
+	return &mergeT{owners: make(map[string]string)}
+}
+
+// byPriority orders paths by descending priority, keeping the given order
+// of those of equal priority.
+func byPriority(paths []utils.RulePathT) []utils.RulePathT {
+	out := slices.Clone(paths)
+	slices.SortStableFunc(out, func(a, b utils.RulePathT) int {
+		return cmp.Compare(b.Priority, a.Priority)
+	})
+	return out
+}
+
+// filter drops the rules of rp that another registry already defines.
+func (m *mergeT) filter(rp utils.RulePathT) utils.ReaderOptT {
+	return utils.WithFilter(func(rule parser.ParseRuleT) bool {
+
+		if rp.Registry == "" {
+			return true
+		}
+
+		for _, id := range []string{rule.Cre.Id, rule.Metadata.Id, rule.Metadata.Hash} {
+			owner, ok := m.owners[id]
+			if id == "" || !ok || owner == "" || owner == rp.Registry {
+				continue
+			}
+
+			c := RuleConflictT{
+				Cre:     rule.Cre.Id,
+				Id:      id,
+				Kept:    owner,
+				Dropped: rp.Registry,
+				Path:    rp.Path,
+			}
+
+			log.Warn().
+				Str("cre", c.Cre).
+				Str("id", c.Id).
+				Str("kept", c.Kept).
+				Str("dropped", c.Dropped).
+				Str("path", c.Path).
+				Msg("Rule conflict; keeping the rule of the registry of higher priority")
+
+			m.conflicts = append(m.conflicts, c)
+			return false
+		}
+
+		return true
+	})
+}
+
+// add records the registry of the rules read from rp.
+func (m *mergeT) add(rp utils.RulePathT, rules *parser.RulesT) {
+	for _, rule := range rules.Rules {
+		for _, id := range []string{rule.Cre.Id, rule.Metadata.Id, rule.Metadata.Hash} {
+			if _, ok := m.owners[id]; !ok && id != "" {
+				m.owners[id] = rp.Registry
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/prequel-dev/prequel-logmatch/pkg/entry"
+)
+
+const (
+	contextHistory = 4096 // lines kept to find the entries of a hit
+)
+
+// contextT keeps the recent lines of a source so each entry of a hit can
+// carry the lines around it. An entry read longer ago than the history,
+// e.g. the first step of a long sequence, gets no context.
+//
+// Hits are held, in order, until the lines after their entries are read,
+// so they are delivered with their context complete. A hit still waiting
+// when the source ends or goes idle is delivered with the lines read so
+// far.
+type contextT struct {
+	n       int
+	ring    []entry.LogEntry
+	next    int
+	full    bool
+	pending []*pendingT
+	held    []heldT
+}
+
+// pendingT collects the lines after an entry as they are read.
+type pendingT struct {
+	ctx  *matchz.ContextT
+	left int
+}
+
+// heldT is a hit waiting on the lines after its entries.
+type heldT struct {
+	hits    *matchz.HitsT
+	waiting []*pendingT
+	deliver func(*matchz.HitsT)
+}
+
+func (h heldT) ready() bool {
+	for _, p := range h.waiting {
+		if p.left > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func newContext(n int) *contextT {
+	return &contextT{
+		n:    n,
+		ring: make([]entry.LogEntry, max(2*n+1, contextHistory)),
+	}
+}
+
+// SetContext captures n lines before and after each entry of a hit.
+func (r *RuntimeT) SetContext(n int) {
+	r.context = n
+}
+
+// push records a line read from the source. Call it before the line is
+// matched so a hit on it collects only the lines that follow.
+func (c *contextT) push(e entry.LogEntry) {
+
+	live := c.pending[:0]
+	for _, p := range c.pending {
+		p.ctx.After = append(p.ctx.After, e.Line)
+		if p.left--; p.left > 0 {
+			live = append(live, p)
+		}
+	}
+	clear(c.pending[len(live):])
+	c.pending = live
+
+	c.ring[c.next] = e
+	if c.next++; c.next == len(c.ring) {
+		c.next, c.full = 0, true
+	}
+
+	c.release(false)
+}
+
+// hold attaches context to h and delivers it once the lines after its
+// entries are read, after any hit held before it.
+func (c *contextT) hold(h *matchz.HitsT, deliver func(*matchz.HitsT)) {
+	c.held = append(c.held, heldT{
+		hits:    h,
+		waiting: c.attach(h),
+		deliver: deliver,
+	})
+	c.release(false)
+}
+
+// release delivers the held hits whose context is complete, or all of
+// them when flushing.
+func (c *contextT) release(flush bool) {
+
+	n := 0
+	for ; n < len(c.held) && (flush || c.held[n].ready()); n++ {
+		c.held[n].deliver(c.held[n].hits)
+	}
+	if n == 0 {
+		return
+	}
+
+	clear(c.held[:n])
+	c.held = c.held[n:]
+
+	if flush {
+		for _, p := range c.pending {
+			p.left = 0
+		}
+		clear(c.pending)
+		c.pending = c.pending[:0]
+	}
+}
+
+// flush delivers every held hit with the context read so far.
+func (c *contextT) flush() {
+	c.release(true)
+}
+
+func (c *contextT) size() int {
+	if c.full {
+		return len(c.ring)
+	}
+	return c.next
+}
+
+// at returns the line i lines back from the newest.
+func (c *contextT) at(i int) entry.LogEntry {
+	return c.ring[(c.next-1-i+len(c.ring))%len(c.ring)]
+}
+
+// attach sets the context of every entry of h still in the history. It
+// returns the contexts still waiting on lines after their entry.
+func (c *contextT) attach(h *matchz.HitsT) (waiting []*pendingT) {
+
+	for i := range h.Entries {
+
+		var (
+			he   = &h.Entries[i]
+			size = c.size()
+		)
+
+		for back := 0; back < size; back++ {
+
+			e := c.at(back)
+			if e.Timestamp != he.Timestamp || e.Line != string(he.Entry) {
+				continue
+			}
+
+			ctx := &matchz.ContextT{}
+			for j := min(back+c.n, size-1); j > back; j-- {
+				ctx.Before = append(ctx.Before, c.at(j).Line)
+			}
+			for j := back - 1; j >= 0 && len(ctx.After) < c.n; j-- {
+				ctx.After = append(ctx.After, c.at(j).Line)
+			}
+			if left := c.n - len(ctx.After); left > 0 {
+				p := &pendingT{ctx: ctx, left: left}
+				c.pending = append(c.pending, p)
+				waiting = append(waiting, p)
+			}
+
+			he.Context = ctx
+			break
+		}
+	}
+
+	return waiting
+}
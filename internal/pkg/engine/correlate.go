@@ -0,0 +1,630 @@
+package engine
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/prequel-dev/preq/internal/pkg/spillz"
+	"github.com/prequel-dev/prequel-compiler/pkg/ast"
+	"github.com/prequel-dev/prequel-compiler/pkg/compiler"
+	"github.com/prequel-dev/prequel-compiler/pkg/schema"
+	"github.com/prequel-dev/prequel-logmatch/pkg/entry"
+	lm "github.com/prequel-dev/prequel-logmatch/pkg/match"
+	"github.com/rs/zerolog/log"
+)
+
+// A rule whose conditions are themselves sets or sequences, each bound to
+// its own event source, correlates across sources. The conditions are
+// matched per source as usual; their hits are then fed, in time order, to a
+// machine that applies the outer set or sequence to them. Correlation
+// labels partition the machine so that only hits from sources that agree on
+// those labels (e.g. the same hostname) are combined.
+
+var (
+	ErrMachineTerm = errors.New("condition hit without term index")
+)
+
+const (
+	correlateBacklog = 1 << 16 // condition hits a machine holds for slower sources
+)
+
+// machinePlugin compiles the cluster scoped nodes of a rule tree, i.e. the
+// sets and sequences that combine other conditions.
+type machinePlugin struct{}
+
+func (machinePlugin) Compile(_ compiler.RuntimeI, node *ast.AstNodeT) (compiler.ObjsT, error) {
+
+	switch node.Metadata.Type {
+	case schema.NodeTypeSeq, schema.NodeTypeSet:
+	default:
+		return nil, nil
+	}
+
+	obj := compiler.NewObj(node, compiler.ObjTypeMatcher)
+	obj.Object = node.Object
+
+	return compiler.ObjsT{obj}, nil
+}
+
+type machineT struct {
+	mux     sync.Mutex
+	addr    *ast.AstNodeAddressT
+	parent  *ast.AstNodeAddressT
+	nTerms  int // positive conditions
+	keys    []string
+	horizon int64 // how long a condition hit may still take part in a match
+	build   func() (lm.Matcher, error)
+	parts   map[string]*partT
+	pending []termHitT
+	state   *stateT
+	report  reportFn // where the hits routed to the machine were bound
+	over    bool     // backlog exceeded; warned once
+}
+
+// partT is the state of the machine for one set of correlation values.
+type partT struct {
+	id    string // in the spill store
+	mm    lm.Matcher
+	hits  map[termKeyT][]matchz.HitsT
+	clock int64
+}
+
+type termKeyT struct {
+	term int
+	ts   int64
+}
+
+type termHitT struct {
+	term int
+	ts   int64
+	m    matchz.HitsT
+}
+
+// newMachine returns nil for a machine with a single condition; it wraps a
+// rule bound to one source and its hits are reported as they are.
+func newMachine(obj *compiler.ObjT) (*machineT, error) {
+
+	var (
+		match, negate []*ast.AstMetadataT
+		keys          []string
+		window        int64
+		seq           bool
+	)
+
+	switch o := obj.Object.(type) {
+	case *ast.AstSeqMatcherT:
+		match, negate, keys, window, seq = o.Order, o.Negate, o.Correlations, o.Window.Nanoseconds(), true
+	case *ast.AstSetMatcherT:
+		match, negate, keys, window = o.Match, o.Negate, o.Correlations, o.Window.Nanoseconds()
+	default:
+		return nil, ErrUnknownObjectType
+	}
+
+	if len(match)+len(negate) <= 1 {
+		return nil, nil
+	}
+
+	var (
+		terms   = make([]lm.TermT, 0, len(match))
+		resets  = make([]lm.ResetT, 0, len(negate))
+		horizon = window
+	)
+
+	for _, md := range match {
+		idx, err := md.Address.GetTermIdx()
+		if err != nil {
+			return nil, ErrMachineTerm
+		}
+		terms = append(terms, lm.TermT{Type: lm.TermRaw, Value: termLine(int(idx))})
+	}
+
+	for _, md := range negate {
+		idx, err := md.Address.GetTermIdx()
+		if err != nil {
+			return nil, ErrMachineTerm
+		}
+		reset := lm.ResetT{Term: lm.TermT{Type: lm.TermRaw, Value: termLine(int(idx))}}
+		if o := md.NegateOpts; o != nil {
+			reset.Window = o.Window.Nanoseconds()
+			reset.Slide = o.Slide.Nanoseconds()
+			reset.Anchor = uint8(o.Anchor)
+			reset.Absolute = o.Absolute
+			horizon = max(horizon, window+reset.Window+max(reset.Slide, -reset.Slide))
+		}
+		resets = append(resets, reset)
+	}
+
+	build := func() (lm.Matcher, error) {
+		switch {
+		case len(resets) > 0 && seq:
+			return lm.NewInverseSeq(window, terms, resets)
+		case len(resets) > 0:
+			return lm.NewInverseSet(window, terms, resets)
+		case len(terms) == 1:
+			return lm.NewMatchSingle(terms[0])
+		case seq:
+			return lm.NewMatchSeq(window, terms...)
+		default:
+			return lm.NewMatchSet(window, terms...)
+		}
+	}
+
+	// Fail at load time rather than on the first hit
+	if _, err := build(); err != nil {
+		return nil, err
+	}
+
+	return &machineT{
+		addr:    obj.Address,
+		parent:  obj.ParentAddress,
+		nTerms:  len(terms),
+		keys:    keys,
+		horizon: horizon,
+		build:   build,
+		parts:   make(map[string]*partT),
+	}, nil
+}
+
+// stateT bounds the condition hits that every machine holds while they
+// wait for the rest of a match. Past its budget the hits of the least
+// recently active partitions are spilled to disk, and read back when the
+// partition is next hit. The matchers themselves only hold term markers.
+// One lock covers the partitions of all machines, so any may be spilled.
+type stateT struct {
+	mux   sync.Mutex
+	store *spillz.StoreT[partRecT]
+	parts map[string]*partT
+}
+
+type partRecT struct {
+	Term int          `json:"term"`
+	Ts   int64        `json:"ts"`
+	Hits matchz.HitsT `json:"hits"`
+}
+
+func newState(limit int64, dir string) *stateT {
+	return &stateT{
+		store: spillz.New[partRecT](limit, dir),
+		parts: make(map[string]*partT),
+	}
+}
+
+func (st *stateT) lock() {
+	if st != nil {
+		st.mux.Lock()
+	}
+}
+
+func (st *stateT) unlock() {
+	if st != nil {
+		st.mux.Unlock()
+	}
+}
+
+// add accounts for m held by part and spills the partitions it pushes
+// over the budget.
+func (st *stateT) add(part *partT, m matchz.HitsT) {
+	if st == nil {
+		return
+	}
+
+	st.parts[part.id] = part
+
+	for _, victim := range st.store.Add(part.id, m.Size()) {
+		st.spill(st.parts[victim])
+	}
+}
+
+// release accounts for m no longer held by part.
+func (st *stateT) release(part *partT, m matchz.HitsT) {
+	if st != nil {
+		st.store.Release(part.id, m.Size())
+	}
+}
+
+func (st *stateT) spill(part *partT) {
+
+	var recs []partRecT
+	for tk, ms := range part.hits {
+		for _, m := range ms {
+			recs = append(recs, partRecT{Term: tk.term, Ts: tk.ts, Hits: m})
+		}
+	}
+
+	if st.store.Spilled() == 0 {
+		log.Warn().
+			Int64("limit", st.store.Limit()).
+			Msg("Memory limit reached; spilling correlation state to disk")
+	}
+
+	if err := st.store.Write(part.id, recs); err != nil {
+		log.Error().Err(err).Str("part", part.id).Msg("Failed to spill correlation state. Keeping it in memory")
+		return
+	}
+	clear(part.hits)
+}
+
+// restore reads the spilled hits of part back, ahead of those held since.
+func (st *stateT) restore(part *partT) {
+	if st == nil || !st.store.Spills(part.id) {
+		return
+	}
+
+	recs, err := st.store.Read(part.id)
+	if err != nil {
+		log.Error().Err(err).Str("part", part.id).Msg("Failed to read spilled correlation state")
+		return
+	}
+	st.store.Forget(part.id)
+
+	spilled := make(map[termKeyT][]matchz.HitsT)
+	for _, rec := range recs {
+		tk := termKeyT{term: rec.Term, ts: rec.Ts}
+		spilled[tk] = append(spilled[tk], rec.Hits)
+	}
+	for tk, ms := range spilled {
+		part.hits[tk] = append(ms, part.hits[tk]...)
+	}
+
+	for _, ms := range part.hits {
+		for _, m := range ms {
+			st.add(part, m)
+		}
+	}
+}
+
+func (st *stateT) spilled() int64 {
+	if st == nil {
+		return 0
+	}
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	return st.store.Spilled()
+}
+
+func (st *stateT) close() error {
+	if st == nil {
+		return nil
+	}
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	return st.store.Close()
+}
+
+// termLine stands for a hit of the condition at idx when fed to the
+// machine's matcher.
+func termLine(idx int) string {
+	return fmt.Sprintf("\x00term%d\x00", idx)
+}
+
+// add queues a hit of the condition at term.
+func (mc *machineT) add(term int, m matchz.HitsT, report reportFn) {
+
+	var ts int64
+	for _, e := range m.Entries {
+		ts = max(ts, e.Timestamp)
+	}
+
+	mc.mux.Lock()
+	mc.pending = append(mc.pending, termHitT{term: term, ts: ts, m: m})
+	mc.report = report
+	mc.mux.Unlock()
+}
+
+func (mc *machineT) reporter() reportFn {
+	mc.mux.Lock()
+	defer mc.mux.Unlock()
+	return mc.report
+}
+
+// drain feeds the queued hits up to the mark to the machine in time order
+// and returns the matches completed. A condition hit counts at the time it
+// completed, i.e. its last entry. Hits past the mark wait for the sources
+// that may still precede them, up to the backlog; beyond it the oldest are
+// fed regardless.
+func (mc *machineT) drain(mark int64) ([]matchz.HitsT, error) {
+
+	mc.mux.Lock()
+	defer mc.mux.Unlock()
+
+	slices.SortStableFunc(mc.pending, func(a, b termHitT) int {
+		return cmp.Compare(a.ts, b.ts)
+	})
+
+	n, _ := slices.BinarySearchFunc(mc.pending, mark, func(th termHitT, mark int64) int {
+		if th.ts <= mark {
+			return -1
+		}
+		return 1
+	})
+
+	if over := len(mc.pending) - n - correlateBacklog; over > 0 {
+		if !mc.over {
+			log.Warn().
+				Str("rule", mc.addr.GetRuleHash()).
+				Int("backlog", correlateBacklog).
+				Msg("Correlation backlog full; matching ahead of slower sources")
+			mc.over = true
+		}
+		n += over
+	}
+
+	if n == 0 {
+		return nil, nil
+	}
+
+	pending := mc.pending[:n]
+	mc.pending = slices.Clone(mc.pending[n:])
+
+	mc.state.lock()
+	defer mc.state.unlock()
+
+	var out []matchz.HitsT
+	for _, th := range pending {
+
+		key := mc.partKey(th.m.Entity.Labels)
+
+		part, ok := mc.parts[key]
+		if !ok {
+			mm, err := mc.build()
+			if err != nil {
+				return out, err
+			}
+			part = &partT{
+				id:   mc.addr.String() + "\x00" + key,
+				mm:   mm,
+				hits: make(map[termKeyT][]matchz.HitsT),
+			}
+			mc.parts[key] = part
+		}
+
+		mc.state.restore(part)
+
+		tk := termKeyT{term: th.term, ts: th.ts}
+		part.hits[tk] = append(part.hits[tk], th.m)
+		part.clock = max(part.clock, th.ts)
+		mc.state.add(part, th.m)
+
+		hits := part.mm.Scan(entry.LogEntry{Timestamp: th.ts, Line: termLine(th.term)})
+		out = append(out, mc.collect(part, hits)...)
+
+		mc.prune(part)
+	}
+
+	return out, nil
+}
+
+func (mc *machineT) partKey(labels map[string]string) string {
+	if len(mc.keys) == 0 {
+		return ""
+	}
+	vals := make([]string, 0, len(mc.keys))
+	for _, k := range mc.keys {
+		vals = append(vals, labels[k])
+	}
+	return strings.Join(vals, "\x00")
+}
+
+// collect ties each match of the machine back to the condition hits it
+// consumed and merges them into one hit.
+func (mc *machineT) collect(part *partT, hits lm.Hits) []matchz.HitsT {
+
+	if hits.Cnt == 0 {
+		return nil
+	}
+
+	out := make([]matchz.HitsT, 0, hits.Cnt)
+	for logs := range slices.Chunk(hits.Logs, mc.nTerms) {
+
+		var (
+			m     matchz.HitsT
+			first = true
+		)
+
+		// A match of replayed hits alone was reported by an earlier run
+		m.Replay = true
+
+		for _, l := range logs {
+			var term int
+			if _, err := fmt.Sscanf(strings.Trim(l.Line, "\x00"), "term%d", &term); err != nil {
+				continue
+			}
+
+			tk := termKeyT{term: term, ts: l.Timestamp}
+			queue := part.hits[tk]
+			if len(queue) == 0 {
+				log.Warn().Int("term", term).Int64("ts", l.Timestamp).Msg("Correlated condition hit not found")
+				continue
+			}
+			th := queue[0]
+			mc.state.release(part, th)
+			if len(queue) == 1 {
+				delete(part.hits, tk)
+			} else {
+				part.hits[tk] = queue[1:]
+			}
+
+			if first {
+				m.Entity = th.Entity
+				first = false
+			}
+			m.Entries = append(m.Entries, th.Entries...)
+			m.Replay = m.Replay && th.Replay
+		}
+
+		slices.SortStableFunc(m.Entries, func(a, b matchz.EntryT) int {
+			return cmp.Compare(a.Timestamp, b.Timestamp)
+		})
+
+		m.Count = 1
+		m.Entity.Origin = true
+		out = append(out, m)
+	}
+
+	return out
+}
+
+// prune drops condition hits too old to take part in a match; the matcher
+// collects its own.
+func (mc *machineT) prune(part *partT) {
+	if mc.horizon <= 0 {
+		return
+	}
+
+	mark := part.clock - mc.horizon
+	for tk, ms := range part.hits {
+		if tk.ts < mark {
+			for _, m := range ms {
+				mc.state.release(part, m)
+			}
+			delete(part.hits, tk)
+		}
+	}
+}
+
+// machine returns the correlating machine at addr, if any.
+func (r *RuntimeT) machine(addr *ast.AstNodeAddressT) *machineT {
+	if addr == nil {
+		return nil
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	return r.machines[addr.String()]
+}
+
+func (r *RuntimeT) addMachines(machines map[string]*machineT) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.machines == nil {
+		r.machines = make(map[string]*machineT, len(machines))
+	}
+	for k, mc := range machines {
+		mc.state = r.state
+		r.machines[k] = mc
+	}
+}
+
+// marksT tracks how far each source has been read. A hit stamped at or
+// before the lowest mark cannot be preceded by one still to come, so the
+// machines correlate up to it.
+type marksT struct {
+	srcs []atomic.Int64
+}
+
+func newMarks(n int) *marksT {
+	return &marksT{srcs: make([]atomic.Int64, n)}
+}
+
+func (mk *marksT) at(i int) *atomic.Int64 {
+	if mk == nil {
+		return nil
+	}
+	return &mk.srcs[i]
+}
+
+// low returns the lowest mark, or no limit when no sources are tracked.
+func (mk *marksT) low() int64 {
+	low := int64(math.MaxInt64)
+	if mk == nil {
+		return low
+	}
+	for i := range mk.srcs {
+		low = min(low, mk.srcs[i].Load())
+	}
+	return low
+}
+
+// advance moves the mark of a source on to ts. Only the source's own
+// goroutine moves it.
+func advance(mark *atomic.Int64, ts int64) {
+	if mark != nil && ts > mark.Load() {
+		mark.Store(ts)
+	}
+}
+
+// correlate drains every machine up to mark, innermost first so that
+// nested matches reach their parents in the same pass. Machines report
+// where their hits were bound unless report is given.
+func (r *RuntimeT) correlate(report reportFn, mark int64) error {
+
+	r.mux.RLock()
+	machines := make([]*machineT, 0, len(r.machines))
+	for _, mc := range r.machines {
+		machines = append(machines, mc)
+	}
+	r.mux.RUnlock()
+
+	slices.SortFunc(machines, func(a, b *machineT) int {
+		return cmp.Compare(b.addr.GetDepth(), a.addr.GetDepth())
+	})
+
+	for _, mc := range machines {
+		rep := report
+		if rep == nil {
+			rep = mc.reporter()
+		}
+		if rep == nil {
+			continue
+		}
+		if err := r.release(mc, rep, mark); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// settle correlates as far as every source has been read.
+func (r *RuntimeT) settle() {
+	if err := r.correlate(nil, r.marks.low()); err != nil {
+		log.Error().Err(err).Msg("Failed to correlate sources")
+	}
+}
+
+// release drains mc up to mark and routes its matches on.
+func (r *RuntimeT) release(mc *machineT, report reportFn, mark int64) error {
+
+	hits, err := mc.drain(mark)
+	for _, m := range hits {
+		if rerr := r.route(mc.addr, mc.parent, m, report); rerr != nil {
+			return rerr
+		}
+	}
+
+	return err
+}
+
+type reportFn func(ruleHash string, m matchz.HitsT) error
+
+// route hands a hit of the node at addr to the machine above it, or to the
+// report when no machine correlates it. The machine correlates the hits it
+// holds as far as every source has been read.
+func (r *RuntimeT) route(addr, parent *ast.AstNodeAddressT, m matchz.HitsT, report reportFn) error {
+
+	mc := r.machine(parent)
+	if mc == nil {
+		if m.Replay {
+			return nil
+		}
+		return report(addr.GetRuleHash(), m)
+	}
+
+	idx, err := addr.GetTermIdx()
+	if err != nil {
+		return ErrMachineTerm
+	}
+
+	mc.add(int(idx), m, report)
+
+	return r.release(mc, report, r.marks.low())
+}
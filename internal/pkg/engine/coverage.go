@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Coverage tells which rules could have detected anything in the sources
+// of a run. A rule applies to the sources whose type is the event source
+// of one of its conditions; stdin and archives apply to every rule. A rule
+// that applies to none of the sources is skipped, and one that correlates
+// several sources with only some of them present can never fire.
+
+type CoverageStatusT string
+
+const (
+	CoverageDetected CoverageStatusT = "detected"
+	CoverageQuiet    CoverageStatusT = "quiet"   // applied but found nothing
+	CoveragePartial  CoverageStatusT = "partial" // some of its sources missing
+	CoverageSkipped  CoverageStatusT = "skipped" // none of its sources present
+)
+
+// CoverageT records the sources each rule was applied to during a run. A
+// nil *CoverageT records nothing.
+type CoverageT struct {
+	mux   sync.Mutex
+	rules map[string]*ruleCoverageT // by rule hash
+}
+
+type ruleCoverageT struct {
+	creId      string
+	sources    map[string]struct{} // event sources of its conditions
+	applied    map[string]struct{} // of those, scanned
+	detections int
+}
+
+// RuleCoverageT is the coverage of one rule.
+type RuleCoverageT struct {
+	CreId      string          `json:"cre_id"`
+	RuleHash   string          `json:"rule_hash"`
+	Status     CoverageStatusT `json:"status"`
+	Sources    []string        `json:"sources"`
+	Missing    []string        `json:"missing,omitempty"`
+	Detections int             `json:"detections"`
+}
+
+func NewCoverage() *CoverageT {
+	return &CoverageT{rules: make(map[string]*ruleCoverageT)}
+}
+
+// SetCoverage records the coverage of the rules in c.
+func (r *RuntimeT) SetCoverage(c *CoverageT) {
+	r.coverage = c
+}
+
+// addRules notes the event sources of the conditions of the rules of m.
+func (c *CoverageT) addRules(m *RuleMatchersT, creId func(string) string) {
+	if c == nil || m == nil {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for key, pe := range m.eventSrc {
+		c.rule(m.hash[key], creId).sources[pe.Source] = struct{}{}
+	}
+}
+
+// apply notes that a condition of the rule on eventSrc was bound to a
+// source.
+func (c *CoverageT) apply(ruleHash, eventSrc string) {
+	if c == nil {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if rc, ok := c.rules[ruleHash]; ok {
+		rc.applied[eventSrc] = struct{}{}
+	}
+}
+
+// detect counts a detection of the rule.
+func (c *CoverageT) detect(ruleHash string) {
+	if c == nil {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if rc, ok := c.rules[ruleHash]; ok {
+		rc.detections++
+	}
+}
+
+// rule returns the coverage of ruleHash, adding it if new. c.mux is held.
+func (c *CoverageT) rule(ruleHash string, creId func(string) string) *ruleCoverageT {
+	rc, ok := c.rules[ruleHash]
+	if !ok {
+		rc = &ruleCoverageT{
+			creId:   creId(ruleHash),
+			sources: make(map[string]struct{}),
+			applied: make(map[string]struct{}),
+		}
+		c.rules[ruleHash] = rc
+	}
+	return rc
+}
+
+// Rules returns the coverage of every rule, skipped first, then partial,
+// quiet and detected, each by CRE id.
+func (c *CoverageT) Rules() []RuleCoverageT {
+	if c == nil {
+		return nil
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	out := make([]RuleCoverageT, 0, len(c.rules))
+	for hash, rc := range c.rules {
+
+		cov := RuleCoverageT{
+			CreId:      rc.creId,
+			RuleHash:   hash,
+			Detections: rc.detections,
+		}
+
+		for src := range rc.sources {
+			cov.Sources = append(cov.Sources, src)
+			if _, ok := rc.applied[src]; !ok {
+				cov.Missing = append(cov.Missing, src)
+			}
+		}
+		slices.Sort(cov.Sources)
+		slices.Sort(cov.Missing)
+
+		switch {
+		case rc.detections > 0:
+			cov.Status = CoverageDetected
+		case len(rc.applied) == 0:
+			cov.Status = CoverageSkipped
+		case len(cov.Missing) > 0:
+			cov.Status = CoveragePartial
+		default:
+			cov.Status = CoverageQuiet
+		}
+
+		out = append(out, cov)
+	}
+
+	order := map[CoverageStatusT]int{CoverageSkipped: 0, CoveragePartial: 1, CoverageQuiet: 2, CoverageDetected: 3}
+	slices.SortFunc(out, func(a, b RuleCoverageT) int {
+		if n := cmp.Compare(order[a.Status], order[b.Status]); n != 0 {
+			return n
+		}
+		if n := cmp.Compare(a.CreId, b.CreId); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.RuleHash, b.RuleHash)
+	})
+
+	return out
+}
+
+// Fprint writes the coverage as a table, with a count of rules by status.
+func (c *CoverageT) Fprint(w io.Writer) {
+
+	rules := c.Rules()
+
+	counts := make(map[CoverageStatusT]int)
+	for _, rc := range rules {
+		counts[rc.Status]++
+	}
+
+	fmt.Fprintf(w, "\nRule coverage (%d rules: %d detected, %d quiet, %d partial, %d skipped):\n",
+		len(rules), counts[CoverageDetected], counts[CoverageQuiet], counts[CoveragePartial], counts[CoverageSkipped])
+	fmt.Fprintf(w, "  %-9s %-20s %-24s %10s  %s\n", "STATUS", "CRE", "RULE HASH", "DETECTIONS", "MISSING SOURCES")
+
+	for _, rc := range rules {
+		fmt.Fprintf(w, "  %-9s %-20s %-24s %10d  %s\n",
+			rc.Status,
+			rc.CreId,
+			rc.RuleHash,
+			rc.Detections,
+			strings.Join(rc.Missing, ", "),
+		)
+	}
+}
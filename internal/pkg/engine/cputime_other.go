@@ -0,0 +1,13 @@
+//go:build !linux
+
+package engine
+
+import "time"
+
+var epoch = time.Now()
+
+// threadTime falls back to the time since start where the CPU time of a
+// thread cannot be read.
+func threadTime() time.Duration {
+	return time.Since(epoch)
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/prequel-dev/preq/internal/pkg/deprecz"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"github.com/rs/zerolog/log"
+)
+
+// SetMapDeprecated reports the detections of a deprecated CRE under the CRE
+// that supersedes it, if its rules are loaded.
+func (r *RuntimeT) SetMapDeprecated(on bool) {
+	r.mapDeprec = on
+}
+
+// Deprecation returns the deprecation of creId, if the loaded rules
+// deprecate it.
+func (r *RuntimeT) Deprecation(creId string) (deprecz.DeprecationT, bool) {
+	return r.deprecs.Lookup(creId)
+}
+
+// Deprecated returns the deprecated CREs detected so far, by CRE id.
+func (r *RuntimeT) Deprecated() []deprecz.DeprecationT {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	out := make([]deprecz.DeprecationT, 0, len(r.fired))
+	for id := range r.fired {
+		if d, ok := r.deprecs.Lookup(id); ok {
+			out = append(out, d)
+		}
+	}
+
+	slices.SortFunc(out, func(a, b deprecz.DeprecationT) int {
+		return cmp.Compare(a.CreId, b.CreId)
+	})
+
+	return out
+}
+
+// deprecated notes a detection of cre if it is deprecated, and returns the
+// CRE to report it under.
+func (r *RuntimeT) deprecated(cre parser.ParseCreT) parser.ParseCreT {
+
+	d, ok := r.deprecs.Lookup(cre.Id)
+	if !ok {
+		return cre
+	}
+
+	r.mux.Lock()
+	if _, seen := r.fired[cre.Id]; !seen {
+		if r.fired == nil {
+			r.fired = make(map[string]struct{})
+		}
+		r.fired[cre.Id] = struct{}{}
+		log.Warn().Str("cre", cre.Id).Str("superseded_by", d.SupersededBy).Msg("Deprecated CRE detected")
+	}
+	r.mux.Unlock()
+
+	if !r.mapDeprec {
+		return cre
+	}
+
+	next := r.deprecs.Successor(cre.Id)
+	if next == cre.Id {
+		return cre
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	for _, c := range r.Rules {
+		if strings.EqualFold(c.Id, next) {
+			return c
+		}
+	}
+
+	return cre
+}
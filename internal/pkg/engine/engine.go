@@ -2,21 +2,33 @@ package engine
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"os"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/Masterminds/semver"
+	"github.com/prequel-dev/preq/internal/pkg/celz"
+	"github.com/prequel-dev/preq/internal/pkg/checkpoint"
+	"github.com/prequel-dev/preq/internal/pkg/decisionz"
+	"github.com/prequel-dev/preq/internal/pkg/deprecz"
+	"github.com/prequel-dev/preq/internal/pkg/literalz"
 	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/prequel-dev/preq/internal/pkg/pluginz"
+	"github.com/prequel-dev/preq/internal/pkg/queuez"
+	"github.com/prequel-dev/preq/internal/pkg/ratez"
 	"github.com/prequel-dev/preq/internal/pkg/resolve"
 	"github.com/prequel-dev/preq/internal/pkg/utils"
 	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/prequel-dev/prequel-compiler/pkg/ast"
 	"github.com/prequel-dev/prequel-compiler/pkg/compiler"
 	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
 	"github.com/prequel-dev/prequel-compiler/pkg/parser"
@@ -34,6 +46,8 @@ type LogData = resolve.LogData
 
 const (
 	ramLimit   = 512 << 20                // 512 MiB
+	cpEvery    = 4096                     // lines between checkpoint marks
+	idleTick   = time.Second              // how often a followed source's idle time is checked
 	futureMark = int64(math.MaxInt64) - 1 // Avoid future issues with MaxInt64 used as a flag
 )
 
@@ -47,22 +61,170 @@ var (
 )
 
 type RuntimeT struct {
-	mux   sync.RWMutex
-	Stop  int64
-	Ux    ux.UxFactoryI
-	Rules map[string]parser.ParseCreT
+	mux         sync.RWMutex
+	Stop        int64
+	Ux          ux.UxFactoryI
+	Rules       map[string]parser.ParseCreT
+	decisions   *decisionz.WriterT
+	stats       RunStatsT
+	pool        chan struct{}
+	sampling    ux.SamplingT
+	collapse    bool
+	cp          *checkpoint.StoreT
+	onDetect    func(ux.ReportDocT)
+	profile     *ProfileT
+	coverage    *CoverageT
+	deprecs     *deprecz.SetT
+	mapDeprec   bool                // report deprecated CREs as their successor
+	fired       map[string]struct{} // deprecated CREs detected
+	memLimit    int                 // per source reorder buffer
+	memTotal    int64               // reorder buffers across sources, if set
+	state       *stateT             // condition hits correlations hold
+	marks       *marksT             // how far each source has been read
+	explain     *ExplainT
+	context     int
+	follow      bool
+	machines    map[string]*machineT
+	cel         *celz.ProgramsT
+	rates       *ratez.RatesT
+	plugins     *pluginz.HostT
+	extracts    map[string][]*extractorT // by rule hash
+	minSev      *uint
+	include     []string            // tags or technologies a rule needs one of
+	exclude     []string            // tags or technologies that drop a rule
+	only        []string            // CRE ids a rule needs to be one of
+	disable     []string            // CRE ids that drop a rule
+	live        *RuleMatchersT      // the rules Run applies
+	gen         atomic.Uint64       // bumped when live is swapped
+	prints      map[string]string   // rule fingerprints by hash
+	literals    map[string][]string // prefilter literals by rule hash
+	repeats     map[string]int      // lines of one run a match may need, by rule hash
+	horizons    map[string]int64    // how far back a match may reach, by rule hash
+	markers     map[string][]string // condition markers by rule hash
+	queueSize   int
+	queuePolicy queuez.PolicyT
+	dropped     atomic.Int64  // lines dropped by full queues in the last Run
+	breaker     *breakerT     // disables rules that exceed the rule timeout
+	lookback    time.Duration // history kept per followed source
+	conflicts   []RuleConflictT
+}
+
+// RunStatsT summarizes a completed Run.
+type RunStatsT struct {
+	Sources   int
+	Lines     int64
+	Collapsed int64 // duplicate lines dropped before matching
+	Dropped   int64 // lines dropped by full queues
+	Rules     int
+	Duration  time.Duration
 }
 
 func New(stop int64, ux ux.UxFactoryI) *RuntimeT {
+
+	cel, err := celz.New()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create CEL environment; cel conditions are not supported")
+	}
+
 	return &RuntimeT{
-		Stop:  stop,
-		Rules: make(map[string]parser.ParseCreT),
-		Ux:    ux,
+		Stop:     stop,
+		Rules:    make(map[string]parser.ParseCreT),
+		Ux:       ux,
+		memLimit: ramLimit,
+		cel:      cel,
+		rates:    ratez.New(),
+		deprecs:  deprecz.New(),
 	}
 }
 
 func (r *RuntimeT) Close() error {
-	return nil
+	return errors.Join(r.plugins.Close(), r.state.close())
+}
+
+// SetDecisionLog records every rule firing and a per source summary to w.
+// The caller owns w and closes it after Run returns.
+func (r *RuntimeT) SetDecisionLog(w *decisionz.WriterT) {
+	r.decisions = w
+}
+
+// SetSampling scans only a fraction of each source's lines, and at most
+// a budget of lines per source.
+func (r *RuntimeT) SetSampling(s ux.SamplingT) {
+	r.sampling = s
+}
+
+// SetCheckpoint records how far each source has been scanned in cp.
+func (r *RuntimeT) SetCheckpoint(cp *checkpoint.StoreT) {
+	r.cp = cp
+}
+
+// SetOnDetection calls cb with each detection as Run finds it, as a one
+// entry report holding the hit, in addition to recording it in the report.
+// cb must be safe for concurrent use; it runs on the source's goroutine, so
+// a slow cb holds up that source.
+func (r *RuntimeT) SetOnDetection(cb func(ux.ReportDocT)) {
+	r.onDetect = cb
+}
+
+// SetProfile records the time each rule's matcher takes and the events it
+// examines in p.
+func (r *RuntimeT) SetProfile(p *ProfileT) {
+	r.profile = p
+}
+
+// SetMemoryLimit bounds the buffers that reorder entries within their
+// windows to reorder bytes across all sources, and the condition hits that
+// correlations hold to state bytes; beyond it, the least recently active
+// are spilled to a temporary file in dir, or the default temporary
+// directory. Call Close to remove the file.
+func (r *RuntimeT) SetMemoryLimit(reorder, state int64, dir string) {
+	r.memTotal = reorder
+	r.state = newState(state, dir)
+}
+
+// Spilled returns the bytes of correlation state moved to disk by the
+// memory limit.
+func (r *RuntimeT) Spilled() int64 {
+	return r.state.spilled()
+}
+
+// SetMinSeverity loads only the rules of CREs at least as severe as sev.
+// Lower values are more severe.
+func (r *RuntimeT) SetMinSeverity(sev uint) {
+	r.minSev = &sev
+}
+
+// SetTags loads only the rules of CREs with a tag or technology in
+// include, if any, and none in exclude. Comparisons ignore case.
+func (r *RuntimeT) SetTags(include, exclude []string) {
+	r.include, r.exclude = include, exclude
+}
+
+// SetMatcherPlugins lets rules hand conditions to the plugins of h. It must
+// be called before the rules are loaded.
+func (r *RuntimeT) SetMatcherPlugins(h *pluginz.HostT) {
+	r.plugins = h
+}
+
+// SetCres loads only the rules of the CRE ids in only, if any, and none of
+// those in disable. Comparisons ignore case.
+func (r *RuntimeT) SetCres(only, disable []string) {
+	r.only, r.disable = only, disable
+}
+
+// SetFollow notes that sources are followed, so time passes while no lines
+// arrive. Pending negative conditions, e.g. an expected line that never
+// comes after a trigger, are then decided as their windows close on the
+// wall clock rather than only when the next line is read.
+func (r *RuntimeT) SetFollow(follow bool) {
+	r.follow = follow
+}
+
+// Stats returns the counters of the last Run.
+func (r *RuntimeT) Stats() RunStatsT {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.stats
 }
 
 func GetEventSource(obj *compiler.ObjT) parser.ParseEventT {
@@ -74,26 +236,38 @@ func GetEventSource(obj *compiler.ObjT) parser.ParseEventT {
 
 func compileRuleTree(cf compiler.RuntimeI, tree *parser.TreeT) (compiler.ObjsT, error) {
 	var (
-		err      error
-		nodeObjs compiler.ObjsT
+		err         error
+		astTree     *ast.AstT
+		nodeObjs    compiler.ObjsT
+		machineObjs compiler.ObjsT
 	)
 
+	if astTree, err = ast.BuildTree(tree); err != nil {
+		return nil, err
+	}
+
 	opts := []compiler.CompilerOptT{
 		compiler.WithRuntime(cf),
-		compiler.WithPlugin(schema.ScopeNode, compiler.NewDefaultPlugin()),
+		compiler.WithPlugin(schema.ScopeNode, nodePlugin{}),
+		compiler.WithPlugin(schema.ScopeCluster, machinePlugin{}),
 	}
 
-	if nodeObjs, err = compiler.CompileTree(tree, schema.ScopeNode, opts...); err != nil {
+	if nodeObjs, err = compiler.CompileAst(astTree, schema.ScopeNode, opts...); err != nil {
 		return nil, err
 	}
 
-	return nodeObjs, nil
+	// The sets and sequences that combine conditions across sources
+	if machineObjs, err = compiler.CompileAst(astTree, schema.ScopeCluster, opts...); err != nil {
+		return nil, err
+	}
+
+	return append(nodeObjs, machineObjs...), nil
 }
 
-func compileRulePath(cf compiler.RuntimeI, rp utils.RulePathT) (compiler.ObjsT, *parser.RulesT, error) {
+func compileRulePath(cf compiler.RuntimeI, rp utils.RulePathT, extra ...utils.ReaderOptT) (compiler.ObjsT, *parser.RulesT, error) {
 	var (
 		rs        *parser.RulesT
-		rdrOpts   = make([]utils.ReaderOptT, 0)
+		rdrOpts   = slices.Clone(extra)
 		parseOpts = make([]parser.ParseOptT, 0)
 		err       error
 	)
@@ -117,10 +291,10 @@ func compileRulePath(cf compiler.RuntimeI, rp utils.RulePathT) (compiler.ObjsT,
 	return doCompileRule(cf, rs, parseOpts)
 }
 
-func compileRule(cf compiler.RuntimeI, data []byte) (compiler.ObjsT, *parser.RulesT, error) {
+func compileRule(cf compiler.RuntimeI, data []byte, extra ...utils.ReaderOptT) (compiler.ObjsT, *parser.RulesT, error) {
 	var (
 		rules     *parser.RulesT
-		rdrOpts   = make([]utils.ReaderOptT, 0)
+		rdrOpts   = slices.Clone(extra)
 		parseOpts = make([]parser.ParseOptT, 0)
 		err       error
 	)
@@ -187,7 +361,7 @@ func (r *RuntimeT) compileRules(cf compiler.RuntimeI, data []byte) (compiler.Obj
 		ok    bool
 	)
 
-	if nObjs, rules, err = compileRule(cf, data); err != nil {
+	if nObjs, rules, err = compileRule(cf, data, r.readerOpts()...); err != nil {
 		log.Error().Err(err).Msg("Failed to compile rule")
 		return nil, nil, err
 	}
@@ -207,11 +381,12 @@ func (r *RuntimeT) compileRulesPaths(cf compiler.RuntimeI, paths []utils.RulePat
 	var (
 		nodeObjs = make(compiler.ObjsT, 0)
 		allRules = make([]*parser.RulesT, 0)
+		merge    = newMerge()
 
 		err error
 	)
 
-	for _, path := range paths {
+	for _, path := range byPriority(paths) {
 
 		var (
 			nObjs compiler.ObjsT
@@ -219,7 +394,7 @@ func (r *RuntimeT) compileRulesPaths(cf compiler.RuntimeI, paths []utils.RulePat
 			ok    bool
 		)
 
-		if nObjs, rules, err = compileRulePath(cf, path); err != nil {
+		if nObjs, rules, err = compileRulePath(cf, path, append(r.readerOpts(), merge.filter(path))...); err != nil {
 			return nil, nil, err
 		}
 
@@ -229,14 +404,88 @@ func (r *RuntimeT) compileRulesPaths(cf compiler.RuntimeI, paths []utils.RulePat
 			return nil, nil, err
 		}
 
+		merge.add(path, rules)
+
 		nodeObjs = append(nodeObjs, nObjs...)
 
 		allRules = append(allRules, rules)
 	}
 
+	r.mux.Lock()
+	r.conflicts = merge.conflicts
+	r.mux.Unlock()
+
 	return nodeObjs, allRules, nil
 }
 
+// readerOpts notes deprecated CREs and swaps cel, plugin and rate
+// conditions for terms the matchers understand as the rules are read, and
+// drops the rules below the minimum severity, outside the tags or outside
+// the CRE ids. Rates go last so that they may count the other conditions.
+func (r *RuntimeT) readerOpts() []utils.ReaderOptT {
+	opts := []utils.ReaderOptT{utils.WithRewrite(r.deprecs.Scan)}
+	if r.cel != nil {
+		opts = append(opts, utils.WithRewrite(r.cel.Rewrite))
+	}
+	opts = append(opts, utils.WithRewrite(r.plugins.Rewrite))
+	if r.rates != nil {
+		opts = append(opts, utils.WithRewrite(r.rates.Rewrite))
+	}
+	if r.minSev != nil {
+		minSev := *r.minSev
+		opts = append(opts, utils.WithFilter(func(rule parser.ParseRuleT) bool {
+			return rule.Cre.Severity <= minSev
+		}))
+	}
+	if len(r.include) > 0 || len(r.exclude) > 0 {
+		include, exclude := r.include, r.exclude
+		opts = append(opts, utils.WithFilter(func(rule parser.ParseRuleT) bool {
+			return (len(include) == 0 || hasTag(rule.Cre, include)) && !hasTag(rule.Cre, exclude)
+		}))
+	}
+	if len(r.only) > 0 || len(r.disable) > 0 {
+		only, disable := r.only, r.disable
+		opts = append(opts, utils.WithFilter(func(rule parser.ParseRuleT) bool {
+			is := func(id string) bool { return strings.EqualFold(id, rule.Cre.Id) }
+			return (len(only) == 0 || slices.ContainsFunc(only, is)) && !slices.ContainsFunc(disable, is)
+		}))
+	}
+	return opts
+}
+
+// hasTag reports whether cre has one of tags as a tag or technology.
+func hasTag(cre parser.ParseCreT, tags []string) bool {
+	for _, tag := range tags {
+		if slices.ContainsFunc(cre.Tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			return true
+		}
+		if slices.ContainsFunc(cre.Applications, func(app parser.ParseApplicationT) bool { return strings.EqualFold(app.Name, tag) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe labels a raw term that stands for a cel, plugin or rate
+// condition.
+func (r *RuntimeT) describe(value string) (string, bool) {
+	if expr, ok := r.cel.Expr(value); ok {
+		return fmt.Sprintf("cel %q", expr), true
+	}
+	if desc, ok := r.plugins.Expr(value); ok {
+		return "plugin " + desc, true
+	}
+	if desc, ok := r.rates.Expr(value); ok {
+		return "rate " + desc, true
+	}
+	return "", false
+}
+
+// stripMarkers removes the cel, plugin and rate markers appended to a line.
+func stripMarkers(line string) string {
+	return ratez.Strip(pluginz.Strip(celz.Strip(line)))
+}
+
 func validateRule(rule parser.ParseRuleT, dupes map[string]struct{}) (bool, error) {
 
 	if _, ok := dupes[rule.Metadata.Id]; ok {
@@ -288,15 +537,89 @@ func validateRules(rules *parser.RulesT, allRules []*parser.RulesT) (bool, error
 	return true, nil
 }
 
+// RuleMatchersT holds the compiled conditions of the rules by the address
+// of their node in the rule tree; a rule correlating several sources has
+// one per source bound condition. Each source a condition is bound to
+// matches it on its own copy.
 type RuleMatchersT struct {
 	match    map[string]any
+	build    map[string]func() (any, error)
 	cb       map[string]compiler.CallbackT
 	eventSrc map[string]parser.ParseEventT
+	hash     map[string]string
+	machines map[string]*machineT
+
+	mux     sync.Mutex
+	claimed map[string]struct{}
+}
+
+// instance returns the matcher of key for a source to scan: the compiled
+// one for the first source that asks, a new one for every other.
+func (m *RuleMatchersT) instance(key string) (any, error) {
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	build, ok := m.build[key]
+	if _, claimed := m.claimed[key]; !claimed || !ok {
+		if m.claimed == nil {
+			m.claimed = make(map[string]struct{})
+		}
+		m.claimed[key] = struct{}{}
+		return m.match[key], nil
+	}
+
+	return build()
+}
+
+// nodePlugin compiles the log matchers of a rule tree as the default plugin
+// does, keeping how to build them again for further sources.
+type nodePlugin struct{}
+
+type nodeObjT struct {
+	object any
+	build  func() (any, error)
+}
+
+func (nodePlugin) Compile(runtime compiler.RuntimeI, node *ast.AstNodeT) (compiler.ObjsT, error) {
+
+	objs, err := compiler.NewDefaultPlugin().Compile(runtime, node)
+	if err != nil {
+		return nil, err
+	}
+
+	build := func() (any, error) {
+		obj, err := compiler.ObjLogMatcher(runtime, node)
+		if err != nil {
+			return nil, err
+		}
+		return obj.Object, nil
+	}
+
+	for _, obj := range objs {
+		obj.Object = &nodeObjT{object: obj.Object, build: build}
+	}
+
+	return objs, nil
+}
+
+// rules is the number of rules matched.
+func (m *RuleMatchersT) rules() int {
+	hashes := make(map[string]struct{}, len(m.hash))
+	for _, h := range m.hash {
+		hashes[h] = struct{}{}
+	}
+	return len(hashes)
 }
 
 func (r *RuntimeT) AddRules(rules *parser.RulesT) error {
 	r.mux.Lock()
 	defer r.mux.Unlock()
+	return r.addRules(rules)
+}
+
+// addRules is AddRules with the lock held.
+func (r *RuntimeT) addRules(rules *parser.RulesT) error {
 
 	var ok bool
 	for _, rule := range rules.Rules {
@@ -315,6 +638,18 @@ func (r *RuntimeT) AddRules(rules *parser.RulesT) error {
 		}
 	}
 
+	r.addPrints(rules)
+	r.addLiterals(rules)
+	r.addRepeats(rules)
+	r.addHorizons(rules)
+	r.addMarkers(rules)
+
+	if err := r.addExtractors(rules); err != nil {
+		return err
+	}
+
+	r.explain.addRules(rules, r.describe)
+
 	return nil
 }
 
@@ -322,21 +657,46 @@ func loadNodeObjs(objs compiler.ObjsT) (*RuleMatchersT, error) {
 	var (
 		m = &RuleMatchersT{
 			match:    make(map[string]any),
+			build:    make(map[string]func() (any, error)),
 			cb:       make(map[string]compiler.CallbackT),
 			eventSrc: make(map[string]parser.ParseEventT),
+			hash:     make(map[string]string),
+			machines: make(map[string]*machineT),
 		}
 	)
 
 	for _, obj := range objs {
 
-		m.cb[obj.RuleId] = obj.Cb
+		key := obj.Address.String()
+
+		if no, ok := obj.Object.(*nodeObjT); ok {
+			obj.Object, m.build[key] = no.object, no.build
+		}
+
+		switch obj.AbstractType {
+		case schema.NodeTypeSeq, schema.NodeTypeSet:
+			mc, err := newMachine(obj)
+			if err != nil {
+				log.Error().
+					Err(err).
+					Str("rule_id", obj.RuleId).
+					Msg("Failed to build correlation")
+				return nil, err
+			}
+			if mc != nil {
+				m.machines[key] = mc
+			}
+			continue
+		}
+
+		m.cb[key] = obj.Cb
 
 		switch obj.AbstractType {
 		case schema.NodeTypeLogSeq:
 
 			switch o := obj.Object.(type) {
 			case *lm.InverseSeq, *lm.MatchSeq:
-				m.match[obj.RuleId] = o
+				m.match[key] = o
 			default:
 				log.Error().
 					Str("rule_id", obj.RuleId).
@@ -348,7 +708,7 @@ func loadNodeObjs(objs compiler.ObjsT) (*RuleMatchersT, error) {
 		case schema.NodeTypeLogSet:
 			switch o := obj.Object.(type) {
 			case *lm.MatchSingle, *lm.MatchSet, *lm.MatchFunc, *lm.InverseSet:
-				m.match[obj.RuleId] = o
+				m.match[key] = o
 			default:
 				log.Error().
 					Str("rule_id", obj.RuleId).
@@ -366,7 +726,8 @@ func loadNodeObjs(objs compiler.ObjsT) (*RuleMatchersT, error) {
 			return nil, ErrUnknownObjectType
 		}
 
-		m.eventSrc[obj.RuleId] = GetEventSource(obj)
+		m.eventSrc[key] = GetEventSource(obj)
+		m.hash[key] = obj.Address.GetRuleHash()
 	}
 
 	return m, nil
@@ -447,18 +808,33 @@ func (r *RuntimeT) CompileRules(ruleData []byte, report *ux.ReportT) (*RuleMatch
 
 	if matchers, err = loadNodeObjs(nodeObjs); err != nil {
 		log.Error().Err(err).Msg("Failed to load node objects")
+		return nil, err
 	}
 
+	r.addMachines(matchers.machines)
+	r.setLive(matchers)
+
 	return matchers, nil
 }
 
 func (r *RuntimeT) getRuntimeCb(report *ux.ReportT) *runtimeT {
-	var err error
+
+	reportHit := r.reportTo(report)
+
 	runtime := NewRuntime(func(params compiler.MatchParamsT, m matchz.HitsT) error {
+		return r.route(params.Address, params.ParentAddress, m, reportHit)
+	})
+
+	return runtime
+}
+
+// reportTo adds the hits of a rule to report.
+func (r *RuntimeT) reportTo(report *ux.ReportT) reportFn {
+	return func(ruleHash string, m matchz.HitsT) error {
 
 		var (
-			cre      parser.ParseCreT
-			ruleHash = params.Address.GetRuleHash()
+			cre parser.ParseCreT
+			err error
 		)
 
 		if cre, err = r.getCre(ruleHash); err != nil {
@@ -466,6 +842,8 @@ func (r *RuntimeT) getRuntimeCb(report *ux.ReportT) *runtimeT {
 			return err
 		}
 
+		cre = r.deprecated(cre)
+
 		var (
 			ts time.Time
 			ok bool
@@ -492,14 +870,17 @@ func (r *RuntimeT) getRuntimeCb(report *ux.ReportT) *runtimeT {
 				Msg("Related match")
 		}
 
+		r.extractValues(ruleHash, &m)
+
+		r.decisions.Match(ruleHash, cre.Id, m.Entity.FileName, m)
+
 		if ok = report.AddCreHit(&cre, ts, m); ok {
 			r.Ux.IncrementProblemsTracker(1)
+			r.coverage.detect(ruleHash)
 		}
 
 		return nil
-	})
-
-	return runtime
+	}
 }
 
 func (r *RuntimeT) CompileRulesPath(rulesPaths []utils.RulePathT, report *ux.ReportT) (*RuleMatchersT, error) {
@@ -530,6 +911,9 @@ func (r *RuntimeT) CompileRulesPath(rulesPaths []utils.RulePathT, report *ux.Rep
 		return nil, err
 	}
 
+	r.addMachines(matchers.machines)
+	r.setLive(matchers)
+
 	return matchers, nil
 }
 
@@ -563,12 +947,46 @@ func (r *RuntimeT) LoadRulesPaths(rep *ux.ReportT, rulesPaths []utils.RulePathT)
 func (r *RuntimeT) Run(ctx context.Context, ruleMatchers *RuleMatchersT, sources []*LogData, report *ux.ReportT) error {
 
 	var (
-		wg    sync.WaitGroup
-		lines atomic.Int64
-		err   error
+		wg        sync.WaitGroup
+		lines     atomic.Int64
+		collapsed atomic.Int64
+		start     = time.Now()
+		err       error
 	)
 
-	err = r._run(ctx, &wg, sources, ruleMatchers, r.Stop, &lines)
+	defer func() {
+		r.mux.Lock()
+		r.stats = RunStatsT{
+			Sources:   len(sources),
+			Lines:     lines.Load(),
+			Collapsed: collapsed.Load(),
+			Dropped:   r.dropped.Load(),
+			Duration:  time.Since(start),
+		}
+		if ruleMatchers != nil {
+			r.stats.Rules = ruleMatchers.rules()
+		}
+		r.mux.Unlock()
+	}()
+
+	r.dropped.Store(0)
+	r.breaker.reset(report)
+	r.cp.SetReplay(r.maxHorizon())
+
+	if r.memTotal > 0 {
+		r.memLimit = int(r.memTotal / int64(max(1, len(sources))))
+	}
+
+	if r.onDetect != nil {
+		report.Observe(r.onDetect)
+	}
+
+	r.coverage.addRules(ruleMatchers, func(ruleHash string) string {
+		cre, _ := r.getCre(ruleHash)
+		return cre.Id
+	})
+
+	err = r._run(ctx, &wg, sources, ruleMatchers, r.Stop, &lines, &collapsed)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to run input")
 		return err
@@ -584,26 +1002,21 @@ func (r *RuntimeT) Run(ctx context.Context, ruleMatchers *RuleMatchersT, sources
 
 	wg.Wait()
 
+	// Every source has been read; correlate the conditions they matched
+	if err = r.correlate(r.reportTo(report), math.MaxInt64); err != nil {
+		log.Error().Err(err).Msg("Failed to correlate sources")
+	}
+
 	return err
 }
 
-func (r *RuntimeT) _run(ctx context.Context, wg *sync.WaitGroup, sources []*LogData, matchers *RuleMatchersT, stop int64, lines *atomic.Int64) error {
+func (r *RuntimeT) _run(ctx context.Context, wg *sync.WaitGroup, sources []*LogData, matchers *RuleMatchersT, stop int64, lines, collapsed *atomic.Int64) error {
 
-	var dupeMap = make(map[string]struct{}, len(sources))
+	// Correlations wait on every source, so all are tracked before any runs
+	r.marks = newMarks(len(sources))
 
-	for _, logData := range sources {
-
-		// Currently supports one data source per type; warn on dupe and continue.
-		if _, ok := dupeMap[logData.SrcType()]; ok {
-			log.Warn().
-				Str("name", logData.Name()).
-				Str("src", logData.SrcType()).
-				Msg("Ignore duplicate source")
-			continue
-		}
-		dupeMap[logData.SrcType()] = struct{}{}
-
-		if err := r._runSrc(ctx, wg, logData, matchers, stop, lines); err != nil {
+	for i, logData := range sources {
+		if err := r._runSrc(ctx, wg, logData, matchers, stop, r.marks.at(i), lines, collapsed); err != nil {
 			return err
 		}
 	}
@@ -611,56 +1024,163 @@ func (r *RuntimeT) _run(ctx context.Context, wg *sync.WaitGroup, sources []*LogD
 	return nil
 }
 
-func (r *RuntimeT) _runSrc(ctx context.Context, wg *sync.WaitGroup, ld *LogData, matchers *RuleMatchersT, stop int64, lines *atomic.Int64) error {
+func (r *RuntimeT) _runSrc(ctx context.Context, wg *sync.WaitGroup, ld *LogData, matchers *RuleMatchersT, stop int64, mark, lines, collapsed *atomic.Int64) error {
 
 	type trioT struct {
+		key        string
+		object     any
+		lits       []int // prefilter literals; nil if always run
 		matcher    matchCB
 		flusher    flushCB
 		compilerCb compiler.CallbackT
+		ruleHash   string
+		conds      *condsT
+		traced     bool
+		brk        *ruleBreakerT
+		hits       int64
+		events     int64
+		spent      time.Duration
 	}
 
 	var (
 		srcType = ld.SrcType()
-		cbs     = make([]trioT, 0, len(matchers.eventSrc))
+		name    = cmp.Or(ld.Name(), srcType)
+		nLines  int64
+		lastTs  int64
+		sampled float64
+		gen     uint64
+		cpKey   = resolve.SourceKey(ld.Name(), ld.SrcType())
 	)
 
-	for ruleId, pe := range matchers.eventSrc {
+	// Lines up to the checkpoint are matched again only to rebuild the
+	// matches in progress when it was written
+	var (
+		resume    = r.newResume(cpKey)
+		atTs      int64 // lines stamped lastTs
+		replayed  int64
+		replaying bool
+	)
 
-		if srcType != "*" && srcType != pe.Source {
-			continue
+	// bind makes the callbacks of the conditions on this source, carrying
+	// over those of prev whose matcher is unchanged, and so its state.
+	bind := func(matchers *RuleMatchersT, prev []*trioT) ([]*trioT, error) {
+
+		var (
+			kept = make(map[string]*trioT, len(prev))
+			out  = make([]*trioT, 0, len(matchers.eventSrc))
+		)
+
+		for _, trio := range prev {
+			kept[trio.key] = trio
 		}
 
-		log.Info().
-			Str("src", ld.Name()).
-			Str("srcType", srcType).
-			Str("ruleId", ruleId).
-			Msg("Matching source")
+		for key, pe := range matchers.eventSrc {
 
-		matcher := matchers.match[ruleId]
+			if srcType != "*" && srcType != pe.Source {
+				continue
+			}
 
-		lm, ok := matcher.(lm.Matcher)
-		if !ok {
-			return errors.New("invalid matcher")
+			r.coverage.apply(matchers.hash[key], pe.Source)
+
+			matcher := matchers.match[key]
+
+			if trio, ok := kept[key]; ok && trio.object == matcher {
+				out = append(out, trio)
+				continue
+			}
+
+			log.Info().
+				Str("src", ld.Name()).
+				Str("srcType", srcType).
+				Str("node", key).
+				Msg("Matching source")
+
+			own, err := matchers.instance(key)
+			if err != nil {
+				return nil, err
+			}
+
+			lm, ok := own.(lm.Matcher)
+			if !ok {
+				return nil, errors.New("invalid matcher")
+			}
+
+			cb := _bindMatchCb(name, ld.Meta, lm)
+			fb := _bindFlushCB(name, ld.Meta, lm)
+
+			out = append(out, &trioT{
+				key:        key,
+				object:     matcher,
+				matcher:    cb,
+				flusher:    fb,
+				compilerCb: matchers.cb[key],
+				ruleHash:   matchers.hash[key],
+				conds:      r.bindConds(matchers.hash[key], ld.Name()),
+				traced:     r.explain.traces(matchers.hash[key]),
+				brk:        r.breaker.rule(matchers.hash[key]),
+			})
 		}
 
-		cb := _bindMatchCb(srcType, lm)
-		fb := _bindFlushCB(srcType, lm)
+		return out, nil
+	}
 
-		cbs = append(cbs, trioT{
-			matcher:    cb,
-			flusher:    fb,
-			compilerCb: matchers.cb[ruleId],
-		})
+	// prefilter builds the literal scan for the conditions of cbs that can
+	// be skipped on lines without their literals, if there are any.
+	prefilter := func(cbs []*trioT) *literalz.ScannerT {
+
+		var (
+			lits []string
+			ids  = make(map[string]int)
+		)
+
+		for _, trio := range cbs {
+			trio.lits = nil
+			if !prefilterable(trio.object) {
+				continue
+			}
+			for _, lit := range r.ruleLiterals(trio.ruleHash) {
+				id, ok := ids[lit]
+				if !ok {
+					id = len(lits)
+					ids[lit] = id
+					lits = append(lits, lit)
+				}
+				trio.lits = append(trio.lits, id)
+			}
+		}
+
+		if len(lits) == 0 {
+			return nil
+		}
+		return literalz.New(lits).NewScanner()
+	}
+
+	cbs, err := bind(matchers, nil)
+	if err != nil {
+		return err
+	}
+	pf := prefilter(cbs)
+	if live, g := r.current(); live == matchers {
+		gen = g
 	}
 
-	if len(cbs) == 0 {
+	// A followed source is read even without conditions, as reloaded rules
+	// may add some
+	if len(cbs) == 0 && !r.follow {
 		log.Info().Str("src", srcType).Msg("No matchers found")
+		advance(mark, math.MaxInt64)
 		return nil
 	}
 
-	name := ld.Name()
-	if name == "" {
-		name = ld.SrcType()
+	var (
+		around *contextT
+		hist   *historyT
+	)
+	if r.follow {
+		hist = newHistory(r.lookback)
+	}
+	if r.context > 0 {
+		around = newContext(r.context)
 	}
 
 	tracker, err := r.Ux.NewBytesTracker(name)
@@ -672,30 +1192,293 @@ func (r *RuntimeT) _runSrc(ctx context.Context, wg *sync.WaitGroup, ld *LogData,
 		tracker.UpdateTotal(total)
 	}
 
+	deliver := func(trio *trioT, msgHits *matchz.HitsT) {
+		log.Info().
+			Interface("hits", msgHits).
+			Msg("Hits")
+		trio.hits++
+		trio.compilerCb(ctx, *msgHits)
+	}
+
+	// Hits wait for the lines after them when context is captured
+	emit := func(trio *trioT, msgHits *matchz.HitsT) {
+		msgHits.Replay = replaying
+		if around != nil {
+			around.hold(msgHits, func(h *matchz.HitsT) { deliver(trio, h) })
+			return
+		}
+		deliver(trio, msgHits)
+	}
+
+	// replay matches the history of the source against conditions new to
+	// it. Their rates are counted from the start of the history.
+	replay := func(fresh []*trioT) {
+
+		if hist.len() == 0 || len(fresh) == 0 {
+			return
+		}
+
+		var hits int64
+
+		hist.each(func(e entry.LogEntry) {
+			ln := lineT{entry: e}
+			for _, trio := range fresh {
+				if msgHits := trio.matcher(trio.conds.entry(&ln)); msgHits != nil {
+					hits++
+					emit(trio, msgHits)
+				}
+			}
+		})
+
+		log.Info().
+			Str("src", name).
+			Int("lines", hist.len()).
+			Int("conditions", len(fresh)).
+			Int64("hits", hits).
+			Msg("Replayed history to reloaded rules")
+	}
+
+	// rebind picks up rules reloaded since the last line. Called with mu
+	// held.
+	rebind := func() {
+		latest, g := r.current()
+		if g == gen {
+			return
+		}
+		gen = g
+
+		next, err := bind(latest, cbs)
+		if err != nil {
+			log.Error().Err(err).Str("src", srcType).Msg("Failed to bind reloaded rules; keeping previous")
+			return
+		}
+
+		log.Info().
+			Str("src", srcType).
+			Int("conditions", len(next)).
+			Int("previous", len(cbs)).
+			Msg("Reloaded rules")
+
+		var fresh []*trioT
+		for _, trio := range next {
+			if !slices.Contains(cbs, trio) {
+				fresh = append(fresh, trio)
+			}
+		}
+		replay(fresh)
+
+		cbs = next
+		pf = prefilter(cbs)
+	}
+
+	matchCb := func(entry entry.LogEntry) bool {
+
+		// Keep an evenly spaced fraction of lines so sampled runs repeat
+		if rate := r.sampling.Rate; rate > 0 && rate < 1 {
+			if sampled += rate; sampled < 1 {
+				return false
+			}
+			sampled--
+		}
+
+		if pf != nil {
+			pf.Scan(entry.Line)
+		}
+
+		// The conditions of a rule are decided once per line, as rates count
+		ln := lineT{entry: entry}
+		match := func(trio *trioT) *matchz.HitsT {
+			e := trio.conds.entry(&ln)
+			if trio.traced {
+				r.explain.observe(name, nLines, e)
+			}
+			return trio.matcher(e)
+		}
+
+		for _, trio := range cbs {
+
+			if trio.lits != nil && !pf.Any(trio.lits) {
+				continue
+			}
+
+			if trio.brk.disabled() {
+				continue
+			}
+
+			var msgHits *matchz.HitsT
+
+			switch {
+			case trio.brk != nil:
+				var (
+					start = time.Now()
+					cost  time.Duration
+				)
+				msgHits, cost = measure(func() *matchz.HitsT { return match(trio) })
+				trio.spent += time.Since(start)
+				trio.events++
+				if r.breaker.observe(trio.brk, cost) {
+					r.trip(trio.brk, trio.ruleHash, name, entry.Timestamp)
+				}
+			case r.profile != nil:
+				start := time.Now()
+				msgHits = match(trio)
+				trio.spent += time.Since(start)
+				trio.events++
+			default:
+				msgHits = match(trio)
+			}
+
+			if msgHits != nil {
+				emit(trio, msgHits)
+			}
+		}
+
+		return false
+	}
+
+	var collapser *collapseT
+	if r.collapse {
+		collapser = newCollapse(matchCb, collapsed, r.maxRepeats())
+		matchCb = collapser.Append
+	}
+
+	// A followed source is also advanced on the wall clock while idle, so
+	// guard the matchers against the idle ticks
+	var (
+		mu       sync.Mutex
+		lastSeen time.Time
+	)
+
 	scanCb := func(entry entry.LogEntry) bool {
 
+		if r.follow {
+			mu.Lock()
+			defer mu.Unlock()
+			lastSeen = time.Now()
+			rebind()
+		}
+
+		if budget := r.sampling.MaxLinesPerSource; budget > 0 && nLines >= budget {
+			return true
+		}
+
 		// Use an atomic instead of calling tracker directly to decrease overhead.
 		lines.Add(1)
+		nLines++
+
+		if entry.Timestamp != lastTs {
+			lastTs, atTs = entry.Timestamp, 0
+		}
+		atTs++
+
+		if replaying = resume.replays(lastTs, atTs); replaying {
+			replayed++
+		}
 
+		// A line may not spell out a marker to satisfy a condition
+		entry.Line = utils.CleanLine(entry.Line)
+
+		if around != nil {
+			around.push(entry)
+		}
+		hist.push(entry)
+
+		done := matchCb(entry)
+		advance(mark, entry.Timestamp)
+
+		if nLines%cpEvery == 0 {
+			r.cp.Mark(cpKey, lastTs, atTs, nLines-replayed)
+		}
+
+		return done
+	}
+
+	// idle closes the windows of pending negative conditions as time passes
+	// without new lines. The clock is moved on from the last line by the time
+	// since it was read, less the reorder window that may still hold lines.
+	reorder := int64(0)
+	for _, rd := range ld.Logs {
+		reorder = max(reorder, rd.Window())
+	}
+
+	idle := func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		rebind()
+
+		// A followed source yet to write is taken to be up to the wall
+		// clock, so it does not hold back correlations on the others
+		if lastTs == 0 {
+			advance(mark, time.Now().UnixNano()-reorder)
+			r.settle()
+			return
+		}
+
+		waited := int64(time.Since(lastSeen))
+		if waited < int64(idleTick) || waited <= reorder {
+			return
+		}
+
+		if collapser != nil {
+			collapser.Flush()
+		}
+
+		clock := lastTs + waited - reorder
 		for _, trio := range cbs {
-			if msgHits := trio.matcher(entry); msgHits != nil {
-				log.Info().
-					Interface("hits", msgHits).
-					Msg("Hits")
-				trio.compilerCb(ctx, *msgHits)
+			if trio.brk.disabled() {
+				continue
+			}
+			if msgHits := trio.flusher(clock); msgHits != nil {
+				emit(trio, msgHits)
 			}
 		}
 
-		return false
+		if around != nil {
+			around.flush()
+		}
+
+		advance(mark, clock)
+		r.settle()
 	}
 
 	finalFlush := func() {
-		for _, trio := range cbs {
-			if msgHits := trio.flusher(); msgHits != nil {
+		if lastTs != 0 {
+			r.cp.Mark(cpKey, lastTs, atTs, nLines-replayed)
+		}
+		if collapser != nil {
+			collapser.Flush()
+			if n := collapser.Collapsed(); n > 0 {
 				log.Info().
-					Interface("hits", msgHits).
-					Msg("Hits on final flush")
-				trio.compilerCb(ctx, *msgHits)
+					Str("src", name).
+					Int64("lines", n).
+					Msg("Collapsed duplicate lines")
+			}
+		}
+		if around != nil {
+			around.flush()
+		}
+		for _, trio := range cbs {
+			start := time.Now()
+			msgHits := trio.flusher(futureMark)
+			trio.spent += time.Since(start)
+
+			// Everything decidable by the last line was reported as it was
+			// read. What remains is waiting on a negative condition whose
+			// window is still open at the end of the data, so whether the
+			// expected line would have come is unknown.
+			if msgHits != nil {
+				log.Warn().
+					Str("src", name).
+					Str("rule", trio.ruleHash).
+					Int("pending", len(msgHits.Entries)).
+					Msg("Negative condition still open at end of stream; not reported")
+			}
+			r.decisions.Summary(trio.ruleHash, srcType, name, nLines, trio.hits)
+
+			if r.profile != nil {
+				cre, _ := r.getCre(trio.ruleHash)
+				r.profile.add(cre.Id, trio.ruleHash, trio.events, trio.hits, trio.spent)
 			}
 		}
 	}
@@ -704,8 +1487,30 @@ func (r *RuntimeT) _runSrc(ctx context.Context, wg *sync.WaitGroup, ld *LogData,
 	go func() {
 		defer wg.Done()
 
-		// Spin across the logs
-		_spinLogs(ld, scanCb, stop, tracker)
+		var (
+			stopIdle func()
+			drain    func()
+			scanF    = scanCb
+		)
+		if r.follow {
+			stopIdle = _every(idleTick, idle)
+			scanF, drain = r.queueLines(name, scanCb)
+		}
+
+		// Spin across the logs, merging multi-file sources by time
+		if r.pool != nil && len(ld.Logs) > 1 {
+			_mergeLogs(ld, scanF, stop, tracker, r.pool, r.memLimit)
+		} else {
+			_spinLogs(ld, scanF, stop, tracker, r.memLimit)
+		}
+
+		if drain != nil {
+			drain()
+		}
+
+		if stopIdle != nil {
+			stopIdle()
+		}
 
 		// Finally flush out any pending negative matches
 		finalFlush()
@@ -717,7 +1522,38 @@ func (r *RuntimeT) _runSrc(ctx context.Context, wg *sync.WaitGroup, ld *LogData,
 	return nil
 }
 
-func _spinLogs(ld *LogData, scanF scanner.ScanFuncT, stop int64, tracker *progress.Tracker) {
+// _every calls f every interval until the returned stop is called; stop
+// waits for a call in progress.
+func _every(interval time.Duration, f func()) (stop func()) {
+
+	var (
+		quit = make(chan struct{})
+		done = make(chan struct{})
+	)
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				f()
+			}
+		}
+	}()
+
+	return func() {
+		close(quit)
+		<-done
+	}
+}
+
+func _spinLogs(ld *LogData, scanF scanner.ScanFuncT, stop int64, tracker *progress.Tracker, memLimit int) {
 
 	for i, rd := range ld.Logs {
 
@@ -745,7 +1581,7 @@ func _spinLogs(ld *LogData, scanF scanner.ScanFuncT, stop int64, tracker *progre
 		var reorder *scanner.ReorderT
 		if rd.Window() > 0 {
 			var err error
-			if reorder, err = scanner.NewReorder(rd.Window(), scanF, scanner.WithMemoryLimit(ramLimit)); err != nil {
+			if reorder, err = scanner.NewReorder(rd.Window(), scanF, scanner.WithMemoryLimit(memLimit)); err != nil {
 				log.Warn().Err(err).Msg("Fail to create reorder object. Continue...")
 			} else {
 				scanF = reorder.Append
@@ -776,9 +1612,13 @@ func _spinLogs(ld *LogData, scanF scanner.ScanFuncT, stop int64, tracker *progre
 }
 
 type matchCB func(entry entry.LogEntry) *matchz.HitsT
-type flushCB func() *matchz.HitsT
+type flushCB func(clock int64) *matchz.HitsT
+
+// labelsT returns the labels of a source when it matches; remote sources
+// only learn theirs as they are read.
+type labelsT func() map[string]string
 
-func makeHitZ(src string, hits lm.Hits) *matchz.HitsT {
+func makeHitZ(src string, labels labelsT, hits lm.Hits) *matchz.HitsT {
 	if hits.Cnt == 0 {
 		return nil
 	}
@@ -792,27 +1632,28 @@ func makeHitZ(src string, hits lm.Hits) *matchz.HitsT {
 	for _, line := range hits.Logs {
 		msgHits.Entries = append(msgHits.Entries, matchz.EntryT{
 			Timestamp: line.Timestamp,
-			Entry:     []byte(line.Line),
+			Entry:     []byte(stripMarkers(line.Line)),
 		})
 	}
 
 	msgHits.Count = uint32(hits.Cnt)
 	msgHits.Entity.FileName = src
+	msgHits.Entity.Labels = labels()
 
 	return &msgHits
 }
 
-func _bindMatchCb(src string, mm lm.Matcher) matchCB {
+func _bindMatchCb(src string, labels labelsT, mm lm.Matcher) matchCB {
 	return func(entry entry.LogEntry) *matchz.HitsT {
 		hits := mm.Scan(entry)
-		return makeHitZ(src, hits)
+		return makeHitZ(src, labels, hits)
 	}
 }
 
-func _bindFlushCB(src string, mm lm.Matcher) flushCB {
-	return func() *matchz.HitsT {
-		hits := mm.Eval(futureMark)
-		return makeHitZ(src, hits)
+func _bindFlushCB(src string, labels labelsT, mm lm.Matcher) flushCB {
+	return func(clock int64) *matchz.HitsT {
+		hits := mm.Eval(clock)
+		return makeHitZ(src, labels, hits)
 	}
 }
 
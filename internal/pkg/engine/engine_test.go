@@ -2,11 +2,32 @@ package engine
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/prequel-dev/preq/internal/pkg/celz"
+	"github.com/prequel-dev/preq/internal/pkg/checkpoint"
+	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/prequel-dev/preq/internal/pkg/resolve"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
 	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/prequel-dev/prequel-compiler/pkg/ast"
 	"github.com/prequel-dev/prequel-compiler/pkg/compiler"
 	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"github.com/prequel-dev/prequel-logmatch/pkg/entry"
+	lm "github.com/prequel-dev/prequel-logmatch/pkg/match"
 )
 
 func TestNew(t *testing.T) {
@@ -294,3 +315,2107 @@ func TestRuntimeT_Run(t *testing.T) {
 		}
 	})
 }
+
+func TestMergeLogs(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		base = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	)
+
+	// Three logs whose lines interleave in time, each larger than a batch
+	for f := 0; f < 3; f++ {
+		var b strings.Builder
+		for i := 0; i < 2*mergeBatch+7; i++ {
+			ts := base.Add(time.Duration(i*3+f) * time.Millisecond)
+			fmt.Fprintf(&b, "%s log%d line%d\n", ts.Format(time.RFC3339Nano), f, i)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("app%d.log", f)), []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resolveAll := func(t *testing.T) *LogData {
+		ds, err := resolve.ParseSources([]byte("sources:\n  - type: app\n    locations:\n      - path: " + filepath.Join(dir, "*.log") + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lds := resolve.Resolve(ds)
+		if len(lds) != 1 || len(lds[0].Logs) != 3 {
+			t.Fatalf("Expected 1 source with 3 logs, got %v", lds)
+		}
+		return lds[0]
+	}
+
+	t.Run("merges by timestamp", func(t *testing.T) {
+		var (
+			last  int64
+			count int
+		)
+		scanF := func(e entry.LogEntry) bool {
+			if e.Timestamp < last {
+				t.Fatalf("Out of order entry %q after %d", e.Line, last)
+			}
+			last = e.Timestamp
+			count++
+			return false
+		}
+
+		_mergeLogs(resolveAll(t), scanF, futureMark, &progress.Tracker{}, make(chan struct{}, 2), ramLimit)
+
+		if want := 3 * (2*mergeBatch + 7); count != want {
+			t.Errorf("Expected %d entries, got %d", want, count)
+		}
+	})
+
+	t.Run("stops early", func(t *testing.T) {
+		var count int
+		scanF := func(e entry.LogEntry) bool {
+			count++
+			return count == 10
+		}
+
+		_mergeLogs(resolveAll(t), scanF, futureMark, &progress.Tracker{}, make(chan struct{}, 1), ramLimit)
+
+		if count != 10 {
+			t.Errorf("Expected 10 entries, got %d", count)
+		}
+	})
+}
+
+func TestCollapse(t *testing.T) {
+	var (
+		dropped atomic.Int64
+		got     []string
+	)
+
+	c := newCollapse(func(e entry.LogEntry) bool {
+		got = append(got, e.Line)
+		return false
+	}, &dropped, 1)
+
+	lines := []string{
+		"2024-05-01T10:00:00Z start",
+		"2024-05-01T10:00:01Z connection refused",
+		"2024-05-01T10:00:02Z connection refused",
+		"2024-05-01T10:00:03Z connection refused",
+		"2024-05-01T10:00:04Z connection refused",
+		"2024-05-01T10:00:05Z recovered",
+		"2024-05-01T10:00:06Z recovered",
+		"Jan  2 15:04:05 host app: 500 error",
+		"Jan  2 15:04:06 host app: 500 error",
+	}
+	for i, l := range lines {
+		c.Append(entry.LogEntry{Line: l, Timestamp: int64(i)})
+	}
+	c.Flush()
+
+	want := []string{lines[0], lines[1], lines[4], lines[5], lines[6], lines[7], lines[8]}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if n := dropped.Load(); n != 2 || c.Collapsed() != 2 {
+		t.Errorf("Expected 2 collapsed lines, got %d", n)
+	}
+}
+
+func TestCollapseKey(t *testing.T) {
+	tests := map[string]string{
+		"2024-05-01T10:00:00.123Z boom":           "boom",
+		"2024-05-01 10:00:00,123 ERROR boom":      "ERROR boom",
+		"Jan  2 15:04:05 host sshd[12]: boom":     "host sshd[12]: boom",
+		"[2024/05/01 10:00:00] boom":              "boom",
+		"1714557600.123 boom":                     "boom",
+		"500 Internal Server Error":               "500 Internal Server Error",
+		`{"ts":"2024-05-01T10:00:00Z","msg":"x"}`: `{"ts":"2024-05-01T10:00:00Z","msg":"x"}`,
+	}
+	for line, want := range tests {
+		if got := collapseKey(line); got != want {
+			t.Errorf("collapseKey(%q): expected %q, got %q", line, want, got)
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+
+	rules, err := os.ReadFile("../../../examples/13-string-example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data strings.Builder
+	for i := 0; i < 9; i++ {
+		msg := "signal process started"
+		if i == 4 {
+			msg = "[emerg] 1655#1655: still could not bind()"
+		}
+		fmt.Fprintf(&data, "2019-02-05T12:07:%02dZ %s\n", 30+i, msg)
+	}
+
+	var (
+		r       = New(math.MaxInt64, ux.NewUxEval())
+		report  = ux.NewReport(nil)
+		profile = NewProfile()
+	)
+
+	matchers, err := r.CompileRules(rules, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := resolve.PipeReader(strings.NewReader(data.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.SetProfile(profile)
+	if err = r.Run(context.Background(), matchers, sources, report); err != nil {
+		t.Fatal(err)
+	}
+
+	got := profile.Rules()
+	if len(got) != 1 {
+		t.Fatalf("Expected 1 profiled rule, got %d", len(got))
+	}
+	// The prefilter spares the rule the lines without its literal
+	if rp := got[0]; rp.CreId != "string-example-1" || rp.Events != 1 || rp.Hits != 1 || rp.Time <= 0 {
+		t.Errorf("Unexpected profile: %+v", rp)
+	}
+
+	var sb strings.Builder
+	profile.Fprint(&sb)
+	if !strings.Contains(sb.String(), "string-example-1") {
+		t.Errorf("Expected rule in table, got:\n%s", sb.String())
+	}
+
+	// A nil profile records nothing
+	var none *ProfileT
+	none.add("cre", "hash", 1, 1, time.Second)
+	if none.Rules() != nil {
+		t.Error("Expected nil profile to be empty")
+	}
+}
+
+func TestRuleTimeout(t *testing.T) {
+
+	rules, err := os.ReadFile("../../../examples/13-string-example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data strings.Builder
+	for i := 0; i < 9; i++ {
+		fmt.Fprintf(&data, "2019-02-05T12:07:%02dZ [emerg] 1655#1655: still could not bind()\n", 30+i)
+	}
+
+	var (
+		r      = New(math.MaxInt64, ux.NewUxEval())
+		report = ux.NewReport(nil)
+	)
+
+	matchers, err := r.CompileRules(rules, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := resolve.PipeReader(strings.NewReader(data.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every line costs more than a nanosecond to match, and the rule is
+	// disabled at the end of its first window
+	r.SetRuleTimeout(time.Nanosecond)
+	r.breaker.window = 3
+	if err = r.Run(context.Background(), matchers, sources, report); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := report.CreateReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc) != 2 {
+		t.Fatalf("Expected a detection and a disabled entry, got %d entries", len(doc))
+	}
+
+	var hits int
+	for _, e := range doc {
+		if d, ok := e["disabled"].(*ux.DisabledT); ok {
+			if e["id"] != "string-example-1" || d.Timeout != "1ns" {
+				t.Errorf("Unexpected disabled entry: %v %+v", e["id"], d)
+			}
+			continue
+		}
+		hits = len(e["hits"].([]ux.HitEntryT))
+	}
+
+	// The line that trips the rule is still matched
+	if hits != 3 {
+		t.Errorf("Expected 3 hits before the rule was disabled, got %d", hits)
+	}
+
+	// Without a timeout rules are never disabled
+	r.SetRuleTimeout(0)
+	if rb := r.breaker.rule("hash"); rb != nil || rb.disabled() {
+		t.Error("Expected no breaker without a timeout")
+	}
+}
+
+func TestExplain(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: seq-example-1
+    metadata:
+      id: YCCUmV8SMuMCaQvTnjXXwm
+      hash: BQ8ouGjLv8mPxvFHd2myeA
+    rule:
+      sequence:
+        event:
+          source: cre.log.kafka
+        window: 10s
+        order:
+          - regex: "foo(.+)bar"
+          - value: "test"
+        negate:
+          - "all clear"
+`
+
+	const data = `2019-02-05T12:07:37Z signal process started
+2019-02-05T12:07:38Z bind() to foo bar
+2019-02-05T12:07:39Z nothing to see
+2019-02-05T12:07:40Z bind() to test
+2019-02-05T12:07:41Z done
+`
+
+	var (
+		r       = New(math.MaxInt64, ux.NewUxEval())
+		report  = ux.NewReport(nil)
+		explain = NewExplain("seq-example-1")
+	)
+
+	r.SetExplain(explain)
+
+	matchers, err := r.CompileRules([]byte(rules), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explain.Rules() != 1 {
+		t.Fatalf("Expected 1 traced rule, got %d", explain.Rules())
+	}
+
+	sources, err := resolve.PipeReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Run(context.Background(), matchers, sources, report); err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	explain.Fprint(&sb, report)
+	out := sb.String()
+
+	for _, want := range []string{
+		`[0] order[0] regex "foo(.+)bar"`,
+		`[2] negate[0] value "all clear"`,
+		"Detection 1:",
+		":2  matched [0]  bind() to foo bar",
+		":4  matched [1]  bind() to test",
+		"window: hits span 2s within 10s, satisfied",
+		"order: satisfied",
+		"negate: no negated condition matched within the span",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestExplainObserve(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: seq-example-1
+    metadata:
+      id: YCCUmV8SMuMCaQvTnjXXwm
+      hash: BQ8ouGjLv8mPxvFHd2myeA
+    rule:
+      sequence:
+        event:
+          source: cre.log.kafka
+        window: 10s
+        order:
+          - value: "foo"
+          - value: "bar"
+`
+
+	var (
+		r       = New(math.MaxInt64, ux.NewUxEval())
+		explain = NewExplain("seq-example-1")
+	)
+
+	r.SetExplain(explain)
+
+	if _, err := r.CompileRules([]byte(rules), ux.NewReport(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	le := entry.LogEntry{Timestamp: 1, Line: "foo"}
+
+	// The same line read from several sources keeps its first position
+	// whatever order they are scanned in
+	var wg sync.WaitGroup
+	for _, src := range []string{"c", "b", "a"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			explain.observe(src, 7, le)
+			explain.observe(src, 3, le)
+		}()
+	}
+	wg.Wait()
+
+	if len(explain.seen) != 1 {
+		t.Fatalf("Expected 1 line seen, got %d", len(explain.seen))
+	}
+	for _, s := range explain.seen {
+		if s.src != "a" || s.pos != 3 {
+			t.Errorf("Expected a:3, got %s:%d", s.src, s.pos)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: string-example-1
+    metadata:
+      id: yGbWBUFtXu7R2hhuNJnJ4k
+      hash: r7AM3gA9zeaG3sA7ykCi72
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "still could not bind()"
+`
+
+	var data strings.Builder
+	for i := 0; i < 8; i++ {
+		msg := fmt.Sprintf("line %d", i)
+		if i == 5 || i == 1 {
+			msg = "still could not bind()"
+		}
+		fmt.Fprintf(&data, "2019-02-05T12:07:%02dZ %s\n", 30+i, msg)
+	}
+
+	var (
+		r      = New(math.MaxInt64, ux.NewUxEval())
+		report = ux.NewReport(nil)
+	)
+
+	r.SetContext(2)
+
+	matchers, err := r.CompileRules([]byte(rules), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := resolve.PipeReader(strings.NewReader(data.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Run(context.Background(), matchers, sources, report); err != nil {
+		t.Fatal(err)
+	}
+
+	hits := report.Occurrences("string-example-1")
+	if len(hits) != 2 {
+		t.Fatalf("Expected 2 detections, got %d", len(hits))
+	}
+
+	var (
+		first  = hits[0].Entries[0].Context
+		second = hits[1].Entries[0].Context
+	)
+
+	if first == nil || !slices.Equal(first.Before, []string{"line 0"}) || !slices.Equal(first.After, []string{"line 2", "line 3"}) {
+		t.Errorf("Unexpected context of first hit: %+v", first)
+	}
+	if second == nil || !slices.Equal(second.Before, []string{"line 3", "line 4"}) || !slices.Equal(second.After, []string{"line 6", "line 7"}) {
+		t.Errorf("Unexpected context of second hit: %+v", second)
+	}
+}
+
+// Detections handed over as they occur carry the lines after their hits
+func TestContextDelivered(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: string-example-1
+    metadata:
+      id: yGbWBUFtXu7R2hhuNJnJ4k
+      hash: r7AM3gA9zeaG3sA7ykCi72
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "still could not bind()"
+`
+
+	var data strings.Builder
+	for i := 0; i < 8; i++ {
+		msg := fmt.Sprintf("line %d", i)
+		if i == 2 || i == 7 {
+			msg = "still could not bind()"
+		}
+		fmt.Fprintf(&data, "2019-02-05T12:07:%02dZ %s\n", 30+i, msg)
+	}
+
+	var (
+		r         = New(math.MaxInt64, ux.NewUxEval())
+		report    = ux.NewReport(nil)
+		mu        sync.Mutex
+		delivered [][]string
+	)
+
+	r.SetContext(2)
+	r.SetOnDetection(func(doc ux.ReportDocT) {
+		out, err := json.Marshal(doc)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var entries []struct {
+			Hits []struct {
+				Context *matchz.ContextT `json:"context"`
+			} `json:"hits"`
+		}
+		if err = json.Unmarshal(out, &entries); err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, e := range entries {
+			for _, h := range e.Hits {
+				if h.Context == nil {
+					delivered = append(delivered, nil)
+					continue
+				}
+				delivered = append(delivered, h.Context.After)
+			}
+		}
+	})
+
+	matchers, err := r.CompileRules([]byte(rules), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := resolve.PipeReader(strings.NewReader(data.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Run(context.Background(), matchers, sources, report); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last hit is delivered at the end of the data with no lines after
+	want := [][]string{{"line 3", "line 4"}, nil}
+	if len(delivered) != len(want) || !slices.Equal(delivered[0], want[0]) || len(delivered[1]) != 0 {
+		t.Errorf("Expected %q delivered, got %q", want, delivered)
+	}
+}
+
+func TestFollowAbsence(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: absence-example
+    metadata:
+      id: iywUN3reMAdHpfprt4Br15
+      hash: TkX4hjdZAs5BUSsZtm3qSm
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "leader election started"
+        negate:
+          - value: "became leader"
+            window: 1s
+`
+
+	var (
+		r        = New(math.MaxInt64, ux.NewUxEval())
+		report   = ux.NewReport(nil)
+		detected = make(chan struct{}, 1)
+		pr, pw   = io.Pipe()
+	)
+
+	r.SetFollow(true)
+	r.SetOnDetection(func(ux.ReportDocT) {
+		detected <- struct{}{}
+	})
+
+	matchers, err := r.CompileRules([]byte(rules), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fill the format detection sample, then leave the source idle
+	var (
+		now  = time.Now().UTC()
+		data strings.Builder
+	)
+	for data.Len() < 32*1024 {
+		fmt.Fprintf(&data, "%s joined cluster\n", now.Add(-time.Minute).Format(time.RFC3339Nano))
+	}
+	fmt.Fprintf(&data, "%s leader election started\n", now.Format(time.RFC3339Nano))
+	// Folding holds the last line until the next one starts
+	fmt.Fprintf(&data, "%s heartbeat\n", now.Format(time.RFC3339Nano))
+	go io.WriteString(pw, data.String())
+
+	sources, err := resolve.PipeReader(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(context.Background(), matchers, sources, report)
+	}()
+
+	// No further line arrives; the window closes on the wall clock
+	select {
+	case <-detected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected detection while the source was idle")
+	}
+
+	pw.Close()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCrossSource(t *testing.T) {
+
+	rules, err := os.ReadFile("../../../examples/42-cross-source-example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const appLog = "2025-03-11T14:01:10Z failed to allocate 512MiB\n"
+
+	tests := map[string]struct {
+		kernelLog  string
+		kernelHost string
+		want       int
+	}{
+		"followed within window": {
+			kernelLog:  "2025-03-11T14:02:05Z Out of memory: Killed process 4242\n",
+			kernelHost: "node-1",
+			want:       1,
+		},
+		"outside window": {
+			kernelLog:  "2025-03-11T14:03:30Z Out of memory: Killed process 4242\n",
+			kernelHost: "node-1",
+		},
+		"out of order": {
+			kernelLog:  "2025-03-11T14:00:05Z Out of memory: Killed process 4242\n",
+			kernelHost: "node-1",
+		},
+		"other host": {
+			kernelLog:  "2025-03-11T14:02:05Z Out of memory: Killed process 4242\n",
+			kernelHost: "node-2",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+
+			var (
+				dir    = t.TempDir()
+				app    = filepath.Join(dir, "app.log")
+				kernel = filepath.Join(dir, "kernel.log")
+			)
+
+			if err := os.WriteFile(app, []byte(appLog), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(kernel, []byte(tc.kernelLog), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			doc := fmt.Sprintf(`version: 0.0.1
+sources:
+  - name: app
+    type: cre.log.app
+    labels:
+      hostname: node-1
+    locations:
+      - path: %s
+  - name: kernel
+    type: cre.log.kernel
+    labels:
+      hostname: %s
+    locations:
+      - path: %s
+`, app, tc.kernelHost, kernel)
+
+			dss, err := resolve.ParseSources([]byte(doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				r      = New(math.MaxInt64, ux.NewUxEval())
+				report = ux.NewReport(nil)
+			)
+
+			matchers, err := r.CompileRules(rules, report)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = r.Run(context.Background(), matchers, resolve.Resolve(dss), report); err != nil {
+				t.Fatal(err)
+			}
+
+			hits := report.Occurrences("cross-source-example")
+			if len(hits) != tc.want {
+				t.Fatalf("Expected %d detections, got %d", tc.want, len(hits))
+			}
+			if tc.want == 0 {
+				return
+			}
+
+			entries := hits[0].Entries
+			if len(entries) != 2 ||
+				!strings.Contains(string(entries[0].Entry), "failed to allocate") ||
+				!strings.Contains(string(entries[1].Entry), "Out of memory") {
+				t.Errorf("Unexpected hits: %+v", entries)
+			}
+			if got := r.Stats().Rules; got != 1 {
+				t.Errorf("Expected 1 rule, got %d", got)
+			}
+		})
+	}
+}
+
+func TestSourceNames(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: broker-down
+    metadata:
+      id: Vb3mK8sLq2WxT5nRz9YcHd
+      hash: Pn4fJ7kDw2QsX8mLz5RtGb
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "broker down"
+  - cre:
+      id: broker-restart
+    metadata:
+      id: Kq7wN2xZm5LsR8vTb3YdFh
+      hash: Ht6gS9pLx3MwQ2nKd7VzRc
+    rule:
+      sequence:
+        event:
+          source: cre.log.kafka
+        window: 10s
+        order:
+          - value: "stopping"
+          - value: "started"
+`
+
+	var (
+		dir  = t.TempDir()
+		logs = map[string]string{
+			"kafka-a": "2025-03-11T14:01:10Z broker down\n2025-03-11T14:01:11Z stopping\n",
+			"kafka-b": "2025-03-11T14:01:20Z broker down\n2025-03-11T14:01:21Z started\n",
+		}
+		doc = "version: 0.0.1\nsources:\n"
+	)
+
+	for _, name := range []string{"kafka-a", "kafka-b"} {
+		fn := filepath.Join(dir, name+".log")
+		if err := os.WriteFile(fn, []byte(logs[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		doc += fmt.Sprintf("  - name: %s\n    type: cre.log.kafka\n    locations:\n      - path: %s\n", name, fn)
+	}
+
+	dss, err := resolve.ParseSources([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		r      = New(math.MaxInt64, ux.NewUxEval())
+		report = ux.NewReport(nil)
+	)
+
+	matchers, err := r.CompileRules([]byte(rules), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Run(context.Background(), matchers, resolve.Resolve(dss), report); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sources of the same type are all read, and hits name theirs
+	var got []string
+	for _, m := range report.Occurrences("broker-down") {
+		got = append(got, m.Entity.FileName)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, []string{"kafka-a", "kafka-b"}) {
+		t.Errorf("Expected a hit from each source, got %q", got)
+	}
+
+	// and match on their own, so a sequence does not span them
+	if occ := report.Occurrences("broker-restart"); len(occ) != 0 {
+		t.Errorf("Expected no sequence across sources, got %v", occ)
+	}
+}
+
+func TestExtract(t *testing.T) {
+
+	rules, err := os.ReadFile("../../../examples/45-extract-example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const data = `2025-03-26T14:01:02Z GET /api/orders request_time=0.012
+2025-03-26T14:01:05Z upstream timed out (110: Connection timed out) request_time=30.001
+2025-03-26T14:01:09Z GET /api/orders request_time=0.020
+2025-03-26T14:01:21Z upstream timed out (110: Connection timed out) request_time=45.500
+2025-03-26T14:01:40Z upstream timed out (110: Connection timed out) request_time=60.002
+`
+
+	var (
+		r      = New(math.MaxInt64, ux.NewUxEval())
+		report = ux.NewReport(nil)
+	)
+
+	matchers, err := r.CompileRules(rules, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := resolve.PipeReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Run(context.Background(), matchers, sources, report); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := report.CreateReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc) != 1 {
+		t.Fatalf("Expected 1 detection, got %d", len(doc))
+	}
+
+	metrics, ok := doc[0]["metrics"].(map[string]ux.MetricT)
+	if !ok {
+		t.Fatalf("Expected metrics in report entry: %v", doc[0])
+	}
+
+	want := ux.MetricT{Count: 3, Min: 30.001, Max: 60.002, Avg: (30.001 + 45.5 + 60.002) / 3}
+	got := metrics["request_time"]
+	if got.Count != want.Count || got.Min != want.Min || got.Max != want.Max || math.Abs(got.Avg-want.Avg) > 1e-9 {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestExtractCondition(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: extract-condition
+    metadata:
+      id: 7sKp2QwXn4Lr9TmVb3YcZd
+      hash: Jf5hRt8WqN2mXk6LpC9vBa
+    rule:
+      sequence:
+        event:
+          source: cre.log.app
+        window: 1m
+        order:
+          - value: "upstream timed out"
+            extract:
+              - name: request_time
+                regex: 'request_time=([0-9.]+)'
+          - value: "retry gave up"
+`
+
+	// Both lines carry a request_time; only the first is of the condition
+	const data = `2025-03-26T14:01:05Z upstream timed out request_time=30.001
+2025-03-26T14:01:09Z retry gave up request_time=0.020
+`
+
+	var (
+		r      = New(math.MaxInt64, ux.NewUxEval())
+		report = ux.NewReport(nil)
+	)
+
+	matchers, err := r.CompileRules([]byte(rules), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := resolve.PipeReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Run(context.Background(), matchers, sources, report); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := report.CreateReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc) != 1 {
+		t.Fatalf("Expected 1 detection, got %d", len(doc))
+	}
+
+	metrics, _ := doc[0]["metrics"].(map[string]ux.MetricT)
+	if got := metrics["request_time"]; got.Count != 1 || got.Max != 30.001 {
+		t.Errorf("Expected the request_time of the timeout only, got %+v", got)
+	}
+
+	for _, hit := range doc[0]["hits"].([]ux.HitEntryT) {
+		if strings.Contains(hit.Entry, "retry") && hit.Values != nil {
+			t.Errorf("Expected no values on %q, got %v", hit.Entry, hit.Values)
+		}
+	}
+}
+
+func TestCel(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: cel-example
+    metadata:
+      id: yGbWBUFtXu7R2hhuNJnJ4k
+      hash: r7AM3gA9zeaG3sA7ykCi72
+    rule:
+      set:
+        event:
+          source: cre.log.app
+        match:
+          - cel: 'fields.status >= 500'
+  - cre:
+      id: anchored-example
+    metadata:
+      id: 4Nq2s8VdLm3xKpJzR7tYcW
+      hash: Hb6fTgWn9pQe2sXkD5mLa8
+    rule:
+      set:
+        event:
+          source: cre.log.app
+        match:
+          - regex: '"status": 503}$'
+`
+
+	const data = `2025-03-26T14:01:02Z {"status": 200}
+2025-03-26T14:01:05Z {"status": 503}
+`
+
+	var (
+		r      = New(math.MaxInt64, ux.NewUxEval())
+		report = ux.NewReport(nil)
+	)
+
+	matchers, err := r.CompileRules([]byte(rules), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := resolve.PipeReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Run(context.Background(), matchers, sources, report); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cel condition of one rule leaves the line the other sees alone
+	dets := report.Detections()
+	if len(dets) != 2 {
+		t.Fatalf("Expected both rules detected, got %v", dets)
+	}
+
+	for _, id := range []string{"cel-example", "anchored-example"} {
+		occ := report.Occurrences(id)
+		if len(occ) != 1 || len(occ[0].Entries) != 1 || string(occ[0].Entries[0].Entry) != `{"status": 503}` {
+			t.Errorf("Expected the 503 line as read for %s, got %v", id, occ)
+		}
+	}
+}
+
+func TestCelForged(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: cel-example
+    metadata:
+      id: yGbWBUFtXu7R2hhuNJnJ4k
+      hash: r7AM3gA9zeaG3sA7ykCi72
+    rule:
+      set:
+        event:
+          source: cre.log.app
+        match:
+          - cel: 'fields.status >= 500'
+`
+
+	var (
+		r      = New(math.MaxInt64, ux.NewUxEval())
+		report = ux.NewReport(nil)
+	)
+
+	matchers, err := r.CompileRules([]byte(rules), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marks := r.ruleMarkers("r7AM3gA9zeaG3sA7ykCi72")
+	if len(marks) != 1 {
+		t.Fatalf("Expected 1 marker, got %q", marks)
+	}
+
+	// A line that carries the marker itself does not satisfy the condition
+	data := fmt.Sprintf("2025-03-26T14:01:02Z {\"status\": 200} %s\n", marks[0])
+
+	sources, err := resolve.PipeReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Run(context.Background(), matchers, sources, report); err != nil {
+		t.Fatal(err)
+	}
+
+	if dets := report.Detections(); len(dets) != 0 {
+		t.Errorf("Expected no detection from a forged marker, got %v", dets)
+	}
+}
+
+func TestCelJq(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: cel-example
+    metadata:
+      id: yGbWBUFtXu7R2hhuNJnJ4k
+      hash: r7AM3gA9zeaG3sA7ykCi72
+    rule:
+      set:
+        event:
+          source: cre.log.app
+        match:
+          - cel: 'fields.status >= 500'
+          - jq: '.status == 503'
+`
+
+	r := New(math.MaxInt64, ux.NewUxEval())
+
+	if _, err := r.CompileRules([]byte(rules), ux.NewReport(nil)); !errors.Is(err, celz.ErrCelJq) {
+		t.Errorf("Expected %v, got %v", celz.ErrCelJq, err)
+	}
+}
+
+func TestRate(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: rate-example
+    metadata:
+      id: yGbWBUFtXu7R2hhuNJnJ4k
+      hash: r7AM3gA9zeaG3sA7ykCi72
+    rule:
+      set:
+        event:
+          source: cre.log.app
+        match:
+          - value: "reset"
+            rate:
+              increase: 100%
+              min: 2
+              window: 10s
+  - cre:
+      id: anchored-example
+    metadata:
+      id: 4Nq2s8VdLm3xKpJzR7tYcW
+      hash: Hb6fTgWn9pQe2sXkD5mLa8
+    rule:
+      set:
+        event:
+          source: cre.log.app
+        match:
+          - regex: 'reset$'
+`
+
+	// One reset every 10s, then a burst
+	var data strings.Builder
+	for _, sec := range []int{0, 10, 20, 30, 33, 35, 37, 39} {
+		fmt.Fprintf(&data, "2025-03-26T14:01:%02dZ connection reset\n", sec)
+	}
+
+	var (
+		r      = New(math.MaxInt64, ux.NewUxEval())
+		report = ux.NewReport(nil)
+	)
+
+	matchers, err := r.CompileRules([]byte(rules), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := resolve.PipeReader(strings.NewReader(data.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Run(context.Background(), matchers, sources, report); err != nil {
+		t.Fatal(err)
+	}
+
+	if occ := report.Occurrences("rate-example"); len(occ) != 1 || string(occ[0].Entries[0].Entry) != "connection reset" {
+		t.Errorf("Expected the rate to hold once, got %v", occ)
+	}
+
+	// The rate leaves the line the other rule sees alone
+	if occ := report.Occurrences("anchored-example"); len(occ) != 8 {
+		t.Errorf("Expected every line detected, got %d", len(occ))
+	}
+}
+
+func TestMinSeverity(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: critical-example
+      severity: 0
+    metadata:
+      id: yGbWBUFtXu7R2hhuNJnJ4k
+      hash: r7AM3gA9zeaG3sA7ykCi72
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "still could not bind()"
+  - cre:
+      id: low-example
+      severity: 3
+    metadata:
+      id: 4Nq2s8VdLm3xKpJzR7tYcW
+      hash: Hb6fTgWn9pQe2sXkD5mLa8
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "still could not bind()"
+`
+
+	const data = "2019-02-05T12:07:30Z still could not bind()\n"
+
+	var (
+		r      = New(math.MaxInt64, ux.NewUxEval())
+		report = ux.NewReport(nil)
+	)
+
+	r.SetMinSeverity(parser.SeverityHigh)
+
+	matchers, err := r.CompileRules([]byte(rules), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := resolve.PipeReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Run(context.Background(), matchers, sources, report); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := r.Stats().Rules; got != 1 {
+		t.Errorf("Expected 1 rule loaded, got %d", got)
+	}
+
+	dets := report.Detections()
+	if len(dets) != 1 || dets[0].Id != "critical-example" {
+		t.Errorf("Expected only the critical CRE, got %v", dets)
+	}
+}
+
+// filterRules are detected by filterData, unless filtered out.
+const filterRules = `rules:
+  - cre:
+      id: kafka-example
+      tags:
+        - Kafka
+    metadata:
+      id: yGbWBUFtXu7R2hhuNJnJ4k
+      hash: r7AM3gA9zeaG3sA7ykCi72
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "still could not bind()"
+  - cre:
+      id: postgres-example
+      applications:
+        - name: postgres
+    metadata:
+      id: 4Nq2s8VdLm3xKpJzR7tYcW
+      hash: Hb6fTgWn9pQe2sXkD5mLa8
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "still could not bind()"
+  - cre:
+      id: untagged-example
+    metadata:
+      id: Qm8vXc2LpR5tNw7ZkJ3hYd
+      hash: Tf4gBn6WsK9eLx2PqV7mRc
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "still could not bind()"
+`
+
+const filterData = "2019-02-05T12:07:30Z still could not bind()\n"
+
+// filteredDetections runs filterRules over filterData with r and returns
+// the CRE ids detected, sorted.
+func filteredDetections(t *testing.T, r *RuntimeT) []string {
+	return detectionsOf(t, r, filterRules)
+}
+
+func detectionsOf(t *testing.T, r *RuntimeT, rules string) []string {
+
+	report := ux.NewReport(nil)
+
+	matchers, err := r.CompileRules([]byte(rules), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := resolve.PipeReader(strings.NewReader(filterData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Run(context.Background(), matchers, sources, report); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, det := range report.Detections() {
+		got = append(got, det.Id)
+	}
+	slices.Sort(got)
+
+	return got
+}
+
+func TestTags(t *testing.T) {
+
+	testCases := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{"include", []string{"kafka", "postgres"}, nil, []string{"kafka-example", "postgres-example"}},
+		{"technology", []string{"POSTGRES"}, nil, []string{"postgres-example"}},
+		{"exclude", nil, []string{"kafka"}, []string{"postgres-example", "untagged-example"}},
+		{"both", []string{"kafka", "postgres"}, []string{"postgres"}, []string{"kafka-example"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(math.MaxInt64, ux.NewUxEval())
+			r.SetTags(tc.include, tc.exclude)
+			if got := filteredDetections(t, r); !slices.Equal(got, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCres(t *testing.T) {
+
+	testCases := []struct {
+		name    string
+		only    []string
+		disable []string
+		want    []string
+	}{
+		{"only", []string{"KAFKA-EXAMPLE", "untagged-example"}, nil, []string{"kafka-example", "untagged-example"}},
+		{"disable", nil, []string{"kafka-example"}, []string{"postgres-example", "untagged-example"}},
+		{"both", []string{"kafka-example", "postgres-example"}, []string{"postgres-example"}, []string{"kafka-example"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(math.MaxInt64, ux.NewUxEval())
+			r.SetCres(tc.only, tc.disable)
+			if got := filteredDetections(t, r); !slices.Equal(got, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+
+	// kafka-example is superseded by untagged-example
+	rules := strings.Replace(filterRules, "      id: kafka-example\n", "      id: kafka-example\n      supersededBy: untagged-example\n", 1)
+
+	r := New(math.MaxInt64, ux.NewUxEval())
+	if got, want := detectionsOf(t, r, rules), []string{"kafka-example", "postgres-example", "untagged-example"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := r.Deprecated(); len(got) != 1 || got[0].CreId != "kafka-example" || got[0].SupersededBy != "untagged-example" {
+		t.Errorf("Expected kafka-example deprecated, got %v", got)
+	}
+	if _, ok := r.Deprecation("KAFKA-EXAMPLE"); !ok {
+		t.Errorf("Expected the deprecation of kafka-example")
+	}
+
+	// Its detections merge into those of untagged-example
+	r = New(math.MaxInt64, ux.NewUxEval())
+	r.SetMapDeprecated(true)
+	if got, want := detectionsOf(t, r, rules), []string{"postgres-example", "untagged-example"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+
+	cross, err := os.ReadFile("../../../examples/42-cross-source-example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const rule = `  - cre:
+      id: %s
+    metadata:
+      id: %s
+      hash: %s
+    rule:
+      set:
+        event:
+          source: %s
+        match:
+          - value: "%s"
+`
+
+	rules := string(cross) +
+		fmt.Sprintf(rule, "app-example", "Qm8vXc2LpR5tNw7ZkJ3hYd", "Tf4gBn6WsK9eLx2PqV7mRc", "cre.log.app", "failed to allocate") +
+		fmt.Sprintf(rule, "quiet-example", "yGbWBUFtXu7R2hhuNJnJ4k", "r7AM3gA9zeaG3sA7ykCi72", "cre.log.app", "still could not bind()") +
+		fmt.Sprintf(rule, "kafka-example", "4Nq2s8VdLm3xKpJzR7tYcW", "Hb6fTgWn9pQe2sXkD5mLa8", "cre.log.kafka", "failed to allocate")
+
+	var (
+		dir = t.TempDir()
+		app = filepath.Join(dir, "app.log")
+	)
+
+	if err := os.WriteFile(app, []byte("2025-03-11T14:01:10Z failed to allocate 512MiB\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dss, err := resolve.ParseSources([]byte(fmt.Sprintf("version: 0.0.1\nsources:\n  - name: app\n    type: cre.log.app\n    locations:\n      - path: %s\n", app)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		r        = New(math.MaxInt64, ux.NewUxEval())
+		report   = ux.NewReport(nil)
+		coverage = NewCoverage()
+	)
+
+	r.SetCoverage(coverage)
+
+	matchers, err := r.CompileRules([]byte(rules), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Run(context.Background(), matchers, resolve.Resolve(dss), report); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		cre     string
+		status  CoverageStatusT
+		missing []string
+	}{
+		{"kafka-example", CoverageSkipped, []string{"cre.log.kafka"}},
+		{"cross-source-example", CoveragePartial, []string{"cre.log.kernel"}},
+		{"quiet-example", CoverageQuiet, nil},
+		{"app-example", CoverageDetected, nil},
+	}
+
+	got := coverage.Rules()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d rules, got %+v", len(want), got)
+	}
+	for i, w := range want {
+		if got[i].CreId != w.cre || got[i].Status != w.status || !slices.Equal(got[i].Missing, w.missing) {
+			t.Errorf("Expected %s %s missing %v, got %+v", w.cre, w.status, w.missing, got[i])
+		}
+	}
+	if got[3].Detections != 1 {
+		t.Errorf("Expected 1 detection of app-example, got %d", got[3].Detections)
+	}
+}
+
+func TestReload(t *testing.T) {
+
+	const rule = `  - cre:
+      id: %s
+    metadata:
+      id: %s
+      hash: %s
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "%s"
+`
+
+	var (
+		fn     = filepath.Join(t.TempDir(), "rules.yaml")
+		alpha  = fmt.Sprintf(rule, "alpha-example", "Q8vXk2LmN4pRt7Yw9ZbC3d", "Fj5Hs8Kd2Lq9Wx4Pz7Nm3R", "alpha")
+		beta   = fmt.Sprintf(rule, "beta-example", "Vb3Nq7Tx2Wk9Lm5Rp8Zc4H", "Gd6Ks9Mw3Xq7Ln2Pb5Rt8Y", "beta")
+		gamma  = fmt.Sprintf(rule, "gamma-example", "Hx4Pm8Rq2Tz6Wn9Kb3Ld7S", "Jc7Lt3Nx9Qw5Rm2Kd8Pz4B", "gamma")
+		paths  = []utils.RulePathT{{Path: fn, Type: utils.RuleTypeUser}}
+		r      = New(math.MaxInt64, ux.NewUxEval())
+		report = ux.NewReport(nil)
+		found  = make(chan string, 4)
+		pr, pw = io.Pipe()
+	)
+
+	write := func(rules ...string) {
+		if err := os.WriteFile(fn, []byte("rules:\n"+strings.Join(rules, "")), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r.SetFollow(true)
+	r.SetOnDetection(func(doc ux.ReportDocT) {
+		for _, d := range doc {
+			if cre, ok := d["cre"].(parser.ParseCreT); ok {
+				found <- cre.Id
+			}
+		}
+	})
+
+	write(alpha, beta)
+	matchers, err := r.CompileRulesPath(paths, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byHash := func(m *RuleMatchersT, hash string) any {
+		for key, h := range m.hash {
+			if h == hash {
+				return m.match[key]
+			}
+		}
+		return nil
+	}
+
+	// Beta changes and gamma is added; alpha keeps its matcher
+	write(alpha, strings.Replace(beta, `value: "beta"`, `value: "beta!"`, 1), gamma)
+	if err = r.ReloadRulesPaths(report, paths); err != nil {
+		t.Fatal(err)
+	}
+
+	live, _ := r.current()
+	if byHash(live, "Fj5Hs8Kd2Lq9Wx4Pz7Nm3R") != byHash(matchers, "Fj5Hs8Kd2Lq9Wx4Pz7Nm3R") {
+		t.Errorf("Expected the unchanged rule to keep its matcher")
+	}
+	if byHash(live, "Gd6Ks9Mw3Xq7Ln2Pb5Rt8Y") == byHash(matchers, "Gd6Ks9Mw3Xq7Ln2Pb5Rt8Y") {
+		t.Errorf("Expected the changed rule to get a new matcher")
+	}
+	if live.rules() != 3 {
+		t.Errorf("Expected 3 rules, got %d", live.rules())
+	}
+
+	// A broken rules file leaves the rules in place
+	write(alpha, "  - cre: [")
+	if err = r.ReloadRulesPaths(report, paths); err == nil {
+		t.Errorf("Expected invalid rules to fail")
+	}
+	if current, _ := r.current(); current != live {
+		t.Errorf("Expected the previous rules to stay in place")
+	}
+
+	// A running source picks up the reloaded rules
+	var (
+		now  = time.Now().UTC()
+		data strings.Builder
+	)
+	for data.Len() < 32*1024 {
+		fmt.Fprintf(&data, "%s joined cluster\n", now.Format(time.RFC3339Nano))
+	}
+	fmt.Fprintf(&data, "%s gamma\n", now.Format(time.RFC3339Nano))
+	fmt.Fprintf(&data, "%s heartbeat\n", now.Format(time.RFC3339Nano))
+	go io.WriteString(pw, data.String())
+
+	sources, err := resolve.PipeReader(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(context.Background(), matchers, sources, report)
+	}()
+
+	select {
+	case id := <-found:
+		if id != "gamma-example" {
+			t.Errorf("Expected gamma-example, got %s", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the reloaded rule to detect")
+	}
+
+	pw.Close()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLookback(t *testing.T) {
+
+	const rule = `  - cre:
+      id: %s
+    metadata:
+      id: %s
+      hash: %s
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "%s"
+`
+
+	var (
+		fn     = filepath.Join(t.TempDir(), "rules.yaml")
+		alpha  = fmt.Sprintf(rule, "alpha-example", "Q8vXk2LmN4pRt7Yw9ZbC3d", "Fj5Hs8Kd2Lq9Wx4Pz7Nm3R", "alpha")
+		gamma  = fmt.Sprintf(rule, "gamma-example", "Hx4Pm8Rq2Tz6Wn9Kb3Ld7S", "Jc7Lt3Nx9Qw5Rm2Kd8Pz4B", "gamma")
+		paths  = []utils.RulePathT{{Path: fn, Type: utils.RuleTypeUser}}
+		r      = New(math.MaxInt64, ux.NewUxEval())
+		report = ux.NewReport(nil)
+		found  = make(chan string, 4)
+		pr, pw = io.Pipe()
+	)
+
+	write := func(rules ...string) {
+		if err := os.WriteFile(fn, []byte("rules:\n"+strings.Join(rules, "")), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wait := func(want string) {
+		select {
+		case id := <-found:
+			if id != want {
+				t.Errorf("Expected %s, got %s", want, id)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %s to detect", want)
+		}
+	}
+
+	r.SetFollow(true)
+	r.SetLookback(time.Hour)
+	r.SetOnDetection(func(doc ux.ReportDocT) {
+		for _, d := range doc {
+			if cre, ok := d["cre"].(parser.ParseCreT); ok {
+				found <- cre.Id
+			}
+		}
+	})
+
+	write(alpha)
+	matchers, err := r.CompileRulesPath(paths, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		now  = time.Now().UTC()
+		data strings.Builder
+	)
+	for data.Len() < 32*1024 {
+		fmt.Fprintf(&data, "%s joined cluster\n", now.Format(time.RFC3339Nano))
+	}
+	fmt.Fprintf(&data, "%s gamma\n", now.Format(time.RFC3339Nano))
+	fmt.Fprintf(&data, "%s alpha\n", now.Format(time.RFC3339Nano))
+	fmt.Fprintf(&data, "%s heartbeat\n", now.Format(time.RFC3339Nano))
+	go io.WriteString(pw, data.String())
+
+	sources, err := resolve.PipeReader(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(context.Background(), matchers, sources, report)
+	}()
+
+	// The gamma line was read before alpha's
+	wait("alpha-example")
+
+	// A rule added later finds the gamma line in the history
+	write(alpha, gamma)
+	if err = r.ReloadRulesPaths(report, paths); err != nil {
+		t.Fatal(err)
+	}
+	wait("gamma-example")
+
+	pw.Close()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// The window forgets lines older than it
+	h := newHistory(time.Minute)
+	for i := 0; i < 5; i++ {
+		h.push(entry.LogEntry{Timestamp: int64(i) * int64(time.Minute), Line: fmt.Sprint(i)})
+	}
+	var kept []string
+	h.each(func(e entry.LogEntry) { kept = append(kept, e.Line) })
+	if strings.Join(kept, ",") != "3,4" {
+		t.Errorf("Expected the last minute of history, got %v", kept)
+	}
+}
+
+func TestLiterals(t *testing.T) {
+
+	named := map[string]parser.ParseTermT{
+		"bind": {StrValue: "still could not bind()"},
+	}
+
+	tests := []struct {
+		name   string
+		terms  []parser.ParseTermT
+		negate []parser.ParseTermT
+		want   []string
+	}{
+		{
+			name:  "values and regex",
+			terms: []parser.ParseTermT{{StrValue: "bind"}, {RegexValue: `reset by (peer|host)`}},
+			want:  []string{"still could not bind()", "reset by "},
+		},
+		{
+			name:  "nested",
+			terms: []parser.ParseTermT{{Set: &parser.ParseSetT{Match: []parser.ParseTermT{{StrValue: "timeout"}}}}},
+			want:  []string{"timeout"},
+		},
+		{
+			name:   "negated",
+			terms:  []parser.ParseTermT{{StrValue: "timeout"}},
+			negate: []parser.ParseTermT{{StrValue: "recovered"}},
+		},
+		{
+			name:  "jq",
+			terms: []parser.ParseTermT{{StrValue: "timeout"}, {JqValue: `select(.level == "error")`}},
+		},
+		{
+			name:  "short value",
+			terms: []parser.ParseTermT{{StrValue: "ok"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := literals(named, tc.terms, tc.negate)
+			if ok != (tc.want != nil) || !slices.Equal(got, tc.want) {
+				t.Errorf("Expected %q, got %q (%v)", tc.want, got, ok)
+			}
+		})
+	}
+}
+
+func TestLint(t *testing.T) {
+
+	const good = `rules:
+  - cre:
+      id: lint-good
+      title: Good rule
+      category: example
+      description: A rule with everything
+      cause: Something broke
+      mitigation: Fix it
+      references:
+        - https://example.com
+    metadata:
+      id: Q8vXk2LmN4pRt7Yw9ZbC3d
+      hash: Fj5Hs8Kd2Lq9Wx4Pz7Nm3R
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - regex: "ok [0-9]+"
+`
+
+	const bad = `rules:
+  - cre:
+      id: lint-good
+    metadata:
+      id: Q8vXk2LmN4pRt7Yw9ZbC3d
+      colour: red
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - regex: "([a-"
+`
+
+	var (
+		dir = t.TempDir()
+		r   = New(math.MaxInt64, ux.NewUxEval())
+	)
+
+	for fn, data := range map[string]string{"a.yaml": good, "b.yaml": bad, "c.yaml": "rules:\n  - cre: [\n", "d.txt": "not yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	issues, err := r.Lint([]utils.RulePathT{{Path: filepath.Join(dir, "a.yaml"), Type: utils.RuleTypeUser}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 0 {
+		t.Errorf("Expected no issues, got %v", issues)
+	}
+
+	issues, err = r.Lint([]utils.RulePathT{{Path: dir, Type: utils.RuleTypeUser}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type wantT struct {
+		file  string
+		line  int
+		level string
+		check string
+		msg   string
+	}
+
+	tests := []wantT{
+		{"b.yaml", 3, LintError, "duplicate", "duplicate cre id lint-good"},
+		{"b.yaml", 3, LintWarning, "metadata", "missing cre title"},
+		{"b.yaml", 5, LintError, "duplicate", "duplicate rule id Q8vXk2LmN4pRt7Yw9ZbC3d"},
+		{"b.yaml", 5, LintWarning, "metadata", "missing metadata hash"},
+		{"b.yaml", 6, LintError, "schema", `unknown field "colour" in metadata`},
+		{"b.yaml", 12, LintError, "compile", "error parsing regexp"},
+		{"c.yaml", 2, LintError, "yaml", "did not find expected node content"},
+	}
+
+	for _, want := range tests {
+		if !slices.ContainsFunc(issues, func(i LintIssueT) bool {
+			return filepath.Base(i.File) == want.file &&
+				i.Line == want.line &&
+				i.Level == want.level &&
+				i.Check == want.check &&
+				strings.Contains(i.Msg, want.msg)
+		}) {
+			t.Errorf("Expected %+v in %v", want, issues)
+		}
+	}
+
+	for _, i := range issues {
+		if filepath.Base(i.File) != "b.yaml" && filepath.Base(i.File) != "c.yaml" {
+			t.Errorf("Unexpected issue %v", i)
+		}
+	}
+}
+
+func TestRegistryConflicts(t *testing.T) {
+
+	const rule = `rules:
+  - cre:
+      id: %s
+      title: %s
+    metadata:
+      id: %s
+      hash: %s
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "%s"
+`
+
+	var (
+		dir       = t.TempDir()
+		community = filepath.Join(dir, "community.yaml")
+		acme      = filepath.Join(dir, "acme.yaml")
+		data      = "2019-02-05T12:07:30Z community says hi\n2019-02-05T12:07:31Z acme says hi\n"
+	)
+
+	write := func(fn, content string) {
+		if err := os.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(community, fmt.Sprintf(rule, "shared-example", "Community", "Q8vXk2LmN4pRt7Yw9ZbC3d", "Fj5Hs8Kd2Lq9Wx4Pz7Nm3R", "community says"))
+	write(acme, fmt.Sprintf(rule, "shared-example", "Acme", "Hx4Pm8Rq2Tz6Wn9Kb3Ld7S", "Jc7Lt3Nx9Qw5Rm2Kd8Pz4B", "acme says"))
+
+	run := func(paths []utils.RulePathT) (*RuntimeT, *ux.ReportT, error) {
+		var (
+			r      = New(math.MaxInt64, ux.NewUxEval())
+			report = ux.NewReport(nil)
+		)
+		matchers, err := r.CompileRulesPath(paths, report)
+		if err != nil {
+			return nil, nil, err
+		}
+		sources, err := resolve.PipeReader(strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r, report, r.Run(context.Background(), matchers, sources, report)
+	}
+
+	// The registry of higher priority wins, whatever the order of its paths
+	r, report, err := run([]utils.RulePathT{
+		{Path: community, Type: utils.RuleTypeUser, Registry: "community"},
+		{Path: acme, Type: utils.RuleTypeUser, Registry: "acme", Priority: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dets := report.Detections()
+	if len(dets) != 1 || dets[0].Title != "Acme" {
+		t.Errorf("Expected only the acme rule to detect, got %v", dets)
+	}
+
+	conflicts := r.Conflicts()
+	want := RuleConflictT{Cre: "shared-example", Id: "shared-example", Kept: "acme", Dropped: "community", Path: community}
+	if len(conflicts) != 1 || conflicts[0] != want {
+		t.Errorf("Expected %v, got %v", want, conflicts)
+	}
+
+	// Rules outside any registry may not be defined twice
+	if _, _, err = run([]utils.RulePathT{
+		{Path: community, Type: utils.RuleTypeUser},
+		{Path: acme, Type: utils.RuleTypeUser},
+	}); err == nil {
+		t.Error("Expected duplicate rules outside registries to fail")
+	}
+}
+
+func TestCorrelationSpill(t *testing.T) {
+
+	var (
+		dir    = t.TempDir()
+		window = time.Minute.Nanoseconds()
+		base   = time.Date(2025, 3, 11, 14, 0, 0, 0, time.UTC).UnixNano()
+		hosts  = []string{"node-0", "node-1", "node-2", "node-3"}
+	)
+
+	mc := &machineT{
+		addr:    &ast.AstNodeAddressT{Name: "seq"},
+		nTerms:  2,
+		keys:    []string{"hostname"},
+		horizon: window,
+		build: func() (lm.Matcher, error) {
+			return lm.NewMatchSeq(window,
+				lm.TermT{Type: lm.TermRaw, Value: termLine(0)},
+				lm.TermT{Type: lm.TermRaw, Value: termLine(1)},
+			)
+		},
+		parts: make(map[string]*partT),
+		// Room for a single partition's hits
+		state: newState(1, dir),
+	}
+	defer mc.state.close()
+
+	hit := func(host, line string, ts int64) matchz.HitsT {
+		return matchz.HitsT{
+			Count:   1,
+			Entries: []matchz.EntryT{{Timestamp: ts, Entry: []byte(line)}},
+			Entity:  matchz.EntityMetadataT{Labels: map[string]string{"hostname": host}},
+		}
+	}
+
+	for i, host := range hosts {
+		mc.add(0, hit(host, "failed to allocate on "+host, base+int64(i)), nil)
+		if out, err := mc.drain(math.MaxInt64); err != nil || len(out) != 0 {
+			t.Fatalf("Expected no match yet, got %v, %v", out, err)
+		}
+	}
+
+	if mc.state.spilled() == 0 {
+		t.Fatal("Expected partitions to be spilled")
+	}
+
+	var got []string
+	for _, host := range hosts {
+		mc.add(1, hit(host, "Out of memory on "+host, base+time.Second.Nanoseconds()), nil)
+		out, err := mc.drain(math.MaxInt64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range out {
+			if len(m.Entries) != 2 {
+				t.Fatalf("Expected both condition hits, got %+v", m.Entries)
+			}
+			got = append(got, string(m.Entries[0].Entry))
+		}
+	}
+
+	want := []string{
+		"failed to allocate on node-0",
+		"failed to allocate on node-1",
+		"failed to allocate on node-2",
+		"failed to allocate on node-3",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// Hits of a sequence arriving out of order, from sources read at different
+// paces, are matched once both sources are read past them
+func TestCorrelationMark(t *testing.T) {
+
+	var (
+		window = time.Minute.Nanoseconds()
+		base   = time.Date(2025, 3, 11, 14, 0, 0, 0, time.UTC).UnixNano()
+	)
+
+	mc := &machineT{
+		addr:   &ast.AstNodeAddressT{Name: "seq"},
+		nTerms: 2,
+		parts:  make(map[string]*partT),
+		build: func() (lm.Matcher, error) {
+			return lm.NewMatchSeq(window,
+				lm.TermT{Type: lm.TermRaw, Value: termLine(0)},
+				lm.TermT{Type: lm.TermRaw, Value: termLine(1)},
+			)
+		},
+	}
+
+	hit := func(line string, ts int64) matchz.HitsT {
+		return matchz.HitsT{Count: 1, Entries: []matchz.EntryT{{Timestamp: ts, Entry: []byte(line)}}}
+	}
+
+	// The second step is read first, from the source that is ahead
+	mc.add(1, hit("Out of memory", base+2), nil)
+	if out, err := mc.drain(base); err != nil || len(out) != 0 {
+		t.Fatalf("Expected no match before the mark, got %v, %v", out, err)
+	}
+
+	mc.add(0, hit("failed to allocate", base+1), nil)
+	out, err := mc.drain(base + 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || len(out[0].Entries) != 2 || string(out[0].Entries[0].Entry) != "failed to allocate" {
+		t.Fatalf("Expected the sequence matched in time order, got %+v", out)
+	}
+
+	// Past the backlog, the oldest are matched without waiting
+	for i := range correlateBacklog + 1 {
+		mc.add(0, hit("failed to allocate", base+10+int64(i)), nil)
+	}
+	if _, err = mc.drain(base); err != nil {
+		t.Fatal(err)
+	}
+	if len(mc.pending) != correlateBacklog {
+		t.Errorf("Expected %d hits held, got %d", correlateBacklog, len(mc.pending))
+	}
+}
+
+// A resumed run matches the lines stamped at the checkpoint that it had not
+// scanned, and a sequence that straddles the checkpoint, without reporting
+// again what the last run did
+func TestResume(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: resume-seq
+    metadata:
+      id: Rs3qTvX8kLmN2pWcYd6bFh
+      hash: Ht5wQz7nBv2LkPx9RmYc4J
+    rule:
+      sequence:
+        event:
+          source: cre.log.kafka
+        window: 10s
+        order:
+          - value: "alpha"
+          - value: "beta"
+  - cre:
+      id: resume-line
+    metadata:
+      id: Lp8dVk2xNq6TzW4hMb9cRf
+      hash: Gj3mXs7vPq2Kd8Wn5Bt6Ly
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "gamma"
+`
+
+	var (
+		dir   = t.TempDir()
+		path  = filepath.Join(dir, "app.log")
+		first = `2019-02-05T12:07:30Z start
+2019-02-05T12:07:31Z gamma
+2019-02-05T12:07:32Z alpha
+2019-02-05T12:07:33Z x
+2019-02-05T12:07:33Z y
+`
+		rest = `2019-02-05T12:07:33Z gamma
+2019-02-05T12:07:34Z beta
+`
+	)
+
+	run := func(data string) (seqs, lines int) {
+		t.Helper()
+
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cp, err := checkpoint.OpenPath(filepath.Join(dir, "cp.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cp.Close()
+
+		var (
+			r      = New(math.MaxInt64, ux.NewUxEval())
+			report = ux.NewReport(nil)
+		)
+		r.SetCheckpoint(cp)
+
+		matchers, err := r.CompileRules([]byte(rules), report)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ds, err := resolve.ParseSources([]byte("sources:\n  - name: app\n    type: cre.log.kafka\n    locations:\n      - path: " + path + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.Run(context.Background(), matchers, resolve.Resolve(ds, resolve.WithResume(cp.Resume())), report); err != nil {
+			t.Fatal(err)
+		}
+
+		return len(report.Occurrences("resume-seq")), len(report.Occurrences("resume-line"))
+	}
+
+	if seqs, lines := run(first); seqs != 0 || lines != 1 {
+		t.Fatalf("Expected 0 sequences and 1 line, got %d and %d", seqs, lines)
+	}
+
+	if seqs, lines := run(first + rest); seqs != 1 || lines != 1 {
+		t.Errorf("Expected the resumed run to add 1 sequence and 1 line, got %d and %d", seqs, lines)
+	}
+}
+
+func TestCollapseCount(t *testing.T) {
+
+	const rules = `rules:
+  - cre:
+      id: count-example
+    metadata:
+      id: kq2RVJd8vC6Pz1uWmXbN4T
+      hash: Hs7bQe3YtLm9XcV2pZa5Rk
+    rule:
+      set:
+        window: 10s
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "connection refused"
+            count: 3
+`
+
+	var data strings.Builder
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(&data, "2019-02-05T12:07:%02dZ connection refused\n", 30+i)
+	}
+	data.WriteString("2019-02-05T12:07:40Z recovered\n")
+
+	var (
+		r      = New(math.MaxInt64, ux.NewUxEval())
+		report = ux.NewReport(nil)
+	)
+
+	r.SetCollapse(true)
+
+	matchers, err := r.CompileRules([]byte(rules), report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := resolve.PipeReader(strings.NewReader(data.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Run(context.Background(), matchers, sources, report); err != nil {
+		t.Fatal(err)
+	}
+
+	hits := report.Occurrences("count-example")
+	if len(hits) != 1 || len(hits[0].Entries) != 3 {
+		t.Fatalf("Expected 1 detection of 3 lines, got %+v", hits)
+	}
+	if got := r.Stats().Collapsed; got != 1 {
+		t.Errorf("Expected 1 collapsed line, got %d", got)
+	}
+}
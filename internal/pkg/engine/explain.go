@@ -0,0 +1,399 @@
+package engine
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"github.com/prequel-dev/prequel-logmatch/pkg/entry"
+	lm "github.com/prequel-dev/prequel-logmatch/pkg/match"
+	"github.com/rs/zerolog/log"
+)
+
+// An explanation traces the rules of one CRE while the sources are
+// scanned: every line that satisfies one of the rule's conditions is
+// recorded with its source and position. Once the run ends, each hit of a
+// detection is tied back to the conditions it satisfied and the window and
+// order constraints are checked against the hit timestamps. Conditions are
+// built from the rule terms as the engine builds them, and lines are
+// checked without a lock; only the lines that satisfy one are recorded
+// under it.
+
+const (
+	explainMaxLines = 100_000 // lines recorded before tracing stops
+)
+
+var (
+	ErrExplainUnknownCre = errors.New("no rule for CRE")
+)
+
+type ExplainT struct {
+	creId   string
+	traced  atomic.Pointer[tracedT]
+	mux     sync.Mutex
+	seen    map[seenKeyT]seenT
+	dropped int64
+}
+
+// tracedT is replaced, never changed, when rules are added so lines can be
+// checked against it while the sources are scanned.
+type tracedT struct {
+	rules []*explainRuleT
+	conds []condT // of every rule, numbered across them
+}
+
+type explainRuleT struct {
+	hash   string
+	kind   string // set or sequence
+	window string
+}
+
+type condT struct {
+	rule   int
+	label  string
+	negate bool
+	match  lm.MatchFunc
+}
+
+type seenKeyT struct {
+	ts   int64
+	line string
+}
+
+// seenT is where a line that satisfied a condition was read.
+type seenT struct {
+	src   string
+	pos   int64 // line number within the source's stream
+	conds []int
+}
+
+func NewExplain(creId string) *ExplainT {
+	e := &ExplainT{
+		creId: creId,
+		seen:  make(map[seenKeyT]seenT),
+	}
+	e.traced.Store(&tracedT{})
+	return e
+}
+
+// SetExplain traces the rules of the CRE in e. Set it before loading the
+// rules.
+func (r *RuntimeT) SetExplain(e *ExplainT) {
+	r.explain = e
+}
+
+// addRules keeps the conditions of the rules that detect the CRE.
+func (e *ExplainT) addRules(rules *parser.RulesT, describe describeFn) {
+	if e == nil {
+		return
+	}
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	var (
+		prev = e.traced.Load()
+		next = &tracedT{rules: slices.Clone(prev.rules), conds: slices.Clone(prev.conds)}
+	)
+
+	for _, rule := range rules.Rules {
+		if rule.Cre.Id != e.creId {
+			continue
+		}
+
+		var (
+			er    = &explainRuleT{hash: rule.Metadata.Hash}
+			conds []condT
+		)
+
+		switch {
+		case rule.Rule.Sequence != nil:
+			er.kind = "sequence"
+			er.window = rule.Rule.Sequence.Window
+			conds = flattenTerms(describe, rules.TermsT, "order", rule.Rule.Sequence.Order, false)
+			conds = append(conds, flattenTerms(describe, rules.TermsT, "negate", rule.Rule.Sequence.Negate, true)...)
+		case rule.Rule.Set != nil:
+			er.kind = "set"
+			er.window = rule.Rule.Set.Window
+			conds = flattenTerms(describe, rules.TermsT, "match", rule.Rule.Set.Match, false)
+			conds = append(conds, flattenTerms(describe, rules.TermsT, "negate", rule.Rule.Set.Negate, true)...)
+		}
+
+		for i := range conds {
+			conds[i].rule = len(next.rules)
+		}
+
+		next.rules = append(next.rules, er)
+		next.conds = append(next.conds, conds...)
+	}
+
+	e.traced.Store(next)
+}
+
+// describeFn labels a raw term that stands for a cel or rate condition.
+type describeFn func(value string) (string, bool)
+
+// flattenTerms builds a condition for each value, regex or jq term,
+// descending into nested sets and sequences.
+func flattenTerms(describe describeFn, named map[string]parser.ParseTermT, path string, terms []parser.ParseTermT, negate bool) []condT {
+
+	var out []condT
+
+	for i, t := range terms {
+
+		label := fmt.Sprintf("%s[%d]", path, i)
+
+		if t.StrValue != "" {
+			if nt, ok := named[t.StrValue]; ok {
+				label += " " + t.StrValue
+				t = nt
+			}
+		}
+
+		switch {
+		case t.Sequence != nil:
+			out = append(out, flattenTerms(describe, named, label+".order", t.Sequence.Order, negate)...)
+			out = append(out, flattenTerms(describe, named, label+".negate", t.Sequence.Negate, !negate)...)
+			continue
+		case t.Set != nil:
+			out = append(out, flattenTerms(describe, named, label+".match", t.Set.Match, negate)...)
+			out = append(out, flattenTerms(describe, named, label+".negate", t.Set.Negate, !negate)...)
+			continue
+		}
+
+		// Markers stand for cel, rate and plugin conditions; the lines
+		// observed still carry them
+		tt, ok := termOf(t)
+		if !ok {
+			continue
+		}
+		if desc, isMarker := describe(t.StrValue); isMarker {
+			label += " " + desc
+		} else {
+			label += fmt.Sprintf(" %s %q", termKind(tt.Type), tt.Value)
+		}
+
+		if t.Count > 1 {
+			label += fmt.Sprintf(" count %d", t.Count)
+		}
+
+		m, err := tt.NewMatcher()
+		if err != nil {
+			log.Warn().Err(err).Str("term", label).Msg("Cannot explain term")
+			continue
+		}
+
+		out = append(out, condT{label: label, negate: negate, match: m})
+	}
+
+	return out
+}
+
+func termKind(tt lm.TermTypeT) string {
+	switch tt {
+	case lm.TermRegex:
+		return "regex"
+	case lm.TermJqJson:
+		return "jq"
+	}
+	return "value"
+}
+
+// Rules returns the number of rules traced.
+func (e *ExplainT) Rules() int {
+	return len(e.traced.Load().rules)
+}
+
+func (e *ExplainT) traces(ruleHash string) bool {
+	if e == nil {
+		return false
+	}
+
+	return slices.ContainsFunc(e.traced.Load().rules, func(er *explainRuleT) bool {
+		return er.hash == ruleHash
+	})
+}
+
+// observe records le if it satisfies any condition. Sources scan in
+// parallel; the same line read from several keeps its first position.
+func (e *ExplainT) observe(src string, pos int64, le entry.LogEntry) {
+
+	var conds []int
+	for i, c := range e.traced.Load().conds {
+		if c.match(le.Line) {
+			conds = append(conds, i)
+		}
+	}
+
+	if len(conds) == 0 {
+		return
+	}
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	key := seenKeyT{ts: le.Timestamp, line: stripMarkers(le.Line)}
+	if prev, ok := e.seen[key]; ok {
+		if cmp.Or(cmp.Compare(prev.src, src), cmp.Compare(prev.pos, pos)) <= 0 {
+			return
+		}
+	} else if len(e.seen) >= explainMaxLines {
+		e.dropped++
+		return
+	}
+
+	e.seen[key] = seenT{src: src, pos: pos, conds: conds}
+}
+
+// Fprint explains each detection of the CRE in report.
+func (e *ExplainT) Fprint(w io.Writer, report *ux.ReportT) {
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	traced := e.traced.Load()
+
+	for ri, er := range traced.rules {
+		fmt.Fprintf(w, "\nExplain %s (rule %s): %s", e.creId, er.hash, er.kind)
+		if er.window != "" {
+			fmt.Fprintf(w, ", window %s", er.window)
+		}
+		fmt.Fprintln(w)
+		for i, c := range traced.conds {
+			if c.rule == ri {
+				fmt.Fprintf(w, "  [%d] %s\n", i, c.label)
+			}
+		}
+	}
+
+	detections := report.Occurrences(e.creId)
+	if len(detections) == 0 {
+		fmt.Fprintf(w, "\nNo detections of %s\n", e.creId)
+	}
+
+	for i, hits := range detections {
+		fmt.Fprintf(w, "\nDetection %d:\n", i+1)
+		e.explainHits(w, traced, hits)
+	}
+
+	if e.dropped > 0 {
+		fmt.Fprintf(w, "\nTracing stopped after %d lines; %d later lines are not located\n", explainMaxLines, e.dropped)
+	}
+}
+
+func (e *ExplainT) explainHits(w io.Writer, traced *tracedT, hits matchz.HitsT) {
+
+	var (
+		first, last int64
+		inOrder     = true
+		rule        = -1
+	)
+
+	for i, h := range hits.Entries {
+
+		if i == 0 || h.Timestamp < first {
+			first = h.Timestamp
+		}
+		if h.Timestamp > last {
+			last = h.Timestamp
+		}
+
+		ts := time.Unix(0, h.Timestamp).UTC().Format(time.RFC3339Nano)
+		line := string(h.Entry)
+
+		s, ok := e.seen[seenKeyT{ts: h.Timestamp, line: line}]
+		if !ok {
+			fmt.Fprintf(w, "  %s  (not located)  %s\n", ts, line)
+			continue
+		}
+
+		var labels []string
+		for _, c := range s.conds {
+			labels = append(labels, fmt.Sprintf("[%d]", c))
+		}
+		fmt.Fprintf(w, "  %s  %s:%d  matched %s  %s\n", ts, s.src, s.pos, strings.Join(labels, ","), line)
+
+		if rule < 0 {
+			rule = traced.conds[s.conds[0]].rule
+		}
+
+		// Hits of a sequence are reported in the order of its conditions
+		if i > 0 && h.Timestamp < hits.Entries[i-1].Timestamp {
+			inOrder = false
+		}
+	}
+
+	if rule < 0 {
+		return
+	}
+
+	var (
+		er   = traced.rules[rule]
+		span = time.Duration(last - first)
+	)
+
+	if er.window != "" {
+		if window, err := time.ParseDuration(er.window); err == nil {
+			verdict := "satisfied"
+			if span > window {
+				verdict = "NOT satisfied"
+			}
+			fmt.Fprintf(w, "  window: hits span %s within %s, %s\n", span, window, verdict)
+		}
+	}
+
+	if er.kind == "sequence" {
+		verdict := "satisfied: each condition matched at or after the previous one"
+		if !inOrder {
+			verdict = "NOT satisfied: hits are out of order"
+		}
+		fmt.Fprintf(w, "  order: %s\n", verdict)
+	}
+
+	// A negated condition seen inside the span points at a false positive
+	// or at negate options (window, slide, anchor) widening the check
+	type negatedT struct {
+		src  string
+		pos  int64
+		cond int
+	}
+
+	var negated []negatedT
+	for k, s := range e.seen {
+		if k.ts < first || k.ts > last {
+			continue
+		}
+		for _, c := range s.conds {
+			if traced.conds[c].rule == rule && traced.conds[c].negate {
+				negated = append(negated, negatedT{src: s.src, pos: s.pos, cond: c})
+			}
+		}
+	}
+
+	if slices.ContainsFunc(traced.conds, func(c condT) bool { return c.rule == rule && c.negate }) {
+		if len(negated) == 0 {
+			fmt.Fprintln(w, "  negate: no negated condition matched within the span")
+			return
+		}
+
+		// By position, not in the order of the map
+		slices.SortFunc(negated, func(a, b negatedT) int {
+			return cmp.Or(cmp.Compare(a.src, b.src), cmp.Compare(a.pos, b.pos), cmp.Compare(a.cond, b.cond))
+		})
+
+		at := make([]string, 0, len(negated))
+		for _, n := range negated {
+			at = append(at, fmt.Sprintf("%s:%d [%d]", n.src, n.pos, n.cond))
+		}
+		fmt.Fprintf(w, "  negate: matched within the span at %s\n", strings.Join(at, ", "))
+	}
+}
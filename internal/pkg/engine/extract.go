@@ -0,0 +1,292 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/itchyny/gojq"
+	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	lm "github.com/prequel-dev/prequel-logmatch/pkg/match"
+	"github.com/rs/zerolog/log"
+)
+
+// A condition may declare values to extract from the lines it matches:
+//
+//	match:
+//	  - regex: 'upstream timed out'
+//	    extract:
+//	      - name: latency_ms
+//	        regex: 'request_time=([0-9.]+)'
+//
+// A regex extract reads its group named after the extract, or else its
+// first group, or else the whole match; a jq extract reads the result of
+// the query on the JSON line. Values that do not read as numbers are
+// skipped. An extract only reads the entries of a hit that its condition
+// matched; for a cel, rate or plugin condition, which cannot be checked
+// again on the entry, those that no other condition of the rule matched.
+// Each hit entry carries the values extracted from it and the report
+// aggregates them per detection.
+
+var (
+	ErrExtractTerm = errors.New("extract needs a name and one of regex or jq")
+)
+
+type extractorT struct {
+	name  string
+	regex *regexp.Regexp
+	group int
+	jq    *gojq.Code
+	match lm.MatchFunc   // lines of its condition, if it can be checked
+	other []lm.MatchFunc // of the other conditions, if it cannot
+}
+
+func newExtractor(e parser.ParseExtractT) (*extractorT, error) {
+
+	x := &extractorT{name: e.Name}
+
+	switch {
+	case e.Name == "":
+		return nil, ErrExtractTerm
+	case e.RegexValue != "" && e.JqValue == "":
+		exp, err := regexp.Compile(e.RegexValue)
+		if err != nil {
+			return nil, fmt.Errorf("extract %s: %w", e.Name, err)
+		}
+		x.regex = exp
+		if x.group = exp.SubexpIndex(e.Name); x.group < 0 {
+			x.group = min(exp.NumSubexp(), 1)
+		}
+	case e.JqValue != "" && e.RegexValue == "":
+		query, err := gojq.Parse(e.JqValue)
+		if err != nil {
+			return nil, fmt.Errorf("extract %s: %w", e.Name, err)
+		}
+		if x.jq, err = gojq.Compile(query); err != nil {
+			return nil, fmt.Errorf("extract %s: %w", e.Name, err)
+		}
+	default:
+		return nil, ErrExtractTerm
+	}
+
+	return x, nil
+}
+
+// reads reports whether line is one the extract is declared on.
+func (x *extractorT) reads(line string) bool {
+
+	if x.match != nil {
+		return x.match(line)
+	}
+
+	for _, m := range x.other {
+		if m(line) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// extract reads the value from line.
+func (x *extractorT) extract(line []byte) (float64, bool) {
+
+	if x.regex != nil {
+		m := x.regex.FindSubmatch(line)
+		if m == nil {
+			return 0, false
+		}
+		v, err := strconv.ParseFloat(string(m[x.group]), 64)
+		return v, err == nil
+	}
+
+	var doc any
+	if err := json.Unmarshal(line, &doc); err != nil {
+		return 0, false
+	}
+
+	res, ok := x.jq.Run(doc).Next()
+	if !ok {
+		return 0, false
+	}
+
+	switch v := res.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+// addExtractors keeps the extracts declared in the rules, by rule hash.
+// Negated conditions cannot declare any. Called with the lock held.
+func (r *RuntimeT) addExtractors(rules *parser.RulesT) error {
+
+	for _, rule := range rules.Rules {
+
+		var terms []parser.ParseTermT
+		switch {
+		case rule.Rule.Sequence != nil:
+			terms = rule.Rule.Sequence.Order
+		case rule.Rule.Set != nil:
+			terms = rule.Rule.Set.Match
+		}
+
+		xs, matches, err := extractors(rules.TermsT, terms)
+		if err != nil {
+			log.Error().Err(err).Str("rule_hash", rule.Metadata.Hash).Msg("Invalid extract")
+			return err
+		}
+
+		if len(xs) == 0 {
+			continue
+		}
+
+		for _, x := range xs {
+			if x.match == nil {
+				x.other = matches
+			}
+		}
+
+		if r.extracts == nil {
+			r.extracts = make(map[string][]*extractorT)
+		}
+		r.extracts[rule.Metadata.Hash] = xs
+	}
+
+	return nil
+}
+
+// extractors returns the extracts declared on terms, and the matchers of
+// the terms that can be checked on a line.
+func extractors(named map[string]parser.ParseTermT, terms []parser.ParseTermT) ([]*extractorT, []lm.MatchFunc, error) {
+
+	var (
+		out     []*extractorT
+		matches []lm.MatchFunc
+	)
+
+	for _, t := range terms {
+
+		if nt, ok := named[t.StrValue]; ok && t.StrValue != "" {
+			t = nt
+		}
+
+		var nested []parser.ParseTermT
+		switch {
+		case t.Sequence != nil:
+			nested = t.Sequence.Order
+		case t.Set != nil:
+			nested = t.Set.Match
+		}
+
+		xs, own, err := extractors(named, nested)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = append(out, xs...)
+
+		if len(nested) == 0 {
+			m, err := termMatch(t)
+			if err != nil {
+				return nil, nil, err
+			}
+			if m != nil {
+				own = append(own, m)
+			}
+		}
+		matches = append(matches, own...)
+
+		for _, e := range t.Extract {
+			x, err := newExtractor(e)
+			if err != nil {
+				return nil, nil, err
+			}
+			x.match = anyMatch(own)
+			out = append(out, x)
+		}
+	}
+
+	return out, matches, nil
+}
+
+// termOf returns the term the engine matches lines of a value, regex or jq
+// condition with.
+func termOf(t parser.ParseTermT) (lm.TermT, bool) {
+	switch {
+	case t.StrValue != "":
+		return lm.TermT{Type: lm.TermRaw, Value: t.StrValue}, true
+	case t.RegexValue != "":
+		return lm.TermT{Type: lm.TermRegex, Value: t.RegexValue}, true
+	case t.JqValue != "":
+		return lm.TermT{Type: lm.TermJqJson, Value: t.JqValue}, true
+	}
+	return lm.TermT{}, false
+}
+
+// termMatch returns the matcher of a value, regex or jq term; nil for a
+// cel, rate or plugin condition, whose marker a hit entry no longer has.
+func termMatch(t parser.ParseTermT) (lm.MatchFunc, error) {
+
+	tt, ok := termOf(t)
+	if !ok || isMarker(t.StrValue) {
+		return nil, nil
+	}
+
+	return tt.NewMatcher()
+}
+
+func anyMatch(ms []lm.MatchFunc) lm.MatchFunc {
+	switch len(ms) {
+	case 0:
+		return nil
+	case 1:
+		return ms[0]
+	}
+	return func(line string) bool {
+		for _, m := range ms {
+			if m(line) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// extractValues sets the values extracted from each entry of a rule's hit,
+// by the extracts declared on the condition the entry matched.
+func (r *RuntimeT) extractValues(ruleHash string, m *matchz.HitsT) {
+
+	r.mux.RLock()
+	xs := r.extracts[ruleHash]
+	r.mux.RUnlock()
+
+	if len(xs) == 0 {
+		return
+	}
+
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		for _, x := range xs {
+			if !x.reads(string(e.Entry)) {
+				continue
+			}
+			v, ok := x.extract(e.Entry)
+			if !ok {
+				continue
+			}
+			if e.Values == nil {
+				e.Values = make(map[string]float64, len(xs))
+			}
+			e.Values[x.name] = v
+		}
+	}
+}
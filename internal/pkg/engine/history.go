@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/prequel-dev/prequel-logmatch/pkg/entry"
+)
+
+// A followed source keeps the lines it read within the lookback window,
+// so that conditions of rules reloaded while it is followed are matched
+// against the recent past as well as the lines to come. Only new or
+// changed conditions replay the history; unchanged ones already saw it.
+
+const (
+	historyMax = 1 << 16 // lines kept per source whatever the window
+)
+
+type historyT struct {
+	window int64
+	lines  []entry.LogEntry
+	head   int
+}
+
+// SetLookback keeps window of history per followed source for reloaded
+// rules to match. A window of zero keeps none.
+func (r *RuntimeT) SetLookback(window time.Duration) {
+	r.lookback = window
+}
+
+func newHistory(window time.Duration) *historyT {
+	if window <= 0 {
+		return nil
+	}
+	return &historyT{window: int64(window)}
+}
+
+// push records a line as read, before it is annotated, and forgets those
+// that fell out of the window.
+func (h *historyT) push(e entry.LogEntry) {
+	if h == nil {
+		return
+	}
+
+	h.lines = append(h.lines, e)
+
+	oldest := e.Timestamp - h.window
+	for h.head < len(h.lines) && (h.lines[h.head].Timestamp < oldest || len(h.lines)-h.head > historyMax) {
+		h.lines[h.head] = entry.LogEntry{}
+		h.head++
+	}
+
+	// Reclaim the forgotten prefix once it is half the slice
+	if h.head > len(h.lines)/2 {
+		n := copy(h.lines, h.lines[h.head:])
+		clear(h.lines[n:])
+		h.lines = h.lines[:n]
+		h.head = 0
+	}
+}
+
+// each calls f with the lines in the window, oldest first.
+func (h *historyT) each(f func(entry.LogEntry)) {
+	if h == nil {
+		return
+	}
+	for _, e := range h.lines[h.head:] {
+		f(e)
+	}
+}
+
+func (h *historyT) len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.lines) - h.head
+}
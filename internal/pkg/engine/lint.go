@@ -0,0 +1,351 @@
+package engine
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prequel-dev/preq/internal/pkg/catalog"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"github.com/prequel-dev/prequel-compiler/pkg/pqerr"
+	"gopkg.in/yaml.v3"
+)
+
+// Lint checks rule files the way a scan would load them, and more: the
+// fields of each rule against the rule schema, its conditions by compiling
+// them, its ids against those of every other rule linted, and its CRE for
+// the metadata a published rule carries. Each problem is reported with the
+// line it is on. Errors stop a rule from loading; warnings do not.
+
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintIssueT is a problem found in a rules file.
+type LintIssueT struct {
+	File  string `json:"file"`
+	Line  int    `json:"line,omitempty"`
+	Col   int    `json:"col,omitempty"`
+	Level string `json:"level"`
+	Check string `json:"check"`
+	CreId string `json:"cre_id,omitempty"`
+	Msg   string `json:"message"`
+}
+
+func (i LintIssueT) String() string {
+	pos := i.File
+	switch {
+	case i.Line > 0 && i.Col > 0:
+		pos = fmt.Sprintf("%s:%d:%d", i.File, i.Line, i.Col)
+	case i.Line > 0:
+		pos = fmt.Sprintf("%s:%d", i.File, i.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s [%s]", pos, i.Level, i.Msg, i.Check)
+}
+
+var (
+	yamlLine  = regexp.MustCompile(`line (\d+)`)
+	termValue = regexp.MustCompile(`value:'(.*?)': `)
+
+	// Fields a published CRE describes itself with
+	wantCre = []string{"title", "category", "description", "cause", "mitigation", "references"}
+
+	ruleKeys = yamlKeys(reflect.TypeOf(parser.ParseRuleT{}))
+	creKeys  = yamlKeys(reflect.TypeOf(parser.ParseCreT{}))
+	metaKeys = yamlKeys(reflect.TypeOf(parser.ParseRuleMetadataT{}))
+	bodyKeys = yamlKeys(reflect.TypeOf(parser.ParseRuleDataT{}))
+)
+
+// Lint checks the rule files at paths, and the YAML files under those that
+// are directories.
+func (r *RuntimeT) Lint(paths []utils.RulePathT) ([]LintIssueT, error) {
+
+	var (
+		issues []LintIssueT
+		seen   = make(map[string]LintIssueT) // id -> where it was first defined
+	)
+
+	for _, rp := range paths {
+
+		files, err := catalog.Files(rp.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fn := range files {
+			issues = append(issues, r.lintFile(utils.RulePathT{Path: fn, Type: rp.Type}, fn == rp.Path, seen)...)
+		}
+	}
+
+	slices.SortStableFunc(issues, func(a, b LintIssueT) int {
+		return cmp.Or(strings.Compare(a.File, b.File), cmp.Compare(a.Line, b.Line), cmp.Compare(a.Col, b.Col))
+	})
+
+	return issues, nil
+}
+
+func (r *RuntimeT) lintFile(rp utils.RulePathT, named bool, seen map[string]LintIssueT) []LintIssueT {
+
+	var issues []LintIssueT
+
+	add := func(n *yaml.Node, level, check, creId, msg string) {
+		i := LintIssueT{File: rp.Path, Level: level, Check: check, CreId: creId, Msg: msg}
+		if n != nil {
+			i.Line, i.Col = n.Line, n.Column
+		}
+		issues = append(issues, i)
+	}
+
+	data, err := os.ReadFile(rp.Path)
+	if err != nil {
+		add(nil, LintError, "read", "", err.Error())
+		return issues
+	}
+
+	docs, err := yamlDocs(data)
+	if err != nil {
+		i := LintIssueT{File: rp.Path, Level: LintError, Check: "yaml", Msg: err.Error()}
+		if m := yamlLine.FindStringSubmatch(err.Error()); m != nil {
+			i.Line, _ = strconv.Atoi(m[1])
+		}
+		return append(issues, i)
+	}
+
+	var nRules int
+	for _, doc := range docs {
+		rules, ok := child(doc, "rules")
+		if !ok {
+			continue
+		}
+		if rules.Kind != yaml.SequenceNode {
+			add(rules, LintError, "schema", "", "rules must be a list")
+			continue
+		}
+		for _, rule := range rules.Content {
+			nRules++
+			issues = append(issues, lintRule(rp.Path, rule, seen)...)
+		}
+	}
+
+	// Files found in a directory need not hold rules
+	if nRules == 0 {
+		if named {
+			add(nil, LintError, "schema", "", "no rules found")
+		}
+		return issues
+	}
+
+	// Compile the conditions as a scan would
+	report := ux.NewReport(nil)
+	nObjs, _, err := compileRulePath(r.getRuntimeCb(report), rp, r.readerOpts()...)
+	if err == nil {
+		_, err = loadNodeObjs(nObjs)
+	}
+	if err != nil {
+		i := LintIssueT{File: rp.Path, Level: LintError, Check: "compile", Msg: err.Error()}
+		var perr *pqerr.Error
+		if errors.As(err, &perr) {
+			i.Line, i.Col, i.CreId = perr.Pos.Line, perr.Pos.Col, perr.CreId
+			if perr.Err != nil {
+				i.Msg = perr.Err.Error()
+				if perr.Msg != "" {
+					i.Msg = perr.Msg + ": " + i.Msg
+				}
+			}
+		}
+		// Matchers that fail to build name their term, not where it is
+		if m := termValue.FindStringSubmatch(i.Msg); i.Line == 0 && m != nil {
+			for _, doc := range docs {
+				if n := findScalar(doc, m[1]); n != nil {
+					i.Line, i.Col = n.Line, n.Column
+					break
+				}
+			}
+		}
+		issues = append(issues, i)
+	}
+
+	return issues
+}
+
+// lintRule checks the fields of one rule and that its ids are unique.
+func lintRule(file string, rule *yaml.Node, seen map[string]LintIssueT) []LintIssueT {
+
+	var (
+		issues []LintIssueT
+		creId  string
+	)
+
+	add := func(n *yaml.Node, level, check, msg string) {
+		issues = append(issues, LintIssueT{
+			File:  file,
+			Line:  n.Line,
+			Col:   n.Column,
+			Level: level,
+			Check: check,
+			CreId: creId,
+			Msg:   msg,
+		})
+	}
+
+	if rule.Kind != yaml.MappingNode {
+		add(rule, LintError, "schema", "a rule must be a mapping")
+		return issues
+	}
+
+	cre, _ := child(rule, "cre")
+	meta, _ := child(rule, "metadata")
+	body, _ := child(rule, "rule")
+
+	if n, ok := child(cre, "id"); ok {
+		creId = n.Value
+	}
+
+	unknown := func(n *yaml.Node, section string, known []string) {
+		if n == nil || n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i < len(n.Content); i += 2 {
+			if k := n.Content[i]; !slices.Contains(known, k.Value) {
+				add(k, LintError, "schema", fmt.Sprintf("unknown field %q in %s", k.Value, section))
+			}
+		}
+	}
+
+	unknown(rule, "rule", ruleKeys)
+	unknown(cre, "cre", creKeys)
+	unknown(meta, "metadata", metaKeys)
+	unknown(body, "rule body", bodyKeys)
+
+	switch {
+	case cre == nil:
+		add(rule, LintError, "schema", "missing cre")
+	case creId == "":
+		add(cre, LintError, "metadata", "missing cre id")
+	}
+
+	if body == nil {
+		add(rule, LintError, "schema", "missing rule")
+	} else {
+		_, isSet := child(body, "set")
+		_, isSeq := child(body, "sequence")
+		if isSet == isSeq {
+			add(body, LintError, "schema", "a rule needs either a set or a sequence")
+		}
+	}
+
+	// Generated ids change with the rule, losing its state and suppressions
+	for _, key := range []string{"id", "hash"} {
+		if n, ok := child(meta, key); !ok || n.Value == "" {
+			at := rule
+			if meta != nil {
+				at = meta
+			}
+			add(at, LintWarning, "metadata", fmt.Sprintf("missing metadata %s; one is generated but changes with the rule", key))
+		}
+	}
+
+	if cre != nil {
+		for _, key := range wantCre {
+			if n, ok := child(cre, key); !ok || (n.Kind == yaml.ScalarNode && strings.TrimSpace(n.Value) == "") {
+				add(cre, LintWarning, "metadata", "missing cre "+key)
+			}
+		}
+	}
+
+	// Ids must be unique across the rule set
+	for _, id := range []struct {
+		section *yaml.Node
+		key     string
+		what    string
+	}{
+		{cre, "id", "cre id"},
+		{meta, "id", "rule id"},
+		{meta, "hash", "rule hash"},
+	} {
+		n, ok := child(id.section, id.key)
+		if !ok || n.Value == "" {
+			continue
+		}
+		key := id.what + "\x00" + n.Value
+		if first, dup := seen[key]; dup {
+			add(n, LintError, "duplicate", fmt.Sprintf("duplicate %s %s, first defined at %s:%d", id.what, n.Value, first.File, first.Line))
+			continue
+		}
+		seen[key] = LintIssueT{File: file, Line: n.Line}
+	}
+
+	return issues
+}
+
+// yamlDocs returns the root mapping of each document in data.
+func yamlDocs(data []byte) ([]*yaml.Node, error) {
+
+	var (
+		docs []*yaml.Node
+		dec  = yaml.NewDecoder(bytes.NewReader(data))
+	)
+
+	for {
+		var doc yaml.Node
+		switch err := dec.Decode(&doc); {
+		case errors.Is(err, io.EOF):
+			return docs, nil
+		case err != nil:
+			return nil, err
+		}
+		if len(doc.Content) > 0 {
+			docs = append(docs, doc.Content[0])
+		}
+	}
+}
+
+// child returns the value of key in the mapping n.
+func child(n *yaml.Node, key string) (*yaml.Node, bool) {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil, false
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1], true
+		}
+	}
+	return nil, false
+}
+
+// findScalar returns the first scalar under n with value.
+func findScalar(n *yaml.Node, value string) *yaml.Node {
+	if n.Kind == yaml.ScalarNode && n.Value == value {
+		return n
+	}
+	for _, c := range n.Content {
+		if f := findScalar(c, value); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// yamlKeys returns the YAML field names of a struct type.
+func yamlKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("yaml")
+		name, _, _ := strings.Cut(tag, ",")
+		if name != "" && name != "-" {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
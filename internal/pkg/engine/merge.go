@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"container/heap"
+
+	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/prequel-dev/prequel-logmatch/pkg/entry"
+	"github.com/prequel-dev/prequel-logmatch/pkg/scanner"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	mergeBatch = 1024 // entries parsed per worker turn
+	mergeDepth = 2    // batches buffered ahead per log
+)
+
+// SetParallel bounds the number of logs parsed at once. With more than one
+// worker, the logs of a multi-file source are parsed concurrently and
+// merged by timestamp; with one they are scanned in turn.
+func (r *RuntimeT) SetParallel(n int) {
+	if n <= 1 {
+		r.pool = nil
+		return
+	}
+	r.pool = make(chan struct{}, n)
+}
+
+// cursorT walks the batches parsed from one log.
+type cursorT struct {
+	idx   int
+	ch    <-chan []entry.LogEntry
+	batch []entry.LogEntry
+	pos   int
+}
+
+func (c *cursorT) head() entry.LogEntry {
+	return c.batch[c.pos]
+}
+
+// next advances to the following entry, waiting for the next batch if
+// needed. It returns false once the log is exhausted.
+func (c *cursorT) next() bool {
+	if c.pos++; c.pos < len(c.batch) {
+		return true
+	}
+	for batch := range c.ch {
+		if len(batch) > 0 {
+			c.batch, c.pos = batch, 0
+			return true
+		}
+	}
+	return false
+}
+
+// mergeHeapT orders cursors by the timestamp of their head, then by log
+// so equal timestamps keep the resolved file order.
+type mergeHeapT []*cursorT
+
+func (h mergeHeapT) Len() int { return len(h) }
+
+func (h mergeHeapT) Less(i, j int) bool {
+	a, b := h[i].head().Timestamp, h[j].head().Timestamp
+	if a != b {
+		return a < b
+	}
+	return h[i].idx < h[j].idx
+}
+
+func (h mergeHeapT) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeapT) Push(x any) { *h = append(*h, x.(*cursorT)) }
+
+func (h *mergeHeapT) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// _mergeLogs parses every log of ld in its own goroutine and feeds the
+// entries to scanF in timestamp order. Parsing is bounded by pool: a
+// worker holds a slot while it fills a batch and releases it before
+// handing the batch over, so a full merge never starves the others.
+func _mergeLogs(ld *LogData, scanF scanner.ScanFuncT, stop int64, tracker *progress.Tracker, pool chan struct{}, memLimit int) {
+
+	var (
+		done = make(chan struct{})
+		h    = make(mergeHeapT, 0, len(ld.Logs))
+	)
+
+	defer close(done)
+
+	cursors := make([]*cursorT, len(ld.Logs))
+	for i := range ld.Logs {
+		ch := make(chan []entry.LogEntry, mergeDepth)
+		cursors[i] = &cursorT{idx: i, ch: ch, pos: -1}
+		go _parseLog(ld, i, ch, done, stop, tracker, pool, memLimit)
+	}
+
+	for _, c := range cursors {
+		if c.next() {
+			h = append(h, c)
+		}
+	}
+	heap.Init(&h)
+
+	for len(h) > 0 {
+		c := h[0]
+		if scanF(c.head()) {
+			return
+		}
+		if c.next() {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+}
+
+// _parseLog scans log idx of ld into batches on ch until the log ends or
+// done is closed.
+func _parseLog(ld *LogData, idx int, ch chan<- []entry.LogEntry, done <-chan struct{}, stop int64, tracker *progress.Tracker, pool chan struct{}, memLimit int) {
+
+	var (
+		rd    = ld.Logs[idx]
+		batch []entry.LogEntry
+	)
+
+	defer close(ch)
+	defer rd.Close()
+
+	send := func() bool {
+		<-pool
+		select {
+		case ch <- batch:
+			batch = nil
+			return true
+		case <-done:
+			batch = nil
+			return false
+		}
+	}
+
+	var scanF scanner.ScanFuncT = func(e entry.LogEntry) bool {
+		if batch == nil {
+			pool <- struct{}{}
+			batch = make([]entry.LogEntry, 0, mergeBatch)
+		}
+		batch = append(batch, e)
+		return len(batch) == mergeBatch && !send()
+	}
+
+	log.Info().
+		Int("i", idx).
+		Int("n", len(ld.Logs)).
+		Str("name", rd.Name()).
+		Int64("size", rd.Size()).
+		Msg("Parsing log")
+
+	opts := []scanner.ScanOptT{
+		scanner.WithStop(stop),
+	}
+
+	if rd.Fold() {
+		opts = append(opts, scanner.WithFold(true))
+	}
+
+	var reorder *scanner.ReorderT
+	if rd.Window() > 0 {
+		var err error
+		if reorder, err = scanner.NewReorder(rd.Window(), scanF, scanner.WithMemoryLimit(memLimit/len(ld.Logs))); err != nil {
+			log.Warn().Err(err).Msg("Fail to create reorder object. Continue...")
+			reorder = nil
+		} else {
+			scanF = reorder.Append
+		}
+	}
+
+	err := scanner.ScanForward(
+		&TrkRdr{rd: rd, trk: tracker},
+		rd.Parser().ReadEntry,
+		scanF,
+		opts...,
+	)
+
+	switch {
+	case err != nil:
+		log.Warn().
+			Err(err).
+			Str("name", rd.Name()).
+			Int64("size", rd.Size()).
+			Msg("Failed to scan log.  Continue...")
+	case reorder != nil:
+		reorder.Flush()
+	}
+
+	if batch != nil {
+		send()
+	}
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"github.com/prequel-dev/preq/internal/pkg/literalz"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	lm "github.com/prequel-dev/prequel-logmatch/pkg/match"
+)
+
+// Most lines match no condition of most rules. Before the matchers of a
+// source see a line, it is scanned once for the literals the conditions
+// need, and a condition none of whose literals are in the line is skipped.
+// A rule takes part only if every condition it has needs a literal: value
+// terms are their own literal, and a regex term needs one if it cannot
+// match without it. Rules with negated conditions, jq, field or cel terms
+// always run, as do matchers other than positive sets and sequences, since they
+// must see time pass on every line.
+
+// addLiterals records the literals a line must hold one of to take part in
+// each rule. Called with the lock held.
+func (r *RuntimeT) addLiterals(rules *parser.RulesT) {
+
+	for _, rule := range rules.Rules {
+
+		var (
+			lits []string
+			ok   bool
+		)
+
+		switch {
+		case rule.Rule.Sequence != nil:
+			seq := rule.Rule.Sequence
+			lits, ok = literals(rules.TermsT, seq.Order, seq.Negate)
+		case rule.Rule.Set != nil:
+			set := rule.Rule.Set
+			lits, ok = literals(rules.TermsT, set.Match, set.Negate)
+		}
+
+		if !ok {
+			delete(r.literals, rule.Metadata.Hash)
+			continue
+		}
+
+		if r.literals == nil {
+			r.literals = make(map[string][]string)
+		}
+		r.literals[rule.Metadata.Hash] = lits
+	}
+}
+
+// literals returns the literals of terms, or false if a term may match
+// without any.
+func literals(named map[string]parser.ParseTermT, terms, negate []parser.ParseTermT) ([]string, bool) {
+
+	if len(negate) > 0 || len(terms) == 0 {
+		return nil, false
+	}
+
+	var out []string
+
+	for _, t := range terms {
+
+		if nt, ok := named[t.StrValue]; ok && t.StrValue != "" {
+			t = nt
+		}
+
+		var (
+			lits []string
+			ok   bool
+		)
+
+		switch {
+		case t.Field != "" || t.PromQL != nil || t.JqValue != "" || isMarker(t.StrValue):
+		case t.Sequence != nil:
+			lits, ok = literals(named, t.Sequence.Order, t.Sequence.Negate)
+		case t.Set != nil:
+			lits, ok = literals(named, t.Set.Match, t.Set.Negate)
+		case t.StrValue != "":
+			lits, ok = literalz.Literal(t.StrValue)
+		case t.RegexValue != "":
+			lits, ok = literalz.Required(t.RegexValue)
+		}
+
+		if !ok {
+			return nil, false
+		}
+		out = append(out, lits...)
+	}
+
+	return out, true
+}
+
+// ruleLiterals returns the literals of a rule, or nil if it always runs.
+func (r *RuntimeT) ruleLiterals(ruleHash string) []string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.literals[ruleHash]
+}
+
+// prefilterable reports whether a matcher only acts on the lines its terms
+// match.
+func prefilterable(matcher any) bool {
+	switch matcher.(type) {
+	case *lm.MatchSingle, *lm.MatchSet, *lm.MatchSeq:
+		return true
+	}
+	return false
+}
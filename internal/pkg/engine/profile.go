@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ProfileT accumulates what each rule cost across the sources of a run:
+// the time spent in its matcher and the events it examined. A nil
+// *ProfileT records nothing.
+type ProfileT struct {
+	mux   sync.Mutex
+	rules map[string]*RuleProfileT
+}
+
+// RuleProfileT is the cost of one rule.
+type RuleProfileT struct {
+	CreId    string
+	RuleHash string
+	Events   int64
+	Hits     int64
+	Time     time.Duration
+}
+
+// PerEvent is the mean matcher time per event examined.
+func (p RuleProfileT) PerEvent() time.Duration {
+	if p.Events == 0 {
+		return 0
+	}
+	return p.Time / time.Duration(p.Events)
+}
+
+func NewProfile() *ProfileT {
+	return &ProfileT{rules: make(map[string]*RuleProfileT)}
+}
+
+func (p *ProfileT) add(creId, ruleHash string, events, hits int64, spent time.Duration) {
+	if p == nil {
+		return
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	rp, ok := p.rules[ruleHash]
+	if !ok {
+		rp = &RuleProfileT{CreId: creId, RuleHash: ruleHash}
+		p.rules[ruleHash] = rp
+	}
+	rp.Events += events
+	rp.Hits += hits
+	rp.Time += spent
+}
+
+// Rules returns the profile of every rule that examined events, slowest
+// first.
+func (p *ProfileT) Rules() []RuleProfileT {
+	if p == nil {
+		return nil
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	out := make([]RuleProfileT, 0, len(p.rules))
+	for _, rp := range p.rules {
+		out = append(out, *rp)
+	}
+
+	slices.SortFunc(out, func(a, b RuleProfileT) int {
+		if c := cmp.Compare(b.Time, a.Time); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.RuleHash, b.RuleHash)
+	})
+
+	return out
+}
+
+// Fprint writes the profile as a table ranked by time spent.
+func (p *ProfileT) Fprint(w io.Writer) {
+
+	rules := p.Rules()
+
+	var total time.Duration
+	for _, rp := range rules {
+		total += rp.Time
+	}
+
+	fmt.Fprintf(w, "\nRule profile (%d rules, %s in matchers):\n", len(rules), total.Round(time.Microsecond))
+	fmt.Fprintf(w, "  %-4s %-20s %-24s %12s %6s %12s %10s %8s\n", "RANK", "CRE", "RULE HASH", "TIME", "%", "EVENTS", "PER EVENT", "HITS")
+
+	for i, rp := range rules {
+		var pct float64
+		if total > 0 {
+			pct = float64(rp.Time) * 100 / float64(total)
+		}
+		fmt.Fprintf(w, "  %-4d %-20s %-24s %12s %6.1f %12d %10s %8d\n",
+			i+1,
+			rp.CreId,
+			rp.RuleHash,
+			rp.Time.Round(time.Microsecond),
+			pct,
+			rp.Events,
+			rp.PerEvent(),
+			rp.Hits,
+		)
+	}
+}
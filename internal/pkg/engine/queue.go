@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"sync/atomic"
+
+	"github.com/prequel-dev/preq/internal/pkg/queuez"
+	"github.com/prequel-dev/prequel-logmatch/pkg/entry"
+	"github.com/prequel-dev/prequel-logmatch/pkg/scanner"
+	"github.com/rs/zerolog/log"
+)
+
+// SetQueue sets the size and policy of the queue between reading a
+// followed source and matching its lines. See queuez.
+func (r *RuntimeT) SetQueue(size int, policy queuez.PolicyT) {
+	r.queueSize = size
+	r.queuePolicy = policy
+}
+
+// queueLines puts a bounded queue in front of scanF, so that lines are read
+// and their timestamps resolved on one goroutine and matched on another.
+// The returned drain closes the queue and waits for the lines in it to be
+// matched.
+func (r *RuntimeT) queueLines(name string, scanF scanner.ScanFuncT) (scanner.ScanFuncT, func()) {
+
+	var (
+		q    = queuez.New[entry.LogEntry](name, r.queueSize, r.queuePolicy)
+		stop atomic.Bool
+		done = make(chan struct{})
+	)
+
+	go func() {
+		defer close(done)
+		for e := range q.C() {
+			if !stop.Load() && scanF(e) {
+				stop.Store(true)
+			}
+		}
+	}()
+
+	push := func(e entry.LogEntry) bool {
+		if stop.Load() {
+			return true
+		}
+		q.Push(e)
+		return false
+	}
+
+	drain := func() {
+		q.Close()
+		<-done
+
+		stats := q.Stats()
+		r.dropped.Add(stats.Dropped)
+		if stats.Dropped > 0 || stats.Blocked > 0 {
+			log.Info().
+				Str("src", name).
+				Int64("lines", stats.Pushed).
+				Int64("dropped", stats.Dropped).
+				Dur("blocked", stats.Blocked).
+				Msg("Line queue")
+		}
+	}
+
+	return push, drain
+}
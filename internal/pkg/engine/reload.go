@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"github.com/rs/zerolog/log"
+)
+
+// Rules can be reloaded while Run follows its sources. The reloaded rules
+// are compiled aside and swapped in whole; each source picks them up before
+// its next line. Rules are told apart by hash, and a rule whose conditions
+// are unchanged keeps its matchers, and with them the partial matches they
+// hold. A changed rule starts over, and a removed rule's partial matches are
+// dropped.
+
+// setLive makes m the rules Run applies.
+func (r *RuntimeT) setLive(m *RuleMatchersT) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.live = m
+	r.gen.Add(1)
+}
+
+// current returns the rules Run applies and their generation.
+func (r *RuntimeT) current() (*RuleMatchersT, uint64) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.live, r.gen.Load()
+}
+
+// addPrints records a fingerprint of the conditions of each rule, so that a
+// reload can tell whether they changed. Named terms are shared by the rules
+// of a document and count towards each. Called with the lock held.
+func (r *RuntimeT) addPrints(rules *parser.RulesT) {
+
+	if r.prints == nil {
+		r.prints = make(map[string]string, len(rules.Rules))
+	}
+
+	terms, err := json.Marshal(rules.TermsT)
+	if err != nil {
+		return
+	}
+
+	for _, rule := range rules.Rules {
+		data, err := json.Marshal(rule.Rule)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(append(data, terms...))
+		r.prints[rule.Metadata.Hash] = hex.EncodeToString(sum[:])
+	}
+}
+
+// ReloadRulesPaths compiles the rules at rulesPaths and swaps them for the
+// rules Run applies. On error the previous rules stay in place.
+func (r *RuntimeT) ReloadRulesPaths(rep *ux.ReportT, rulesPaths []utils.RulePathT) error {
+
+	nodeObjs, configs, err := r.compileRulesPaths(r.getRuntimeCb(rep), rulesPaths)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to compile reloaded rules")
+		return err
+	}
+
+	matchers, err := loadNodeObjs(nodeObjs)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load reloaded node objects")
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	var (
+		prev       = r.live
+		prevRules  = r.Rules
+		prevPrints = r.prints
+		prevXs     = r.extracts
+		prevLits   = r.literals
+		prevMarks  = r.markers
+	)
+
+	r.Rules = make(map[string]parser.ParseCreT, len(prevRules))
+	r.prints, r.extracts, r.literals, r.markers = nil, nil, nil, nil
+
+	for _, rules := range configs {
+		if err = r.addRules(rules); err != nil {
+			r.Rules, r.prints, r.extracts, r.literals, r.markers = prevRules, prevPrints, prevXs, prevLits, prevMarks
+			log.Error().Err(err).Msg("Failed to add reloaded rules")
+			return err
+		}
+	}
+
+	// Matchers of removed rules are dropped as sources pick up the swap, so
+	// their conditions are no longer decided
+	r.retainMarkers()
+
+	// Hits of a rule read before the swap may still be reported after it
+	for hash, cre := range prevRules {
+		if _, ok := r.Rules[hash]; !ok {
+			r.Rules[hash] = cre
+		}
+	}
+
+	unchanged := func(hash string) bool {
+		return prevPrints[hash] != "" && prevPrints[hash] == r.prints[hash]
+	}
+
+	var kept int
+	if prev != nil {
+		for key, hash := range matchers.hash {
+			if prev.hash[key] != hash || !unchanged(hash) {
+				continue
+			}
+			if old, ok := prev.match[key]; ok {
+				matchers.match[key], matchers.cb[key] = old, prev.cb[key]
+				kept++
+			}
+		}
+		for key, mc := range prev.machines {
+			if _, ok := matchers.machines[key]; ok && unchanged(mc.addr.GetRuleHash()) {
+				matchers.machines[key] = mc
+			}
+		}
+	}
+
+	for _, mc := range matchers.machines {
+		mc.state = r.state
+	}
+
+	r.machines = maps.Clone(matchers.machines)
+	r.live = matchers
+	r.gen.Add(1)
+
+	for _, rules := range configs {
+		rep.UpdateRules(rules)
+	}
+
+	log.Info().
+		Int("rules", matchers.rules()).
+		Int("conditions", len(matchers.match)).
+		Int("kept", kept).
+		Msg("Reloaded rules")
+
+	return nil
+}
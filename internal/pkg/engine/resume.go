@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"github.com/rs/zerolog/log"
+)
+
+// A run resumed from a checkpoint re-reads each source from the longest
+// rule window before its position, so that matches in progress when the
+// last run stopped are rebuilt. Hits completed on those lines alone were
+// reported by that run and are dropped; see resumeT.
+
+// resumeT tells the lines of a source up to its checkpoint position from
+// those after it.
+type resumeT struct {
+	ts   int64 // position
+	seen int64 // entries stamped ts scanned up to the position
+	on   bool  // still at or before the position
+}
+
+func (r *RuntimeT) newResume(key string) resumeT {
+	ts, seen, ok := r.cp.Position(key)
+	return resumeT{ts: ts, seen: seen, on: ok}
+}
+
+// replays reports whether a line stamped ts, the nth so stamped, was
+// scanned by the run that wrote the checkpoint.
+func (rs *resumeT) replays(ts, nth int64) bool {
+	if !rs.on {
+		return false
+	}
+	if ts < rs.ts || ts == rs.ts && nth <= rs.seen {
+		return true
+	}
+	rs.on = false
+	return false
+}
+
+func (r *RuntimeT) addHorizons(rules *parser.RulesT) {
+
+	for _, rule := range rules.Rules {
+
+		var h time.Duration
+		switch {
+		case rule.Rule.Sequence != nil:
+			h = horizon(rules.TermsT, rule.Rule.Sequence.Window, rule.Rule.Sequence.Order, rule.Rule.Sequence.Negate)
+		case rule.Rule.Set != nil:
+			h = horizon(rules.TermsT, rule.Rule.Set.Window, rule.Rule.Set.Match, rule.Rule.Set.Negate)
+		}
+
+		if r.horizons == nil {
+			r.horizons = make(map[string]int64)
+		}
+		r.horizons[rule.Metadata.Hash] = int64(h)
+	}
+}
+
+// horizon is how far back the lines of one match may reach: the window,
+// the reach of negative conditions past it, and that of nested conditions.
+func horizon(named map[string]parser.ParseTermT, window string, match, negate []parser.ParseTermT) time.Duration {
+
+	var (
+		h, inner time.Duration
+		parse    = func(s string) time.Duration {
+			if s == "" {
+				return 0
+			}
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				log.Debug().Err(err).Str("window", s).Msg("Failed to parse window")
+			}
+			return d
+		}
+	)
+
+	h = parse(window)
+
+	for i, t := range append(match[:len(match):len(match)], negate...) {
+
+		if nt, ok := named[t.StrValue]; ok && t.StrValue != "" {
+			t = nt
+		}
+
+		switch {
+		case t.Sequence != nil:
+			inner = max(inner, horizon(named, t.Sequence.Window, t.Sequence.Order, t.Sequence.Negate))
+		case t.Set != nil:
+			inner = max(inner, horizon(named, t.Set.Window, t.Set.Match, t.Set.Negate))
+		}
+
+		if o := t.NegateOpts; o != nil && i >= len(match) {
+			h = max(h, parse(window)+parse(o.Window)+parse(o.Slide))
+		}
+	}
+
+	return h + inner
+}
+
+// maxHorizon returns the furthest back the lines of any match may reach.
+func (r *RuntimeT) maxHorizon() time.Duration {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	var h int64
+	for _, v := range r.horizons {
+		h = max(h, v)
+	}
+	return time.Duration(h)
+}
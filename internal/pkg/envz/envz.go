@@ -0,0 +1,131 @@
+package envz
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/verz"
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	probeTimeout = 2 * time.Second
+)
+
+const (
+	KeyOs         = "os"
+	KeyArch       = "arch"
+	KeyHostname   = "hostname"
+	KeyKernel     = "kernel"
+	KeyPreq       = "preq"
+	KeyKubernetes = "kubernetes"
+)
+
+// EnvT is a flat set of platform versions captured at scan time.
+type EnvT map[string]string
+
+// Container runtime CLIs probed for a version. Missing binaries are skipped.
+var runtimeProbes = map[string][]string{
+	"docker":     {"docker", "version", "--format", "{{.Server.Version}}"},
+	"podman":     {"podman", "version", "--format", "{{.Version}}"},
+	"containerd": {"containerd", "--version"},
+	"crio":       {"crio", "--version"},
+}
+
+// Capture collects OS, kernel, container runtime and Kubernetes server
+// versions. Every probe is best effort and bounded by a short timeout.
+func Capture(ctx context.Context) EnvT {
+
+	env := EnvT{
+		KeyOs:   runtime.GOOS,
+		KeyArch: runtime.GOARCH,
+		KeyPreq: verz.Semver(),
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		env[KeyHostname] = hostname
+	}
+
+	if v := kernelVersion(ctx); v != "" {
+		env[KeyKernel] = v
+	}
+
+	for name, args := range runtimeProbes {
+		if v := cmdVersion(ctx, args...); v != "" {
+			env[name] = v
+		}
+	}
+
+	if v := k8sVersion(); v != "" {
+		env[KeyKubernetes] = v
+	}
+
+	log.Debug().Interface("env", env).Msg("Captured environment")
+
+	return env
+}
+
+func kernelVersion(ctx context.Context) string {
+	switch runtime.GOOS {
+	case "linux":
+		if data, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+			return strings.TrimSpace(string(data))
+		}
+	case "windows":
+		return ""
+	}
+	return cmdVersion(ctx, "uname", "-r")
+}
+
+// cmdVersion returns the first line of output of a version command.
+func cmdVersion(ctx context.Context, args ...string) string {
+
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		log.Debug().Err(err).Str("cmd", args[0]).Msg("Failed to probe version")
+		return ""
+	}
+
+	line, _, _ := bufio.NewReader(bytes.NewReader(out)).ReadLine()
+	return strings.TrimSpace(string(line))
+}
+
+// k8sVersion asks the API server for its version when a kubeconfig or
+// in-cluster service account is available.
+func k8sVersion() string {
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return ""
+	}
+	cfg.Timeout = probeTimeout
+
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return ""
+	}
+
+	info, err := dc.ServerVersion()
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to get Kubernetes server version")
+		return ""
+	}
+
+	return info.GitVersion
+}
@@ -0,0 +1,23 @@
+package envz
+
+import (
+	"context"
+	"runtime"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	env := Capture(context.Background())
+	if env[KeyOs] != runtime.GOOS || env[KeyArch] != runtime.GOARCH {
+		t.Fatalf("unexpected os/arch: %v", env)
+	}
+	if _, ok := env[KeyPreq]; !ok {
+		t.Fatalf("missing preq version")
+	}
+}
+
+func TestCmdVersion(t *testing.T) {
+	if v := cmdVersion(context.Background(), "preq-does-not-exist", "--version"); v != "" {
+		t.Fatalf("expected empty version for missing binary, got %q", v)
+	}
+}
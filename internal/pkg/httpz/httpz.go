@@ -0,0 +1,365 @@
+package httpz
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/verz"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Clients created here share one pooled transport per proxy, so repeated
+// calls to the same host reuse connections instead of dialing each time.
+// Every request is tagged with the preq user agent and recorded in the
+// per-client statistics returned by Stats.
+//
+// Clients go through the proxy of HTTPS_PROXY, HTTP_PROXY and NO_PROXY,
+// unless SetProxy sets one for the process, as the proxy section of the
+// config does. Once SetOffline is on, every request fails with ErrOffline
+// before it reaches the network.
+
+const (
+	defaultName                = "default"
+	defaultDialTimeout         = 10 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 16
+)
+
+var (
+	ErrOffline = errors.New("network access is off with --offline")
+
+	offline atomic.Bool
+)
+
+type optsT struct {
+	name    string
+	timeout time.Duration
+	proxy   *url.URL
+	tls     *tls.Config
+}
+
+type OptT func(*optsT)
+
+// WithName labels the client's requests in logs and statistics.
+func WithName(name string) OptT {
+	return func(o *optsT) {
+		o.name = name
+	}
+}
+
+// WithTimeout bounds each request, including reading the body.
+func WithTimeout(timeout time.Duration) OptT {
+	return func(o *optsT) {
+		o.timeout = timeout
+	}
+}
+
+// WithProxy overrides the proxy from the environment.
+func WithProxy(proxy *url.URL) OptT {
+	return func(o *optsT) {
+		o.proxy = proxy
+	}
+}
+
+// WithTLS sets the TLS config, such as client certificates or private
+// roots. Clients with their own TLS config do not share a transport.
+func WithTLS(cfg *tls.Config) OptT {
+	return func(o *optsT) {
+		o.tls = cfg
+	}
+}
+
+func parseOpts(opts ...OptT) optsT {
+	o := optsT{name: defaultName}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// ProxyT is the outbound proxy of the clients of the process, and the
+// roots they trust.
+type ProxyT struct {
+	Url     *url.URL       // nil uses the proxy of the environment
+	NoProxy string         // hosts reached directly, as in NO_PROXY
+	Roots   *x509.CertPool // nil trusts the system roots
+}
+
+var (
+	proxyMux  sync.Mutex
+	procProxy ProxyT
+)
+
+// SetProxy sets the proxy of the clients created after, but those given
+// one WithProxy.
+func SetProxy(p ProxyT) {
+	proxyMux.Lock()
+	procProxy = p
+	proxyMux.Unlock()
+
+	transportMux.Lock()
+	clear(transports)
+	transportMux.Unlock()
+}
+
+// SetOffline turns network access off, or back on, for every client.
+func SetOffline(on bool) {
+	offline.Store(on)
+}
+
+// DoerI is a client httpz does not build, such as that of the AWS SDK.
+type DoerI interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+type guardT struct {
+	name string
+	next DoerI
+}
+
+// Guard has a client httpz does not build refuse requests while offline.
+func Guard(name string, next DoerI) DoerI {
+	return &guardT{name: name, next: next}
+}
+
+func (g *guardT) Do(req *http.Request) (*http.Response, error) {
+	if offline.Load() {
+		log.Warn().Str("client", g.name).Str("host", req.URL.Host).Msg("Refused HTTP request while offline")
+		return nil, ErrOffline
+	}
+	return g.next.Do(req)
+}
+
+// RootCAs returns the roots clients trust, for a client trusting more.
+func RootCAs() *x509.CertPool {
+	proxyMux.Lock()
+	defer proxyMux.Unlock()
+
+	if procProxy.Roots != nil {
+		return procProxy.Roots.Clone()
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		return x509.NewCertPool()
+	}
+	return pool
+}
+
+func currentProxy() ProxyT {
+	proxyMux.Lock()
+	defer proxyMux.Unlock()
+	return procProxy
+}
+
+// New returns a client backed by the shared transport for its proxy.
+func New(opts ...OptT) *http.Client {
+	o := parseOpts(opts...)
+
+	next := transportFor(o.proxy)
+	if o.tls != nil {
+		cfg := o.tls
+		if roots := currentProxy().Roots; cfg.RootCAs == nil && roots != nil {
+			cfg = cfg.Clone()
+			cfg.RootCAs = roots
+		}
+		next = next.Clone()
+		next.TLSClientConfig = cfg
+	}
+
+	return &http.Client{
+		Timeout: o.timeout,
+		Transport: &roundTripperT{
+			name: o.name,
+			next: next,
+		},
+	}
+}
+
+func UserAgent() string {
+	return "preq/" + verz.Semver() + " (" + runtime.GOOS + "/" + runtime.GOARCH + ")"
+}
+
+var (
+	transportMux sync.Mutex
+	transports   = make(map[string]*http.Transport)
+)
+
+func transportFor(proxy *url.URL) *http.Transport {
+
+	key := ""
+	if proxy != nil {
+		key = proxy.String()
+	}
+
+	transportMux.Lock()
+	defer transportMux.Unlock()
+
+	if t, ok := transports[key]; ok {
+		return t
+	}
+
+	var (
+		proc      = currentProxy()
+		proxyFunc = http.ProxyFromEnvironment
+		tlsConfig *tls.Config
+	)
+
+	switch {
+	case proxy != nil:
+		proxyFunc = http.ProxyURL(proxy)
+	case proc.Url != nil:
+		cfg := &httpproxy.Config{
+			HTTPProxy:  proc.Url.String(),
+			HTTPSProxy: proc.Url.String(),
+			NoProxy:    proc.NoProxy,
+		}
+		pf := cfg.ProxyFunc()
+		proxyFunc = func(req *http.Request) (*url.URL, error) {
+			return pf(req.URL)
+		}
+	}
+
+	if proc.Roots != nil {
+		tlsConfig = &tls.Config{RootCAs: proc.Roots}
+	}
+
+	t := &http.Transport{
+		TLSClientConfig: tlsConfig,
+		Proxy:           proxyFunc,
+		DialContext: (&net.Dialer{
+			Timeout:   defaultDialTimeout,
+			KeepAlive: defaultKeepAlive,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          defaultMaxIdleConns,
+		MaxIdleConnsPerHost:   defaultMaxIdleConnsPerHost,
+		IdleConnTimeout:       defaultIdleConnTimeout,
+		TLSHandshakeTimeout:   defaultTLSHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	transports[key] = t
+	return t
+}
+
+type roundTripperT struct {
+	name string
+	next http.RoundTripper
+}
+
+func (rt *roundTripperT) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	if offline.Load() {
+		log.Warn().Str("client", rt.name).Str("host", req.URL.Host).Msg("Refused HTTP request while offline")
+		return nil, ErrOffline
+	}
+
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", UserAgent())
+	}
+
+	start := time.Now()
+	resp, err := rt.next.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	record(rt.name, elapsed, status, err)
+
+	ev := log.Debug()
+	if err != nil {
+		ev = log.Warn().Err(err)
+	}
+	ev.Str("client", rt.name).
+		Str("method", req.Method).
+		Str("host", req.URL.Host).
+		Int("status", status).
+		Dur("elapsed", elapsed).
+		Msg("HTTP request")
+
+	return resp, err
+}
+
+// StatT summarizes the requests made by clients sharing a name.
+type StatT struct {
+	Name     string
+	Requests int
+	Errors   int // transport errors and 5xx responses
+	Total    time.Duration
+	Max      time.Duration
+}
+
+var (
+	statsMux sync.Mutex
+	stats    = make(map[string]*StatT)
+)
+
+func record(name string, elapsed time.Duration, status int, err error) {
+	statsMux.Lock()
+	defer statsMux.Unlock()
+
+	s, ok := stats[name]
+	if !ok {
+		s = &StatT{Name: name}
+		stats[name] = s
+	}
+
+	s.Requests++
+	s.Total += elapsed
+	s.Max = max(s.Max, elapsed)
+	if err != nil || status >= http.StatusInternalServerError {
+		s.Errors++
+	}
+}
+
+// Stats returns a snapshot of the request statistics ordered by name.
+func Stats() []StatT {
+	statsMux.Lock()
+	defer statsMux.Unlock()
+
+	out := make([]StatT, 0, len(stats))
+	for _, s := range stats {
+		out = append(out, *s)
+	}
+
+	slices.SortFunc(out, func(a, b StatT) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return out
+}
+
+// LogStats writes the request statistics at debug level.
+func LogStats() {
+	for _, s := range Stats() {
+		var avg time.Duration
+		if s.Requests > 0 {
+			avg = s.Total / time.Duration(s.Requests)
+		}
+		log.Debug().
+			Str("client", s.Name).
+			Int("requests", s.Requests).
+			Int("errors", s.Errors).
+			Dur("avg", avg).
+			Dur("max", s.Max).
+			Msg("HTTP client stats")
+	}
+}
@@ -0,0 +1,152 @@
+package httpz
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	var agents []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.Header.Get("User-Agent"))
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	client := New(WithName("test.client"))
+
+	for _, path := range []string{"/ok", "/fail"} {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("Get %s: %v", path, err)
+		}
+		resp.Body.Close()
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("User-Agent", "custom")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	resp.Body.Close()
+
+	if !strings.HasPrefix(agents[0], "preq/") || agents[2] != "custom" {
+		t.Errorf("Unexpected user agents: %v", agents)
+	}
+
+	var found bool
+	for _, s := range Stats() {
+		if s.Name == "test.client" {
+			found = true
+			if s.Requests != 3 || s.Errors != 1 {
+				t.Errorf("Unexpected stats: %+v", s)
+			}
+		}
+	}
+	if !found {
+		t.Error("Expected stats for test.client")
+	}
+}
+
+func TestSharedTransport(t *testing.T) {
+	a := New(WithName("a")).Transport.(*roundTripperT).next
+	b := New(WithName("b")).Transport.(*roundTripperT).next
+	if a != b {
+		t.Error("Expected clients without a proxy to share a transport")
+	}
+
+	proxy, _ := url.Parse("http://proxy.internal:3128")
+	c := New(WithProxy(proxy)).Transport.(*roundTripperT).next
+	if a == c {
+		t.Error("Expected a separate transport for a proxy")
+	}
+
+	cfg := &tls.Config{ServerName: "rules.internal"}
+	d := New(WithTLS(cfg)).Transport.(*roundTripperT).next.(*http.Transport)
+	if a == d || d.TLSClientConfig != cfg || a.(*http.Transport).TLSClientConfig == cfg {
+		t.Error("Expected a separate transport for a TLS config")
+	}
+}
+
+func TestSetProxy(t *testing.T) {
+	t.Cleanup(func() { SetProxy(ProxyT{}) })
+
+	var (
+		targets []string
+		auths   []string
+	)
+	proxySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets = append(targets, r.URL.String())
+		auths = append(auths, r.Header.Get("Proxy-Authorization"))
+	}))
+	defer proxySrv.Close()
+
+	before := New().Transport.(*roundTripperT).next
+
+	proxy, _ := url.Parse(proxySrv.URL)
+	proxy.User = url.UserPassword("svc", "secret")
+	roots := x509.NewCertPool()
+	SetProxy(ProxyT{Url: proxy, NoProxy: "direct.example.test", Roots: roots})
+
+	next := New().Transport.(*roundTripperT).next.(*http.Transport)
+	if next == before || next.TLSClientConfig == nil || next.TLSClientConfig.RootCAs != roots {
+		t.Fatal("Expected a new transport trusting the proxy roots")
+	}
+
+	resp, err := New().Get("http://rules.example.test/rules.yaml")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+
+	if len(targets) != 1 || targets[0] != "http://rules.example.test/rules.yaml" || !strings.HasPrefix(auths[0], "Basic ") {
+		t.Errorf("Expected the request through the proxy with basic auth, got %v %v", targets, auths)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://direct.example.test/", nil)
+	if u, err := next.Proxy(req); err != nil || u != nil {
+		t.Errorf("Expected no proxy for a NoProxy host, got %v (%v)", u, err)
+	}
+
+	// A client with its own TLS config, but no roots, trusts the proxy roots
+	cfg := &tls.Config{ServerName: "rules.internal"}
+	d := New(WithTLS(cfg)).Transport.(*roundTripperT).next.(*http.Transport)
+	if d.TLSClientConfig.RootCAs != roots || cfg.RootCAs != nil {
+		t.Error("Expected the proxy roots added to a copy of the TLS config")
+	}
+}
+
+func TestSetOffline(t *testing.T) {
+	t.Cleanup(func() { SetOffline(false) })
+
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	SetOffline(true)
+	if _, err := New().Get(srv.URL); !errors.Is(err, ErrOffline) || hits != 0 {
+		t.Errorf("Expected %v without a request, got %v and %d requests", ErrOffline, err, hits)
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if _, err := Guard("aws", http.DefaultClient).Do(req); !errors.Is(err, ErrOffline) || hits != 0 {
+		t.Errorf("Expected %v from a guarded client, got %v and %d requests", ErrOffline, err, hits)
+	}
+
+	SetOffline(false)
+	resp, err := New().Get(srv.URL)
+	if err != nil || hits != 1 {
+		t.Fatalf("Expected the request once back online, got %v", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,292 @@
+package literalz
+
+// Package literalz finds which of a set of literal strings occur in a line
+// in one pass over it, with an Aho-Corasick automaton, and works out the
+// literals a regex cannot match without. Together they let the engine skip
+// the conditions that cannot match a line before running their matchers.
+
+import (
+	"regexp/syntax"
+	"slices"
+)
+
+const (
+	// MinLen is the shortest literal worth filtering on; shorter ones hit
+	// too many lines to pay for the lookup.
+	MinLen = 3
+
+	noOut = -1
+)
+
+type edgeT struct {
+	b  byte
+	to int32
+}
+
+type stateT struct {
+	edges []edgeT // sorted by b
+	fail  int32
+	dict  int32 // nearest state on the fail chain with an output, or -1
+	out   int32 // literal ending here, or noOut
+}
+
+// MatcherT is an Aho-Corasick automaton over a set of literals. It is
+// immutable once built and safe for concurrent use through its scanners.
+type MatcherT struct {
+	root   [256]int32
+	states []stateT
+	alias  []int32 // the id marked for each literal, that of its first copy
+}
+
+// New builds a matcher for lits; the id of a literal is its index in lits.
+// Duplicate literals share the id of the first.
+func New(lits []string) *MatcherT {
+
+	m := &MatcherT{
+		states: []stateT{{fail: 0, dict: -1, out: noOut}},
+		alias:  make([]int32, len(lits)),
+	}
+
+	// The trie
+	for id, lit := range lits {
+		var s int32
+		for i := 0; i < len(lit); i++ {
+			s = m.child(s, lit[i], true)
+		}
+		if m.states[s].out == noOut {
+			m.states[s].out = int32(id)
+		}
+		m.alias[id] = m.states[s].out
+	}
+
+	// Fail and dictionary links, breadth first
+	var queue []int32
+	for b := 0; b < 256; b++ {
+		to := m.child(0, byte(b), false)
+		m.root[b] = max(to, 0)
+		if to > 0 {
+			queue = append(queue, to)
+		}
+	}
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+
+		for _, e := range m.states[s].edges {
+			f := m.states[s].fail
+			for f != 0 && m.child(f, e.b, false) < 0 {
+				f = m.states[f].fail
+			}
+			if to := m.child(f, e.b, false); to >= 0 && to != e.to {
+				f = to
+			} else {
+				f = 0
+			}
+
+			st := &m.states[e.to]
+			st.fail = f
+			if m.states[f].out != noOut {
+				st.dict = f
+			} else {
+				st.dict = m.states[f].dict
+			}
+			queue = append(queue, e.to)
+		}
+	}
+
+	return m
+}
+
+// child returns the state reached from s on b, adding it if asked; -1 if
+// there is none.
+func (m *MatcherT) child(s int32, b byte, add bool) int32 {
+
+	edges := m.states[s].edges
+	i, ok := slices.BinarySearchFunc(edges, b, func(e edgeT, b byte) int {
+		return int(e.b) - int(b)
+	})
+	if ok {
+		return edges[i].to
+	}
+	if !add {
+		return -1
+	}
+
+	to := int32(len(m.states))
+	m.states = append(m.states, stateT{dict: -1, out: noOut})
+	m.states[s].edges = slices.Insert(m.states[s].edges, i, edgeT{b: b, to: to})
+	return to
+}
+
+func (m *MatcherT) Len() int {
+	return len(m.alias)
+}
+
+// ScannerT records the literals found in the last line scanned. It is not
+// safe for concurrent use; each goroutine needs its own.
+type ScannerT struct {
+	m     *MatcherT
+	stamp uint32
+	seen  []uint32
+}
+
+func (m *MatcherT) NewScanner() *ScannerT {
+	return &ScannerT{m: m, seen: make([]uint32, len(m.alias))}
+}
+
+// Scan finds the literals in line, forgetting those of the previous line.
+func (sc *ScannerT) Scan(line string) {
+
+	if sc.stamp++; sc.stamp == 0 {
+		clear(sc.seen)
+		sc.stamp = 1
+	}
+
+	var (
+		m = sc.m
+		s int32
+	)
+
+	for i := 0; i < len(line); i++ {
+		b := line[i]
+		for {
+			if s == 0 {
+				s = m.root[b]
+				break
+			}
+			if to := m.child(s, b, false); to >= 0 {
+				s = to
+				break
+			}
+			s = m.states[s].fail
+		}
+
+		for o := s; o > 0; o = m.states[o].dict {
+			if id := m.states[o].out; id != noOut {
+				sc.seen[id] = sc.stamp
+			}
+		}
+	}
+}
+
+// Any reports whether any of the literals ids was in the last line scanned.
+func (sc *ScannerT) Any(ids []int) bool {
+	for _, id := range ids {
+		if sc.seen[sc.m.alias[id]] == sc.stamp {
+			return true
+		}
+	}
+	return false
+}
+
+// Required returns literals one of which is in every line the regex
+// matches, or false if there are none to rely on: a case-insensitive
+// regex, or one that can match without a literal of MinLen bytes.
+func Required(expr string) ([]string, bool) {
+
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+
+	lits, ok := required(re.Simplify())
+	if !ok || len(lits) == 0 {
+		return nil, false
+	}
+	for _, lit := range lits {
+		if len(lit) < MinLen {
+			return nil, false
+		}
+	}
+
+	return lits, true
+}
+
+func required(re *syntax.Regexp) ([]string, bool) {
+
+	switch re.Op {
+
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil, false
+		}
+		return []string{string(re.Rune)}, true
+
+	case syntax.OpCapture, syntax.OpPlus:
+		return required(re.Sub[0])
+
+	case syntax.OpRepeat:
+		if re.Min < 1 {
+			return nil, false
+		}
+		return required(re.Sub[0])
+
+	case syntax.OpAlternate:
+		var out []string
+		for _, sub := range re.Sub {
+			lits, ok := required(sub)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, lits...)
+		}
+		return out, true
+
+	case syntax.OpConcat:
+		// Adjacent literals join into one; of the rest keep the alternatives
+		// whose shortest literal is longest
+		var (
+			best []string
+			run  []rune
+		)
+
+		consider := func(lits []string) {
+			if len(lits) > 0 && (best == nil || shortest(lits) > shortest(best)) {
+				best = lits
+			}
+		}
+
+		flush := func() {
+			if len(run) > 0 {
+				consider([]string{string(run)})
+				run = nil
+			}
+		}
+
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0 {
+				run = append(run, sub.Rune...)
+				continue
+			}
+			flush()
+			if lits, ok := required(sub); ok {
+				consider(lits)
+			}
+		}
+		flush()
+
+		return best, best != nil
+	}
+
+	return nil, false
+}
+
+// shortest returns the length in bytes of the shortest of lits.
+func shortest(lits []string) int {
+	n := -1
+	for _, lit := range lits {
+		if l := len(lit); n < 0 || l < n {
+			n = l
+		}
+	}
+	return n
+}
+
+// Literal returns s as a literal to filter on, or false if it is too short.
+func Literal(s string) ([]string, bool) {
+	if len(s) < MinLen {
+		return nil, false
+	}
+	return []string{s}, true
+}
@@ -0,0 +1,58 @@
+package literalz
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+
+	lits := []string{"he", "she", "his", "hers", "still could not bind()", "she", "\x1fcel:3f9a\x1f"}
+	m := New(lits)
+	sc := m.NewScanner()
+
+	lines := []string{
+		"ushers",
+		"this is his",
+		"[emerg] 1655#1655: still could not bind()",
+		"nothing here",
+		"a line \x1fcel:3f9a\x1f",
+		"",
+	}
+
+	for _, line := range lines {
+		sc.Scan(line)
+		for id, lit := range lits {
+			if got, want := sc.Any([]int{id}), strings.Contains(line, lit); got != want {
+				t.Errorf("%q in %q: expected %v, got %v", lit, line, want, got)
+			}
+		}
+	}
+}
+
+func TestRequired(t *testing.T) {
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{expr: `upstream timed out`, want: []string{"upstream timed out"}},
+		{expr: `reset by (peer|host)`, want: []string{"reset by "}},
+		{expr: `(timeout|refused): [0-9]+`, want: []string{"timeout", "refused"}},
+		{expr: `request_time=([0-9.]+)`, want: []string{"request_time="}},
+		{expr: `x+abc[0-9]+defg`, want: []string{"defg"}},
+		{expr: `(?i)timeout`},
+		{expr: `[0-9]+ms`},
+		{expr: `(timeout)?failed`, want: []string{"failed"}},
+		{expr: `a|timeout`},
+		{expr: `(`},
+	}
+
+	for _, tc := range tests {
+		got, ok := Required(tc.expr)
+		if ok != (tc.want != nil) || !slices.Equal(got, tc.want) {
+			t.Errorf("%s: expected %q, got %q (%v)", tc.expr, tc.want, got, ok)
+		}
+	}
+}
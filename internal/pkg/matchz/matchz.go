@@ -8,14 +8,37 @@ type HitsT struct {
 	Count   uint32
 	Entries []EntryT
 	Entity  EntityMetadataT
+	Replay  bool // completed on lines re-read to resume a checkpoint
 }
 
 type EntryT struct {
 	Timestamp int64
 	Entry     []byte
+	Context   *ContextT
+	Values    map[string]float64 // extracted by the rule, by name
+}
+
+// ContextT holds the lines read around an entry. After fills in as the
+// source is read on.
+type ContextT struct {
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
 }
 
 type EntityMetadataT struct {
 	FileName string
 	Origin   bool
+	Labels   map[string]string
+}
+
+// Size approximates the memory held by a hit.
+func (m HitsT) Size() int64 {
+	n := int64(64 + len(m.Entity.FileName))
+	for _, e := range m.Entries {
+		n += int64(32 + len(e.Entry))
+	}
+	for k, v := range m.Entity.Labels {
+		n += int64(len(k) + len(v))
+	}
+	return n
 }
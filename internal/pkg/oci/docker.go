@@ -0,0 +1,122 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The docker config holds the logins of docker login, either inline or in
+// a credential helper such as docker-credential-ecr-login.
+
+const (
+	dockerConfigEnv = "DOCKER_CONFIG"
+	dockerHubHost   = "registry-1.docker.io"
+	dockerHubKey    = "https://index.docker.io/v1/"
+)
+
+type dockerAuthT struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type dockerConfigT struct {
+	Auths       map[string]dockerAuthT `json:"auths"`
+	CredsStore  string                 `json:"credsStore"`
+	CredHelpers map[string]string      `json:"credHelpers"`
+}
+
+// dockerCreds returns the login of the docker config for host, if any.
+func dockerCreds(ctx context.Context, host string) (string, string, bool) {
+
+	dir := os.Getenv(dockerConfigEnv)
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", false
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", "", false
+	}
+
+	var conf dockerConfigT
+	if err := json.Unmarshal(data, &conf); err != nil {
+		log.Warn().Err(err).Str("dir", dir).Msg("Failed to parse docker config")
+		return "", "", false
+	}
+
+	keys := []string{host, "https://" + host, "http://" + host}
+	if host == dockerHubHost || host == "docker.io" {
+		keys = append(keys, dockerHubKey)
+	}
+
+	helper := conf.CredsStore
+	if h, ok := conf.CredHelpers[host]; ok {
+		helper = h
+	}
+	if helper != "" {
+		for _, key := range keys {
+			if username, password, ok := credHelper(ctx, helper, key); ok {
+				return username, password, true
+			}
+		}
+	}
+
+	for _, key := range keys {
+		a, ok := conf.Auths[key]
+		if !ok {
+			continue
+		}
+		if a.Auth != "" {
+			dec, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				continue
+			}
+			if username, password, ok := strings.Cut(string(dec), ":"); ok {
+				return username, password, true
+			}
+		}
+		if a.Username != "" {
+			return a.Username, a.Password, true
+		}
+	}
+
+	return "", "", false
+}
+
+// credHelper asks docker-credential-<helper> for the login of key.
+func credHelper(ctx context.Context, helper, key string) (string, string, bool) {
+
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(key)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		log.Debug().Err(err).Str("helper", helper).Str("key", key).Msg("No credentials from docker credential helper")
+		return "", "", false
+	}
+
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &creds); err != nil || creds.Secret == "" {
+		return "", "", false
+	}
+
+	return creds.Username, creds.Secret, true
+}
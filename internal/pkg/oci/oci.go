@@ -0,0 +1,507 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
+	"github.com/rs/zerolog/log"
+)
+
+// Rules are distributed as OCI artifacts so they can be pushed, mirrored
+// and pinned with the tooling of container images:
+//
+//	oras push registry.acme.internal/preq/cres:1.4.0 \
+//	    rules.yaml:application/vnd.prequel.rules.v1+yaml
+//
+// and pulled from oci://registry.acme.internal/preq/cres:1.4.0, or from
+// oci://registry.acme.internal/preq/cres@sha256:<digest> to pin a version.
+// Pull returns the layer of RulesMediaType, or the only layer of an
+// artifact with one, checked against its digest. Registries are reached
+// over HTTPS, except on loopback addresses, with the credentials given or
+// else those of the docker config, as docker login leaves them.
+//
+// The signatures cosign sign leaves on an artifact, under the tag
+// sha256-<digest>.sig, are returned by Signatures for the caller to check.
+// Pull returns the manifest the rules were pulled with, so that they can
+// be checked against a signature later with CheckRules, without the
+// registry.
+
+const (
+	Scheme         = "oci"
+	RulesMediaType = "application/vnd.prequel.rules.v1+yaml"
+
+	defaultTag      = "latest"
+	maxManifestSize = 4 * 1024 * 1024
+
+	mediaTypeOciManifest    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+
+	cosignSigSuffix     = ".sig"
+	cosignSigAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+var (
+	ErrRef      = errors.New("invalid OCI reference")
+	ErrManifest = errors.New("unsupported OCI manifest")
+	ErrNoRules  = errors.New("OCI artifact has no rules layer")
+	ErrDigest   = errors.New("OCI content does not match its digest")
+	ErrStatus   = errors.New("OCI registry responded with an error")
+	ErrNotFound = errors.New("OCI artifact not found")
+	ErrNoSigs   = errors.New("OCI artifact has no signatures")
+	ErrTooLarge = errors.New("OCI rules layer exceeds maximum size")
+)
+
+var (
+	repoPath  = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagName   = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	digestRef = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	authParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// RefT names an artifact in a registry by tag or digest.
+type RefT struct {
+	Host   string
+	Repo   string
+	Tag    string
+	Digest string
+}
+
+func (r RefT) String() string {
+	if r.Digest != "" {
+		return fmt.Sprintf("%s://%s/%s@%s", Scheme, r.Host, r.Repo, r.Digest)
+	}
+	return fmt.Sprintf("%s://%s/%s:%s", Scheme, r.Host, r.Repo, r.Tag)
+}
+
+// reference is the digest of r if pinned, else its tag.
+func (r RefT) reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// IsRef reports whether s is meant as an OCI reference.
+func IsRef(s string) bool {
+	return strings.HasPrefix(s, Scheme+"://")
+}
+
+// ParseRef parses oci://host[:port]/repo[:tag|@digest]. The tag defaults
+// to latest.
+func ParseRef(s string) (RefT, error) {
+
+	rest, ok := strings.CutPrefix(s, Scheme+"://")
+	if !ok {
+		return RefT{}, fmt.Errorf("%w: %s: missing %s://", ErrRef, s, Scheme)
+	}
+
+	host, path, ok := strings.Cut(rest, "/")
+	if !ok || host == "" {
+		return RefT{}, fmt.Errorf("%w: %s: missing repository", ErrRef, s)
+	}
+
+	ref := RefT{Host: host, Tag: defaultTag}
+
+	switch name, digest, pinned := strings.Cut(path, "@"); {
+	case pinned:
+		if !digestRef.MatchString(digest) {
+			return RefT{}, fmt.Errorf("%w: %s: digest must be sha256:<hex>", ErrRef, s)
+		}
+		ref.Repo, ref.Tag, ref.Digest = name, "", digest
+	default:
+		ref.Repo = path
+		if i := strings.LastIndex(path, ":"); i >= 0 {
+			ref.Repo, ref.Tag = path[:i], path[i+1:]
+			if !tagName.MatchString(ref.Tag) {
+				return RefT{}, fmt.Errorf("%w: %s: bad tag %q", ErrRef, s, ref.Tag)
+			}
+		}
+	}
+
+	if !repoPath.MatchString(ref.Repo) {
+		return RefT{}, fmt.Errorf("%w: %s: bad repository %q", ErrRef, s, ref.Repo)
+	}
+
+	return ref, nil
+}
+
+type optsT struct {
+	client   *http.Client
+	username string
+	password string
+	token    string
+	maxSize  int64
+}
+
+type OptT func(*optsT)
+
+// WithClient sends the requests with client, such as one with a private CA
+// or a client certificate.
+func WithClient(client *http.Client) OptT {
+	return func(o *optsT) {
+		o.client = client
+	}
+}
+
+// WithBasicAuth logs in to the registry as username, instead of with the
+// docker config.
+func WithBasicAuth(username, password string) OptT {
+	return func(o *optsT) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// WithToken sends token as the bearer token of every request, skipping
+// the token exchange of the registry.
+func WithToken(token string) OptT {
+	return func(o *optsT) {
+		o.token = token
+	}
+}
+
+// WithMaxSize caps the size of the rules layer; zero leaves it uncapped.
+func WithMaxSize(n int64) OptT {
+	return func(o *optsT) {
+		o.maxSize = n
+	}
+}
+
+type pullerT struct {
+	ref    RefT
+	opts   optsT
+	scheme string
+	auth   string // Authorization header of the requests
+}
+
+type descriptorT struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type manifestT struct {
+	MediaType string        `json:"mediaType"`
+	Layers    []descriptorT `json:"layers"`
+}
+
+// SignatureT is a cosign signature of an artifact: the signature of a
+// simple signing payload naming the digest of the artifact's manifest.
+type SignatureT struct {
+	Payload   []byte
+	Signature []byte // base64 encoded
+}
+
+func newPuller(ref RefT, opts ...OptT) *pullerT {
+
+	p := &pullerT{ref: ref, scheme: "https"}
+	for _, opt := range opts {
+		opt(&p.opts)
+	}
+	if p.opts.client == nil {
+		p.opts.client = httpz.New(httpz.WithName("oci"))
+	}
+	if isLoopback(ref.Host) {
+		p.scheme = "http"
+	}
+	if p.opts.token != "" {
+		p.auth = "Bearer " + p.opts.token
+	}
+
+	return p
+}
+
+// Pull returns the rules of the artifact ref and its manifest.
+func Pull(ctx context.Context, ref RefT, opts ...OptT) ([]byte, []byte, error) {
+
+	p := newPuller(ref, opts...)
+
+	m, raw, err := p.manifest(ctx, ref.reference())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	layer, err := rulesLayer(m)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	if p.opts.maxSize > 0 && layer.Size > p.opts.maxSize {
+		return nil, nil, fmt.Errorf("%w: %d bytes (limit %d)", ErrTooLarge, layer.Size, p.opts.maxSize)
+	}
+
+	data, err := p.blob(ctx, layer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Debug().
+		Str("ref", ref.String()).
+		Str("manifest", Digest(raw)).
+		Str("digest", layer.Digest).
+		Int64("size", layer.Size).
+		Msg("Pulled OCI rules")
+
+	return data, raw, nil
+}
+
+// CheckRules checks that data is the rules layer of manifest.
+func CheckRules(manifest, data []byte) error {
+
+	var m manifestT
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return fmt.Errorf("%w: %w", ErrManifest, err)
+	}
+
+	layer, err := rulesLayer(m)
+	if err != nil {
+		return err
+	}
+	if Digest(data) != layer.Digest {
+		return fmt.Errorf("%w: rules %s", ErrDigest, layer.Digest)
+	}
+
+	return nil
+}
+
+// Signatures returns the cosign signatures of the artifact of ref whose
+// manifest has digest.
+func Signatures(ctx context.Context, ref RefT, digest string, opts ...OptT) ([]SignatureT, error) {
+
+	p := newPuller(ref, opts...)
+
+	m, _, err := p.manifest(ctx, strings.Replace(digest, ":", "-", 1)+cosignSigSuffix)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNoSigs, ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sigs []SignatureT
+	for _, l := range m.Layers {
+		sig, ok := l.Annotations[cosignSigAnnotation]
+		if !ok || l.Size > maxManifestSize {
+			continue
+		}
+		payload, err := p.blob(ctx, l)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, SignatureT{Payload: payload, Signature: []byte(sig)})
+	}
+
+	if len(sigs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoSigs, ref)
+	}
+
+	return sigs, nil
+}
+
+// manifest reads the image manifest of reference, a tag or digest, and
+// returns it parsed and as read.
+func (p *pullerT) manifest(ctx context.Context, reference string) (manifestT, []byte, error) {
+
+	var m manifestT
+
+	data, err := p.get(ctx, "manifests/"+reference, mediaTypeOciManifest+", "+mediaTypeDockerManifest, maxManifestSize)
+	if err != nil {
+		return m, nil, err
+	}
+
+	if digestRef.MatchString(reference) && Digest(data) != reference {
+		return m, nil, fmt.Errorf("%w: manifest %s of %s", ErrDigest, reference, p.ref)
+	}
+
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, nil, fmt.Errorf("%w: %w", ErrManifest, err)
+	}
+	if m.MediaType != "" && m.MediaType != mediaTypeOciManifest && m.MediaType != mediaTypeDockerManifest {
+		return m, nil, fmt.Errorf("%w: %s", ErrManifest, m.MediaType)
+	}
+
+	return m, data, nil
+}
+
+// blob reads the blob of a layer, checked against its digest.
+func (p *pullerT) blob(ctx context.Context, layer descriptorT) ([]byte, error) {
+
+	data, err := p.get(ctx, "blobs/"+layer.Digest, "", layer.Size)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != layer.Size || Digest(data) != layer.Digest {
+		return nil, fmt.Errorf("%w: layer %s of %s", ErrDigest, layer.Digest, p.ref)
+	}
+
+	return data, nil
+}
+
+// rulesLayer returns the layer of RulesMediaType, or the only layer.
+func rulesLayer(m manifestT) (descriptorT, error) {
+	for _, l := range m.Layers {
+		if l.MediaType == RulesMediaType {
+			return l, nil
+		}
+	}
+	if len(m.Layers) == 1 {
+		return m.Layers[0], nil
+	}
+	return descriptorT{}, fmt.Errorf("%w: %d layers, none of type %s", ErrNoRules, len(m.Layers), RulesMediaType)
+}
+
+// get reads at most limit bytes from the registry API, authenticating once
+// if challenged.
+func (p *pullerT) get(ctx context.Context, path, accept string, limit int64) ([]byte, error) {
+
+	for retried := false; ; retried = true {
+
+		u := fmt.Sprintf("%s://%s/v2/%s/%s", p.scheme, p.ref.Host, p.ref.Repo, path)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if p.auth != "" {
+			req.Header.Set("Authorization", p.auth)
+		}
+
+		resp, err := p.opts.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && !retried && p.opts.token == "" {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if p.auth, err = p.authenticate(ctx, challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotFound:
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, u)
+		default:
+			return nil, fmt.Errorf("%w: %s: %s", ErrStatus, u, resp.Status)
+		}
+
+		data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > limit {
+			return nil, fmt.Errorf("%w: %s: more than %d bytes", ErrStatus, u, limit)
+		}
+		return data, nil
+	}
+}
+
+// authenticate answers the challenge of a registry with its credentials,
+// exchanging them for a token where the registry asks for one.
+func (p *pullerT) authenticate(ctx context.Context, challenge string) (string, error) {
+
+	username, password := p.opts.username, p.opts.password
+	if username == "" {
+		username, password, _ = dockerCreds(ctx, p.ref.Host)
+	}
+
+	scheme, rest, _ := strings.Cut(challenge, " ")
+	params := make(map[string]string)
+	for _, m := range authParam.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+
+	switch {
+	case strings.EqualFold(scheme, "basic") && username != "":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case strings.EqualFold(scheme, "bearer") && params["realm"] != "":
+	default:
+		return "", fmt.Errorf("%w: unauthorized for %s", ErrStatus, p.ref)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", err
+	}
+
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	q.Set("scope", "repository:"+p.ref.Repo+":pull")
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := p.opts.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: token for %s: %s", ErrStatus, p.ref, resp.Status)
+	}
+
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&tok); err != nil {
+		return "", err
+	}
+
+	token := tok.Token
+	if token == "" {
+		token = tok.AccessToken
+	}
+	if token == "" {
+		return "", fmt.Errorf("%w: no token for %s", ErrStatus, p.ref)
+	}
+
+	return "Bearer " + token, nil
+}
+
+// Digest returns the digest of content, as registries name it.
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// isLoopback reports whether host, with or without a port, is this
+// machine, which registries are commonly run on without TLS.
+func isLoopback(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,276 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRef(t *testing.T) {
+
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	testCases := []struct {
+		ref  string
+		want RefT
+		err  bool
+	}{
+		{ref: "oci://ghcr.io/acme/cres:1.4.0", want: RefT{Host: "ghcr.io", Repo: "acme/cres", Tag: "1.4.0"}},
+		{ref: "oci://localhost:5000/cres", want: RefT{Host: "localhost:5000", Repo: "cres", Tag: "latest"}},
+		{ref: "oci://registry.acme.internal/preq/cres@" + digest, want: RefT{Host: "registry.acme.internal", Repo: "preq/cres", Digest: digest}},
+		{ref: "https://ghcr.io/acme/cres:1.4.0", err: true},
+		{ref: "oci://ghcr.io", err: true},
+		{ref: "oci://ghcr.io/Acme/cres", err: true},
+		{ref: "oci://ghcr.io/acme/cres:", err: true},
+		{ref: "oci://ghcr.io/acme/cres@sha256:abc", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.ref, func(t *testing.T) {
+			got, err := ParseRef(tc.ref)
+			switch {
+			case tc.err:
+				if !errors.Is(err, ErrRef) {
+					t.Errorf("Expected %v, got %v", ErrRef, err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case got != tc.want:
+				t.Errorf("Expected %+v, got %+v", tc.want, got)
+			case got.String() != tc.ref && !strings.HasSuffix(tc.ref, "/cres"):
+				t.Errorf("Expected %s, got %s", tc.ref, got)
+			}
+		})
+	}
+}
+
+// registryT serves one artifact behind a token exchange, as a registry
+// such as Docker Hub or GHCR does.
+type registryT struct {
+	*httptest.Server
+	manifest []byte
+	layers   map[string][]byte
+	tags     map[string][]byte // manifests of other tags
+	login    string            // basic credentials the token endpoint accepts
+}
+
+const testToken = "pull-token"
+
+func newRegistry(t *testing.T, layers map[string]string) *registryT {
+
+	reg := &registryT{layers: make(map[string][]byte), tags: make(map[string][]byte)}
+
+	m := manifestT{MediaType: mediaTypeOciManifest}
+	for mediaType, data := range layers {
+		d := descriptorT{MediaType: mediaType, Digest: Digest([]byte(data)), Size: int64(len(data))}
+		m.Layers = append(m.Layers, d)
+		reg.layers[d.Digest] = []byte(data)
+	}
+	reg.manifest, _ = json.Marshal(m)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if reg.login != "" && user+":"+pass != reg.login {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("scope") != "repository:acme/cres:pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, `{"token":%q}`, testToken)
+	})
+
+	mux.HandleFunc("/v2/acme/cres/", func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, reg.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch ref := strings.TrimPrefix(r.URL.Path, "/v2/acme/cres/"); {
+		// Any digest, to check that clients verify it
+		case ref == "manifests/1.0" || strings.HasPrefix(ref, "manifests/sha256:"):
+			w.Header().Set("Content-Type", mediaTypeOciManifest)
+			w.Write(reg.manifest)
+		case reg.tags[strings.TrimPrefix(ref, "manifests/")] != nil:
+			w.Write(reg.tags[strings.TrimPrefix(ref, "manifests/")])
+		case strings.HasPrefix(ref, "blobs/"):
+			data, ok := reg.layers[strings.TrimPrefix(ref, "blobs/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	reg.Server = httptest.NewServer(mux)
+	t.Cleanup(reg.Close)
+
+	return reg
+}
+
+func (r *registryT) ref(t *testing.T, reference string) RefT {
+	sep := ":"
+	if strings.HasPrefix(reference, "sha256:") {
+		sep = "@"
+	}
+	ref, err := ParseRef(Scheme + "://" + strings.TrimPrefix(r.URL, "http://") + "/acme/cres" + sep + reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestPull(t *testing.T) {
+
+	const rules = "rules: []\n"
+
+	t.Setenv(dockerConfigEnv, t.TempDir())
+
+	reg := newRegistry(t, map[string]string{
+		RulesMediaType: rules,
+		"application/vnd.oci.image.config.v1+json": "{}",
+	})
+
+	data, manifest, err := Pull(context.Background(), reg.ref(t, "1.0"))
+	if err != nil || string(data) != rules || !bytes.Equal(manifest, reg.manifest) {
+		t.Fatalf("Expected the rules layer, got %q %s (%v)", data, manifest, err)
+	}
+	if err := CheckRules(manifest, data); err != nil {
+		t.Errorf("Expected the rules of the manifest, got %v", err)
+	}
+	if err := CheckRules(manifest, []byte("rules: [x]\n")); !errors.Is(err, ErrDigest) {
+		t.Errorf("Expected %v, got %v", ErrDigest, err)
+	}
+
+	// Pinned by digest
+	data, _, err = Pull(context.Background(), reg.ref(t, Digest(reg.manifest)))
+	if err != nil || string(data) != rules {
+		t.Fatalf("Expected the rules layer by digest, got %q (%v)", data, err)
+	}
+
+	// A manifest that does not match its digest
+	other := Digest([]byte("other"))
+	if _, _, err := Pull(context.Background(), reg.ref(t, other)); !errors.Is(err, ErrDigest) {
+		t.Errorf("Expected %v, got %v", ErrDigest, err)
+	}
+
+	if _, _, err := Pull(context.Background(), reg.ref(t, "1.0"), WithMaxSize(4)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected %v, got %v", ErrTooLarge, err)
+	}
+
+	if _, _, err := Pull(context.Background(), reg.ref(t, "2.0")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected %v, got %v", ErrNotFound, err)
+	}
+
+	// A tampered layer of the same size
+	for digest := range reg.layers {
+		reg.layers[digest] = []byte("rules: {}\n")
+	}
+	if _, _, err := Pull(context.Background(), reg.ref(t, "1.0")); !errors.Is(err, ErrDigest) {
+		t.Errorf("Expected %v, got %v", ErrDigest, err)
+	}
+}
+
+func TestPullLayers(t *testing.T) {
+
+	t.Setenv(dockerConfigEnv, t.TempDir())
+
+	// The only layer is taken whatever its type
+	reg := newRegistry(t, map[string]string{"application/yaml": "rules: []\n"})
+	if _, _, err := Pull(context.Background(), reg.ref(t, "1.0")); err != nil {
+		t.Errorf("Expected the only layer, got %v", err)
+	}
+
+	reg = newRegistry(t, map[string]string{"application/yaml": "rules: []\n", "text/plain": "README"})
+	if _, _, err := Pull(context.Background(), reg.ref(t, "1.0")); !errors.Is(err, ErrNoRules) {
+		t.Errorf("Expected %v, got %v", ErrNoRules, err)
+	}
+}
+
+func TestPullAuth(t *testing.T) {
+
+	const rules = "rules: []\n"
+
+	reg := newRegistry(t, map[string]string{RulesMediaType: rules})
+	reg.login = "preq:secret"
+
+	var (
+		dir  = t.TempDir()
+		host = strings.TrimPrefix(reg.URL, "http://")
+	)
+	t.Setenv(dockerConfigEnv, dir)
+
+	if _, _, err := Pull(context.Background(), reg.ref(t, "1.0")); !errors.Is(err, ErrStatus) {
+		t.Errorf("Expected %v without a login, got %v", ErrStatus, err)
+	}
+
+	if _, _, err := Pull(context.Background(), reg.ref(t, "1.0"), WithBasicAuth("preq", "secret")); err != nil {
+		t.Errorf("Expected a pull with basic auth, got %v", err)
+	}
+
+	if _, _, err := Pull(context.Background(), reg.ref(t, "1.0"), WithToken(testToken)); err != nil {
+		t.Errorf("Expected a pull with a token, got %v", err)
+	}
+
+	// As docker login leaves it
+	conf := fmt.Sprintf(`{"auths":{%q:{"auth":%q}}}`, host, base64.StdEncoding.EncodeToString([]byte("preq:secret")))
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Pull(context.Background(), reg.ref(t, "1.0")); err != nil {
+		t.Errorf("Expected a pull with the docker login, got %v", err)
+	}
+}
+
+func TestSignatures(t *testing.T) {
+
+	t.Setenv(dockerConfigEnv, t.TempDir())
+
+	var (
+		reg     = newRegistry(t, map[string]string{RulesMediaType: "rules: []\n"})
+		digest  = Digest(reg.manifest)
+		payload = fmt.Sprintf(`{"critical":{"image":{"docker-manifest-digest":%q}}}`, digest)
+	)
+
+	if _, err := Signatures(context.Background(), reg.ref(t, "1.0"), digest); !errors.Is(err, ErrNoSigs) {
+		t.Errorf("Expected %v before signing, got %v", ErrNoSigs, err)
+	}
+
+	// As cosign sign leaves it
+	sig := manifestT{
+		MediaType: mediaTypeOciManifest,
+		Layers: []descriptorT{{
+			MediaType:   "application/vnd.dev.cosign.simplesigning.v1+json",
+			Digest:      Digest([]byte(payload)),
+			Size:        int64(len(payload)),
+			Annotations: map[string]string{cosignSigAnnotation: "c2lnbmF0dXJl"},
+		}},
+	}
+	reg.tags[strings.Replace(digest, ":", "-", 1)+".sig"], _ = json.Marshal(sig)
+	reg.layers[sig.Layers[0].Digest] = []byte(payload)
+
+	sigs, err := Signatures(context.Background(), reg.ref(t, "1.0"), digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 1 || string(sigs[0].Payload) != payload || string(sigs[0].Signature) != "c2lnbmF0dXJl" {
+		t.Errorf("Unexpected signatures %+v", sigs)
+	}
+}
@@ -0,0 +1,538 @@
+package pluginz
+
+// A matcher plugin is an external process that decides rule conditions
+// preq cannot express itself. Plugins are declared in the configuration:
+//
+//	matcherPlugins:
+//	  - name: fraud
+//	    command: /usr/local/bin/preq-fraud
+//	    args: [--model, /etc/fraud/model.bin]
+//	    timeout: 2s
+//
+// and a rule condition hands a line to one with the arguments it needs:
+//
+//	match:
+//	  - plugin:
+//	      name: fraud
+//	      args:
+//	        kind: card-testing
+//
+// As with cel conditions, each plugin condition is swapped for a raw term on
+// a marker when the rules are read. The plugin is asked about a line once,
+// however many rules hand it conditions, and the markers of those it holds
+// for are appended to the line only as their own rule's matchers see it.
+//
+// The protocol is newline delimited JSON on the plugin's stdin and stdout,
+// one reply per request. The plugin is started on the first line it is to
+// see and is told the conditions it decides:
+//
+//	> {"type":"init","version":1,"terms":[{"id":"3f9a...","args":{"kind":"card-testing"}}]}
+//	< {"ok":true}
+//
+// Conditions loaded later are sent the same way with "type":"terms". Then
+// every line of the sources is sent, and the plugin replies with the ids of
+// the conditions it holds for:
+//
+//	> {"type":"line","source":"app.log","ts":1741701610000000000,"line":"..."}
+//	< {"ok":true,"match":["3f9a..."]}
+//
+// A reply with "ok":false and an "error" fails that request. A plugin that
+// exits, writes something other than a reply, or does not reply within its
+// timeout (5s unless set) is stopped, logged, and matches nothing for the
+// rest of the run. Replies are limited to 1MiB. Anything the plugin writes
+// to stderr is logged.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ProtocolVersion = 1
+
+	keyPlugin    = "plugin"
+	markerPrefix = utils.MarkerSep + "plugin:"
+	markerSuffix = utils.MarkerSep
+	maxReply     = 1 << 20
+
+	defaultTimeout = 5 * time.Second
+)
+
+var (
+	ErrUnknownPlugin = errors.New("unknown matcher plugin")
+	ErrPluginTerm    = errors.New("plugin condition needs a name")
+	ErrPluginMixed   = errors.New("plugin cannot be combined with value, regex, jq or cel")
+	ErrPluginReply   = errors.New("invalid plugin reply")
+	ErrPluginStopped = errors.New("plugin stopped")
+)
+
+// SpecT declares a plugin in the configuration.
+type SpecT struct {
+	Name    string            `yaml:"name"`
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args,omitempty"`
+	Env     map[string]string `yaml:"env,omitempty"`
+	Timeout time.Duration     `yaml:"timeout,omitempty"`
+}
+
+type termT struct {
+	Id   string         `json:"id"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+type requestT struct {
+	Type    string  `json:"type"`
+	Version int     `json:"version,omitempty"`
+	Terms   []termT `json:"terms,omitempty"`
+	Source  string  `json:"source,omitempty"`
+	Ts      int64   `json:"ts,omitempty"`
+	Line    string  `json:"line,omitempty"`
+}
+
+type replyT struct {
+	Ok    bool     `json:"ok"`
+	Error string   `json:"error,omitempty"`
+	Match []string `json:"match,omitempty"`
+}
+
+// HostT runs the plugins of the configuration. A nil *HostT has none.
+type HostT struct {
+	mux     sync.RWMutex
+	plugins map[string]*pluginT
+	markers map[string]*pluginT // by marker
+}
+
+type pluginT struct {
+	mux   sync.Mutex
+	spec  SpecT
+	terms []termT
+	sent  int // terms the process has been told of
+	descs map[string]string
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	failed bool
+}
+
+func New(specs []SpecT) (*HostT, error) {
+
+	h := &HostT{
+		plugins: make(map[string]*pluginT, len(specs)),
+		markers: make(map[string]*pluginT),
+	}
+
+	for i, spec := range specs {
+		if spec.Name == "" || spec.Command == "" {
+			return nil, fmt.Errorf("matcher plugin #%d: needs a name and a command", i)
+		}
+		if _, ok := h.plugins[spec.Name]; ok {
+			return nil, fmt.Errorf("matcher plugin %q: declared twice", spec.Name)
+		}
+		h.plugins[spec.Name] = &pluginT{spec: spec, descs: make(map[string]string)}
+	}
+
+	return h, nil
+}
+
+// Len returns the number of plugin conditions in the rules read so far.
+func (h *HostT) Len() int {
+	if h == nil {
+		return 0
+	}
+
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	return len(h.markers)
+}
+
+// Rewrite replaces each plugin condition in a rules document with a raw
+// term on its marker. Documents without plugin conditions are returned as
+// they are.
+func (h *HostT) Rewrite(data []byte) ([]byte, error) {
+
+	if !bytes.Contains(data, []byte(keyPlugin)) {
+		return data, nil
+	}
+
+	return utils.RewriteDocs(data, func(doc *yaml.Node) (bool, error) {
+		n, err := h.rewriteNode(doc)
+		return n > 0, err
+	})
+}
+
+func (h *HostT) rewriteNode(n *yaml.Node) (int, error) {
+
+	var count int
+
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value != keyPlugin || n.Content[i+1].Kind != yaml.MappingNode {
+				continue
+			}
+
+			for j := 0; j+1 < len(n.Content); j += 2 {
+				switch n.Content[j].Value {
+				case "value", "regex", "jq", "cel":
+					return 0, fmt.Errorf("line %d: %w", n.Line, ErrPluginMixed)
+				}
+			}
+
+			var term struct {
+				Name string         `yaml:"name"`
+				Args map[string]any `yaml:"args"`
+			}
+			if err := n.Content[i+1].Decode(&term); err != nil {
+				return 0, fmt.Errorf("line %d: %w", n.Line, err)
+			}
+
+			marker, err := h.add(term.Name, term.Args)
+			if err != nil {
+				return 0, fmt.Errorf("line %d: %w", n.Line, err)
+			}
+
+			n.Content[i] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "value"}
+			n.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: marker, Style: yaml.DoubleQuotedStyle}
+			count++
+		}
+	}
+
+	for _, c := range n.Content {
+		nc, err := h.rewriteNode(c)
+		if err != nil {
+			return 0, err
+		}
+		count += nc
+	}
+
+	return count, nil
+}
+
+// add registers a condition of the named plugin and returns its marker.
+func (h *HostT) add(name string, args map[string]any) (string, error) {
+
+	if name == "" {
+		return "", ErrPluginTerm
+	}
+
+	if h == nil {
+		return "", fmt.Errorf("%w: %q", ErrUnknownPlugin, name)
+	}
+
+	p, ok := h.plugins[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownPlugin, name)
+	}
+
+	// Marshalled maps have sorted keys, so equal conditions share a marker
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "", fmt.Errorf("plugin %s: %w", name, err)
+	}
+
+	sum := sha256.Sum256(append([]byte(name+"\x00"), data...))
+	id := hex.EncodeToString(sum[:6])
+	marker := markerPrefix + utils.MarkerNonce + ":" + id + markerSuffix
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if _, ok := h.markers[marker]; ok {
+		return marker, nil
+	}
+	h.markers[marker] = p
+
+	p.mux.Lock()
+	p.terms = append(p.terms, termT{Id: id, Args: args})
+	p.descs[marker] = fmt.Sprintf("%s %s", name, data)
+	p.mux.Unlock()
+
+	return marker, nil
+}
+
+// Expr describes the plugin condition a raw term value stands for, if it is
+// a marker.
+func (h *HostT) Expr(value string) (string, bool) {
+	if h == nil || !strings.HasPrefix(value, markerPrefix) {
+		return "", false
+	}
+
+	h.mux.RLock()
+	p, ok := h.markers[value]
+	h.mux.RUnlock()
+	if !ok {
+		return "", false
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.descs[value], true
+}
+
+// IsMarker reports whether a raw term value stands for a plugin condition.
+func IsMarker(value string) bool {
+	return strings.HasPrefix(value, markerPrefix)
+}
+
+// RepliesT holds what the plugins replied for one line, so that each is
+// asked once however many rules hand it conditions. The zero value is
+// ready to use.
+type RepliesT struct {
+	ids map[*pluginT][]string
+}
+
+// BoundT holds the plugin conditions of one rule.
+type BoundT struct {
+	plugins []*pluginT
+	markers map[string]struct{}
+}
+
+// Bind returns the plugin conditions among markers, or nil if there are
+// none.
+func (h *HostT) Bind(markers []string) *BoundT {
+	if h == nil {
+		return nil
+	}
+
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	var b BoundT
+	for _, marker := range markers {
+		p, ok := h.markers[marker]
+		if !ok {
+			continue
+		}
+		if b.markers == nil {
+			b.markers = make(map[string]struct{})
+		}
+		b.markers[marker] = struct{}{}
+		if !slices.Contains(b.plugins, p) {
+			b.plugins = append(b.plugins, p)
+		}
+	}
+
+	if len(b.plugins) == 0 {
+		return nil
+	}
+	return &b
+}
+
+// Holds appends to dst the markers of the conditions that hold for line,
+// asking the plugins that replies has no answer from yet.
+func (b *BoundT) Holds(dst []string, replies *RepliesT, source string, ts int64, line string) []string {
+
+	for _, p := range b.plugins {
+
+		ids, ok := replies.ids[p]
+		if !ok {
+			ids = p.match(source, ts, line)
+			if replies.ids == nil {
+				replies.ids = make(map[*pluginT][]string)
+			}
+			replies.ids[p] = ids
+		}
+
+		for _, id := range ids {
+			marker := markerPrefix + utils.MarkerNonce + ":" + id + markerSuffix
+			if _, ok := b.markers[marker]; ok {
+				dst = append(dst, marker)
+			}
+		}
+	}
+
+	return dst
+}
+
+// match asks the plugin which of its conditions hold for line.
+func (p *pluginT) match(source string, ts int64, line string) []string {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if len(p.terms) == 0 || p.failed {
+		return nil
+	}
+
+	if p.cmd == nil {
+		if err := p.start(); err != nil {
+			p.fail(err)
+			return nil
+		}
+	}
+
+	if p.sent < len(p.terms) {
+		if _, err := p.call(requestT{Type: "terms", Terms: p.terms[p.sent:]}); err != nil {
+			p.fail(err)
+			return nil
+		}
+		p.sent = len(p.terms)
+	}
+
+	reply, err := p.call(requestT{Type: "line", Source: source, Ts: ts, Line: line})
+	if err != nil {
+		if errors.Is(err, ErrPluginReply) {
+			log.Warn().Err(err).Str("plugin", p.spec.Name).Msg("Plugin failed to match line")
+			return nil
+		}
+		p.fail(err)
+		return nil
+	}
+
+	return reply.Match
+}
+
+// start runs the plugin process and sends it the conditions so far. Called
+// with the lock held.
+func (p *pluginT) start() error {
+
+	cmd := exec.Command(p.spec.Command, p.spec.Args...)
+	if len(p.spec.Env) > 0 {
+		cmd.Env = cmd.Environ()
+		for k, v := range p.spec.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("plugin", p.spec.Name).Str("command", p.spec.Command).Msg("Starting matcher plugin")
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Warn().Str("plugin", p.spec.Name).Msg(scanner.Text())
+		}
+	}()
+
+	p.cmd, p.stdin, p.stdout = cmd, stdin, bufio.NewReaderSize(stdout, maxReply)
+
+	if _, err := p.call(requestT{Type: "init", Version: ProtocolVersion, Terms: p.terms}); err != nil {
+		return err
+	}
+	p.sent = len(p.terms)
+
+	return nil
+}
+
+// call sends one request and reads its reply, killing the plugin if it
+// takes longer than its timeout. Called with the lock held.
+func (p *pluginT) call(req requestT) (replyT, error) {
+
+	var reply replyT
+
+	timeout := p.spec.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	proc := p.cmd.Process
+	timer := time.AfterFunc(timeout, func() { proc.Kill() })
+	defer timer.Stop()
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return reply, err
+	}
+
+	if _, err = p.stdin.Write(append(data, '\n')); err != nil {
+		return reply, fmt.Errorf("%w: %w", ErrPluginStopped, err)
+	}
+
+	line, err := p.stdout.ReadSlice('\n')
+	switch {
+	case !timer.Stop():
+		return reply, fmt.Errorf("%w: no reply within %s", ErrPluginStopped, timeout)
+	case errors.Is(err, bufio.ErrBufferFull):
+		return reply, fmt.Errorf("%w: reply too long", ErrPluginStopped)
+	case err != nil:
+		return reply, fmt.Errorf("%w: %w", ErrPluginStopped, err)
+	}
+
+	if err = json.Unmarshal(line, &reply); err != nil {
+		return reply, fmt.Errorf("%w: %w", ErrPluginStopped, err)
+	}
+
+	if !reply.Ok {
+		return reply, fmt.Errorf("%w: %s", ErrPluginReply, reply.Error)
+	}
+
+	return reply, nil
+}
+
+// fail stops the plugin for the rest of the run. Called with the lock held.
+func (p *pluginT) fail(err error) {
+	log.Error().Err(err).Str("plugin", p.spec.Name).Msg("Matcher plugin failed; its conditions will not match")
+	p.failed = true
+	p.stop()
+}
+
+// stop closes the plugin's stdin and waits for it to exit. Called with the
+// lock held.
+func (p *pluginT) stop() {
+	if p.cmd == nil {
+		return
+	}
+
+	p.stdin.Close()
+	if err := p.cmd.Wait(); err != nil && !p.failed {
+		log.Warn().Err(err).Str("plugin", p.spec.Name).Msg("Matcher plugin exited with error")
+	}
+	p.cmd = nil
+}
+
+// Close stops the plugins.
+func (h *HostT) Close() error {
+	if h == nil {
+		return nil
+	}
+
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	for _, name := range slices.Sorted(maps.Keys(h.plugins)) {
+		p := h.plugins[name]
+		p.mux.Lock()
+		p.stop()
+		p.mux.Unlock()
+	}
+
+	return nil
+}
+
+// Strip removes the markers appended to line.
+func Strip(line string) string {
+	before, _, _ := strings.Cut(line, " "+markerPrefix)
+	return before
+}
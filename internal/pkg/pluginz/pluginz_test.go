@@ -0,0 +1,261 @@
+package pluginz
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test binary doubles as a plugin matching the lines that contain the
+// "contains" argument of a condition. It replies to "big" past the size of
+// a default buffer, and to "slow" not at all.
+func TestMain(m *testing.M) {
+	if os.Getenv("PREQ_TEST_PLUGIN") == "" {
+		os.Exit(m.Run())
+	}
+
+	var (
+		terms   = make(map[string]string)
+		scanner = bufio.NewScanner(os.Stdin)
+		enc     = json.NewEncoder(os.Stdout)
+	)
+
+	for scanner.Scan() {
+		var req requestT
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			os.Exit(1)
+		}
+
+		for _, t := range req.Terms {
+			terms[t.Id], _ = t.Args["contains"].(string)
+		}
+
+		reply := replyT{Ok: true}
+		if req.Type == "line" {
+			switch req.Line {
+			case "crash":
+				os.Exit(1)
+			case "slow":
+				time.Sleep(time.Minute)
+			case "big":
+				reply.Error = strings.Repeat("x", 8192)
+			}
+			for id, s := range terms {
+				if strings.Contains(req.Line, s) {
+					reply.Match = append(reply.Match, id)
+				}
+			}
+		}
+		enc.Encode(reply)
+	}
+
+	os.Exit(0)
+}
+
+func testHost(t *testing.T) *HostT {
+	t.Helper()
+	return testHostTimeout(t, 0)
+}
+
+func testHostTimeout(t *testing.T, timeout time.Duration) *HostT {
+	t.Helper()
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := New([]SpecT{{
+		Name:    "contains",
+		Command: exe,
+		Env:     map[string]string{"PREQ_TEST_PLUGIN": "1"},
+		Timeout: timeout,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+
+	return h
+}
+
+func TestRewrite(t *testing.T) {
+
+	h := testHost(t)
+
+	doc := `rules:
+  - rule:
+      set:
+        match:
+          - plugin:
+              name: contains
+              args:
+                contains: card
+          - value: "timeout"
+`
+
+	out, err := h.Rewrite([]byte(doc))
+	if err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+	if strings.Contains(string(out), "plugin:\n") || strings.Count(string(out), "value:") != 2 {
+		t.Fatalf("Expected plugin term to be replaced:\n%s", out)
+	}
+	if h.Len() != 1 {
+		t.Fatalf("Expected 1 plugin condition, got %d", h.Len())
+	}
+
+	for marker := range h.markers {
+		if desc, ok := h.Expr(marker); !ok || desc != `contains {"contains":"card"}` {
+			t.Errorf("Unexpected description %q", desc)
+		}
+	}
+}
+
+func TestRewriteErrors(t *testing.T) {
+
+	tests := map[string]struct {
+		doc  string
+		want error
+	}{
+		"unknown": {
+			doc:  "match:\n  - plugin:\n      name: fraud\n",
+			want: ErrUnknownPlugin,
+		},
+		"no name": {
+			doc:  "match:\n  - plugin:\n      args:\n        contains: x\n",
+			want: ErrPluginTerm,
+		},
+		"mixed": {
+			doc:  "match:\n  - value: x\n    plugin:\n      name: contains\n",
+			want: ErrPluginMixed,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := testHost(t).Rewrite([]byte(tc.doc)); !errors.Is(err, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+// holds asks the plugins about line afresh, as for a new line.
+func holds(b *BoundT, line string) []string {
+	var replies RepliesT
+	return b.Holds(nil, &replies, "app", 1, line)
+}
+
+func TestBind(t *testing.T) {
+
+	h := testHost(t)
+
+	doc := "match:\n  - plugin:\n      name: contains\n      args:\n        contains: card\n"
+	if _, err := h.Rewrite([]byte(doc)); err != nil {
+		t.Fatal(err)
+	}
+	card := slices.Collect(maps.Keys(h.markers))
+
+	if b := h.Bind([]string{"\x1fcel:0\x1f"}); b != nil {
+		t.Errorf("Expected no plugin conditions to bind")
+	}
+
+	b := h.Bind(card)
+	if got := holds(b, "login ok"); len(got) != 0 {
+		t.Errorf("Expected no match, got %q", got)
+	}
+	if got := holds(b, "card declined"); !slices.Equal(got, card) {
+		t.Fatalf("Expected %q, got %q", card, got)
+	}
+
+	// Conditions loaded after the plugin started are sent to it, and a rule
+	// sees only the markers of its own conditions
+	doc = "match:\n  - plugin:\n      name: contains\n      args:\n        contains: declined\n"
+	if _, err := h.Rewrite([]byte(doc)); err != nil {
+		t.Fatal(err)
+	}
+	if got := holds(b, "card declined"); !slices.Equal(got, card) {
+		t.Errorf("Expected %q, got %q", card, got)
+	}
+	if got := holds(h.Bind(slices.Collect(maps.Keys(h.markers))), "card declined"); len(got) != 2 {
+		t.Errorf("Expected both conditions to match, got %q", got)
+	}
+
+	// Replies are shared by the rules that see the same line
+	var replies RepliesT
+	b.Holds(nil, &replies, "app", 2, "card")
+	if got := b.Holds(nil, &replies, "app", 2, "login ok"); !slices.Equal(got, card) {
+		t.Errorf("Expected the first reply to be reused, got %q", got)
+	}
+
+	// Replies may be longer than a default buffer
+	if got := holds(b, "big"); len(got) != 0 {
+		t.Errorf("Expected no match, got %q", got)
+	}
+	if got := holds(b, "card"); !slices.Equal(got, card) {
+		t.Errorf("Expected a long reply to be read, got %q", got)
+	}
+
+	// A plugin that dies matches nothing from then on
+	if got := holds(b, "crash"); len(got) != 0 {
+		t.Errorf("Expected no match, got %q", got)
+	}
+	if got := holds(b, "card declined"); len(got) != 0 {
+		t.Errorf("Expected a stopped plugin to match nothing, got %q", got)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+
+	h := testHostTimeout(t, 100*time.Millisecond)
+
+	doc := "match:\n  - plugin:\n      name: contains\n      args:\n        contains: card\n"
+	if _, err := h.Rewrite([]byte(doc)); err != nil {
+		t.Fatal(err)
+	}
+	b := h.Bind(slices.Collect(maps.Keys(h.markers)))
+
+	start := time.Now()
+	if got := holds(b, "slow"); len(got) != 0 {
+		t.Errorf("Expected no match, got %q", got)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("Expected the plugin to be killed, waited %s", d)
+	}
+	if got := holds(b, "card"); len(got) != 0 {
+		t.Errorf("Expected a stopped plugin to match nothing, got %q", got)
+	}
+}
+
+func TestNilHost(t *testing.T) {
+	var h *HostT
+
+	if h.Len() != 0 {
+		t.Errorf("Expected no conditions")
+	}
+	if b := h.Bind([]string{markerPrefix + "0" + markerSuffix}); b != nil {
+		t.Errorf("Expected no plugin conditions to bind")
+	}
+	if _, err := h.Rewrite([]byte("match:\n  - plugin:\n      name: contains\n")); !errors.Is(err, ErrUnknownPlugin) {
+		t.Errorf("Expected %v, got %v", ErrUnknownPlugin, err)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := New([]SpecT{{Name: "a"}}); err == nil {
+		t.Errorf("Expected a missing command to fail")
+	}
+	if _, err := New([]SpecT{{Name: "a", Command: "x"}, {Name: "a", Command: "y"}}); err == nil {
+		t.Errorf("Expected a duplicate name to fail")
+	}
+}
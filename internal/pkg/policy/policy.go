@@ -0,0 +1,221 @@
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+default: pass
+exit_codes:
+  warn: 0
+  fail: 2
+rules:
+  # The first rule that matches a detection decides its outcome
+  - outcome: pass
+    ids: [CRE-2025-0025]          # known and accepted
+  - outcome: fail
+    severity: high                # high or critical
+  - outcome: warn
+    categories: [memory-problems]
+  - outcome: fail
+    ids: ["CRE-2024-00*"]         # path.Match globs
+*/
+
+type OutcomeT string
+
+const (
+	OutcomePass OutcomeT = "pass"
+	OutcomeWarn OutcomeT = "warn"
+	OutcomeFail OutcomeT = "fail"
+)
+
+const (
+	DefaultFailExitCode = 2
+)
+
+var (
+	ErrInvalidOutcome = errors.New("invalid outcome")
+	ErrEmptyRule      = errors.New("rule matches nothing")
+)
+
+func (o OutcomeT) rank() int {
+	switch o {
+	case OutcomeWarn:
+		return 1
+	case OutcomeFail:
+		return 2
+	}
+	return 0
+}
+
+func (o OutcomeT) validate() error {
+	switch o {
+	case OutcomePass, OutcomeWarn, OutcomeFail:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidOutcome, o)
+}
+
+type PolicyT struct {
+	Default   OutcomeT   `yaml:"default"`
+	ExitCodes ExitCodesT `yaml:"exit_codes"`
+	Rules     []RuleT    `yaml:"rules"`
+}
+
+type ExitCodesT struct {
+	Warn int  `yaml:"warn"`
+	Fail *int `yaml:"fail"`
+}
+
+type RuleT struct {
+	Outcome    OutcomeT `yaml:"outcome"`
+	Ids        []string `yaml:"ids,omitempty"`
+	Categories []string `yaml:"categories,omitempty"`
+	Severity   string   `yaml:"severity,omitempty"`
+
+	severity *uint
+}
+
+// matches reports whether every criterion set on the rule holds for cre.
+func (r *RuleT) matches(cre parser.ParseCreT) bool {
+
+	if len(r.Ids) > 0 && !slices.ContainsFunc(r.Ids, func(pattern string) bool {
+		ok, _ := path.Match(pattern, cre.Id)
+		return ok
+	}) {
+		return false
+	}
+
+	if len(r.Categories) > 0 && !slices.Contains(r.Categories, cre.Category) {
+		return false
+	}
+
+	// Lower values are more severe
+	if r.severity != nil && cre.Severity > *r.severity {
+		return false
+	}
+
+	return true
+}
+
+// Parse reads a policy. Unknown keys are refused, so that a misspelled one
+// does not leave a rule silently matching more or less than meant.
+func Parse(data []byte) (*PolicyT, error) {
+	var p PolicyT
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if p.Default == "" {
+		p.Default = OutcomePass
+	}
+	if err := p.Default.validate(); err != nil {
+		return nil, fmt.Errorf("default: %w", err)
+	}
+
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if err := r.Outcome.validate(); err != nil {
+			return nil, fmt.Errorf("rule #%d: %w", i, err)
+		}
+		if len(r.Ids) == 0 && len(r.Categories) == 0 && r.Severity == "" {
+			return nil, fmt.Errorf("rule #%d: %w", i, ErrEmptyRule)
+		}
+		for _, id := range r.Ids {
+			if _, err := path.Match(id, ""); err != nil {
+				return nil, fmt.Errorf("rule #%d: invalid id pattern %q: %w", i, id, err)
+			}
+		}
+		if r.Severity != "" {
+			sev, err := ux.ParseSeverity(r.Severity)
+			if err != nil {
+				return nil, fmt.Errorf("rule #%d: %w", i, err)
+			}
+			r.severity = &sev
+		}
+	}
+
+	return &p, nil
+}
+
+func ParseFile(fn string) (*PolicyT, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// DecisionT is the outcome for a single detection.
+type DecisionT struct {
+	Cre     parser.ParseCreT
+	Outcome OutcomeT
+	Rule    int // index of the deciding rule, -1 for the default
+}
+
+type ResultT struct {
+	Outcome   OutcomeT
+	Decisions []DecisionT
+}
+
+// Evaluate decides each detection with the first matching rule and
+// returns the most severe outcome overall. No detections always pass.
+func (p *PolicyT) Evaluate(cres []parser.ParseCreT) ResultT {
+
+	res := ResultT{Outcome: OutcomePass}
+
+	for _, cre := range cres {
+		d := DecisionT{Cre: cre, Outcome: p.Default, Rule: -1}
+		for i := range p.Rules {
+			if p.Rules[i].matches(cre) {
+				d.Outcome, d.Rule = p.Rules[i].Outcome, i
+				break
+			}
+		}
+
+		if d.Outcome.rank() > res.Outcome.rank() {
+			res.Outcome = d.Outcome
+		}
+		res.Decisions = append(res.Decisions, d)
+	}
+
+	return res
+}
+
+// ExitCode maps an outcome to the process exit code.
+func (p *PolicyT) ExitCode(o OutcomeT) int {
+	switch o {
+	case OutcomeFail:
+		if p.ExitCodes.Fail != nil {
+			return *p.ExitCodes.Fail
+		}
+		return DefaultFailExitCode
+	case OutcomeWarn:
+		return p.ExitCodes.Warn
+	}
+	return 0
+}
+
+// Fprint writes a short summary of the decisions to w.
+func (r ResultT) Fprint(w io.Writer) {
+	fmt.Fprintf(w, "\nPolicy outcome: %s\n", r.Outcome)
+	for _, d := range r.Decisions {
+		rule := "default"
+		if d.Rule >= 0 {
+			rule = fmt.Sprintf("rule #%d", d.Rule)
+		}
+		fmt.Fprintf(w, "  %-20s %-8s %-4s (%s)\n", d.Cre.Id, ux.SeverityName(d.Cre.Severity), d.Outcome, rule)
+	}
+}
@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+)
+
+const testPolicy = `
+default: warn
+exit_codes:
+  warn: 3
+rules:
+  - outcome: pass
+    ids: [CRE-2025-0025]
+  - outcome: fail
+    severity: high
+  - outcome: pass
+    categories: [memory-problems]
+  - outcome: fail
+    ids: ["CRE-2024-00*"]
+`
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		cres    []parser.ParseCreT
+		outcome OutcomeT
+		rules   []int
+	}{
+		{name: "none", outcome: OutcomePass},
+		{
+			name:    "first match wins",
+			cres:    []parser.ParseCreT{{Id: "CRE-2025-0025", Severity: parser.SeverityCritical}},
+			outcome: OutcomePass,
+			rules:   []int{0},
+		},
+		{
+			name:    "severity threshold",
+			cres:    []parser.ParseCreT{{Id: "CRE-2025-0001", Severity: parser.SeverityCritical}},
+			outcome: OutcomeFail,
+			rules:   []int{1},
+		},
+		{
+			name:    "category",
+			cres:    []parser.ParseCreT{{Id: "CRE-2025-0002", Severity: parser.SeverityMedium, Category: "memory-problems"}},
+			outcome: OutcomePass,
+			rules:   []int{2},
+		},
+		{
+			name:    "glob",
+			cres:    []parser.ParseCreT{{Id: "CRE-2024-0007", Severity: parser.SeverityLow}},
+			outcome: OutcomeFail,
+			rules:   []int{3},
+		},
+		{
+			name: "worst wins",
+			cres: []parser.ParseCreT{
+				{Id: "CRE-2025-0003", Severity: parser.SeverityLow},
+				{Id: "CRE-2025-0025", Severity: parser.SeverityLow},
+			},
+			outcome: OutcomeWarn,
+			rules:   []int{-1, 0},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := p.Evaluate(tc.cres)
+			if res.Outcome != tc.outcome {
+				t.Errorf("outcome: got %s, want %s", res.Outcome, tc.outcome)
+			}
+			if len(res.Decisions) != len(tc.rules) {
+				t.Fatalf("decisions: got %d, want %d", len(res.Decisions), len(tc.rules))
+			}
+			for i, d := range res.Decisions {
+				if d.Rule != tc.rules[i] {
+					t.Errorf("decision %d: got rule %d, want %d", i, d.Rule, tc.rules[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if code := p.ExitCode(OutcomePass); code != 0 {
+		t.Errorf("pass: got %d", code)
+	}
+	if code := p.ExitCode(OutcomeWarn); code != 3 {
+		t.Errorf("warn: got %d", code)
+	}
+	if code := p.ExitCode(OutcomeFail); code != DefaultFailExitCode {
+		t.Errorf("fail: got %d", code)
+	}
+
+	p, err = Parse([]byte("exit_codes:\n  fail: 0\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if p.Default != OutcomePass {
+		t.Errorf("default: got %s", p.Default)
+	}
+	if code := p.ExitCode(OutcomeFail); code != 0 {
+		t.Errorf("explicit fail: got %d", code)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{name: "default", data: "default: explode\n", err: ErrInvalidOutcome},
+		{name: "outcome", data: "rules:\n  - outcome: maybe\n    ids: [x]\n", err: ErrInvalidOutcome},
+		{name: "empty", data: "rules:\n  - outcome: fail\n", err: ErrEmptyRule},
+		{name: "severity", data: "rules:\n  - outcome: fail\n    severity: dire\n", err: ux.ErrInvalidSeverity},
+		{name: "glob", data: "rules:\n  - outcome: fail\n    ids: [\"CRE-[\"]\n"},
+		{name: "unknown key", data: "rules:\n  - outcome: fail\n    severty: high\n    ids: [x]\n"},
+		{name: "unknown section", data: "exit_code:\n  fail: 3\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(tc.data))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
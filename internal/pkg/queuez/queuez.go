@@ -0,0 +1,187 @@
+package queuez
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// A QueueT is a bounded hand-off between two stages of a long running
+// process, so that a stalled consumer holds its producer back, or has
+// items dropped, instead of growing memory without bound. What happens
+// when the queue is full is the queue's policy:
+//
+//	block        the producer waits for room (backpressure, the default)
+//	drop-newest  the item pushed is dropped
+//	drop-oldest  the oldest queued item is dropped to make room
+//
+// Dropped items are counted and logged at most once per dropLogEvery.
+
+type PolicyT int
+
+const (
+	PolicyBlock PolicyT = iota
+	PolicyDropNewest
+	PolicyDropOldest
+)
+
+const (
+	DefaultSize  = 4096
+	dropLogEvery = 10 * time.Second
+)
+
+var (
+	ErrPolicy = errors.New("invalid queue policy, want block, drop-newest or drop-oldest")
+)
+
+var policyNames = map[PolicyT]string{
+	PolicyBlock:      "block",
+	PolicyDropNewest: "drop-newest",
+	PolicyDropOldest: "drop-oldest",
+}
+
+func (p PolicyT) String() string {
+	return policyNames[p]
+}
+
+// ParsePolicy reads a policy name. The empty name blocks.
+func ParsePolicy(s string) (PolicyT, error) {
+	if s == "" {
+		return PolicyBlock, nil
+	}
+	for p, name := range policyNames {
+		if name == s {
+			return p, nil
+		}
+	}
+	return PolicyBlock, fmt.Errorf("%w: %q", ErrPolicy, s)
+}
+
+// StatsT counts what went through a queue.
+type StatsT struct {
+	Name    string        `json:"name"`
+	Cap     int           `json:"cap"`
+	Pushed  int64         `json:"pushed"`
+	Dropped int64         `json:"dropped"`
+	Blocked time.Duration `json:"blocked"` // time producers waited for room
+}
+
+type QueueT[T any] struct {
+	name    string
+	policy  PolicyT
+	ch      chan T
+	pushed  atomic.Int64
+	dropped atomic.Int64
+	blocked atomic.Int64
+	mux     sync.Mutex
+	logged  time.Time
+	once    sync.Once
+}
+
+// New makes a queue of size items; a size below one uses DefaultSize.
+func New[T any](name string, size int, policy PolicyT) *QueueT[T] {
+	if size < 1 {
+		size = DefaultSize
+	}
+	return &QueueT[T]{
+		name:   name,
+		policy: policy,
+		ch:     make(chan T, size),
+	}
+}
+
+// Push queues v according to the policy. It returns false if v, or an
+// older item in its place, was dropped.
+func (q *QueueT[T]) Push(v T) bool {
+	return q.PushCtx(context.Background(), v)
+}
+
+// PushCtx is Push that stops waiting for room once ctx is done; v is then
+// dropped.
+func (q *QueueT[T]) PushCtx(ctx context.Context, v T) bool {
+
+	q.pushed.Add(1)
+
+	select {
+	case q.ch <- v:
+		return true
+	default:
+	}
+
+	switch q.policy {
+	case PolicyDropNewest:
+		q.drop()
+		return false
+
+	case PolicyDropOldest:
+		for {
+			select {
+			case <-q.ch:
+				q.drop()
+			default:
+			}
+			select {
+			case q.ch <- v:
+				return false
+			default:
+			}
+		}
+	}
+
+	start := time.Now()
+	defer func() { q.blocked.Add(int64(time.Since(start))) }()
+
+	select {
+	case q.ch <- v:
+		return true
+	case <-ctx.Done():
+		q.drop()
+		return false
+	}
+}
+
+func (q *QueueT[T]) drop() {
+
+	n := q.dropped.Add(1)
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if time.Since(q.logged) < dropLogEvery {
+		return
+	}
+	q.logged = time.Now()
+
+	log.Warn().
+		Str("queue", q.name).
+		Int("cap", cap(q.ch)).
+		Str("policy", q.policy.String()).
+		Int64("dropped", n).
+		Msg("Queue full; dropping")
+}
+
+// C returns the channel to consume the queue from. It is closed by Close.
+func (q *QueueT[T]) C() <-chan T {
+	return q.ch
+}
+
+// Close stops the queue; items already queued can still be consumed. Push
+// must not be called after Close.
+func (q *QueueT[T]) Close() {
+	q.once.Do(func() { close(q.ch) })
+}
+
+func (q *QueueT[T]) Stats() StatsT {
+	return StatsT{
+		Name:    q.name,
+		Cap:     cap(q.ch),
+		Pushed:  q.pushed.Load(),
+		Dropped: q.dropped.Load(),
+		Blocked: time.Duration(q.blocked.Load()),
+	}
+}
@@ -0,0 +1,113 @@
+package queuez
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPolicies(t *testing.T) {
+
+	drain := func(q *QueueT[int]) []int {
+		q.Close()
+		var out []int
+		for v := range q.C() {
+			out = append(out, v)
+		}
+		return out
+	}
+
+	q := New[int]("newest", 2, PolicyDropNewest)
+	for i := range 4 {
+		q.Push(i)
+	}
+	if got := drain(q); !slices.Equal(got, []int{0, 1}) {
+		t.Errorf("drop-newest: expected [0 1], got %v", got)
+	}
+	if st := q.Stats(); st.Pushed != 4 || st.Dropped != 2 {
+		t.Errorf("drop-newest: unexpected stats %+v", st)
+	}
+
+	q = New[int]("oldest", 2, PolicyDropOldest)
+	for i := range 4 {
+		q.Push(i)
+	}
+	if got := drain(q); !slices.Equal(got, []int{2, 3}) {
+		t.Errorf("drop-oldest: expected [2 3], got %v", got)
+	}
+	if st := q.Stats(); st.Dropped != 2 {
+		t.Errorf("drop-oldest: expected 2 dropped, got %d", st.Dropped)
+	}
+}
+
+func TestBlock(t *testing.T) {
+
+	q := New[int]("block", 1, PolicyBlock)
+	q.Push(0)
+
+	pushed := make(chan struct{})
+	go func() {
+		q.Push(1)
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("Expected push to block while the queue is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if v := <-q.C(); v != 0 {
+		t.Errorf("Expected 0, got %d", v)
+	}
+	<-pushed
+
+	if st := q.Stats(); st.Dropped != 0 || st.Blocked <= 0 {
+		t.Errorf("Expected blocked time and nothing dropped, got %+v", st)
+	}
+}
+
+func TestBlockCancel(t *testing.T) {
+
+	q := New[int]("block", 1, PolicyBlock)
+	q.Push(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pushed := make(chan bool)
+	go func() {
+		pushed <- q.PushCtx(ctx, 1)
+	}()
+
+	cancel()
+	select {
+	case ok := <-pushed:
+		if ok {
+			t.Error("Expected push to be dropped once canceled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected push to return once canceled")
+	}
+
+	if st := q.Stats(); st.Dropped != 1 {
+		t.Errorf("Expected 1 dropped, got %+v", st)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for s, want := range map[string]PolicyT{
+		"":            PolicyBlock,
+		"block":       PolicyBlock,
+		"drop-newest": PolicyDropNewest,
+		"drop-oldest": PolicyDropOldest,
+	} {
+		if p, err := ParsePolicy(s); err != nil || p != want {
+			t.Errorf("%q: expected %v, got %v (%v)", s, want, p, err)
+		}
+	}
+
+	if _, err := ParsePolicy("drop"); !errors.Is(err, ErrPolicy) {
+		t.Errorf("Expected %v, got %v", ErrPolicy, err)
+	}
+}
@@ -0,0 +1,393 @@
+package ratez
+
+// A rule condition may fire on a rise in how often a term occurs rather than
+// on the term itself:
+//
+//	match:
+//	  - value: 'connection reset by peer'
+//	    rate:
+//	      increase: 50%
+//	      min: 5
+//
+// The condition holds on the occurrence that brings the count over the last
+// window to at least min and at least increase percent above the count over
+// the window before it. It holds again only after the rate has dropped back.
+// The window is the rate's own or else that of the enclosing set or
+// sequence. A plain threshold, N occurrences within the window, is a term
+// with a count and needs no rate.
+//
+// As with cel conditions, each rate is swapped for a raw term on a marker
+// when the rules are read. The matchers of a rule on a source count its
+// rates over the lines they see, and the marker of a rate is appended to
+// the line on which it holds for them alone.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	lm "github.com/prequel-dev/prequel-logmatch/pkg/match"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	keyRate      = "rate"
+	keyWindow    = "window"
+	markerPrefix = utils.MarkerSep + "rate:"
+	markerSuffix = utils.MarkerSep
+)
+
+var (
+	ErrRateTerm     = errors.New("rate needs exactly one of value, regex or jq")
+	ErrRateWindow   = errors.New("rate needs a window")
+	ErrRateIncrease = errors.New("rate increase must be a positive percentage")
+	ErrRateMin      = errors.New("rate min must be positive")
+	ErrRateKey      = errors.New("unknown rate key")
+)
+
+type specT struct {
+	term     lm.TermT
+	window   int64
+	increase float64
+	min      int
+	desc     string
+}
+
+// RatesT holds the rate conditions of the rules read so far. A nil *RatesT
+// has none.
+type RatesT struct {
+	mux   sync.RWMutex
+	specs map[string]*specT // by marker
+}
+
+func New() *RatesT {
+	return &RatesT{
+		specs: make(map[string]*specT),
+	}
+}
+
+// Len returns the number of distinct rate conditions.
+func (r *RatesT) Len() int {
+	if r == nil {
+		return 0
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return len(r.specs)
+}
+
+// Rewrite replaces each rate condition in a rules document with a raw term
+// on its marker. Documents without rate conditions are returned as they are.
+func (r *RatesT) Rewrite(data []byte) ([]byte, error) {
+
+	if !bytes.Contains(data, []byte(keyRate)) {
+		return data, nil
+	}
+
+	return utils.RewriteDocs(data, func(doc *yaml.Node) (bool, error) {
+		n, err := r.rewriteNode(doc, 0)
+		return n > 0, err
+	})
+}
+
+// rewriteNode rewrites the rate conditions under n; window is that of the
+// nearest enclosing set or sequence.
+func (r *RatesT) rewriteNode(n *yaml.Node, window int64) (int, error) {
+
+	var count int
+
+	if n.Kind == yaml.MappingNode {
+
+		if v := mapValue(n, keyWindow); v != nil && v.Kind == yaml.ScalarNode {
+			if d, err := time.ParseDuration(v.Value); err == nil {
+				window = d.Nanoseconds()
+			}
+		}
+
+		if v := mapValue(n, keyRate); v != nil && v.Kind == yaml.MappingNode {
+			if err := r.rewriteTerm(n, v, window); err != nil {
+				return 0, fmt.Errorf("line %d: %w", n.Line, err)
+			}
+			return 1, nil
+		}
+	}
+
+	for _, c := range n.Content {
+		nc, err := r.rewriteNode(c, window)
+		if err != nil {
+			return 0, err
+		}
+		count += nc
+	}
+
+	return count, nil
+}
+
+// rewriteTerm swaps the term mapping n, which holds the rate mapping v, for
+// a raw term on the rate's marker.
+func (r *RatesT) rewriteTerm(n, v *yaml.Node, window int64) error {
+
+	spec := &specT{min: 1}
+
+	for i := 0; i+1 < len(v.Content); i += 2 {
+		var (
+			key = v.Content[i].Value
+			val = v.Content[i+1].Value
+			err error
+		)
+		switch key {
+		case "increase":
+			spec.increase, err = strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64)
+			if err != nil || spec.increase <= 0 {
+				return fmt.Errorf("%w: %q", ErrRateIncrease, val)
+			}
+		case "min":
+			spec.min, err = strconv.Atoi(val)
+			if err != nil || spec.min <= 0 {
+				return fmt.Errorf("%w: %q", ErrRateMin, val)
+			}
+		case keyWindow:
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrRateWindow, err)
+			}
+			window = d.Nanoseconds()
+		default:
+			return fmt.Errorf("%w: %q", ErrRateKey, key)
+		}
+	}
+
+	if spec.increase <= 0 {
+		return ErrRateIncrease
+	}
+	if window <= 0 {
+		return ErrRateWindow
+	}
+	spec.window = window
+
+	var (
+		content = make([]*yaml.Node, 0, len(n.Content))
+		terms   int
+	)
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		var (
+			key = n.Content[i]
+			val = n.Content[i+1]
+		)
+		switch key.Value {
+		case "value":
+			spec.term, terms = lm.TermT{Type: lm.TermRaw, Value: val.Value}, terms+1
+		case "regex":
+			spec.term, terms = lm.TermT{Type: lm.TermRegex, Value: val.Value}, terms+1
+		case "jq":
+			spec.term, terms = lm.TermT{Type: lm.TermJqJson, Value: val.Value}, terms+1
+		case keyRate:
+		default:
+			content = append(content, key, val)
+			continue
+		}
+	}
+
+	if terms != 1 {
+		return ErrRateTerm
+	}
+
+	if _, err := spec.term.NewMatcher(); err != nil {
+		return err
+	}
+
+	spec.desc = fmt.Sprintf("%s %q up %g%% over %s", spec.term.Type, spec.term.Value, spec.increase, time.Duration(spec.window))
+	if spec.min > 1 {
+		spec.desc += fmt.Sprintf(" min %d", spec.min)
+	}
+
+	marker := r.add(spec)
+
+	n.Content = append(content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "value"},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: marker, Style: yaml.DoubleQuotedStyle},
+	)
+
+	return nil
+}
+
+func mapValue(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// add registers spec and returns its marker.
+func (r *RatesT) add(spec *specT) string {
+
+	key := fmt.Sprintf("%d\x00%s\x00%d\x00%g\x00%d", spec.term.Type, spec.term.Value, spec.window, spec.increase, spec.min)
+	sum := sha256.Sum256([]byte(key))
+	marker := markerPrefix + utils.MarkerNonce + ":" + hex.EncodeToString(sum[:6]) + markerSuffix
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if _, ok := r.specs[marker]; !ok {
+		r.specs[marker] = spec
+	}
+
+	return marker
+}
+
+// Expr describes the rate a raw term value stands for, if it is a marker.
+func (r *RatesT) Expr(value string) (string, bool) {
+	if r == nil || !strings.HasPrefix(value, markerPrefix) {
+		return "", false
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	spec, ok := r.specs[value]
+	if !ok {
+		return "", false
+	}
+	return spec.desc, true
+}
+
+// IsMarker reports whether a raw term value stands for a rate.
+func IsMarker(value string) bool {
+	return strings.HasPrefix(value, markerPrefix)
+}
+
+// Retain drops the rates whose markers keep does not hold, such as those
+// of rules a reload removed.
+func (r *RatesT) Retain(keep func(marker string) bool) {
+	if r == nil {
+		return
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for marker := range r.specs {
+		if !keep(marker) {
+			delete(r.specs, marker)
+		}
+	}
+}
+
+// CounterT counts the rates of one rule over one source, whose lines arrive
+// in time order. It is not safe for concurrent use.
+type CounterT struct {
+	first  int64
+	states []*stateT
+}
+
+type stateT struct {
+	marker string
+	spec   *specT
+	match  lm.MatchFunc
+	ts     []int64 // occurrences over the last two windows
+	held   bool
+}
+
+// Bind returns a counter of the rates among markers, or nil if there are
+// none.
+func (r *RatesT) Bind(markers []string) *CounterT {
+	if r == nil {
+		return nil
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	c := &CounterT{first: -1}
+	for _, marker := range markers {
+
+		spec, ok := r.specs[marker]
+		if !ok || slices.ContainsFunc(c.states, func(st *stateT) bool { return st.marker == marker }) {
+			continue
+		}
+
+		m, err := spec.term.NewMatcher()
+		if err != nil {
+			continue
+		}
+		c.states = append(c.states, &stateT{marker: marker, spec: spec, match: m})
+	}
+
+	if len(c.states) == 0 {
+		return nil
+	}
+	return c
+}
+
+// Holds counts the occurrences in line and appends to dst the markers of
+// the rates that come to hold on it. A rate cannot hold until the source
+// has covered two windows, since until then there is no earlier window to
+// compare to.
+func (c *CounterT) Holds(dst []string, ts int64, line string) []string {
+
+	if c.first < 0 {
+		c.first = ts
+	}
+
+	for _, st := range c.states {
+
+		if !st.match(line) {
+			continue
+		}
+
+		st.ts = append(st.ts, ts)
+
+		var (
+			mark = ts - 2*st.spec.window
+			i    int
+		)
+		for i < len(st.ts) && st.ts[i] <= mark {
+			i++
+		}
+		st.ts = st.ts[i:]
+
+		holds := c.first <= mark && st.spec.holds(ts, st.ts)
+		if holds && !st.held {
+			dst = append(dst, st.marker)
+		}
+		st.held = holds
+	}
+
+	return dst
+}
+
+// holds compares the occurrences in the window ending at ts with those in
+// the window before it.
+func (s *specT) holds(ts int64, occurrences []int64) bool {
+
+	var (
+		mark = ts - s.window
+		cur  int
+	)
+	for _, t := range occurrences {
+		if t > mark {
+			cur++
+		}
+	}
+	prev := len(occurrences) - cur
+
+	return cur >= s.min && cur > prev && float64(cur)*100 >= float64(prev)*(100+s.increase)
+}
+
+// Strip removes the markers appended to line.
+func Strip(line string) string {
+	before, _, _ := strings.Cut(line, " "+markerPrefix)
+	return before
+}
@@ -0,0 +1,146 @@
+package ratez
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRewrite(t *testing.T) {
+
+	r := New()
+
+	doc := `rules:
+  - rule:
+      set:
+        window: 30s
+        match:
+          - regex: 'reset by (peer|host)'
+            rate:
+              increase: 50%
+          - value: "timeout"
+`
+
+	out, err := r.Rewrite([]byte(doc))
+	if err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+	if strings.Contains(string(out), "increase") || strings.Contains(string(out), "regex:") || strings.Count(string(out), "value:") != 2 {
+		t.Fatalf("Expected rate term to be replaced:\n%s", out)
+	}
+	if r.Len() != 1 {
+		t.Fatalf("Expected 1 rate, got %d", r.Len())
+	}
+
+	for marker := range r.specs {
+		desc, ok := r.Expr(marker)
+		if !ok || !strings.Contains(desc, "over 30s") {
+			t.Errorf("Expected the enclosing window, got %q", desc)
+		}
+	}
+
+	// Documents without rate conditions are untouched
+	plain := []byte("rules:\n  - rule:\n      set:\n        match:\n          - value: cancelled\n")
+	if out, err = r.Rewrite(plain); err != nil || string(out) != string(plain) {
+		t.Errorf("Expected document unchanged, got %q: %v", out, err)
+	}
+}
+
+func TestRewriteErrors(t *testing.T) {
+
+	tests := map[string]struct {
+		doc  string
+		want error
+	}{
+		"no window": {
+			doc:  "match:\n  - value: x\n    rate:\n      increase: 50\n",
+			want: ErrRateWindow,
+		},
+		"no increase": {
+			doc:  "match:\n  - value: x\n    rate:\n      window: 1m\n",
+			want: ErrRateIncrease,
+		},
+		"no term": {
+			doc:  "match:\n  - rate:\n      increase: 50\n      window: 1m\n",
+			want: ErrRateTerm,
+		},
+		"bad min": {
+			doc:  "match:\n  - value: x\n    rate:\n      increase: 50\n      window: 1m\n      min: 0\n",
+			want: ErrRateMin,
+		},
+		"unknown key": {
+			doc:  "match:\n  - value: x\n    rate:\n      increase: 50\n      window: 1m\n      max: 3\n",
+			want: ErrRateKey,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := New().Rewrite([]byte(tc.doc)); !errors.Is(err, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestCounter(t *testing.T) {
+
+	r := New()
+
+	doc := "match:\n  - value: reset\n    rate:\n      increase: 100%\n      min: 2\n      window: 10s\n"
+	if _, err := r.Rewrite([]byte(doc)); err != nil {
+		t.Fatal(err)
+	}
+
+	markers := slices.Collect(maps.Keys(r.specs))
+	if len(markers) != 1 {
+		t.Fatalf("Expected one rate, got %v", markers)
+	}
+
+	var (
+		c     = r.Bind(markers)
+		sec   = int64(time.Second)
+		fired []int64
+	)
+
+	// One reset every 10s, then a burst of four, then back to one
+	for _, ts := range []int64{0, 10, 20, 30, 33, 35, 37, 39, 50, 60, 70} {
+		if held := c.Holds(nil, ts*sec, "reset"); len(held) > 0 {
+			if line := "reset " + held[0]; Strip(line) != "reset" {
+				t.Fatalf("Expected markers stripped, got %q", Strip(line))
+			}
+			fired = append(fired, ts)
+		}
+	}
+
+	if len(fired) != 1 || fired[0] != 33 {
+		t.Errorf("Expected the rate to hold once at 33s, got %v", fired)
+	}
+
+	// Each rule counts on its own
+	if held := r.Bind(markers).Holds(nil, 40*sec, "reset"); len(held) != 0 {
+		t.Errorf("Expected a new counter to start over, got %q", held)
+	}
+
+	r.Retain(func(string) bool { return false })
+	if r.Len() != 0 || r.Bind(markers) != nil {
+		t.Errorf("Expected the rate dropped")
+	}
+}
+
+func TestNilRates(t *testing.T) {
+	var r *RatesT
+
+	if r.Len() != 0 {
+		t.Errorf("Expected no rates")
+	}
+	if c := r.Bind([]string{markerPrefix + "0" + markerSuffix}); c != nil {
+		t.Errorf("Expected no counter, got %v", c)
+	}
+	if _, ok := r.Expr(markerPrefix + "0" + markerSuffix); ok {
+		t.Errorf("Expected no expression")
+	}
+}
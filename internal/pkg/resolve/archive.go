@@ -0,0 +1,297 @@
+package resolve
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+	"github.com/rs/zerolog/log"
+)
+
+// Tar archives such as vendor support bundles are expanded into one log per
+// member file. Each member gets its own timestamp detection; members that
+// are not recognizable logs (configs, binaries) are skipped. The members of
+// a plain tar are read in place. Those of a compressed one are extracted to
+// temporary files, removed once scanned or when resolving is cancelled, up
+// to archiveMaxMember bytes a member and archiveMaxTotal an archive; larger
+// members, and those past the total, are skipped.
+
+var (
+	ErrEmptyArchive = errors.New("no logs found in archive")
+	ErrMemberSize   = errors.New("archive member too large to extract")
+)
+
+var (
+	archiveMaxMember int64 = 1 << 30
+	archiveMaxTotal  int64 = 4 << 30
+)
+
+var archiveSuffixes = map[string]string{
+	".tar":     utils.CompressNone,
+	".tar.gz":  utils.CompressGzip,
+	".tgz":     utils.CompressGzip,
+	".tar.bz2": utils.CompressBzip2,
+	".tbz2":    utils.CompressBzip2,
+	".tar.xz":  utils.CompressXz,
+	".txz":     utils.CompressXz,
+}
+
+// archiveCompression reports whether fn names a tar archive and how it is
+// compressed.
+func archiveCompression(fn string) (string, bool) {
+	lower := strings.ToLower(fn)
+	for suffix, compression := range archiveSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return compression, true
+		}
+	}
+	return "", false
+}
+
+func IsArchive(fn string) bool {
+	_, ok := archiveCompression(fn)
+	return ok
+}
+
+// ResolveArchive resolves every log in a tar archive as a single source
+// that matches all rules, like stdin.
+func ResolveArchive(fn string, opts ...OptT) ([]*LogData, error) {
+	slogs, err := resolveLog(datasrc.Location{Path: fn}, nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return []*LogData{NewLogData(slogs, filepath.Base(fn), "*")}, nil
+}
+
+func resolveArchive(fn string, opts ...OptT) ([]*logSrc, error) {
+
+	compression, _ := archiveCompression(fn)
+
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	rd, err := utils.Decompress(compression, fh)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ctx       = parseOpts(opts...).ctx
+		tr        = tar.NewReader(rd)
+		inPlace   = compression == utils.CompressNone
+		extracted int64
+		resolved  []*logSrc
+	)
+
+	for {
+		hdr, err := tr.Next()
+		switch {
+		case err == io.EOF:
+			if len(resolved) == 0 {
+				return nil, fmt.Errorf("%w: %s", ErrEmptyArchive, fn)
+			}
+			return resolved, nil
+		case err != nil:
+			closeAll(resolved)
+			return nil, err
+		}
+
+		if err := ctx.Err(); err != nil {
+			closeAll(resolved)
+			return nil, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+			continue
+		}
+
+		var lsrc *logSrc
+		switch {
+		case inPlace && !isEvtx(hdr.Name) && !sparse(hdr):
+			var off int64
+			if off, err = fh.Seek(0, io.SeekCurrent); err == nil {
+				lsrc, err = memberSrc(fn, off, hdr, opts...)
+			}
+		case hdr.Size > archiveMaxMember || extracted+hdr.Size > archiveMaxTotal:
+			err = fmt.Errorf("%w: %d bytes, %d extracted", ErrMemberSize, hdr.Size, extracted)
+		default:
+			extracted += hdr.Size
+			lsrc, err = extractMember(ctx, tr, hdr.Name, hdr.ModTime, opts...)
+		}
+
+		if ctx.Err() != nil {
+			if lsrc != nil {
+				lsrc.Close()
+			}
+			closeAll(resolved)
+			return nil, ctx.Err()
+		}
+
+		if err != nil {
+			log.Info().
+				Err(err).
+				Str("archive", fn).
+				Str("member", hdr.Name).
+				Msg("Skipping archive member")
+			continue
+		}
+
+		lsrc.name = fn + ":" + hdr.Name
+		lsrc.member = true
+
+		log.Info().
+			Str("path", lsrc.name).
+			Str("format", lsrc.factory.String()).
+			Int64("ts", lsrc.ts).
+			Msg("Resolved log")
+
+		resolved = append(resolved, lsrc)
+	}
+}
+
+// memberSrc reads the member of hdr in place, at off in the plain tar fn.
+func memberSrc(fn string, off int64, hdr *tar.Header, opts ...OptT) (src *logSrc, err error) {
+
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			fh.Close()
+		}
+	}()
+
+	o := parseOpts(opts...)
+	if o.yearRef.IsZero() && !hdr.ModTime.IsZero() {
+		opts = append(opts, withYearRef(hdr.ModTime))
+		o = parseOpts(opts...)
+	}
+
+	// The suffix of the member tells whether it is compressed
+	open := func() (io.Reader, error) {
+		rd, err := newReader(hdr.Name, io.NewSectionReader(fh, off, hdr.Size))
+		if err != nil {
+			return nil, err
+		}
+		return o.tokenizer.apply(rd)
+	}
+
+	rd, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := make([]byte, detectSampleSize)
+	n, err := io.ReadFull(rd, buffer)
+	switch err {
+	case nil, io.ErrUnexpectedEOF:
+	default:
+		return nil, err
+	}
+
+	factory, ts, err := NewLogFactory(buffer[:n], opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if rd, err = open(); err != nil {
+		return nil, err
+	}
+
+	sz := int64(-1)
+	if !isCompressed(hdr.Name) && o.tokenizer.IsZero() && o.srcRange.IsZero() && o.begin == 0 {
+		sz = hdr.Size
+	}
+
+	rd, cri, fold, err := frame(rd, nil, factory, o)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logSrc{
+		sz:      sz,
+		ts:      ts,
+		fh:      fh,
+		rd:      rd,
+		factory: factory,
+		window:  o.window,
+		fold:    fold,
+		cri:     cri,
+	}, nil
+}
+
+// sparse reports whether a member has holes, which only the tar reader
+// fills in.
+func sparse(hdr *tar.Header) bool {
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// extractMember copies a member to a temporary file, keeping its suffix so
+// compressed members are still recognized, and its modification time so
+// missing years are inferred as for the original file. The copy stops when
+// ctx is done.
+func extractMember(ctx context.Context, rd io.Reader, name string, mtime time.Time, opts ...OptT) (*logSrc, error) {
+
+	tmp, err := os.CreateTemp("", "preq-archive-*-"+path.Base(name))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(tmp, ctxReader{ctx: ctx, rd: rd})
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !mtime.IsZero() {
+		err = os.Chtimes(tmp.Name(), mtime, mtime)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	lsrc, err := newLogSrc(tmp.Name(), opts...)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	lsrc.tmp = true
+	return lsrc, nil
+}
+
+// ctxReader stops reading once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	rd  io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.rd.Read(p)
+}
+
+func closeAll(srcs []*logSrc) {
+	for _, src := range srcs {
+		src.Close()
+	}
+}
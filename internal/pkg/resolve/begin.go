@@ -0,0 +1,156 @@
+package resolve
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"time"
+
+	"github.com/prequel-dev/prequel-logmatch/pkg/format"
+)
+
+// A begin time drops the lines of a log written before it. Plain files are
+// binary searched by seeking, on the assumption that lines are mostly in
+// time order, so the skipped part of a large file is never read. The
+// remainder, and any stream that cannot seek, is filtered line by line up
+// to the first line at or after the begin time.
+
+const (
+	beginSeekMin     = 64 * 1024 // bytes left to scan linearly
+	beginProbeWindow = 256 * 1024
+	beginProbeLines  = 50
+)
+
+// ParseTime accepts RFC3339, "now", or a duration meaning that long
+// before now. "24h" and "-24h" are equivalent; on the command line the
+// latter must be attached to its flag, as in --begin=-24h, or it reads as
+// a flag of its own.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	return parseTimeBound(s, now, time.Time{})
+}
+
+// seekBegin narrows rd to the lines at or after begin. If fh is non-nil,
+// rd must be positioned at the start of fh.
+func seekBegin(rd io.Reader, fh *os.File, factory format.FactoryI, begin int64) (io.Reader, error) {
+
+	if fh != nil {
+		off, err := searchBegin(fh, factory.New(), begin)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fh.Seek(off, io.SeekStart); err != nil {
+			return nil, err
+		}
+		rd = fh
+	}
+
+	return &beginRdr{
+		br:     bufio.NewReader(rd),
+		parser: factory.New(),
+		begin:  begin,
+		skip:   true,
+	}, nil
+}
+
+// searchBegin returns a line offset at or before the first line at or
+// after begin, within beginSeekMin bytes of it.
+func searchBegin(fh *os.File, parser format.ParserI, begin int64) (int64, error) {
+
+	info, err := fh.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var lo, hi int64 = 0, info.Size()
+
+	for hi-lo > beginSeekMin {
+		mid := lo + (hi-lo)/2
+
+		off, ts, ok, err := probeTs(fh, parser, mid)
+		if err != nil {
+			return 0, err
+		}
+
+		if ok && ts < begin && off < hi {
+			lo = off
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo, nil
+}
+
+// probeTs finds the first line starting after off that has a timestamp.
+func probeTs(fh *os.File, parser format.ParserI, off int64) (int64, int64, bool, error) {
+
+	var (
+		br  = bufio.NewReader(io.NewSectionReader(fh, off, beginProbeWindow))
+		pos = off
+	)
+
+	// Skip the line off falls in
+	line, err := br.ReadBytes('\n')
+	pos += int64(len(line))
+	if err != nil {
+		return 0, 0, false, ignoreEOF(err)
+	}
+
+	for range beginProbeLines {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			// A partial line at the end of the window cannot be trusted
+			return 0, 0, false, ignoreEOF(err)
+		}
+		if entry, perr := parser.ReadEntry(bytes.TrimRight(line, "\r\n")); perr == nil {
+			return pos, entry.Timestamp, true, nil
+		}
+		pos += int64(len(line))
+	}
+
+	return 0, 0, false, nil
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// beginRdr drops lines until the first one stamped at or after begin.
+// Lines without a timestamp are dropped along with the entry they belong
+// to.
+type beginRdr struct {
+	br      *bufio.Reader
+	parser  format.ParserI
+	begin   int64
+	skip    bool
+	pending []byte
+}
+
+func (b *beginRdr) Read(p []byte) (int, error) {
+
+	for b.skip {
+		line, err := b.br.ReadBytes('\n')
+		if len(line) > 0 {
+			entry, perr := b.parser.ReadEntry(bytes.TrimRight(line, "\r\n"))
+			if perr == nil && entry.Timestamp >= b.begin {
+				b.skip, b.pending = false, line
+				break
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(b.pending) > 0 {
+		n := copy(p, b.pending)
+		b.pending = b.pending[n:]
+		return n, nil
+	}
+
+	return b.br.Read(p)
+}
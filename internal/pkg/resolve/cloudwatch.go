@@ -0,0 +1,185 @@
+package resolve
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+)
+
+// A CloudWatch location names the log group in path, with optional query
+// parameters. Credentials come from the standard AWS SDK chain.
+//
+//	locations:
+//	  - type: cloudwatch
+//	    path: /aws/eks/prod/cluster?stream_prefix=kube-apiserver&start=2h&region=us-east-1
+//
+// Parameters: stream_prefix, filter (CloudWatch filter pattern), start and
+// end (RFC3339, "now" or a duration before now; default the last hour),
+// region and profile.
+
+const (
+	locationCloudWatch = "cloudwatch"
+	defaultLookback    = time.Hour
+)
+
+type cloudWatchAPI interface {
+	FilterLogEvents(ctx context.Context, params *cloudwatchlogs.FilterLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error)
+}
+
+type cloudWatchSpec struct {
+	group        string
+	streamPrefix string
+	keep         func(stream string) bool // nil keeps every stream
+	filter       string
+	region       string
+	profile      string
+	start        time.Time
+	end          time.Time
+}
+
+func parseCloudWatch(path string, now time.Time) (*cloudWatchSpec, error) {
+
+	group, query, _ := strings.Cut(path, "?")
+	if group == "" {
+		return nil, ErrMissingLogGroup
+	}
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &cloudWatchSpec{
+		group:        group,
+		streamPrefix: params.Get("stream_prefix"),
+		filter:       params.Get("filter"),
+		region:       params.Get("region"),
+		profile:      params.Get("profile"),
+	}
+
+	if spec.start, err = parseTimeBound(params.Get("start"), now, now.Add(-defaultLookback)); err != nil {
+		return nil, err
+	}
+	if spec.end, err = parseTimeBound(params.Get("end"), now, now); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// newCloudWatchClient is a variable so tests can substitute a fake.
+var newCloudWatchClient = func(ctx context.Context, spec *cloudWatchSpec) (cloudWatchAPI, error) {
+
+	var opts []func(*config.LoadOptions) error
+	if spec.region != "" {
+		opts = append(opts, config.WithRegion(spec.region))
+	}
+	if spec.profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(spec.profile))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The SDK builds its own client; guard it so --offline refuses it too
+	return cloudwatchlogs.NewFromConfig(cfg, func(o *cloudwatchlogs.Options) {
+		o.HTTPClient = httpz.Guard(locationCloudWatch, o.HTTPClient)
+	}), nil
+}
+
+func resolveCloudWatch(location datasrc.Location, opts ...OptT) ([]LogSrcI, error) {
+
+	spec, err := parseCloudWatch(location.Path, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newCloudWatchClient(context.Background(), spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if location.Window != 0 {
+		opts = append(opts, WithWindow(int64(location.Window)))
+	}
+
+	follow := parseOpts(opts...).follow != nil
+	src := newRemoteSrc(locationCloudWatch+":"+spec.group, cloudWatchFetch(client, spec, follow), opts...)
+
+	return []LogSrcI{src}, nil
+}
+
+// cloudWatchFetch pages through FilterLogEvents. In follow mode the end of
+// the range is open and, once caught up, it polls for events newer than the
+// last one seen.
+func cloudWatchFetch(client cloudWatchAPI, spec *cloudWatchSpec, follow bool) fetchT {
+
+	var (
+		token *string
+		from  = spec.start.UnixMilli()
+		last  = from - 1
+		done  bool
+	)
+
+	return func(ctx context.Context) ([]eventT, error) {
+
+		if done {
+			if !follow {
+				return nil, io.EOF
+			}
+			if err := pollWait(ctx, defaultPollInterval); err != nil {
+				return nil, err
+			}
+			from, token, done = last+1, nil, false
+		}
+
+		in := &cloudwatchlogs.FilterLogEventsInput{
+			LogGroupName: aws.String(spec.group),
+			StartTime:    aws.Int64(from),
+			NextToken:    token,
+		}
+		if spec.streamPrefix != "" {
+			in.LogStreamNamePrefix = aws.String(spec.streamPrefix)
+		}
+		if spec.filter != "" {
+			in.FilterPattern = aws.String(spec.filter)
+		}
+		if !follow {
+			in.EndTime = aws.Int64(spec.end.UnixMilli())
+		}
+
+		out, err := client.FilterLogEvents(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+
+		events := make([]eventT, 0, len(out.Events))
+		for _, ev := range out.Events {
+			ts := aws.ToInt64(ev.Timestamp)
+			last = max(last, ts)
+			if spec.keep != nil && !spec.keep(aws.ToString(ev.LogStreamName)) {
+				continue
+			}
+			events = append(events, eventT{
+				ts:   time.UnixMilli(ts),
+				line: strings.TrimRight(aws.ToString(ev.Message), "\n"),
+			})
+		}
+
+		if token = out.NextToken; token == nil {
+			done = true
+		}
+
+		return events, nil
+	}
+}
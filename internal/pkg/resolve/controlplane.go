@@ -0,0 +1,45 @@
+package resolve
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Control plane components selectable on eks and gke locations.
+const (
+	componentAPI               = "api"
+	componentAudit             = "audit"
+	componentAuthenticator     = "authenticator"
+	componentControllerManager = "controller-manager"
+	componentScheduler         = "scheduler"
+)
+
+var (
+	ErrUnknownComponent = errors.New("unknown control plane component")
+
+	defaultComponents = []string{componentAPI, componentAudit, componentScheduler}
+)
+
+// parseComponents splits a comma separated list of components, keeping the
+// order given and dropping repeats. Empty selects the defaults.
+func parseComponents(s string, supported []string) ([]string, error) {
+
+	if s == "" {
+		return defaultComponents, nil
+	}
+
+	var out []string
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if !slices.Contains(supported, c) {
+			return nil, fmt.Errorf("%w: %q (expected one of %s)", ErrUnknownComponent, c, strings.Join(supported, ", "))
+		}
+		if !slices.Contains(out, c) {
+			out = append(out, c)
+		}
+	}
+
+	return out, nil
+}
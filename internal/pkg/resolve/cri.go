@@ -0,0 +1,154 @@
+package resolve
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/prequel-dev/prequel-logmatch/pkg/format"
+)
+
+// CRI log files, as written by containerd and the kubelet under
+// /var/log/pods, split long lines into partial records tagged P followed by
+// a final record tagged F:
+//
+//	2016-10-06T00:17:09.669794202Z stdout P first part of a long line
+//	2016-10-06T00:17:09.669794203Z stdout F and the rest
+//
+// criRdr joins the partial records of each stream back into one F record
+// with the timestamp of the first part, so matchers see the whole line.
+
+const (
+	criTagPartial = 'P'
+	criTagFull    = "F"
+)
+
+type criPendingT struct {
+	prefix []byte // timestamp and stream of the first part
+	body   []byte
+}
+
+type criRdr struct {
+	src     io.Reader
+	br      *bufio.Reader
+	pending map[string]*criPendingT
+	order   []string // streams with pending partials, oldest first
+	out     []byte
+	err     error
+}
+
+func newCriRdr(src io.Reader) *criRdr {
+	return &criRdr{
+		src:     src,
+		pending: make(map[string]*criPendingT),
+	}
+}
+
+// isCri reports whether lines from factory need partial reassembly.
+func isCri(factory format.FactoryI) bool {
+	return factory.String() == format.FactoryCRI
+}
+
+func (c *criRdr) Read(p []byte) (int, error) {
+	if c.br == nil {
+		c.br = bufio.NewReader(c.src)
+	}
+
+	for len(c.out) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+
+		line, err := c.br.ReadBytes('\n')
+		if len(line) > 0 {
+			c.add(line)
+		}
+		if err != nil {
+			// Emit any unterminated partials before reporting the error
+			c.flushAll()
+			c.err = err
+		}
+	}
+
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// add processes one record; anything that does not parse as CRI is passed
+// through unchanged.
+func (c *criRdr) add(line []byte) {
+
+	ts, rest, ok := bytes.Cut(line, []byte{' '})
+	if !ok {
+		c.out = append(c.out, line...)
+		return
+	}
+	stream, rest, ok := bytes.Cut(rest, []byte{' '})
+	if !ok {
+		c.out = append(c.out, line...)
+		return
+	}
+	tag, msg, ok := bytes.Cut(rest, []byte{' '})
+	if !ok || len(tag) == 0 {
+		c.out = append(c.out, line...)
+		return
+	}
+
+	key := string(stream)
+	pend := c.pending[key]
+
+	if tag[0] == criTagPartial {
+		if pend == nil {
+			pend = &criPendingT{prefix: append(append(append([]byte{}, ts...), ' '), stream...)}
+			c.pending[key] = pend
+			c.order = append(c.order, key)
+		}
+		pend.body = append(pend.body, bytes.TrimRight(msg, "\r\n")...)
+
+		// Bound memory on a stream that never finishes its line
+		if len(pend.body) >= format.MaxRecordSize {
+			c.flush(key)
+		}
+		return
+	}
+
+	if pend == nil {
+		c.out = append(c.out, line...)
+		return
+	}
+
+	pend.body = append(pend.body, msg...)
+	if !bytes.HasSuffix(pend.body, []byte{'\n'}) {
+		pend.body = append(pend.body, '\n')
+	}
+	c.flush(key)
+}
+
+func (c *criRdr) flush(key string) {
+	pend := c.pending[key]
+	if pend == nil {
+		return
+	}
+
+	c.out = append(c.out, pend.prefix...)
+	c.out = append(c.out, ' ')
+	c.out = append(c.out, criTagFull...)
+	c.out = append(c.out, ' ')
+	c.out = append(c.out, bytes.TrimRight(pend.body, "\n")...)
+	c.out = append(c.out, '\n')
+
+	delete(c.pending, key)
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (c *criRdr) flushAll() {
+	for len(c.order) > 0 {
+		c.flush(c.order[0])
+	}
+}
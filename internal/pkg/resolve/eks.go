@@ -0,0 +1,118 @@
+package resolve
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+)
+
+// An EKS location names a cluster and reads its control plane logs from
+// the CloudWatch log group EKS writes them to. Logging must be enabled on
+// the cluster for each component read.
+//
+//	locations:
+//	  - type: eks
+//	    path: prod?components=api,audit&start=6h&region=us-east-1
+//
+// Parameters: components (api, audit, authenticator, controller-manager and
+// scheduler; default api, audit and scheduler), start, end, region and
+// profile as for cloudwatch.
+
+const (
+	locationEks = "eks"
+	eksGroupFmt = "/aws/eks/%s/cluster"
+)
+
+// eksStreams maps components to the prefix of their log streams. Audit
+// streams share the api server prefix, so api skips them.
+var eksStreams = map[string]struct{ prefix, skip string }{
+	componentAPI:               {"kube-apiserver-", "kube-apiserver-audit-"},
+	componentAudit:             {"kube-apiserver-audit-", ""},
+	componentAuthenticator:     {"authenticator-", ""},
+	componentControllerManager: {"kube-controller-manager-", ""},
+	componentScheduler:         {"kube-scheduler-", ""},
+}
+
+var eksComponents = []string{
+	componentAPI,
+	componentAudit,
+	componentAuthenticator,
+	componentControllerManager,
+	componentScheduler,
+}
+
+func parseEks(path string, now time.Time) (*cloudWatchSpec, error) {
+
+	cluster, query, _ := strings.Cut(path, "?")
+	if cluster == "" {
+		return nil, ErrMissingCluster
+	}
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	components, err := parseComponents(params.Get("components"), eksComponents)
+	if err != nil {
+		return nil, err
+	}
+
+	params.Del("components")
+	params.Del("stream_prefix")
+
+	spec, err := parseCloudWatch(fmt.Sprintf(eksGroupFmt, cluster)+"?"+params.Encode(), now)
+	if err != nil {
+		return nil, err
+	}
+
+	spec.keep = eksKeep(components)
+
+	// A single component is narrowed server side
+	if len(components) == 1 {
+		spec.streamPrefix = eksStreams[components[0]].prefix
+	}
+
+	return spec, nil
+}
+
+// eksKeep matches the streams of the selected components. All components
+// share one log group, so reading them as one log keeps them in time order.
+func eksKeep(components []string) func(string) bool {
+	return func(stream string) bool {
+		return slices.ContainsFunc(components, func(c string) bool {
+			s := eksStreams[c]
+			return strings.HasPrefix(stream, s.prefix) && (s.skip == "" || !strings.HasPrefix(stream, s.skip))
+		})
+	}
+}
+
+func resolveEks(location datasrc.Location, opts ...OptT) ([]LogSrcI, error) {
+
+	spec, err := parseEks(location.Path, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newCloudWatchClient(context.Background(), spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if location.Window != 0 {
+		opts = append(opts, WithWindow(int64(location.Window)))
+	}
+
+	var (
+		follow        = parseOpts(opts...).follow != nil
+		cluster, _, _ = strings.Cut(location.Path, "?")
+		src           = newRemoteSrc(locationEks+":"+cluster, cloudWatchFetch(client, spec, follow), opts...)
+	)
+
+	return []LogSrcI{src}, nil
+}
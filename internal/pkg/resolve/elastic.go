@@ -0,0 +1,268 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+)
+
+// An Elasticsearch or OpenSearch location points at the cluster URL with the
+// index pattern as the path, and carries the query and range as parameters.
+//
+//	locations:
+//	  - type: elasticsearch
+//	    path: https://es:9200/logs-*?query=kubernetes.namespace:prod AND level:error&start=2h
+//
+// Parameters: query (query string syntax; default all documents), start and
+// end (RFC3339, "now" or a duration before now; default the last hour),
+// ts_field (default @timestamp), msg_field (default message), size (hits per
+// request), tiebreaker (unique sort field for paging; default _doc),
+// api_key_env and token_env (environment variables holding an API key or a
+// bearer token). Basic auth may be given in the URL.
+
+const (
+	locationElastic       = "elasticsearch"
+	locationOpenSearch    = "opensearch"
+	elasticDefaultSize    = 1000
+	elasticDefaultTs      = "@timestamp"
+	elasticDefaultMsg     = "message"
+	elasticDefaultTie     = "_doc"
+	elasticClientTimeout  = 30 * time.Second
+	elasticTimeFormatDate = "strict_date_optional_time"
+)
+
+type elasticSpec struct {
+	base       string
+	index      string
+	query      string
+	start      time.Time
+	end        time.Time
+	tsField    string
+	msgField   string
+	tiebreaker string
+	size       int
+	apiKeyEnv  string
+	tokenEnv   string
+}
+
+func parseElastic(path string, now time.Time) (*elasticSpec, error) {
+
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	index := strings.Trim(u.Path, "/")
+	if index == "" {
+		return nil, ErrMissingIndex
+	}
+
+	params := u.Query()
+	u.Path, u.RawPath, u.RawQuery = "", "", ""
+
+	spec := &elasticSpec{
+		base:       u.String(),
+		index:      index,
+		query:      params.Get("query"),
+		tsField:    elasticDefaultTs,
+		msgField:   elasticDefaultMsg,
+		tiebreaker: elasticDefaultTie,
+		size:       elasticDefaultSize,
+		apiKeyEnv:  params.Get("api_key_env"),
+		tokenEnv:   params.Get("token_env"),
+	}
+
+	if f := params.Get("ts_field"); f != "" {
+		spec.tsField = f
+	}
+	if f := params.Get("msg_field"); f != "" {
+		spec.msgField = f
+	}
+	if f := params.Get("tiebreaker"); f != "" {
+		spec.tiebreaker = f
+	}
+
+	if s := params.Get("size"); s != "" {
+		if spec.size, err = strconv.Atoi(s); err != nil || spec.size <= 0 {
+			return nil, fmt.Errorf("invalid size %q", s)
+		}
+	}
+
+	if spec.start, err = parseTimeBound(params.Get("start"), now, now.Add(-defaultLookback)); err != nil {
+		return nil, err
+	}
+	if spec.end, err = parseTimeBound(params.Get("end"), now, now); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+type elasticHitT struct {
+	Source map[string]any `json:"_source"`
+	Sort   []any          `json:"sort"`
+}
+
+type elasticResponseT struct {
+	Hits struct {
+		Hits []elasticHitT `json:"hits"`
+	} `json:"hits"`
+}
+
+func resolveElastic(location datasrc.Location, opts ...OptT) ([]LogSrcI, error) {
+
+	spec, err := parseElastic(location.Path, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if location.Window != 0 {
+		opts = append(opts, WithWindow(int64(location.Window)))
+	}
+
+	var (
+		follow = parseOpts(opts...).follow != nil
+		client = httpz.New(httpz.WithName(location.Type), httpz.WithTimeout(elasticClientTimeout))
+		src    = newRemoteSrc(location.Type+":"+spec.index, elasticFetch(client, spec, follow), opts...)
+	)
+
+	return []LogSrcI{src}, nil
+}
+
+// elasticFetch pages through the search results in timestamp order with
+// search_after. In follow mode the range end is moved to now on each poll
+// and paging resumes after the last hit seen.
+func elasticFetch(client *http.Client, spec *elasticSpec, follow bool) fetchT {
+
+	var (
+		after []any
+		done  bool
+	)
+
+	return func(ctx context.Context) ([]eventT, error) {
+
+		end := spec.end
+		if done {
+			if !follow {
+				return nil, io.EOF
+			}
+			if err := pollWait(ctx, defaultPollInterval); err != nil {
+				return nil, err
+			}
+			done = false
+		}
+		if follow {
+			end = time.Now()
+		}
+
+		resp, err := elasticSearch(ctx, client, spec, end, after)
+		if err != nil {
+			return nil, err
+		}
+
+		hits := resp.Hits.Hits
+		events := make([]eventT, 0, len(hits))
+		for _, hit := range hits {
+			ts, err := eventTime(lookupField(hit.Source, spec.tsField))
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", spec.tsField, err)
+			}
+			events = append(events, eventT{
+				ts:   ts,
+				line: eventMessage(lookupField(hit.Source, spec.msgField)),
+			})
+		}
+
+		if len(hits) > 0 {
+			after = hits[len(hits)-1].Sort
+		}
+		if len(hits) < spec.size {
+			done = true
+		}
+
+		return events, nil
+	}
+}
+
+func elasticSearch(ctx context.Context, client *http.Client, spec *elasticSpec, end time.Time, after []any) (*elasticResponseT, error) {
+
+	filter := []any{
+		map[string]any{
+			"range": map[string]any{
+				spec.tsField: map[string]any{
+					"gte":    spec.start.UTC().Format(time.RFC3339Nano),
+					"lte":    end.UTC().Format(time.RFC3339Nano),
+					"format": elasticTimeFormatDate,
+				},
+			},
+		},
+	}
+	if spec.query != "" {
+		filter = append(filter, map[string]any{
+			"query_string": map[string]any{"query": spec.query},
+		})
+	}
+
+	body := map[string]any{
+		"size":    spec.size,
+		"_source": []string{spec.tsField, spec.msgField},
+		"sort": []any{
+			map[string]any{spec.tsField: "asc"},
+			map[string]any{spec.tiebreaker: "asc"},
+		},
+		"query": map[string]any{
+			"bool": map[string]any{"filter": filter},
+		},
+	}
+	if len(after) > 0 {
+		body["search_after"] = after
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	reqURL := spec.base + "/" + url.PathEscape(spec.index) + "/_search"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	switch {
+	case spec.apiKeyEnv != "":
+		req.Header.Set("Authorization", "ApiKey "+os.Getenv(spec.apiKeyEnv))
+	case spec.tokenEnv != "":
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(spec.tokenEnv))
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("search failed: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var out elasticResponseT
+	if err = json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
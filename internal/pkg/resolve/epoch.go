@@ -0,0 +1,49 @@
+package resolve
+
+import (
+	"time"
+
+	"github.com/prequel-dev/prequel-logmatch/pkg/format"
+	"github.com/prequel-dev/prequel-logmatch/pkg/timez"
+)
+
+// Lines that start with a bare epoch, as written by many structured
+// loggers and metrics exporters:
+//
+//	1714557600 GET /healthz 200
+//	1714557600123 level=warn ...
+//	1714557600.123456 conn reset
+//
+// Seconds, millis, micros and nanos are told apart by their digit count.
+var epochSpecs = []FmtSpec{
+	{Pattern: `^(\d{10}\.\d{1,9})(?:\s|$)`, Format: timez.FmtDotNotation},
+	{Pattern: `^(\d{19}|\d{16}|\d{13}|\d{10})(?:\s|$)`, Format: timez.FmtEpochAny},
+}
+
+// A leading number is only taken as an epoch within these bounds, so
+// counters and line numbers are not mistaken for timestamps.
+var (
+	epochMin = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	epochMax = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+)
+
+func detectEpoch(data []byte, maxTries int) (format.FactoryI, int64, error) {
+
+	err := error(format.ErrMatchTimestamp)
+
+	for _, spec := range epochSpecs {
+		var (
+			factory format.FactoryI
+			ts      int64
+		)
+		if factory, ts, err = timez.TryTimestampFormat(spec.Pattern, spec.Format, data, maxTries); err != nil {
+			continue
+		}
+		if ts >= epochMin && ts < epochMax {
+			return factory, ts, nil
+		}
+		err = format.ErrParseTimestamp
+	}
+
+	return nil, -1, err
+}
@@ -0,0 +1,130 @@
+package resolve
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+)
+
+// An eventlog location reads a live Windows event log channel. Events are
+// rendered as XML, like exported .evtx files. Only Windows builds support
+// it.
+//
+//	locations:
+//	  - type: eventlog
+//	    path: System?query=*[System[(Level=1 or Level=2)]]&start=24h
+//
+// Parameters: query (XPath filter; default all events) and start (RFC3339
+// or a duration before now; default the last hour).
+
+const (
+	locationEventLog = "eventlog"
+)
+
+var (
+	ErrMissingChannel      = errors.New("missing event log channel")
+	ErrEventLogUnsupported = errors.New("live event logs are only supported on Windows")
+)
+
+type eventLogSpec struct {
+	channel string
+	query   string
+	start   time.Time
+}
+
+func parseEventLog(path string, now time.Time) (*eventLogSpec, error) {
+
+	channel, query, _ := strings.Cut(path, "?")
+	if channel == "" {
+		return nil, ErrMissingChannel
+	}
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &eventLogSpec{
+		channel: channel,
+		query:   params.Get("query"),
+	}
+
+	if spec.query == "" {
+		spec.query = "*"
+	}
+
+	if spec.start, err = parseTimeBound(params.Get("start"), now, now.Add(-defaultLookback)); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+func resolveEventLog(location datasrc.Location, opts ...OptT) ([]LogSrcI, error) {
+
+	spec, err := parseEventLog(location.Path, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	fetch, err := eventLogFetch(spec, parseOpts(opts...).follow != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if location.Window != 0 {
+		opts = append(opts, WithWindow(int64(location.Window)))
+	}
+
+	return []LogSrcI{newRemoteSrc(locationEventLog+":"+spec.channel, fetch, opts...)}, nil
+}
+
+var (
+	eventRecordId   = regexp.MustCompile(`<EventRecordID>(\d+)</EventRecordID>`)
+	eventSystemTime = regexp.MustCompile(`<TimeCreated SystemTime=['"]([^'"]+)['"]`)
+)
+
+// eventLogQuery selects events in the channel newer than the start time, or
+// after the last record seen once polling.
+func eventLogQuery(spec *eventLogSpec, after uint64) string {
+
+	var (
+		b       strings.Builder
+		channel = xmlEscaper.Replace(spec.channel)
+	)
+
+	b.WriteString(`<QueryList><Query Id="0"><Select Path="` + channel + `">` + xmlEscaper.Replace(spec.query) + `</Select>`)
+	if after > 0 {
+		b.WriteString(`<Suppress Path="` + channel + `">*[System[EventRecordID&lt;=` + strconv.FormatUint(after, 10) + `]]</Suppress>`)
+	} else {
+		b.WriteString(`<Suppress Path="` + channel + `">*[System[TimeCreated[@SystemTime&lt;'` + spec.start.UTC().Format(time.RFC3339Nano) + `']]]</Suppress>`)
+	}
+	b.WriteString(`</Query></QueryList>`)
+
+	return b.String()
+}
+
+// eventFromXml timestamps a rendered event with its creation time and
+// returns its record id.
+func eventFromXml(xml string) (eventT, uint64) {
+
+	ev := eventT{ts: time.Now(), line: xml}
+
+	if m := eventSystemTime.FindStringSubmatch(xml); m != nil {
+		if ts, err := time.Parse(time.RFC3339Nano, m[1]); err == nil {
+			ev.ts = ts
+		}
+	}
+
+	var id uint64
+	if m := eventRecordId.FindStringSubmatch(xml); m != nil {
+		id, _ = strconv.ParseUint(m[1], 10, 64)
+	}
+
+	return ev, id
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package resolve
+
+func eventLogFetch(spec *eventLogSpec, follow bool) (fetchT, error) {
+	return nil, ErrEventLogUnsupported
+}
@@ -0,0 +1,140 @@
+//go:build windows
+
+package resolve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modWevtapi    = syscall.NewLazyDLL("wevtapi.dll")
+	procEvtQuery  = modWevtapi.NewProc("EvtQuery")
+	procEvtNext   = modWevtapi.NewProc("EvtNext")
+	procEvtRender = modWevtapi.NewProc("EvtRender")
+	procEvtClose  = modWevtapi.NewProc("EvtClose")
+)
+
+const (
+	evtQueryForwardDirection = 0x100
+	evtRenderEventXml        = 1
+	evtBatchSize             = 64
+	evtNextTimeoutMs         = 1000
+
+	errNoMoreItems        = syscall.Errno(259)
+	errInsufficientBuffer = syscall.Errno(122)
+	errEvtTimeout         = syscall.Errno(1460)
+)
+
+// eventLogFetch reads the query results a batch at a time. In follow mode
+// it re-queries for records after the last one seen once caught up.
+func eventLogFetch(spec *eventLogSpec, follow bool) (fetchT, error) {
+
+	if err := procEvtQuery.Find(); err != nil {
+		return nil, err
+	}
+
+	var (
+		results uintptr
+		last    uint64
+		queried bool
+	)
+
+	return func(ctx context.Context) ([]eventT, error) {
+
+		if results == 0 {
+			if queried {
+				if !follow {
+					return nil, io.EOF
+				}
+				if err := pollWait(ctx, defaultPollInterval); err != nil {
+					return nil, err
+				}
+			}
+
+			query, err := syscall.UTF16PtrFromString(eventLogQuery(spec, last))
+			if err != nil {
+				return nil, err
+			}
+
+			r, _, err := procEvtQuery.Call(0, 0, uintptr(unsafe.Pointer(query)), evtQueryForwardDirection)
+			if r == 0 {
+				return nil, fmt.Errorf("EvtQuery %s: %w", spec.channel, err)
+			}
+			results, queried = r, true
+		}
+
+		xmls, err := evtNext(results)
+		switch {
+		case errors.Is(err, errNoMoreItems):
+			evtClose(results)
+			results = 0
+		case errors.Is(err, errEvtTimeout):
+		case err != nil:
+			evtClose(results)
+			results = 0
+			return nil, fmt.Errorf("EvtNext %s: %w", spec.channel, err)
+		}
+
+		events := make([]eventT, 0, len(xmls))
+		for _, xml := range xmls {
+			ev, id := eventFromXml(xml)
+			last = max(last, id)
+			events = append(events, ev)
+		}
+
+		return events, nil
+	}, nil
+}
+
+func evtNext(results uintptr) ([]string, error) {
+
+	var (
+		handles  [evtBatchSize]uintptr
+		returned uint32
+	)
+
+	r, _, err := procEvtNext.Call(results, evtBatchSize, uintptr(unsafe.Pointer(&handles[0])), evtNextTimeoutMs, 0, uintptr(unsafe.Pointer(&returned)))
+	if r == 0 {
+		return nil, err
+	}
+
+	xmls := make([]string, 0, returned)
+	for _, h := range handles[:returned] {
+		xml, err := evtRender(h)
+		evtClose(h)
+		if err != nil {
+			return xmls, fmt.Errorf("EvtRender: %w", err)
+		}
+		xmls = append(xmls, xml)
+	}
+
+	return xmls, nil
+}
+
+func evtRender(h uintptr) (string, error) {
+
+	var used, props uint32
+
+	// The first call sizes the buffer
+	r, _, err := procEvtRender.Call(0, h, evtRenderEventXml, 0, 0, uintptr(unsafe.Pointer(&used)), uintptr(unsafe.Pointer(&props)))
+	if r == 0 && !errors.Is(err, errInsufficientBuffer) {
+		return "", err
+	}
+
+	buf := make([]uint16, used/2+1)
+	r, _, err = procEvtRender.Call(0, h, evtRenderEventXml, uintptr(len(buf)*2), uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&used)), uintptr(unsafe.Pointer(&props)))
+	if r == 0 {
+		return "", err
+	}
+
+	return syscall.UTF16ToString(buf), nil
+}
+
+func evtClose(h uintptr) {
+	procEvtClose.Call(h)
+}
@@ -0,0 +1,714 @@
+package resolve
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/prequel-dev/prequel-logmatch/pkg/format"
+	"github.com/rs/zerolog/log"
+)
+
+// Exported Windows event logs (.evtx) are rendered one record per line as
+// the event XML that wevtutil and Event Viewer show, timestamped with the
+// time the record was written:
+//
+//	<Event xmlns="..."><System><Provider Name="Service Control Manager"/>...<EventID>7031</EventID>...</System><EventData>...</EventData></Event>
+//
+// A file is a header followed by 64KiB chunks. Each chunk holds records
+// encoded as binary XML, whose element names and templates are stored
+// once per chunk and referenced by offset. Checksums are not verified so
+// that logs copied from a live system can still be read.
+
+const (
+	evtxFileMagic      = "ElfFile\x00"
+	evtxChunkMagic     = "ElfChnk\x00"
+	evtxFileHeaderSize = 4096
+	evtxChunkSize      = 64 * 1024
+	evtxChunkHeader    = 512 // header, string and template tables
+	evtxRecordMagic    = 0x00002a2a
+	evtxRecordHeader   = 24
+	evtxMaxDepth       = 64
+
+	// 100ns intervals between 1601-01-01 and 1970-01-01
+	filetimeEpoch = 116444736000000000
+)
+
+// Binary XML tokens; 0x40 flags more data (attributes, or a following
+// attribute or value) and is masked off.
+const (
+	bxEOF          = 0x00
+	bxOpenStart    = 0x01
+	bxCloseStart   = 0x02
+	bxCloseEmpty   = 0x03
+	bxEndElement   = 0x04
+	bxValue        = 0x05
+	bxAttribute    = 0x06
+	bxCDATA        = 0x07
+	bxCharRef      = 0x08
+	bxEntityRef    = 0x09
+	bxPITarget     = 0x0a
+	bxPIData       = 0x0b
+	bxTemplate     = 0x0c
+	bxSubstitution = 0x0d
+	bxOptionalSub  = 0x0e
+	bxFragment     = 0x0f
+	bxMoreData     = 0x40
+)
+
+// Substitution value types
+const (
+	evNull      = 0x00
+	evString    = 0x01
+	evAnsi      = 0x02
+	evInt8      = 0x03
+	evUint8     = 0x04
+	evInt16     = 0x05
+	evUint16    = 0x06
+	evInt32     = 0x07
+	evUint32    = 0x08
+	evInt64     = 0x09
+	evUint64    = 0x0a
+	evFloat32   = 0x0b
+	evFloat64   = 0x0c
+	evBool      = 0x0d
+	evBinary    = 0x0e
+	evGuid      = 0x0f
+	evSizeT     = 0x10
+	evFiletime  = 0x11
+	evSystime   = 0x12
+	evSid       = 0x13
+	evHexInt32  = 0x14
+	evHexInt64  = 0x15
+	evBinXml    = 0x21
+	evArrayFlag = 0x80
+)
+
+var (
+	ErrEvtxCorrupt = errors.New("corrupt evtx file")
+)
+
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func isEvtx(fn string) bool {
+	return strings.EqualFold(filepath.Ext(fn), ".evtx")
+}
+
+func filetime(ft uint64) time.Time {
+	return time.Unix(0, (int64(ft)-filetimeEpoch)*100).UTC()
+}
+
+// newEvtxSrc resolves an exported event log as a log of Docker JSON lines,
+// the same encoding used for remote sources.
+func newEvtxSrc(fn string, opts ...OptT) (src *logSrc, err error) {
+
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			fh.Close()
+		}
+	}()
+
+	rdr, err := newEvtxRdr(fh)
+	if err != nil {
+		return nil, err
+	}
+
+	// Render up to the first record for the starting timestamp
+	for rdr.first.IsZero() {
+		switch err = rdr.fill(); err {
+		case nil:
+		case io.EOF:
+			return nil, fmt.Errorf("%w: no records", ErrEvtxCorrupt)
+		default:
+			return nil, err
+		}
+	}
+
+	o := parseOpts(opts...)
+
+	var rd io.Reader
+	if rd, err = o.srcRange.apply(rdr, nil); err != nil {
+		return nil, err
+	}
+
+	return &logSrc{
+		sz:      -1,
+		ts:      rdr.first.UnixNano(),
+		fh:      fh,
+		rd:      rd,
+		factory: format.NewJsonFactory(),
+		window:  o.window,
+	}, nil
+}
+
+// evtxRdr renders one chunk at a time, oldest first.
+type evtxRdr struct {
+	fh     io.ReaderAt
+	chunks []int64 // chunk offsets ordered by first record number
+	buf    bytes.Buffer
+	first  time.Time
+}
+
+func newEvtxRdr(fh *os.File) (*evtxRdr, error) {
+
+	var hdr [evtxFileHeaderSize]byte
+	if _, err := io.ReadFull(fh, hdr[:]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEvtxCorrupt, err)
+	}
+	if string(hdr[:8]) != evtxFileMagic {
+		return nil, fmt.Errorf("%w: bad file signature", ErrEvtxCorrupt)
+	}
+
+	info, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	type chunkT struct {
+		off   int64
+		first uint64
+	}
+
+	// The header's chunk count may be stale in a dirty file; scan instead
+	var (
+		chunks []chunkT
+		chdr   [16]byte
+	)
+	for off := int64(evtxFileHeaderSize); off+evtxChunkSize <= info.Size(); off += evtxChunkSize {
+		if _, err := fh.ReadAt(chdr[:], off); err != nil {
+			return nil, err
+		}
+		if string(chdr[:8]) != evtxChunkMagic {
+			continue
+		}
+		chunks = append(chunks, chunkT{off: off, first: binary.LittleEndian.Uint64(chdr[8:])})
+	}
+
+	// Chunks wrap around in a circular log
+	slices.SortFunc(chunks, func(a, b chunkT) int {
+		return cmp.Compare(a.first, b.first)
+	})
+
+	rdr := &evtxRdr{fh: fh}
+	for _, c := range chunks {
+		rdr.chunks = append(rdr.chunks, c.off)
+	}
+	return rdr, nil
+}
+
+func (r *evtxRdr) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	return r.buf.Read(p)
+}
+
+// fill renders the next chunk into buf.
+func (r *evtxRdr) fill() error {
+
+	if len(r.chunks) == 0 {
+		return io.EOF
+	}
+
+	off := r.chunks[0]
+	r.chunks = r.chunks[1:]
+
+	chunk := make([]byte, evtxChunkSize)
+	if _, err := r.fh.ReadAt(chunk, off); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(&r.buf)
+	for _, ev := range evtxChunkEvents(chunk, off) {
+		if r.first.IsZero() {
+			r.first = ev.ts
+		}
+		if err := enc.Encode(remoteLineT{Log: ev.line, Stream: streamStdout, Time: ev.ts}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// evtxChunkEvents renders the records in a chunk. Records that fail to
+// render are logged and skipped.
+func evtxChunkEvents(chunk []byte, off int64) []eventT {
+
+	var (
+		events []eventT
+		free   = int(binary.LittleEndian.Uint32(chunk[48:]))
+	)
+
+	free = min(free, len(chunk))
+
+	for pos := evtxChunkHeader; pos+evtxRecordHeader <= free; {
+
+		var (
+			magic = binary.LittleEndian.Uint32(chunk[pos:])
+			size  = int(binary.LittleEndian.Uint32(chunk[pos+4:]))
+			id    = binary.LittleEndian.Uint64(chunk[pos+8:])
+			ts    = filetime(binary.LittleEndian.Uint64(chunk[pos+16:]))
+		)
+
+		if magic != evtxRecordMagic || size < evtxRecordHeader+4 || pos+size > len(chunk) {
+			break
+		}
+
+		bx := &binXmlT{chunk: chunk, pos: pos + evtxRecordHeader, end: pos + size - 4}
+		if line, err := bx.render(); err != nil {
+			log.Info().
+				Err(err).
+				Int64("chunk", off).
+				Uint64("record", id).
+				Msg("Skipping evtx record")
+		} else {
+			events = append(events, eventT{ts: ts, line: line})
+		}
+
+		pos += size
+	}
+
+	return events
+}
+
+type subValueT struct {
+	typ  byte
+	off  int
+	size int
+}
+
+// binXmlT renders a binary XML fragment. Offsets are relative to the
+// chunk, since names and templates are shared across its records.
+type binXmlT struct {
+	chunk []byte
+	pos   int
+	end   int
+	subs  []subValueT
+	depth int
+	b     strings.Builder
+	err   error
+}
+
+func (x *binXmlT) render() (string, error) {
+	x.fragment()
+	if x.err != nil {
+		return "", x.err
+	}
+	return x.b.String(), nil
+}
+
+func (x *binXmlT) fail(format string, args ...any) {
+	if x.err == nil {
+		x.err = fmt.Errorf("%w: "+format, append([]any{ErrEvtxCorrupt}, args...)...)
+	}
+}
+
+// bytes returns n bytes at off, or zeros once out of bounds so callers
+// need not check every read.
+func (x *binXmlT) bytes(off, n int) []byte {
+	if x.err == nil && (off < 0 || n < 0 || off+n > len(x.chunk)) {
+		x.fail("read past end of chunk at %d", off)
+	}
+	if x.err != nil {
+		return make([]byte, max(n, 0))
+	}
+	return x.chunk[off : off+n]
+}
+
+func (x *binXmlT) u8() byte {
+	v := x.bytes(x.pos, 1)[0]
+	x.pos++
+	return v
+}
+
+func (x *binXmlT) u16() uint16 {
+	v := binary.LittleEndian.Uint16(x.bytes(x.pos, 2))
+	x.pos += 2
+	return v
+}
+
+func (x *binXmlT) u32() uint32 {
+	v := binary.LittleEndian.Uint32(x.bytes(x.pos, 4))
+	x.pos += 4
+	return v
+}
+
+func (x *binXmlT) peek() byte {
+	if x.pos >= x.end {
+		return bxEOF
+	}
+	return x.bytes(x.pos, 1)[0]
+}
+
+func (x *binXmlT) utf16(off, chars int) string {
+	return decodeUtf16(x.bytes(off, chars*2))
+}
+
+func decodeUtf16(data []byte) string {
+	u := make([]uint16, len(data)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+}
+
+// name reads an element or attribute name. A name defined inline directly
+// follows the token and is skipped.
+func (x *binXmlT) name() string {
+	off := int(x.u32())
+	chars := int(binary.LittleEndian.Uint16(x.bytes(off+6, 2)))
+	if off == x.pos {
+		x.pos += 8 + chars*2 + 2
+	}
+	return x.utf16(off+8, chars)
+}
+
+func (x *binXmlT) fragment() {
+	if x.depth++; x.depth > evtxMaxDepth {
+		x.fail("nesting too deep")
+		return
+	}
+	defer func() { x.depth-- }()
+
+	if x.peek() == bxFragment {
+		x.pos += 4
+	}
+
+	switch tok := x.peek(); tok &^ bxMoreData {
+	case bxTemplate:
+		x.template()
+	case bxOpenStart:
+		x.element()
+	default:
+		x.fail("unexpected token 0x%02x at %d", tok, x.pos)
+	}
+}
+
+// template renders a template instance: a definition, given inline or by
+// offset, and the substitution values for this record.
+func (x *binXmlT) template() {
+
+	x.pos += 2 // token, unknown
+	x.u32()    // template id
+	def := int(x.u32())
+
+	if def == x.pos {
+		x.pos += 20 // next template offset, guid
+		x.pos += int(x.u32())
+	}
+
+	var (
+		count = int(x.u32())
+		subs  = make([]subValueT, 0, count)
+		off   = x.pos + count*4
+	)
+
+	if count > evtxChunkSize/4 {
+		x.fail("too many substitutions: %d", count)
+		return
+	}
+
+	for range count {
+		size := int(x.u16())
+		typ := x.u8()
+		x.pos++
+		subs = append(subs, subValueT{typ: typ, off: off, size: size})
+		off += size
+	}
+	x.pos = off
+
+	size := int(binary.LittleEndian.Uint32(x.bytes(def+20, 4)))
+	body := &binXmlT{chunk: x.chunk, pos: def + 24, end: def + 24 + size, subs: subs, depth: x.depth}
+	body.fragment()
+	if body.err != nil {
+		x.err = body.err
+		return
+	}
+	x.b.WriteString(body.b.String())
+}
+
+func (x *binXmlT) element() {
+	if x.depth++; x.depth > evtxMaxDepth {
+		x.fail("nesting too deep")
+		return
+	}
+	defer func() { x.depth-- }()
+
+	tok := x.u8()
+	x.pos += 2 // dependency id
+	x.pos += 4 // data size
+	name := x.name()
+	if tok&bxMoreData != 0 {
+		x.pos += 4 // attribute list size
+	}
+
+	x.b.WriteString("<" + name)
+	for x.err == nil && x.peek()&^bxMoreData == bxAttribute {
+		x.attribute()
+	}
+
+	switch tok := x.u8(); tok {
+	case bxCloseEmpty:
+		x.b.WriteString("/>")
+	case bxCloseStart:
+		x.b.WriteString(">")
+		x.content()
+		if tok := x.u8(); tok != bxEndElement && x.err == nil {
+			x.fail("unterminated element %s", name)
+		}
+		x.b.WriteString("</" + name + ">")
+	default:
+		x.fail("unexpected token 0x%02x in element %s", tok, name)
+	}
+}
+
+// attribute renders name="value". An attribute whose only value is an
+// empty optional substitution is dropped, as Windows does.
+func (x *binXmlT) attribute() {
+	x.pos++
+	name := x.name()
+
+	var (
+		val      = &binXmlT{chunk: x.chunk, pos: x.pos, end: x.end, subs: x.subs, depth: x.depth}
+		optional bool
+	)
+
+loop:
+	for val.err == nil {
+		switch val.peek() &^ bxMoreData {
+		case bxValue, bxCharRef, bxEntityRef, bxSubstitution:
+			val.node()
+		case bxOptionalSub:
+			optional = true
+			val.node()
+		default:
+			break loop
+		}
+	}
+
+	x.pos, x.err = val.pos, val.err
+	if optional && val.b.Len() == 0 {
+		return
+	}
+	x.b.WriteString(" " + name + `="` + val.b.String() + `"`)
+}
+
+// content renders child nodes up to the end of the enclosing element.
+func (x *binXmlT) content() {
+	for x.err == nil {
+		switch x.peek() &^ bxMoreData {
+		case bxEndElement, bxEOF:
+			return
+		case bxOpenStart:
+			x.element()
+		case bxTemplate:
+			x.template()
+		default:
+			x.node()
+		}
+	}
+}
+
+func (x *binXmlT) node() {
+	switch tok := x.u8(); tok &^ bxMoreData {
+	case bxValue:
+		x.pos++ // always a string
+		chars := int(x.u16())
+		x.b.WriteString(xmlEscaper.Replace(x.utf16(x.pos, chars)))
+		x.pos += chars * 2
+	case bxSubstitution, bxOptionalSub:
+		id := int(x.u16())
+		x.pos++ // type, repeated in the value
+		if id < len(x.subs) {
+			x.value(x.subs[id])
+		}
+	case bxCDATA:
+		chars := int(x.u16())
+		x.b.WriteString("<![CDATA[" + x.utf16(x.pos, chars) + "]]>")
+		x.pos += chars * 2
+	case bxCharRef:
+		x.b.WriteString("&#" + strconv.Itoa(int(x.u16())) + ";")
+	case bxEntityRef:
+		x.b.WriteString("&" + x.name() + ";")
+	case bxPITarget:
+		x.b.WriteString("<?" + x.name())
+	case bxPIData:
+		chars := int(x.u16())
+		x.b.WriteString(" " + x.utf16(x.pos, chars) + "?>")
+		x.pos += chars * 2
+	default:
+		x.fail("unexpected token 0x%02x at %d", tok, x.pos-1)
+	}
+}
+
+// value renders a substitution value. Embedded binary XML is rendered as
+// markup; everything else is escaped text.
+func (x *binXmlT) value(v subValueT) {
+	if v.typ == evBinXml {
+		nested := &binXmlT{chunk: x.chunk, pos: v.off, end: v.off + v.size, depth: x.depth}
+		nested.fragment()
+		if nested.err != nil {
+			x.err = nested.err
+			return
+		}
+		x.b.WriteString(nested.b.String())
+		return
+	}
+	x.b.WriteString(xmlEscaper.Replace(x.text(v.typ, x.bytes(v.off, v.size))))
+}
+
+func (x *binXmlT) text(typ byte, data []byte) string {
+
+	le := binary.LittleEndian
+
+	if typ&evArrayFlag != 0 {
+		return x.array(typ&^evArrayFlag, data)
+	}
+
+	switch {
+	case typ == evNull:
+		return ""
+	case typ == evString:
+		return decodeUtf16(data)
+	case typ == evAnsi:
+		return strings.TrimRight(string(data), "\x00")
+	case typ == evBinary:
+		return strings.ToUpper(hex.EncodeToString(data))
+	case typ == evSid:
+		return sidString(data)
+	case typ == evBool && len(data) >= 4:
+		return strconv.FormatBool(le.Uint32(data) != 0)
+	case typ == evSizeT && len(data) == 4, typ == evHexInt32 && len(data) >= 4:
+		return fmt.Sprintf("0x%x", le.Uint32(data))
+	case typ == evSizeT && len(data) == 8, typ == evHexInt64 && len(data) >= 8:
+		return fmt.Sprintf("0x%x", le.Uint64(data))
+	case typ == evGuid && len(data) >= 16:
+		return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}", le.Uint32(data), le.Uint16(data[4:]), le.Uint16(data[6:]), data[8:10], data[10:16])
+	case typ == evFiletime && len(data) >= 8:
+		return filetime(le.Uint64(data)).Format(time.RFC3339Nano)
+	case typ == evSystime && len(data) >= 16:
+		return time.Date(int(le.Uint16(data)), time.Month(le.Uint16(data[2:])), int(le.Uint16(data[6:])),
+			int(le.Uint16(data[8:])), int(le.Uint16(data[10:])), int(le.Uint16(data[12:])),
+			int(le.Uint16(data[14:]))*int(time.Millisecond), time.UTC).Format(time.RFC3339Nano)
+	}
+
+	n, ok := evtxNumber(typ, data)
+	if !ok {
+		return strings.ToUpper(hex.EncodeToString(data))
+	}
+	return n
+}
+
+// array renders array values comma separated. String arrays are NUL
+// separated; other types are fixed size.
+func (x *binXmlT) array(typ byte, data []byte) string {
+
+	var parts []string
+
+	switch typ {
+	case evString:
+		parts = strings.Split(decodeUtf16(data), "\x00")
+	case evAnsi:
+		parts = strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+	default:
+		size := evtxTypeSize(typ)
+		if size == 0 {
+			return strings.ToUpper(hex.EncodeToString(data))
+		}
+		for i := 0; i+size <= len(data); i += size {
+			parts = append(parts, x.text(typ, data[i:i+size]))
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func evtxTypeSize(typ byte) int {
+	switch typ {
+	case evInt8, evUint8:
+		return 1
+	case evInt16, evUint16:
+		return 2
+	case evInt32, evUint32, evFloat32, evBool, evHexInt32:
+		return 4
+	case evInt64, evUint64, evFloat64, evFiletime, evHexInt64:
+		return 8
+	case evGuid, evSystime:
+		return 16
+	}
+	return 0
+}
+
+func evtxNumber(typ byte, data []byte) (string, bool) {
+
+	if size := evtxTypeSize(typ); size == 0 || len(data) < size {
+		return "", false
+	}
+
+	le := binary.LittleEndian
+
+	switch typ {
+	case evInt8:
+		return strconv.Itoa(int(int8(data[0]))), true
+	case evUint8:
+		return strconv.Itoa(int(data[0])), true
+	case evInt16:
+		return strconv.Itoa(int(int16(le.Uint16(data)))), true
+	case evUint16:
+		return strconv.Itoa(int(le.Uint16(data))), true
+	case evInt32:
+		return strconv.Itoa(int(int32(le.Uint32(data)))), true
+	case evUint32:
+		return strconv.FormatUint(uint64(le.Uint32(data)), 10), true
+	case evInt64:
+		return strconv.FormatInt(int64(le.Uint64(data)), 10), true
+	case evUint64:
+		return strconv.FormatUint(le.Uint64(data), 10), true
+	case evFloat32:
+		return strconv.FormatFloat(float64(math.Float32frombits(le.Uint32(data))), 'g', -1, 32), true
+	case evFloat64:
+		return strconv.FormatFloat(math.Float64frombits(le.Uint64(data)), 'g', -1, 64), true
+	}
+	return "", false
+}
+
+// sidString formats a security identifier, e.g. S-1-5-18.
+func sidString(data []byte) string {
+	if len(data) < 8 {
+		return strings.ToUpper(hex.EncodeToString(data))
+	}
+
+	var (
+		count     = int(data[1])
+		authority uint64
+	)
+	for _, b := range data[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+
+	sid := fmt.Sprintf("S-%d-%d", data[0], authority)
+	for i := 0; i < count && 8+i*4+4 <= len(data); i++ {
+		sid += "-" + strconv.FormatUint(uint64(binary.LittleEndian.Uint32(data[8+i*4:])), 10)
+	}
+	return sid
+}
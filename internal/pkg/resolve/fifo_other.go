@@ -0,0 +1,5 @@
+//go:build !unix
+
+package resolve
+
+const fifoNonblock = 0
@@ -0,0 +1,7 @@
+//go:build unix
+
+package resolve
+
+import "golang.org/x/sys/unix"
+
+const fifoNonblock = unix.O_NONBLOCK
@@ -0,0 +1,121 @@
+package resolve
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+const (
+	defaultFollowInterval = 250 * time.Millisecond
+)
+
+// followRdr turns EOF into a poll for appended data, like `tail -f`.
+// It only reports EOF once the follow context is cancelled.
+type followRdr struct {
+	ctx      context.Context
+	rd       io.Reader
+	interval time.Duration
+}
+
+func newFollowRdr(ctx context.Context, rd io.Reader) *followRdr {
+	return &followRdr{
+		ctx:      ctx,
+		rd:       rd,
+		interval: defaultFollowInterval,
+	}
+}
+
+func (f *followRdr) Read(p []byte) (int, error) {
+	for {
+		n, err := f.rd.Read(p)
+		switch {
+		case n > 0:
+			return n, nil
+		case err != nil && err != io.EOF:
+			return 0, err
+		}
+
+		select {
+		case <-f.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(f.interval):
+		}
+	}
+}
+
+// tailFollow applies a suffix range to a followed stream, which has no
+// end: the range keeps the end of what was written before the stream
+// first went quiet, and what comes after is read as it arrives.
+func tailFollow(ctx context.Context, rd io.Reader, rng *RangeSpec) (io.Reader, error) {
+	p := newPumpRdr(ctx, rd)
+	backlog, err := rng.apply(&idleRdr{p: p, idle: defaultFollowInterval}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(backlog, p), nil
+}
+
+// pumpRdr reads a stream in the background so a read can give up when
+// nothing arrives for a while.
+type pumpRdr struct {
+	ch  chan []byte
+	err error // set before ch is closed
+	buf []byte
+}
+
+func newPumpRdr(ctx context.Context, rd io.Reader) *pumpRdr {
+	p := &pumpRdr{ch: make(chan []byte)}
+	go func() {
+		defer close(p.ch)
+		for {
+			b := make([]byte, tailChunkSize)
+			n, err := rd.Read(b)
+			if n > 0 {
+				select {
+				case p.ch <- b[:n]:
+				case <-ctx.Done():
+					p.err = io.EOF
+					return
+				}
+			}
+			if err != nil {
+				p.err = err
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *pumpRdr) Read(b []byte) (int, error) {
+	return p.read(b, nil)
+}
+
+// read returns io.EOF if idle fires before anything arrives.
+func (p *pumpRdr) read(b []byte, idle <-chan time.Time) (int, error) {
+	if len(p.buf) == 0 {
+		select {
+		case chunk, ok := <-p.ch:
+			if !ok {
+				return 0, p.err
+			}
+			p.buf = chunk
+		case <-idle:
+			return 0, io.EOF
+		}
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+// idleRdr reads a pumpRdr until it has been quiet for idle.
+type idleRdr struct {
+	p    *pumpRdr
+	idle time.Duration
+}
+
+func (i *idleRdr) Read(b []byte) (int, error) {
+	return i.p.read(b, time.After(i.idle))
+}
@@ -0,0 +1,282 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+)
+
+// A GKE location names a cluster and reads its control plane and audit
+// logs from Cloud Logging.
+//
+//	locations:
+//	  - type: gke
+//	    path: prod?project=acme-prod&location=us-central1&components=api,audit&start=6h
+//
+// Parameters: project (required), location (of the cluster, to tell apart
+// clusters of the same name), components (api, audit, controller-manager
+// and scheduler; default api, audit and scheduler), start and end (RFC3339,
+// "now" or a duration before now; default the last hour) and token_env
+// (environment variable holding an OAuth access token, default
+// GOOGLE_OAUTH_ACCESS_TOKEN, e.g. from gcloud auth print-access-token).
+
+const (
+	locationGke         = "gke"
+	gkeDefaultTokenEnv  = "GOOGLE_OAUTH_ACCESS_TOKEN"
+	gkePageSize         = 1000
+	gkeClientTimeout    = 60 * time.Second
+	gkeAuditActivityLog = "cloudaudit.googleapis.com%2Factivity"
+)
+
+// gkeEndpoint is a variable so tests can substitute a fake.
+var gkeEndpoint = "https://logging.googleapis.com/v2/entries:list"
+
+// gkeControlPlane maps components to the component_name label Cloud
+// Logging sets on control plane logs.
+var gkeControlPlane = map[string]string{
+	componentAPI:               "apiserver",
+	componentControllerManager: "controller-manager",
+	componentScheduler:         "scheduler",
+}
+
+var gkeComponents = []string{
+	componentAPI,
+	componentAudit,
+	componentControllerManager,
+	componentScheduler,
+}
+
+type gkeSpec struct {
+	cluster    string
+	project    string
+	location   string
+	components []string
+	start      time.Time
+	end        time.Time
+	tokenEnv   string
+}
+
+func parseGke(path string, now time.Time) (*gkeSpec, error) {
+
+	cluster, query, _ := strings.Cut(path, "?")
+	if cluster == "" {
+		return nil, ErrMissingCluster
+	}
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &gkeSpec{
+		cluster:  cluster,
+		project:  params.Get("project"),
+		location: params.Get("location"),
+		tokenEnv: params.Get("token_env"),
+	}
+
+	if spec.project == "" {
+		return nil, ErrMissingProject
+	}
+	if spec.tokenEnv == "" {
+		spec.tokenEnv = gkeDefaultTokenEnv
+	}
+
+	if spec.components, err = parseComponents(params.Get("components"), gkeComponents); err != nil {
+		return nil, err
+	}
+
+	if spec.start, err = parseTimeBound(params.Get("start"), now, now.Add(-defaultLookback)); err != nil {
+		return nil, err
+	}
+	if spec.end, err = parseTimeBound(params.Get("end"), now, now); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// filter builds the Cloud Logging query for the selected components from
+// start onwards. The end is left open in follow mode.
+func (s *gkeSpec) filter(start, end time.Time) string {
+
+	var (
+		names []string
+		terms []string
+	)
+
+	for _, c := range s.components {
+		if name, ok := gkeControlPlane[c]; ok {
+			names = append(names, fmt.Sprintf("%q", name))
+		}
+	}
+
+	if len(names) > 0 {
+		terms = append(terms, fmt.Sprintf(`(resource.type="k8s_control_plane_component" AND resource.labels.component_name=(%s))`, strings.Join(names, " OR ")))
+	}
+	for _, c := range s.components {
+		if c == componentAudit {
+			terms = append(terms, fmt.Sprintf(`(resource.type="k8s_cluster" AND logName="projects/%s/logs/%s")`, s.project, gkeAuditActivityLog))
+		}
+	}
+
+	f := fmt.Sprintf(`resource.labels.cluster_name=%q AND (%s) AND timestamp>=%q`, s.cluster, strings.Join(terms, " OR "), start.UTC().Format(time.RFC3339Nano))
+	if s.location != "" {
+		f += fmt.Sprintf(` AND resource.labels.location=%q`, s.location)
+	}
+	if !end.IsZero() {
+		f += fmt.Sprintf(` AND timestamp<=%q`, end.UTC().Format(time.RFC3339Nano))
+	}
+
+	return f
+}
+
+type gkeRequestT struct {
+	ResourceNames []string `json:"resourceNames"`
+	Filter        string   `json:"filter"`
+	OrderBy       string   `json:"orderBy"`
+	PageSize      int      `json:"pageSize"`
+	PageToken     string   `json:"pageToken,omitempty"`
+}
+
+type gkeResponseT struct {
+	Entries []struct {
+		Timestamp    time.Time      `json:"timestamp"`
+		TextPayload  string         `json:"textPayload"`
+		JsonPayload  map[string]any `json:"jsonPayload"`
+		ProtoPayload map[string]any `json:"protoPayload"`
+	} `json:"entries"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func resolveGke(location datasrc.Location, opts ...OptT) ([]LogSrcI, error) {
+
+	spec, err := parseGke(location.Path, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if location.Window != 0 {
+		opts = append(opts, WithWindow(int64(location.Window)))
+	}
+
+	var (
+		follow = parseOpts(opts...).follow != nil
+		client = httpz.New(httpz.WithName(locationGke), httpz.WithTimeout(gkeClientTimeout))
+		src    = newRemoteSrc(locationGke+":"+spec.cluster, gkeFetch(client, spec, follow), opts...)
+	)
+
+	return []LogSrcI{src}, nil
+}
+
+// gkeFetch pages through entries:list in time order. In follow mode it
+// polls for entries newer than the last one seen once caught up.
+func gkeFetch(client *http.Client, spec *gkeSpec, follow bool) fetchT {
+
+	var (
+		token string
+		from  = spec.start
+		bound boundaryT
+		done  bool
+	)
+
+	return func(ctx context.Context) ([]eventT, error) {
+
+		if done {
+			if !follow {
+				return nil, io.EOF
+			}
+			if err := pollWait(ctx, defaultPollInterval); err != nil {
+				return nil, err
+			}
+			// Resume at the newest timestamp delivered so entries
+			// written later with that same timestamp are not lost.
+			from = bound.from(spec.start)
+			token, done = "", false
+		}
+
+		end := spec.end
+		if follow {
+			end = time.Time{}
+		}
+
+		resp, err := gkeList(ctx, client, spec, gkeRequestT{
+			ResourceNames: []string{"projects/" + spec.project},
+			Filter:        spec.filter(from, end),
+			OrderBy:       "timestamp asc",
+			PageSize:      gkePageSize,
+			PageToken:     token,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		events := make([]eventT, 0, len(resp.Entries))
+		for _, e := range resp.Entries {
+			line := e.TextPayload
+			switch {
+			case e.JsonPayload != nil:
+				line = eventMessage(e.JsonPayload)
+			case e.ProtoPayload != nil:
+				line = eventMessage(e.ProtoPayload)
+			}
+
+			ev := eventT{ts: e.Timestamp, line: strings.TrimRight(line, "\n")}
+			if bound.keep(ev) {
+				events = append(events, ev)
+			}
+		}
+
+		if token = resp.NextPageToken; token == "" {
+			done = true
+		}
+
+		return events, nil
+	}
+}
+
+func gkeList(ctx context.Context, client *http.Client, spec *gkeSpec, body gkeRequestT) (*gkeResponseT, error) {
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gkeEndpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv(spec.tokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("cloud logging query failed: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var out gkeResponseT
+	if err = json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
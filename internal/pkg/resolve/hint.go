@@ -0,0 +1,207 @@
+package resolve
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/prequel-logmatch/pkg/format"
+)
+
+// Format hints skip timestamp detection for input whose shape is known,
+// e.g. `kubectl logs -f ... | preq --stdin-format cri`.
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+	FormatPlain  = "plain"
+	FormatCRI    = "cri"
+
+	FactoryJSONLines = "json_lines"
+	FactoryPlain     = "plain"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown format")
+)
+
+var Formats = []string{FormatJSON, FormatLogfmt, FormatPlain, FormatCRI}
+
+// jsonTimeKeys are tried in order for the timestamp of a JSON line.
+var jsonTimeKeys = []string{"time", "ts", "timestamp", "@timestamp"}
+
+// WithFormat parses input as the named format instead of detecting it.
+func WithFormat(name string) func(*optsT) {
+	return func(o *optsT) {
+		o.format = name
+	}
+}
+
+func ValidateFormat(name string) error {
+	for _, f := range Formats {
+		if name == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q, expected one of %s", ErrUnknownFormat, name, strings.Join(Formats, ", "))
+}
+
+// hintedFactory returns the parser for a format hint. The sample only
+// provides the first timestamp; nothing else is detected.
+func hintedFactory(name string, data []byte, o *optsT) (format.FactoryI, int64, error) {
+
+	var factory format.FactoryI
+
+	switch name {
+	case FormatJSON:
+		factory = &jsonLinesFactoryT{loc: o.location}
+	case FormatLogfmt:
+		factory = &logfmtFactoryT{loc: o.location}
+	case FormatPlain:
+		factory = &plainFactoryT{}
+	case FormatCRI:
+		// The format package only builds CRI parsers by detection
+		var err error
+		if factory, _, err = format.Detect(strings.NewReader("1970-01-01T00:00:00Z stdout F -\n")); err != nil {
+			return nil, -1, err
+		}
+	default:
+		return nil, -1, ValidateFormat(name)
+	}
+
+	ts, err := firstStamp(factory.New(), data, o.timestampTries)
+	if err != nil {
+		return nil, -1, fmt.Errorf("input is not %s: %w", name, err)
+	}
+	return factory, ts, nil
+}
+
+// firstStamp returns the timestamp of the first parseable line of data,
+// skipping up to maxTries lines.
+func firstStamp(parser format.ParserI, data []byte, maxTries int) (int64, error) {
+
+	err := error(format.ErrMatchTimestamp)
+
+	for try := 0; len(data) > 0 && try <= maxTries; try++ {
+		line := data
+		if idx := bytes.IndexByte(data, '\n'); idx >= 0 {
+			line, data = data[:idx], data[idx+1:]
+		} else {
+			data = nil
+		}
+
+		var entry format.LogEntry
+		if entry, err = parser.ReadEntry(line); err == nil {
+			return entry.Timestamp, nil
+		}
+	}
+
+	return -1, err
+}
+
+// jsonLinesFactoryT reads one JSON object per line. The timestamp is the
+// first of jsonTimeKeys, a date string or an epoch in any unit. Docker
+// JSON lines keep only their "log" and "stream" fields.
+type jsonLinesFactoryT struct {
+	loc *time.Location
+}
+
+type jsonLinesFmtT struct {
+	loc *time.Location
+}
+
+func (f *jsonLinesFactoryT) New() format.ParserI {
+	return &jsonLinesFmtT{loc: f.loc}
+}
+
+func (f *jsonLinesFactoryT) String() string {
+	return FactoryJSONLines
+}
+
+func (f *jsonLinesFmtT) ReadTimestamp(rdr io.Reader) (int64, error) {
+	return readFirstTimestamp(f, rdr)
+}
+
+func (f *jsonLinesFmtT) ReadEntry(line []byte) (entry format.LogEntry, err error) {
+
+	var doc map[string]json.RawMessage
+	if err = json.Unmarshal(line, &doc); err != nil {
+		err = errors.Join(format.ErrJsonUnmarshal, err)
+		return
+	}
+
+	for _, key := range jsonTimeKeys {
+		raw, ok := doc[key]
+		if !ok {
+			continue
+		}
+
+		var v string
+		if json.Unmarshal(raw, &v) != nil {
+			v = string(raw) // a number
+		}
+		if entry.Timestamp, err = parseLogfmtTime(v, f.loc); err != nil {
+			return
+		}
+
+		var msg string
+		if raw, ok := doc["log"]; ok && json.Unmarshal(raw, &msg) == nil {
+			entry.Line = strings.TrimRight(msg, "\n")
+			if raw, ok := doc["stream"]; ok {
+				_ = json.Unmarshal(raw, &entry.Stream)
+			}
+		} else {
+			entry.Line = string(bytes.TrimRight(line, "\r\n"))
+		}
+		return
+	}
+
+	err = format.ErrMatchTimestamp
+	return
+}
+
+// plainFactoryT reads lines without timestamps, stamping each with the
+// time it was read. Stamps never go backwards, so order is preserved.
+type plainFactoryT struct{}
+
+type plainFmtT struct {
+	last int64
+}
+
+func (f *plainFactoryT) New() format.ParserI {
+	return &plainFmtT{}
+}
+
+func (f *plainFactoryT) String() string {
+	return FactoryPlain
+}
+
+func (f *plainFmtT) ReadTimestamp(rdr io.Reader) (int64, error) {
+	return readFirstTimestamp(f, rdr)
+}
+
+func (f *plainFmtT) ReadEntry(line []byte) (entry format.LogEntry, err error) {
+	ts := time.Now().UnixNano()
+	if ts <= f.last {
+		ts = f.last + 1
+	}
+	f.last = ts
+
+	entry.Line = string(bytes.TrimRight(line, "\r\n"))
+	entry.Timestamp = ts
+	return
+}
+
+// readFirstTimestamp parses the first line of rdr with p.
+func readFirstTimestamp(p format.ParserI, rdr io.Reader) (int64, error) {
+	line, err := bufio.NewReader(io.LimitReader(rdr, format.MaxRecordSize)).ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return 0, err
+	}
+	entry, err := p.ReadEntry(line)
+	return entry.Timestamp, err
+}
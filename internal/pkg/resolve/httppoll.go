@@ -0,0 +1,384 @@
+package resolve
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+)
+
+// An HTTP location fetches a URL and feeds the response into preq. In
+// follow mode the URL is polled. The query string is sent as is; preq
+// options go in the fragment, which is never sent:
+//
+//	locations:
+//	  - type: http
+//	    path: https://app.internal/api/logs?level=warn#format=json&items=data.entries&cursor=after&cursor_from=json:next&interval=30s
+//
+// Options: interval (poll period in follow mode; default 5s), format (lines
+// or json; default lines), items (dotted path to the array of entries in a
+// json response; default the whole body), ts_field and msg_field (default
+// timestamp and message), cursor (query parameter carrying the cursor),
+// cursor_from (where the next cursor comes from: header:<name>, json:<path>
+// or last_ts for the RFC3339 timestamp of the newest entry), token_env and
+// header_env (<header>:<variable>, may repeat) for credentials.
+//
+// Lines format entries may start with an RFC3339 timestamp; others get the
+// fetch time. Without a cursor, entries not newer than the last one seen are
+// dropped, and lines without a timestamp that the previous response held,
+// so a plain /logs endpoint can be polled repeatedly. A cursor needs
+// cursor_from to say where it comes from. A response larger than 64MiB is
+// an error rather than cut short.
+
+const (
+	locationHttp        = "http"
+	httpFormatLines     = "lines"
+	httpFormatJson      = "json"
+	httpCursorHeader    = "header:"
+	httpCursorJson      = "json:"
+	httpCursorLastTs    = "last_ts"
+	httpDefaultTsField  = "timestamp"
+	httpDefaultMsgField = "message"
+	httpClientTimeout   = 30 * time.Second
+	httpMaxBody         = 64 * 1024 * 1024
+)
+
+var (
+	ErrHttpBody = errors.New("http response larger than 64MiB")
+)
+
+type httpSpec struct {
+	url        *url.URL
+	interval   time.Duration
+	format     string
+	items      string
+	tsField    string
+	msgField   string
+	cursor     string
+	cursorFrom string
+	headers    map[string]string // header name to environment variable
+	tokenEnv   string
+}
+
+func parseHttpPoll(path string) (*httpSpec, error) {
+
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		return nil, err
+	}
+	u.Fragment, u.RawFragment = "", ""
+
+	spec := &httpSpec{
+		url:        u,
+		interval:   defaultPollInterval,
+		format:     httpFormatLines,
+		items:      params.Get("items"),
+		tsField:    httpDefaultTsField,
+		msgField:   httpDefaultMsgField,
+		cursor:     params.Get("cursor"),
+		cursorFrom: params.Get("cursor_from"),
+		headers:    make(map[string]string),
+		tokenEnv:   params.Get("token_env"),
+	}
+
+	if f := params.Get("format"); f != "" {
+		if f != httpFormatLines && f != httpFormatJson {
+			return nil, fmt.Errorf("invalid format %q: expected lines or json", f)
+		}
+		spec.format = f
+	}
+	if f := params.Get("ts_field"); f != "" {
+		spec.tsField = f
+	}
+	if f := params.Get("msg_field"); f != "" {
+		spec.msgField = f
+	}
+
+	if i := params.Get("interval"); i != "" {
+		if spec.interval, err = time.ParseDuration(i); err != nil || spec.interval <= 0 {
+			return nil, fmt.Errorf("invalid interval %q", i)
+		}
+	}
+
+	switch {
+	case spec.cursorFrom == "", spec.cursorFrom == httpCursorLastTs:
+	case strings.HasPrefix(spec.cursorFrom, httpCursorHeader):
+	case strings.HasPrefix(spec.cursorFrom, httpCursorJson):
+		if spec.format != httpFormatJson {
+			return nil, fmt.Errorf("cursor_from %q requires format=json", spec.cursorFrom)
+		}
+	default:
+		return nil, fmt.Errorf("invalid cursor_from %q", spec.cursorFrom)
+	}
+	if spec.cursorFrom != "" && spec.cursor == "" {
+		return nil, fmt.Errorf("cursor_from requires cursor")
+	}
+	if spec.cursor != "" && spec.cursorFrom == "" {
+		return nil, fmt.Errorf("cursor requires cursor_from")
+	}
+
+	for _, h := range params["header_env"] {
+		name, env, ok := strings.Cut(h, ":")
+		if !ok || name == "" || env == "" {
+			return nil, fmt.Errorf("invalid header_env %q: expected <header>:<variable>", h)
+		}
+		spec.headers[name] = env
+	}
+
+	return spec, nil
+}
+
+func resolveHttpPoll(location datasrc.Location, opts ...OptT) ([]LogSrcI, error) {
+
+	spec, err := parseHttpPoll(location.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if location.Window != 0 {
+		opts = append(opts, WithWindow(int64(location.Window)))
+	}
+
+	var (
+		follow = parseOpts(opts...).follow != nil
+		client = httpz.New(httpz.WithName(locationHttp), httpz.WithTimeout(httpClientTimeout))
+		src    = newRemoteSrc(locationHttp+":"+spec.url.Redacted(), httpPollFetch(client, spec, follow), opts...)
+	)
+
+	return []LogSrcI{src}, nil
+}
+
+// httpPollFetch follows the cursor until it stops changing, then either
+// finishes or, in follow mode, waits for the next poll.
+func httpPollFetch(client *http.Client, spec *httpSpec, follow bool) fetchT {
+
+	var (
+		cursor string
+		last   time.Time
+		wait   bool
+		seen   map[string]int // lines without a timestamp in the last response
+	)
+
+	return func(ctx context.Context) ([]eventT, error) {
+
+		if wait {
+			if !follow {
+				return nil, io.EOF
+			}
+			if err := pollWait(ctx, spec.interval); err != nil {
+				return nil, err
+			}
+			wait = false
+		}
+
+		events, next, err := httpPoll(ctx, client, spec, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		// Without a cursor, drop what an earlier poll already delivered:
+		// entries by their timestamp, and lines without one by the count of
+		// each in the last response
+		var (
+			fresh  = events
+			counts = make(map[string]int)
+		)
+		if spec.cursor == "" {
+			fresh = events[:0]
+			for _, ev := range events {
+				switch {
+				case ev.ts.IsZero():
+					if counts[ev.line]++; counts[ev.line] > seen[ev.line] {
+						fresh = append(fresh, ev)
+					}
+				case ev.ts.After(last):
+					fresh = append(fresh, ev)
+				}
+			}
+			seen = counts
+		}
+		for _, ev := range fresh {
+			if ev.ts.After(last) {
+				last = ev.ts
+			}
+		}
+
+		now := time.Now()
+		for i := range fresh {
+			if fresh[i].ts.IsZero() {
+				fresh[i].ts = now
+			}
+		}
+
+		if spec.cursorFrom == httpCursorLastTs && !last.IsZero() {
+			next = last.UTC().Format(time.RFC3339Nano)
+		}
+
+		// Another page is only requested while the cursor advances
+		if spec.cursorFrom == "" || next == "" || next == cursor || len(events) == 0 {
+			wait = true
+		}
+		if next != "" {
+			cursor = next
+		}
+
+		return fresh, nil
+	}
+}
+
+func httpPoll(ctx context.Context, client *http.Client, spec *httpSpec, cursor string) ([]eventT, string, error) {
+
+	u := *spec.url
+	if spec.cursor != "" && cursor != "" {
+		q := u.Query()
+		q.Set(spec.cursor, cursor)
+		u.RawQuery = q.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if spec.tokenEnv != "" {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(spec.tokenEnv))
+	}
+	for name, env := range spec.headers {
+		req.Header.Set(name, os.Getenv(env))
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, "", fmt.Errorf("http poll failed: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, httpMaxBody+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(body) > httpMaxBody {
+		return nil, "", ErrHttpBody
+	}
+
+	var next string
+	if name, ok := strings.CutPrefix(spec.cursorFrom, httpCursorHeader); ok {
+		next = res.Header.Get(name)
+	}
+
+	if spec.format == httpFormatLines {
+		return httpLines(body), next, nil
+	}
+
+	events, jsonNext, err := httpJson(body, spec)
+	if err != nil {
+		return nil, "", err
+	}
+	if jsonNext != "" {
+		next = jsonNext
+	}
+	return events, next, nil
+}
+
+// httpLines leaves the time of lines without a timestamp zero.
+func httpLines(body []byte) []eventT {
+
+	var (
+		events []eventT
+		sc     = bufio.NewScanner(bytes.NewReader(body))
+	)
+	sc.Buffer(nil, httpMaxBody)
+
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			continue
+		}
+		ev := eventT{line: line}
+		if stamp, msg, ok := strings.Cut(line, " "); ok {
+			if ts, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+				ev.ts, ev.line = ts, msg
+			}
+		}
+		events = append(events, ev)
+	}
+
+	return events
+}
+
+func httpJson(body []byte, spec *httpSpec) ([]eventT, string, error) {
+
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if path, ok := strings.CutPrefix(spec.cursorFrom, httpCursorJson); ok {
+		if obj, ok := doc.(map[string]any); ok {
+			if v := lookupField(obj, path); v != nil {
+				next = fmt.Sprint(v)
+			}
+		}
+	}
+
+	items := doc
+	if spec.items != "" {
+		obj, ok := doc.(map[string]any)
+		if !ok {
+			return nil, "", fmt.Errorf("items %q: response is not an object", spec.items)
+		}
+		items = lookupField(obj, spec.items)
+	}
+
+	list, ok := items.([]any)
+	if !ok {
+		if items == nil {
+			return nil, next, nil
+		}
+		return nil, "", fmt.Errorf("items %q: not an array", spec.items)
+	}
+
+	events := make([]eventT, 0, len(list))
+	for _, item := range list {
+		obj, ok := item.(map[string]any)
+		if !ok {
+			return nil, "", fmt.Errorf("items %q: entries must be objects", spec.items)
+		}
+
+		ts, err := eventTime(lookupField(obj, spec.tsField))
+		if err != nil {
+			return nil, "", fmt.Errorf("field %s: %w", spec.tsField, err)
+		}
+
+		msg := lookupField(obj, spec.msgField)
+		if msg == nil {
+			msg = obj
+		}
+
+		events = append(events, eventT{ts: ts, line: eventMessage(msg)})
+	}
+
+	return events, next, nil
+}
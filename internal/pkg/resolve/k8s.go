@@ -0,0 +1,288 @@
+package resolve
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// A Kubernetes location streams pod logs straight from the API server.
+// Select one pod, or every pod matching a label selector:
+//
+//	locations:
+//	  - path: k8s://prod/api-7d9f8b6c5-x2x9z/server
+//	  - path: k8s://prod?selector=app=api,tier=web&since=2h
+//
+// The path is k8s://namespace/pod[/container]. Without a container every
+// container in the pod is read. Parameters: selector (instead of a pod),
+// container, since (duration; default all retained logs), previous (read
+// the previous container instance), context and kubeconfig. Credentials
+// come from the kubeconfig, or the service account when run in a cluster.
+
+const (
+	locationK8s  = "k8s"
+	k8sScheme    = "k8s://"
+	k8sBatchSize = 1000
+	k8sTimeout   = 30 * time.Second // to look up pods, or open a log stream
+)
+
+var (
+	ErrMissingPod = errors.New("missing pod or selector")
+	ErrNoPods     = errors.New("no pods found")
+)
+
+type k8sSpec struct {
+	namespace  string
+	pod        string
+	container  string
+	selector   string
+	since      time.Duration
+	previous   bool
+	context    string
+	kubeconfig string
+}
+
+func isK8sPath(path string) bool {
+	return strings.HasPrefix(path, k8sScheme)
+}
+
+func parseK8s(path string) (*k8sSpec, error) {
+
+	rest, query, _ := strings.Cut(strings.TrimPrefix(path, k8sScheme), "?")
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	if len(parts) > 3 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid kubernetes path %q: expected k8s://namespace/pod[/container]", path)
+	}
+
+	spec := &k8sSpec{
+		namespace:  parts[0],
+		selector:   params.Get("selector"),
+		container:  params.Get("container"),
+		previous:   params.Get("previous") == "true",
+		context:    params.Get("context"),
+		kubeconfig: params.Get("kubeconfig"),
+	}
+
+	if len(parts) > 1 {
+		spec.pod = parts[1]
+	}
+	if len(parts) > 2 {
+		spec.container = parts[2]
+	}
+
+	switch {
+	case spec.pod == "" && spec.selector == "":
+		return nil, ErrMissingPod
+	case spec.pod != "" && spec.selector != "":
+		return nil, errors.New("pod and selector are mutually exclusive")
+	}
+
+	if s := params.Get("since"); s != "" {
+		if spec.since, err = time.ParseDuration(s); err != nil || spec.since <= 0 {
+			return nil, fmt.Errorf("invalid since %q", s)
+		}
+	}
+
+	return spec, nil
+}
+
+// newK8sClient is a variable so tests can substitute a fake clientset.
+var newK8sClient = func(spec *k8sSpec) (kubernetes.Interface, error) {
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if spec.kubeconfig != "" {
+		rules.ExplicitPath = spec.kubeconfig
+	}
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: spec.context}
+
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(cfg)
+}
+
+func resolveK8s(location datasrc.Location, opts ...OptT) ([]LogSrcI, error) {
+
+	spec, err := parseK8s(location.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newK8sClient(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), k8sTimeout)
+	defer cancel()
+
+	var pods []v1.Pod
+	if spec.pod != "" {
+		pod, err := client.CoreV1().Pods(spec.namespace).Get(ctx, spec.pod, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, *pod)
+	} else {
+		list, err := client.CoreV1().Pods(spec.namespace).List(ctx, metav1.ListOptions{LabelSelector: spec.selector})
+		if err != nil {
+			return nil, err
+		}
+		pods = list.Items
+	}
+
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoPods, location.Path)
+	}
+
+	if location.Window != 0 {
+		opts = append(opts, WithWindow(int64(location.Window)))
+	}
+
+	var (
+		follow = parseOpts(opts...).follow != nil
+		srcs   []LogSrcI
+	)
+
+	for _, pod := range pods {
+
+		containers := []string{spec.container}
+		if spec.container == "" {
+			containers = containers[:0]
+			for _, c := range pod.Spec.Containers {
+				containers = append(containers, c.Name)
+			}
+		}
+
+		// Each pod reports its own labels, not those it shares with others
+		labels := &labelSetT{}
+		podLabels := map[string]string{"namespace": pod.Namespace}
+		for k, v := range pod.Labels {
+			podLabels[k] = v
+		}
+		labels.add(podLabels)
+
+		for _, container := range containers {
+			logOpts := &v1.PodLogOptions{
+				Container:  container,
+				Follow:     follow,
+				Previous:   spec.previous,
+				Timestamps: true,
+			}
+			if spec.since > 0 {
+				secs := int64(spec.since.Seconds())
+				logOpts.SinceSeconds = &secs
+			}
+
+			name := fmt.Sprintf("%s%s/%s/%s", k8sScheme, pod.Namespace, pod.Name, container)
+			fetch := k8sFetch(client, pod.Namespace, pod.Name, logOpts)
+			srcs = append(srcs, &labeledSrc{remoteSrc: newRemoteSrc(name, fetch, opts...), labels: labels})
+		}
+	}
+
+	return srcs, nil
+}
+
+// k8sFetch streams the container log. Each line is prefixed with its
+// RFC3339Nano timestamp; lines without one inherit the previous timestamp.
+func k8sFetch(client kubernetes.Interface, namespace, pod string, logOpts *v1.PodLogOptions) fetchT {
+
+	var (
+		body io.ReadCloser
+		br   *bufio.Reader
+		last time.Time
+	)
+
+	return func(ctx context.Context) ([]eventT, error) {
+
+		if br == nil {
+			var err error
+			if body, err = k8sStream(ctx, client, namespace, pod, logOpts); err != nil {
+				return nil, err
+			}
+			br = bufio.NewReader(body)
+		}
+
+		var events []eventT
+		for len(events) < k8sBatchSize {
+			line, err := br.ReadBytes('\n')
+			if len(line) > 0 {
+				line = bytes.TrimRight(line, "\r\n")
+				if stamp, msg, ok := bytes.Cut(line, []byte{' '}); ok {
+					if ts, perr := time.Parse(time.RFC3339Nano, string(stamp)); perr == nil {
+						last, line = ts, msg
+					}
+				}
+				if last.IsZero() {
+					last = time.Now()
+				}
+				events = append(events, eventT{ts: last, line: string(line)})
+			}
+
+			if err != nil {
+				body.Close()
+				return events, err
+			}
+
+			// Hand over what is buffered rather than wait for a full batch
+			if br.Buffered() == 0 {
+				break
+			}
+		}
+
+		return events, nil
+	}
+}
+
+// k8sStream opens the container log, giving up if the API server does not
+// answer within k8sTimeout. Once open the stream is read for as long as it
+// lasts, so the deadline cannot simply be set on ctx.
+func k8sStream(ctx context.Context, client kubernetes.Interface, namespace, pod string, logOpts *v1.PodLogOptions) (io.ReadCloser, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(k8sTimeout, cancel)
+
+	body, err := client.CoreV1().Pods(namespace).GetLogs(pod, logOpts).Stream(ctx)
+	if !timer.Stop() && err == nil {
+		body.Close()
+		err = context.DeadlineExceeded
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &cancelCloser{ReadCloser: body, cancel: cancel}, nil
+}
+
+// cancelCloser releases the context of a stream when the stream is closed.
+type cancelCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
@@ -7,6 +7,7 @@ import "errors"
 type LogData struct {
 	name    string
 	srcType string
+	labels  map[string]string
 	Logs    []LogSrcI
 }
 
@@ -31,8 +32,37 @@ func (ld *LogData) Type() string {
 	return logType
 }
 
+// labelerI is implemented by sources that carry labels, e.g. Loki streams.
+type labelerI interface {
+	Labels() map[string]string
+}
+
+// Meta returns the labels shared by the underlying logs, if any, overlaid
+// with the labels configured on the source. Logs that have not reported
+// labels yet, e.g. remote streams not read from, are left out.
 func (ld *LogData) Meta() map[string]string {
-	return nil
+	var (
+		meta   map[string]string
+		shared labelSetT
+	)
+	add := func(labels map[string]string) {
+		for k, v := range labels {
+			if meta == nil {
+				meta = make(map[string]string)
+			}
+			meta[k] = v
+		}
+	}
+	for _, log := range ld.Logs {
+		if l, ok := log.(labelerI); ok {
+			if labels := l.Labels(); len(labels) > 0 {
+				shared.add(labels)
+			}
+		}
+	}
+	add(shared.labels)
+	add(ld.labels)
+	return meta
 }
 
 func (ld *LogData) Size() int64 {
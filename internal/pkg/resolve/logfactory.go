@@ -2,6 +2,10 @@ package resolve
 
 import (
 	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/prequel-dev/prequel-logmatch/pkg/format"
 	"github.com/prequel-dev/prequel-logmatch/pkg/timez"
@@ -34,18 +38,107 @@ func WithStampRegex(stampRegex ...FmtSpec) func(*optsT) {
 	}
 }
 
+// WithSourceStamps tries specs before format detection, in place of the
+// global timestamp regexes.
+func WithSourceStamps(specs ...FmtSpec) func(*optsT) {
+	return func(o *optsT) {
+		o.srcStamps = specs
+	}
+}
+
 func WithWindow(window int64) func(*optsT) {
 	return func(o *optsT) {
 		o.window = window
 	}
 }
 
+// WithSourceWindows overrides the default reorder window per source type.
+func WithSourceWindows(windows map[string]time.Duration) func(*optsT) {
+	return func(o *optsT) {
+		o.sourceWindows = windows
+	}
+}
+
+// WithRange restricts every resolved log to part of its content.
+func WithRange(r *RangeSpec) func(*optsT) {
+	return func(o *optsT) {
+		o.srcRange = r
+	}
+}
+
+// WithTokenizer splits every resolved log into records instead of lines.
+func WithTokenizer(t *TokenizerSpec) func(*optsT) {
+	return func(o *optsT) {
+		o.tokenizer = t
+	}
+}
+
+// WithRotated also resolves the rotated siblings of each log file, so
+// app.log is scanned together with app.log.1, app.log.2.gz and so on.
+func WithRotated() func(*optsT) {
+	return func(o *optsT) {
+		o.rotated = true
+	}
+}
+
+// WithResume skips, per source, the lines stamped before the time a
+// checkpoint resumes it from. Sources are keyed by SourceKey.
+func WithResume(resume map[string]int64) func(*optsT) {
+	return func(o *optsT) {
+		o.resume = resume
+	}
+}
+
+// SourceKey identifies a source across runs.
+func SourceKey(name, srcType string) string {
+	return srcType + "/" + name
+}
+
+// WithBegin drops the lines of every resolved log written before begin.
+func WithBegin(begin time.Time) func(*optsT) {
+	return func(o *optsT) {
+		o.begin = begin.UnixNano()
+	}
+}
+
+// WithYear assigns year to timestamps written without one. By default
+// each file's modification time decides.
+func WithYear(year int) func(*optsT) {
+	return func(o *optsT) {
+		// Any December reference keeps every month in year, in any zone
+		o.yearRef = time.Date(year, time.December, 15, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// withYearRef infers missing years relative to when a log was written.
+func withYearRef(ref time.Time) func(*optsT) {
+	return func(o *optsT) {
+		o.yearRef = ref
+	}
+}
+
 func WithTimestampTries(tries int) func(*optsT) {
 	return func(o *optsT) {
 		o.timestampTries = tries
 	}
 }
 
+// WithFollow keeps reading past EOF, polling for appended data until ctx
+// is cancelled. Only the newest file of a glob location is followed.
+func WithFollow(ctx context.Context) func(*optsT) {
+	return func(o *optsT) {
+		o.follow = ctx
+	}
+}
+
+// WithContext stops resolving when ctx is done, removing the archive
+// members already extracted.
+func WithContext(ctx context.Context) func(*optsT) {
+	return func(o *optsT) {
+		o.ctx = ctx
+	}
+}
+
 func (o *optsT) tryCustom() bool {
 	return o.customFmt != "" || o.customRegex != ""
 }
@@ -62,8 +155,23 @@ func NewLogFactory(data []byte, opts ...OptT) (format.FactoryI, int64, error) {
 
 	log.Debug().Int("maxTries", maxTries).Msg("Trying custom timestamp format")
 
+	if o.format != "" {
+		return hintedFactory(o.format, data, o)
+	}
+
 	if o.tryCustom() {
-		return timez.TryTimestampFormat(o.customRegex, timez.TimestampFmt(o.customFmt), data, maxTries)
+		return tryStamp(FmtSpec{Pattern: o.customRegex, Format: TimestampFmt(o.customFmt)}, data, o)
+	}
+
+	// Source timestamps take precedence over detection and replace the
+	// global regexes
+	if len(o.srcStamps) > 0 {
+		for _, spec := range o.srcStamps {
+			if factory, stamp, err = tryStamp(spec, data, o); err == nil {
+				return factory, stamp, nil
+			}
+		}
+		log.Debug().Err(err).Msg("No source timestamp matched, detecting format")
 	}
 
 	// Detect format
@@ -71,9 +179,29 @@ func NewLogFactory(data []byte, opts ...OptT) (format.FactoryI, int64, error) {
 		return factory, stamp, nil
 	}
 
+	stamps := o.stampRegex
+	if len(o.srcStamps) > 0 {
+		stamps = nil
+	}
+
+	// Timestamp regexes configured beyond the defaults describe the logs
+	// better than any guess, so logfmt and epoch lines are only detected
+	// without them
+	if len(customStamps(stamps)) == 0 {
+		// Key=value lines carry their timestamp in a ts or time field
+		if factory, stamp, err = detectLogfmt(data, maxTries, o.location); err == nil {
+			return factory, stamp, nil
+		}
+
+		// Lines led by a bare epoch in any unit
+		if factory, stamp, err = detectEpoch(data, maxTries); err == nil {
+			return factory, stamp, nil
+		}
+	}
+
 	// Failed to detect format, try timestamp regexes if any
-	for _, spec := range o.stampRegex {
-		if factory, stamp, err = timez.TryTimestampFormat(spec.Pattern, spec.Format, data, maxTries); err == nil {
+	for _, spec := range stamps {
+		if factory, stamp, err = tryStamp(spec, data, o); err == nil {
 			break
 		}
 	}
@@ -86,16 +214,45 @@ func NewLogFactory(data []byte, opts ...OptT) (format.FactoryI, int64, error) {
 	return factory, stamp, nil
 }
 
+// customStamps returns the specs that are not among the default timestamp
+// regexes, which a config lists unless told otherwise. The config trims
+// them, so they are compared trimmed.
+func customStamps(specs []FmtSpec) []FmtSpec {
+	var out []FmtSpec
+	for _, spec := range specs {
+		isDefault := slices.ContainsFunc(timez.Defaults, func(d timez.FmtSpec) bool {
+			return strings.TrimSpace(d.Pattern) == strings.TrimSpace(spec.Pattern) &&
+				strings.TrimSpace(string(d.Format)) == strings.TrimSpace(string(spec.Format))
+		})
+		if !isDefault {
+			out = append(out, spec)
+		}
+	}
+	return out
+}
+
 type optsT struct {
 	customFmt      string
 	customRegex    string
 	stampRegex     []FmtSpec
+	srcStamps      []FmtSpec
 	window         int64
 	timestampTries int
+	follow         context.Context
+	sourceWindows  map[string]time.Duration
+	srcRange       *RangeSpec
+	tokenizer      *TokenizerSpec
+	rotated        bool
+	begin          int64
+	location       *time.Location
+	yearRef        time.Time
+	format         string
+	resume         map[string]int64
+	ctx            context.Context
 }
 
 func parseOpts(opts ...OptT) *optsT {
-	o := &optsT{}
+	o := &optsT{ctx: context.Background()}
 	for _, opt := range opts {
 		opt(o)
 	}
@@ -0,0 +1,220 @@
+package resolve
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/prequel-logmatch/pkg/format"
+)
+
+// logfmt lines are space separated key=value pairs, with values optionally
+// double quoted, as written by Heroku and many Go services:
+//
+//	ts=2024-05-01T10:00:00.123Z level=error msg="dial tcp: timeout" peer=10.0.0.7
+//
+// The timestamp comes from the first of the ts, time, timestamp or t keys.
+
+const (
+	FactoryLogfmt = "logfmt"
+
+	// A line needs at least this many pairs to be taken as logfmt
+	logfmtMinPairs = 2
+)
+
+var logfmtTimeKeys = []string{"ts", "time", "timestamp", "t"}
+
+var logfmtLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+}
+
+type logfmtFactoryT struct {
+	loc *time.Location
+}
+
+type logfmtFmtT struct {
+	loc *time.Location
+}
+
+func (f *logfmtFactoryT) New() format.ParserI {
+	return &logfmtFmtT{loc: f.loc}
+}
+
+func (f *logfmtFactoryT) String() string {
+	return FactoryLogfmt
+}
+
+func (f *logfmtFmtT) ReadTimestamp(rdr io.Reader) (int64, error) {
+	return readFirstTimestamp(f, rdr)
+}
+
+func (f *logfmtFmtT) ReadEntry(line []byte) (entry format.LogEntry, err error) {
+
+	pairs := parseLogfmt(line)
+	if len(pairs) < logfmtMinPairs {
+		err = format.ErrMatchTimestamp
+		return
+	}
+
+	for _, key := range logfmtTimeKeys {
+		v, ok := pairs[key]
+		if !ok {
+			continue
+		}
+		if entry.Timestamp, err = parseLogfmtTime(v, f.loc); err != nil {
+			return
+		}
+		entry.Line = string(bytes.TrimRight(line, "\r\n"))
+		return
+	}
+
+	err = format.ErrMatchTimestamp
+	return
+}
+
+// detectLogfmt tries the first lines of data, skipping up to maxTries
+// lines that are not logfmt with a timestamp.
+func detectLogfmt(data []byte, maxTries int, loc *time.Location) (format.FactoryI, int64, error) {
+
+	var (
+		f   = logfmtFmtT{loc: loc}
+		err = format.ErrMatchTimestamp
+	)
+
+	for try := 0; len(data) > 0 && (maxTries <= 0 || try < maxTries); try++ {
+		line := data
+		if idx := bytes.IndexByte(data, '\n'); idx >= 0 {
+			line, data = data[:idx], data[idx+1:]
+		} else {
+			data = nil
+		}
+
+		var entry format.LogEntry
+		if entry, err = f.ReadEntry(line); err == nil {
+			return &logfmtFactoryT{loc: loc}, entry.Timestamp, nil
+		}
+	}
+
+	return nil, -1, err
+}
+
+// ParseLogfmt returns the key=value pairs on a line, or nil if it is not
+// logfmt.
+func ParseLogfmt(line []byte) map[string]string {
+	return parseLogfmt(line)
+}
+
+// parseLogfmt returns the pairs on a line, or nil if any token is not a
+// key=value pair. The first occurrence of a key wins.
+func parseLogfmt(line []byte) map[string]string {
+
+	var (
+		pairs = make(map[string]string)
+		i     = 0
+	)
+
+	for i < len(line) {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t' || line[i] == '\r' || line[i] == '\n') {
+			i++
+		}
+
+		start := i
+		for i < len(line) && line[i] > ' ' && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		if i == start {
+			// Not a key; logfmt cannot contain free text
+			if i < len(line) {
+				return nil
+			}
+			break
+		}
+		key := string(line[start:i])
+
+		if i >= len(line) || line[i] != '=' {
+			return nil
+		}
+		i++
+
+		var val string
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil
+			}
+			s, err := strconv.Unquote(string(line[i : end+1]))
+			if err != nil {
+				return nil
+			}
+			val, i = s, end+1
+		} else {
+			start = i
+			for i < len(line) && line[i] > ' ' {
+				i++
+			}
+			val = string(line[start:i])
+		}
+
+		if _, ok := pairs[key]; !ok {
+			pairs[key] = val
+		}
+	}
+
+	return pairs
+}
+
+// parseLogfmtTime accepts the layouts above, assuming loc (UTC if nil)
+// when no zone is given, and epoch values in any unit with optional
+// fractional seconds.
+func parseLogfmtTime(v string, loc *time.Location) (int64, error) {
+
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	for _, layout := range logfmtLayouts {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return t.UnixNano(), nil
+		}
+	}
+
+	whole, frac, dotted := strings.Cut(v, ".")
+	n, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, format.ErrParseTimestamp
+	}
+
+	if dotted {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		ns, err := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		if err != nil {
+			return 0, format.ErrParseTimestamp
+		}
+		return n*int64(time.Second) + ns, nil
+	}
+
+	switch sz := len(whole); {
+	case sz > 16:
+		return n, nil
+	case sz > 13:
+		return n * int64(time.Microsecond), nil
+	case sz > 10:
+		return n * int64(time.Millisecond), nil
+	}
+	return n * int64(time.Second), nil
+}
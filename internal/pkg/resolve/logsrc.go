@@ -1,12 +1,12 @@
 package resolve
 
 import (
-	"compress/gzip"
+	"context"
 	"errors"
 	"io"
 	"os"
-	"strings"
 
+	"github.com/prequel-dev/preq/internal/pkg/utils"
 	"github.com/prequel-dev/prequel-logmatch/pkg/format"
 	"github.com/rs/zerolog/log"
 )
@@ -28,11 +28,22 @@ type logSrc struct {
 	rd      io.Reader
 	factory format.FactoryI
 	fold    bool
+	cri     *criRdr
+	mm      *mmapRdr
+	name    string // display name when fh is not the log itself
+	tmp     bool   // remove fh on close
+	member  bool   // of an archive
 }
 
 func newLogSrc(fn string, opts ...OptT) (src *logSrc, err error) {
-	var fh *os.File
+	var (
+		fh *os.File
+		mm *mmapRdr
+	)
 	defer func() {
+		if err != nil && mm != nil {
+			mm.Close()
+		}
 		if err != nil && fh != nil {
 			if cerr := fh.Close(); cerr != nil {
 				log.Error().Err(cerr).Msg("Failed to close file")
@@ -41,15 +52,32 @@ func newLogSrc(fn string, opts ...OptT) (src *logSrc, err error) {
 		}
 	}()
 
+	if isEvtx(fn) {
+		return newEvtxSrc(fn, opts...)
+	}
+
 	if fh, err = os.Open(fn); err != nil {
 		return
 	}
 
+	o := parseOpts(opts...)
+
+	// A log holds nothing written after it was last modified
+	if o.yearRef.IsZero() {
+		if info, serr := fh.Stat(); serr == nil {
+			opts = append(opts, withYearRef(info.ModTime()))
+		}
+	}
+
 	rd, err := newReader(fn, fh)
 	if err != nil {
 		return
 	}
 
+	if rd, err = o.tokenizer.apply(rd); err != nil {
+		return
+	}
+
 	var buffer = make([]byte, detectSampleSize)
 	n, err := io.ReadFull(rd, buffer)
 	switch err {
@@ -59,7 +87,6 @@ func newLogSrc(fn string, opts ...OptT) (src *logSrc, err error) {
 	}
 	buffer = buffer[:n]
 
-	o := parseOpts(opts...)
 	factory, ts, err := NewLogFactory(buffer, opts...)
 
 	if err != nil {
@@ -70,22 +97,42 @@ func newLogSrc(fn string, opts ...OptT) (src *logSrc, err error) {
 		return
 	}
 
-	if rd, err = newReader(fn, fh); err != nil {
+	// Map large plain files that are scanned start to end; followed files
+	// grow past the mapping and ranges seek the file directly
+	if !isCompressed(fn) && o.follow == nil && o.srcRange.IsZero() && o.begin == 0 {
+		mm = newMmapRdr(fh)
+	}
+
+	if mm != nil {
+		rd = mm
+	} else if rd, err = newReader(fn, fh); err != nil {
 		return
 	}
 
-	var sz int64 = -1
-	if !isGzip(fn) {
+	if rd, err = o.tokenizer.apply(rd); err != nil {
+		return
+	}
+
+	// Re-framed records no longer line up with file offsets
+	var (
+		sz       int64 = -1
+		reframed       = !o.tokenizer.IsZero()
+	)
+	if !isCompressed(fn) && !reframed && o.srcRange.IsZero() && o.begin == 0 {
 		if info, err := fh.Stat(); err == nil {
 			sz = info.Size()
 		}
 	}
 
-	// Only fold on Regex or rfc3339Nano; doesn't make sense on CRI or JSON
-	var fold bool
-	switch factory.String() {
-	case format.FactoryRegex, format.FactoryRfc3339Nano:
-		fold = true
+	// Only plain files can seek to the end of a suffix range
+	var seekable *os.File
+	if !isCompressed(fn) && !reframed {
+		seekable = fh
+	}
+
+	rd, cri, fold, err := frame(rd, seekable, factory, o)
+	if err != nil {
+		return
 	}
 
 	return &logSrc{
@@ -96,19 +143,82 @@ func newLogSrc(fn string, opts ...OptT) (src *logSrc, err error) {
 		factory: factory,
 		window:  o.window,
 		fold:    fold,
+		cri:     cri,
+		mm:      mm,
 	}, nil
 }
 
-func isGzip(fn string) bool {
-	return strings.HasSuffix(fn, ".gz") || strings.HasSuffix(fn, ".gzip")
+// frame applies the begin time and range of o to rd, a log in the format
+// of factory, and reassembles CRI lines. seekable, if not nil, is the
+// plain file under rd.
+func frame(rd io.Reader, seekable *os.File, factory format.FactoryI, o *optsT) (io.Reader, *criRdr, bool, error) {
+
+	var err error
+
+	// Seek to the begin time first; a range then applies to what follows
+	if o.begin != 0 {
+		var searchable = seekable
+		if !o.srcRange.IsZero() {
+			searchable = nil
+		}
+		if rd, err = seekBegin(rd, searchable, factory, o.begin); err != nil {
+			return nil, nil, false, err
+		}
+		seekable = nil
+	}
+
+	if rd, err = o.srcRange.apply(rd, seekable); err != nil {
+		return nil, nil, false, err
+	}
+
+	var cri *criRdr
+	if isCri(factory) {
+		cri = newCriRdr(rd)
+		rd = cri
+	}
+
+	// Only fold on Regex, rfc3339Nano or logfmt; doesn't make sense on CRI or JSON
+	var fold bool
+	switch factory.String() {
+	case format.FactoryRegex, format.FactoryRfc3339Nano, FactoryLogfmt:
+		fold = true
+	}
+
+	return rd, cri, fold, nil
+}
+
+func isCompressed(fn string) bool {
+	return utils.CompressionOf(fn) != utils.CompressNone
 }
 
+// newReader decompresses gzip, bzip2 and xz files by suffix.
 func newReader(fn string, src io.Reader) (io.Reader, error) {
-	if !isGzip(fn) {
-		return src, nil
-	}
+	return utils.Decompress(utils.CompressionOf(fn), src)
+}
 
-	return gzip.NewReader(src)
+// followTail keeps reading the file as it grows. Compressed files cannot be
+// followed and are read once.
+func (ls *logSrc) followTail(ctx context.Context) {
+	if ls.member {
+		log.Warn().Str("path", ls.Name()).Msg("Cannot follow archive member. Continue...")
+		return
+	}
+	if isEvtx(ls.fh.Name()) {
+		log.Warn().Str("path", ls.fh.Name()).Msg("Cannot follow exported event log. Continue...")
+		return
+	}
+	if isCompressed(ls.fh.Name()) {
+		log.Warn().Str("path", ls.fh.Name()).Msg("Cannot follow compressed file. Continue...")
+		return
+	}
+	// Follow beneath partial reassembly so a line still being written is
+	// not cut short at the current end of file
+	if ls.cri != nil {
+		ls.cri.src = newFollowRdr(ctx, ls.cri.src)
+	} else {
+		ls.rd = newFollowRdr(ctx, ls.rd)
+	}
+	ls.sz = -1
 }
 
 func (ls *logSrc) Size() int64 {
@@ -120,7 +230,17 @@ func (ls *logSrc) Read(p []byte) (n int, err error) {
 }
 
 func (ls *logSrc) Close() error {
-	return ls.fh.Close()
+	var err error
+	if ls.mm != nil {
+		err = ls.mm.Close()
+	}
+	err = errors.Join(err, ls.fh.Close())
+	if ls.tmp {
+		if rerr := os.Remove(ls.fh.Name()); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+			err = errors.Join(err, rerr)
+		}
+	}
+	return err
 }
 
 func (ls *logSrc) Parser() format.ParserI {
@@ -128,6 +248,9 @@ func (ls *logSrc) Parser() format.ParserI {
 }
 
 func (ls *logSrc) Name() string {
+	if ls.name != "" {
+		return ls.name
+	}
 	return ls.fh.Name()
 }
 
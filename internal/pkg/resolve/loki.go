@@ -0,0 +1,275 @@
+package resolve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+	"github.com/rs/zerolog/log"
+)
+
+// A Loki location points at the Loki base URL and carries the LogQL query
+// and range as parameters. Percent-encode '&' and '+' inside the query.
+//
+//	locations:
+//	  - type: loki
+//	    path: http://loki:3100?query={app="api"} |= "error"&start=6h&limit=5000
+//
+// Parameters: query (required), start and end (RFC3339, "now" or a duration
+// before now; default the last hour), limit (entries per request), org
+// (sent as X-Scope-OrgID) and token_env (environment variable holding a
+// bearer token). Basic auth may be given in the URL.
+
+const (
+	locationLoki      = "loki"
+	lokiQueryRange    = "/loki/api/v1/query_range"
+	lokiDefaultLimit  = 5000
+	lokiClientTimeout = 30 * time.Second
+)
+
+type lokiSpec struct {
+	base     string
+	query    string
+	start    time.Time
+	end      time.Time
+	limit    int
+	org      string
+	tokenEnv string
+}
+
+func parseLoki(path string, now time.Time) (*lokiSpec, error) {
+
+	base, query, _ := strings.Cut(path, "?")
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &lokiSpec{
+		base:     strings.TrimSuffix(base, "/"),
+		query:    params.Get("query"),
+		limit:    lokiDefaultLimit,
+		org:      params.Get("org"),
+		tokenEnv: params.Get("token_env"),
+	}
+
+	if spec.query == "" {
+		return nil, ErrMissingQuery
+	}
+
+	if l := params.Get("limit"); l != "" {
+		if spec.limit, err = strconv.Atoi(l); err != nil || spec.limit <= 0 {
+			return nil, fmt.Errorf("invalid limit %q", l)
+		}
+	}
+
+	if spec.start, err = parseTimeBound(params.Get("start"), now, now.Add(-defaultLookback)); err != nil {
+		return nil, err
+	}
+	if spec.end, err = parseTimeBound(params.Get("end"), now, now); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+type lokiResponseT struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+func resolveLoki(location datasrc.Location, opts ...OptT) ([]LogSrcI, error) {
+
+	spec, err := parseLoki(location.Path, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if location.Window != 0 {
+		opts = append(opts, WithWindow(int64(location.Window)))
+	}
+
+	var (
+		labels = &labelSetT{}
+		follow = parseOpts(opts...).follow != nil
+		client = httpz.New(httpz.WithName(locationLoki), httpz.WithTimeout(lokiClientTimeout))
+		fetch  = lokiFetch(client, spec, follow, labels)
+		src    = newRemoteSrc(locationLoki+":"+spec.query, fetch, opts...)
+	)
+
+	return []LogSrcI{&labeledSrc{remoteSrc: src, labels: labels}}, nil
+}
+
+// lokiFetch pages forward through query_range. Each page starts just after
+// the newest entry of the previous one.
+func lokiFetch(client *http.Client, spec *lokiSpec, follow bool, labels *labelSetT) fetchT {
+
+	var (
+		bound boundaryT
+		done  bool
+	)
+
+	return func(ctx context.Context) ([]eventT, error) {
+
+		end := spec.end
+		if done {
+			if !follow {
+				return nil, io.EOF
+			}
+			if err := pollWait(ctx, defaultPollInterval); err != nil {
+				return nil, err
+			}
+			done = false
+		}
+		if follow {
+			end = time.Now()
+		}
+
+		from := bound.from(spec.start)
+		resp, err := lokiQuery(ctx, client, spec, from.UnixNano(), end.UnixNano())
+		if err != nil {
+			return nil, err
+		}
+
+		var events []eventT
+		for _, res := range resp.Data.Result {
+			labels.add(res.Stream)
+			for _, v := range res.Values {
+				ns, err := strconv.ParseInt(v[0], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid loki timestamp %q: %w", v[0], err)
+				}
+				events = append(events, eventT{ts: time.Unix(0, ns), line: v[1]})
+			}
+		}
+
+		// Streams are returned separately; merge them by time
+		slices.SortStableFunc(events, func(a, b eventT) int {
+			return a.ts.Compare(b.ts)
+		})
+
+		full := len(events) >= spec.limit
+		if !full {
+			done = true
+		}
+
+		// The next page starts at the newest timestamp again so entries
+		// sharing it are not lost; drop the ones already returned.
+		events = bound.fresh(events)
+		if full && len(events) == 0 {
+			log.Warn().Str("query", spec.query).Time("ts", bound.ts).Int("limit", spec.limit).Msg("More loki entries share one timestamp than fit a page; skipping the rest. Continue...")
+			bound.skip(time.Nanosecond)
+		}
+
+		return events, nil
+	}
+}
+
+func lokiQuery(ctx context.Context, client *http.Client, spec *lokiSpec, start, end int64) (*lokiResponseT, error) {
+
+	params := url.Values{}
+	params.Set("query", spec.query)
+	params.Set("start", strconv.FormatInt(start, 10))
+	params.Set("end", strconv.FormatInt(end, 10))
+	params.Set("limit", strconv.Itoa(spec.limit))
+	params.Set("direction", "forward")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, spec.base+lokiQueryRange+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.org != "" {
+		req.Header.Set("X-Scope-OrgID", spec.org)
+	}
+	if spec.tokenEnv != "" {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(spec.tokenEnv))
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("loki query failed: %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	var out lokiResponseT
+	if err = json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if out.Data.ResultType != "" && out.Data.ResultType != "streams" {
+		return nil, fmt.Errorf("loki query returned %s, expected a log query", out.Data.ResultType)
+	}
+
+	return &out, nil
+}
+
+// labelSetT keeps the labels shared by every stream seen so far.
+type labelSetT struct {
+	mux    sync.Mutex
+	seen   bool
+	labels map[string]string
+}
+
+func (l *labelSetT) add(labels map[string]string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if !l.seen {
+		l.seen = true
+		l.labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			l.labels[k] = v
+		}
+		return
+	}
+
+	for k, v := range l.labels {
+		if labels[k] != v {
+			delete(l.labels, k)
+		}
+	}
+}
+
+func (l *labelSetT) get() map[string]string {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	out := make(map[string]string, len(l.labels))
+	for k, v := range l.labels {
+		out[k] = v
+	}
+	return out
+}
+
+// labeledSrc is a remote source that reports the labels of its streams.
+type labeledSrc struct {
+	*remoteSrc
+	labels *labelSetT
+}
+
+func (l *labeledSrc) Labels() map[string]string {
+	return l.labels.get()
+}
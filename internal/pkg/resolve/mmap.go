@@ -0,0 +1,97 @@
+package resolve
+
+import (
+	"errors"
+	"io"
+	"math"
+	"os"
+	"runtime/debug"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	errMmapUnsupported = errors.New("mmap unsupported")
+)
+
+// Plain files at least this large are mapped rather than read, so the
+// scanner copies lines straight out of the page cache.
+var mmapThreshold int64 = 256 << 20
+
+// mmapRdr reads a file mapped into memory. A file truncated while mapped,
+// as by copytruncate rotation, faults on the pages it lost; the fault is
+// recovered and the rest is read from the file instead.
+type mmapRdr struct {
+	data []byte
+	off  int
+	fh   *os.File
+	rd   io.Reader // once the file changed
+}
+
+// newMmapRdr maps fh when it is large enough. It returns nil, with the
+// caller falling back to reading fh, if the file is small or cannot be
+// mapped on this platform.
+func newMmapRdr(fh *os.File) *mmapRdr {
+
+	info, err := fh.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() < mmapThreshold {
+		return nil
+	}
+
+	data, err := mmapFile(fh, info.Size())
+	if err != nil {
+		log.Debug().Err(err).Str("path", fh.Name()).Msg("Failed to map file, reading instead")
+		return nil
+	}
+
+	log.Debug().Str("path", fh.Name()).Int64("size", info.Size()).Msg("Mapped file")
+
+	return &mmapRdr{data: data, fh: fh}
+}
+
+func (m *mmapRdr) Read(p []byte) (n int, err error) {
+
+	if m.rd != nil {
+		return m.rd.Read(p)
+	}
+
+	if m.off >= len(m.data) {
+		return 0, io.EOF
+	}
+
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(interface{ Addr() uintptr }); !ok {
+				panic(r)
+			}
+			n, err = m.fallback(p)
+		}
+	}()
+
+	n = copy(p, m.data[m.off:])
+	m.off += n
+	return n, nil
+}
+
+// fallback unmaps the file after a fault and reads on from the file.
+func (m *mmapRdr) fallback(p []byte) (int, error) {
+
+	log.Warn().Str("path", m.fh.Name()).Int("offset", m.off).Msg("File changed while mapped, reading instead")
+
+	if err := m.Close(); err != nil {
+		log.Error().Err(err).Str("path", m.fh.Name()).Msg("Failed to unmap file")
+	}
+
+	m.rd = io.NewSectionReader(m.fh, int64(m.off), math.MaxInt64-int64(m.off))
+	return m.rd.Read(p)
+}
+
+func (m *mmapRdr) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return munmapFile(data)
+}
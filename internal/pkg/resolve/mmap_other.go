@@ -0,0 +1,13 @@
+//go:build !unix
+
+package resolve
+
+import "os"
+
+func mmapFile(*os.File, int64) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmapFile([]byte) error {
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package resolve
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func mmapFile(fh *os.File, size int64) ([]byte, error) {
+	if int64(int(size)) != size {
+		return nil, errMmapUnsupported
+	}
+
+	data, err := unix.Mmap(int(fh.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	// Advisory only; lets the kernel read ahead aggressively
+	_ = unix.Madvise(data, unix.MADV_SEQUENTIAL)
+
+	return data, nil
+}
+
+func munmapFile(data []byte) error {
+	return unix.Munmap(data)
+}
@@ -0,0 +1,348 @@
+package resolve
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidRange = errors.New("invalid range")
+)
+
+const (
+	tailChunkSize = 64 * 1024
+)
+
+// RangeSpec limits a source to part of its content. Both forms follow
+// HTTP Range syntax: "start-end", "start-" or "-suffix".
+//
+//	range:
+//	  bytes: -10485760  # last 10 MiB, starting at the next full line
+//	  lines: 100-200    # lines 100 through 200 (1-based, inclusive)
+type RangeSpec struct {
+	Bytes string `yaml:"bytes,omitempty"`
+	Lines string `yaml:"lines,omitempty"`
+}
+
+func (r *RangeSpec) IsZero() bool {
+	return r == nil || (r.Bytes == "" && r.Lines == "")
+}
+
+// suffix reports whether the range counts back from the end.
+func (r *RangeSpec) suffix() bool {
+	s := r.Bytes
+	if s == "" {
+		s = r.Lines
+	}
+	return strings.HasPrefix(strings.TrimSpace(s), "-")
+}
+
+// HeadLines selects the first n lines.
+func HeadLines(n int64) *RangeSpec {
+	return &RangeSpec{Lines: fmt.Sprintf("1-%d", n)}
+}
+
+// TailLines selects the last n lines.
+func TailLines(n int64) *RangeSpec {
+	return &RangeSpec{Lines: fmt.Sprintf("-%d", n)}
+}
+
+type spanT struct {
+	start  int64
+	end    int64 // inclusive; -1 reads to the end
+	suffix bool  // start is a count back from the end
+}
+
+func parseSpan(s string) (spanT, error) {
+	s = strings.TrimSpace(s)
+
+	if n, ok := strings.CutPrefix(s, "-"); ok {
+		v, err := strconv.ParseInt(n, 10, 64)
+		if err != nil || v <= 0 {
+			return spanT{}, fmt.Errorf("%w: %q", ErrInvalidRange, s)
+		}
+		return spanT{start: v, end: -1, suffix: true}, nil
+	}
+
+	first, last, ok := strings.Cut(s, "-")
+	if !ok {
+		return spanT{}, fmt.Errorf("%w: %q", ErrInvalidRange, s)
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return spanT{}, fmt.Errorf("%w: %q", ErrInvalidRange, s)
+	}
+
+	span := spanT{start: start, end: -1}
+	if last != "" {
+		if span.end, err = strconv.ParseInt(last, 10, 64); err != nil || span.end < start {
+			return spanT{}, fmt.Errorf("%w: %q", ErrInvalidRange, s)
+		}
+	}
+
+	return span, nil
+}
+
+func (r *RangeSpec) validate() error {
+	if r.Bytes != "" && r.Lines != "" {
+		return fmt.Errorf("%w: bytes and lines are mutually exclusive", ErrInvalidRange)
+	}
+	if r.Bytes != "" {
+		_, err := parseSpan(r.Bytes)
+		return err
+	}
+	_, err := parseSpan(r.Lines)
+	return err
+}
+
+// apply narrows rd to the range. If fh is non-nil, rd must be positioned at
+// the start of fh; suffix ranges then seek instead of reading everything.
+func (r *RangeSpec) apply(rd io.Reader, fh *os.File) (io.Reader, error) {
+
+	if r.IsZero() {
+		return rd, nil
+	}
+
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+
+	if r.Bytes != "" {
+		span, _ := parseSpan(r.Bytes)
+		return byteRange(rd, fh, span)
+	}
+
+	span, _ := parseSpan(r.Lines)
+	return lineRange(rd, fh, span)
+}
+
+func byteRange(rd io.Reader, fh *os.File, span spanT) (io.Reader, error) {
+
+	var offset = span.start
+
+	switch {
+	case span.suffix && fh != nil:
+		info, err := fh.Stat()
+		if err != nil {
+			return nil, err
+		}
+		offset = max(0, info.Size()-span.start)
+		fallthrough
+
+	case fh != nil:
+		if _, err := fh.Seek(max(0, offset-1), io.SeekStart); err != nil {
+			return nil, err
+		}
+		rd = fh
+
+	case span.suffix:
+		// Keep one byte more to tell whether the range starts a line
+		data, err := tailBytes(rd, span.start+1)
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) <= span.start {
+			return bufio.NewReader(bytes.NewReader(data)), nil
+		}
+		return skipPartialLine(bytes.NewReader(data[1:]), data[0] != '\n')
+
+	default:
+		if _, err := io.CopyN(io.Discard, rd, max(0, offset-1)); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+
+	// A range that starts right after a newline starts on a full line
+	var prev = []byte{'\n'}
+	if offset > 0 {
+		if _, err := io.ReadFull(rd, prev); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+
+	if span.end >= 0 {
+		rd = io.LimitReader(rd, span.end-span.start+1)
+	}
+
+	return skipPartialLine(rd, prev[0] != '\n')
+}
+
+// skipPartialLine drops everything up to the first newline so parsing
+// starts on a line boundary.
+func skipPartialLine(rd io.Reader, skip bool) (io.Reader, error) {
+	br := bufio.NewReader(rd)
+	if !skip {
+		return br, nil
+	}
+	for {
+		_, err := br.ReadSlice('\n')
+		switch err {
+		case nil, io.EOF:
+			return br, nil
+		case bufio.ErrBufferFull:
+			continue
+		default:
+			return nil, err
+		}
+	}
+}
+
+// tailBytes keeps the last n bytes of a stream that cannot seek.
+func tailBytes(rd io.Reader, n int64) ([]byte, error) {
+	var (
+		buf = make([]byte, 0, 2*n)
+		tmp = make([]byte, tailChunkSize)
+	)
+	for {
+		m, err := rd.Read(tmp)
+		buf = append(buf, tmp[:m]...)
+		if int64(len(buf)) > 2*n {
+			buf = append(buf[:0], buf[int64(len(buf))-n:]...)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if int64(len(buf)) > n {
+		buf = buf[int64(len(buf))-n:]
+	}
+	return buf, nil
+}
+
+func lineRange(rd io.Reader, fh *os.File, span spanT) (io.Reader, error) {
+
+	switch {
+	case span.suffix && fh != nil:
+		offset, err := tailLineOffset(fh, span.start)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fh.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return fh, nil
+
+	case span.suffix:
+		return tailLines(rd, span.start)
+	}
+
+	return &lineRangeRdr{
+		br:    bufio.NewReader(rd),
+		line:  1,
+		start: span.start,
+		end:   span.end,
+	}, nil
+}
+
+// tailLineOffset scans backwards from the end of fh for the start of the
+// last n lines.
+func tailLineOffset(fh *os.File, n int64) (int64, error) {
+
+	info, err := fh.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		pos   = info.Size()
+		buf   = make([]byte, tailChunkSize)
+		count int64
+		last  = true
+	)
+
+	for pos > 0 {
+		sz := min(int64(len(buf)), pos)
+		pos -= sz
+
+		if _, err := fh.ReadAt(buf[:sz], pos); err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		for i := sz - 1; i >= 0; i-- {
+			if buf[i] != '\n' {
+				last = false
+				continue
+			}
+			// A trailing newline terminates the last line; it doesn't start one
+			if last {
+				last = false
+				continue
+			}
+			if count++; count == n {
+				return pos + i + 1, nil
+			}
+		}
+	}
+
+	return 0, nil
+}
+
+// tailLines keeps the last n lines of a stream that cannot seek.
+func tailLines(rd io.Reader, n int64) (io.Reader, error) {
+	var (
+		ring = make([][]byte, n)
+		idx  int64
+		br   = bufio.NewReader(rd)
+	)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			ring[idx%n] = line
+			idx++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var out bytes.Buffer
+	for i := max(0, idx-n); i < idx; i++ {
+		out.Write(ring[i%n])
+	}
+	return &out, nil
+}
+
+type lineRangeRdr struct {
+	br      *bufio.Reader
+	pending []byte
+	line    int64
+	start   int64
+	end     int64
+}
+
+func (l *lineRangeRdr) Read(p []byte) (int, error) {
+	for len(l.pending) == 0 {
+		if l.end >= 0 && l.line > l.end {
+			return 0, io.EOF
+		}
+
+		line, err := l.br.ReadBytes('\n')
+		if len(line) > 0 {
+			if l.line >= l.start {
+				l.pending = line
+			}
+			l.line++
+		}
+
+		if err != nil && len(l.pending) == 0 {
+			return 0, err
+		}
+	}
+
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	return n, nil
+}
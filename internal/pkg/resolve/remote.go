@@ -0,0 +1,318 @@
+package resolve
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prequel-dev/prequel-logmatch/pkg/format"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	streamStdout        = "stdout"
+)
+
+// eventT is a log event fetched from a remote API with its native timestamp.
+type eventT struct {
+	ts     time.Time
+	line   string
+	stream string
+}
+
+// fetchT returns the next batch of events. It returns io.EOF once the
+// source is exhausted; in follow mode it should instead block or return
+// an empty batch until more events arrive.
+type fetchT func(ctx context.Context) ([]eventT, error)
+
+// boundaryT tracks the newest timestamp delivered by a remote source so a
+// re-query can resume at that timestamp inclusively. Events sharing it that
+// arrive later are picked up; those already delivered are dropped by count,
+// so identical lines are neither lost nor repeated.
+type boundaryT struct {
+	ts    time.Time
+	prior map[string]int
+	cur   map[string]int
+}
+
+// from returns where the next query should start and begins a new pass
+// over the boundary timestamp.
+func (b *boundaryT) from(start time.Time) time.Time {
+
+	if b.ts.IsZero() {
+		return start
+	}
+
+	if b.prior == nil {
+		b.prior = make(map[string]int, len(b.cur))
+	}
+	for k, n := range b.cur {
+		b.prior[k] = max(b.prior[k], n)
+	}
+	b.cur = nil
+
+	return b.ts
+}
+
+// keep reports whether ev is new; events must be offered in time order.
+func (b *boundaryT) keep(ev eventT) bool {
+
+	key := ev.stream + "\x00" + ev.line
+
+	switch {
+	case ev.ts.Before(b.ts):
+		return false
+	case ev.ts.After(b.ts):
+		b.ts, b.prior, b.cur = ev.ts, nil, map[string]int{key: 1}
+		return true
+	}
+
+	if b.cur == nil {
+		b.cur = make(map[string]int)
+	}
+	b.cur[key]++
+
+	return b.cur[key] > b.prior[key]
+}
+
+// skip moves the boundary past its timestamp, for when a full page holds
+// nothing but that timestamp and re-querying it would never progress.
+func (b *boundaryT) skip(unit time.Duration) {
+	b.ts, b.prior, b.cur = b.ts.Add(unit), nil, nil
+}
+
+// fresh filters events down to those not yet delivered.
+func (b *boundaryT) fresh(events []eventT) []eventT {
+	out := events[:0]
+	for _, ev := range events {
+		if b.keep(ev) {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// remoteSrc adapts a remote event API to LogSrcI. Events are re-encoded as
+// Docker JSON lines so the parser uses their native timestamps verbatim.
+// Fetching starts on the first Read.
+type remoteSrc struct {
+	name   string
+	window int64
+	ctx    context.Context
+	cancel context.CancelFunc
+	fetch  fetchT
+	once   sync.Once
+	pr     *io.PipeReader
+	rd     io.Reader
+	rng    *RangeSpec
+	begin  int64
+	err    error
+}
+
+func newRemoteSrc(name string, fetch fetchT, opts ...OptT) *remoteSrc {
+
+	var (
+		o   = parseOpts(opts...)
+		ctx = context.Background()
+	)
+
+	if o.follow != nil {
+		ctx = o.follow
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &remoteSrc{
+		name:   name,
+		window: o.window,
+		ctx:    ctx,
+		cancel: cancel,
+		fetch:  fetch,
+		rng:    o.srcRange,
+		begin:  o.begin,
+	}
+}
+
+type remoteLineT struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+func (r *remoteSrc) start() {
+
+	pr, pw := io.Pipe()
+	r.pr = pr
+
+	if r.rd, r.err = r.rng.apply(pr, nil); r.err != nil {
+		return
+	}
+
+	if r.begin != 0 {
+		if r.rd, r.err = seekBegin(r.rd, nil, format.NewJsonFactory(), r.begin); r.err != nil {
+			return
+		}
+	}
+
+	go func() {
+		var (
+			bw  = bufio.NewWriter(pw)
+			enc = json.NewEncoder(bw)
+		)
+
+		for {
+			events, err := r.fetch(r.ctx)
+
+			for _, ev := range events {
+				stream := ev.stream
+				if stream == "" {
+					stream = streamStdout
+				}
+				if werr := enc.Encode(remoteLineT{Log: ev.line, Stream: stream, Time: ev.ts.UTC()}); werr != nil {
+					pw.CloseWithError(werr)
+					return
+				}
+			}
+
+			if ferr := bw.Flush(); ferr != nil {
+				pw.CloseWithError(ferr)
+				return
+			}
+
+			switch {
+			case err == nil:
+			case errors.Is(err, io.EOF), errors.Is(err, context.Canceled):
+				pw.Close()
+				return
+			default:
+				log.Warn().Err(err).Str("name", r.name).Msg("Failed to fetch remote events")
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+}
+
+func (r *remoteSrc) Read(p []byte) (int, error) {
+	r.once.Do(r.start)
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.rd.Read(p)
+}
+
+func (r *remoteSrc) Close() error {
+	r.cancel()
+	if r.pr != nil {
+		return r.pr.Close()
+	}
+	return nil
+}
+
+func (r *remoteSrc) Size() int64 {
+	return -1
+}
+
+func (r *remoteSrc) Name() string {
+	return r.name
+}
+
+func (r *remoteSrc) Fold() bool {
+	return false
+}
+
+func (r *remoteSrc) Window() int64 {
+	return r.window
+}
+
+func (r *remoteSrc) Parser() format.ParserI {
+	return format.NewJsonFactory().New()
+}
+
+// pollWait sleeps between polls in follow mode.
+func pollWait(ctx context.Context, interval time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(interval):
+		return nil
+	}
+}
+
+// parseTimeBound accepts RFC3339, "now", or a duration meaning that long
+// before now ("1h" and "-1h" are equivalent). Empty returns def.
+func parseTimeBound(s string, now, def time.Time) (time.Time, error) {
+	switch s {
+	case "":
+		return def, nil
+	case "now":
+		return now, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	d, err := time.ParseDuration(strings.TrimPrefix(s, "-"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected RFC3339, now or a duration", s)
+	}
+	return now.Add(-d), nil
+}
+
+// lookupField finds a field in a document by its dotted name, either as a
+// flat key or by walking nested objects.
+func lookupField(doc map[string]any, name string) any {
+
+	if v, ok := doc[name]; ok {
+		return v
+	}
+
+	head, rest, ok := strings.Cut(name, ".")
+	if !ok {
+		return nil
+	}
+	if sub, ok := doc[head].(map[string]any); ok {
+		return lookupField(sub, rest)
+	}
+	return nil
+}
+
+// eventTime accepts date strings and epoch milliseconds.
+func eventTime(v any) (time.Time, error) {
+	switch t := v.(type) {
+	case string:
+		if ts, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			return ts, nil
+		}
+		if ms, err := strconv.ParseInt(t, 10, 64); err == nil {
+			return time.UnixMilli(ms), nil
+		}
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", t)
+	case float64:
+		return time.UnixMilli(int64(t)), nil
+	case nil:
+		return time.Time{}, fmt.Errorf("missing timestamp")
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %v", v)
+}
+
+// eventMessage returns strings as is and other values as JSON.
+func eventMessage(v any) string {
+	switch m := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimRight(m, "\n")
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -6,24 +6,80 @@ import (
 	"fmt"
 	"os"
 	"slices"
+	"time"
 
 	"path/filepath"
 
 	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
 	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
 )
 
 var (
-	ErrorSourceType = errors.New("unsupported source type")
+	ErrorSourceType    = errors.New("unsupported source type")
+	ErrMissingLogGroup = errors.New("missing log group")
+	ErrMissingQuery    = errors.New("missing query")
+	ErrMissingIndex    = errors.New("missing index")
+	ErrMissingCluster  = errors.New("missing cluster")
+	ErrMissingProject  = errors.New("missing project")
 )
 
 const (
 	logType = "log"
 )
 
-type (
-	DataSources = datasrc.DataSources
-)
+// DataSources mirrors datasrc.DataSources with preq-specific extensions to
+// each source.
+type DataSources struct {
+	Version string   `yaml:"version"`
+	Sources []Source `yaml:"sources"`
+}
+
+// Source extends the compiler's data source schema. Unknown fields are
+// ignored by the compiler, so files remain compatible both ways.
+type Source struct {
+	datasrc.Source `yaml:",inline"`
+	Range          *RangeSpec      `yaml:"range,omitempty"`
+	Tokenizer      *TokenizerSpec  `yaml:"tokenizer,omitempty"`
+	Rotated        bool            `yaml:"rotated,omitempty"`
+	Timestamps     []TimestampSpec `yaml:"timestamps,omitempty"`
+
+	// Timezone of timestamps written without one, e.g. Europe/Berlin,
+	// Local or +02:00. Overrides --tz.
+	Timezone string `yaml:"timezone,omitempty"`
+
+	// Labels are attached to every detection from this source, e.g. env,
+	// service or host.
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+func ParseSources(data []byte) (*DataSources, error) {
+	var ds DataSources
+	if err := yaml.Unmarshal(data, &ds); err != nil {
+		return nil, err
+	}
+	return &ds, nil
+}
+
+func ParseSourcesFile(fn string) (*DataSources, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSources(data)
+}
+
+// Base returns the sources without preq-specific extensions.
+func (dss *DataSources) Base() *datasrc.DataSources {
+	base := &datasrc.DataSources{
+		Version: dss.Version,
+		Sources: make([]datasrc.Source, 0, len(dss.Sources)),
+	}
+	for _, src := range dss.Sources {
+		base.Sources = append(base.Sources, src.Source)
+	}
+	return base
+}
 
 func Resolve(dss *DataSources, opts ...OptT) []*LogData {
 	var sources []*LogData
@@ -38,6 +94,7 @@ func Resolve(dss *DataSources, opts ...OptT) []*LogData {
 				Str("type", src.Type).
 				Msg("Failed to resolve source")
 		} else {
+			dataSrc.labels = src.Labels
 			sources = append(sources, dataSrc)
 		}
 	}
@@ -45,19 +102,84 @@ func Resolve(dss *DataSources, opts ...OptT) []*LogData {
 	return sources
 }
 
-func resolveSource(src datasrc.Source, opts ...OptT) (*LogData, error) {
+func resolveSource(src Source, opts ...OptT) (*LogData, error) {
 	var (
 		errList []error
 	)
 
+	if !src.Range.IsZero() {
+		if err := src.Range.validate(); err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithRange(src.Range))
+	}
+
+	if !src.Tokenizer.IsZero() {
+		if err := src.Tokenizer.validate(); err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithTokenizer(src.Tokenizer))
+	}
+
+	if src.Rotated {
+		opts = append(opts, WithRotated())
+	}
+
+	if err := validateStamps(src); err != nil {
+		return nil, err
+	}
+
+	if src.Timezone != "" {
+		loc, err := ParseTimezone(src.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithTimezone(loc))
+	}
+
+	if len(src.Timestamps) > 0 {
+		specs := make([]FmtSpec, 0, len(src.Timestamps))
+		for _, spec := range src.Timestamps {
+			specs = append(specs, spec.fmtSpec())
+		}
+		opts = append(opts, WithSourceStamps(specs...))
+	}
+
+	// Resume after the checkpoint unless --begin is already later
+	if o := parseOpts(opts...); o.resume != nil {
+		if from, ok := o.resume[SourceKey(src.Name, src.Type)]; ok && from > o.begin {
+			log.Info().
+				Str("name", src.Name).
+				Str("type", src.Type).
+				Time("from", time.Unix(0, from)).
+				Msg("Resuming source from checkpoint")
+			opts = append(opts, WithBegin(time.Unix(0, from)))
+		}
+	}
+
 	ts := src.Timestamp
 
-	if src.Window != 0 {
+	// Window precedence: location, source, global config, then source type default
+	switch o := parseOpts(opts...); {
+	case src.Window != 0:
 		opts = append(opts, WithWindow(int64(src.Window)))
+	case o.window == 0:
+		if w := DefaultWindow(src.Type, o.sourceWindows); w > 0 {
+			log.Debug().
+				Str("name", src.Name).
+				Str("type", src.Type).
+				Dur("window", w).
+				Msg("Using default window for source type")
+			opts = append(opts, WithWindow(int64(w)))
+		}
 	}
 
 	for idx, location := range src.Locations {
 
+		if location.Type == "" && isK8sPath(location.Path) {
+			location.Type = locationK8s
+		}
+
 		switch location.Type {
 		case "", logType:
 			if slogs, err := resolveLog(location, ts, opts...); err == nil {
@@ -71,6 +193,116 @@ func resolveSource(src datasrc.Source, opts ...OptT) (*LogData, error) {
 				errList = append(errList, err)
 			}
 
+		case locationCloudWatch:
+			if slogs, err := resolveCloudWatch(location, opts...); err == nil {
+				return NewLogData(slogs, src.Name, src.Type), nil
+			} else {
+				log.Info().
+					Err(err).
+					Int("idx", idx).
+					Msg("Failed to resolve cloudwatch source")
+				errList = append(errList, err)
+			}
+
+		case locationEks:
+			if slogs, err := resolveEks(location, opts...); err == nil {
+				return NewLogData(slogs, src.Name, src.Type), nil
+			} else {
+				log.Info().
+					Err(err).
+					Int("idx", idx).
+					Msg("Failed to resolve eks source")
+				errList = append(errList, err)
+			}
+
+		case locationGke:
+			if slogs, err := resolveGke(location, opts...); err == nil {
+				return NewLogData(slogs, src.Name, src.Type), nil
+			} else {
+				log.Info().
+					Err(err).
+					Int("idx", idx).
+					Msg("Failed to resolve gke source")
+				errList = append(errList, err)
+			}
+
+		case locationLoki:
+			if slogs, err := resolveLoki(location, opts...); err == nil {
+				return NewLogData(slogs, src.Name, src.Type), nil
+			} else {
+				log.Info().
+					Err(err).
+					Int("idx", idx).
+					Msg("Failed to resolve loki source")
+				errList = append(errList, err)
+			}
+
+		case locationElastic, locationOpenSearch:
+			if slogs, err := resolveElastic(location, opts...); err == nil {
+				return NewLogData(slogs, src.Name, src.Type), nil
+			} else {
+				log.Info().
+					Err(err).
+					Int("idx", idx).
+					Msg("Failed to resolve elasticsearch source")
+				errList = append(errList, err)
+			}
+
+		case locationSplunk:
+			if slogs, err := resolveSplunk(location, opts...); err == nil {
+				return NewLogData(slogs, src.Name, src.Type), nil
+			} else {
+				log.Info().
+					Err(err).
+					Int("idx", idx).
+					Msg("Failed to resolve splunk source")
+				errList = append(errList, err)
+			}
+
+		case locationK8s:
+			if slogs, err := resolveK8s(location, opts...); err == nil {
+				return NewLogData(slogs, src.Name, src.Type), nil
+			} else {
+				log.Info().
+					Err(err).
+					Int("idx", idx).
+					Msg("Failed to resolve kubernetes source")
+				errList = append(errList, err)
+			}
+
+		case locationEventLog:
+			if slogs, err := resolveEventLog(location, opts...); err == nil {
+				return NewLogData(slogs, src.Name, src.Type), nil
+			} else {
+				log.Info().
+					Err(err).
+					Int("idx", idx).
+					Msg("Failed to resolve event log source")
+				errList = append(errList, err)
+			}
+
+		case locationSocket:
+			if slogs, err := resolveSocket(location, opts...); err == nil {
+				return NewLogData(slogs, src.Name, src.Type), nil
+			} else {
+				log.Info().
+					Err(err).
+					Int("idx", idx).
+					Msg("Failed to resolve socket source")
+				errList = append(errList, err)
+			}
+
+		case locationHttp:
+			if slogs, err := resolveHttpPoll(location, opts...); err == nil {
+				return NewLogData(slogs, src.Name, src.Type), nil
+			} else {
+				log.Info().
+					Err(err).
+					Int("idx", idx).
+					Msg("Failed to resolve http source")
+				errList = append(errList, err)
+			}
+
 		default:
 			log.Info().
 				Int("idx", idx).
@@ -93,6 +325,10 @@ func resolveLog(location datasrc.Location, ts *datasrc.Timestamp, opts ...OptT)
 		return nil, os.ErrNotExist
 	}
 
+	if parseOpts(opts...).rotated {
+		matches = expandRotated(matches)
+	}
+
 	// Use location timestamp if provided, otherwise source timestamp (if specified)
 	if location.Timestamp != nil {
 		ts = location.Timestamp
@@ -109,9 +345,39 @@ func resolveLog(location datasrc.Location, ts *datasrc.Timestamp, opts ...OptT)
 	var (
 		errList  []error
 		resolved []*logSrc
+		streams  []LogSrcI
 	)
 
 	for _, match := range matches {
+
+		if isFifo(match) {
+			src, err := newFifoSrc(match, opts...)
+			if err != nil {
+				log.Info().
+					Err(err).
+					Str("path", match).
+					Msg("Failed to read named pipe")
+				errList = append(errList, err)
+				continue
+			}
+			streams = append(streams, src)
+			continue
+		}
+
+		if IsArchive(match) {
+			members, err := resolveArchive(match, opts...)
+			if err != nil {
+				log.Info().
+					Err(err).
+					Str("path", match).
+					Msg("Failed to expand archive")
+				errList = append(errList, err)
+				continue
+			}
+			resolved = append(resolved, members...)
+			continue
+		}
+
 		lsrc, err := newLogSrc(match, opts...)
 		if err != nil {
 			log.Info().
@@ -131,7 +397,7 @@ func resolveLog(location datasrc.Location, ts *datasrc.Timestamp, opts ...OptT)
 		resolved = append(resolved, lsrc)
 	}
 
-	if len(resolved) == 0 {
+	if len(resolved) == 0 && len(streams) == 0 {
 		return nil, errors.Join(errList...)
 	}
 
@@ -139,10 +405,15 @@ func resolveLog(location datasrc.Location, ts *datasrc.Timestamp, opts ...OptT)
 		return cmp.Compare(a.ts, b.ts)
 	})
 
-	slogs := make([]LogSrcI, len(resolved))
-	for idx, log := range resolved {
-		slogs[idx] = log
+	if o := parseOpts(opts...); o.follow != nil && len(resolved) > 0 {
+		resolved[len(resolved)-1].followTail(o.follow)
+	}
+
+	// Named pipes are read after the files
+	slogs := make([]LogSrcI, 0, len(resolved)+len(streams))
+	for _, log := range resolved {
+		slogs = append(slogs, log)
 	}
 
-	return slogs, nil
+	return append(slogs, streams...), nil
 }
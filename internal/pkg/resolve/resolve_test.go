@@ -1,13 +1,39 @@
 package resolve
 
 import (
+	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
 	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+	"github.com/prequel-dev/prequel-logmatch/pkg/timez"
+	"github.com/ulikunitz/xz"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 )
 
 func createTestFile(t *testing.T, dir, name, content string, useGzip bool) string {
@@ -75,6 +101,41 @@ func TestNewLogSrc(t *testing.T) {
 		}
 	})
 
+	t.Run("with bzip2 and xz files", func(t *testing.T) {
+		const content = "2023-10-28T10:40:01Z first\n2023-10-28T10:40:02Z second\n"
+
+		bz2, _ := base64.StdEncoding.DecodeString("QlpoOTFBWSZTWZpKniYAAAvbgAAQQAJ8UAQQDyGcACAAVFGjIGjTI0EqnqehqZppDRkagqMdF7COKOQUcQURhhDkIGXvOvM//eo5fF3JFOFCQmkqeJg=")
+
+		var xzBuf bytes.Buffer
+		xw, err := xz.NewWriter(&xzBuf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		xw.Write([]byte(content))
+		xw.Close()
+
+		for name, data := range map[string][]byte{"test.log.bz2": bz2, "test.log.xz": xzBuf.Bytes()} {
+			path := filepath.Join(tempDir, name)
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			src, err := newLogSrc(path)
+			if err != nil {
+				t.Fatalf("newLogSrc failed for %s: %v", name, err)
+			}
+
+			got, err := io.ReadAll(src)
+			src.Close()
+			if err != nil || string(got) != content {
+				t.Errorf("%s: expected %q, got %q: %v", name, content, got, err)
+			}
+			if src.Size() != -1 {
+				t.Errorf("%s: expected size -1, got %d", name, src.Size())
+			}
+		}
+	})
+
 	t.Run("with window option", func(t *testing.T) {
 		path := createTestFile(t, tempDir, "window.log", logContent, false)
 		expectedWindow := int64(30)
@@ -104,13 +165,13 @@ func TestResolveSource(t *testing.T) {
 	logPath := createTestFile(t, tempDir, "app.log", logContent, false)
 
 	t.Run("with valid source", func(t *testing.T) {
-		dss := &datasrc.DataSources{
-			Sources: []datasrc.Source{
-				{
+		dss := &DataSources{
+			Sources: []Source{
+				{Source: datasrc.Source{
 					Name:      "my-app",
 					Type:      "log",
 					Locations: []datasrc.Location{{Path: logPath}},
-				},
+				}},
 			},
 		}
 
@@ -157,3 +218,2378 @@ func TestPipeStdin(t *testing.T) {
 		t.Fatalf("Expected PipeStdin to return 1 LogData source, but got %d", len(results))
 	}
 }
+
+func TestPipeReader(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("2023-10-28T11:00:00Z Streamed log content\n")
+	for b.Len() < detectSampleSize*2 {
+		b.WriteString("2023-10-28T11:00:01Z Filler line for reader test.\n")
+	}
+
+	results, err := PipeReader(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatalf("PipeReader returned an unexpected error: %v", err)
+	}
+
+	if len(results) != 1 || len(results[0].Logs) != 1 {
+		t.Fatalf("Expected PipeReader to return 1 LogData source with 1 log")
+	}
+
+	data, err := io.ReadAll(results[0].Logs[0])
+	if err != nil {
+		t.Fatalf("Failed to read resolved source: %v", err)
+	}
+
+	if string(data) != b.String() {
+		t.Errorf("Expected %d bytes, got %d", b.Len(), len(data))
+	}
+}
+
+func TestPipeReaderFollow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r, w := io.Pipe()
+	defer w.Close()
+
+	go w.Write([]byte("2023-10-28T11:00:00Z one\n2023-10-28T11:00:01Z two\n2023-10-28T11:00:02Z three\n"))
+
+	// Neither a short sample nor a tail of a stream that doesn't end block
+	done := make(chan []*LogData)
+	go func() {
+		results, err := PipeReader(r, WithFollow(ctx), WithRange(TailLines(2)))
+		if err != nil {
+			t.Errorf("PipeReader returned an unexpected error: %v", err)
+		}
+		done <- results
+	}()
+
+	var results []*LogData
+	select {
+	case results = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("PipeReader blocked on a followed stream")
+	}
+	if len(results) != 1 || len(results[0].Logs) != 1 {
+		t.Fatalf("Expected PipeReader to return 1 LogData source with 1 log")
+	}
+
+	go w.Write([]byte("2023-10-28T11:00:03Z four\n"))
+
+	want := "2023-10-28T11:00:01Z two\n2023-10-28T11:00:02Z three\n2023-10-28T11:00:03Z four\n"
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(results[0].Logs[0], buf); err != nil {
+		t.Fatalf("Failed to read followed stream: %v", err)
+	}
+	if string(buf) != want {
+		t.Errorf("Expected %q, got %q", want, buf)
+	}
+}
+
+func TestFollowLog(t *testing.T) {
+	tempDir := t.TempDir()
+	logPath := createTestFile(t, tempDir, "follow.log", "2023-10-28T10:50:00Z first line", false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slogs, err := resolveLog(datasrc.Location{Path: logPath}, nil, WithFollow(ctx))
+	if err != nil {
+		t.Fatalf("resolveLog failed: %v", err)
+	}
+	defer slogs[0].Close()
+
+	if slogs[0].Size() != -1 {
+		t.Errorf("Expected size -1 for followed log, got %d", slogs[0].Size())
+	}
+
+	info, _ := os.Stat(logPath)
+	if _, err := io.ReadFull(slogs[0], make([]byte, info.Size())); err != nil {
+		t.Fatalf("Failed to read initial content: %v", err)
+	}
+
+	appended := "2023-10-28T10:51:00Z appended line\n"
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		f, _ := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+		f.WriteString(appended)
+		f.Close()
+	}()
+
+	buf := make([]byte, len(appended))
+	if _, err := io.ReadFull(slogs[0], buf); err != nil {
+		t.Fatalf("Failed to read appended content: %v", err)
+	}
+	if string(buf) != appended {
+		t.Errorf("Expected %q, got %q", appended, buf)
+	}
+
+	cancel()
+	if _, err := slogs[0].Read(buf); err != io.EOF {
+		t.Errorf("Expected EOF after cancel, got %v", err)
+	}
+}
+
+func TestDefaultWindow(t *testing.T) {
+	overrides := map[string]time.Duration{"cre.log.kafka": 3 * time.Second}
+
+	tests := map[string]time.Duration{
+		"cre.prequel.k8s": 10 * time.Second,
+		"cre.log.syslog":  2 * time.Second,
+		"cre.log.kafka":   3 * time.Second,
+		"cre.log.nginx":   0,
+	}
+
+	for srcType, expected := range tests {
+		if w := DefaultWindow(srcType, overrides); w != expected {
+			t.Errorf("%s: expected window %v, got %v", srcType, expected, w)
+		}
+	}
+}
+
+func TestResolveSourceWindow(t *testing.T) {
+	tempDir := t.TempDir()
+	logPath := createTestFile(t, tempDir, "k8s.log", "2023-10-28T10:40:00Z some log content", false)
+
+	newSrc := func(window time.Duration) Source {
+		return Source{Source: datasrc.Source{
+			Type:      "cre.prequel.k8s",
+			Window:    window,
+			Locations: []datasrc.Location{{Path: logPath}},
+		}}
+	}
+
+	tests := map[string]struct {
+		src      Source
+		opts     []OptT
+		expected int64
+	}{
+		"type default":  {src: newSrc(0), expected: int64(10 * time.Second)},
+		"source window": {src: newSrc(time.Minute), expected: int64(time.Minute)},
+		"global window": {src: newSrc(0), opts: []OptT{WithWindow(int64(time.Second))}, expected: int64(time.Second)},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ld, err := resolveSource(tc.src, tc.opts...)
+			if err != nil {
+				t.Fatalf("resolveSource failed: %v", err)
+			}
+			defer ld.Close()
+
+			if w := ld.Logs[0].Window(); w != tc.expected {
+				t.Errorf("Expected window %d, got %d", tc.expected, w)
+			}
+		})
+	}
+}
+
+func TestRangeSpec(t *testing.T) {
+	const content = "line1\nline2\nline3\nline4\nline5\n"
+
+	logPath := filepath.Join(t.TempDir(), "range.log")
+	if err := os.WriteFile(logPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	tests := map[string]struct {
+		spec     RangeSpec
+		expected string
+	}{
+		"HeadLines":   {spec: *HeadLines(2), expected: "line1\nline2\n"},
+		"TailLines":   {spec: *TailLines(2), expected: "line4\nline5\n"},
+		"LineSpan":    {spec: RangeSpec{Lines: "2-3"}, expected: "line2\nline3\n"},
+		"LineOpen":    {spec: RangeSpec{Lines: "4-"}, expected: "line4\nline5\n"},
+		"ByteSuffix":  {spec: RangeSpec{Bytes: "-8"}, expected: "line5\n"},
+		"ByteSpan":    {spec: RangeSpec{Bytes: "8-17"}, expected: "line3\n"},
+		"ByteAtStart": {spec: RangeSpec{Bytes: "0-5"}, expected: "line1\n"},
+		"ByteOnLine":  {spec: RangeSpec{Bytes: "6-11"}, expected: "line2\n"},
+		"SuffixLine":  {spec: RangeSpec{Bytes: "-12"}, expected: "line4\nline5\n"},
+		"SuffixAll":   {spec: RangeSpec{Bytes: "-40"}, expected: content},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, seekable := range []bool{true, false} {
+				fh, err := os.Open(logPath)
+				if err != nil {
+					t.Fatalf("Failed to open test file: %v", err)
+				}
+				defer fh.Close()
+
+				var (
+					rd io.Reader = fh
+					sk *os.File
+				)
+				if seekable {
+					sk = fh
+				} else {
+					rd = io.MultiReader(fh)
+				}
+
+				rd, err = tc.spec.apply(rd, sk)
+				if err != nil {
+					t.Fatalf("apply returned an unexpected error: %v", err)
+				}
+
+				data, err := io.ReadAll(rd)
+				if err != nil {
+					t.Fatalf("Failed to read range: %v", err)
+				}
+				if string(data) != tc.expected {
+					t.Errorf("seekable=%v: expected %q, got %q", seekable, tc.expected, string(data))
+				}
+			}
+		})
+	}
+}
+
+func TestRangeSpecInvalid(t *testing.T) {
+	for _, spec := range []RangeSpec{
+		{Lines: "abc"},
+		{Lines: "5-2"},
+		{Bytes: "-0"},
+		{Bytes: "1-2", Lines: "1-2"},
+	} {
+		if err := spec.validate(); !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("Expected ErrInvalidRange for %+v, got %v", spec, err)
+		}
+	}
+}
+
+func TestParseSourcesRange(t *testing.T) {
+	ds, err := ParseSources([]byte(`
+version: 0.0.1
+sources:
+  - name: app
+    type: cre.log.app
+    range:
+      lines: "-100"
+    locations:
+      - path: /var/log/app.log
+`))
+	if err != nil {
+		t.Fatalf("ParseSources returned an unexpected error: %v", err)
+	}
+
+	if len(ds.Sources) != 1 || ds.Sources[0].Range == nil || ds.Sources[0].Range.Lines != "-100" {
+		t.Fatalf("Expected range to be parsed, got %+v", ds.Sources)
+	}
+
+	base := ds.Base()
+	if len(base.Sources) != 1 || base.Sources[0].Name != "app" || len(base.Sources[0].Locations) != 1 {
+		t.Errorf("Expected base data sources to match, got %+v", base.Sources)
+	}
+}
+
+type fakeCloudWatch struct {
+	pages []*cloudwatchlogs.FilterLogEventsOutput
+	calls []*cloudwatchlogs.FilterLogEventsInput
+}
+
+func (f *fakeCloudWatch) FilterLogEvents(ctx context.Context, in *cloudwatchlogs.FilterLogEventsInput, _ ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	f.calls = append(f.calls, in)
+	page := f.pages[0]
+	f.pages = f.pages[1:]
+	return page, nil
+}
+
+func TestResolveCloudWatch(t *testing.T) {
+	fake := &fakeCloudWatch{
+		pages: []*cloudwatchlogs.FilterLogEventsOutput{
+			{
+				Events: []cwtypes.FilteredLogEvent{
+					{Timestamp: aws.Int64(1698489600000), Message: aws.String("first event\n")},
+				},
+				NextToken: aws.String("next"),
+			},
+			{
+				Events: []cwtypes.FilteredLogEvent{
+					{Timestamp: aws.Int64(1698489601500), Message: aws.String("second event")},
+				},
+			},
+		},
+	}
+
+	saved := newCloudWatchClient
+	t.Cleanup(func() { newCloudWatchClient = saved })
+
+	var spec *cloudWatchSpec
+	newCloudWatchClient = func(ctx context.Context, s *cloudWatchSpec) (cloudWatchAPI, error) {
+		spec = s
+		return fake, nil
+	}
+
+	ld, err := resolveSource(Source{Source: datasrc.Source{
+		Name: "eks",
+		Type: "cre.prequel.k8s",
+		Locations: []datasrc.Location{{
+			Type: "cloudwatch",
+			Path: "/aws/eks/prod/cluster?stream_prefix=kube-apiserver&region=us-east-1&start=2023-10-28T00:00:00Z&end=now",
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("resolveSource returned an unexpected error: %v", err)
+	}
+	defer ld.Close()
+
+	if spec.group != "/aws/eks/prod/cluster" || spec.streamPrefix != "kube-apiserver" || spec.region != "us-east-1" {
+		t.Errorf("Unexpected spec: %+v", spec)
+	}
+
+	src := ld.Logs[0]
+	data, err := io.ReadAll(src)
+	if err != nil {
+		t.Fatalf("Failed to read cloudwatch source: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d: %q", len(lines), data)
+	}
+
+	parser := src.Parser()
+	for i, want := range []struct {
+		line string
+		ts   int64
+	}{
+		{"first event", 1698489600000 * int64(time.Millisecond)},
+		{"second event", 1698489601500 * int64(time.Millisecond)},
+	} {
+		entry, err := parser.ReadEntry([]byte(lines[i]))
+		if err != nil {
+			t.Fatalf("Failed to parse line %d: %v", i, err)
+		}
+		if entry.Line != want.line || entry.Timestamp != want.ts {
+			t.Errorf("Line %d: expected %q@%d, got %q@%d", i, want.line, want.ts, entry.Line, entry.Timestamp)
+		}
+	}
+
+	if len(fake.calls) != 2 || aws.ToString(fake.calls[1].NextToken) != "next" || fake.calls[0].EndTime == nil {
+		t.Errorf("Unexpected FilterLogEvents calls: %+v", fake.calls)
+	}
+}
+
+func TestCloudWatchOffline(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+
+	httpz.SetOffline(true)
+	t.Cleanup(func() { httpz.SetOffline(false) })
+
+	client, err := newCloudWatchClient(context.Background(), &cloudWatchSpec{group: "/aws/eks/prod/cluster", region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.FilterLogEvents(context.Background(), &cloudwatchlogs.FilterLogEventsInput{LogGroupName: aws.String("/aws/eks/prod/cluster")}, func(o *cloudwatchlogs.Options) {
+		o.RetryMaxAttempts = 1
+	})
+	if !errors.Is(err, httpz.ErrOffline) {
+		t.Errorf("Expected %v, got %v", httpz.ErrOffline, err)
+	}
+}
+
+func TestResolveEks(t *testing.T) {
+	fake := &fakeCloudWatch{
+		pages: []*cloudwatchlogs.FilterLogEventsOutput{{
+			Events: []cwtypes.FilteredLogEvent{
+				{Timestamp: aws.Int64(1698489600000), LogStreamName: aws.String("kube-apiserver-abc"), Message: aws.String("api")},
+				{Timestamp: aws.Int64(1698489600100), LogStreamName: aws.String("kube-apiserver-audit-abc"), Message: aws.String("audit")},
+				{Timestamp: aws.Int64(1698489600200), LogStreamName: aws.String("kube-scheduler-abc"), Message: aws.String("scheduler")},
+				{Timestamp: aws.Int64(1698489600300), LogStreamName: aws.String("authenticator-abc"), Message: aws.String("authenticator")},
+			},
+		}},
+	}
+
+	saved := newCloudWatchClient
+	t.Cleanup(func() { newCloudWatchClient = saved })
+
+	var spec *cloudWatchSpec
+	newCloudWatchClient = func(ctx context.Context, s *cloudWatchSpec) (cloudWatchAPI, error) {
+		spec = s
+		return fake, nil
+	}
+
+	ld, err := resolveSource(Source{Source: datasrc.Source{
+		Name: "eks",
+		Type: "cre.prequel.k8s",
+		Locations: []datasrc.Location{{
+			Type: "eks",
+			Path: "prod?components=api,scheduler&region=us-east-1&start=2023-10-28T00:00:00Z",
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("resolveSource returned an unexpected error: %v", err)
+	}
+	defer ld.Close()
+
+	if spec.group != "/aws/eks/prod/cluster" || spec.streamPrefix != "" || spec.region != "us-east-1" {
+		t.Errorf("Unexpected spec: %+v", spec)
+	}
+
+	data, err := io.ReadAll(ld.Logs[0])
+	if err != nil {
+		t.Fatalf("Failed to read eks source: %v", err)
+	}
+
+	var (
+		parser = ld.Logs[0].Parser()
+		got    []string
+	)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		entry, err := parser.ReadEntry([]byte(line))
+		if err != nil {
+			t.Fatalf("Failed to parse line: %v", err)
+		}
+		got = append(got, entry.Line)
+	}
+
+	if want := []string{"api", "scheduler"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	t.Run("single component", func(t *testing.T) {
+		spec, err := parseEks("prod?components=audit", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec.streamPrefix != "kube-apiserver-audit-" {
+			t.Errorf("Expected audit stream prefix, got %q", spec.streamPrefix)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := parseEks("?components=api", time.Now()); !errors.Is(err, ErrMissingCluster) {
+			t.Errorf("Expected ErrMissingCluster, got %v", err)
+		}
+		if _, err := parseEks("prod?components=etcd", time.Now()); !errors.Is(err, ErrUnknownComponent) {
+			t.Errorf("Expected ErrUnknownComponent, got %v", err)
+		}
+	})
+}
+
+func TestResolveGke(t *testing.T) {
+	var bodies []gkeRequestT
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Unexpected request headers: %v", r.Header)
+		}
+		var body gkeRequestT
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		bodies = append(bodies, body)
+
+		switch body.PageToken {
+		case "":
+			io.WriteString(w, `{"entries":[
+				{"timestamp":"2023-10-28T10:00:00.5Z","textPayload":"I1028 apiserver started\n"},
+				{"timestamp":"2023-10-28T10:00:01Z","protoPayload":{"methodName":"io.k8s.core.v1.pods.delete"}}],
+				"nextPageToken":"next"}`)
+		default:
+			io.WriteString(w, `{"entries":[{"timestamp":"2023-10-28T10:00:02Z","jsonPayload":{"message":"scheduled"}}]}`)
+		}
+	}))
+	defer srv.Close()
+
+	saved := gkeEndpoint
+	t.Cleanup(func() { gkeEndpoint = saved })
+	gkeEndpoint = srv.URL
+	t.Setenv("GKE_TOKEN", "secret")
+
+	ld, err := resolveSource(Source{Source: datasrc.Source{
+		Name: "gke",
+		Type: "cre.prequel.k8s",
+		Locations: []datasrc.Location{{
+			Type: "gke",
+			Path: "prod?project=acme&location=us-central1&token_env=GKE_TOKEN&start=2023-10-28T00:00:00Z&end=2023-10-29T00:00:00Z",
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("resolveSource returned an unexpected error: %v", err)
+	}
+	defer ld.Close()
+
+	data, err := io.ReadAll(ld.Logs[0])
+	if err != nil {
+		t.Fatalf("Failed to read gke source: %v", err)
+	}
+
+	var (
+		parser = ld.Logs[0].Parser()
+		got    []string
+	)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		entry, err := parser.ReadEntry([]byte(line))
+		if err != nil {
+			t.Fatalf("Failed to parse line: %v", err)
+		}
+		got = append(got, entry.Line)
+	}
+
+	want := []string{"I1028 apiserver started", `{"methodName":"io.k8s.core.v1.pods.delete"}`, `{"message":"scheduled"}`}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if len(bodies) != 2 || bodies[1].PageToken != "next" || bodies[0].ResourceNames[0] != "projects/acme" || bodies[0].OrderBy != "timestamp asc" {
+		t.Fatalf("Unexpected requests: %+v", bodies)
+	}
+	for _, term := range []string{
+		`resource.labels.cluster_name="prod"`,
+		`resource.labels.component_name=("apiserver" OR "scheduler")`,
+		`logName="projects/acme/logs/cloudaudit.googleapis.com%2Factivity"`,
+		`resource.labels.location="us-central1"`,
+		`timestamp<="2023-10-29T00:00:00Z"`,
+	} {
+		if !strings.Contains(bodies[0].Filter, term) {
+			t.Errorf("Expected filter to contain %s, got %s", term, bodies[0].Filter)
+		}
+	}
+
+	if _, err := parseGke("prod", time.Now()); !errors.Is(err, ErrMissingProject) {
+		t.Errorf("Expected ErrMissingProject, got %v", err)
+	}
+	if _, err := parseGke("prod?project=acme&components=authenticator", time.Now()); !errors.Is(err, ErrUnknownComponent) {
+		t.Errorf("Expected ErrUnknownComponent, got %v", err)
+	}
+}
+
+func TestParseTimeBound(t *testing.T) {
+	var (
+		now = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		def = now.Add(-time.Hour)
+	)
+
+	tests := map[string]time.Time{
+		"":                     def,
+		"now":                  now,
+		"2h":                   now.Add(-2 * time.Hour),
+		"-30m":                 now.Add(-30 * time.Minute),
+		"2023-12-31T00:00:00Z": time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+
+	for in, want := range tests {
+		got, err := parseTimeBound(in, now, def)
+		if err != nil {
+			t.Fatalf("parseTimeBound(%q): %v", in, err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("parseTimeBound(%q): expected %v, got %v", in, want, got)
+		}
+	}
+
+	if _, err := parseTimeBound("yesterday", now, def); err == nil {
+		t.Errorf("Expected error for invalid time")
+	}
+}
+
+func TestResolveLoki(t *testing.T) {
+	var queries []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/query_range" || r.Header.Get("X-Scope-OrgID") != "tenant" {
+			t.Errorf("Unexpected request: %s %v", r.URL.Path, r.Header)
+		}
+		queries = append(queries, r.URL.Query())
+
+		// Pages are full at limit=2. The second resumes at the newest
+		// timestamp of the first, where another entry has since been written
+		switch len(queries) {
+		case 1:
+			io.WriteString(w, `{"status":"success","data":{"resultType":"streams","result":[
+				{"stream":{"app":"api","pod":"api-1"},"values":[["1698489602000000000","from pod 1"]]},
+				{"stream":{"app":"api","pod":"api-2"},"values":[["1698489601000000000","from pod 2"]]}]}}`)
+		case 2:
+			io.WriteString(w, `{"status":"success","data":{"resultType":"streams","result":[
+				{"stream":{"app":"api","pod":"api-1"},"values":[["1698489602000000000","from pod 1"]]},
+				{"stream":{"app":"api","pod":"api-3"},"values":[["1698489602000000000","from pod 3"]]}]}}`)
+		default:
+			io.WriteString(w, `{"status":"success","data":{"resultType":"streams","result":[
+				{"stream":{"app":"api","pod":"api-1"},"values":[["1698489603000000000","last"]]}]}}`)
+		}
+	}))
+	defer srv.Close()
+
+	ld, err := resolveSource(Source{Source: datasrc.Source{
+		Name: "api",
+		Type: "cre.log.api",
+		Locations: []datasrc.Location{{
+			Type: "loki",
+			Path: srv.URL + `?query={app="api"}&org=tenant&limit=2&start=2023-10-28T00:00:00Z`,
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("resolveSource returned an unexpected error: %v", err)
+	}
+	defer ld.Close()
+
+	data, err := io.ReadAll(ld.Logs[0])
+	if err != nil {
+		t.Fatalf("Failed to read loki source: %v", err)
+	}
+
+	var (
+		parser = ld.Logs[0].Parser()
+		got    []string
+	)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		entry, err := parser.ReadEntry([]byte(line))
+		if err != nil {
+			t.Fatalf("Failed to parse line: %v", err)
+		}
+		got = append(got, entry.Line)
+	}
+
+	if want := []string{"from pod 2", "from pod 1", "from pod 3", "last"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if len(queries) != 3 || queries[0].Get("query") != `{app="api"}` || queries[1].Get("start") != "1698489602000000000" || queries[2].Get("start") != "1698489602000000000" {
+		t.Errorf("Unexpected queries: %v", queries)
+	}
+
+	if meta := ld.Meta(); meta["app"] != "api" || meta["pod"] != "" {
+		t.Errorf("Expected only shared labels in meta, got %v", meta)
+	}
+}
+
+func TestBoundary(t *testing.T) {
+	var (
+		b     boundaryT
+		start = time.Unix(100, 0)
+		t1    = time.Unix(101, 0)
+		t2    = time.Unix(102, 0)
+	)
+
+	lines := func(events []eventT) []string {
+		var out []string
+		for _, ev := range events {
+			out = append(out, ev.line)
+		}
+		return out
+	}
+
+	if from := b.from(start); !from.Equal(start) {
+		t.Fatalf("Expected first query from %v, got %v", start, from)
+	}
+	got := b.fresh([]eventT{{ts: t1, line: "a"}, {ts: t2, line: "dup"}, {ts: t2, line: "b"}})
+	if want := []string{"a", "dup", "b"}; !slices.Equal(lines(got), want) {
+		t.Errorf("Expected %v, got %v", want, lines(got))
+	}
+
+	// The re-query starts at t2 and repeats what was read there, plus a
+	// second identical line and one written later at the same time
+	if from := b.from(start); !from.Equal(t2) {
+		t.Fatalf("Expected re-query from %v, got %v", t2, from)
+	}
+	got = b.fresh([]eventT{{ts: t2, line: "dup"}, {ts: t2, line: "b"}, {ts: t2, line: "dup"}, {ts: t2, line: "c"}})
+	if want := []string{"dup", "c"}; !slices.Equal(lines(got), want) {
+		t.Errorf("Expected %v, got %v", want, lines(got))
+	}
+
+	// A further pass only returns what is newer still
+	b.from(start)
+	got = b.fresh([]eventT{{ts: t2, line: "dup"}, {ts: t2, line: "dup"}, {ts: t2, line: "b"}, {ts: t2, line: "c"}, {ts: t2.Add(1), line: "d"}})
+	if want := []string{"d"}; !slices.Equal(lines(got), want) {
+		t.Errorf("Expected %v, got %v", want, lines(got))
+	}
+
+	b.skip(time.Nanosecond)
+	if from := b.from(start); !from.Equal(t2.Add(2)) {
+		t.Errorf("Expected skip to move past %v, got %v", t2.Add(1), from)
+	}
+}
+
+func TestResolveElastic(t *testing.T) {
+	var bodies []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/logs-*/_search" || r.Header.Get("Authorization") != "ApiKey secret" {
+			t.Errorf("Unexpected request: %s %v", r.URL.Path, r.Header)
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode search body: %v", err)
+		}
+		bodies = append(bodies, body)
+
+		// First page is full (size=2), second page has the remainder
+		switch len(bodies) {
+		case 1:
+			io.WriteString(w, `{"hits":{"hits":[
+				{"_source":{"@timestamp":"2023-10-28T10:40:01.5Z","message":"first"},"sort":[1698489601500,3]},
+				{"_source":{"@timestamp":"2023-10-28T10:40:02Z","message":"second"},"sort":[1698489602000,7]}]}}`)
+		default:
+			io.WriteString(w, `{"hits":{"hits":[
+				{"_source":{"@timestamp":1698489603000,"message":{"nested":true}},"sort":[1698489603000,1]}]}}`)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("PREQ_TEST_ES_KEY", "secret")
+
+	ld, err := resolveSource(Source{Source: datasrc.Source{
+		Name: "api",
+		Type: "cre.log.api",
+		Locations: []datasrc.Location{{
+			Type: "opensearch",
+			Path: srv.URL + `/logs-*?query=level:error&size=2&api_key_env=PREQ_TEST_ES_KEY&start=2023-10-28T00:00:00Z`,
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("resolveSource returned an unexpected error: %v", err)
+	}
+	defer ld.Close()
+
+	data, err := io.ReadAll(ld.Logs[0])
+	if err != nil {
+		t.Fatalf("Failed to read elasticsearch source: %v", err)
+	}
+
+	var (
+		parser = ld.Logs[0].Parser()
+		got    []string
+	)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		entry, err := parser.ReadEntry([]byte(line))
+		if err != nil {
+			t.Fatalf("Failed to parse line: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%d %s", entry.Timestamp, entry.Line))
+	}
+
+	want := []string{
+		"1698489601500000000 first",
+		"1698489602000000000 second",
+		`1698489603000000000 {"nested":true}`,
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("Expected 2 searches, got %d", len(bodies))
+	}
+	if _, ok := bodies[0]["search_after"]; ok {
+		t.Errorf("Unexpected search_after on first page: %v", bodies[0])
+	}
+	if after := fmt.Sprint(bodies[1]["search_after"]); after != "[1.698489602e+12 7]" {
+		t.Errorf("Unexpected search_after: %s", after)
+	}
+}
+
+func TestParseElasticInvalid(t *testing.T) {
+	now := time.Now()
+	if _, err := parseElastic("http://es:9200", now); !errors.Is(err, ErrMissingIndex) {
+		t.Errorf("Expected ErrMissingIndex, got %v", err)
+	}
+	if _, err := parseElastic("http://es:9200/logs?size=0", now); err == nil {
+		t.Error("Expected error for invalid size")
+	}
+}
+
+func TestTokenizer(t *testing.T) {
+	var framed bytes.Buffer
+	for _, rec := range []string{"2023-10-28T10:40:01Z first", "2023-10-28T10:40:02Z multi\nline"} {
+		binary.Write(&framed, binary.LittleEndian, uint16(len(rec)))
+		framed.WriteString(rec)
+	}
+
+	tests := map[string]struct {
+		spec     TokenizerSpec
+		input    string
+		expected string
+	}{
+		"Newline": {
+			spec:     TokenizerSpec{Type: TokenizerNewline},
+			input:    "a\nb\n",
+			expected: "a\nb\n",
+		},
+		"Fixed": {
+			spec:     TokenizerSpec{Type: TokenizerFixed, Width: 8},
+			input:    "abc     defghijkxy\x00\x00  \x00\x00z",
+			expected: "abc\ndefghijk\nxy\nz\n",
+		},
+		"Length": {
+			spec:     TokenizerSpec{Type: TokenizerLength, Prefix: 2, Order: "little"},
+			input:    framed.String(),
+			expected: "2023-10-28T10:40:01Z first\n2023-10-28T10:40:02Z multi\\nline\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rd, err := tc.spec.apply(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			got, err := io.ReadAll(rd)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if string(got) != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+
+	// End to end through a resolved source
+	path := filepath.Join(t.TempDir(), "framed.bin")
+	if err := os.WriteFile(path, framed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ld, err := resolveSource(Source{
+		Source: datasrc.Source{
+			Name:      "appliance",
+			Type:      "cre.log.appliance",
+			Locations: []datasrc.Location{{Path: path}},
+		},
+		Tokenizer: &TokenizerSpec{Type: TokenizerLength, Prefix: 2, Order: "little"},
+	})
+	if err != nil {
+		t.Fatalf("resolveSource returned an unexpected error: %v", err)
+	}
+	defer ld.Close()
+
+	data, err := io.ReadAll(ld.Logs[0])
+	if err != nil {
+		t.Fatalf("Failed to read source: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("Expected 2 records, got %q", data)
+	}
+}
+
+func TestTokenizerInvalid(t *testing.T) {
+	for _, spec := range []TokenizerSpec{
+		{Type: "xml"},
+		{Type: TokenizerFixed},
+		{Type: TokenizerLength, Prefix: 3},
+		{Type: TokenizerLength, Prefix: 4, Order: "middle"},
+	} {
+		if err := spec.validate(); !errors.Is(err, ErrInvalidTokenizer) {
+			t.Errorf("Expected ErrInvalidTokenizer for %+v, got %v", spec, err)
+		}
+	}
+
+	rd, _ := (&TokenizerSpec{Type: TokenizerLength, Prefix: 1}).apply(strings.NewReader("\x05ab"))
+	if _, err := io.ReadAll(rd); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected ErrUnexpectedEOF for a truncated record, got %v", err)
+	}
+}
+
+func TestResolveSplunk(t *testing.T) {
+	var forms []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/servicesNS/nobody/ops/search/v2/jobs/export" || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Unexpected request: %s %v", r.URL.Path, r.Header)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("Failed to parse form: %v", err)
+		}
+		forms = append(forms, r.PostForm)
+
+		io.WriteString(w, `{"preview":true,"result":{"_time":"2023-10-28T10:40:00.000+00:00","_raw":"preview"}}
+{"preview":false,"offset":0,"result":{"_time":"2023-10-28T10:40:01.000+00:00","_raw":"first"}}
+{"preview":false,"offset":1,"result":{"_time":"2023-10-28T10:40:02.500+00:00","_raw":"second\n"}}
+`)
+	}))
+	defer srv.Close()
+
+	t.Setenv("PREQ_TEST_SPLUNK_TOKEN", "secret")
+
+	ld, err := resolveSource(Source{Source: datasrc.Source{
+		Name: "api",
+		Type: "cre.log.api",
+		Locations: []datasrc.Location{{
+			Type: "splunk",
+			Path: srv.URL + `?saved=API errors&app=ops&token_env=PREQ_TEST_SPLUNK_TOKEN&start=2023-10-28T00:00:00Z&end=2023-10-29T00:00:00Z`,
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("resolveSource returned an unexpected error: %v", err)
+	}
+	defer ld.Close()
+
+	data, err := io.ReadAll(ld.Logs[0])
+	if err != nil {
+		t.Fatalf("Failed to read splunk source: %v", err)
+	}
+
+	var (
+		parser = ld.Logs[0].Parser()
+		got    []string
+	)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		entry, err := parser.ReadEntry([]byte(line))
+		if err != nil {
+			t.Fatalf("Failed to parse line: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%d %s", entry.Timestamp, entry.Line))
+	}
+
+	if want := []string{"1698489601000000000 first", "1698489602500000000 second"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if len(forms) != 1 {
+		t.Fatalf("Expected 1 search, got %d", len(forms))
+	}
+	if q := forms[0].Get("search"); q != `| savedsearch "API errors" | sort 0 _time` {
+		t.Errorf("Unexpected search: %s", q)
+	}
+	if forms[0].Get("earliest_time") != "1698451200.000" || forms[0].Get("output_mode") != "json" {
+		t.Errorf("Unexpected form: %v", forms[0])
+	}
+}
+
+func TestParseSplunk(t *testing.T) {
+	now := time.Now()
+
+	spec, err := parseSplunk("https://splunk:8089?search=index=k8s error", now)
+	if err != nil {
+		t.Fatalf("parseSplunk: %v", err)
+	}
+	if q := spec.query(); q != "search index=k8s error | sort 0 _time" {
+		t.Errorf("Unexpected query: %s", q)
+	}
+
+	if _, err := parseSplunk("https://splunk:8089", now); !errors.Is(err, ErrMissingQuery) {
+		t.Errorf("Expected ErrMissingQuery, got %v", err)
+	}
+	if _, err := parseSplunk("https://splunk:8089?search=x&saved=y", now); err == nil {
+		t.Error("Expected error for search and saved")
+	}
+}
+
+func TestCriPartialLines(t *testing.T) {
+	input := "2023-10-28T10:40:01.000000001Z stdout P first \n" +
+		"2023-10-28T10:40:01.000000002Z stderr F error line\n" +
+		"2023-10-28T10:40:01.000000003Z stdout P second \n" +
+		"2023-10-28T10:40:01.000000004Z stdout F third\n" +
+		"2023-10-28T10:40:02Z stdout F whole\n" +
+		"2023-10-28T10:40:03Z stderr P unterminated\n"
+
+	path := filepath.Join(t.TempDir(), "0.log")
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := newLogSrc(path)
+	if err != nil {
+		t.Fatalf("newLogSrc: %v", err)
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+
+	var (
+		parser = src.Parser()
+		got    []string
+	)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		entry, err := parser.ReadEntry([]byte(line))
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", line, err)
+		}
+		got = append(got, fmt.Sprintf("%d %s %s", entry.Timestamp, entry.Stream, entry.Line))
+	}
+
+	want := []string{
+		"1698489601000000002 stderr error line",
+		"1698489601000000001 stdout first second third",
+		"1698489602000000000 stdout whole",
+		"1698489603000000000 stderr unterminated",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestResolveK8s(t *testing.T) {
+	pod := func(name string, labels map[string]string, containers ...string) *v1.Pod {
+		p := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "prod", Labels: labels}}
+		for _, c := range containers {
+			p.Spec.Containers = append(p.Spec.Containers, v1.Container{Name: c})
+		}
+		return p
+	}
+
+	client := k8sfake.NewSimpleClientset(
+		pod("api-1", map[string]string{"app": "api", "pod-template-hash": "a"}, "server", "sidecar"),
+		pod("api-2", map[string]string{"app": "api", "pod-template-hash": "b"}, "server"),
+		pod("db-1", map[string]string{"app": "db"}, "postgres"),
+	)
+
+	orig := newK8sClient
+	defer func() { newK8sClient = orig }()
+	newK8sClient = func(*k8sSpec) (kubernetes.Interface, error) { return client, nil }
+
+	ld, err := resolveSource(Source{Source: datasrc.Source{
+		Name:      "api",
+		Type:      "cre.log.api",
+		Locations: []datasrc.Location{{Path: "k8s://prod?selector=app=api"}},
+	}})
+	if err != nil {
+		t.Fatalf("resolveSource returned an unexpected error: %v", err)
+	}
+	defer ld.Close()
+
+	var names []string
+	for _, l := range ld.Logs {
+		names = append(names, l.Name())
+	}
+	want := "k8s://prod/api-1/server,k8s://prod/api-1/sidecar,k8s://prod/api-2/server"
+	if strings.Join(names, ",") != want {
+		t.Errorf("Expected %s, got %v", want, names)
+	}
+
+	// The fake clientset serves a fixed body without timestamps
+	data, err := io.ReadAll(ld.Logs[0])
+	if err != nil {
+		t.Fatalf("Failed to read kubernetes source: %v", err)
+	}
+	entry, err := ld.Logs[0].Parser().ReadEntry(bytes.TrimSpace(data))
+	if err != nil || entry.Line != "fake logs" {
+		t.Errorf("Unexpected entry %+v: %v", entry, err)
+	}
+
+	// Each pod keeps its own labels; the source only those they share
+	for i, hash := range []string{"a", "a", "b"} {
+		if labels := ld.Logs[i].(labelerI).Labels(); labels["pod-template-hash"] != hash || labels["app"] != "api" {
+			t.Errorf("Expected the labels of its pod on %s, got %v", ld.Logs[i].Name(), labels)
+		}
+	}
+	if meta := ld.Meta(); meta["app"] != "api" || meta["namespace"] != "prod" || meta["pod-template-hash"] != "" {
+		t.Errorf("Expected shared labels in meta, got %v", meta)
+	}
+}
+
+func TestParseK8s(t *testing.T) {
+	spec, err := parseK8s("k8s://prod/api-1/server?since=2h&previous=true")
+	if err != nil {
+		t.Fatalf("parseK8s: %v", err)
+	}
+	if spec.namespace != "prod" || spec.pod != "api-1" || spec.container != "server" || spec.since != 2*time.Hour || !spec.previous {
+		t.Errorf("Unexpected spec: %+v", spec)
+	}
+
+	for path, want := range map[string]error{
+		"k8s://prod":                    ErrMissingPod,
+		"k8s://prod/api-1?selector=a=b": nil,
+		"k8s://prod/a/b/c":              nil,
+		"k8s://prod/a?since=soon":       nil,
+	} {
+		if _, err := parseK8s(path); err == nil || (want != nil && !errors.Is(err, want)) {
+			t.Errorf("%s: expected error %v, got %v", path, want, err)
+		}
+	}
+}
+
+func TestResolveHttpPoll(t *testing.T) {
+	var queries []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			t.Errorf("Unexpected headers: %v", r.Header)
+		}
+		queries = append(queries, r.URL.Query())
+
+		switch r.URL.Query().Get("after") {
+		case "":
+			io.WriteString(w, `{"next":"p2","data":{"entries":[
+				{"timestamp":"2023-10-28T10:40:01Z","message":"first"},
+				{"timestamp":"2023-10-28T10:40:02Z","message":"second"}]}}`)
+		case "p2":
+			io.WriteString(w, `{"next":"p2","data":{"entries":[
+				{"timestamp":"2023-10-28T10:40:03Z","level":"warn"}]}}`)
+		default:
+			t.Errorf("Unexpected cursor: %v", r.URL.Query())
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("PREQ_TEST_HTTP_KEY", "secret")
+
+	ld, err := resolveSource(Source{Source: datasrc.Source{
+		Name: "api",
+		Type: "cre.log.api",
+		Locations: []datasrc.Location{{
+			Type: "http",
+			Path: srv.URL + "/logs?level=warn#format=json&items=data.entries&cursor=after&cursor_from=json:next&header_env=X-Api-Key:PREQ_TEST_HTTP_KEY",
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("resolveSource returned an unexpected error: %v", err)
+	}
+	defer ld.Close()
+
+	data, err := io.ReadAll(ld.Logs[0])
+	if err != nil {
+		t.Fatalf("Failed to read http source: %v", err)
+	}
+
+	var (
+		parser = ld.Logs[0].Parser()
+		got    []string
+	)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		entry, err := parser.ReadEntry([]byte(line))
+		if err != nil {
+			t.Fatalf("Failed to parse line: %v", err)
+		}
+		got = append(got, entry.Line)
+	}
+
+	want := []string{"first", "second", `{"level":"warn","timestamp":"2023-10-28T10:40:03Z"}`}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if len(queries) != 2 || queries[0].Get("level") != "warn" || queries[1].Get("after") != "p2" {
+		t.Errorf("Unexpected queries: %v", queries)
+	}
+}
+
+func TestHttpPollLines(t *testing.T) {
+	bodies := []string{
+		"2023-10-28T10:40:01Z a\n2023-10-28T10:40:02Z b\n",
+		"2023-10-28T10:40:02Z b\n2023-10-28T10:40:03Z c\n",
+	}
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, bodies[min(n, len(bodies)-1)])
+		n++
+	}))
+	defer srv.Close()
+
+	spec, err := parseHttpPoll(srv.URL + "#interval=10ms")
+	if err != nil {
+		t.Fatalf("parseHttpPoll: %v", err)
+	}
+
+	var (
+		fetch = httpPollFetch(srv.Client(), spec, true)
+		got   []string
+	)
+
+	// Without a cursor, the second poll only delivers the new line
+	for range 2 {
+		events, err := fetch(context.Background())
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		for _, ev := range events {
+			got = append(got, ev.line)
+		}
+	}
+
+	if strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("Expected a,b,c, got %v", got)
+	}
+
+	// Lines without a timestamp are told apart by those of the last response
+	bodies = []string{"x\ny\n", "x\ny\ny\nz\n", "y\nz\n"}
+	n, got = 0, nil
+	fetch = httpPollFetch(srv.Client(), spec, true)
+	for range 3 {
+		events, err := fetch(context.Background())
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		for _, ev := range events {
+			if ev.ts.IsZero() {
+				t.Errorf("Expected %q stamped with the fetch time", ev.line)
+			}
+			got = append(got, ev.line)
+		}
+	}
+	if strings.Join(got, ",") != "x,y,y,z" {
+		t.Errorf("Expected x,y,y,z, got %v", got)
+	}
+
+	// A body over the cap is an error, not a cut short response
+	bodies = []string{strings.Repeat("x", httpMaxBody+1)}
+	n = 0
+	if _, err := httpPollFetch(srv.Client(), spec, false)(context.Background()); !errors.Is(err, ErrHttpBody) {
+		t.Errorf("Expected %v, got %v", ErrHttpBody, err)
+	}
+
+	for _, path := range []string{
+		"http://app/logs#format=xml",
+		"http://app/logs#cursor=after",
+		"http://app/logs#cursor_from=json:next",
+		"http://app/logs#cursor=c&cursor_from=json:next",
+		"http://app/logs#header_env=X-Key",
+		"http://app/logs#interval=-1s",
+	} {
+		if _, err := parseHttpPoll(path); err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+}
+
+func TestResolveArchive(t *testing.T) {
+
+	var gzMember bytes.Buffer
+	gw := gzip.NewWriter(&gzMember)
+	gw.Write([]byte("2023-10-28T10:40:01Z kernel: oom-killer invoked\n"))
+	gw.Close()
+
+	members := []struct {
+		name string
+		data []byte
+	}{
+		{"bundle/var/log/app.log", []byte("2023-10-28T10:40:02Z app started\n2023-10-28T10:40:03Z app ready\n")},
+		{"bundle/var/log/kern.log.gz", gzMember.Bytes()},
+		{"bundle/etc/config.yaml", []byte("key: value\n")},
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	tw.WriteHeader(&tar.Header{Name: "bundle/", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, m := range members {
+		if err := tw.WriteHeader(&tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.data))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(m.data)
+	}
+	tw.Close()
+	zw.Close()
+
+	path := filepath.Join(t.TempDir(), "support-bundle.tar.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsArchive(path) || IsArchive("app.log.gz") {
+		t.Fatal("IsArchive mismatch")
+	}
+
+	lds, err := ResolveArchive(path)
+	if err != nil {
+		t.Fatalf("ResolveArchive: %v", err)
+	}
+	if len(lds) != 1 || lds[0].SrcType() != "*" || lds[0].Name() != "support-bundle.tar.gz" {
+		t.Fatalf("Unexpected log data: %+v", lds)
+	}
+
+	logs := lds[0].Logs
+	if len(logs) != 2 {
+		t.Fatalf("Expected 2 logs, got %d", len(logs))
+	}
+
+	// Ordered by first timestamp; the config file is skipped
+	want := []string{path + ":bundle/var/log/kern.log.gz", path + ":bundle/var/log/app.log"}
+	var tmps []string
+	for i, l := range logs {
+		if l.Name() != want[i] {
+			t.Errorf("Log %d: expected %s, got %s", i, want[i], l.Name())
+		}
+		tmps = append(tmps, l.(*logSrc).fh.Name())
+		if _, err := io.ReadAll(l); err != nil {
+			t.Errorf("Read %s: %v", l.Name(), err)
+		}
+	}
+
+	if err := lds[0].Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	for _, tmp := range tmps {
+		if _, err := os.Stat(tmp); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected %s to be removed, got %v", tmp, err)
+		}
+	}
+
+	// Members of a plain tar are read in place, compressed ones included
+	var plain bytes.Buffer
+	tw = tar.NewWriter(&plain)
+	for _, m := range members {
+		tw.WriteHeader(&tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.data))})
+		tw.Write(m.data)
+	}
+	tw.Close()
+	plainPath := filepath.Join(t.TempDir(), "support-bundle.tar")
+	os.WriteFile(plainPath, plain.Bytes(), 0644)
+
+	lds, err = ResolveArchive(plainPath)
+	if err != nil {
+		t.Fatalf("ResolveArchive: %v", err)
+	}
+	var lines []string
+	for _, l := range lds[0].Logs {
+		if l.(*logSrc).fh.Name() != plainPath || l.(*logSrc).tmp {
+			t.Errorf("Expected %s read in place, got %s", l.Name(), l.(*logSrc).fh.Name())
+		}
+		data, _ := io.ReadAll(l)
+		lines = append(lines, strings.Split(strings.TrimSpace(string(data)), "\n")...)
+	}
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], "oom-killer invoked") {
+		t.Errorf("Unexpected lines read in place: %q", lines)
+	}
+	lds[0].Close()
+
+	t.Run("caps", func(t *testing.T) {
+		defer func(m, n int64) { archiveMaxMember, archiveMaxTotal = m, n }(archiveMaxMember, archiveMaxTotal)
+
+		// Only the smaller of the two logs is under the member cap
+		small, big := members[0], members[1]
+		if len(small.data) > len(big.data) {
+			small, big = big, small
+		}
+		archiveMaxMember = int64(len(small.data))
+		lds, err := ResolveArchive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lds[0].Logs) != 1 || lds[0].Logs[0].Name() != path+":"+small.name {
+			t.Errorf("Expected only %s extracted, got %d logs", small.name, len(lds[0].Logs))
+		}
+		lds[0].Close()
+
+		// Nothing past the total
+		archiveMaxMember, archiveMaxTotal = 1<<20, 1
+		if _, err := ResolveArchive(path); !errors.Is(err, ErrEmptyArchive) {
+			t.Errorf("Expected every member over the total skipped, got %v", err)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		tmp := t.TempDir()
+		t.Setenv("TMPDIR", tmp)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := ResolveArchive(path, WithContext(ctx)); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected %v, got %v", context.Canceled, err)
+		}
+		if left, _ := os.ReadDir(tmp); len(left) != 0 {
+			t.Errorf("Expected no extracted members left, got %v", left)
+		}
+	})
+
+	t.Run("empty archive", func(t *testing.T) {
+		empty := filepath.Join(t.TempDir(), "empty.tar")
+		var b bytes.Buffer
+		tar.NewWriter(&b).Close()
+		os.WriteFile(empty, b.Bytes(), 0644)
+
+		if _, err := ResolveArchive(empty); !errors.Is(err, ErrEmptyArchive) {
+			t.Errorf("Expected ErrEmptyArchive, got %v", err)
+		}
+	})
+}
+
+func TestResolveRotated(t *testing.T) {
+	dir := t.TempDir()
+
+	createTestFile(t, dir, "app.log", "2023-10-28T10:40:05Z live\n", false)
+	createTestFile(t, dir, "app.log.1", "2023-10-28T10:40:03Z rotated once\n", false)
+	createTestFile(t, dir, "app.log.2.gz", "2023-10-28T10:40:01Z rotated twice\n", true)
+	createTestFile(t, dir, "app.log.bak", "2023-10-28T10:40:00Z backup\n", false)
+	createTestFile(t, dir, "app.logger", "2023-10-28T10:40:00Z other\n", false)
+
+	data := fmt.Sprintf(`
+version: 0.0.1
+sources:
+  - name: app
+    type: app
+    rotated: true
+    locations:
+      - path: %s
+`, filepath.Join(dir, "app.log"))
+
+	ds, err := ParseSources([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lds := Resolve(ds)
+	if len(lds) != 1 {
+		t.Fatalf("Expected 1 source, got %d", len(lds))
+	}
+	defer lds[0].Close()
+
+	var got []string
+	for _, l := range lds[0].Logs {
+		got = append(got, filepath.Base(l.Name()))
+	}
+
+	want := []string{"app.log.2.gz", "app.log.1", "app.log"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		slogs, err := resolveLog(datasrc.Location{Path: filepath.Join(dir, "app.log")}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range slogs {
+			l.Close()
+		}
+		if len(slogs) != 1 {
+			t.Errorf("Expected only the live file, got %d logs", len(slogs))
+		}
+	})
+}
+
+func TestLogfmt(t *testing.T) {
+
+	want := time.Date(2024, 5, 1, 10, 0, 0, 123000000, time.UTC).UnixNano()
+
+	tests := []struct {
+		line string
+		ts   int64
+		ok   bool
+	}{
+		{`ts=2024-05-01T10:00:00.123Z level=error msg="dial tcp: timeout" peer=10.0.0.7`, want, true},
+		{`level=info time="2024-05-01 10:00:00.123 +0000 UTC" msg=started`, want, true},
+		{`at=info method=GET path="/" t=1714557600.123`, want, true},
+		{`level=warn timestamp=1714557600123 msg="slow \"query\""`, want, true},
+		{`level=info msg=no-time`, 0, false},
+		{`2024-05-01T10:00:00Z plain text ts=2024-05-01T10:00:00Z`, 0, false},
+		{`ts=2024-05-01T10:00:00Z`, 0, false},
+	}
+
+	var f logfmtFmtT
+	for _, tc := range tests {
+		entry, err := f.ReadEntry([]byte(tc.line))
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: expected ok=%v, got %v", tc.line, tc.ok, err)
+			continue
+		}
+		if tc.ok && entry.Timestamp != tc.ts {
+			t.Errorf("%q: expected ts %d, got %d", tc.line, tc.ts, entry.Timestamp)
+		}
+	}
+
+	t.Run("detect", func(t *testing.T) {
+		data := []byte("starting up\nts=2024-05-01T10:00:00.123Z level=info msg=ready\n")
+
+		factory, ts, err := NewLogFactory(data, WithTimestampTries(5))
+		if err != nil {
+			t.Fatalf("NewLogFactory: %v", err)
+		}
+		if factory.String() != FactoryLogfmt || ts != want {
+			t.Errorf("Expected logfmt at %d, got %s at %d", want, factory.String(), ts)
+		}
+	})
+
+	t.Run("regex", func(t *testing.T) {
+		var (
+			data   = []byte(`ts=2024-05-01T10:00:00.123Z level=info at="2024/05/01 11:00:00" msg=ready` + "\n")
+			custom = FmtSpec{Pattern: `at="([^"]+)"`, Format: "2006/01/02 15:04:05"}
+			at     = time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC).UnixNano()
+		)
+
+		// A configured regex wins over detection; the defaults do not
+		factory, ts, err := NewLogFactory(data, WithTimestampTries(5), WithStampRegex(append(defaultStamps(), custom)...))
+		if err != nil {
+			t.Fatalf("NewLogFactory: %v", err)
+		}
+		if factory.String() == FactoryLogfmt || ts != at {
+			t.Errorf("Expected the configured regex at %d, got %s at %d", at, factory.String(), ts)
+		}
+
+		factory, ts, err = NewLogFactory(data, WithTimestampTries(5), WithStampRegex(defaultStamps()...))
+		if err != nil {
+			t.Fatalf("NewLogFactory: %v", err)
+		}
+		if factory.String() != FactoryLogfmt || ts != want {
+			t.Errorf("Expected logfmt at %d, got %s at %d", want, factory.String(), ts)
+		}
+	})
+}
+
+func defaultStamps() []FmtSpec {
+	var specs []FmtSpec
+	for _, d := range timez.Defaults {
+		specs = append(specs, FmtSpec{Pattern: strings.TrimSpace(d.Pattern), Format: d.Format})
+	}
+	return specs
+}
+
+func TestEpoch(t *testing.T) {
+
+	var (
+		sec = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).UnixNano()
+		ms  = sec + int64(123*time.Millisecond)
+		us  = sec + int64(123456*time.Microsecond)
+	)
+
+	tests := []struct {
+		data string
+		ts   int64
+		ok   bool
+	}{
+		{"1714557600 GET /healthz 200\n", sec, true},
+		{"1714557600123 level=warn slow query\n", ms, true},
+		{"1714557600123456 conn reset\n", us, true},
+		{"1714557600123456000 conn reset\n", us, true},
+		{"1714557600.123 conn reset\n", ms, true},
+		{"1714557600.123456\n", us, true},
+		{"# header\n1714557600 GET /\n", sec, true},
+		{"0000000042 items processed\n", 0, false},
+		{"17145576001 eleven digits\n", 0, false},
+		{"4102444800 year 2100\n", 0, false},
+	}
+
+	for _, tc := range tests {
+		factory, ts, err := NewLogFactory([]byte(tc.data), WithTimestampTries(2))
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: expected ok=%v, got %v", tc.data, tc.ok, err)
+			continue
+		}
+		if !tc.ok {
+			continue
+		}
+		if ts != tc.ts {
+			t.Errorf("%q: expected ts %d, got %d", tc.data, tc.ts, ts)
+		}
+
+		// Every line is read with the same unit
+		entry, err := factory.New().ReadEntry([]byte(strings.TrimPrefix(tc.data, "# header\n")))
+		if err != nil || entry.Timestamp != tc.ts {
+			t.Errorf("%q: expected entry at %d, got %d: %v", tc.data, tc.ts, entry.Timestamp, err)
+		}
+	}
+}
+
+// bxBuilder writes binary XML at a known chunk offset, with every name
+// defined inline.
+type bxBuilder struct {
+	buf  []byte
+	base int
+}
+
+func (b *bxBuilder) pos() int {
+	return b.base + len(b.buf)
+}
+
+func (b *bxBuilder) u8(v byte) {
+	b.buf = append(b.buf, v)
+}
+
+func (b *bxBuilder) u16(v uint16) {
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, v)
+}
+
+func (b *bxBuilder) u32(v uint32) {
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, v)
+}
+
+func (b *bxBuilder) u64(v uint64) {
+	b.buf = binary.LittleEndian.AppendUint64(b.buf, v)
+}
+
+func (b *bxBuilder) utf16(s string) {
+	for _, c := range s {
+		b.u16(uint16(c))
+	}
+}
+
+func (b *bxBuilder) name(s string) {
+	b.u32(uint32(b.pos() + 4))
+	b.u32(0)
+	b.u16(0)
+	b.u16(uint16(len(s)))
+	b.utf16(s)
+	b.u16(0)
+}
+
+func (b *bxBuilder) open(name string, attrs bool) {
+	tok := byte(bxOpenStart)
+	if attrs {
+		tok |= bxMoreData
+	}
+	b.u8(tok)
+	b.u16(0xffff)
+	b.u32(0)
+	b.name(name)
+	if attrs {
+		b.u32(0)
+	}
+}
+
+func (b *bxBuilder) attr(name string) {
+	b.u8(bxAttribute)
+	b.name(name)
+}
+
+func (b *bxBuilder) text(s string) {
+	b.u8(bxValue)
+	b.u8(evString)
+	b.u16(uint16(len(s)))
+	b.utf16(s)
+}
+
+func (b *bxBuilder) sub(tok byte, id uint16, typ byte) {
+	b.u8(tok)
+	b.u16(id)
+	b.u8(typ)
+}
+
+// evtxTemplateBody is <Event><System>...</System><EventData>...</EventData></Event>
+// with substitutions for the provider, event id, time, user and data.
+func evtxTemplateBody(b *bxBuilder) {
+	b.u8(bxFragment)
+	b.u8(1)
+	b.u8(1)
+	b.u8(0)
+	b.open("Event", true)
+	b.attr("xmlns")
+	b.text("http://schemas.microsoft.com/win/2004/08/events/event")
+	b.u8(bxCloseStart)
+	b.open("System", false)
+	b.u8(bxCloseStart)
+	b.open("Provider", true)
+	b.attr("Name")
+	b.sub(bxSubstitution, 0, evString)
+	b.u8(bxCloseEmpty)
+	b.open("EventID", false)
+	b.u8(bxCloseStart)
+	b.sub(bxSubstitution, 1, evUint16)
+	b.u8(bxEndElement)
+	b.open("TimeCreated", true)
+	b.attr("SystemTime")
+	b.sub(bxSubstitution, 2, evFiletime)
+	b.u8(bxCloseEmpty)
+	b.open("Security", true)
+	b.attr("UserID")
+	b.sub(bxOptionalSub, 3, evSid)
+	b.u8(bxCloseEmpty)
+	b.u8(bxEndElement)
+	b.open("EventData", false)
+	b.u8(bxCloseStart)
+	b.open("Data", false)
+	b.u8(bxCloseStart)
+	b.sub(bxSubstitution, 4, evString)
+	b.u8(bxEndElement)
+	b.u8(bxEndElement)
+	b.u8(bxEndElement)
+	b.u8(bxEOF)
+}
+
+func evtxFiletime(ts time.Time) uint64 {
+	return uint64(ts.UnixNano()/100 + filetimeEpoch)
+}
+
+// evtxRecord writes a record instantiating the template at def, or defining
+// it inline when def is zero. It returns the template offset.
+func evtxRecord(chunk []byte, off int, id uint64, ts time.Time, def int, provider string, eventId uint16, sid []byte, data string) (int, int) {
+
+	b := &bxBuilder{base: off + evtxRecordHeader}
+	b.u8(bxFragment)
+	b.u8(1)
+	b.u8(1)
+	b.u8(0)
+	b.u8(bxTemplate)
+	b.u8(1)
+	b.u32(1)
+
+	if def == 0 {
+		def = b.pos() + 4
+		b.u32(uint32(def))
+		b.u32(0)
+		b.buf = append(b.buf, make([]byte, 16)...)
+		body := &bxBuilder{base: b.pos() + 4}
+		evtxTemplateBody(body)
+		b.u32(uint32(len(body.buf)))
+		b.buf = append(b.buf, body.buf...)
+	} else {
+		b.u32(uint32(def))
+	}
+
+	vals := &bxBuilder{}
+	vals.utf16(provider)
+	providerSize := len(vals.buf)
+	vals.u16(eventId)
+	vals.u64(evtxFiletime(ts))
+	vals.buf = append(vals.buf, sid...)
+	dataStart := len(vals.buf)
+	vals.utf16(data)
+
+	b.u32(5)
+	for _, d := range []struct {
+		size int
+		typ  byte
+	}{
+		{providerSize, evString}, {2, evUint16}, {8, evFiletime}, {len(sid), evSid}, {len(vals.buf) - dataStart, evString},
+	} {
+		if d.size == 0 {
+			d.typ = evNull
+		}
+		b.u16(uint16(d.size))
+		b.u8(d.typ)
+		b.u8(0)
+	}
+	b.buf = append(b.buf, vals.buf...)
+	b.u8(bxEOF)
+
+	size := evtxRecordHeader + len(b.buf) + 4
+	binary.LittleEndian.PutUint32(chunk[off:], evtxRecordMagic)
+	binary.LittleEndian.PutUint32(chunk[off+4:], uint32(size))
+	binary.LittleEndian.PutUint64(chunk[off+8:], id)
+	binary.LittleEndian.PutUint64(chunk[off+16:], evtxFiletime(ts))
+	copy(chunk[off+evtxRecordHeader:], b.buf)
+	binary.LittleEndian.PutUint32(chunk[off+size-4:], uint32(size))
+
+	return off + size, def
+}
+
+func TestEvtx(t *testing.T) {
+
+	var (
+		ts0   = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+		ts1   = ts0.Add(time.Second)
+		chunk = make([]byte, evtxChunkSize)
+		sid   = []byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0}
+	)
+
+	copy(chunk, evtxChunkMagic)
+	binary.LittleEndian.PutUint64(chunk[8:], 1)
+
+	off, def := evtxRecord(chunk, evtxChunkHeader, 1, ts0, 0, "Service Control Manager", 7031, nil, "a <b> & c")
+	off, _ = evtxRecord(chunk, off, 2, ts1, def, "Security", 4625, sid, "logon failed")
+	binary.LittleEndian.PutUint32(chunk[48:], uint32(off))
+
+	file := make([]byte, evtxFileHeaderSize)
+	copy(file, evtxFileMagic)
+	file = append(file, chunk...)
+
+	path := filepath.Join(t.TempDir(), "System.evtx")
+	if err := os.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := newLogSrc(path)
+	if err != nil {
+		t.Fatalf("newLogSrc: %v", err)
+	}
+	defer src.Close()
+
+	if src.ts != ts0.UnixNano() || src.factory.String() != "json" {
+		t.Errorf("Expected json log starting at %v, got %s at %d", ts0, src.factory.String(), src.ts)
+	}
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const ns = `<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event">`
+	want := []remoteLineT{
+		{Time: ts0, Stream: streamStdout, Log: ns + `<System><Provider Name="Service Control Manager"/><EventID>7031</EventID><TimeCreated SystemTime="2024-05-01T10:00:00Z"/><Security/></System><EventData><Data>a &lt;b&gt; &amp; c</Data></EventData></Event>`},
+		{Time: ts1, Stream: streamStdout, Log: ns + `<System><Provider Name="Security"/><EventID>4625</EventID><TimeCreated SystemTime="2024-05-01T10:00:01Z"/><Security UserID="S-1-5-18"/></System><EventData><Data>logon failed</Data></EventData></Event>`},
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d lines, got %d: %s", len(want), len(lines), data)
+	}
+	for i, line := range lines {
+		var got remoteLineT
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want[i] {
+			t.Errorf("Record %d:\nexpected %+v\n     got %+v", i, want[i], got)
+		}
+	}
+
+	t.Run("corrupt", func(t *testing.T) {
+		bad := filepath.Join(t.TempDir(), "bad.evtx")
+		os.WriteFile(bad, []byte("not an event log"), 0644)
+		if _, err := newLogSrc(bad); !errors.Is(err, ErrEvtxCorrupt) {
+			t.Errorf("Expected ErrEvtxCorrupt, got %v", err)
+		}
+	})
+}
+
+func TestEventLog(t *testing.T) {
+
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	spec, err := parseEventLog("System?query=*[System[Level=2]]&start=2h", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.channel != "System" || spec.query != "*[System[Level=2]]" || !spec.start.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("Unexpected spec: %+v", spec)
+	}
+
+	if _, err := parseEventLog("?query=*", now); !errors.Is(err, ErrMissingChannel) {
+		t.Errorf("Expected ErrMissingChannel, got %v", err)
+	}
+
+	if q := eventLogQuery(spec, 42); !strings.Contains(q, `<Select Path="System">*[System[Level=2]]</Select>`) || !strings.Contains(q, "EventRecordID&lt;=42") {
+		t.Errorf("Unexpected query: %s", q)
+	}
+
+	ev, id := eventFromXml(`<Event><System><TimeCreated SystemTime='2024-05-01T09:59:59.1234567Z'/><EventRecordID>42</EventRecordID></System></Event>`)
+	if id != 42 || !ev.ts.Equal(time.Date(2024, 5, 1, 9, 59, 59, 123456700, time.UTC)) {
+		t.Errorf("Unexpected event: %v %d", ev.ts, id)
+	}
+}
+
+func TestBegin(t *testing.T) {
+
+	var (
+		b     strings.Builder
+		start = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		lines = 50000
+	)
+	for i := range lines {
+		fmt.Fprintf(&b, "%s line %d\n", start.Add(time.Duration(i)*time.Second).Format(time.RFC3339), i)
+	}
+	content := b.String()
+
+	path := createTestFile(t, t.TempDir(), "big.log", content, false)
+	begin := start.Add(40000 * time.Second)
+
+	check := func(t *testing.T, rd io.Reader) {
+		t.Helper()
+		data, err := io.ReadAll(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(got) != lines-40000 || !strings.HasSuffix(got[0], " line 40000") {
+			t.Errorf("Expected %d lines from line 40000, got %d starting %q", lines-40000, len(got), got[0])
+		}
+	}
+
+	t.Run("seek", func(t *testing.T) {
+		src, err := newLogSrc(path, WithBegin(begin))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+		check(t, src)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		lds, err := PipeReader(strings.NewReader(content), WithBegin(begin))
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, lds[0].Logs[0])
+	})
+
+	t.Run("search", func(t *testing.T) {
+		fh, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fh.Close()
+
+		factory, _, _ := NewLogFactory([]byte(content[:1024]))
+		off, err := searchBegin(fh, factory.New(), begin.UnixNano())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		target := int64(strings.Index(content, begin.Format(time.RFC3339)))
+		if off > target || target-off > 2*beginSeekMin {
+			t.Errorf("Expected an offset shortly before %d, got %d", target, off)
+		}
+	})
+}
+
+func TestSourceLabels(t *testing.T) {
+	path := createTestFile(t, t.TempDir(), "app.log", "2023-10-28T10:40:01Z started\n", false)
+
+	ds, err := ParseSources([]byte(fmt.Sprintf(`
+version: 0.0.1
+sources:
+  - name: app
+    type: app
+    labels:
+      env: prod
+      service: checkout
+    locations:
+      - path: %s
+`, path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lds := Resolve(ds)
+	if len(lds) != 1 {
+		t.Fatalf("Expected 1 source, got %d", len(lds))
+	}
+	defer lds[0].Close()
+
+	meta := lds[0].Meta()
+	if len(meta) != 2 || meta["env"] != "prod" || meta["service"] != "checkout" {
+		t.Errorf("Unexpected labels: %v", meta)
+	}
+}
+
+func TestSourceTimestamps(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		nginx = createTestFile(t, dir, "access.log", "10.0.0.1 - - [28/Oct/2023:10:40:01 +0200] \"GET / HTTP/1.1\" 200\n", false)
+		pg    = createTestFile(t, dir, "pg.log", "28.10.2023 10:40:02 LOG:  checkpoint starting\n", false)
+	)
+
+	ds, err := ParseSources([]byte(fmt.Sprintf(`
+version: 0.0.1
+sources:
+  - name: nginx
+    type: nginx
+    timestamps:
+      - pattern: '\[(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]'
+        format: 02/Jan/2006:15:04:05 -0700
+    locations:
+      - path: %s
+  - name: postgres
+    type: postgres
+    timestamps:
+      - pattern: '^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})'
+        format: 2006-01-02 15:04:05
+      - pattern: '^(\d{2}\.\d{2}\.\d{4} \d{2}:\d{2}:\d{2})'
+        format: 02.01.2006 15:04:05
+    locations:
+      - path: %s
+`, nginx, pg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lds := Resolve(ds)
+	if len(lds) != 2 {
+		t.Fatalf("Expected 2 sources, got %d", len(lds))
+	}
+
+	want := []time.Time{
+		time.Date(2023, 10, 28, 8, 40, 1, 0, time.UTC),
+		time.Date(2023, 10, 28, 10, 40, 2, 0, time.UTC),
+	}
+	for i, ld := range lds {
+		defer ld.Close()
+		lsrc := ld.Logs[0].(*logSrc)
+		if lsrc.ts != want[i].UnixNano() {
+			t.Errorf("%s: expected %v, got %v", ld.Name(), want[i], time.Unix(0, lsrc.ts).UTC())
+		}
+	}
+}
+
+func TestSourceTimestampsInvalid(t *testing.T) {
+	path := createTestFile(t, t.TempDir(), "app.log", "2023-10-28T10:40:01Z started\n", false)
+
+	tests := map[string]string{
+		"missing format": "timestamp:\n      regex: '^(\\S+)'",
+		"bad regex":      "timestamps:\n      - pattern: '^(\\S+'\n        format: rfc3339",
+		"missing regex":  "timestamps:\n      - format: rfc3339",
+	}
+
+	for name, ts := range tests {
+		t.Run(name, func(t *testing.T) {
+			ds, err := ParseSources([]byte(fmt.Sprintf(`
+sources:
+  - name: app
+    type: app
+    %s
+    locations:
+      - path: %s
+`, ts, path)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = resolveSource(ds.Sources[0]); !errors.Is(err, ErrInvalidTimestamp) {
+				t.Errorf("Expected ErrInvalidTimestamp, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMmap(t *testing.T) {
+	defer func(old int64) { mmapThreshold = old }(mmapThreshold)
+	mmapThreshold = 1
+
+	var b strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&b, "2023-10-28T10:40:%02dZ line %d\n", i%60, i)
+	}
+	path := createTestFile(t, t.TempDir(), "big.log", b.String(), false)
+
+	lsrc, err := newLogSrc(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsrc.Close()
+
+	if lsrc.mm == nil && runtime.GOOS != "windows" {
+		t.Fatal("Expected file to be mapped")
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lsrc.Size() != int64(len(want)) {
+		t.Errorf("Expected size %d, got %d", len(want), lsrc.Size())
+	}
+
+	data, err := io.ReadAll(lsrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Error("Mapped content differs from file")
+	}
+
+	// A file truncated while mapped is read on instead of faulting
+	if lsrc.mm != nil {
+		lsrc, err = newLogSrc(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lsrc.Close()
+
+		head := make([]byte, 100)
+		if _, err := io.ReadFull(lsrc, head); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(path, 0); err != nil {
+			t.Fatal(err)
+		}
+		if rest, err := io.ReadAll(lsrc); err != nil || len(rest) != 0 {
+			t.Errorf("Expected the truncated file read to its end, got %d bytes (%v)", len(rest), err)
+		}
+		os.WriteFile(path, want, 0644)
+	}
+
+	// Ranges seek the file instead
+	lsrc, err = newLogSrc(path, WithRange(&RangeSpec{Lines: "-10"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsrc.Close()
+	if lsrc.mm != nil {
+		t.Error("Expected range to read the file")
+	}
+}
+
+func TestTimezone(t *testing.T) {
+	for name, offset := range map[string]int{"+02:00": 7200, "-0700": -25200, "UTC": 0} {
+		loc, err := ParseTimezone(name)
+		if err != nil {
+			t.Fatalf("ParseTimezone(%q): %v", name, err)
+		}
+		if _, got := time.Date(2024, 1, 1, 0, 0, 0, 0, loc).Zone(); got != offset {
+			t.Errorf("ParseTimezone(%q): expected offset %d, got %d", name, offset, got)
+		}
+	}
+	if _, err := ParseTimezone("Mars/Olympus"); !errors.Is(err, ErrInvalidTimezone) {
+		t.Errorf("Expected ErrInvalidTimezone, got %v", err)
+	}
+
+	var (
+		dir    = t.TempDir()
+		naive  = createTestFile(t, dir, "naive.log", "2023-10-28 10:40:01 started\n", false)
+		zoned  = createTestFile(t, dir, "zoned.log", "2023-10-28T10:40:01Z started\n", false)
+		logfmt = createTestFile(t, dir, "logfmt.log", "time=\"2023-10-28 10:40:01\" level=info msg=started\n", false)
+	)
+
+	ds, err := ParseSources([]byte(fmt.Sprintf(`
+sources:
+  - name: naive
+    type: naive
+    timezone: "+02:00"
+    timestamps:
+      - pattern: '^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})'
+        format: 2006-01-02 15:04:05
+    locations:
+      - path: %s
+  - name: zoned
+    type: zoned
+    timezone: "+02:00"
+    locations:
+      - path: %s
+  - name: logfmt
+    type: logfmt
+    locations:
+      - path: %s
+`, naive, zoned, logfmt)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The global zone applies to sources that do not set their own
+	est, _ := ParseTimezone("-05:00")
+	lds := Resolve(ds, WithTimezone(est))
+	if len(lds) != 3 {
+		t.Fatalf("Expected 3 sources, got %d", len(lds))
+	}
+
+	want := []time.Time{
+		time.Date(2023, 10, 28, 8, 40, 1, 0, time.UTC),
+		time.Date(2023, 10, 28, 10, 40, 1, 0, time.UTC),
+		time.Date(2023, 10, 28, 15, 40, 1, 0, time.UTC),
+	}
+	for i, ld := range lds {
+		defer ld.Close()
+		if ts := ld.Logs[0].(*logSrc).ts; ts != want[i].UnixNano() {
+			t.Errorf("%s: expected %v, got %v", ld.Name(), want[i], time.Unix(0, ts).UTC())
+		}
+	}
+}
+
+func TestSyslogYear(t *testing.T) {
+	var (
+		data  = "Dec 31 23:59:58 host app[12]: rotating\nJan  1 00:00:01 host app[12]: started\n"
+		path  = createTestFile(t, t.TempDir(), "syslog", data, false)
+		mtime = time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+		stamp = WithStampRegex(FmtSpec{Pattern: `^([A-Z][a-z]{2}\s{1,2}\d{1,2}\s\d{2}:\d{2}:\d{2}) `, Format: "Jan 2 15:04:05"})
+	)
+
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(t *testing.T, opts ...OptT) []time.Time {
+		lsrc, err := newLogSrc(path, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lsrc.Close()
+
+		var out []time.Time
+		parser := lsrc.Parser()
+		for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+			entry, err := parser.ReadEntry([]byte(line))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, time.Unix(0, entry.Timestamp).UTC())
+		}
+		return out
+	}
+
+	t.Run("modification time", func(t *testing.T) {
+		got := read(t, stamp)
+		want := []time.Time{
+			time.Date(2023, 12, 31, 23, 59, 58, 0, time.UTC),
+			time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC),
+		}
+		if !got[0].Equal(want[0]) || !got[1].Equal(want[1]) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("explicit year", func(t *testing.T) {
+		got := read(t, stamp, WithYear(2020))
+		want := []time.Time{
+			time.Date(2020, 12, 31, 23, 59, 58, 0, time.UTC),
+			time.Date(2020, 1, 1, 0, 0, 1, 0, time.UTC),
+		}
+		if !got[0].Equal(want[0]) || !got[1].Equal(want[1]) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	})
+}
+
+func TestFormatHint(t *testing.T) {
+
+	want := time.Date(2024, 5, 1, 10, 0, 0, 123000000, time.UTC).UnixNano()
+
+	tests := []struct {
+		format string
+		line   string
+		entry  string
+	}{
+		{FormatJSON, `{"level":"error","ts":1714557600.123,"msg":"boom"}`, `{"level":"error","ts":1714557600.123,"msg":"boom"}`},
+		{FormatJSON, `{"@timestamp":"2024-05-01T10:00:00.123Z","message":"boom"}`, `{"@timestamp":"2024-05-01T10:00:00.123Z","message":"boom"}`},
+		{FormatJSON, `{"log":"boom\n","stream":"stderr","time":"2024-05-01T10:00:00.123Z"}`, "boom"},
+		{FormatLogfmt, `ts=2024-05-01T10:00:00.123Z level=error msg=boom`, `ts=2024-05-01T10:00:00.123Z level=error msg=boom`},
+		{FormatCRI, `2024-05-01T10:00:00.123Z stderr F boom`, "boom"},
+	}
+
+	for _, tc := range tests {
+		factory, ts, err := NewLogFactory([]byte(tc.line+"\n"), WithFormat(tc.format))
+		if err != nil {
+			t.Errorf("%s %q: %v", tc.format, tc.line, err)
+			continue
+		}
+		if ts != want {
+			t.Errorf("%s %q: expected ts %d, got %d", tc.format, tc.line, want, ts)
+		}
+		entry, err := factory.New().ReadEntry([]byte(tc.line))
+		if err != nil || entry.Line != tc.entry {
+			t.Errorf("%s %q: expected entry %q, got %q: %v", tc.format, tc.line, tc.entry, entry.Line, err)
+		}
+	}
+
+	t.Run("plain", func(t *testing.T) {
+		factory, _, err := NewLogFactory([]byte("no timestamp here\n"), WithFormat(FormatPlain))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parser := factory.New()
+		a, _ := parser.ReadEntry([]byte("first"))
+		b, _ := parser.ReadEntry([]byte("second"))
+		if a.Line != "first" || b.Timestamp <= a.Timestamp {
+			t.Errorf("Expected increasing stamps, got %d then %d", a.Timestamp, b.Timestamp)
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		if _, _, err := NewLogFactory([]byte("level=info msg=started\n"), WithFormat(FormatJSON)); err == nil {
+			t.Error("Expected logfmt input to fail as json")
+		}
+		if err := ValidateFormat("xml"); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("Expected ErrUnknownFormat, got %v", err)
+		}
+	})
+}
+
+func TestSocket(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		lines = "2024-05-01T10:00:00Z first\n2024-05-01T10:00:01Z second\n"
+	)
+
+	readAll := func(t *testing.T, srcs []LogSrcI, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srcs[0].Close()
+		data, err := io.ReadAll(srcs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	t.Run("listen", func(t *testing.T) {
+		path := filepath.Join(dir, "listen.sock")
+
+		// A socket left behind by a process that is gone is replaced
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln.(*net.UnixListener).SetUnlinkOnClose(false)
+		ln.Close()
+
+		go func() {
+			for i := 0; i < 100; i++ {
+				conn, err := net.Dial("unix", path)
+				if err != nil {
+					time.Sleep(10 * time.Millisecond)
+					continue
+				}
+				io.WriteString(conn, lines)
+				conn.Close()
+				return
+			}
+		}()
+
+		srcs, err := resolveSocket(datasrc.Location{Type: locationSocket, Path: path})
+		if got := readAll(t, srcs, err); got != lines {
+			t.Errorf("Expected %q, got %q", lines, got)
+		}
+		if srcs[0].Name() != "socket:"+path {
+			t.Errorf("Unexpected name %q", srcs[0].Name())
+		}
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected socket removed on close, got %v", err)
+		}
+	})
+
+	t.Run("connect", func(t *testing.T) {
+		path := filepath.Join(dir, "connect.sock")
+
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(conn, lines)
+			conn.Close()
+		}()
+
+		srcs, err := resolveSocket(datasrc.Location{Type: locationSocket, Path: path + "?mode=connect"})
+		if got := readAll(t, srcs, err); got != lines {
+			t.Errorf("Expected %q, got %q", lines, got)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, path := range []string{"", "/tmp/x.sock?mode=bind", "/tmp/x.sock?network=tcp", "/tmp/x.sock?mode=connect&network=unixgram"} {
+			if _, err := parseSocket(path); !errors.Is(err, ErrInvalidSocket) {
+				t.Errorf("%q: expected ErrInvalidSocket, got %v", path, err)
+			}
+		}
+		if _, err := resolveSocket(datasrc.Location{Type: locationSocket, Path: filepath.Join(dir, "d.sock?network=unixgram")}); !errors.Is(err, ErrInvalidSocket) {
+			t.Errorf("Expected unixgram without follow to fail, got %v", err)
+		}
+	})
+}
+
+func TestResume(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		start = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		b     strings.Builder
+	)
+	for i := range 10 {
+		fmt.Fprintf(&b, "%s line %d\n", start.Add(time.Duration(i)*time.Second).Format(time.RFC3339), i)
+	}
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resolveWith := func(t *testing.T, opts ...OptT) []string {
+		t.Helper()
+		ds, err := ParseSources([]byte("sources:\n  - name: api\n    type: app\n    locations:\n      - path: " + path + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lds := Resolve(ds, opts...)
+		if len(lds) != 1 {
+			t.Fatalf("Expected 1 source, got %d", len(lds))
+		}
+		defer lds[0].Close()
+		data, err := io.ReadAll(lds[0].Logs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	last := start.Add(6 * time.Second).UnixNano()
+
+	// Lines stamped at the position are read again; the engine tells
+	// those already scanned
+	got := resolveWith(t, WithResume(map[string]int64{SourceKey("api", "app"): last}))
+	if len(got) != 4 || !strings.HasSuffix(got[0], " line 6") {
+		t.Errorf("Expected to resume at line 6, got %q", got)
+	}
+
+	// A later --begin wins
+	got = resolveWith(t, WithResume(map[string]int64{SourceKey("api", "app"): last}), WithBegin(start.Add(8*time.Second)))
+	if len(got) != 2 || !strings.HasSuffix(got[0], " line 8") {
+		t.Errorf("Expected to begin at line 8, got %q", got)
+	}
+
+	// Other sources are read in full
+	got = resolveWith(t, WithResume(map[string]int64{SourceKey("other", "app"): last}))
+	if len(got) != 10 {
+		t.Errorf("Expected 10 lines, got %d", len(got))
+	}
+}
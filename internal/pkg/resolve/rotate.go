@@ -0,0 +1,45 @@
+package resolve
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Rotated siblings share the live file's name plus a numeric or dated
+// suffix, optionally compressed: app.log.1, app.log.2.gz, app.log-20240101.
+// Their order is decided by the first timestamp in each, not the suffix.
+var rotatedSuffix = regexp.MustCompile(`^[.-]\d+(\.(gz|bz2|xz))?$`)
+
+// expandRotated adds the rotated siblings of each match, keeping the
+// matches first and dropping duplicates.
+func expandRotated(matches []string) []string {
+
+	var out = slices.Clone(matches)
+
+	for _, match := range matches {
+		var (
+			dir, base = filepath.Split(match)
+			entries   []os.DirEntry
+			err       error
+		)
+
+		if entries, err = os.ReadDir(filepath.Clean(dir)); err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.HasPrefix(name, base) || !rotatedSuffix.MatchString(name[len(base):]) {
+				continue
+			}
+			if sib := dir + name; !slices.Contains(out, sib) {
+				out = append(out, sib)
+			}
+		}
+	}
+
+	return out
+}
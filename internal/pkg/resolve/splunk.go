@@ -0,0 +1,258 @@
+package resolve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+)
+
+// A Splunk location points at the management port and carries either an
+// ad-hoc search or the name of a saved search as parameters.
+//
+//	locations:
+//	  - type: splunk
+//	    path: https://splunk:8089?search=index=k8s sourcetype=kube:container error&start=4h
+//	  - type: splunk
+//	    path: https://splunk:8089?saved=Prod API errors&app=ops&start=24h
+//
+// Parameters: search or saved (one is required), app and owner (namespace
+// of a saved search; default search and nobody), start and end (RFC3339,
+// "now" or a duration before now; default the last hour) and token_env
+// (environment variable holding an authentication token). Basic auth may be
+// given in the URL. Results are requested in time order and read from the
+// _time and _raw fields.
+
+const (
+	locationSplunk     = "splunk"
+	splunkExport       = "/servicesNS/%s/%s/search/v2/jobs/export"
+	splunkDefaultApp   = "search"
+	splunkDefaultOwner = "nobody"
+	splunkBatchSize    = 1000
+)
+
+type splunkSpec struct {
+	base     string
+	search   string
+	saved    string
+	app      string
+	owner    string
+	start    time.Time
+	end      time.Time
+	tokenEnv string
+}
+
+func parseSplunk(path string, now time.Time) (*splunkSpec, error) {
+
+	base, query, _ := strings.Cut(path, "?")
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &splunkSpec{
+		base:     strings.TrimSuffix(base, "/"),
+		search:   params.Get("search"),
+		saved:    params.Get("saved"),
+		app:      splunkDefaultApp,
+		owner:    splunkDefaultOwner,
+		tokenEnv: params.Get("token_env"),
+	}
+
+	switch {
+	case spec.search == "" && spec.saved == "":
+		return nil, ErrMissingQuery
+	case spec.search != "" && spec.saved != "":
+		return nil, errors.New("search and saved are mutually exclusive")
+	}
+
+	if a := params.Get("app"); a != "" {
+		spec.app = a
+	}
+	if o := params.Get("owner"); o != "" {
+		spec.owner = o
+	}
+
+	if spec.start, err = parseTimeBound(params.Get("start"), now, now.Add(-defaultLookback)); err != nil {
+		return nil, err
+	}
+	if spec.end, err = parseTimeBound(params.Get("end"), now, now); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// query builds the SPL, forcing ascending time order.
+func (s *splunkSpec) query() string {
+	if s.saved != "" {
+		return fmt.Sprintf("| savedsearch %q | sort 0 _time", s.saved)
+	}
+
+	q := strings.TrimSpace(s.search)
+	if !strings.HasPrefix(q, "|") && !strings.HasPrefix(q, "search ") {
+		q = "search " + q
+	}
+	return q + " | sort 0 _time"
+}
+
+type splunkResultT struct {
+	Preview bool `json:"preview"`
+	Result  struct {
+		Time string `json:"_time"`
+		Raw  string `json:"_raw"`
+	} `json:"result"`
+	Messages []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"messages"`
+}
+
+func resolveSplunk(location datasrc.Location, opts ...OptT) ([]LogSrcI, error) {
+
+	spec, err := parseSplunk(location.Path, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if location.Window != 0 {
+		opts = append(opts, WithWindow(int64(location.Window)))
+	}
+
+	name := spec.saved
+	if name == "" {
+		name = spec.search
+	}
+
+	var (
+		follow = parseOpts(opts...).follow != nil
+		client = httpz.New(httpz.WithName(locationSplunk))
+		src    = newRemoteSrc(locationSplunk+":"+name, splunkFetch(client, spec, follow), opts...)
+	)
+
+	return []LogSrcI{src}, nil
+}
+
+// splunkFetch streams the export endpoint in batches. In follow mode the
+// search is re-run for events newer than the last one seen.
+func splunkFetch(client *http.Client, spec *splunkSpec, follow bool) fetchT {
+
+	var (
+		body  io.ReadCloser
+		dec   *json.Decoder
+		bound boundaryT
+	)
+
+	return func(ctx context.Context) ([]eventT, error) {
+
+		if dec == nil {
+			end := spec.end
+			if follow {
+				end = time.Now()
+			}
+
+			var err error
+			if body, err = splunkExportSearch(ctx, client, spec, bound.from(spec.start), end); err != nil {
+				return nil, err
+			}
+			dec = json.NewDecoder(body)
+		}
+
+		var events []eventT
+		for len(events) < splunkBatchSize {
+			var res splunkResultT
+			err := dec.Decode(&res)
+
+			switch {
+			case err == io.EOF:
+				body.Close()
+				body, dec = nil, nil
+				if !follow {
+					return events, io.EOF
+				}
+				if len(events) == 0 {
+					return nil, pollWait(ctx, defaultPollInterval)
+				}
+				return events, nil
+			case err != nil:
+				body.Close()
+				return nil, err
+			}
+
+			for _, m := range res.Messages {
+				if m.Type == "FATAL" || m.Type == "ERROR" {
+					body.Close()
+					return nil, fmt.Errorf("splunk search failed: %s", m.Text)
+				}
+			}
+
+			if res.Preview || res.Result.Time == "" {
+				continue
+			}
+
+			ts, err := time.Parse(time.RFC3339Nano, res.Result.Time)
+			if err != nil {
+				body.Close()
+				return nil, fmt.Errorf("invalid splunk _time %q: %w", res.Result.Time, err)
+			}
+
+			// Each search resumes at the newest _time delivered; skip
+			// the results at that time which were already returned.
+			ev := eventT{ts: ts, line: strings.TrimRight(res.Result.Raw, "\n")}
+			if bound.keep(ev) {
+				events = append(events, ev)
+			}
+		}
+
+		return events, nil
+	}
+}
+
+func splunkExportSearch(ctx context.Context, client *http.Client, spec *splunkSpec, start, end time.Time) (io.ReadCloser, error) {
+
+	form := url.Values{}
+	form.Set("search", spec.query())
+	form.Set("output_mode", "json")
+	form.Set("earliest_time", splunkTime(start))
+	form.Set("latest_time", splunkTime(end))
+
+	reqURL := spec.base + fmt.Sprintf(splunkExport, url.PathEscape(spec.owner), url.PathEscape(spec.app))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if spec.tokenEnv != "" {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(spec.tokenEnv))
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("splunk search failed: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return res.Body, nil
+}
+
+// splunkTime formats epoch seconds with millisecond precision.
+func splunkTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', 3, 64)
+}
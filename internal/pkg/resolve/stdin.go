@@ -1,6 +1,7 @@
 package resolve
 
 import (
+	"bufio"
 	"bytes"
 	"io"
 	"os"
@@ -24,7 +25,13 @@ func PipeStdin(opts ...OptT) ([]*LogData, error) {
 }
 
 func PipeEval(data []byte, opts ...OptT) ([]*LogData, error) {
-	rdr, err := newPipeReader(bytes.NewReader(data), opts...)
+	return PipeReader(bytes.NewReader(data), opts...)
+}
+
+// PipeReader resolves an arbitrary stream (e.g. a browser Blob) without
+// buffering it in full; only the detection sample is held in memory.
+func PipeReader(r io.Reader, opts ...OptT) ([]*LogData, error) {
+	rdr, err := newPipeReader(r, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -39,15 +46,12 @@ func PipeEval(data []byte, opts ...OptT) ([]*LogData, error) {
 
 func newPipeReader(r io.Reader, opts ...OptT) (*PipeRdrT, error) {
 	// Read a sample to detect format
-	buf := make([]byte, detectSampleSize)
-	n, err := io.ReadFull(r, buf)
-	switch err {
-	case nil, io.ErrUnexpectedEOF: // NOOP
-	default:
+	br := bufio.NewReaderSize(r, detectSampleSize)
+	buf, err := readSample(br, detectSampleSize)
+	if err != nil {
 		return nil, err
 	}
-
-	buf = buf[:n] // shrink to actual read size
+	r = br
 
 	// Perform detection
 	o := parseOpts(opts...)
@@ -57,22 +61,83 @@ func newPipeReader(r io.Reader, opts ...OptT) (*PipeRdrT, error) {
 		return nil, err
 	}
 
-	// Only fold on Regex or rfc3339Nano; doesn't make sense on CRI or JSON
+	// Only fold on Regex, rfc3339Nano or logfmt; doesn't make sense on CRI or JSON
 	var fold bool
 	switch factory.String() {
-	case format.FactoryRegex, format.FactoryRfc3339Nano:
+	case format.FactoryRegex, format.FactoryRfc3339Nano, FactoryLogfmt:
 		fold = true
 	}
 
+	if o.follow != nil {
+		r = newFollowRdr(o.follow, r)
+	}
+
+	var prologue = bytes.NewBuffer(buf)
+
+	// Begin times and ranges apply to the whole stream, including the
+	// detection sample
+	if o.begin != 0 {
+		if r, err = seekBegin(io.MultiReader(prologue, r), nil, factory, o.begin); err != nil {
+			return nil, err
+		}
+		prologue = nil
+	}
+
+	if !o.srcRange.IsZero() {
+		if prologue != nil {
+			r = io.MultiReader(prologue, r)
+			prologue = nil
+		}
+		if o.follow != nil && o.srcRange.suffix() {
+			r, err = tailFollow(o.follow, r, o.srcRange)
+		} else {
+			r, err = o.srcRange.apply(r, nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if isCri(factory) {
+		if prologue != nil {
+			r = io.MultiReader(prologue, r)
+			prologue = nil
+		}
+		r = newCriRdr(r)
+	}
+
 	return &PipeRdrT{
 		src:      r,
-		prologue: bytes.NewBuffer(buf),
+		prologue: prologue,
 		factory:  factory,
 		window:   o.window,
 		fold:     fold,
 	}, nil
 }
 
+// readSample reads the detection sample a line at a time. It stops early
+// at a line boundary with no more input buffered, so a live stream is
+// detected from what it has written so far instead of waiting for more.
+func readSample(br *bufio.Reader, size int) ([]byte, error) {
+	var buf []byte
+	for len(buf) < size {
+		line, err := br.ReadSlice('\n')
+		buf = append(buf, line...)
+		switch err {
+		case nil:
+			if br.Buffered() == 0 {
+				return buf, nil
+			}
+		case bufio.ErrBufferFull: // NOOP
+		case io.EOF:
+			return buf, nil
+		default:
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
 func _pipeStdin(opts ...OptT) (*PipeRdrT, error) {
 
 	fi, err := os.Stdin.Stat()
@@ -89,6 +154,8 @@ func _pipeStdin(opts ...OptT) (*PipeRdrT, error) {
 }
 
 type PipeRdrT struct {
+	name     string
+	closer   io.Closer
 	src      io.Reader
 	window   int64
 	prologue *bytes.Buffer
@@ -101,6 +168,9 @@ func (p *PipeRdrT) Parser() format.ParserI {
 }
 
 func (p *PipeRdrT) Close() error {
+	if p.closer != nil {
+		return p.closer.Close()
+	}
 	return nil
 }
 
@@ -109,6 +179,9 @@ func (p *PipeRdrT) Size() int64 {
 }
 
 func (p *PipeRdrT) Name() string {
+	if p.name != "" {
+		return p.name
+	}
 	return "stdin"
 }
 
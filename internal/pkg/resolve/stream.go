@@ -0,0 +1,391 @@
+package resolve
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+	"github.com/rs/zerolog/log"
+)
+
+// A socket location reads newline delimited logs from a unix domain
+// socket, so supervisors and log shippers can feed preq directly.
+//
+//	locations:
+//	  - type: socket
+//	    path: /run/preq.sock                        # preq listens; writers connect
+//	  - type: socket
+//	    path: /run/app/logs.sock?mode=connect       # preq connects and reads
+//	  - type: socket
+//	    path: /run/preq-dgram.sock?network=unixgram # one line per datagram
+//
+// Parameters: mode (listen or connect, default listen) and network (unix
+// or unixgram, listen only). Without --follow a listening socket reads a
+// single writer to the end; with it, writers are accepted until preq
+// exits and their lines interleave. Datagram sockets need --follow.
+//
+// Named pipes need no location type; a log path that is a FIFO is read
+// as a stream.
+
+const (
+	locationSocket = "socket"
+
+	socketListen   = "listen"
+	socketConnect  = "connect"
+	socketUnix     = "unix"
+	socketUnixgram = "unixgram"
+
+	maxDatagramSize = 64 * 1024
+)
+
+var (
+	ErrInvalidSocket = errors.New("invalid socket")
+	ErrEmptyFifo     = errors.New("named pipe has no writer")
+)
+
+type socketSpec struct {
+	path    string
+	mode    string
+	network string
+}
+
+func parseSocket(path string) (*socketSpec, error) {
+
+	path = strings.TrimPrefix(path, "unix://")
+	fn, query, _ := strings.Cut(path, "?")
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &socketSpec{
+		path:    fn,
+		mode:    params.Get("mode"),
+		network: params.Get("network"),
+	}
+
+	if spec.mode == "" {
+		spec.mode = socketListen
+	}
+	if spec.network == "" {
+		spec.network = socketUnix
+	}
+
+	switch {
+	case spec.path == "":
+		return nil, fmt.Errorf("%w: missing path", ErrInvalidSocket)
+	case spec.mode != socketListen && spec.mode != socketConnect:
+		return nil, fmt.Errorf("%w: mode %q", ErrInvalidSocket, spec.mode)
+	case spec.network != socketUnix && spec.network != socketUnixgram:
+		return nil, fmt.Errorf("%w: network %q", ErrInvalidSocket, spec.network)
+	case spec.network == socketUnixgram && spec.mode != socketListen:
+		return nil, fmt.Errorf("%w: unixgram sockets can only listen", ErrInvalidSocket)
+	}
+
+	return spec, nil
+}
+
+func resolveSocket(location datasrc.Location, opts ...OptT) ([]LogSrcI, error) {
+
+	spec, err := parseSocket(location.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if location.Window != 0 {
+		opts = append(opts, WithWindow(int64(location.Window)))
+	}
+
+	var (
+		o   = parseOpts(opts...)
+		ctx = context.Background()
+		rc  io.ReadCloser
+	)
+
+	if o.follow != nil {
+		ctx = o.follow
+	} else if spec.network == socketUnixgram {
+		return nil, fmt.Errorf("%w: unixgram sockets never end, use --follow", ErrInvalidSocket)
+	}
+
+	switch {
+	case spec.mode == socketConnect:
+		var conn net.Conn
+		if conn, err = net.Dial(socketUnix, spec.path); err != nil {
+			return nil, err
+		}
+		rc = closeOnDone(ctx, conn)
+
+	case spec.network == socketUnixgram:
+		removeStale(spec.network, spec.path)
+		var conn net.PacketConn
+		if conn, err = net.ListenPacket(socketUnixgram, spec.path); err != nil {
+			return nil, err
+		}
+		rc = closeOnDone(ctx, &datagramRdr{conn: conn, path: spec.path})
+
+	default:
+		removeStale(spec.network, spec.path)
+		var ln net.Listener
+		if ln, err = net.Listen(socketUnix, spec.path); err != nil {
+			return nil, err
+		}
+		rc = newListenRdr(ctx, ln, o.follow != nil)
+	}
+
+	log.Info().
+		Str("path", spec.path).
+		Str("mode", spec.mode).
+		Str("network", spec.network).
+		Msg("Reading socket")
+
+	src, err := newStreamSrc(locationSocket+":"+spec.path, rc, opts...)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return []LogSrcI{src}, nil
+}
+
+// removeStale removes a socket left behind by a process that is gone, so
+// listening on its path doesn't fail. A socket still in use is kept. The
+// socket preq listens on is removed again on close.
+func removeStale(network, path string) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+
+	conn, err := net.Dial(network, path)
+	if err == nil {
+		conn.Close()
+		return
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		if err = os.Remove(path); err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("Failed to remove stale socket")
+		}
+	}
+}
+
+// newStreamSrc detects the format of a stream that cannot be rewound and
+// reads it like stdin.
+func newStreamSrc(name string, rc io.ReadCloser, opts ...OptT) (*PipeRdrT, error) {
+	src, err := newPipeReader(rc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	src.name = name
+	src.closer = rc
+	return src, nil
+}
+
+// closeOnDone closes rc when ctx is cancelled so a blocked Read returns.
+func closeOnDone(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	if ctx.Done() == nil {
+		return rc
+	}
+	stop := context.AfterFunc(ctx, func() { rc.Close() })
+	return &stopCloser{ReadCloser: rc, stop: stop}
+}
+
+type stopCloser struct {
+	io.ReadCloser
+	stop func() bool
+}
+
+func (s *stopCloser) Close() error {
+	s.stop()
+	return s.ReadCloser.Close()
+}
+
+// listenRdr interleaves whole lines from every accepted connection. It
+// reads one connection to its end unless many is set, in which case it
+// accepts until the listener is closed.
+type listenRdr struct {
+	ln   net.Listener
+	pr   *io.PipeReader
+	pw   *io.PipeWriter
+	mux  sync.Mutex
+	once sync.Once
+	stop func() bool
+}
+
+func newListenRdr(ctx context.Context, ln net.Listener, many bool) *listenRdr {
+
+	pr, pw := io.Pipe()
+	l := &listenRdr{ln: ln, pr: pr, pw: pw}
+	l.stop = context.AfterFunc(ctx, func() { ln.Close() })
+
+	go l.accept(many)
+
+	return l
+}
+
+func (l *listenRdr) accept(many bool) {
+	var wg sync.WaitGroup
+
+	defer func() {
+		wg.Wait()
+		l.pw.Close()
+	}()
+
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Warn().Err(err).Str("addr", l.ln.Addr().String()).Msg("Failed to accept connection")
+			}
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.copyLines(conn)
+		}()
+
+		if !many {
+			return
+		}
+	}
+}
+
+// copyLines forwards complete lines so writers never interleave mid-line.
+func (l *listenRdr) copyLines(conn net.Conn) {
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+			l.mux.Lock()
+			_, werr := l.pw.Write(line)
+			l.mux.Unlock()
+			if werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (l *listenRdr) Read(p []byte) (int, error) {
+	return l.pr.Read(p)
+}
+
+func (l *listenRdr) Close() error {
+	var err error
+	l.once.Do(func() {
+		l.stop()
+		err = l.ln.Close()
+		l.pr.Close()
+		if errors.Is(err, net.ErrClosed) {
+			err = nil
+		}
+	})
+	return err
+}
+
+// datagramRdr turns each datagram into a line.
+type datagramRdr struct {
+	conn net.PacketConn
+	path string
+	buf  []byte
+	pend []byte
+}
+
+func (d *datagramRdr) Read(p []byte) (int, error) {
+	for len(d.pend) == 0 {
+		if d.buf == nil {
+			d.buf = make([]byte, maxDatagramSize)
+		}
+		n, _, err := d.conn.ReadFrom(d.buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		msg := d.buf[:n]
+		if n > 0 && msg[n-1] != '\n' {
+			msg = append(msg, '\n')
+		}
+		d.pend = msg
+	}
+	n := copy(p, d.pend)
+	d.pend = d.pend[n:]
+	return n, nil
+}
+
+func (d *datagramRdr) Close() error {
+	err := d.conn.Close()
+	os.Remove(d.path)
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	return err
+}
+
+// isFifo reports whether fn is a named pipe.
+func isFifo(fn string) bool {
+	info, err := os.Stat(fn)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// newFifoSrc reads a named pipe. Without a writer it fails instead of
+// waiting for one, unless following. In follow mode the pipe is also held open for writing, so it never reports
+// EOF when writers come and go.
+func newFifoSrc(fn string, opts ...OptT) (*PipeRdrT, error) {
+
+	var (
+		o    = parseOpts(opts...)
+		flag = os.O_RDONLY | fifoNonblock
+		ctx  = context.Background()
+	)
+
+	if o.follow != nil {
+		flag, ctx = os.O_RDWR, o.follow
+	}
+
+	fh, err := os.OpenFile(fn, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var rc io.ReadCloser = fh
+	if o.follow == nil {
+		br := bufio.NewReader(fh)
+		if _, err := br.Peek(1); err == io.EOF {
+			fh.Close()
+			return nil, fmt.Errorf("%w: %s", ErrEmptyFifo, fn)
+		}
+		rc = &struct {
+			io.Reader
+			io.Closer
+		}{br, fh}
+	}
+
+	src, err := newStreamSrc(fn, closeOnDone(ctx, rc), opts...)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	return src, nil
+}
@@ -0,0 +1,58 @@
+//go:build unix
+
+package resolve
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+)
+
+func TestFifo(t *testing.T) {
+	var (
+		path  = filepath.Join(t.TempDir(), "app.pipe")
+		lines = "2024-05-01T10:00:00Z first\n2024-05-01T10:00:01Z second\n"
+	)
+
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+
+	// Without a writer the pipe fails instead of blocking
+	if _, err := resolveLog(datasrc.Location{Path: path}, nil); !errors.Is(err, ErrEmptyFifo) {
+		t.Fatalf("Expected ErrEmptyFifo, got %v", err)
+	}
+
+	// Hold a reader so the writer can open and leave its lines pending
+	hold, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fh, lines)
+
+	srcs, err := resolveLog(datasrc.Location{Path: path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srcs[0].Close()
+
+	fh.Close()
+	hold.Close()
+
+	data, err := io.ReadAll(srcs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != lines {
+		t.Errorf("Expected %q, got %q", lines, data)
+	}
+}
@@ -0,0 +1,175 @@
+package resolve
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+	"github.com/prequel-dev/prequel-logmatch/pkg/format"
+	"github.com/prequel-dev/prequel-logmatch/pkg/timez"
+)
+
+var (
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+)
+
+// TimestampSpec is a candidate timestamp for a source. A source's
+// timestamps replace the global config list and are tried, in order,
+// before format detection; the first that parses the log wins.
+//
+//	timestamps:
+//	  - pattern: '\[(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]'
+//	    format: 02/Jan/2006:15:04:05 -0700
+//	  - pattern: '^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3} \w+)'
+//	    format: 2006-01-02 15:04:05.000 MST
+//
+// The single timestamp field of the source or a location instead forces
+// one format with no fallback.
+type TimestampSpec struct {
+	Pattern string `yaml:"pattern"`
+	Format  string `yaml:"format"`
+}
+
+func (s TimestampSpec) fmtSpec() FmtSpec {
+	return FmtSpec{
+		Pattern: strings.TrimSpace(s.Pattern),
+		Format:  TimestampFmt(strings.TrimSpace(s.Format)),
+	}
+}
+
+func validateStamp(pattern, format string) error {
+	switch {
+	case pattern == "" && format == "":
+		return nil
+	case pattern == "":
+		return fmt.Errorf("%w: format %q without a regex", ErrInvalidTimestamp, format)
+	case format == "":
+		return fmt.Errorf("%w: regex %q without a format", ErrInvalidTimestamp, pattern)
+	}
+
+	if _, err := regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidTimestamp, err)
+	}
+	if _, err := timez.GetTimestampFormat(TimestampFmt(format)); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidTimestamp, err)
+	}
+	return nil
+}
+
+// validateStamps checks every timestamp override of src so a typo fails
+// the source up front rather than silently falling back to detection.
+func validateStamps(src Source) error {
+
+	check := func(ts *datasrc.Timestamp) error {
+		if ts == nil {
+			return nil
+		}
+		return validateStamp(ts.Regex, ts.Format)
+	}
+
+	if err := check(src.Timestamp); err != nil {
+		return err
+	}
+	for _, loc := range src.Locations {
+		if err := check(loc.Timestamp); err != nil {
+			return err
+		}
+	}
+	for i, spec := range src.Timestamps {
+		s := spec.fmtSpec()
+		if s.Pattern == "" || s.Format == "" {
+			return fmt.Errorf("%w: timestamps[%d] needs a pattern and a format", ErrInvalidTimestamp, i)
+		}
+		if err := validateStamp(s.Pattern, string(s.Format)); err != nil {
+			return fmt.Errorf("timestamps[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// isLayout reports whether f is a Go time layout rather than one of the
+// named formats, which are either zoned or absolute.
+func isLayout(f TimestampFmt) bool {
+	switch f {
+	case timez.FmtRfc3339, timez.FmtRfc3339Nano, timez.FmtUnix,
+		timez.FmtEpochAny, timez.FmtEpochSeconds, timez.FmtEpochMillis,
+		timez.FmtEpochMicros, timez.FmtEpochNanos, timez.FmtDotNotation, "":
+		return false
+	}
+	return true
+}
+
+// tryStamp is timez.TryTimestampFormat, except that layouts without a
+// zone are read in o.location and those without a year take it from
+// o.yearRef.
+func tryStamp(spec FmtSpec, data []byte, o *optsT) (format.FactoryI, int64, error) {
+
+	maxTries := o.timestampTries
+
+	if !isLayout(spec.Format) {
+		return timez.TryTimestampFormat(spec.Pattern, spec.Format, data, maxTries)
+	}
+
+	factory, err := format.NewRegexFactory(spec.Pattern, layoutCb(string(spec.Format), o.location, o.yearRef))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The first lines may be a header; try up to maxTries more
+	var (
+		f  = factory.New()
+		ts int64
+	)
+	for try := 0; ; try++ {
+		if ts, err = f.ReadTimestamp(bytes.NewReader(data)); err == nil && ts != 0 {
+			return factory, ts, nil
+		}
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 || try >= maxTries {
+			break
+		}
+		data = data[idx+1:]
+	}
+
+	if err == nil {
+		err = timez.ErrInvalidTimestampFormat
+	}
+	return nil, 0, err
+}
+
+// layoutCb parses layout in loc, UTC if nil. Timestamps without a year,
+// like RFC 3164 syslog, take the year of ref (now if zero) unless that
+// would put them more than a month after ref; a log written up to ref
+// cannot hold later lines, so December lines in a January file belong to
+// the year before.
+func layoutCb(layout string, loc *time.Location, ref time.Time) format.TimeFormatCbT {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return func(m []byte) (int64, error) {
+		t, err := time.ParseInLocation(layout, string(m), loc)
+		if err != nil {
+			return 0, err
+		}
+		if t.Year() == 0 {
+			r := ref
+			if r.IsZero() {
+				r = time.Now()
+			}
+			t = withYear(r.In(loc), t)
+		}
+		return t.UnixNano(), nil
+	}
+}
+
+func withYear(ref, t time.Time) time.Time {
+	year := ref.Year()
+	if t.Month() > ref.Month()+1 {
+		year--
+	}
+	return time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
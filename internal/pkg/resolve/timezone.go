@@ -0,0 +1,46 @@
+package resolve
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	// Windows and WASM hosts have no zone database of their own
+	_ "time/tzdata"
+)
+
+var (
+	ErrInvalidTimezone = errors.New("invalid timezone")
+)
+
+// WithTimezone reads timestamps that carry no zone as wall time in loc
+// instead of UTC. Timestamps with a zone or offset are unaffected.
+func WithTimezone(loc *time.Location) func(*optsT) {
+	return func(o *optsT) {
+		o.location = loc
+	}
+}
+
+// ParseTimezone accepts an IANA name (Europe/Berlin), "Local", "UTC" or a
+// fixed offset (+02:00, -0700). Empty returns nil, meaning UTC.
+func ParseTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	if name[0] == '+' || name[0] == '-' {
+		for _, layout := range []string{"-07:00", "-0700", "-07"} {
+			if t, err := time.Parse(layout, name); err == nil {
+				_, offset := t.Zone()
+				return time.FixedZone(name, offset), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTimezone, name)
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTimezone, err)
+	}
+	return loc, nil
+}
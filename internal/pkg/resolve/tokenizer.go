@@ -0,0 +1,208 @@
+package resolve
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrInvalidTokenizer = errors.New("invalid tokenizer")
+	ErrRecordTooLarge   = errors.New("record too large")
+)
+
+const (
+	TokenizerNewline = "newline"
+	TokenizerFixed   = "fixed"
+	TokenizerLength  = "length"
+
+	maxRecordSize = 16 * 1024 * 1024
+)
+
+// TokenizerSpec selects how a source is split into records. Records are
+// re-emitted one per line, so the rest of the pipeline is unchanged.
+//
+//	tokenizer:
+//	  type: fixed       # newline (default), fixed or length
+//	  width: 256        # fixed: record size in bytes; trailing spaces and NULs are trimmed
+//
+//	tokenizer:
+//	  type: length
+//	  prefix: 4         # length: size of the length prefix in bytes (1, 2, 4 or 8)
+//	  order: little     # length: prefix byte order, big (default) or little
+type TokenizerSpec struct {
+	Type   string `yaml:"type"`
+	Width  int    `yaml:"width,omitempty"`
+	Prefix int    `yaml:"prefix,omitempty"`
+	Order  string `yaml:"order,omitempty"`
+}
+
+func (t *TokenizerSpec) IsZero() bool {
+	return t == nil || t.Type == "" || t.Type == TokenizerNewline
+}
+
+// TokenizerI reads the next record, without a trailing delimiter, from br.
+// It returns io.EOF when no further record is available.
+type TokenizerI interface {
+	Next(br *bufio.Reader) ([]byte, error)
+}
+
+func (t *TokenizerSpec) validate() error {
+	_, err := t.tokenizer()
+	return err
+}
+
+func (t *TokenizerSpec) tokenizer() (TokenizerI, error) {
+	switch t.Type {
+	case "", TokenizerNewline:
+		return newlineTokenizer{}, nil
+
+	case TokenizerFixed:
+		if t.Width <= 0 || t.Width > maxRecordSize {
+			return nil, fmt.Errorf("%w: width %d", ErrInvalidTokenizer, t.Width)
+		}
+		return fixedTokenizer{width: t.Width}, nil
+
+	case TokenizerLength:
+		var order binary.ByteOrder
+		switch t.Order {
+		case "", "big":
+			order = binary.BigEndian
+		case "little":
+			order = binary.LittleEndian
+		default:
+			return nil, fmt.Errorf("%w: order %q", ErrInvalidTokenizer, t.Order)
+		}
+		switch t.Prefix {
+		case 1, 2, 4, 8:
+		default:
+			return nil, fmt.Errorf("%w: prefix %d", ErrInvalidTokenizer, t.Prefix)
+		}
+		return lengthTokenizer{prefix: t.Prefix, order: order}, nil
+	}
+
+	return nil, fmt.Errorf("%w: type %q", ErrInvalidTokenizer, t.Type)
+}
+
+// apply returns rd re-framed as newline-delimited records.
+func (t *TokenizerSpec) apply(rd io.Reader) (io.Reader, error) {
+	if t.IsZero() {
+		return rd, nil
+	}
+
+	tok, err := t.tokenizer()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenRdr{br: bufio.NewReader(rd), tok: tok}, nil
+}
+
+type newlineTokenizer struct{}
+
+func (newlineTokenizer) Next(br *bufio.Reader) ([]byte, error) {
+	line, err := br.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	return bytes.TrimRight(line, "\r\n"), err
+}
+
+type fixedTokenizer struct {
+	width int
+}
+
+func (f fixedTokenizer) Next(br *bufio.Reader) ([]byte, error) {
+	rec := make([]byte, f.width)
+	n, err := io.ReadFull(br, rec)
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		// Keep a short final record
+		err = nil
+	case err != nil:
+		return nil, err
+	}
+	return bytes.TrimRight(rec[:n], " \x00"), nil
+}
+
+type lengthTokenizer struct {
+	prefix int
+	order  binary.ByteOrder
+}
+
+func (l lengthTokenizer) Next(br *bufio.Reader) ([]byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(br, hdr[:l.prefix]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated length prefix: %w", err)
+		}
+		return nil, err
+	}
+
+	var size uint64
+	switch l.prefix {
+	case 1:
+		size = uint64(hdr[0])
+	case 2:
+		size = uint64(l.order.Uint16(hdr[:2]))
+	case 4:
+		size = uint64(l.order.Uint32(hdr[:4]))
+	case 8:
+		size = l.order.Uint64(hdr[:8])
+	}
+
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrRecordTooLarge, size)
+	}
+
+	rec := make([]byte, size)
+	if _, err := io.ReadFull(br, rec); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return rec, nil
+}
+
+// tokenRdr emits each record followed by a newline. Newlines inside a
+// record are escaped so a record is always exactly one line.
+type tokenRdr struct {
+	br  *bufio.Reader
+	tok TokenizerI
+	buf []byte
+	err error
+}
+
+func (t *tokenRdr) Read(p []byte) (int, error) {
+	for len(t.buf) == 0 {
+		if t.err != nil {
+			return 0, t.err
+		}
+		rec, err := t.tok.Next(t.br)
+		switch {
+		case err == io.EOF:
+			// Not sticky, so a followed file can grow
+			return 0, err
+		case err != nil:
+			t.err = err
+			continue
+		}
+		t.buf = append(escapeNewlines(rec), '\n')
+	}
+
+	n := copy(p, t.buf)
+	t.buf = t.buf[n:]
+	return n, nil
+}
+
+func escapeNewlines(rec []byte) []byte {
+	if bytes.IndexAny(rec, "\r\n") < 0 {
+		return rec
+	}
+	rec = bytes.ReplaceAll(rec, []byte("\r"), []byte(`\r`))
+	return bytes.ReplaceAll(rec, []byte("\n"), []byte(`\n`))
+}
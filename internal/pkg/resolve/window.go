@@ -0,0 +1,37 @@
+package resolve
+
+import (
+	"strings"
+	"time"
+)
+
+// Built-in reorder windows keyed by a segment of the source type (e.g. the
+// "k8s" in "cre.prequel.k8s"). Sources with several writers interleaving
+// into one stream need a wider window than a single application log.
+var defaultWindows = map[string]time.Duration{
+	"k8s":        10 * time.Second,
+	"kubernetes": 10 * time.Second,
+	"kubelet":    10 * time.Second,
+	"containerd": 10 * time.Second,
+	"docker":     10 * time.Second,
+	"syslog":     2 * time.Second,
+	"journald":   2 * time.Second,
+}
+
+// DefaultWindow returns the reorder window for a source type. An exact match
+// in overrides wins, then the built-in defaults by type segment. Zero means
+// no reordering.
+func DefaultWindow(srcType string, overrides map[string]time.Duration) time.Duration {
+
+	if w, ok := overrides[srcType]; ok {
+		return w
+	}
+
+	for _, seg := range strings.Split(srcType, ".") {
+		if w, ok := defaultWindows[seg]; ok {
+			return w
+		}
+	}
+
+	return 0
+}
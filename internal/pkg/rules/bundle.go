@@ -0,0 +1,444 @@
+package rules
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/cosign"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/prequel-dev/preq/internal/pkg/verz"
+	"github.com/rs/zerolog/log"
+)
+
+// A bundle carries rules into a network without outbound access: the
+// community rules package and the copies of the remote registries, as
+// downloaded to the config directory, in a gzipped tar file:
+//
+//	manifest.json
+//	community/prequel-public-cre-rules.0.3.21.yaml.gz
+//	community/prequel-public-cre-rules.0.3.21.yaml.gz.sig
+//	registries/acme.yaml
+//	registries/acme.yaml.sig
+//
+// The manifest records the version of the community rules, the registry
+// and url of each file, and its size and SHA-256, which import checks
+// before installing anything. The signatures the files were verified with
+// when downloaded come with them, and are verified again on import, as on
+// download: community rules with the key built in, and the rules of a
+// registry with its publicKey, if it has one or verify.require is set.
+
+const (
+	BundleFormat = 1
+
+	bundleManifest  = "manifest.json"
+	bundleCommunity = "community"
+	maxManifestSize = 1 << 20
+)
+
+var (
+	ErrBundleEmpty    = errors.New("no downloaded rules to bundle")
+	ErrBundleFormat   = errors.New("unsupported bundle format")
+	ErrBundleFile     = errors.New("unexpected file in bundle")
+	ErrBundleMissing  = errors.New("file missing from bundle")
+	ErrBundleChecksum = errors.New("bundle file does not match its checksum")
+	ErrBundleUnsigned = errors.New("bundle file has no signature to verify")
+)
+
+// BundleT is the manifest of a bundle.
+type BundleT struct {
+	Format      int           `json:"format"`
+	Created     time.Time     `json:"created"`
+	PreqVersion string        `json:"preq_version"`
+	Files       []BundleFileT `json:"files"`
+}
+
+type BundleFileT struct {
+	Name     string `json:"name"` // path in the bundle
+	Registry string `json:"registry"`
+	Url      string `json:"url,omitempty"`
+	Version  string `json:"version,omitempty"` // of the community rules
+	Size     int64  `json:"size"`
+	Sha256   string `json:"sha256"`
+	Sig      string `json:"sig,omitempty"` // path of its signature in the bundle
+}
+
+// ExportBundle writes the downloaded rules of the config to w.
+func ExportBundle(w io.Writer, conf *config.Config, configDir string) (*BundleT, error) {
+
+	var (
+		b = &BundleT{
+			Format:      BundleFormat,
+			Created:     time.Now().UTC(),
+			PreqVersion: verz.Semver(),
+		}
+		files = make(map[string][]byte)
+	)
+
+	add := func(f BundleFileT, fn string, signed bool) error {
+		data, err := os.ReadFile(fn)
+		if err != nil {
+			return err
+		}
+		f.Size, f.Sha256 = int64(len(data)), utils.Sha256Sum(data)
+
+		switch sig, err := os.ReadFile(fn + prequelRulesSigSuffix); {
+		case err == nil:
+			f.Sig = f.Name + prequelRulesSigSuffix
+			files[f.Sig] = sig
+		case !errors.Is(err, os.ErrNotExist):
+			return err
+		case signed:
+			return fmt.Errorf("%w: %s; download it again to bundle it", ErrBundleUnsigned, fn)
+		}
+
+		b.Files = append(b.Files, f)
+		files[f.Name] = data
+		return nil
+	}
+
+	if !conf.Rules.Disabled {
+		switch ver, fn, err := GetCurrentRulesVersion(configDir); {
+		case errors.Is(err, ErrNoRulesRelease):
+		case err != nil:
+			return nil, err
+		default:
+			f := BundleFileT{
+				Name:     path.Join(bundleCommunity, filepath.Base(fn)),
+				Registry: CommunityRegistry,
+				Version:  ver.String(),
+			}
+			if err := add(f, fn, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, reg := range conf.Rules.Registries {
+		if reg.Url == "" {
+			continue
+		}
+		fn := registryFile(configDir, reg)
+		if _, err := os.Stat(fn); err != nil {
+			log.Warn().Str("registry", reg.Name).Msg("No rules fetched from registry yet; not bundled")
+			continue
+		}
+		f := BundleFileT{
+			Name:     path.Join(registriesDir, reg.Name+".yaml"),
+			Registry: reg.Name,
+			Url:      reg.Url,
+		}
+		if err := add(f, fn, reg.PublicKey != ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(b.Files) == 0 {
+		return nil, ErrBundleEmpty
+	}
+
+	manifest, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		gz = gzip.NewWriter(w)
+		tw = tar.NewWriter(gz)
+	)
+
+	write := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: b.Created,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	if err := write(bundleManifest, manifest); err != nil {
+		return nil, err
+	}
+	for _, f := range b.Files {
+		if err := write(f.Name, files[f.Name]); err != nil {
+			return nil, err
+		}
+		if f.Sig == "" {
+			continue
+		}
+		if err := write(f.Sig, files[f.Sig]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// ImportBundle installs the rules of a bundle read from r into configDir,
+// once every file matches its checksum and signature, and parses. Community rules older
+// than those installed are kept beside them, unused. An imported registry
+// counts as just updated, so it is not fetched before its update frequency
+// has passed.
+func ImportBundle(r io.Reader, conf *config.Config, configDir string) (*BundleT, error) {
+
+	var (
+		maxFile = maxSize(conf.Downloads.MaxRulesSize, DefaultMaxRulesSize)
+		files   = make(map[string][]byte)
+		b       BundleT
+	)
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("%w: %s", ErrBundleFile, hdr.Name)
+		}
+
+		limit := maxFile
+		switch {
+		case hdr.Name == bundleManifest:
+			limit = maxManifestSize
+		case strings.HasSuffix(hdr.Name, prequelRulesSigSuffix):
+			limit = maxSigSize
+		}
+		if limit > 0 && hdr.Size > limit {
+			return nil, fmt.Errorf("%s: %w", hdr.Name, tooLarge(hdr.Size, limit))
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name] = data
+	}
+
+	manifest, ok := files[bundleManifest]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBundleMissing, bundleManifest)
+	}
+	delete(files, bundleManifest)
+
+	if err := json.Unmarshal(manifest, &b); err != nil {
+		return nil, fmt.Errorf("%s: %w", bundleManifest, err)
+	}
+	if b.Format != BundleFormat {
+		return nil, fmt.Errorf("%w: %d", ErrBundleFormat, b.Format)
+	}
+
+	// Check everything before installing anything
+	var (
+		data = make([][]byte, len(b.Files))
+		sigs = make([][]byte, len(b.Files))
+		dsts = make([]string, len(b.Files))
+	)
+	for i, f := range b.Files {
+
+		if data[i], ok = files[f.Name]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrBundleMissing, f.Name)
+		}
+		delete(files, f.Name)
+
+		if int64(len(data[i])) != f.Size || utils.Sha256Sum(data[i]) != f.Sha256 {
+			return nil, fmt.Errorf("%w: %s", ErrBundleChecksum, f.Name)
+		}
+
+		if f.Sig != "" {
+			if f.Sig != f.Name+prequelRulesSigSuffix {
+				return nil, fmt.Errorf("%w: %s", ErrBundleFile, f.Sig)
+			}
+			if sigs[i], ok = files[f.Sig]; !ok {
+				return nil, fmt.Errorf("%w: %s", ErrBundleMissing, f.Sig)
+			}
+			delete(files, f.Sig)
+		}
+
+		if err := verifyBundleFile(f, data[i], sigs[i], conf); err != nil {
+			return nil, err
+		}
+
+		if dsts[i], err = bundleDest(f, configDir); err != nil {
+			return nil, err
+		}
+	}
+
+	for name := range files {
+		return nil, fmt.Errorf("%w: %s", ErrBundleFile, name)
+	}
+
+	for i, f := range b.Files {
+		if err := installBundleFile(f, data[i], sigs[i], dsts[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range b.Files {
+		if f.Registry == CommunityRegistry {
+			continue
+		}
+		configured := slices.ContainsFunc(conf.Rules.Registries, func(reg config.Registry) bool {
+			return reg.Name == f.Registry && reg.Url != ""
+		})
+		if !configured {
+			log.Warn().Str("registry", f.Registry).Msg("Imported rules of a registry missing from the config; add it to use them")
+		}
+	}
+
+	return &b, nil
+}
+
+// verifyBundleFile checks the signature of a file of a bundle as it was
+// checked when downloaded: community rules with the key built in, and the
+// rules of a registry with its public key, if it has one.
+func verifyBundleFile(f BundleFileT, data, sig []byte, conf *config.Config) error {
+
+	if f.Registry == CommunityRegistry {
+		if sig == nil {
+			return fmt.Errorf("%w: %s", ErrBundleUnsigned, f.Name)
+		}
+		if err := verifyRelease(data, sig); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		return nil
+	}
+
+	var (
+		key crypto.PublicKey
+		idx = slices.IndexFunc(conf.Rules.Registries, func(reg config.Registry) bool {
+			return reg.Name == f.Registry
+		})
+	)
+
+	switch {
+	case idx >= 0 && conf.Rules.Registries[idx].PublicKey != "":
+		var err error
+		if key, err = cosign.LoadKey(conf.Rules.Registries[idx].PublicKey); err != nil {
+			return err
+		}
+	case conf.Verify.Require:
+		return fmt.Errorf("%w: %s", ErrUnsigned, f.Registry)
+	default:
+		return nil
+	}
+
+	if sig == nil {
+		return fmt.Errorf("%w: %s", ErrBundleUnsigned, f.Name)
+	}
+
+	// Signed as fetched from the url of the bundle
+	if err := verifyRegistry(config.Registry{Name: f.Registry, Url: f.Url}, key, data, sig); err != nil {
+		return fmt.Errorf("%s: %w", f.Name, err)
+	}
+
+	return nil
+}
+
+// bundleDest returns where a file of a bundle is installed.
+func bundleDest(f BundleFileT, configDir string) (string, error) {
+
+	var (
+		dir, base = path.Split(f.Name)
+		dst       string
+	)
+
+	switch dir {
+	case bundleCommunity + "/":
+		if ok, _ := filepath.Match(fmt.Sprintf(rulesFilenameFmt, "*"), base); ok && f.Registry == CommunityRegistry {
+			dst = filepath.Join(configDir, base)
+		}
+	case registriesDir + "/":
+		if registryName.MatchString(f.Registry) && base == f.Registry+".yaml" {
+			dst = registryFile(configDir, config.Registry{Name: f.Registry})
+		}
+	}
+
+	if dst == "" {
+		return "", fmt.Errorf("%w: %s", ErrBundleFile, f.Name)
+	}
+
+	return dst, nil
+}
+
+// installBundleFile installs a checked file once its rules parse, with its
+// signature beside it.
+func installBundleFile(f BundleFileT, data, sig []byte, dst string) error {
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmp := dst + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	opt := utils.WithGenIds()
+	if f.Registry == CommunityRegistry {
+		opt = utils.WithMultiDoc()
+	}
+
+	_, err := utils.ParseRulesPath(tmp, opt)
+	if err == nil && f.Registry == CommunityRegistry {
+		var ver *semver.Version
+		if ver, err = getRulesVersion(tmp); err == nil && ver.String() != f.Version {
+			err = fmt.Errorf("%w: version %s, manifest says %s", ErrBundleChecksum, ver, f.Version)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("%s: %w", f.Name, err)
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+
+	if err := writeSig(dst, sig); err != nil {
+		return err
+	}
+
+	if f.Registry != CommunityRegistry {
+		if err := os.WriteFile(dst+checkedSuffix, nil, 0644); err != nil {
+			return err
+		}
+	}
+
+	log.Info().Str("registry", f.Registry).Str("path", dst).Msg("Imported rules")
+
+	return nil
+}
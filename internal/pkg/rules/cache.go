@@ -0,0 +1,267 @@
+package rules
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/rs/zerolog/log"
+)
+
+// The rules cache is what preq downloads to the config directory: the
+// community rules releases with their checksums, the copies of the remote
+// registries and of the rules passed by url, and what an interrupted update
+// or import leaves behind. The config file and the login are not part of
+// it.
+//
+// A release older than the newest kept ones, the copy of a registry no
+// longer in the config, and leftovers are stale; prune removes them. Clear
+// removes the whole cache, so the next run downloads the rules again.
+
+const (
+	CacheRelease  = "release"
+	CacheRegistry = "registry"
+	CacheUrl      = "url"
+	CacheTemp     = "temp"
+
+	tmpSuffix         = ".tmp"
+	updateTempPattern = "cre-rule-update*"
+)
+
+// CacheEntryT is a release, registry copy or leftover in the cache.
+type CacheEntryT struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"` // version of a release, name of a registry
+	Path  string `json:"path"`
+	Size  int64  `json:"size"` // with the checksums of a release
+	InUse bool   `json:"in_use"`
+	Stale bool   `json:"stale"`
+}
+
+// CacheT is the content of the rules cache.
+type CacheT struct {
+	Dir     string        `json:"dir"`
+	Entries []CacheEntryT `json:"entries"`
+	Size    int64         `json:"size"`
+}
+
+// CacheInfo lists the rules cache in configDir, the newest keep releases
+// not stale. Releases come newest first.
+func CacheInfo(conf *config.Config, configDir string, keep int) (*CacheT, error) {
+
+	c := &CacheT{Dir: configDir, Entries: []CacheEntryT{}}
+
+	releases, err := cachedReleases(configDir)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range releases {
+		e.InUse = i == 0 && !conf.Rules.Disabled
+		e.Stale = i >= max(keep, 1)
+		c.add(e)
+	}
+
+	regs, err := filepath.Glob(filepath.Join(configDir, registriesDir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range regs {
+		name := strings.TrimSuffix(filepath.Base(fn), ".yaml")
+		inUse := slices.ContainsFunc(conf.Rules.Registries, func(reg config.Registry) bool {
+			return reg.Name == name && reg.Url != ""
+		})
+		c.add(CacheEntryT{
+			Kind:  CacheRegistry,
+			Name:  name,
+			Path:  fn,
+			Size:  filesSize(fn, fn+checkedSuffix),
+			InUse: inUse,
+			Stale: !inUse,
+		})
+	}
+
+	urls, err := filepath.Glob(filepath.Join(configDir, urlsDir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range urls {
+		c.add(CacheEntryT{Kind: CacheUrl, Name: filepath.Base(fn), Path: fn, Size: filesSize(fn)})
+	}
+
+	var temps []string
+	for _, pattern := range []string{
+		filepath.Join(configDir, updateTempPattern),
+		filepath.Join(configDir, "*"+tmpSuffix),
+		filepath.Join(configDir, registriesDir, "*"+tmpSuffix),
+		filepath.Join(configDir, urlsDir, "*"+tmpSuffix),
+	} {
+		fns, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		temps = append(temps, fns...)
+	}
+	for _, fn := range temps {
+		c.add(CacheEntryT{Kind: CacheTemp, Name: filepath.Base(fn), Path: fn, Size: filesSize(fn), Stale: true})
+	}
+
+	return c, nil
+}
+
+// PruneCache removes the stale entries of the rules cache, keeping the
+// newest keep releases, and returns them.
+func PruneCache(conf *config.Config, configDir string, keep int) ([]CacheEntryT, error) {
+
+	c, err := CacheInfo(conf, configDir, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []CacheEntryT
+	for _, e := range c.Entries {
+		if !e.Stale {
+			continue
+		}
+		if err := removeEntry(e); err != nil {
+			return removed, err
+		}
+		removed = append(removed, e)
+	}
+
+	return removed, nil
+}
+
+// ClearCache removes the whole rules cache and returns what it removed.
+// The next run checks for updates at once.
+func ClearCache(conf *config.Config, configDir, updateFile string) ([]CacheEntryT, error) {
+
+	c, err := CacheInfo(conf, configDir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []CacheEntryT
+	for _, e := range c.Entries {
+		if err := removeEntry(e); err != nil {
+			return removed, err
+		}
+		removed = append(removed, e)
+	}
+
+	if err := os.Remove(updateFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return removed, err
+	}
+
+	return removed, nil
+}
+
+func (c *CacheT) add(e CacheEntryT) {
+	c.Entries = append(c.Entries, e)
+	c.Size += e.Size
+}
+
+// cachedReleases lists the community rules releases, newest first.
+func cachedReleases(configDir string) ([]CacheEntryT, error) {
+
+	packages, err := filepath.Glob(filepath.Join(configDir, fmt.Sprintf(rulesFilenameFmt, "*")))
+	if err != nil {
+		return nil, err
+	}
+
+	type releaseT struct {
+		ver   *semver.Version
+		entry CacheEntryT
+	}
+
+	var releases []releaseT
+	for _, p := range packages {
+		ver, err := getRulesVersion(p)
+		if err != nil {
+			log.Warn().Err(err).Str("path", p).Msg("Failed to get rules version; leaving it be")
+			continue
+		}
+		files, err := releaseFiles(p)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, releaseT{
+			ver:   ver,
+			entry: CacheEntryT{Kind: CacheRelease, Name: ver.String(), Path: p, Size: filesSize(files...)},
+		})
+	}
+
+	slices.SortFunc(releases, func(a, b releaseT) int {
+		return cmp.Or(b.ver.Compare(a.ver), strings.Compare(a.entry.Path, b.entry.Path))
+	})
+
+	out := make([]CacheEntryT, 0, len(releases))
+	for _, r := range releases {
+		out = append(out, r.entry)
+	}
+
+	return out, nil
+}
+
+// releaseFiles returns the package of a release and the checksums
+// downloaded with it, which share its name up to the suffix.
+func releaseFiles(pkg string) ([]string, error) {
+	files, err := filepath.Glob(strings.TrimSuffix(pkg, prequelRulesSuffix) + ".*")
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(files, func(fn string) bool {
+		return fn != pkg && strings.HasSuffix(fn, prequelRulesSuffix)
+	}), nil
+}
+
+func removeEntry(e CacheEntryT) error {
+
+	var files []string
+
+	switch e.Kind {
+	case CacheRelease:
+		var err error
+		if files, err = releaseFiles(e.Path); err != nil {
+			return err
+		}
+	case CacheRegistry:
+		files = []string{e.Path, e.Path + checkedSuffix, e.Path + prequelRulesSigSuffix}
+	default:
+		files = []string{e.Path}
+	}
+
+	for _, fn := range files {
+		if err := os.RemoveAll(fn); err != nil {
+			return err
+		}
+	}
+
+	log.Info().Str("kind", e.Kind).Str("name", e.Name).Str("path", e.Path).Msg("Removed from rules cache")
+
+	return nil
+}
+
+// filesSize returns the size of the files, and of those under them if
+// directories, that exist.
+func filesSize(fns ...string) int64 {
+	var size int64
+	for _, fn := range fns {
+		filepath.WalkDir(fn, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info, err := d.Info(); err == nil && !d.IsDir() {
+				size += info.Size()
+			}
+			return nil
+		})
+	}
+	return size
+}
@@ -3,6 +3,8 @@ package rules
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,6 +15,7 @@ import (
 
 	"github.com/avast/retry-go/v4"
 	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
 	"github.com/prequel-dev/preq/internal/pkg/ux"
 	"github.com/rs/zerolog/log"
 )
@@ -27,10 +30,37 @@ const (
 var (
 	ErrPrefixMatch         = errors.New("url does not match prefix")
 	ErrInvalidDownloadPath = errors.New("invalid download path")
+	ErrDownloadTooLarge    = errors.New("download exceeds size limit")
 )
 
 const numAttempts = 3
 
+const (
+	DefaultMaxRulesSize  = 64 << 20  // 64 MiB
+	DefaultMaxUpdateSize = 256 << 20 // 256 MiB
+	maxSigSize           = 64 << 10  // 64 KiB; hash and signature files
+	maxApiRespSize       = 1 << 20   // 1 MiB; JSON API responses
+)
+
+func tooLarge(size, maxSize int64) error {
+	return fmt.Errorf("%w: %d bytes > %d byte limit", ErrDownloadTooLarge, size, maxSize)
+}
+
+// readApiResp reads a JSON API response, failing without retry once it
+// passes maxApiRespSize rather than handing on a truncated document.
+func readApiResp(r io.Reader) ([]byte, error) {
+
+	body, err := io.ReadAll(io.LimitReader(r, maxApiRespSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxApiRespSize {
+		return nil, retry.Unrecoverable(tooLarge(int64(len(body)), maxApiRespSize))
+	}
+
+	return body, nil
+}
+
 type RulesDownload struct {
 	RulesPackage string `json:"rules_package"`
 }
@@ -75,9 +105,7 @@ func rulesDownloadAuthRequest(ctx context.Context, retries int, baseUrl, rulesDo
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
-	client := &http.Client{
-		Timeout: timeout,
-	}
+	client := httpz.New(httpz.WithName("rules"), httpz.WithTimeout(timeout))
 
 	return retry.DoWithData(
 		func() (*RulesDownloadAuth, error) {
@@ -88,7 +116,7 @@ func rulesDownloadAuthRequest(ctx context.Context, retries int, baseUrl, rulesDo
 			}
 			defer resp.Body.Close()
 
-			body, err := io.ReadAll(resp.Body)
+			body, err := readApiResp(resp.Body)
 			if err != nil {
 				log.Error().Err(err).Msg("Fail read body")
 				return nil, err
@@ -107,28 +135,35 @@ func rulesDownloadAuthRequest(ctx context.Context, retries int, baseUrl, rulesDo
 	)
 }
 
-func downloadPackage(ctx context.Context, apiUrl, packageUrl, token string, totalSize int64, pw progress.Writer, downloadTimeout time.Duration) ([]byte, error) {
+// downloadPackage fetches packageUrl, aborting once more than maxSize bytes
+// arrive. If expectedHash is set, the sha256 is computed while streaming and
+// a mismatch fails the download.
+func downloadPackage(ctx context.Context, apiUrl, packageUrl, token string, totalSize, maxSize int64, expectedHash string, pw progress.Writer, downloadTimeout time.Duration) ([]byte, error) {
 	var (
 		authHdrs *RulesDownloadAuth
 		err      error
 	)
 
+	// Don't bother asking for auth if the advertised size is already too big
+	if maxSize > 0 && totalSize > maxSize {
+		log.Error().Str("url", packageUrl).Int64("size", totalSize).Int64("max", maxSize).Msg("Download too large")
+		return nil, tooLarge(totalSize, maxSize)
+	}
+
 	if authHdrs, err = rulesDownloadAuthRequest(ctx, numAttempts, apiUrl, packageUrl, token, downloadTimeout); err != nil {
 		log.Error().Err(err).Msg("Fail RulesDownloadAuthRequest")
 		return nil, err
 	}
 
-	return _downloadPackage(ctx, packageUrl, totalSize, authHdrs, pw, downloadTimeout)
+	return _downloadPackage(ctx, packageUrl, totalSize, maxSize, expectedHash, authHdrs, pw, downloadTimeout)
 }
 
-func _downloadPackage(ctx context.Context, url string, totalSize int64, authHdrs *RulesDownloadAuth, pw progress.Writer, downloadTimeout time.Duration) ([]byte, error) {
+func _downloadPackage(ctx context.Context, url string, totalSize, maxSize int64, expectedHash string, authHdrs *RulesDownloadAuth, pw progress.Writer, downloadTimeout time.Duration) ([]byte, error) {
 
 	var (
 		httpRequest *http.Request
-		client      = &http.Client{
-			Timeout: downloadTimeout,
-		}
-		err error
+		client      = httpz.New(httpz.WithName("rules.download"), httpz.WithTimeout(downloadTimeout))
+		err         error
 	)
 
 	if httpRequest, err = http.NewRequest("GET", url, nil); err != nil {
@@ -150,21 +185,34 @@ func _downloadPackage(ctx context.Context, url string, totalSize int64, authHdrs
 			}
 			defer resp.Body.Close()
 
+			if maxSize > 0 && resp.ContentLength > maxSize {
+				pw.Stop()
+				return nil, retry.Unrecoverable(tooLarge(resp.ContentLength, maxSize))
+			}
+
 			tracker := ux.NewDownloadTracker(totalSize)
 
 			pw.AppendTracker(&tracker)
 
-			var buf bytes.Buffer
+			var (
+				buf  bytes.Buffer
+				hash = sha256.New()
+				body = io.TeeReader(resp.Body, hash)
+			)
 
 			chunkSize := 1 * 1024
 			tmp := make([]byte, chunkSize)
 
 			for {
-				n, readErr := resp.Body.Read(tmp)
+				n, readErr := body.Read(tmp)
 				if n > 0 {
 					buf.Write(tmp[:n])
 					tracker.Increment(int64(n))
 				}
+				if maxSize > 0 && int64(buf.Len()) > maxSize {
+					pw.Stop()
+					return nil, retry.Unrecoverable(tooLarge(int64(buf.Len()), maxSize))
+				}
 				if readErr == io.EOF {
 					break
 				}
@@ -178,6 +226,13 @@ func _downloadPackage(ctx context.Context, url string, totalSize int64, authHdrs
 			tracker.MarkAsDone()
 			pw.Stop()
 
+			if expectedHash != "" {
+				if sum := hex.EncodeToString(hash.Sum(nil)); sum != expectedHash {
+					log.Error().Str("expected", expectedHash).Str("actual", sum).Msg("Hash mismatch")
+					return nil, retry.Unrecoverable(ErrHashMismatch)
+				}
+			}
+
 			return buf.Bytes(), nil
 		},
 		retry.Attempts(retries),
@@ -1,6 +1,28 @@
 package rules
 
-import _ "embed"
+import (
+	_ "embed"
+	"fmt"
+
+	"github.com/prequel-dev/preq/internal/pkg/cosign"
+)
 
 //go:embed rules_ec_public.pem
 var publicRulesKeyPEM []byte
+
+// verifyRelease checks data released by Prequel, community rules or a preq
+// binary, against its cosign signature made with the key built in. Releases
+// are always checked, with or without verify.require.
+func verifyRelease(data, sig []byte) error {
+
+	key, err := cosign.ParseKey(publicRulesKeyPEM)
+	if err != nil {
+		return ErrInvalidKey
+	}
+
+	if err := cosign.VerifyBlob(key, data, sig); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,579 @@
+package rules
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/cosign"
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
+	"github.com/prequel-dev/preq/internal/pkg/oci"
+	"github.com/prequel-dev/preq/internal/pkg/secretz"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+/*
+rules:
+  registries:
+    - name: community             # the community rules package
+      priority: 0
+      updateFrequency: 24h
+    - name: acme
+      url: https://rules.acme.internal/preq/rules.yaml
+      priority: 10                # wins CRE id conflicts with the above
+      updateFrequency: 1h
+      auth:                       # any of the below the server wants
+        tokenEnv: ACME_RULES_TOKEN
+        tokenHeader: X-Api-Key    # sends the token as is; Authorization: Bearer if unset
+        username: preq            # basic auth
+        passwordEnv: ACME_RULES_PASSWORD
+        certFile: /etc/preq/acme.crt   # mTLS
+        keyFile: /etc/preq/acme.key
+        caFile: /etc/preq/acme-ca.crt  # a private CA of the server
+      publicKey: /etc/preq/acme-cosign.pub  # rules.yaml.sig must verify with it
+    - name: mirror
+      url: oci://registry.acme.internal/preq/cres:1.4.0  # an OCI artifact; docker login works
+      priority: 5
+      publicKey: /etc/preq/acme-cosign.pub  # cosign sign must have signed it
+    - name: team
+      paths:
+        - /etc/preq/team-rules.yaml
+      priority: 20
+verify:
+  require: true                   # every remote registry needs a publicKey
+*/
+
+// The signature a registry's rules were verified with is kept beside them,
+// with .sig appended, so that a bundle can carry it and be checked again
+// where it is imported. That of an OCI registry holds the manifest of the
+// artifact with the cosign signature of its digest.
+
+const (
+	CommunityRegistry = "community"
+
+	registriesDir   = "registries"
+	checkedSuffix   = ".checked"
+	registryTimeout = 30 * time.Second
+	maxRedirects    = 10
+)
+
+var (
+	ErrRegistryName   = errors.New("registry needs a name of letters, digits, '-' and '_'")
+	ErrRegistryDup    = errors.New("registry defined twice")
+	ErrRegistrySource = errors.New("registry needs one of url or paths")
+	ErrRegistryUrl    = errors.New("registry url must be http, https or oci")
+	ErrRegistryStatus = errors.New("registry responded with an error")
+	ErrRegistryAuth   = errors.New("invalid registry auth")
+	ErrRegistryCreds  = errors.New("registry credentials not set")
+	ErrRegistryKey    = errors.New("invalid registry public key")
+	ErrRegistrySig    = errors.New("registry rules are not signed with its public key")
+	ErrUnsigned       = errors.New("signature verification required, but registry has no public key")
+)
+
+var registryName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidateRegistries checks the registries of the config.
+func ValidateRegistries(conf *config.Config) error {
+
+	seen := make(map[string]struct{}, len(conf.Rules.Registries))
+
+	for _, reg := range conf.Rules.Registries {
+
+		if !registryName.MatchString(reg.Name) {
+			return fmt.Errorf("%w: %q", ErrRegistryName, reg.Name)
+		}
+		if _, ok := seen[reg.Name]; ok {
+			return fmt.Errorf("%w: %s", ErrRegistryDup, reg.Name)
+		}
+		seen[reg.Name] = struct{}{}
+
+		var (
+			hasUrl   = reg.Url != ""
+			hasPaths = len(reg.Paths) > 0
+		)
+
+		switch {
+		case reg.Name == CommunityRegistry && !hasUrl && !hasPaths:
+		case hasUrl == hasPaths:
+			return fmt.Errorf("%w: %s", ErrRegistrySource, reg.Name)
+		case hasUrl && oci.IsRef(reg.Url):
+			if _, err := oci.ParseRef(reg.Url); err != nil {
+				return fmt.Errorf("%w: %s: %w", ErrRegistryUrl, reg.Name, err)
+			}
+		case hasUrl:
+			u, err := url.Parse(reg.Url)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("%w: %s", ErrRegistryUrl, reg.Name)
+			}
+		}
+
+		if err := validateAuth(reg); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrRegistryAuth, reg.Name, err)
+		}
+
+		switch {
+		case reg.PublicKey != "" && reg.Url == "":
+			return fmt.Errorf("%w: %s: only remote registries are signed", ErrRegistryKey, reg.Name)
+		case reg.PublicKey != "":
+			if _, err := cosign.LoadKey(reg.PublicKey); err != nil {
+				return fmt.Errorf("%w: %s: %w", ErrRegistryKey, reg.Name, err)
+			}
+		case conf.Verify.Require && reg.Url != "":
+			return fmt.Errorf("%w: %s", ErrUnsigned, reg.Name)
+		}
+	}
+
+	return nil
+}
+
+func validateAuth(reg config.Registry) error {
+
+	var (
+		auth     = reg.Auth
+		token    = !auth.TokenSecret().IsZero()
+		password = !auth.PasswordSecret().IsZero()
+	)
+
+	switch {
+	case auth == config.RegistryAuth{}:
+		return nil
+	case reg.Url == "":
+		return errors.New("auth needs a url")
+	case !auth.Token.IsZero() && auth.TokenEnv != "":
+		return errors.New("token and tokenEnv both set the token")
+	case !auth.Password.IsZero() && auth.PasswordEnv != "":
+		return errors.New("password and passwordEnv both set the password")
+	case auth.TokenHeader != "" && !token:
+		return errors.New("tokenHeader needs a token or tokenEnv")
+	case auth.TokenHeader != "" && oci.IsRef(reg.Url):
+		return errors.New("OCI registries take the token as a bearer token")
+	case (auth.Username == "") != !password:
+		return errors.New("basic auth needs both username and password or passwordEnv")
+	case token && auth.TokenHeader == "" && auth.Username != "":
+		return errors.New("a bearer token and basic auth both set the Authorization header")
+	case (auth.CertFile == "") != (auth.KeyFile == ""):
+		return errors.New("a client certificate needs both certFile and keyFile")
+	}
+
+	return nil
+}
+
+// communityRegistry returns the settings of the community rules.
+func communityRegistry(conf *config.Config) config.Registry {
+	for _, reg := range conf.Rules.Registries {
+		if reg.Name == CommunityRegistry && reg.Url == "" && len(reg.Paths) == 0 {
+			if reg.UpdateFrequency == nil {
+				reg.UpdateFrequency = conf.UpdateFrequency
+			}
+			return reg
+		}
+	}
+	return config.Registry{Name: CommunityRegistry, UpdateFrequency: conf.UpdateFrequency}
+}
+
+// RegistryPaths returns the rule paths of the registries of the config
+// other than the community rules. Remote registries are fetched first if
+// their copy in configDir is older than their update frequency; a registry
+// that fails to update keeps its previous copy. With NoUpdateCheck set
+// only the copies are used.
+func RegistryPaths(ctx context.Context, conf *config.Config, configDir string) []utils.RulePathT {
+	return registryPaths(ctx, conf, configDir, !conf.NoUpdateCheck)
+}
+
+// CachedRegistryPaths is RegistryPaths without updates.
+func CachedRegistryPaths(conf *config.Config, configDir string) []utils.RulePathT {
+	return registryPaths(context.Background(), conf, configDir, false)
+}
+
+func registryPaths(ctx context.Context, conf *config.Config, configDir string, update bool) []utils.RulePathT {
+
+	var paths []utils.RulePathT
+
+	for _, reg := range conf.Rules.Registries {
+
+		for _, p := range reg.Paths {
+			paths = append(paths, utils.RulePathT{
+				Path:     p,
+				Type:     utils.RuleTypeUser,
+				Registry: reg.Name,
+				Priority: reg.Priority,
+			})
+		}
+
+		if reg.Url == "" {
+			continue
+		}
+
+		fn := registryFile(configDir, reg)
+
+		if update {
+			if err := syncRegistry(ctx, reg, fn, maxSize(conf.Downloads.MaxRulesSize, DefaultMaxRulesSize)); err != nil {
+				log.Error().Err(err).Str("registry", reg.Name).Msg("Failed to update registry; using previous rules")
+			}
+		}
+
+		if _, err := os.Stat(fn); err != nil {
+			log.Warn().Str("registry", reg.Name).Msg("No rules fetched from registry yet")
+			continue
+		}
+
+		paths = append(paths, utils.RulePathT{
+			Path:     fn,
+			Type:     utils.RuleTypeUser,
+			Registry: reg.Name,
+			Priority: reg.Priority,
+		})
+	}
+
+	return paths
+}
+
+// registryFile is where the rules of a remote registry are kept. They may
+// be compressed; rule files are read by their content.
+func registryFile(configDir string, reg config.Registry) string {
+	return filepath.Join(configDir, registriesDir, reg.Name+".yaml")
+}
+
+// syncRegistry fetches the rules of reg to fn once the update frequency of
+// the registry has passed since it was last tried.
+func syncRegistry(ctx context.Context, reg config.Registry, fn string, maxDownload int64) error {
+
+	dur := defaultLocalCheckDur
+	if reg.UpdateFrequency != nil {
+		dur = *reg.UpdateFrequency
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+
+	// A registry that is down is retried on its cadence, not on every run
+	if due, err := localStateShouldUpdate(fn+checkedSuffix, dur); err != nil || !due {
+		return err
+	}
+
+	return updateRegistry(ctx, reg, fn, maxDownload)
+}
+
+// updateRegistry fetches the rules of reg to fn.
+func updateRegistry(ctx context.Context, reg config.Registry, fn string, maxDownload int64) error {
+
+	log.Info().Str("registry", reg.Name).Str("url", reg.Url).Msg("Updating registry")
+
+	ctx, cancel := context.WithTimeout(ctx, registryTimeout)
+	defer cancel()
+
+	client, err := registryClient(reg.Auth)
+	if err != nil {
+		return err
+	}
+
+	var key crypto.PublicKey
+	if reg.PublicKey != "" {
+		if key, err = cosign.LoadKey(reg.PublicKey); err != nil {
+			return err
+		}
+	}
+
+	var data, sig []byte
+	if oci.IsRef(reg.Url) {
+		data, sig, err = pullRegistry(ctx, client, reg, key, maxDownload)
+	} else {
+		data, sig, err = fetchRegistry(ctx, client, reg, key, maxDownload)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Only rules that parse replace the previous copy
+	tmp := fn + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if _, err := utils.ParseRulesPath(tmp, utils.WithGenIds()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, fn); err != nil {
+		return err
+	}
+
+	return writeSig(fn, sig)
+}
+
+// writeSig keeps sig beside the rules file fn, or removes a stale one if
+// the rules are unsigned.
+func writeSig(fn string, sig []byte) error {
+	if sig == nil {
+		if err := os.Remove(fn + prequelRulesSigSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(fn+prequelRulesSigSuffix, sig, 0644)
+}
+
+// ociSigT is the signature kept beside the rules of an OCI registry.
+type ociSigT struct {
+	Manifest  []byte `json:"manifest"`
+	Payload   []byte `json:"payload"`
+	Signature []byte `json:"signature"` // base64 encoded
+}
+
+// verifyRegistry checks the rules of reg against a signature kept beside
+// them.
+func verifyRegistry(reg config.Registry, key crypto.PublicKey, data, sig []byte) error {
+
+	if !oci.IsRef(reg.Url) {
+		if err := cosign.VerifyBlob(key, data, sig); err != nil {
+			return fmt.Errorf("%w: %w", ErrRegistrySig, err)
+		}
+		return nil
+	}
+
+	var s ociSigT
+	if err := json.Unmarshal(sig, &s); err != nil {
+		return fmt.Errorf("%w: %w", ErrRegistrySig, err)
+	}
+	if err := oci.CheckRules(s.Manifest, data); err != nil {
+		return fmt.Errorf("%w: %w", ErrRegistrySig, err)
+	}
+	if err := cosign.VerifyPayload(key, s.Payload, s.Signature, oci.Digest(s.Manifest)); err != nil {
+		return fmt.Errorf("%w: %w", ErrRegistrySig, err)
+	}
+
+	return nil
+}
+
+// fetchRegistry downloads the rules file of an HTTP registry, and checks
+// its signature, at the url of the file with .sig appended, if the
+// registry has a key. It returns the rules and their signature.
+func fetchRegistry(ctx context.Context, client *http.Client, reg config.Registry, key crypto.PublicKey, maxDownload int64) ([]byte, []byte, error) {
+
+	data, err := getRegistry(ctx, client, reg, reg.Url, maxDownload)
+	if err != nil || key == nil {
+		return data, nil, err
+	}
+
+	u, err := url.Parse(reg.Url)
+	if err != nil {
+		return nil, nil, err
+	}
+	u.Path += prequelRulesSigSuffix
+
+	sig, err := getRegistry(ctx, client, reg, u.String(), maxSigSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrRegistrySig, err)
+	}
+	if err := verifyRegistry(reg, key, data, sig); err != nil {
+		return nil, nil, err
+	}
+
+	log.Info().Str("registry", reg.Name).Msg("Verified registry signature")
+
+	return data, sig, nil
+}
+
+// getRegistry reads at most maxDownload bytes from u, a file of reg.
+func getRegistry(ctx context.Context, client *http.Client, reg config.Registry, u string, maxDownload int64) ([]byte, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(req, reg.Auth); err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrRegistryStatus, resp.Status)
+	}
+
+	rdr := io.Reader(resp.Body)
+	if maxDownload > 0 {
+		if resp.ContentLength > maxDownload {
+			return nil, tooLarge(resp.ContentLength, maxDownload)
+		}
+		rdr = io.LimitReader(resp.Body, maxDownload+1)
+	}
+
+	data, err := io.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+	if maxDownload > 0 && int64(len(data)) > maxDownload {
+		return nil, tooLarge(int64(len(data)), maxDownload)
+	}
+
+	return data, nil
+}
+
+// pullRegistry pulls the rules artifact of an OCI registry, logging in with
+// the credentials of the registry or else those of the docker config, and
+// checks its cosign signatures if the registry has a key. It returns the
+// rules and the signature that verified them.
+func pullRegistry(ctx context.Context, client *http.Client, reg config.Registry, key crypto.PublicKey, maxDownload int64) ([]byte, []byte, error) {
+
+	ref, err := oci.ParseRef(reg.Url)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := []oci.OptT{oci.WithClient(client), oci.WithMaxSize(maxDownload)}
+
+	if secret := reg.Auth.TokenSecret(); !secret.IsZero() {
+		token, err := registrySecret(secret)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, oci.WithToken(token))
+	}
+
+	if reg.Auth.Username != "" {
+		password, err := registrySecret(reg.Auth.PasswordSecret())
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, oci.WithBasicAuth(reg.Auth.Username, password))
+	}
+
+	data, manifest, err := oci.Pull(ctx, ref, opts...)
+	if err != nil || key == nil {
+		return data, nil, err
+	}
+
+	digest := oci.Digest(manifest)
+
+	sigs, err := oci.Signatures(ctx, ref, digest, opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrRegistrySig, err)
+	}
+
+	// Any of the signatures of the artifact will do
+	for _, sig := range sigs {
+		if err = cosign.VerifyPayload(key, sig.Payload, sig.Signature, digest); err == nil {
+			log.Info().Str("registry", reg.Name).Str("digest", digest).Msg("Verified registry signature")
+			kept, err := json.Marshal(ociSigT{Manifest: manifest, Payload: sig.Payload, Signature: sig.Signature})
+			return data, kept, err
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%w: %w", ErrRegistrySig, err)
+}
+
+// authorize sets the credentials of a registry on req.
+func authorize(req *http.Request, auth config.RegistryAuth) error {
+
+	secret := auth.TokenSecret()
+
+	if !secret.IsZero() {
+		token, err := registrySecret(secret)
+		if err != nil {
+			return err
+		}
+		if auth.TokenHeader != "" {
+			req.Header.Set(auth.TokenHeader, token)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	if auth.Username != "" {
+		password, err := registrySecret(auth.PasswordSecret())
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(auth.Username, password)
+	}
+
+	if req.URL.Scheme != "https" && (!secret.IsZero() || auth.Username != "") {
+		log.Warn().Str("url", req.URL.Redacted()).Msg("Sending registry credentials without TLS")
+	}
+
+	return nil
+}
+
+// registrySecret resolves a credential of a registry.
+func registrySecret(secret secretz.SecretT) (string, error) {
+	value, err := secret.Get()
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrRegistryCreds, err)
+	}
+	return value, nil
+}
+
+// registryClient returns a client presenting the client certificate of a
+// registry and trusting its CA, if any.
+func registryClient(auth config.RegistryAuth) (*http.Client, error) {
+
+	opts := []httpz.OptT{httpz.WithName("registry")}
+
+	if auth.CertFile == "" && auth.CaFile == "" {
+		return withRedirects(httpz.New(opts...), auth), nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if auth.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(auth.CertFile, auth.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if auth.CaFile != "" {
+		pem, err := os.ReadFile(auth.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := httpz.RootCAs()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: no certificates in %s", ErrRegistryAuth, auth.CaFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	return withRedirects(httpz.New(append(opts, httpz.WithTLS(cfg))...), auth), nil
+}
+
+// withRedirects keeps the token header of auth from redirects to another
+// host. Authorization, if the token is sent in it, is already dropped by
+// the client, but a custom header would be forwarded as is.
+func withRedirects(client *http.Client, auth config.RegistryAuth) *http.Client {
+
+	if auth.TokenHeader == "" {
+		return client
+	}
+
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		if req.URL.Host != via[0].URL.Host {
+			req.Header.Del(auth.TokenHeader)
+		}
+		return nil
+	}
+
+	return client
+}
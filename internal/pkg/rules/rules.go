@@ -4,11 +4,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"crypto/ecdsa"
-	"crypto/sha256"
-	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
@@ -25,6 +21,7 @@ import (
 	"github.com/cqroot/prompt"
 	"github.com/cqroot/prompt/choose"
 	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
 	"github.com/prequel-dev/preq/internal/pkg/utils"
 	"github.com/prequel-dev/preq/internal/pkg/ux"
 	"github.com/prequel-dev/preq/internal/pkg/verz"
@@ -103,18 +100,31 @@ func GetRules(ctx context.Context, conf *config.Config, configDir, cmdLineRules,
 	)
 
 	// Sync rules
-	if syncRulesPath, err = syncUpdates(ctx, conf, configDir, token, ruleUpdateFile, baseAddr, tlsPort, udpPort); err != nil {
+	if conf.NoUpdateCheck {
+		if _, syncRulesPath, err = GetCurrentRulesVersion(configDir); err != nil {
+			log.Warn().Err(err).Msg("No community rules installed and update checks are off")
+		}
+	} else if syncRulesPath, err = syncUpdates(ctx, conf, configDir, token, ruleUpdateFile, baseAddr, tlsPort, udpPort); err != nil {
 		// Continue on error. If we cannot download any rules at all on first run, a user will have to provide them on the command line or config
 		log.Error().Err(err).Msg("Failed to sync updates. Continue...")
 	}
 
 	if syncRulesPath != "" && !conf.Rules.Disabled {
 		rulePaths = append(rulePaths, utils.RulePathT{
-			Path: syncRulesPath,
-			Type: utils.RuleTypeCre,
+			Path:     syncRulesPath,
+			Type:     utils.RuleTypeCre,
+			Registry: CommunityRegistry,
+			Priority: communityRegistry(conf).Priority,
 		})
 	}
 
+	if IsRulesUrl(cmdLineRules) {
+		if cmdLineRules, err = FetchRulesUrl(ctx, conf, configDir, cmdLineRules); err != nil {
+			log.Error().Err(err).Msg("Failed to download rules")
+			return nil, err
+		}
+	}
+
 	if cmdLineRules != "" {
 		rulePaths = append(rulePaths, utils.RulePathT{
 			Path: cmdLineRules,
@@ -129,6 +139,8 @@ func GetRules(ctx context.Context, conf *config.Config, configDir, cmdLineRules,
 		})
 	}
 
+	rulePaths = append(rulePaths, RegistryPaths(ctx, conf, configDir)...)
+
 	if len(rulePaths) == 0 {
 		return nil, ErrNoRules
 	}
@@ -157,8 +169,8 @@ func syncUpdates(ctx context.Context, conf *config.Config, configDir, token, upd
 
 	log.Info().Str("path", currRulesPath).Str("version", currRulesVer.String()).Msg("Current rules version")
 
-	if conf.UpdateFrequency != nil {
-		dur = *conf.UpdateFrequency
+	if freq := communityRegistry(conf).UpdateFrequency; freq != nil {
+		dur = *freq
 	}
 
 	// Always check local state first in case time is up and we should just do a full check in
@@ -204,13 +216,13 @@ func syncUpdates(ctx context.Context, conf *config.Config, configDir, token, upd
 
 	// If we had a tiny response earlier, we have a full one now. If we had a full one earlier, we still have it.
 	if shouldUpdateExe(fullResp) && !isKrewPluginEnabled() {
-		if err = requestExeUpdate(ctx, fullResp, apiUrl, token, slowCheckTimeout, downloadTimeout, conf.AcceptUpdates); err != nil {
+		if err = requestExeUpdate(ctx, fullResp, apiUrl, token, slowCheckTimeout, downloadTimeout, maxSize(conf.Downloads.MaxUpdateSize, DefaultMaxUpdateSize), conf.AcceptUpdates); err != nil {
 			return "", ErrUpdateExeFailed
 		}
 	}
 
 	if shouldUpdateRules(currRulesVer, fullResp) {
-		if currRulesPath, err = requestRuleUpdate(ctx, fullResp, apiUrl, token, configDir, slowCheckTimeout, downloadTimeout, conf.AcceptUpdates); err != nil {
+		if currRulesPath, err = requestRuleUpdate(ctx, fullResp, apiUrl, token, configDir, slowCheckTimeout, downloadTimeout, maxSize(conf.Downloads.MaxRulesSize, DefaultMaxRulesSize), conf.AcceptUpdates); err != nil {
 			return "", err
 		}
 	}
@@ -218,6 +230,18 @@ func syncUpdates(ctx context.Context, conf *config.Config, configDir, token, upd
 	return currRulesPath, nil
 }
 
+// maxSize returns the configured download cap, or def if unset. A negative
+// configured value disables the cap.
+func maxSize(configured, def int64) int64 {
+	switch {
+	case configured < 0:
+		return 0
+	case configured == 0:
+		return def
+	}
+	return configured
+}
+
 func isKrewPluginEnabled() bool {
 	log.Debug().Bool("enabled", len(krewPluginEnabled) > 0).Msg("Krew plugin")
 	return len(krewPluginEnabled) > 0
@@ -285,7 +309,7 @@ func shouldUpdateExe(r *RuleUpdateResponse) bool {
 	return newVer.GreaterThan(currVer)
 }
 
-func requestExeUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl, token string, slowCheckTimeout, downloadTimeout time.Duration, acceptUpdates bool) error {
+func requestExeUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl, token string, slowCheckTimeout, downloadTimeout time.Duration, maxDownload int64, acceptUpdates bool) error {
 
 	var (
 		downloadLink = fmt.Sprintf(ux.DownloadPreqLinkFmt, fullResp.LatestExeVersion)
@@ -380,7 +404,7 @@ func requestExeUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl,
 		}
 	}
 
-	eb, err = downloadPackage(ctx, apiUrl, dataUrl, token, dataSize, pw, downloadTimeout)
+	eb, err = downloadPackage(ctx, apiUrl, dataUrl, token, dataSize, maxDownload, exeHash, pw, downloadTimeout)
 	if err != nil {
 		return err
 	}
@@ -394,7 +418,7 @@ func requestExeUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl,
 		Str("path", newExePath).
 		Msg("Temp updated exe path")
 
-	hb, err = downloadPackage(ctx, apiUrl, hashUrl, token, hashSize, pw, downloadTimeout)
+	hb, err = downloadPackage(ctx, apiUrl, hashUrl, token, hashSize, maxSigSize, "", pw, downloadTimeout)
 	if err != nil {
 		return err
 	}
@@ -408,7 +432,7 @@ func requestExeUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl,
 		Str("path", newExeHashPath).
 		Msg("Temp updated exe hash path")
 
-	sb, err = downloadPackage(ctx, apiUrl, sigUrl, token, sigSize, pw, downloadTimeout)
+	sb, err = downloadPackage(ctx, apiUrl, sigUrl, token, sigSize, maxSigSize, "", pw, downloadTimeout)
 	if err != nil {
 		return err
 	}
@@ -422,36 +446,16 @@ func requestExeUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl,
 		Str("path", newExeSigPath).
 		Msg("Temp updated exe sig path")
 
-	block, _ := pem.Decode(publicRulesKeyPEM)
-	if block == nil {
-		return ErrInvalidKey
-	}
-
-	pubKeyInterface, err := x509.ParsePKIXPublicKey(block.Bytes)
-	if err != nil {
+	if err := verifyRelease(eb, sb); err != nil {
 		return err
 	}
 
-	pubKey, ok := pubKeyInterface.(*ecdsa.PublicKey)
-	if !ok {
-		return ErrInvalidKey
-	}
-
-	hash := sha256.New()
-	hash.Write(eb)
-	hashed := hash.Sum(nil)
-
-	valid := ecdsa.VerifyASN1(pubKey, hashed, sb)
-	if !valid {
-		return ErrInvalidSignature
-	}
-
 	ebHash := utils.Sha256Sum(eb)
 	if ebHash != exeHash {
 		return ErrHashMismatch
 	}
 
-	fmt.Println("ECDSA signature and sha256 hash verified")
+	fmt.Println("Signature and sha256 hash verified")
 
 	if err = utils.CopyFile(newExePath, currPath); err != nil {
 		return err
@@ -465,7 +469,7 @@ func requestExeUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl,
 	return nil
 }
 
-func requestRuleUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl, token string, configDir string, slowCheckTimeout, downloadTimeout time.Duration, acceptUpdates bool) (string, error) {
+func requestRuleUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl, token string, configDir string, slowCheckTimeout, downloadTimeout time.Duration, maxDownload int64, acceptUpdates bool) (string, error) {
 
 	var (
 		downloadLink = fmt.Sprintf(ux.DownloadCreLinkFmt, fullResp.LatestRuleVersion)
@@ -511,7 +515,7 @@ func requestRuleUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl
 		rb, hb, sb      []byte
 	)
 
-	rb, err = downloadPackage(ctx, apiUrl, fullResp.RuleUrls.DataUrl, token, fullResp.RuleUrls.DataSize, pw, downloadTimeout)
+	rb, err = downloadPackage(ctx, apiUrl, fullResp.RuleUrls.DataUrl, token, fullResp.RuleUrls.DataSize, maxDownload, fullResp.LatestRuleHash, pw, downloadTimeout)
 	if err != nil {
 		return "", err
 	}
@@ -525,7 +529,7 @@ func requestRuleUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl
 		Str("path", newRulePath).
 		Msg("Temp updated rule path")
 
-	hb, err = downloadPackage(ctx, apiUrl, fullResp.RuleUrls.HashUrl, token, fullResp.RuleUrls.HashSize, pw, downloadTimeout)
+	hb, err = downloadPackage(ctx, apiUrl, fullResp.RuleUrls.HashUrl, token, fullResp.RuleUrls.HashSize, maxSigSize, "", pw, downloadTimeout)
 	if err != nil {
 		return "", err
 	}
@@ -539,7 +543,7 @@ func requestRuleUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl
 		Str("path", newRuleHashPath).
 		Msg("Temp updated rule hash path")
 
-	sb, err = downloadPackage(ctx, apiUrl, fullResp.RuleUrls.SigUrl, token, fullResp.RuleUrls.SigSize, pw, downloadTimeout)
+	sb, err = downloadPackage(ctx, apiUrl, fullResp.RuleUrls.SigUrl, token, fullResp.RuleUrls.SigSize, maxSigSize, "", pw, downloadTimeout)
 	if err != nil {
 		return "", err
 	}
@@ -553,37 +557,17 @@ func requestRuleUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl
 		Str("path", newRuleSigPath).
 		Msg("Temp updated rule sig path")
 
-	block, _ := pem.Decode(publicRulesKeyPEM)
-	if block == nil {
-		return "", ErrInvalidKey
-	}
-
-	pubKeyInterface, err := x509.ParsePKIXPublicKey(block.Bytes)
-	if err != nil {
+	if err := verifyRelease(rb, sb); err != nil {
 		return "", err
 	}
 
-	pubKey, ok := pubKeyInterface.(*ecdsa.PublicKey)
-	if !ok {
-		return "", ErrInvalidKey
-	}
-
-	hash := sha256.New()
-	hash.Write(rb)
-	hashed := hash.Sum(nil)
-
-	valid := ecdsa.VerifyASN1(pubKey, hashed, sb)
-	if !valid {
-		return "", ErrInvalidSignature
-	}
-
 	ebHash := utils.Sha256Sum(rb)
 	if ebHash != fullResp.LatestRuleHash {
 		log.Error().Str("expected", fullResp.LatestRuleHash).Str("actual", ebHash).Msg("Hash mismatch")
 		return "", ErrHashMismatch
 	}
 
-	fmt.Println("ECDSA signature and sha256 hash verified")
+	fmt.Println("Signature and sha256 hash verified")
 
 	baseRulesName, err := utils.UrlBase(fullResp.RuleUrls.DataUrl)
 	if err != nil {
@@ -600,6 +584,11 @@ func requestRuleUpdate(ctx context.Context, fullResp *RuleUpdateResponse, apiUrl
 		Str("dst_path", updatedRulesPath).
 		Msg("Updated rule path")
 
+	// Kept for bundles to carry
+	if err = writeSig(updatedRulesPath, sb); err != nil {
+		return "", err
+	}
+
 	baseHashName, err := utils.UrlBase(fullResp.RuleUrls.HashUrl)
 	if err != nil {
 		return "", err
@@ -650,14 +639,41 @@ func GetCurrentRulesVersion(configDir string) (*semver.Version, string, error) {
 	return currVer, currPath, nil
 }
 
+// ReleasePath returns the community rules package of version in configDir.
+// Updates leave the packages they replace there, and imports may add older
+// ones.
+func ReleasePath(configDir, version string) (string, error) {
+
+	want, err := semver.NewVersion(version)
+	if err != nil {
+		return "", err
+	}
+
+	packages, err := filepath.Glob(filepath.Join(configDir, fmt.Sprintf(rulesFilenameFmt, "*")))
+	if err != nil {
+		return "", err
+	}
+
+	for _, p := range packages {
+		ver, err := getRulesVersion(p)
+		if err != nil {
+			log.Warn().Err(err).Str("path", p).Msg("Failed to get rules version")
+			continue
+		}
+		if ver.Equal(want) {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrNoRulesRelease, want)
+}
+
 func postUrl(ctx context.Context, url string, token string, body []byte, timeout time.Duration) ([]byte, error) {
 
 	var (
 		httpRequest *http.Request
-		client      = &http.Client{
-			Timeout: timeout,
-		}
-		err error
+		client      = httpz.New(httpz.WithName("rules"), httpz.WithTimeout(timeout))
+		err         error
 	)
 
 	if httpRequest, err = http.NewRequest("POST", url, bytes.NewBuffer(body)); err != nil {
@@ -678,7 +694,7 @@ func postUrl(ctx context.Context, url string, token string, body []byte, timeout
 			}
 			defer resp.Body.Close()
 
-			rb, err := io.ReadAll(resp.Body)
+			rb, err := readApiResp(resp.Body)
 			if err != nil {
 				log.Error().Err(err).Msg("Fail read body")
 				return nil, err
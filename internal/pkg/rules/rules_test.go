@@ -1,15 +1,42 @@
 package rules
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Masterminds/semver"
+	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/cosign"
+	"github.com/prequel-dev/preq/internal/pkg/oci"
+	"github.com/prequel-dev/preq/internal/pkg/secretz"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
 	"github.com/prequel-dev/preq/internal/pkg/verz"
 )
 
@@ -168,3 +195,976 @@ func TestPostUrl(t *testing.T) {
 		t.Errorf("Response body does not match expected. Got %v", actualResponse)
 	}
 }
+
+func TestPostUrlTooLarge(t *testing.T) {
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes.Repeat([]byte(" "), maxApiRespSize+1))
+	}))
+	t.Cleanup(mockServer.Close)
+
+	if _, err := postUrl(context.Background(), mockServer.URL, "token", nil, 5*time.Second); !errors.Is(err, ErrDownloadTooLarge) {
+		t.Errorf("Expected %v, got %v", ErrDownloadTooLarge, err)
+	}
+}
+
+func TestDownloadPackageLimits(t *testing.T) {
+	payload := []byte("rules payload")
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	t.Cleanup(mockServer.Close)
+
+	tests := map[string]struct {
+		totalSize    int64
+		maxSize      int64
+		expectedHash string
+		err          error
+	}{
+		"within limit":     {maxSize: 1024, expectedHash: utils.Sha256Sum(payload)},
+		"no limit":         {maxSize: 0},
+		"body too large":   {maxSize: 4, err: ErrDownloadTooLarge},
+		"advertised large": {totalSize: 2048, maxSize: 1024, err: ErrDownloadTooLarge},
+		"hash mismatch":    {maxSize: 1024, expectedHash: "deadbeef", err: ErrHashMismatch},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			pw := ux.NewProgressWriter(1)
+
+			var (
+				data []byte
+				err  error
+			)
+
+			if tc.totalSize > tc.maxSize {
+				data, err = downloadPackage(context.Background(), mockServer.URL, mockServer.URL, "", tc.totalSize, tc.maxSize, tc.expectedHash, pw, time.Second)
+			} else {
+				data, err = _downloadPackage(context.Background(), mockServer.URL, tc.totalSize, tc.maxSize, tc.expectedHash, &RulesDownloadAuth{}, pw, time.Second)
+			}
+
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("Expected error %v, got %v", tc.err, err)
+			}
+			if tc.err == nil && string(data) != string(payload) {
+				t.Errorf("Expected %q, got %q", payload, data)
+			}
+		})
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	if maxSize(0, DefaultMaxRulesSize) != DefaultMaxRulesSize {
+		t.Errorf("Expected default limit")
+	}
+	if maxSize(-1, DefaultMaxRulesSize) != 0 {
+		t.Errorf("Expected negative limit to disable cap")
+	}
+	if maxSize(10, DefaultMaxRulesSize) != 10 {
+		t.Errorf("Expected configured limit")
+	}
+}
+
+func TestVerifyRelease(t *testing.T) {
+
+	data := []byte("rules:\n")
+
+	// Only a signature of the key built in, which tests cannot make, passes
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sum := sha256.Sum256(data)
+	forged, _ := ecdsa.SignASN1(rand.Reader, priv, sum[:])
+
+	for name, sig := range map[string][]byte{"none": nil, "garbage": []byte("not a signature"), "other key": forged} {
+		if err := verifyRelease(data, sig); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected %v, got %v", name, ErrInvalidSignature, err)
+		}
+	}
+}
+
+func TestValidateRegistries(t *testing.T) {
+
+	testCases := []struct {
+		name string
+		regs []config.Registry
+		want error
+	}{
+		{"none", nil, nil},
+		{"community settings", []config.Registry{{Name: CommunityRegistry, Priority: 5}}, nil},
+		{"url and paths", []config.Registry{{Name: "acme", Url: "https://rules.example.com/r.yaml"}, {Name: "team", Paths: []string{"r.yaml"}}}, nil},
+		{"no name", []config.Registry{{Url: "https://rules.example.com/r.yaml"}}, ErrRegistryName},
+		{"bad name", []config.Registry{{Name: "../acme", Url: "https://rules.example.com/r.yaml"}}, ErrRegistryName},
+		{"twice", []config.Registry{{Name: "acme", Paths: []string{"a.yaml"}}, {Name: "acme", Paths: []string{"b.yaml"}}}, ErrRegistryDup},
+		{"no source", []config.Registry{{Name: "acme"}}, ErrRegistrySource},
+		{"both sources", []config.Registry{{Name: "acme", Url: "https://rules.example.com/r.yaml", Paths: []string{"a.yaml"}}}, ErrRegistrySource},
+		{"bad url", []config.Registry{{Name: "acme", Url: "file:///etc/r.yaml"}}, ErrRegistryUrl},
+		{"oci", []config.Registry{{Name: "acme", Url: "oci://ghcr.io/acme/cres:1.0"}}, nil},
+		{"bad oci", []config.Registry{{Name: "acme", Url: "oci://ghcr.io/Acme/cres"}}, ErrRegistryUrl},
+		{"oci token header", []config.Registry{{Name: "acme", Url: "oci://ghcr.io/acme/cres", Auth: config.RegistryAuth{TokenEnv: "T", TokenHeader: "X-Api-Key"}}}, ErrRegistryAuth},
+		{"auth", []config.Registry{{Name: "acme", Url: "https://rules.example.com/r.yaml", Auth: config.RegistryAuth{TokenEnv: "T", TokenHeader: "X-Api-Key", Username: "u", PasswordEnv: "P", CertFile: "c", KeyFile: "k"}}}, nil},
+		{"auth without url", []config.Registry{{Name: "acme", Paths: []string{"a.yaml"}, Auth: config.RegistryAuth{TokenEnv: "T"}}}, ErrRegistryAuth},
+		{"header without token", []config.Registry{{Name: "acme", Url: "https://rules.example.com/r.yaml", Auth: config.RegistryAuth{TokenHeader: "X-Api-Key"}}}, ErrRegistryAuth},
+		{"no password", []config.Registry{{Name: "acme", Url: "https://rules.example.com/r.yaml", Auth: config.RegistryAuth{Username: "u"}}}, ErrRegistryAuth},
+		{"bearer and basic", []config.Registry{{Name: "acme", Url: "https://rules.example.com/r.yaml", Auth: config.RegistryAuth{TokenEnv: "T", Username: "u", PasswordEnv: "P"}}}, ErrRegistryAuth},
+		{"token secret", []config.Registry{{Name: "acme", Url: "https://rules.example.com/r.yaml", Auth: config.RegistryAuth{Token: secretz.Ref("keychain:acme"), TokenHeader: "X-Api-Key"}}}, nil},
+		{"token twice", []config.Registry{{Name: "acme", Url: "https://rules.example.com/r.yaml", Auth: config.RegistryAuth{Token: secretz.Ref("T"), TokenEnv: "T"}}}, ErrRegistryAuth},
+		{"no key", []config.Registry{{Name: "acme", Url: "https://rules.example.com/r.yaml", Auth: config.RegistryAuth{CertFile: "c"}}}, ErrRegistryAuth},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := &config.Config{}
+			conf.Rules.Registries = tc.regs
+			if err := ValidateRegistries(conf); !errors.Is(err, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+
+	// Public keys
+	_, pub := writeKey(t)
+
+	conf := &config.Config{}
+	conf.Rules.Registries = []config.Registry{{Name: "acme", Url: "https://rules.example.com/r.yaml", PublicKey: pub}, {Name: "team", Paths: []string{"r.yaml"}}}
+	conf.Verify.Require = true
+	if err := ValidateRegistries(conf); err != nil {
+		t.Errorf("Expected signed registries to validate, got %v", err)
+	}
+
+	conf.Rules.Registries[0].PublicKey = ""
+	if err := ValidateRegistries(conf); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Expected %v, got %v", ErrUnsigned, err)
+	}
+
+	conf.Rules.Registries[0].PublicKey = filepath.Join(t.TempDir(), "nope.pub")
+	if err := ValidateRegistries(conf); !errors.Is(err, ErrRegistryKey) {
+		t.Errorf("Expected %v, got %v", ErrRegistryKey, err)
+	}
+
+	conf.Rules.Registries = []config.Registry{{Name: "team", Paths: []string{"r.yaml"}, PublicKey: pub}}
+	if err := ValidateRegistries(conf); !errors.Is(err, ErrRegistryKey) {
+		t.Errorf("Expected %v, got %v", ErrRegistryKey, err)
+	}
+}
+
+func TestRegistryPaths(t *testing.T) {
+
+	const rules = "rules:\n  - cre:\n      id: acme-example\n    rule:\n      set:\n        event:\n          source: cre.log.kafka\n        match:\n          - value: x\n"
+
+	var (
+		body  = rules
+		calls int
+		auth  string
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		auth = r.Header.Get("Authorization")
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	t.Setenv("ACME_TOKEN", "secret")
+
+	var (
+		dir  = t.TempDir()
+		freq = time.Hour
+		conf = &config.Config{}
+	)
+
+	conf.Rules.Registries = []config.Registry{
+		{Name: "acme", Url: srv.URL + "/rules.yaml", Priority: 10, UpdateFrequency: &freq, Auth: config.RegistryAuth{TokenEnv: "ACME_TOKEN"}},
+		{Name: "team", Paths: []string{"team.yaml"}, Priority: 20},
+	}
+
+	if paths := CachedRegistryPaths(conf, dir); len(paths) != 1 || paths[0].Path != "team.yaml" {
+		t.Fatalf("Expected only local paths before a fetch, got %v", paths)
+	}
+
+	paths := RegistryPaths(context.Background(), conf, dir)
+	want := []utils.RulePathT{
+		{Path: filepath.Join(dir, registriesDir, "acme.yaml"), Type: utils.RuleTypeUser, Registry: "acme", Priority: 10},
+		{Path: "team.yaml", Type: utils.RuleTypeUser, Registry: "team", Priority: 20},
+	}
+	if !slices.Equal(paths, want) {
+		t.Fatalf("Expected %v, got %v", want, paths)
+	}
+	if calls != 1 || auth != "Bearer secret" {
+		t.Errorf("Expected one authorized fetch, got %d with %q", calls, auth)
+	}
+
+	// Not fetched again before the update frequency has passed
+	RegistryPaths(context.Background(), conf, dir)
+	if calls != 1 {
+		t.Errorf("Expected no fetch within the update frequency, got %d", calls)
+	}
+
+	// Rules that fail to parse keep the previous copy
+	freq = 0
+	body = "rules: [\n"
+	RegistryPaths(context.Background(), conf, dir)
+	if calls != 2 {
+		t.Errorf("Expected a fetch once due, got %d", calls)
+	}
+	data, err := os.ReadFile(want[0].Path)
+	if err != nil || string(data) != rules {
+		t.Errorf("Expected the previous rules kept, got %q (%v)", data, err)
+	}
+}
+
+func TestRegistryAuth(t *testing.T) {
+
+	const rules = "rules:\n  - cre:\n      id: acme-example\n    rule:\n      set:\n        event:\n          source: cre.log.kafka\n        match:\n          - value: x\n"
+
+	var (
+		dir      = t.TempDir()
+		certFile = filepath.Join(dir, "client.crt")
+		keyFile  = filepath.Join(dir, "client.key")
+		caFile   = filepath.Join(dir, "ca.crt")
+		got      *http.Request
+	)
+
+	clientCert := writeCert(t, certFile, keyFile)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		fmt.Fprint(w, rules)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: x509.NewCertPool()}
+	srv.TLS.ClientCAs.AddCert(clientCert)
+	srv.StartTLS()
+	defer srv.Close()
+
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("ACME_TOKEN", "secret")
+	t.Setenv("ACME_PASSWORD", "hunter2")
+
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		auth    config.RegistryAuth
+		check   func(*http.Request) bool
+		wantErr error
+	}{
+		{
+			name: "token header",
+			auth: config.RegistryAuth{TokenEnv: "ACME_TOKEN", TokenHeader: "X-Api-Key", CaFile: caFile},
+			check: func(r *http.Request) bool {
+				return r.Header.Get("X-Api-Key") == "secret" && r.Header.Get("Authorization") == ""
+			},
+		},
+		{
+			name: "basic",
+			auth: config.RegistryAuth{Username: "preq", PasswordEnv: "ACME_PASSWORD", CaFile: caFile},
+			check: func(r *http.Request) bool {
+				user, password, ok := r.BasicAuth()
+				return ok && user == "preq" && password == "hunter2"
+			},
+		},
+		{
+			name: "password secret",
+			auth: config.RegistryAuth{Username: "preq", Password: secretz.Ref("file:" + passwordFile), CaFile: caFile},
+			check: func(r *http.Request) bool {
+				user, password, ok := r.BasicAuth()
+				return ok && user == "preq" && password == "from-file"
+			},
+		},
+		{
+			name:  "client certificate",
+			auth:  config.RegistryAuth{CertFile: certFile, KeyFile: keyFile, CaFile: caFile},
+			check: func(r *http.Request) bool { return len(r.TLS.PeerCertificates) == 1 },
+		},
+		{
+			name:    "unset password",
+			auth:    config.RegistryAuth{Username: "preq", PasswordEnv: "ACME_NOPE", CaFile: caFile},
+			wantErr: ErrRegistryCreds,
+		},
+		{
+			name:    "unset token secret",
+			auth:    config.RegistryAuth{Token: secretz.Ref("env:ACME_NOPE"), CaFile: caFile},
+			wantErr: ErrRegistryCreds,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			got = nil
+
+			var (
+				reg = config.Registry{Name: "acme", Url: srv.URL + "/rules.yaml", Auth: tc.auth}
+				fn  = filepath.Join(t.TempDir(), "acme.yaml")
+				err = syncRegistry(context.Background(), reg, fn, 0)
+			)
+
+			switch {
+			case tc.wantErr != nil:
+				if !errors.Is(err, tc.wantErr) || got != nil {
+					t.Errorf("Expected %v before any request, got %v", tc.wantErr, err)
+				}
+			case err != nil:
+				t.Fatalf("Expected a fetch, got %v", err)
+			case !tc.check(got):
+				t.Errorf("Unexpected request %v", got.Header)
+			}
+		})
+	}
+
+	// The server is only trusted through caFile
+	reg := config.Registry{Name: "acme", Url: srv.URL + "/rules.yaml", Auth: config.RegistryAuth{CertFile: certFile, KeyFile: keyFile}}
+	var verr *tls.CertificateVerificationError
+	if err := syncRegistry(context.Background(), reg, filepath.Join(t.TempDir(), "acme.yaml"), 0); !errors.As(err, &verr) {
+		t.Errorf("Expected a certificate error, got %v", err)
+	}
+}
+
+func TestRegistryRedirect(t *testing.T) {
+
+	const rules = "rules:\n  - cre:\n      id: acme-example\n    rule:\n      set:\n        event:\n          source: cre.log.kafka\n        match:\n          - value: x\n"
+
+	var leaked, kept string
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("X-Api-Key")
+		fmt.Fprint(w, rules)
+	}))
+	defer mirror.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rules.yaml":
+			http.Redirect(w, r, "/moved.yaml", http.StatusFound)
+		case "/moved.yaml":
+			kept = r.Header.Get("X-Api-Key")
+			http.Redirect(w, r, mirror.URL+"/rules.yaml", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("ACME_TOKEN", "secret")
+
+	reg := config.Registry{Name: "acme", Url: srv.URL + "/rules.yaml", Auth: config.RegistryAuth{TokenEnv: "ACME_TOKEN", TokenHeader: "X-Api-Key"}}
+	if err := syncRegistry(context.Background(), reg, filepath.Join(t.TempDir(), "acme.yaml"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if kept != "secret" || leaked != "" {
+		t.Errorf("Expected the token header kept on the host only, got %q and %q", kept, leaked)
+	}
+}
+
+func TestRegistrySignature(t *testing.T) {
+
+	const rules = "rules:\n  - cre:\n      id: acme-example\n    rule:\n      set:\n        event:\n          source: cre.log.kafka\n        match:\n          - value: x\n"
+
+	key, pub := writeKey(t)
+
+	sig := signBlob(t, key, []byte(rules))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rules.yaml":
+			fmt.Fprint(w, rules)
+		case "/rules.yaml.sig":
+			if sig == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprint(w, sig)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	testCases := []struct {
+		name string
+		url  string
+		sig  string
+		want error
+	}{
+		{"signed", srv.URL + "/rules.yaml?v=1", sig, nil},
+		{"tampered", srv.URL + "/rules.yaml", signBlob(t, key, []byte("rules: []\n")), cosign.ErrSignature},
+		{"unsigned", srv.URL + "/rules.yaml", "", ErrRegistryStatus},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			sig = tc.sig
+
+			var (
+				reg = config.Registry{Name: "acme", Url: tc.url, PublicKey: pub}
+				fn  = filepath.Join(t.TempDir(), "acme.yaml")
+				err = syncRegistry(context.Background(), reg, fn, DefaultMaxRulesSize)
+			)
+
+			if !errors.Is(err, tc.want) || (tc.want != nil && !errors.Is(err, ErrRegistrySig)) {
+				t.Fatalf("Expected %v, got %v", tc.want, err)
+			}
+			if _, err := os.Stat(fn); (err == nil) != (tc.want == nil) {
+				t.Errorf("Expected only verified rules kept, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRegistryOci(t *testing.T) {
+
+	const rules = "rules:\n  - cre:\n      id: acme-example\n    rule:\n      set:\n        event:\n          source: cre.log.kafka\n        match:\n          - value: x\n"
+
+	key, pub := writeKey(t)
+
+	var (
+		digest   = sha256Digest([]byte(rules))
+		manifest = fmt.Sprintf(`{"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[{"mediaType":%q,"digest":%q,"size":%d}]}`, oci.RulesMediaType, digest, len(rules))
+		payload  = fmt.Sprintf(`{"critical":{"image":{"docker-manifest-digest":%q}}}`, sha256Digest([]byte(manifest)))
+		sigTag   = strings.Replace(sha256Digest([]byte(manifest)), ":", "-", 1) + ".sig"
+		sig      = fmt.Sprintf(`{"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[{"mediaType":"application/vnd.dev.cosign.simplesigning.v1+json","digest":%q,"size":%d,"annotations":{"dev.cosignproject.cosign/signature":%q}}]}`, sha256Digest([]byte(payload)), len(payload), signBlob(t, key, []byte(payload)))
+		auth     string
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/v2/acme/cres/manifests/1.0":
+			fmt.Fprint(w, manifest)
+		case "/v2/acme/cres/manifests/" + sigTag:
+			fmt.Fprint(w, sig)
+		case "/v2/acme/cres/blobs/" + digest:
+			fmt.Fprint(w, rules)
+		case "/v2/acme/cres/blobs/" + sha256Digest([]byte(payload)):
+			fmt.Fprint(w, payload)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("ACME_TOKEN", "secret")
+
+	var (
+		reg = config.Registry{
+			Name:      "acme",
+			Url:       "oci://" + strings.TrimPrefix(srv.URL, "http://") + "/acme/cres:1.0",
+			Auth:      config.RegistryAuth{TokenEnv: "ACME_TOKEN"},
+			PublicKey: pub,
+		}
+		fn = filepath.Join(t.TempDir(), "acme.yaml")
+	)
+
+	if err := syncRegistry(context.Background(), reg, fn, DefaultMaxRulesSize); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(fn); err != nil || string(data) != rules {
+		t.Errorf("Expected the rules pulled, got %q (%v)", data, err)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Expected the token sent, got %q", auth)
+	}
+
+	// The signature kept beside the rules verifies them without the registry
+	pubKey, err := cosign.LoadKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := os.ReadFile(fn + prequelRulesSigSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyRegistry(reg, pubKey, []byte(rules), kept); err != nil {
+		t.Errorf("Expected the kept signature to verify, got %v", err)
+	}
+	if err := verifyRegistry(reg, pubKey, []byte("rules: []\n"), kept); !errors.Is(err, ErrRegistrySig) {
+		t.Errorf("Expected %v, got %v", ErrRegistrySig, err)
+	}
+
+	// Signed by another key
+	_, other := writeKey(t)
+	reg.PublicKey = other
+	fn = filepath.Join(t.TempDir(), "acme.yaml")
+	if err := syncRegistry(context.Background(), reg, fn, DefaultMaxRulesSize); !errors.Is(err, ErrRegistrySig) {
+		t.Errorf("Expected %v, got %v", ErrRegistrySig, err)
+	}
+}
+
+func TestBundle(t *testing.T) {
+
+	const (
+		community = "section: version\ncontent:\n  - version: 0.3.21\n---\nsection: rules\nrules:\n  - cre:\n      id: CRE-2025-0001\n    metadata:\n      id: m1\n      hash: h1\n    rule:\n      set:\n        event:\n          source: cre.log.kafka\n        match:\n          - value: x\n"
+		acme      = "rules:\n  - cre:\n      id: acme-example\n    rule:\n      set:\n        event:\n          source: cre.log.kafka\n        match:\n          - value: x\n"
+	)
+
+	var (
+		src  = t.TempDir()
+		dst  = t.TempDir()
+		conf = &config.Config{}
+		gz   bytes.Buffer
+	)
+
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(community))
+	zw.Close()
+
+	// Community rules signed with a key standing in for the one built in
+	key, pub := writeKey(t)
+	pem, err := os.ReadFile(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builtin := publicRulesKeyPEM
+	publicRulesKeyPEM = pem
+	t.Cleanup(func() { publicRulesKeyPEM = builtin })
+
+	conf.Rules.Registries = []config.Registry{
+		{Name: "acme", Url: "https://rules.acme.internal/rules.yaml", PublicKey: pub},
+		{Name: "later", Url: "https://rules.acme.internal/later.yaml"},
+		{Name: "team", Paths: []string{"team.yaml"}},
+	}
+
+	if _, err := ExportBundle(io.Discard, conf, src); !errors.Is(err, ErrBundleEmpty) {
+		t.Errorf("Expected %v, got %v", ErrBundleEmpty, err)
+	}
+
+	communityFn := filepath.Join(src, fmt.Sprintf(rulesFilenameFmt, ".0.3.21.yaml"))
+	if err := os.WriteFile(communityFn, gz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	acmeFn := registryFile(src, conf.Rules.Registries[0])
+	os.MkdirAll(filepath.Join(src, registriesDir), 0755)
+	if err := os.WriteFile(acmeFn, []byte(acme), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signatures as left by the downloads
+	if _, err := ExportBundle(io.Discard, conf, src); !errors.Is(err, ErrBundleUnsigned) {
+		t.Errorf("Expected %v, got %v", ErrBundleUnsigned, err)
+	}
+	writeSig(communityFn, []byte(signBlob(t, key, gz.Bytes())))
+	writeSig(acmeFn, []byte(signBlob(t, key, []byte(acme))))
+
+	var bundle bytes.Buffer
+	b, err := ExportBundle(&bundle, conf, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Files) != 2 || b.Files[0].Version != "0.3.21" || b.Files[1].Registry != "acme" || b.Files[1].Sha256 != utils.Sha256Sum([]byte(acme)) || b.Files[1].Sig != "registries/acme.yaml.sig" {
+		t.Fatalf("Unexpected manifest %+v", b.Files)
+	}
+
+	// Not imported where the registry has another key
+	_, other := writeKey(t)
+	otherConf := &config.Config{}
+	otherConf.Rules.Registries = []config.Registry{{Name: "acme", Url: "https://rules.acme.internal/rules.yaml", PublicKey: other}}
+	if _, err := ImportBundle(bytes.NewReader(bundle.Bytes()), otherConf, t.TempDir()); !errors.Is(err, ErrRegistrySig) {
+		t.Errorf("Expected %v, got %v", ErrRegistrySig, err)
+	}
+
+	// Nor community rules not signed with the key built in
+	publicRulesKeyPEM = builtin
+	if _, err := ImportBundle(bytes.NewReader(bundle.Bytes()), conf, t.TempDir()); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected %v, got %v", ErrInvalidSignature, err)
+	}
+	publicRulesKeyPEM = pem
+
+	imported, err := ImportBundle(bytes.NewReader(bundle.Bytes()), conf, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Files) != 2 || !imported.Created.Equal(b.Created) {
+		t.Errorf("Expected the manifest exported, got %+v", imported)
+	}
+
+	if ver, fn, err := GetCurrentRulesVersion(dst); err != nil || ver.String() != "0.3.21" || filepath.Base(fn) != filepath.Base(communityFn) {
+		t.Errorf("Expected community rules 0.3.21 installed, got %v %s (%v)", ver, fn, err)
+	}
+	if fn, err := ReleasePath(dst, "v0.3.21"); err != nil || filepath.Base(fn) != filepath.Base(communityFn) {
+		t.Errorf("Expected the 0.3.21 package, got %s (%v)", fn, err)
+	}
+	if _, err := ReleasePath(dst, "0.3.20"); !errors.Is(err, ErrNoRulesRelease) {
+		t.Errorf("Expected %v, got %v", ErrNoRulesRelease, err)
+	}
+	if paths := CachedRegistryPaths(conf, dst); len(paths) != 2 || paths[0].Registry != "acme" {
+		t.Errorf("Expected the acme rules installed, got %v", paths)
+	}
+
+	// Not fetched before its update frequency has passed
+	if due, err := localStateShouldUpdate(registryFile(dst, conf.Rules.Registries[0])+checkedSuffix, time.Hour); err != nil || due {
+		t.Errorf("Expected an imported registry not due, got %v (%v)", due, err)
+	}
+
+	// Installed with its signature, so it can be bundled again
+	if _, err := os.Stat(registryFile(dst, conf.Rules.Registries[0]) + prequelRulesSigSuffix); err != nil {
+		t.Errorf("Expected the signature installed, got %v", err)
+	}
+}
+
+func TestImportBundleChecks(t *testing.T) {
+
+	const acme = "rules:\n  - cre:\n      id: acme-example\n    rule:\n      set:\n        event:\n          source: cre.log.kafka\n        match:\n          - value: x\n"
+
+	bundle := func(files map[string]string, manifest BundleT) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(zw)
+		m, _ := json.Marshal(manifest)
+		files[bundleManifest] = string(m)
+		for name, data := range files {
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))})
+			tw.Write([]byte(data))
+		}
+		tw.Close()
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	file := BundleFileT{Name: "registries/acme.yaml", Registry: "acme", Size: int64(len(acme)), Sha256: utils.Sha256Sum([]byte(acme))}
+
+	testCases := []struct {
+		name  string
+		files map[string]string
+		file  BundleFileT
+		want  error
+	}{
+		{"ok", map[string]string{file.Name: acme}, file, nil},
+		{"tampered", map[string]string{file.Name: strings.Replace(acme, "x", "y", 1)}, file, ErrBundleChecksum},
+		{"missing", map[string]string{}, file, ErrBundleMissing},
+		{"extra", map[string]string{file.Name: acme, "evil.yaml": acme}, file, ErrBundleFile},
+		{"escape", map[string]string{"registries/../../acme.yaml": acme}, BundleFileT{Name: "registries/../../acme.yaml", Registry: "acme", Size: file.Size, Sha256: file.Sha256}, ErrBundleFile},
+		{"bad registry", map[string]string{"registries/x.yaml": acme}, BundleFileT{Name: "registries/x.yaml", Registry: "acme", Size: file.Size, Sha256: file.Sha256}, ErrBundleFile},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			data := bundle(tc.files, BundleT{Format: BundleFormat, Files: []BundleFileT{tc.file}})
+			if _, err := ImportBundle(bytes.NewReader(data), &config.Config{}, dir); !errors.Is(err, tc.want) {
+				t.Fatalf("Expected %v, got %v", tc.want, err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, registriesDir, "acme.yaml")); (err == nil) != (tc.want == nil) {
+				t.Errorf("Expected rules installed only if the bundle checks, got %v", err)
+			}
+		})
+	}
+
+	data := bundle(map[string]string{}, BundleT{Format: 2})
+	if _, err := ImportBundle(bytes.NewReader(data), &config.Config{}, t.TempDir()); !errors.Is(err, ErrBundleFormat) {
+		t.Errorf("Expected %v, got %v", ErrBundleFormat, err)
+	}
+}
+
+func TestGetRulesNoUpdateCheck(t *testing.T) {
+
+	var (
+		dir        = t.TempDir()
+		updateFile = filepath.Join(dir, ".ruleupdate")
+		conf       = &config.Config{NoUpdateCheck: true}
+		reg        = config.Registry{Name: "acme", Url: "http://127.0.0.1:1/rules.yaml"}
+	)
+
+	conf.Rules.Registries = []config.Registry{reg}
+
+	if _, err := GetRules(context.Background(), conf, dir, "", "", updateFile, "127.0.0.1", 1, 1); !errors.Is(err, ErrNoRules) {
+		t.Errorf("Expected %v with nothing installed, got %v", ErrNoRules, err)
+	}
+
+	os.MkdirAll(filepath.Join(dir, registriesDir), 0755)
+	if err := os.WriteFile(registryFile(dir, reg), []byte("rules: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := GetRules(context.Background(), conf, dir, "", "", updateFile, "127.0.0.1", 1, 1)
+	if err != nil || len(paths) != 1 || paths[0].Registry != "acme" {
+		t.Fatalf("Expected the cached acme rules, got %v (%v)", paths, err)
+	}
+
+	// Neither the community rules nor the registry were checked
+	for _, fn := range []string{updateFile, registryFile(dir, reg) + checkedSuffix} {
+		if _, err := os.Stat(fn); !os.IsNotExist(err) {
+			t.Errorf("Expected no update check stamp %s, got %v", fn, err)
+		}
+	}
+}
+
+func TestRegistryChecks(t *testing.T) {
+
+	var (
+		dir    = t.TempDir()
+		hourly = time.Hour
+		conf   = &config.Config{}
+	)
+
+	conf.Rules.Registries = []config.Registry{
+		{Name: "acme", Url: "https://rules.acme.internal/rules.yaml", UpdateFrequency: &hourly},
+		{Name: "stale", Url: "https://rules.acme.internal/stale.yaml", UpdateFrequency: &hourly},
+		{Name: "never", Url: "oci://registry.acme.internal/preq/cres:1.4.0"},
+		{Name: "team", Paths: []string{"team.yaml"}},
+	}
+
+	os.MkdirAll(filepath.Join(dir, registriesDir), 0755)
+	for _, reg := range conf.Rules.Registries[:2] {
+		if err := os.WriteFile(registryFile(dir, reg)+checkedSuffix, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(registryFile(dir, conf.Rules.Registries[1])+checkedSuffix, old, old)
+
+	checks := registryChecks(conf, dir)
+	if len(checks) != 3 {
+		t.Fatalf("Expected the 3 remote registries, got %+v", checks)
+	}
+
+	for i, want := range []struct {
+		checked bool
+		due     bool
+	}{{true, false}, {true, true}, {false, true}} {
+		if got := checks[i]; (got.Checked != nil) != want.checked || got.Due != want.due {
+			t.Errorf("%s: expected checked %v due %v, got %+v", got.Name, want.checked, want.due, got)
+		}
+	}
+}
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// writeKey writes the public key of a new cosign key pair.
+func writeKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := os.WriteFile(fn, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return key, fn
+}
+
+// signBlob signs data as cosign sign-blob does.
+func signBlob(t *testing.T, key *ecdsa.PrivateKey, data []byte) string {
+	sum := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// writeCert writes a self-signed client certificate and its key.
+func writeCert(t *testing.T, certFile, keyFile string) *x509.Certificate {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "preq"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestCache(t *testing.T) {
+
+	var (
+		dir  = t.TempDir()
+		conf = &config.Config{}
+	)
+
+	write := func(name string, data []byte) {
+		fn := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(fn), 0755)
+		if err := os.WriteFile(fn, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	release := func(ver string) {
+		var gz bytes.Buffer
+		zw := gzip.NewWriter(&gz)
+		zw.Write([]byte("section: version\ncontent:\n  - version: " + ver + "\n"))
+		zw.Close()
+		name := fmt.Sprintf(rulesFilenameFmt, "."+ver+".yaml")
+		write(name, gz.Bytes())
+		write(name+".sha2", []byte("sum"))
+	}
+
+	release("0.3.9")
+	release("0.3.21")
+	release("0.3.20")
+	write(filepath.Join(registriesDir, "acme.yaml"), []byte("rules: []\n"))
+	write(filepath.Join(registriesDir, "acme.yaml"+checkedSuffix), nil)
+	write(filepath.Join(registriesDir, "gone.yaml"), []byte("rules: []\n"))
+	write(filepath.Join(registriesDir, "acme.yaml"+tmpSuffix), []byte("partial"))
+	write("config.yaml", []byte("rules: {}\n"))
+
+	conf.Rules.Registries = []config.Registry{{Name: "acme", Url: "https://rules.acme.internal/rules.yaml"}}
+
+	c, err := CacheInfo(conf, dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, e := range c.Entries {
+		got = append(got, fmt.Sprintf("%s %s %v %v", e.Kind, e.Name, e.InUse, e.Stale))
+	}
+	want := []string{
+		"release 0.3.21 true false",
+		"release 0.3.20 false false",
+		"release 0.3.9 false true",
+		"registry acme true false",
+		"registry gone false true",
+		"temp acme.yaml.tmp false true",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	removed, err := PruneCache(conf, dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 4 {
+		t.Errorf("Expected 4 entries pruned, got %v", removed)
+	}
+	if ver, _, err := GetCurrentRulesVersion(dir); err != nil || ver.String() != "0.3.21" {
+		t.Errorf("Expected 0.3.21 kept, got %v (%v)", ver, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf(rulesFilenameFmt, ".0.3.20.yaml")+".sha2")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the checksum of 0.3.20 pruned, got %v", err)
+	}
+
+	updateFile := filepath.Join(dir, ".ruleupdate")
+	write(".ruleupdate", nil)
+
+	if removed, err = ClearCache(conf, dir, updateFile); err != nil || len(removed) != 2 {
+		t.Errorf("Expected the release and registry cleared, got %v (%v)", removed, err)
+	}
+	if _, _, err := GetCurrentRulesVersion(dir); !errors.Is(err, ErrNoRulesRelease) {
+		t.Errorf("Expected %v, got %v", ErrNoRulesRelease, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "config.yaml")); err != nil {
+		t.Errorf("Expected the config kept, got %v", err)
+	}
+	if _, err := os.Stat(updateFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the update stamp removed, got %v", err)
+	}
+}
+
+func TestFetchRulesUrl(t *testing.T) {
+
+	const rules = "rules:\n  - cre:\n      id: url-example\n    rule:\n      set:\n        event:\n          source: cre.log.kafka\n        match:\n          - value: x\n"
+
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte(rules))
+	}))
+	defer srv.Close()
+
+	var (
+		dir  = t.TempDir()
+		conf = &config.Config{}
+		sum  = utils.Sha256Sum([]byte(rules))
+		ctx  = context.Background()
+	)
+
+	fn, err := FetchRulesUrl(ctx, conf, dir, srv.URL+"/rules.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(fn); err != nil || string(data) != rules {
+		t.Errorf("Expected the rules downloaded, got %q (%v)", data, err)
+	}
+
+	if _, err := FetchRulesUrl(ctx, conf, dir, srv.URL+"/rules.yaml#sha256="+strings.Repeat("0", 64)); !errors.Is(err, ErrRulesPin) {
+		t.Errorf("Expected %v, got %v", ErrRulesPin, err)
+	}
+
+	// A pinned copy is reused
+	pinned := srv.URL + "/rules.yaml#sha256=" + strings.ToUpper(sum)
+	if _, err := FetchRulesUrl(ctx, conf, dir, pinned); err != nil {
+		t.Fatal(err)
+	}
+	hits = 0
+	if got, err := FetchRulesUrl(ctx, conf, dir, pinned); err != nil || got != fn || hits != 0 {
+		t.Errorf("Expected the pinned copy reused, got %s after %d downloads (%v)", got, hits, err)
+	}
+
+	for _, u := range []string{"ftp://rules.acme.internal/rules.yaml", srv.URL + "/rules.yaml#md5=abc", srv.URL + "/rules.yaml#sha256=abc"} {
+		if _, err := FetchRulesUrl(ctx, conf, dir, u); !errors.Is(err, ErrRulesUrl) {
+			t.Errorf("%s: expected %v, got %v", u, ErrRulesUrl, err)
+		}
+	}
+
+	// With update checks off, only the copy kept is used
+	conf.NoUpdateCheck = true
+	hits = 0
+	if got, err := FetchRulesUrl(ctx, conf, dir, srv.URL+"/rules.yaml"); err != nil || got != fn || hits != 0 {
+		t.Errorf("Expected the kept copy used, got %s after %d downloads (%v)", got, hits, err)
+	}
+	if _, err := FetchRulesUrl(ctx, conf, dir, srv.URL+"/rules.yaml#sha256="+strings.Repeat("0", 64)); !errors.Is(err, ErrRulesPin) {
+		t.Errorf("Expected %v, got %v", ErrRulesPin, err)
+	}
+	if _, err := FetchRulesUrl(ctx, conf, dir, srv.URL+"/other.yaml"); !errors.Is(err, ErrRulesStale) || hits != 0 {
+		t.Errorf("Expected %v without a download, got %v after %d downloads", ErrRulesStale, err, hits)
+	}
+	conf.NoUpdateCheck = false
+
+	conf.Verify.Require = true
+	if _, err := FetchRulesUrl(ctx, conf, dir, srv.URL+"/rules.yaml"); !errors.Is(err, ErrRulesUnpinned) {
+		t.Errorf("Expected %v, got %v", ErrRulesUnpinned, err)
+	}
+	if _, err := FetchRulesUrl(ctx, conf, dir, pinned); err != nil {
+		t.Errorf("Expected a pinned url accepted, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/verz"
+	"github.com/rs/zerolog/log"
+)
+
+// Updates are checked for and installed apart from a scan by preq rules
+// update. A check never prompts or installs anything; its JSON is:
+//
+//	{
+//	  "preq":  {"current": "0.1.30", "latest": "0.1.31", "update": true},
+//	  "rules": {"current": "0.3.21", "latest": "0.3.21", "update": false},
+//	  "registries": [
+//	    {"name": "acme", "url": "https://rules.acme.internal/preq/rules.yaml", "checked": "2026-10-14T09:12:03Z", "due": false}
+//	  ]
+//	}
+//
+// Whether a remote registry has newer rules is only known by fetching
+// them, so a check reports when each was last tried and whether its
+// update frequency has passed since.
+
+const updateCheckTimeout = 5 * time.Second
+
+var (
+	ErrUpdateCheck = errors.New("update check failed")
+)
+
+type UpdateCheckT struct {
+	Preq       VersionCheckT    `json:"preq"`
+	Rules      VersionCheckT    `json:"rules"`
+	Registries []RegistryCheckT `json:"registries"`
+}
+
+type VersionCheckT struct {
+	Current string `json:"current"`
+	Latest  string `json:"latest"`
+	Update  bool   `json:"update"`
+}
+
+type RegistryCheckT struct {
+	Name    string     `json:"name"`
+	Url     string     `json:"url"`
+	Checked *time.Time `json:"checked,omitempty"`
+	Due     bool       `json:"due"`
+}
+
+// CheckUpdates reports whether a newer preq or community rules release
+// exists, and the state of the remote registries. It ignores the update
+// frequency and changes nothing.
+func CheckUpdates(ctx context.Context, conf *config.Config, configDir, token, baseAddr string, tlsPort int) (*UpdateCheckT, error) {
+	check, _, err := checkUpdates(ctx, conf, configDir, token, baseAddr, tlsPort)
+	return check, err
+}
+
+// Update installs the updates CheckUpdates reports, prompting for each
+// unless conf.AcceptUpdates is set, and fetches every remote registry.
+// Update frequencies restart from now. A registry that fails to update
+// keeps its previous copy.
+func Update(ctx context.Context, conf *config.Config, configDir, token, updateFile, baseAddr string, tlsPort int) (*UpdateCheckT, error) {
+
+	var (
+		apiUrl      = fmt.Sprintf("https://%s:%d", baseAddr, tlsPort)
+		maxDownload = maxSize(conf.Downloads.MaxRulesSize, DefaultMaxRulesSize)
+		errs        []error
+	)
+
+	check, resp, err := checkUpdates(ctx, conf, configDir, token, baseAddr, tlsPort)
+	if err != nil {
+		return nil, err
+	}
+
+	if check.Preq.Update && !isKrewPluginEnabled() {
+		if err := requestExeUpdate(ctx, resp, apiUrl, token, updateCheckTimeout, downloadTimeout, maxSize(conf.Downloads.MaxUpdateSize, DefaultMaxUpdateSize), conf.AcceptUpdates); err != nil {
+			return nil, ErrUpdateExeFailed
+		}
+	}
+
+	if check.Rules.Update && !conf.Rules.Disabled {
+		if _, err := requestRuleUpdate(ctx, resp, apiUrl, token, configDir, updateCheckTimeout, downloadTimeout, maxDownload, conf.AcceptUpdates); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := localStateShouldUpdate(updateFile, 0); err != nil {
+		log.Warn().Err(err).Str("path", updateFile).Msg("Failed to restart update frequency")
+	}
+
+	for _, reg := range conf.Rules.Registries {
+		if reg.Url == "" {
+			continue
+		}
+
+		fn := registryFile(configDir, reg)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			return nil, err
+		}
+		if _, err := localStateShouldUpdate(fn+checkedSuffix, 0); err != nil {
+			return nil, err
+		}
+
+		if err := updateRegistry(ctx, reg, fn, maxDownload); err != nil {
+			log.Error().Err(err).Str("registry", reg.Name).Msg("Failed to update registry; using previous rules")
+			errs = append(errs, fmt.Errorf("%s: %w", reg.Name, err))
+		}
+	}
+
+	check.Registries = registryChecks(conf, configDir)
+
+	return check, errors.Join(errs...)
+}
+
+func checkUpdates(ctx context.Context, conf *config.Config, configDir, token, baseAddr string, tlsPort int) (*UpdateCheckT, *RuleUpdateResponse, error) {
+
+	var (
+		apiUrl = fmt.Sprintf("https://%s:%d", baseAddr, tlsPort)
+		check  = &UpdateCheckT{
+			Preq:       VersionCheckT{Current: verz.Semver()},
+			Registries: []RegistryCheckT{},
+		}
+	)
+
+	currRulesVer, _, err := GetCurrentRulesVersion(configDir)
+	if err != nil {
+		currRulesVer = semver.MustParse("0.0.0")
+	}
+	check.Rules.Current = currRulesVer.String()
+
+	resp, err := checkin(ctx, apiUrl, token, currRulesVer, updateCheckTimeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrUpdateCheck, err)
+	}
+	if resp.LatestExeVersion == "" || resp.LatestRuleVersion == "" {
+		return nil, nil, fmt.Errorf("%w: %w", ErrUpdateCheck, ErrInvalidResponse)
+	}
+
+	check.Preq.Latest, check.Preq.Update = resp.LatestExeVersion, shouldUpdateExe(resp)
+	check.Rules.Latest, check.Rules.Update = resp.LatestRuleVersion, shouldUpdateRules(currRulesVer, resp)
+
+	check.Registries = registryChecks(conf, configDir)
+
+	return check, resp, nil
+}
+
+// registryChecks reports when each remote registry was last tried.
+func registryChecks(conf *config.Config, configDir string) []RegistryCheckT {
+
+	checks := []RegistryCheckT{}
+
+	for _, reg := range conf.Rules.Registries {
+		if reg.Url == "" {
+			continue
+		}
+
+		dur := defaultLocalCheckDur
+		if reg.UpdateFrequency != nil {
+			dur = *reg.UpdateFrequency
+		}
+
+		c := RegistryCheckT{Name: reg.Name, Url: reg.Url, Due: true}
+		if info, err := os.Stat(registryFile(configDir, reg) + checkedSuffix); err == nil {
+			checked := info.ModTime().UTC()
+			c.Checked, c.Due = &checked, time.Since(checked) >= dur
+		}
+		checks = append(checks, c)
+	}
+
+	return checks
+}
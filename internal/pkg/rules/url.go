@@ -0,0 +1,120 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/prequel-dev/preq/internal/pkg/config"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// Rules passed with -r may be a url, downloaded for the run, so that CI
+// jobs need no download step:
+//
+//	preq -r 'https://rules.acme.internal/preq/rules.yaml.gz#sha256=9f86d081884c7d65...'
+//
+// A sha256 fragment pins the file: a download that does not match it is
+// refused, and the copy kept in the config directory is reused while it
+// matches. With verify.require set, a url must be pinned. With update
+// checks off, only the copy kept from an earlier run is used. The file may
+// be compressed; it is read by its content.
+
+const (
+	urlsDir   = "urls"
+	pinPrefix = "sha256="
+)
+
+var (
+	ErrRulesUrl      = errors.New("rules url must be http or https, with an optional #sha256= pin")
+	ErrRulesPin      = errors.New("rules do not match their pinned sha256")
+	ErrRulesUnpinned = errors.New("signature verification required, but rules url has no #sha256= pin")
+	ErrRulesStale    = errors.New("rules url not downloaded yet, and update checks are off")
+
+	sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// IsRulesUrl tells whether the rules passed with -r are a url.
+func IsRulesUrl(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// FetchRulesUrl downloads the rules at rawUrl to configDir, unless a copy
+// matching its pin is there already, and returns the path of the copy.
+// With conf.NoUpdateCheck set it never downloads.
+func FetchRulesUrl(ctx context.Context, conf *config.Config, configDir, rawUrl string) (string, error) {
+
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", ErrRulesUrl
+	}
+
+	var pin string
+	if u.Fragment != "" {
+		pin = strings.ToLower(strings.TrimPrefix(u.Fragment, pinPrefix))
+		if !strings.HasPrefix(u.Fragment, pinPrefix) || !sha256Hex.MatchString(pin) {
+			return "", ErrRulesUrl
+		}
+		u.Fragment = ""
+	}
+
+	if pin == "" && conf.Verify.Require {
+		return "", ErrRulesUnpinned
+	}
+
+	fn := filepath.Join(configDir, urlsDir, utils.Sha256Sum([]byte(u.String()))[:16]+".yaml")
+
+	if pin != "" || conf.NoUpdateCheck {
+		data, err := os.ReadFile(fn)
+		switch {
+		case err == nil && (pin == "" || utils.Sha256Sum(data) == pin):
+			log.Info().Str("url", u.Redacted()).Str("path", fn).Msg("Using rules already downloaded")
+			return fn, nil
+		case err == nil && conf.NoUpdateCheck:
+			return "", fmt.Errorf("%w: got %s", ErrRulesPin, utils.Sha256Sum(data))
+		case conf.NoUpdateCheck:
+			return "", ErrRulesStale
+		}
+	}
+
+	log.Info().Str("url", u.Redacted()).Msg("Downloading rules")
+
+	ctx, cancel := context.WithTimeout(ctx, registryTimeout)
+	defer cancel()
+
+	client, err := registryClient(config.RegistryAuth{})
+	if err != nil {
+		return "", err
+	}
+
+	data, err := getRegistry(ctx, client, config.Registry{}, u.String(), maxSize(conf.Downloads.MaxRulesSize, DefaultMaxRulesSize))
+	if err != nil {
+		return "", err
+	}
+
+	if sum := utils.Sha256Sum(data); pin != "" && sum != pin {
+		return "", fmt.Errorf("%w: got %s", ErrRulesPin, sum)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return "", err
+	}
+
+	// Only rules that parse replace the previous copy
+	tmp := fn + tmpSuffix
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return "", err
+	}
+	if _, err := utils.ParseRulesPath(tmp, utils.WithGenIds()); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return fn, os.Rename(tmp, fn)
+}
@@ -0,0 +1,326 @@
+package ruletest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/prequel-dev/preq/internal/pkg/catalog"
+	"github.com/prequel-dev/preq/internal/pkg/engine"
+	"github.com/prequel-dev/preq/internal/pkg/pluginz"
+	"github.com/prequel-dev/preq/internal/pkg/resolve"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+# rules.test.yaml, next to rules.yaml
+tests:
+  - name: fires on bind failures
+    log: 01-example.log             # relative to this file
+    expect:
+      - cre: CRE-2025-0025
+        hits: 2                     # times detected; at least once if left out
+  - name: quiet on a clean start    # expects no detections
+    input: |
+      2025-01-02T15:04:05Z server started
+*/
+
+var (
+	ErrNoTests  = errors.New("fixture has no tests")
+	ErrNoInput  = errors.New("test needs one of log or input")
+	ErrNoCre    = errors.New("expected CRE is not defined in the rules file")
+	ErrNoExpect = errors.New("expected detection needs a cre")
+)
+
+type FixtureT struct {
+	Tests []CaseT `yaml:"tests"`
+}
+
+// CaseT runs the rules of a file over a log and lists the CREs it must
+// detect; those of the file it does not list must not be detected.
+type CaseT struct {
+	Name   string    `yaml:"name"`
+	Log    string    `yaml:"log,omitempty"`
+	Input  string    `yaml:"input,omitempty"`
+	Expect []ExpectT `yaml:"expect,omitempty"`
+}
+
+type ExpectT struct {
+	Cre  string `yaml:"cre"`
+	Hits *int   `yaml:"hits,omitempty"`
+}
+
+// ResultT is the outcome of the fixture of a rules file for one of its
+// rules, across all the fixture's tests.
+type ResultT struct {
+	Cre      string   `json:"cre"`
+	Path     string   `json:"path"`
+	Fixture  string   `json:"fixture"`
+	Tests    int      `json:"tests"`
+	Failures []string `json:"failures,omitempty"`
+}
+
+func (r ResultT) Pass() bool {
+	return len(r.Failures) == 0
+}
+
+// SummaryT is the outcome of a run of the fixtures.
+type SummaryT struct {
+	Results  []ResultT `json:"results"`
+	Untested []string  `json:"untested,omitempty"` // rule files without a fixture
+	Passed   int       `json:"passed"`
+	Failed   int       `json:"failed"`
+}
+
+type optsT struct {
+	plugins *pluginz.HostT
+	resolve []resolve.OptT
+}
+
+type OptT func(*optsT)
+
+// WithMatcherPlugins lets the rules use the conditions of the plugins.
+func WithMatcherPlugins(h *pluginz.HostT) OptT {
+	return func(o *optsT) {
+		o.plugins = h
+	}
+}
+
+// WithResolveOpts reads the logs of the tests with opts, such as the
+// timestamp formats of the config file.
+func WithResolveOpts(opts ...resolve.OptT) OptT {
+	return func(o *optsT) {
+		o.resolve = append(o.resolve, opts...)
+	}
+}
+
+// Load reads a fixture file.
+func Load(fn string) (*FixtureT, error) {
+
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	var f FixtureT
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	if len(f.Tests) == 0 {
+		return nil, fmt.Errorf("%s: %w", fn, ErrNoTests)
+	}
+
+	for i, c := range f.Tests {
+		if (c.Log == "") == (c.Input == "") {
+			return nil, fmt.Errorf("%s: test %s: %w", fn, c.label(i), ErrNoInput)
+		}
+		for _, e := range c.Expect {
+			if e.Cre == "" {
+				return nil, fmt.Errorf("%s: test %s: %w", fn, c.label(i), ErrNoExpect)
+			}
+		}
+	}
+
+	return &f, nil
+}
+
+func (c CaseT) label(i int) string {
+	if c.Name != "" {
+		return fmt.Sprintf("%q", c.Name)
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// Run runs the fixtures of the rule files at paths, and of the YAML files
+// under those that are directories.
+func Run(ctx context.Context, paths []utils.RulePathT, opts ...OptT) (*SummaryT, error) {
+
+	var (
+		o   optsT
+		sum = &SummaryT{}
+	)
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	for _, rp := range paths {
+
+		files, err := catalog.Files(rp.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fn := range files {
+
+			var (
+				frp     = utils.RulePathT{Path: fn, Type: rp.Type}
+				fixture = catalog.FixturePath(fn)
+			)
+
+			// Files found in a directory need not hold rules
+			if _, err := os.Stat(fixture); err != nil {
+				if _, err := catalog.Parse(frp); err == nil || fn == rp.Path {
+					sum.Untested = append(sum.Untested, fn)
+				}
+				continue
+			}
+
+			results, err := runFixture(ctx, frp, fixture, o)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, res := range results {
+				if res.Pass() {
+					sum.Passed++
+				} else {
+					sum.Failed++
+				}
+			}
+			sum.Results = append(sum.Results, results...)
+		}
+	}
+
+	return sum, nil
+}
+
+func runFixture(ctx context.Context, rp utils.RulePathT, fixture string, o optsT) ([]ResultT, error) {
+
+	f, err := Load(fixture)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := catalog.Parse(rp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", rp.Path, err)
+	}
+
+	var (
+		results = make([]ResultT, 0, len(rules.Rules))
+		byCre   = make(map[string]*ResultT, len(rules.Rules))
+	)
+
+	for _, rule := range rules.Rules {
+		if _, ok := byCre[rule.Cre.Id]; ok {
+			continue
+		}
+		results = append(results, ResultT{
+			Cre:     rule.Cre.Id,
+			Path:    rp.Path,
+			Fixture: fixture,
+			Tests:   len(f.Tests),
+		})
+		byCre[rule.Cre.Id] = &results[len(results)-1]
+	}
+
+	for i, c := range f.Tests {
+
+		for _, e := range c.Expect {
+			if _, ok := byCre[e.Cre]; !ok {
+				return nil, fmt.Errorf("%s: test %s: %w: %s", fixture, c.label(i), ErrNoCre, e.Cre)
+			}
+		}
+
+		hits, err := runCase(ctx, rp, fixture, c, o)
+		if err != nil {
+			return nil, fmt.Errorf("%s: test %s: %w", fixture, c.label(i), err)
+		}
+
+		for id, res := range byCre {
+			var (
+				got    = hits[id]
+				expect = slices.IndexFunc(c.Expect, func(e ExpectT) bool { return e.Cre == id })
+				fail   string
+			)
+
+			switch {
+			case expect < 0 && got > 0:
+				fail = fmt.Sprintf("expected no detection, got %d", got)
+			case expect < 0:
+			case got == 0:
+				fail = "expected a detection, got none"
+			case c.Expect[expect].Hits != nil && *c.Expect[expect].Hits != got:
+				fail = fmt.Sprintf("expected %d detections, got %d", *c.Expect[expect].Hits, got)
+			}
+
+			if fail != "" {
+				res.Failures = append(res.Failures, fmt.Sprintf("test %s: %s", c.label(i), fail))
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// runCase scans the input of a test with the rules of a file, as preq
+// would read it from stdin, and returns the hits of each CRE.
+func runCase(ctx context.Context, rp utils.RulePathT, fixture string, c CaseT, o optsT) (map[string]int, error) {
+
+	var input io.Reader
+
+	if c.Log != "" {
+		fn := c.Log
+		if !filepath.IsAbs(fn) {
+			fn = filepath.Join(filepath.Dir(fixture), fn)
+		}
+		fh, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+		defer fh.Close()
+		input = fh
+	} else {
+		input = bytes.NewReader([]byte(c.Input))
+	}
+
+	var (
+		r      = engine.New(math.MaxInt64, ux.NewUxEval())
+		report = ux.NewReport(nil)
+	)
+
+	// The plugins outlive the runtime of each test
+	if o.plugins != nil {
+		r.SetMatcherPlugins(o.plugins)
+	}
+
+	matchers, err := r.CompileRulesPath([]utils.RulePathT{rp}, report)
+	if err != nil {
+		return nil, err
+	}
+
+	sources, err := resolve.PipeReader(input, o.resolve...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.Run(ctx, matchers, sources, report); err != nil {
+		return nil, err
+	}
+
+	hits := make(map[string]int, len(report.CreHits))
+	for id, times := range report.CreHits {
+		hits[id] = len(times)
+	}
+
+	log.Debug().
+		Str("path", rp.Path).
+		Str("test", c.Name).
+		Any("hits", hits).
+		Msg("Ran rule test")
+
+	return hits, nil
+}
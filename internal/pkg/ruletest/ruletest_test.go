@@ -0,0 +1,168 @@
+package ruletest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+)
+
+const testRules = `rules:
+  - cre:
+      id: bind-example
+    metadata:
+      id: Q8vXk2LmN4pRt7Yw9ZbC3d
+      hash: Fj5Hs8Kd2Lq9Wx4Pz7Nm3R
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "still could not bind()"
+  - cre:
+      id: panic-example
+    metadata:
+      id: Hx4Pm8Rq2Tz6Wn9Kb3Ld7S
+      hash: Jc7Lt3Nx9Qw5Rm2Kd8Pz4B
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "panic:"
+`
+
+const testLog = `2019-02-05T12:07:30Z starting
+2019-02-05T12:07:31Z still could not bind()
+2019-02-05T12:07:32Z still could not bind()
+`
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for fn, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRun(t *testing.T) {
+
+	const fixture = `tests:
+  - name: binds
+    log: bind.log
+    expect:
+      - cre: bind-example
+        hits: %d
+  - name: panics
+    input: |
+      2019-02-05T12:07:30Z panic: nil map
+    expect:
+      - cre: %s
+`
+
+	tests := []struct {
+		name     string
+		hits     string
+		panicCre string
+		want     map[string]string // cre -> failure, if any
+	}{
+		{
+			name:     "pass",
+			hits:     "2",
+			panicCre: "panic-example",
+			want:     map[string]string{"bind-example": "", "panic-example": ""},
+		},
+		{
+			name:     "wrong count",
+			hits:     "1",
+			panicCre: "panic-example",
+			want:     map[string]string{"bind-example": `test "binds": expected 1 detections, got 2`, "panic-example": ""},
+		},
+		{
+			name:     "unexpected and missing",
+			hits:     "2",
+			panicCre: "bind-example",
+			want: map[string]string{
+				"bind-example":  `test "panics": expected a detection, got none`,
+				"panic-example": `test "panics": expected no detection, got 1`,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+
+			dir := writeFiles(t, map[string]string{
+				"rules.yaml":      testRules,
+				"rules.test.yaml": strings.NewReplacer("%d", tc.hits, "%s", tc.panicCre).Replace(fixture),
+				"bind.log":        testLog,
+				"other.yaml":      testRules,
+			})
+
+			sum, err := Run(context.Background(), []utils.RulePathT{{Path: dir, Type: utils.RuleTypeUser}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(sum.Untested) != 1 || filepath.Base(sum.Untested[0]) != "other.yaml" {
+				t.Errorf("Expected other.yaml untested, got %v", sum.Untested)
+			}
+
+			if len(sum.Results) != len(tc.want) {
+				t.Fatalf("Expected %d results, got %v", len(tc.want), sum.Results)
+			}
+
+			for _, res := range sum.Results {
+				want, ok := tc.want[res.Cre]
+				if !ok {
+					t.Errorf("Unexpected result %v", res)
+					continue
+				}
+				if got := strings.Join(res.Failures, "; "); got != want {
+					t.Errorf("%s: expected %q, got %q", res.Cre, want, got)
+				}
+				if res.Tests != 2 {
+					t.Errorf("%s: expected 2 tests, got %d", res.Cre, res.Tests)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		fixture string
+		want    error
+	}{
+		{"no tests", "tests: []\n", ErrNoTests},
+		{"no input", "tests:\n  - name: empty\n", ErrNoInput},
+		{"both inputs", "tests:\n  - log: a.log\n    input: x\n", ErrNoInput},
+		{"no cre", "tests:\n  - input: x\n    expect:\n      - hits: 1\n", ErrNoExpect},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"rules.test.yaml": tc.fixture})
+			if _, err := Load(filepath.Join(dir, "rules.test.yaml")); !errors.Is(err, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+
+	// A fixture must only expect the CREs of its rules
+	dir := writeFiles(t, map[string]string{
+		"rules.yaml":      testRules,
+		"rules.test.yaml": "tests:\n  - input: x\n    expect:\n      - cre: nope\n",
+	})
+	if _, err := Run(context.Background(), []utils.RulePathT{{Path: filepath.Join(dir, "rules.yaml"), Type: utils.RuleTypeUser}}); !errors.Is(err, ErrNoCre) {
+		t.Errorf("Expected %v, got %v", ErrNoCre, err)
+	}
+}
@@ -0,0 +1,191 @@
+package runbook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultTimeout    = 5 * time.Second
+	defaultRetryDelay = time.Second
+)
+
+/*
+defaults:
+  timeout: 10s
+  retries: 3
+  retry_delay: 2s
+  proxy: http://proxy.internal:3128
+  templates:
+    title: '[{{ field .cre "Id" }}] {{ field .cre "Title" }}'
+actions:
+  - type: slack
+    timeout: 30s          # overrides defaults.timeout for this action only
+    slack:
+      webhook_url: https://hooks.slack.com/services/...
+      message_template: |
+        *preq detection*: {{ template "title" . }}
+*/
+
+// defaultsConfig holds settings shared by every action. Actions may
+// override timeout, retries, retry_delay and proxy individually.
+type defaultsConfig struct {
+	Timeout    time.Duration     `yaml:"timeout,omitempty"`
+	Retries    uint              `yaml:"retries,omitempty"`
+	RetryDelay time.Duration     `yaml:"retry_delay,omitempty"`
+	Proxy      string            `yaml:"proxy,omitempty"`
+	Templates  map[string]string `yaml:"templates,omitempty"`
+}
+
+// merge returns the defaults overlaid with any non-zero action settings.
+func (d defaultsConfig) merge(c actionConfig) defaultsConfig {
+	if c.Timeout != 0 {
+		d.Timeout = c.Timeout
+	}
+	if c.Retries != nil {
+		d.Retries = *c.Retries
+	}
+	if c.RetryDelay != 0 {
+		d.RetryDelay = c.RetryDelay
+	}
+	if c.Proxy != "" {
+		d.Proxy = c.Proxy
+	}
+	return d
+}
+
+type actionOptT func(*actionOptsT)
+
+type actionOptsT struct {
+	timeout  time.Duration
+	proxy    *url.URL
+	snippets map[string]string
+}
+
+func withTimeout(timeout time.Duration) actionOptT {
+	return func(o *actionOptsT) {
+		o.timeout = timeout
+	}
+}
+
+func withProxy(proxy *url.URL) actionOptT {
+	return func(o *actionOptsT) {
+		o.proxy = proxy
+	}
+}
+
+func withSnippets(snippets map[string]string) actionOptT {
+	return func(o *actionOptsT) {
+		o.snippets = snippets
+	}
+}
+
+func parseActionOpts(opts ...actionOptT) *actionOptsT {
+	o := &actionOptsT{
+		timeout: defaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (d defaultsConfig) actionOpts() ([]actionOptT, error) {
+	opts := []actionOptT{
+		withSnippets(d.Templates),
+	}
+
+	if d.Timeout > 0 {
+		opts = append(opts, withTimeout(d.Timeout))
+	}
+
+	if d.Proxy != "" {
+		u, err := url.Parse(d.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %w", d.Proxy, err)
+		}
+		opts = append(opts, withProxy(u))
+	}
+
+	return opts, nil
+}
+
+func (o *actionOptsT) httpClient(name string) *http.Client {
+	opts := []httpz.OptT{
+		httpz.WithName("runbook." + name),
+		httpz.WithTimeout(o.timeout),
+	}
+	if o.proxy != nil {
+		opts = append(opts, httpz.WithProxy(o.proxy))
+	}
+	return httpz.New(opts...)
+}
+
+// newTemplate parses text with the shared snippets available via
+// {{ template "name" . }}.
+func (o *actionOptsT) newTemplate(name, text string) (*template.Template, error) {
+	t := template.New(name).Funcs(funcMap())
+	for n, s := range o.snippets {
+		if _, err := t.New(n).Parse(s); err != nil {
+			return nil, fmt.Errorf("invalid template snippet %q: %w", n, err)
+		}
+	}
+	return t.Parse(text)
+}
+
+// ----- decorator that retries a failed action ---------------------------------
+type retryAction struct {
+	attempts uint
+	delay    time.Duration
+	inner    Action
+}
+
+func (r *retryAction) Execute(ctx context.Context, ev map[string]any) error {
+	return retry.Do(
+		func() error {
+			return r.inner.Execute(ctx, ev)
+		},
+		retry.Attempts(r.attempts),
+		retry.Delay(r.delay),
+		retry.Context(ctx),
+		retry.OnRetry(func(n uint, err error) {
+			log.Warn().Err(err).Uint("retry", n).Msg("Retry action")
+		}),
+		retry.LastErrorOnly(true),
+	)
+}
+
+// ----- decorator that bounds each action run ----------------------------------
+type timeoutAction struct {
+	timeout time.Duration
+	inner   Action
+}
+
+func (t *timeoutAction) Execute(ctx context.Context, ev map[string]any) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.inner.Execute(ctx, ev)
+}
+
+// decorate wraps an action with the timeout and retry policy in d.
+func (d defaultsConfig) decorate(a Action) Action {
+	if d.Timeout > 0 {
+		a = &timeoutAction{timeout: d.Timeout, inner: a}
+	}
+	if d.Retries > 0 {
+		delay := d.RetryDelay
+		if delay == 0 {
+			delay = defaultRetryDelay
+		}
+		a = &retryAction{attempts: d.Retries + 1, delay: delay, inner: a}
+	}
+	return a
+}
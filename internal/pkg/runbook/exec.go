@@ -7,7 +7,6 @@ import (
 	"os"
 	"os/exec"
 	"strings"
-	"text/template"
 )
 
 type execConfig struct {
@@ -18,24 +17,25 @@ type execConfig struct {
 }
 
 type execAction struct {
-	cfg execConfig
+	cfg  execConfig
+	opts *actionOptsT
 }
 
-func newExecAction(cfg execConfig) (Action, error) {
+func newExecAction(cfg execConfig, opts ...actionOptT) (Action, error) {
 	if cfg.Path == "" && cfg.Expr == "" {
 		return nil, errors.New("either exec.path or exec.expr is required")
 	}
 	if cfg.Path != "" && cfg.Expr != "" {
 		return nil, errors.New("exec.path and exec.expr are mutually exclusive")
 	}
-	return &execAction{cfg: cfg}, nil
+	return &execAction{cfg: cfg, opts: parseActionOpts(opts...)}, nil
 }
 
 func (e *execAction) Execute(ctx context.Context, cre map[string]any) error {
 	// Template substitution for args
 	args := make([]string, len(e.cfg.Args))
 	for i, a := range e.cfg.Args {
-		tmpl, err := template.New("arg").Funcs(funcMap()).Parse(a)
+		tmpl, err := e.opts.newTemplate("arg", a)
 		if err != nil {
 			return err
 		}
@@ -53,9 +53,9 @@ func (e *execAction) Execute(ctx context.Context, cre map[string]any) error {
 
 	// expr + runtime piped via stdin
 	case e.cfg.Expr != "":
-  	// Expand template variables
-  	expr, err := renderTemplate(e.cfg.Expr, cre)
- 		if err != nil {
+		// Expand template variables
+		expr, err := e.renderTemplate(e.cfg.Expr, cre)
+		if err != nil {
 			return err
 		}
 
@@ -66,7 +66,7 @@ func (e *execAction) Execute(ctx context.Context, cre map[string]any) error {
 
 		parts := splitRuntime(runtime)
 		cmd = exec.CommandContext(ctx, parts[0], append(parts[1:], args...)...)
-    cmd.Stdin = strings.NewReader(expr)
+		cmd.Stdin = strings.NewReader(expr)
 	}
 
 	// Common output wiring
@@ -76,8 +76,8 @@ func (e *execAction) Execute(ctx context.Context, cre map[string]any) error {
 	return cmd.Run()
 }
 
-func renderTemplate(input string, data map[string]any) (string, error) {
-	tmpl, err := template.New("inline").Funcs(funcMap()).Parse(input)
+func (e *execAction) renderTemplate(input string, data map[string]any) (string, error) {
+	tmpl, err := e.opts.newTemplate("inline", input)
 	if err != nil {
 		return "", err
 	}
@@ -8,28 +8,31 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"text/template"
-	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/secretz"
 )
 
 type jiraConfig struct {
-	WebhookURL          string `yaml:"webhook_url"`
-	Secret              string `yaml:"secret"`     // optional
-	SecretEnv           string `yaml:"secret_env"` // optional
-	SummaryTemplate     string `yaml:"summary_template"`
-	DescriptionTemplate string `yaml:"description_template"`
-	ProjectKey          string `yaml:"project_key"` // e.g. "PREQ"
+	WebhookURL          string          `yaml:"webhook_url"`
+	Secret              secretz.SecretT `yaml:"secret"`     // optional, may be a !secret reference
+	SecretEnv           string          `yaml:"secret_env"` // optional
+	SummaryTemplate     string          `yaml:"summary_template"`
+	DescriptionTemplate string          `yaml:"description_template"`
+	ProjectKey          string          `yaml:"project_key"` // e.g. "PREQ"
 }
 
 type jiraAction struct {
 	cfg         jiraConfig
+	secret      string
 	summaryTmpl *template.Template
 	descTmpl    *template.Template
 	httpc       *http.Client
 }
 
-func newJiraAction(cfg jiraConfig) (Action, error) {
+func newJiraAction(cfg jiraConfig, opts ...actionOptT) (Action, error) {
+	o := parseActionOpts(opts...)
+
 	if cfg.WebhookURL == "" {
 		return nil, errors.New("jira.webhook_url is required")
 	}
@@ -39,30 +42,33 @@ func newJiraAction(cfg jiraConfig) (Action, error) {
 	if cfg.ProjectKey == "" {
 		return nil, errors.New("jira.project_key is required when using REST API mode")
 	}
-	st, err := template.New("jira-summary").Funcs(funcMap()).Parse(cfg.SummaryTemplate)
+	st, err := o.newTemplate("jira-summary", cfg.SummaryTemplate)
 	if err != nil {
 		return nil, err
 	}
-	dt, err := template.New("jira-desc").Funcs(funcMap()).Parse(cfg.DescriptionTemplate)
+	dt, err := o.newTemplate("jira-desc", cfg.DescriptionTemplate)
 	if err != nil {
 		return nil, err
 	}
 
-	if cfg.Secret == "" && cfg.SecretEnv != "" {
-		cfg.Secret = os.Getenv(cfg.SecretEnv)
+	if cfg.Secret.IsZero() && cfg.SecretEnv != "" {
+		cfg.Secret = secretz.Ref("env:" + cfg.SecretEnv)
 	}
 	// optional: hard‑fail if both were empty
-	if cfg.Secret == "" {
+	secret, err := cfg.Secret.Get()
+	if err != nil && !errors.Is(err, secretz.ErrUnset) {
+		return nil, fmt.Errorf("jira secret: %w", err)
+	}
+	if secret == "" {
 		return nil, errors.New("jira secret missing; set either 'secret' or 'secret_env'")
 	}
 
 	return &jiraAction{
 		cfg:         cfg,
+		secret:      secret,
 		summaryTmpl: st,
 		descTmpl:    dt,
-		httpc: &http.Client{
-			Timeout: 5 * time.Second,
-		},
+		httpc:       o.httpClient("jira"),
 	}, nil
 }
 
@@ -87,8 +93,8 @@ func (j *jiraAction) Execute(ctx context.Context, cre map[string]any) error {
 		return fmt.Errorf("jira post: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	if j.cfg.Secret != "" {
-		req.Header.Set("X-Automation-Webhook-Token", j.cfg.Secret)
+	if j.secret != "" {
+		req.Header.Set("X-Automation-Webhook-Token", j.secret)
 	}
 	resp, err := j.httpc.Do(req)
 	if err != nil {
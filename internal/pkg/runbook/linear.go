@@ -9,24 +9,29 @@ import (
 	"net/http"
 	"os"
 	"text/template"
+
+	"github.com/prequel-dev/preq/internal/pkg/secretz"
 )
 
 type linearConfig struct {
-	SecretEnv           string `yaml:"secret_env"`
-	Secret              string `yaml:"secret"`
-	TitleTemplate       string `yaml:"title_template"`
-	DescriptionTemplate string `yaml:"description_template"`
-	TeamID              string `yaml:"team_id"`
+	SecretEnv           string          `yaml:"secret_env"`
+	Secret              secretz.SecretT `yaml:"secret"` // may be a !secret reference
+	TitleTemplate       string          `yaml:"title_template"`
+	DescriptionTemplate string          `yaml:"description_template"`
+	TeamID              string          `yaml:"team_id"`
 }
 
 type linearAction struct {
-	token      string
-	teamID     string
-	titleTmpl  *template.Template
-	descTmpl   *template.Template
+	token     string
+	teamID    string
+	titleTmpl *template.Template
+	descTmpl  *template.Template
+	httpc     *http.Client
 }
 
-func newLinearAction(cfg linearConfig) (Action, error) {
+func newLinearAction(cfg linearConfig, opts ...actionOptT) (Action, error) {
+	o := parseActionOpts(opts...)
+
 	if cfg.TeamID == "" {
 		return nil, errors.New("linear.team_id is required")
 	}
@@ -37,36 +42,41 @@ func newLinearAction(cfg linearConfig) (Action, error) {
 		return nil, errors.New("linear.description_template is required")
 	}
 
-	st, err := template.New("linear-title").Funcs(funcMap()).Parse(cfg.TitleTemplate)
+	st, err := o.newTemplate("linear-title", cfg.TitleTemplate)
 	if err != nil {
 		return nil, fmt.Errorf("linear title template error: %w", err)
 	}
-	dt, err := template.New("linear-desc").Funcs(funcMap()).Parse(cfg.DescriptionTemplate)
+	dt, err := o.newTemplate("linear-desc", cfg.DescriptionTemplate)
 	if err != nil {
 		return nil, fmt.Errorf("linear description template error: %w", err)
 	}
 
-	if cfg.Secret == "" && cfg.SecretEnv != "" {
-		cfg.Secret = os.Getenv(cfg.SecretEnv)
+	if cfg.Secret.IsZero() && cfg.SecretEnv != "" {
+		cfg.Secret = secretz.Ref("env:" + cfg.SecretEnv)
+	}
+	token, err := cfg.Secret.Get()
+	if err != nil && !errors.Is(err, secretz.ErrUnset) {
+		return nil, fmt.Errorf("linear secret: %w", err)
 	}
-	if cfg.Secret == "" {
+	if token == "" {
 		return nil, errors.New("linear secret missing; set either 'secret' or 'secret_env'")
 	}
 
 	return &linearAction{
-		token:     cfg.Secret,
+		token:     token,
 		teamID:    cfg.TeamID,
 		titleTmpl: st,
 		descTmpl:  dt,
+		httpc:     o.httpClient("linear"),
 	}, nil
 }
 
 func (a *linearAction) Execute(ctx context.Context, cre map[string]any) error {
 	var title, desc string
-  if err := executeTemplate(&title, a.titleTmpl, cre); err != nil {
+	if err := executeTemplate(&title, a.titleTmpl, cre); err != nil {
 		return fmt.Errorf("linear: title: %w", err)
 	}
-  if err := executeTemplate(&desc, a.descTmpl, cre); err != nil {
+	if err := executeTemplate(&desc, a.descTmpl, cre); err != nil {
 		return fmt.Errorf("linear: description: %w", err)
 	}
 
@@ -102,7 +112,7 @@ func (a *linearAction) Execute(ctx context.Context, cre map[string]any) error {
 	req.Header.Set("Authorization", a.token)
 	req.Header.Set("Content-Type", "application/json")
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := a.httpc.Do(req)
 	if err != nil {
 		return fmt.Errorf("linear: post: %w", err)
 	}
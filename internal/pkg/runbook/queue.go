@@ -0,0 +1,448 @@
+package runbook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+queue:
+  path: ~/.preq/runbook.db
+  drain_timeout: 30s     # how long a one-shot run waits for deliveries
+  retry_interval: 5s     # pause between delivery passes
+  dedup_ttl: 24h         # how long delivered keys suppress duplicates
+actions:
+  - type: slack
+    name: oncall          # stable name; defaults to "<index>-<type>"
+    dedup_key: '{{ field .cre "Id" }}'
+    slack:
+      ...
+*/
+
+const (
+	defaultDrainTimeout  = 30 * time.Second
+	defaultRetryInterval = 5 * time.Second
+	defaultDedupTTL      = 24 * time.Hour
+	queueOpenTimeout     = time.Second
+)
+
+var (
+	bucketPending   = []byte("pending")
+	bucketDelivered = []byte("delivered")
+)
+
+type queueConfig struct {
+	Path          string        `yaml:"path"`
+	DrainTimeout  time.Duration `yaml:"drain_timeout,omitempty"`
+	RetryInterval time.Duration `yaml:"retry_interval,omitempty"`
+	DedupTTL      time.Duration `yaml:"dedup_ttl,omitempty"`
+}
+
+// queuedActionT is an action with the identity it is persisted under.
+type queuedActionT struct {
+	name  string
+	dedup *template.Template
+	Action
+}
+
+// dedupKey identifies one delivery of ev to the action. Without a
+// dedup_key template the CRE, rule and first detection time are used.
+func (a *queuedActionT) dedupKey(ev map[string]any) (string, error) {
+	var key string
+	if a.dedup != nil {
+		if err := executeTemplate(&key, a.dedup, ev); err != nil {
+			return "", err
+		}
+	} else {
+		key = fmt.Sprintf("%v\x00%v\x00%v", ev["id"], ev["rule_hash"], ev["timestamp"])
+	}
+
+	sum := sha256.Sum256([]byte(a.name + "\x00" + key))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type queueItemT struct {
+	Action    string          `json:"action"`
+	Attempts  int             `json:"attempts"`
+	Enqueued  time.Time       `json:"enqueued"`
+	LastError string          `json:"last_error,omitempty"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// queueT persists pending deliveries in a bbolt file so they survive a
+// restart. Delivered keys are remembered for the dedup TTL.
+type queueT struct {
+	db  *bolt.DB
+	ttl time.Duration
+}
+
+func openQueue(path string, ttl time.Duration) (*queueT, error) {
+
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, rest)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: queueOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("open runbook queue %s: %w", path, err)
+	}
+
+	q := &queueT{db: db, ttl: ttl}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(bucketPending); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(bucketDelivered); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err = q.prune(time.Now()); err != nil {
+		log.Warn().Err(err).Msg("Failed to prune runbook queue. Continue...")
+	}
+
+	return q, nil
+}
+
+// prune forgets delivered keys older than the dedup TTL.
+func (q *queueT) prune(now time.Time) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketDelivered)
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var ts time.Time
+			if err := ts.UnmarshalBinary(v); err != nil || now.Sub(ts) > q.ttl {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// enqueue stores ev for action unless key is already pending or was
+// recently delivered.
+func (q *queueT) enqueue(action, key string, ev []byte) (bool, error) {
+
+	item, err := json.Marshal(queueItemT{
+		Action:   action,
+		Enqueued: time.Now(),
+		Event:    ev,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	var added bool
+	err = q.db.Update(func(tx *bolt.Tx) error {
+		var (
+			k         = []byte(key)
+			pending   = tx.Bucket(bucketPending)
+			delivered = tx.Bucket(bucketDelivered)
+		)
+		if pending.Get(k) != nil || delivered.Get(k) != nil {
+			return nil
+		}
+		added = true
+		return pending.Put(k, item)
+	})
+
+	return added, err
+}
+
+type pendingT struct {
+	key  string
+	item queueItemT
+}
+
+func (q *queueT) pending() ([]pendingT, error) {
+	var out []pendingT
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPending).ForEach(func(k, v []byte) error {
+			var item queueItemT
+			if err := json.Unmarshal(v, &item); err != nil {
+				log.Warn().Err(err).Str("key", string(k)).Msg("Skip corrupt runbook queue item")
+				return nil
+			}
+			out = append(out, pendingT{key: string(k), item: item})
+			return nil
+		})
+	})
+	return out, err
+}
+
+// ack marks key as delivered.
+func (q *queueT) ack(key string) error {
+	ts, err := time.Now().MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return q.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketPending).Delete([]byte(key)); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketDelivered).Put([]byte(key), ts)
+	})
+}
+
+// drop removes key without marking it delivered.
+func (q *queueT) drop(key string) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPending).Delete([]byte(key))
+	})
+}
+
+func (q *queueT) fail(p pendingT, cause error) error {
+	p.item.Attempts++
+	p.item.LastError = cause.Error()
+
+	data, err := json.Marshal(p.item)
+	if err != nil {
+		return err
+	}
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPending).Put([]byte(p.key), data)
+	})
+}
+
+func (q *queueT) Close() error {
+	return q.db.Close()
+}
+
+// DispatcherT delivers runbook actions from a persistent queue in the
+// background. Deliveries are at-least-once: an item is removed only after
+// its action succeeds, so a crash mid-delivery repeats it on restart.
+type DispatcherT struct {
+	q        *queueT
+	actions  map[string]*queuedActionT
+	order    []*queuedActionT
+	interval time.Duration
+	drain    time.Duration
+
+	wake chan struct{}
+	idle chan struct{}
+	done chan struct{}
+	mux  sync.Mutex
+	quit context.CancelFunc
+}
+
+// NewDispatcher opens the queue configured in cfgPath and starts delivering
+// anything left pending by a previous run.
+func NewDispatcher(ctx context.Context, cfgPath string) (*DispatcherT, error) {
+
+	file, actions, err := loadActions(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.Queue == nil || file.Queue.Path == "" {
+		return nil, fmt.Errorf("runbook %s has no queue path", cfgPath)
+	}
+
+	return newDispatcher(ctx, file.Queue, actions)
+}
+
+func newDispatcher(ctx context.Context, cfg *queueConfig, actions []*queuedActionT) (*DispatcherT, error) {
+
+	ttl := cfg.DedupTTL
+	if ttl <= 0 {
+		ttl = defaultDedupTTL
+	}
+
+	q, err := openQueue(cfg.Path, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DispatcherT{
+		q:        q,
+		actions:  make(map[string]*queuedActionT, len(actions)),
+		order:    actions,
+		interval: cfg.RetryInterval,
+		drain:    cfg.DrainTimeout,
+		wake:     make(chan struct{}, 1),
+		idle:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if d.interval <= 0 {
+		d.interval = defaultRetryInterval
+	}
+	if d.drain <= 0 {
+		d.drain = defaultDrainTimeout
+	}
+
+	for _, a := range actions {
+		d.actions[a.name] = a
+	}
+
+	ctx, d.quit = context.WithCancel(ctx)
+	go d.run(ctx)
+
+	return d, nil
+}
+
+// Submit persists a delivery of every report entry to every action, but
+// for entries that only note a suppressed CRE or disabled rule, and
+// returns without waiting for them to run.
+func (d *DispatcherT) Submit(report ux.ReportDocT) error {
+
+	for _, ev := range report {
+		if noteOnly(ev) {
+			continue
+		}
+
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range d.order {
+			key, err := a.dedupKey(ev)
+			if err != nil {
+				return fmt.Errorf("action %s dedup key: %w", a.name, err)
+			}
+
+			added, err := d.q.enqueue(a.name, key, data)
+			if err != nil {
+				return err
+			}
+			if !added {
+				log.Debug().Str("action", a.name).Str("key", key).Msg("Skip duplicate runbook delivery")
+			}
+		}
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Wait blocks until the queue is empty, ctx is done or the drain timeout
+// passes. It returns the number of deliveries still pending.
+func (d *DispatcherT) Wait(ctx context.Context) int {
+
+	ctx, cancel := context.WithTimeout(ctx, d.drain)
+	defer cancel()
+
+	for {
+		d.mux.Lock()
+		idle := d.idle
+		d.mux.Unlock()
+
+		select {
+		case <-idle:
+		case <-ctx.Done():
+		}
+
+		items, err := d.q.pending()
+		if err != nil || len(items) == 0 || ctx.Err() != nil {
+			return len(items)
+		}
+	}
+}
+
+// Close stops delivery. Pending items stay on disk for the next run.
+func (d *DispatcherT) Close() error {
+	d.quit()
+	<-d.done
+	return d.q.Close()
+}
+
+func (d *DispatcherT) run(ctx context.Context) {
+	defer close(d.done)
+
+	for {
+		d.deliver(ctx)
+
+		// Signal anyone waiting that a pass completed
+		d.mux.Lock()
+		close(d.idle)
+		d.idle = make(chan struct{})
+		d.mux.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-time.After(d.interval):
+		}
+	}
+}
+
+// deliver makes one pass over the pending items.
+func (d *DispatcherT) deliver(ctx context.Context) {
+
+	items, err := d.q.pending()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read runbook queue")
+		return
+	}
+
+	for _, p := range items {
+		if ctx.Err() != nil {
+			return
+		}
+
+		a, ok := d.actions[p.item.Action]
+		if !ok {
+			log.Warn().Str("action", p.item.Action).Msg("Drop queued delivery for unknown action")
+			if err := d.q.drop(p.key); err != nil {
+				log.Error().Err(err).Msg("Failed to drop runbook queue item")
+			}
+			continue
+		}
+
+		ev, err := ux.DecodeReportEntry(p.item.Event)
+		if err != nil {
+			log.Warn().Err(err).Str("action", a.name).Msg("Drop undecodable runbook queue item")
+			if err := d.q.drop(p.key); err != nil {
+				log.Error().Err(err).Msg("Failed to drop runbook queue item")
+			}
+			continue
+		}
+
+		if err = a.Execute(ctx, ev); err != nil {
+			log.Warn().
+				Err(err).
+				Str("action", a.name).
+				Int("attempts", p.item.Attempts+1).
+				Msg("Runbook delivery failed. Will retry...")
+			if err := d.q.fail(p, err); err != nil {
+				log.Error().Err(err).Msg("Failed to update runbook queue item")
+			}
+			continue
+		}
+
+		if err = d.q.ack(p.key); err != nil {
+			log.Error().Err(err).Msg("Failed to ack runbook queue item")
+		}
+	}
+}
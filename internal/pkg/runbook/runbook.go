@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/prequel-dev/preq/internal/pkg/ux"
 	"github.com/rs/zerolog/log"
@@ -49,7 +50,7 @@ actions:
     regex: "CRE-2025-0026"
     linear:
       team_id: 9cfb482a-81e3-4154-b5b9-2c805e70a02d
-      secret_env: LINEAR_TOKEN
+      secret: !secret keychain:linear
       title_template: |
         [{{ field .cre "Id" }}] {{ field .cre "Title" }}
       description_template: |
@@ -88,17 +89,29 @@ type Action interface {
 }
 
 type configFile struct {
-	Actions []actionConfig `yaml:"actions"`
+	Defaults defaultsConfig `yaml:"defaults,omitempty"`
+	Queue    *queueConfig   `yaml:"queue,omitempty"`
+	Actions  []actionConfig `yaml:"actions"`
 }
 
 type actionConfig struct {
 	Type  string `yaml:"type"`
+	Name  string `yaml:"name,omitempty"`
 	Regex string `yaml:"regex,omitempty"`
 
-	Slack *slackConfig `yaml:"slack,omitempty"`
-	Jira  *jiraConfig  `yaml:"jira,omitempty"`
+	// Template for the queue's per-action dedup key
+	DedupKey string `yaml:"dedup_key,omitempty"`
+
+	// Optional per-action overrides of the top-level defaults
+	Timeout    time.Duration `yaml:"timeout,omitempty"`
+	Retries    *uint         `yaml:"retries,omitempty"`
+	RetryDelay time.Duration `yaml:"retry_delay,omitempty"`
+	Proxy      string        `yaml:"proxy,omitempty"`
+
+	Slack  *slackConfig  `yaml:"slack,omitempty"`
+	Jira   *jiraConfig   `yaml:"jira,omitempty"`
 	Linear *linearConfig `yaml:"linear,omitempty"`
-	Exec  *execConfig  `yaml:"exec,omitempty"`
+	Exec   *execConfig   `yaml:"exec,omitempty"`
 }
 
 func extractCreId(ev map[string]any) string {
@@ -148,56 +161,99 @@ func (f *filteredAction) Execute(ctx context.Context, ev map[string]any) error {
 }
 
 func buildActions(cfgPath string) ([]Action, error) {
-	raw, err := os.ReadFile(cfgPath)
+	_, named, err := loadActions(cfgPath)
 	if err != nil {
 		return nil, err
 	}
+
+	actions := make([]Action, 0, len(named))
+	for _, a := range named {
+		actions = append(actions, a.Action)
+	}
+	return actions, nil
+}
+
+func loadActions(cfgPath string) (*configFile, []*queuedActionT, error) {
+	raw, err := os.ReadFile(cfgPath)
+	if err != nil {
+		return nil, nil, err
+	}
 	var file configFile
 	if err := yaml.Unmarshal(raw, &file); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	actions := make([]Action, 0, len(file.Actions))
+	var (
+		actions = make([]*queuedActionT, 0, len(file.Actions))
+		names   = make(map[string]struct{}, len(file.Actions))
+	)
 	for i, c := range file.Actions {
-		var a Action
+		var (
+			a        Action
+			settings = file.Defaults.merge(c)
+		)
+
+		opts, err := settings.actionOpts()
+		if err != nil {
+			return nil, nil, fmt.Errorf("action #%d: %w", i, err)
+		}
+
 		switch c.Type {
 		case ActionTypeSlack:
 			if c.Slack == nil {
-				return nil, fmt.Errorf("missing slack section for action #%d", i)
+				return nil, nil, fmt.Errorf("missing slack section for action #%d", i)
 			}
-			a, err = newSlackAction(*c.Slack)
+			a, err = newSlackAction(*c.Slack, opts...)
 		case ActionTypeJira:
 			if c.Jira == nil {
-				return nil, fmt.Errorf("missing jira section for action #%d", i)
+				return nil, nil, fmt.Errorf("missing jira section for action #%d", i)
 			}
-			a, err = newJiraAction(*c.Jira)
+			a, err = newJiraAction(*c.Jira, opts...)
 		case ActionTypeExec:
 			if c.Exec == nil {
-				return nil, fmt.Errorf("missing exec section for action #%d", i)
+				return nil, nil, fmt.Errorf("missing exec section for action #%d", i)
 			}
-			a, err = newExecAction(*c.Exec)
+			a, err = newExecAction(*c.Exec, opts...)
 		case ActionTypeLinear:
 			if c.Linear == nil {
-				return nil, fmt.Errorf("missing linear section for action #%d", i)
+				return nil, nil, fmt.Errorf("missing linear section for action #%d", i)
 			}
-			a, err = newLinearAction(*c.Linear)
+			a, err = newLinearAction(*c.Linear, opts...)
 		default:
 			err = fmt.Errorf("unknown action type %q (index %d)", c.Type, i)
 		}
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
+		a = settings.decorate(a)
+
 		if c.Regex != "" {
 			re, err := regexp.Compile(c.Regex)
 			if err != nil {
-				return nil, fmt.Errorf("invalid cre_id_regex for action #%d: %w", i, err)
+				return nil, nil, fmt.Errorf("invalid cre_id_regex for action #%d: %w", i, err)
 			}
 			a = &filteredAction{pattern: re, inner: a}
 		}
-		actions = append(actions, a)
+
+		qa := &queuedActionT{name: c.Name, Action: a}
+		if qa.name == "" {
+			qa.name = fmt.Sprintf("%d-%s", i, c.Type)
+		}
+		if _, ok := names[qa.name]; ok {
+			return nil, nil, fmt.Errorf("duplicate action name %q (index %d)", qa.name, i)
+		}
+		names[qa.name] = struct{}{}
+
+		if c.DedupKey != "" {
+			if qa.dedup, err = template.New("dedup_key").Funcs(funcMap()).Parse(c.DedupKey); err != nil {
+				return nil, nil, fmt.Errorf("invalid dedup_key for action #%d: %w", i, err)
+			}
+		}
+
+		actions = append(actions, qa)
 	}
-	return actions, nil
+	return &file, actions, nil
 }
 
 // template helper function to extract fields from CRE reports
@@ -254,13 +310,20 @@ func executeTemplate(out *string, tmpl *template.Template, data any) error {
 
 func Runbook(ctx context.Context, cfgPath string, report ux.ReportDocT) error {
 
-	actions, err := buildActions(cfgPath)
+	file, actions, err := loadActions(cfgPath)
 	if err != nil {
 		return err
 	}
 
+	if file.Queue != nil && file.Queue.Path != "" {
+		return runQueued(ctx, file.Queue, actions, report)
+	}
+
 	for _, a := range actions {
 		for _, cre := range report {
+			if noteOnly(cre) {
+				continue
+			}
 			if err := a.Execute(ctx, cre); err != nil {
 				return err
 			}
@@ -269,3 +332,35 @@ func Runbook(ctx context.Context, cfgPath string, report ux.ReportDocT) error {
 
 	return nil
 }
+
+// noteOnly reports whether a report entry only notes that its CRE was
+// suppressed or its rule disabled, which no action is run for.
+func noteOnly(ev map[string]any) bool {
+	_, suppressed := ev["suppressed"]
+	_, disabled := ev["disabled"]
+	return suppressed || disabled
+}
+
+// runQueued persists the deliveries before running them so that any that
+// fail, or do not finish before the drain timeout, are retried next run.
+func runQueued(ctx context.Context, cfg *queueConfig, actions []*queuedActionT, report ux.ReportDocT) error {
+
+	d, err := newDispatcher(ctx, cfg, actions)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err = d.Submit(report); err != nil {
+		return err
+	}
+
+	if n := d.Wait(ctx); n > 0 {
+		log.Warn().
+			Int("pending", n).
+			Str("queue", cfg.Path).
+			Msg("Runbook deliveries still pending; will retry on next run")
+	}
+
+	return nil
+}
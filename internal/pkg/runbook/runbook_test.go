@@ -3,15 +3,21 @@ package runbook
 import (
 	"bytes"
 	"context"
-	"github.com/prequel-dev/preq/internal/pkg/ux"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"text/template"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
 )
 
 type stubAction struct{ called bool }
@@ -192,3 +198,213 @@ func TestRunbook(t *testing.T) {
 		t.Fatalf("Runbook: %v", err)
 	}
 }
+
+func TestRunbookSuppressed(t *testing.T) {
+	dir := t.TempDir()
+	ran := filepath.Join(dir, "ran")
+	script := filepath.Join(dir, "run.sh")
+	os.WriteFile(script, []byte("#!/bin/sh\ntouch "+ran+"\n"), 0755)
+	cfg := "actions:\n- type: exec\n  exec:\n    path: " + script + "\n"
+	path := filepath.Join(dir, "cfg.yaml")
+	os.WriteFile(path, []byte(cfg), 0644)
+
+	report := ux.ReportDocT{{
+		"cre":        map[string]any{"ID": "CRE"},
+		"suppressed": &ux.SuppressedT{Reason: "known"},
+	}, {
+		"cre":      map[string]any{"ID": "CRE"},
+		"disabled": &ux.DisabledT{Reason: "rule timeout exceeded"},
+	}}
+	if err := Runbook(context.Background(), path, report); err != nil {
+		t.Fatalf("Runbook: %v", err)
+	}
+	if _, err := os.Stat(ran); err == nil {
+		t.Errorf("expected no action for suppressed or disabled entries")
+	}
+}
+
+func TestBuildActionsDefaults(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		if !bytes.Contains(body, []byte("[CRE-3]")) {
+			t.Errorf("missing snippet output: %s", body)
+		}
+		if calls == 1 {
+			w.WriteHeader(500)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer srv.Close()
+
+	cfg := `defaults:
+  timeout: 2s
+  retries: 1
+  retry_delay: 1ms
+  templates:
+    title: '[{{ field .cre "ID" }}]'
+slack: &slack
+  webhook_url: ` + srv.URL + `
+  message_template: '{{ template "title" . }}'
+actions:
+  - type: slack
+    slack: *slack
+  - type: slack
+    retries: 0
+    slack:
+      <<: *slack
+`
+	path := filepath.Join(t.TempDir(), "cfg.yaml")
+	os.WriteFile(path, []byte(cfg), 0644)
+	acts, err := buildActions(path)
+	if err != nil {
+		t.Fatalf("buildActions: %v", err)
+	}
+	if len(acts) != 2 {
+		t.Fatalf("expected 2 actions got %d", len(acts))
+	}
+	if _, ok := acts[0].(*retryAction); !ok {
+		t.Fatalf("expected default retry policy, got %T", acts[0])
+	}
+	if _, ok := acts[1].(*timeoutAction); !ok {
+		t.Fatalf("expected retry override, got %T", acts[1])
+	}
+
+	ev := map[string]any{"cre": map[string]any{"ID": "CRE-3"}}
+	if err := acts[0].Execute(context.Background(), ev); err != nil {
+		t.Fatalf("execute with retry: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 calls got %d", calls)
+	}
+}
+
+func TestBuildActionsInvalidProxy(t *testing.T) {
+	cfg := "defaults:\n  proxy: \"http://[::1\"\nactions:\n- type: exec\n  exec:\n    expr: 'true'\n"
+	path := filepath.Join(t.TempDir(), "cfg.yaml")
+	os.WriteFile(path, []byte(cfg), 0644)
+	if _, err := buildActions(path); err == nil {
+		t.Fatalf("expected error for invalid proxy")
+	}
+}
+
+func TestRunbookQueue(t *testing.T) {
+	var (
+		calls int
+		fail  = true
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		if !bytes.Contains(body, []byte("CRE-9")) {
+			t.Errorf("missing cre id: %s", body)
+		}
+		if fail {
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	cfg := `queue:
+  path: ` + filepath.Join(dir, "queue.db") + `
+  drain_timeout: 50ms
+  retry_interval: 10ms
+actions:
+  - type: slack
+    name: oncall
+    slack:
+      webhook_url: ` + srv.URL + `
+      message_template: '{{ field .cre "Id" }} {{ (index .hits 0).Entry }}'
+`
+	path := filepath.Join(dir, "cfg.yaml")
+	os.WriteFile(path, []byte(cfg), 0644)
+
+	rep := ux.NewReport(nil)
+	rep.Rules["CRE-9"] = parser.ParseRuleT{Cre: parser.ParseCreT{Id: "CRE-9"}}
+	rep.AddCreHit(&parser.ParseCreT{Id: "CRE-9"}, time.Unix(1, 0), matchz.HitsT{
+		Entries: []matchz.EntryT{{Timestamp: 1, Entry: []byte("boom")}},
+	})
+	doc, err := rep.CreateReport()
+	if err != nil {
+		t.Fatalf("CreateReport: %v", err)
+	}
+
+	// Endpoint outage: the delivery stays queued
+	if err := Runbook(context.Background(), path, doc); err != nil {
+		t.Fatalf("Runbook: %v", err)
+	}
+	if calls == 0 {
+		t.Fatalf("expected a delivery attempt")
+	}
+
+	// Next run delivers the pending item once, and dedups the resubmission
+	fail = false
+	calls = 0
+	if err := Runbook(context.Background(), path, doc); err != nil {
+		t.Fatalf("Runbook: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 delivery got %d", calls)
+	}
+
+	calls = 0
+	if err := Runbook(context.Background(), path, doc); err != nil {
+		t.Fatalf("Runbook: %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected duplicate to be suppressed, got %d calls", calls)
+	}
+}
+
+func TestStream(t *testing.T) {
+	var (
+		mux sync.Mutex
+		ids []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mux.Lock()
+		ids = append(ids, string(body))
+		mux.Unlock()
+	}))
+	defer srv.Close()
+
+	cfg := `actions:
+  - type: slack
+    slack:
+      webhook_url: ` + srv.URL + `
+      message_template: '{{ field .cre "Id" }}'
+`
+	path := filepath.Join(t.TempDir(), "cfg.yaml")
+	os.WriteFile(path, []byte(cfg), 0644)
+
+	s, err := NewStream(context.Background(), path)
+	if err != nil {
+		t.Fatalf("NewStream: %v", err)
+	}
+
+	rep := ux.NewReport(nil)
+	rep.Notify(s.Submit)
+	for i, id := range []string{"CRE-1", "CRE-2"} {
+		rep.Rules[id] = parser.ParseRuleT{Cre: parser.ParseCreT{Id: id}}
+		rep.AddCreHit(&parser.ParseCreT{Id: id}, time.Unix(int64(i), 0), matchz.HitsT{
+			Entries: []matchz.EntryT{{Timestamp: int64(i), Entry: []byte("boom")}},
+		})
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if len(ids) != 2 || !strings.Contains(ids[0], "CRE-1") || !strings.Contains(ids[1], "CRE-2") {
+		t.Errorf("Expected a delivery per detection in order, got %q", ids)
+	}
+	if rep.Size() != 0 {
+		t.Errorf("Expected delivered detections to be dropped, got %d", rep.Size())
+	}
+}
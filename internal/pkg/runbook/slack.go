@@ -9,7 +9,6 @@ import (
 	"io"
 	"net/http"
 	"text/template"
-	"time"
 )
 
 type slackConfig struct {
@@ -23,24 +22,24 @@ type slackAction struct {
 	httpc *http.Client
 }
 
-func newSlackAction(cfg slackConfig) (Action, error) {
+func newSlackAction(cfg slackConfig, opts ...actionOptT) (Action, error) {
+	o := parseActionOpts(opts...)
+
 	if cfg.WebhookURL == "" {
 		return nil, errors.New("slack.webhook_url is required")
 	}
 	if cfg.MessageTemplate == "" {
 		return nil, errors.New("slack.message_template is required")
 	}
-	t, err := template.New("slack").Funcs(funcMap()).Parse(cfg.MessageTemplate)
+	t, err := o.newTemplate("slack", cfg.MessageTemplate)
 	if err != nil {
 		return nil, err
 	}
 
 	return &slackAction{
-		cfg:  cfg,
-		tmpl: t,
-		httpc: &http.Client{
-			Timeout: 5 * time.Second,
-		},
+		cfg:   cfg,
+		tmpl:  t,
+		httpc: o.httpClient("slack"),
 	}, nil
 }
 
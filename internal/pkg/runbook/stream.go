@@ -0,0 +1,120 @@
+package runbook
+
+import (
+	"context"
+
+	"github.com/prequel-dev/preq/internal/pkg/queuez"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	streamBacklog = 256
+)
+
+// StreamT runs a runbook on detections as they are found by a long running
+// process. With a queue configured, deliveries are persisted and retried by
+// a DispatcherT. Otherwise the actions run in turn on one goroutine and a
+// failed delivery is logged and dropped.
+type StreamT struct {
+	ctx     context.Context
+	d       *DispatcherT
+	actions []*queuedActionT
+	q       *queuez.QueueT[ux.ReportDocT]
+	policy  queuez.PolicyT
+	done    chan struct{}
+}
+
+type StreamOptT func(*StreamT)
+
+// WithBacklogPolicy sets what happens when the backlog of an unqueued
+// runbook is full. It blocks by default.
+func WithBacklogPolicy(policy queuez.PolicyT) StreamOptT {
+	return func(s *StreamT) {
+		s.policy = policy
+	}
+}
+
+// NewStream loads the runbook in cfgPath and starts delivering.
+func NewStream(ctx context.Context, cfgPath string, opts ...StreamOptT) (*StreamT, error) {
+
+	file, actions, err := loadActions(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &StreamT{ctx: ctx, done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if file.Queue != nil && file.Queue.Path != "" {
+		if s.d, err = newDispatcher(ctx, file.Queue, actions); err != nil {
+			return nil, err
+		}
+		close(s.done)
+		return s, nil
+	}
+
+	s.actions = actions
+	s.q = queuez.New[ux.ReportDocT]("runbook", streamBacklog, s.policy)
+	go s.run()
+
+	return s, nil
+}
+
+// Submit hands a report to the actions. While the backlog of an unqueued
+// runbook is full it blocks, or drops a report, as the backlog's policy
+// says; it stops blocking once the stream's context is done.
+func (s *StreamT) Submit(report ux.ReportDocT) {
+
+	if s.d != nil {
+		if err := s.d.Submit(report); err != nil {
+			log.Error().Err(err).Msg("Failed to queue runbook delivery")
+		}
+		return
+	}
+
+	s.q.PushCtx(s.ctx, report)
+}
+
+func (s *StreamT) run() {
+	defer close(s.done)
+
+	for report := range s.q.C() {
+		for _, a := range s.actions {
+			for _, ev := range report {
+				if noteOnly(ev) {
+					continue
+				}
+				if err := a.Execute(s.ctx, ev); err != nil {
+					log.Warn().
+						Err(err).
+						Str("action", a.name).
+						Interface("id", ev["id"]).
+						Msg("Runbook action failed")
+				}
+			}
+		}
+	}
+}
+
+// Close stops accepting reports and waits for those already submitted.
+// Queued deliveries left pending are retried on the next start.
+func (s *StreamT) Close() error {
+
+	if s.d != nil {
+		if n := s.d.Wait(s.ctx); n > 0 {
+			log.Warn().Int("pending", n).Msg("Runbook deliveries still pending; will retry on next start")
+		}
+		return s.d.Close()
+	}
+
+	s.q.Close()
+	<-s.done
+
+	if stats := s.q.Stats(); stats.Dropped > 0 {
+		log.Warn().Int64("dropped", stats.Dropped).Msg("Runbook backlog was full; reports were dropped")
+	}
+	return nil
+}
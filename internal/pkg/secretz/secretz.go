@@ -0,0 +1,147 @@
+package secretz
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+	"gopkg.in/yaml.v3"
+)
+
+// Secrets in config and action files that are committed to git are
+// written as references with the !secret tag, resolved when used:
+//
+//	token: !secret env:ACME_RULES_TOKEN      # an environment variable
+//	token: !secret ACME_RULES_TOKEN          # the same
+//	token: !secret file:/run/secrets/acme    # a file, as of docker or k8s secrets
+//	token: !secret keychain:acme-rules       # an item of the OS keychain
+//
+// Keychain items are kept under the service preq-secrets; preq config
+// secret stores one. A secret written without the tag is used as is, but
+// then is in the file. Secrets print as their reference, or redacted,
+// never as their value.
+
+const (
+	Tag = "!secret"
+
+	KeychainService = "preq-secrets"
+
+	schemeEnv      = "env"
+	schemeFile     = "file"
+	schemeKeychain = "keychain"
+
+	redacted = "xxxxx"
+)
+
+var (
+	ErrRef   = errors.New("secret references are env:NAME, file:PATH or keychain:NAME")
+	ErrUnset = errors.New("secret is not set")
+)
+
+// SecretT is a secret of a config, written in it or referenced with the
+// !secret tag.
+type SecretT struct {
+	ref   string
+	value string
+}
+
+// Ref returns a secret referencing ref, as the !secret tag does.
+func Ref(ref string) SecretT {
+	return SecretT{ref: ref}
+}
+
+func (s SecretT) IsZero() bool {
+	return s.ref == "" && s.value == ""
+}
+
+// Get resolves the secret.
+func (s SecretT) Get() (string, error) {
+
+	if s.ref == "" {
+		return s.value, nil
+	}
+
+	scheme, name, ok := strings.Cut(s.ref, ":")
+	if !ok {
+		scheme, name = schemeEnv, s.ref
+	}
+	if name == "" {
+		return "", fmt.Errorf("%w: %s", ErrRef, s.ref)
+	}
+
+	var (
+		secret string
+		err    error
+	)
+
+	switch scheme {
+	case schemeEnv:
+		secret = os.Getenv(name)
+	case schemeFile:
+		var data []byte
+		if data, err = os.ReadFile(name); err == nil {
+			secret = strings.TrimRight(string(data), "\r\n")
+		}
+	case schemeKeychain:
+		if secret, err = keyring.Get(KeychainService, name); errors.Is(err, keyring.ErrNotFound) {
+			err = nil
+		}
+	default:
+		return "", fmt.Errorf("%w: %s", ErrRef, s.ref)
+	}
+
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("secret %s: %w", s.ref, err)
+	case secret == "":
+		return "", fmt.Errorf("%w: %s", ErrUnset, s.ref)
+	}
+
+	return secret, nil
+}
+
+// String returns the reference of the secret, or the secret redacted.
+func (s SecretT) String() string {
+	switch {
+	case s.ref != "":
+		return Tag + " " + s.ref
+	case s.value != "":
+		return redacted
+	}
+	return ""
+}
+
+func (s *SecretT) UnmarshalYAML(n *yaml.Node) error {
+
+	if n.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: a secret is a string or a %s reference", n.Line, Tag)
+	}
+
+	switch {
+	case n.Tag == Tag:
+		*s = SecretT{ref: strings.TrimSpace(n.Value)}
+	case n.Tag == "!!null":
+		*s = SecretT{}
+	default:
+		*s = SecretT{value: n.Value}
+	}
+
+	return nil
+}
+
+func (s SecretT) MarshalYAML() (any, error) {
+	switch {
+	case s.ref != "":
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: Tag, Value: s.ref}, nil
+	case s.value != "":
+		return redacted, nil
+	}
+	return nil, nil
+}
+
+// Store keeps secret in the OS keychain as name, for keychain:name.
+func Store(name, secret string) error {
+	return keyring.Set(KeychainService, name, secret)
+}
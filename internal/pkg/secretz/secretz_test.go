@@ -0,0 +1,92 @@
+package secretz
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zalando/go-keyring"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSecretGet(t *testing.T) {
+	keyring.MockInit()
+
+	t.Setenv("TEST_SECRET", "from-env")
+	t.Setenv("TEST_UNSET", "")
+
+	fn := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(fn, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Store("acme", "from-keychain"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		secret SecretT
+		want   string
+		err    error
+	}{
+		{"plain", SecretT{value: "plain"}, "plain", nil},
+		{"env", Ref("env:TEST_SECRET"), "from-env", nil},
+		{"bare env", Ref("TEST_SECRET"), "from-env", nil},
+		{"unset env", Ref("env:TEST_UNSET"), "", ErrUnset},
+		{"file", Ref("file:" + fn), "from-file", nil},
+		{"missing file", Ref("file:" + fn + ".nope"), "", os.ErrNotExist},
+		{"keychain", Ref("keychain:acme"), "from-keychain", nil},
+		{"missing keychain", Ref("keychain:nope"), "", ErrUnset},
+		{"unknown scheme", Ref("vault:acme"), "", ErrRef},
+		{"empty name", Ref("env:"), "", ErrRef},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.secret.Get()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected %v, got %v", tt.err, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSecretYAML(t *testing.T) {
+
+	var c struct {
+		Ref   SecretT `yaml:"ref"`
+		Plain SecretT `yaml:"plain"`
+		Unset SecretT `yaml:"unset"`
+	}
+
+	data := "ref: !secret keychain:acme\nplain: hunter2\nunset:\n"
+	if err := yaml.Unmarshal([]byte(data), &c); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Ref != Ref("keychain:acme") || c.Plain.value != "hunter2" || !c.Unset.IsZero() {
+		t.Fatalf("Unexpected secrets %+v", c)
+	}
+
+	// Printed, a secret is its reference or redacted, never its value
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(out); !strings.Contains(s, "ref: !secret keychain:acme") || !strings.Contains(s, "plain: "+redacted) || strings.Contains(s, "hunter2") {
+		t.Errorf("Unexpected secrets printed:\n%s", s)
+	}
+	if s := c.Plain.String(); s != redacted {
+		t.Errorf("Expected %s, got %s", redacted, s)
+	}
+
+	if err := yaml.Unmarshal([]byte("ref: [a, b]\n"), &c); err == nil {
+		t.Error("Expected an error for a list as a secret")
+	}
+}
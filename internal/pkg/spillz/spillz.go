@@ -0,0 +1,171 @@
+package spillz
+
+// Package spillz moves state that outgrows a memory budget to a temporary
+// file, least recently active first, and reads it back on demand. The
+// caller holds the state and tells the store what it adds; the store says
+// what to spill. A StoreT is not safe for concurrent use.
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+type StoreT[R any] struct {
+	dir     string
+	limit   int64
+	used    int64
+	spilled int64
+	sizes   map[string]int64
+	lru     *list.List // front is most recently added to
+	elems   map[string]*list.Element
+	f       *os.File
+	off     int64
+	spans   map[string][]spanT
+}
+
+type spanT struct {
+	off int64
+	n   int64
+}
+
+// New makes a store that holds limit bytes in memory and spills to a
+// temporary file in dir, or the default temporary directory.
+func New[R any](limit int64, dir string) *StoreT[R] {
+	return &StoreT[R]{
+		dir:   dir,
+		limit: limit,
+		sizes: make(map[string]int64),
+		lru:   list.New(),
+		elems: make(map[string]*list.Element),
+		spans: make(map[string][]spanT),
+	}
+}
+
+func (s *StoreT[R]) Limit() int64 {
+	return s.limit
+}
+
+// Spilled returns the bytes moved to disk so far.
+func (s *StoreT[R]) Spilled() int64 {
+	return s.spilled
+}
+
+// Add accounts for size more bytes held for id and returns the ids to
+// spill with Write, least recently active first. id itself is never
+// returned.
+func (s *StoreT[R]) Add(id string, size int64) []string {
+
+	s.sizes[id] += size
+	s.used += size
+
+	if el, ok := s.elems[id]; ok {
+		s.lru.MoveToFront(el)
+	} else {
+		s.elems[id] = s.lru.PushFront(id)
+	}
+
+	var evict []string
+	for used := s.used; used > s.limit; {
+		el := s.lru.Back()
+		if el == nil || el.Value.(string) == id {
+			break
+		}
+		victim := el.Value.(string)
+		used -= s.sizes[victim]
+		s.lru.Remove(el)
+		delete(s.elems, victim)
+		evict = append(evict, victim)
+	}
+
+	return evict
+}
+
+// Release accounts for size bytes of id the caller no longer holds.
+func (s *StoreT[R]) Release(id string, size int64) {
+	size = min(size, s.sizes[id])
+	s.sizes[id] -= size
+	s.used -= size
+}
+
+// Write appends the records of id to the spill file; the caller then
+// drops them from memory.
+func (s *StoreT[R]) Write(id string, recs []R) error {
+
+	if s.f == nil {
+		f, err := os.CreateTemp(s.dir, "preq-spill-*")
+		if err != nil {
+			return err
+		}
+		s.f = f
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.f.WriteAt(buf.Bytes(), s.off); err != nil {
+		return err
+	}
+
+	s.spans[id] = append(s.spans[id], spanT{off: s.off, n: int64(buf.Len())})
+	s.off += int64(buf.Len())
+	s.spilled += s.sizes[id]
+	s.used -= s.sizes[id]
+	s.sizes[id] = 0
+
+	return nil
+}
+
+// Spills reports whether id has records on disk.
+func (s *StoreT[R]) Spills(id string) bool {
+	return len(s.spans[id]) > 0
+}
+
+// Read returns the spilled records of id in the order written.
+func (s *StoreT[R]) Read(id string) ([]R, error) {
+
+	var recs []R
+	for _, sp := range s.spans[id] {
+		dec := json.NewDecoder(io.NewSectionReader(s.f, sp.off, sp.n))
+		for {
+			var rec R
+			if err := dec.Decode(&rec); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			recs = append(recs, rec)
+		}
+	}
+
+	return recs, nil
+}
+
+// Forget drops id, e.g. once its state has been handed over or read back.
+func (s *StoreT[R]) Forget(id string) {
+	s.used -= s.sizes[id]
+	delete(s.sizes, id)
+	delete(s.spans, id)
+	if el, ok := s.elems[id]; ok {
+		s.lru.Remove(el)
+		delete(s.elems, id)
+	}
+}
+
+// Close removes the spill file.
+func (s *StoreT[R]) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	name := s.f.Name()
+	s.f.Close()
+	s.f = nil
+	return os.Remove(name)
+}
@@ -0,0 +1,60 @@
+package spillz
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+
+	var (
+		dir = t.TempDir()
+		s   = New[string](10, dir)
+	)
+
+	if evict := s.Add("a", 6); len(evict) != 0 {
+		t.Fatalf("Expected nothing to spill, got %v", evict)
+	}
+	if evict := s.Add("b", 6); !slices.Equal(evict, []string{"a"}) {
+		t.Fatalf("Expected a to spill, got %v", evict)
+	}
+
+	// The id added to is never spilled
+	if evict := s.Add("b", 20); len(evict) != 0 {
+		t.Fatalf("Expected nothing to spill, got %v", evict)
+	}
+
+	if err := s.Write("a", []string{"one", "two"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write("a", []string{"three"}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Spills("a") || s.Spills("b") {
+		t.Error("Expected only a to spill")
+	}
+	if s.Spilled() != 6 {
+		t.Errorf("Expected 6 bytes spilled, got %d", s.Spilled())
+	}
+
+	recs, err := s.Read("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"one", "two", "three"}; !slices.Equal(recs, want) {
+		t.Errorf("Expected %v, got %v", want, recs)
+	}
+
+	s.Forget("a")
+	if s.Spills("a") {
+		t.Error("Expected a forgotten")
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := os.ReadDir(dir); len(left) != 0 {
+		t.Errorf("Expected spill file removed, found %d files", len(left))
+	}
+}
@@ -0,0 +1,169 @@
+package statz
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/dirz"
+	"github.com/prequel-dev/preq/internal/pkg/httpz"
+	"github.com/prequel-dev/preq/internal/pkg/verz"
+)
+
+const (
+	DefaultTimeout = 10 * time.Second
+	hostIdLen      = 16
+	hostIdFile     = "host-id"
+)
+
+// SummaryT is the run summary sent to the stats endpoint. It carries only
+// counters and versions; never log content, source names or hostnames.
+type SummaryT struct {
+	HostId       string    `json:"host_id"`
+	Version      string    `json:"version"`
+	RulesVersion string    `json:"rules_version,omitempty"`
+	Os           string    `json:"os"`
+	Arch         string    `json:"arch"`
+	Start        time.Time `json:"start"`
+	DurationMs   int64     `json:"duration_ms"`
+	Sources      int       `json:"sources"`
+	Rules        int       `json:"rules"`
+	Lines        int64     `json:"lines"`
+	Collapsed    int64     `json:"collapsed,omitempty"`
+	Dropped      int64     `json:"dropped,omitempty"`
+	Spilled      int64     `json:"spilled_bytes,omitempty"`
+	Detections   int       `json:"detections"`
+}
+
+// NewSummary fills in the process fields of a summary.
+func NewSummary(start time.Time) SummaryT {
+	return SummaryT{
+		HostId:  HostId(),
+		Version: verz.Semver(),
+		Os:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Start:   start.UTC(),
+	}
+}
+
+// HostId is a stable identifier for this installation so runs can be
+// grouped per host. It is random, kept in the data directory, and so says
+// nothing about the host; empty if it cannot be kept.
+func HostId() string {
+	return hostId(dirz.Data())
+}
+
+func hostId(dir string) string {
+
+	path := filepath.Join(dir, hostIdFile)
+	if id, ok := readHostId(path); ok {
+		return id
+	}
+
+	buf := make([]byte, hostIdLen/2)
+	rand.Read(buf)
+	id := hex.EncodeToString(buf)
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return ""
+	}
+
+	// Runs starting together keep the id of whichever wrote it first
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	switch {
+	case errors.Is(err, fs.ErrExist):
+		if id, ok := readHostId(path); ok {
+			return id
+		}
+		return ""
+	case err != nil:
+		return ""
+	}
+	defer fh.Close()
+
+	if _, err := fh.WriteString(id + "\n"); err != nil {
+		return ""
+	}
+
+	return id
+}
+
+func readHostId(path string) (string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	id := strings.TrimSpace(string(data))
+	return id, len(id) == hostIdLen
+}
+
+type optsT struct {
+	token   string
+	timeout time.Duration
+}
+
+type OptT func(*optsT)
+
+// WithToken sends token as a bearer token.
+func WithToken(token string) OptT {
+	return func(o *optsT) {
+		o.token = token
+	}
+}
+
+func WithTimeout(timeout time.Duration) OptT {
+	return func(o *optsT) {
+		o.timeout = timeout
+	}
+}
+
+// Push POSTs the summary as JSON to endpoint.
+func Push(ctx context.Context, endpoint string, s SummaryT, opts ...OptT) error {
+
+	o := optsT{timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if o.token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.token)
+	}
+
+	client := httpz.New(httpz.WithName("stats"), httpz.WithTimeout(o.timeout))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("stats push failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
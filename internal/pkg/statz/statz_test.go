@@ -0,0 +1,76 @@
+package statz
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestHostId(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+
+	id := hostId(dir)
+	if len(id) != hostIdLen {
+		t.Fatalf("Unexpected host id: %q", id)
+	}
+	if again := hostId(dir); again != id {
+		t.Errorf("Expected the kept id %s, got %s", id, again)
+	}
+	if other := hostId(t.TempDir()); other == id {
+		t.Errorf("Expected another installation to get its own id, got %s", other)
+	}
+
+	if fi, err := os.Stat(filepath.Join(dir, hostIdFile)); err != nil || (runtime.GOOS != "windows" && fi.Mode().Perm() != 0600) {
+		t.Errorf("Expected a private id file, got %v, %v", fi, err)
+	}
+}
+
+func TestPush(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Unexpected request: %s %v", r.Method, r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode summary: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	s := NewSummary(time.Now())
+	s.Lines = 42
+	s.Detections = 2
+
+	if err := Push(context.Background(), srv.URL, s, WithToken("token")); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	if got["lines"] != float64(42) || got["detections"] != float64(2) || got["host_id"] != HostId() {
+		t.Errorf("Unexpected summary: %v", got)
+	}
+	if len(HostId()) != hostIdLen {
+		t.Errorf("Unexpected host id: %q", HostId())
+	}
+}
+
+func TestPushError(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	if err := Push(context.Background(), srv.URL, NewSummary(time.Now())); err == nil {
+		t.Fatal("Expected error for a rejected push")
+	}
+}
@@ -0,0 +1,227 @@
+package suppress
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+# .preqignore
+- id: CRE-2025-0025               # path.Match globs
+  reason: Known and accepted, see OPS-1234
+- id: "CRE-2024-00*"
+  source: /var/log/legacy/*.log   # the source the hit was read from
+  expires: 2025-12-31             # flags again from the day after
+  reason: Legacy service is being retired
+- match: 'GET /healthz'           # regex on the hit lines
+  reason: Health checks time out during deploys
+*/
+
+const (
+	DefaultFile = ".preqignore"
+	dateFormat  = time.DateOnly
+)
+
+var (
+	ErrEmptyRule = errors.New("suppression matches nothing")
+	ErrNoReason  = errors.New("suppression has no reason")
+	ErrExpires   = errors.New("invalid expiry date, want YYYY-MM-DD")
+)
+
+type RuleT struct {
+	Id      string `yaml:"id,omitempty"`
+	Source  string `yaml:"source,omitempty"`
+	Match   string `yaml:"match,omitempty"`
+	Expires string `yaml:"expires,omitempty"`
+	Reason  string `yaml:"reason"`
+
+	match   *regexp.Regexp
+	expires time.Time // end of the expiry day; zero if none
+}
+
+// ListT holds the suppressions of an ignore file and the configuration. A
+// nil *ListT suppresses nothing.
+type ListT struct {
+	rules []RuleT
+}
+
+// New validates rules.
+func New(rules []RuleT) (*ListT, error) {
+
+	l := &ListT{rules: slices.Clone(rules)}
+
+	for i := range l.rules {
+		r := &l.rules[i]
+
+		if r.Id == "" && r.Source == "" && r.Match == "" {
+			return nil, fmt.Errorf("suppression #%d: %w", i, ErrEmptyRule)
+		}
+		if r.Reason == "" {
+			return nil, fmt.Errorf("suppression #%d: %w", i, ErrNoReason)
+		}
+
+		for _, pattern := range []string{r.Id, r.Source} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("suppression #%d: invalid pattern %q: %w", i, pattern, err)
+			}
+		}
+
+		if r.Match != "" {
+			exp, err := regexp.Compile(r.Match)
+			if err != nil {
+				return nil, fmt.Errorf("suppression #%d: %w", i, err)
+			}
+			r.match = exp
+		}
+
+		if r.Expires != "" {
+			day, err := time.ParseInLocation(dateFormat, r.Expires, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("suppression #%d: %w: %q", i, ErrExpires, r.Expires)
+			}
+			r.expires = day.AddDate(0, 0, 1)
+		}
+	}
+
+	return l, nil
+}
+
+func Parse(data []byte) (*ListT, error) {
+	var rules []RuleT
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	return New(rules)
+}
+
+// Load reads the ignore file fn. A missing file suppresses nothing.
+func Load(fn string) (*ListT, error) {
+	data, err := os.ReadFile(fn)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	log.Info().Str("file", fn).Msg("Loading suppressions")
+	return Parse(data)
+}
+
+// KnownT is a CRE detected in a source, e.g. by an earlier run. An empty
+// Source stands for any.
+type KnownT struct {
+	Id     string
+	Source string
+}
+
+// Known suppresses the CREs of known in their sources, for reason.
+func Known(known []KnownT, reason string) *ListT {
+	l := &ListT{rules: make([]RuleT, 0, len(known))}
+	for _, k := range known {
+		l.rules = append(l.rules, RuleT{Id: escape(k.Id), Source: escape(k.Source), Reason: reason})
+	}
+	return l
+}
+
+// escape quotes the pattern characters in an id.
+func escape(id string) string {
+	var sb strings.Builder
+	for _, c := range id {
+		if strings.ContainsRune(`*?[\`, c) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// Merge returns the suppressions of both lists.
+func (l *ListT) Merge(o *ListT) *ListT {
+	switch {
+	case l == nil:
+		return o
+	case o == nil:
+		return l
+	}
+	return &ListT{rules: slices.Concat(l.rules, o.rules)}
+}
+
+func (l *ListT) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.rules)
+}
+
+// Expired returns the suppressions that have expired by now, so that they
+// can be pointed out before they flag again.
+func (l *ListT) Expired(now time.Time) []RuleT {
+	if l == nil {
+		return nil
+	}
+
+	var out []RuleT
+	for _, r := range l.rules {
+		if r.expired(now) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Match returns the first suppression in force at now that covers a hit of
+// cre.
+func (l *ListT) Match(cre *parser.ParseCreT, m matchz.HitsT, now time.Time) (RuleT, bool) {
+	if l == nil {
+		return RuleT{}, false
+	}
+
+	for _, r := range l.rules {
+		if !r.expired(now) && r.matches(cre, m) {
+			return r, true
+		}
+	}
+
+	return RuleT{}, false
+}
+
+func (r *RuleT) expired(now time.Time) bool {
+	return !r.expires.IsZero() && !now.Before(r.expires)
+}
+
+// matches reports whether every criterion set on the rule holds for the
+// hit.
+func (r *RuleT) matches(cre *parser.ParseCreT, m matchz.HitsT) bool {
+
+	if r.Id != "" {
+		if ok, _ := path.Match(r.Id, cre.Id); !ok {
+			return false
+		}
+	}
+
+	if r.Source != "" {
+		if ok, _ := path.Match(r.Source, m.Entity.FileName); !ok {
+			return false
+		}
+	}
+
+	if r.match != nil && !slices.ContainsFunc(m.Entries, func(e matchz.EntryT) bool {
+		return r.match.Match(e.Entry)
+	}) {
+		return false
+	}
+
+	return true
+}
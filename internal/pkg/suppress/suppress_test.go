@@ -0,0 +1,130 @@
+package suppress
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+)
+
+const testIgnore = `
+- id: CRE-2025-0025
+  reason: accepted
+- id: "CRE-2024-00*"
+  source: /var/log/legacy/*.log
+  expires: 2025-06-30
+  reason: legacy service retiring
+- match: 'GET /healthz'
+  reason: health checks
+`
+
+func TestMatch(t *testing.T) {
+	l, err := Parse([]byte(testIgnore))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	var (
+		before = time.Date(2025, 6, 30, 23, 0, 0, 0, time.Local)
+		after  = time.Date(2025, 7, 1, 0, 0, 0, 0, time.Local)
+	)
+
+	hit := func(src, line string) matchz.HitsT {
+		return matchz.HitsT{
+			Entries: []matchz.EntryT{{Entry: []byte(line)}},
+			Entity:  matchz.EntityMetadataT{FileName: src},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		hit    matchz.HitsT
+		now    time.Time
+		reason string
+	}{
+		{name: "id", id: "CRE-2025-0025", hit: hit("app", "boom"), now: after, reason: "accepted"},
+		{name: "glob and source", id: "CRE-2024-0007", hit: hit("/var/log/legacy/a.log", "boom"), now: before, reason: "legacy service retiring"},
+		{name: "other source", id: "CRE-2024-0007", hit: hit("/var/log/app.log", "boom"), now: before},
+		{name: "expired", id: "CRE-2024-0007", hit: hit("/var/log/legacy/a.log", "boom"), now: after},
+		{name: "pattern", id: "CRE-2025-0001", hit: hit("app", "GET /healthz 504"), now: after, reason: "health checks"},
+		{name: "none", id: "CRE-2025-0001", hit: hit("app", "boom"), now: after},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, ok := l.Match(&parser.ParseCreT{Id: tc.id}, tc.hit, tc.now)
+			if ok != (tc.reason != "") || r.Reason != tc.reason {
+				t.Errorf("Expected reason %q, got %q (%v)", tc.reason, r.Reason, ok)
+			}
+		})
+	}
+
+	if expired := l.Expired(after); len(expired) != 1 || expired[0].Id != "CRE-2024-00*" {
+		t.Errorf("Expected one expired suppression, got %v", expired)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]struct {
+		doc  string
+		want error
+	}{
+		"empty":      {doc: "- reason: why\n", want: ErrEmptyRule},
+		"no reason":  {doc: "- id: CRE-2025-0025\n", want: ErrNoReason},
+		"bad expiry": {doc: "- id: CRE-2025-0025\n  reason: why\n  expires: next week\n", want: ErrExpires},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(tc.doc)); !errors.Is(err, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestKnown(t *testing.T) {
+	l := Known([]KnownT{
+		{Id: "CRE-2025-0001"},
+		{Id: "custom-[a]"},
+		{Id: "CRE-2025-0003", Source: "/var/log/app-[1].log"},
+	}, "in baseline")
+
+	for _, tc := range []struct {
+		id, source string
+		want       bool
+	}{
+		{"CRE-2025-0001", "/var/log/any.log", true},
+		{"custom-[a]", "", true},
+		{"custom-a", "", false},
+		{"CRE-2025-0002", "", false},
+		{"CRE-2025-0003", "/var/log/app-[1].log", true},
+		{"CRE-2025-0003", "/var/log/app-1.log", false},
+	} {
+		m := matchz.HitsT{Entity: matchz.EntityMetadataT{FileName: tc.source}}
+		r, ok := l.Match(&parser.ParseCreT{Id: tc.id}, m, time.Now())
+		if ok != tc.want || (ok && r.Reason != "in baseline") {
+			t.Errorf("%s in %q: expected %v, got %v %q", tc.id, tc.source, tc.want, ok, r.Reason)
+		}
+	}
+}
+
+func TestNilList(t *testing.T) {
+	var l *ListT
+
+	if _, ok := l.Match(&parser.ParseCreT{Id: "CRE-2025-0025"}, matchz.HitsT{}, time.Now()); ok {
+		t.Errorf("Expected nothing suppressed")
+	}
+
+	if l.Merge(nil).Len() != 0 {
+		t.Errorf("Expected an empty list")
+	}
+
+	missing, err := Load(t.TempDir() + "/" + DefaultFile)
+	if err != nil || missing.Len() != 0 {
+		t.Errorf("Expected a missing file to suppress nothing: %v", err)
+	}
+}
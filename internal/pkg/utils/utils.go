@@ -3,7 +3,9 @@ package utils
 import (
 	"bufio"
 	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -14,8 +16,11 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 
 	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"github.com/ulikunitz/xz"
 
 	"gopkg.in/yaml.v3"
 	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
@@ -31,6 +36,65 @@ var (
 	sectionRules = "rules"
 )
 
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicBzip2 = []byte("BZh")
+	magicXz    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// MarkerSep delimits the markers that stand for cel, plugin and rate
+// conditions.
+const MarkerSep = "\x1f"
+
+// MarkerNonce is drawn once per run and written into every marker, so that
+// a marker cannot be worked out from the rule text.
+var MarkerNonce = rand.Text()
+
+const (
+	CompressNone  = ""
+	CompressGzip  = "gzip"
+	CompressBzip2 = "bzip2"
+	CompressXz    = "xz"
+)
+
+// CompressionOf returns the compression implied by a file name suffix.
+func CompressionOf(fn string) string {
+	switch strings.ToLower(filepath.Ext(fn)) {
+	case ".gz", ".gzip":
+		return CompressGzip
+	case ".bz2", ".bzip2":
+		return CompressBzip2
+	case ".xz":
+		return CompressXz
+	}
+	return CompressNone
+}
+
+// Decompress wraps rd in a reader for the given compression.
+func Decompress(compression string, rd io.Reader) (io.Reader, error) {
+	switch compression {
+	case CompressGzip:
+		return gzip.NewReader(rd)
+	case CompressBzip2:
+		return bzip2.NewReader(rd), nil
+	case CompressXz:
+		return xz.NewReader(rd)
+	}
+	return rd, nil
+}
+
+func sniffCompression(buf []byte) string {
+	switch {
+	case bytes.HasPrefix(buf, magicGzip):
+		return CompressGzip
+	case bytes.HasPrefix(buf, magicBzip2):
+		return CompressBzip2
+	case bytes.HasPrefix(buf, magicXz):
+		return CompressXz
+	}
+	return CompressNone
+}
+
 type RuleTypeT string
 
 const (
@@ -38,9 +102,14 @@ const (
 	RuleTypeUser RuleTypeT = "user"
 )
 
+// RulePathT is a rules file and how to read it. Rules of a named registry
+// give way to those of registries of higher priority that define the same
+// CRE; rules outside any registry may not be defined twice.
 type RulePathT struct {
-	Path string
-	Type RuleTypeT
+	Path     string
+	Type     RuleTypeT
+	Registry string
+	Priority int
 }
 
 func GetStopTime() (ts int64) {
@@ -55,7 +124,8 @@ func OpenRulesFile(filePath string) (io.Reader, func(), error) {
 
 	var (
 		file *os.File
-		buf  [2]byte
+		buf  [6]byte
+		n    int
 		err  error
 	)
 
@@ -65,7 +135,7 @@ func OpenRulesFile(filePath string) (io.Reader, func(), error) {
 
 	cleanup := func() { file.Close() }
 
-	if _, err = file.Read(buf[:]); err != nil {
+	if n, err = file.Read(buf[:]); err != nil {
 		file.Close()
 		return nil, nil, err
 	}
@@ -75,7 +145,9 @@ func OpenRulesFile(filePath string) (io.Reader, func(), error) {
 		return nil, nil, err
 	}
 
-	if buf[0] == 0x1f && buf[1] == 0x8b {
+	switch compression := sniffCompression(buf[:n]); compression {
+	case CompressNone:
+	case CompressGzip:
 		gzReader, err := gzip.NewReader(file)
 		if err != nil {
 			file.Close()
@@ -86,6 +158,13 @@ func OpenRulesFile(filePath string) (io.Reader, func(), error) {
 			file.Close()
 		}
 		return gzReader, cleanup, nil
+	default:
+		rd, err := Decompress(compression, file)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return rd, cleanup, nil
 	}
 
 	return file, cleanup, nil
@@ -131,9 +210,91 @@ func WithGenIds() func(*readerOptsT) {
 	}
 }
 
+// WithRewrite transforms the rules document before it is parsed. Rewrites
+// are applied in the order given.
+func WithRewrite(f func([]byte) ([]byte, error)) func(*readerOptsT) {
+	return func(o *readerOptsT) {
+		o.rewrite = append(o.rewrite, f)
+	}
+}
+
+// WithFilter drops the rules for which keep returns false once the
+// document is read. A rule is kept only if every filter keeps it.
+func WithFilter(keep func(parser.ParseRuleT) bool) func(*readerOptsT) {
+	return func(o *readerOptsT) {
+		if prev := o.keep; prev != nil {
+			o.keep = func(rule parser.ParseRuleT) bool {
+				return prev(rule) && keep(rule)
+			}
+			return
+		}
+		o.keep = keep
+	}
+}
+
+// CleanLine removes the marker delimiter from a line read from a source, so
+// that the line cannot carry markers of its own.
+func CleanLine(line string) string {
+	if !strings.Contains(line, MarkerSep) {
+		return line
+	}
+	return strings.ReplaceAll(line, MarkerSep, "")
+}
+
+// RewriteDocs calls f on each document of data, a YAML stream, and encodes
+// them again if f changed any. Data that does not parse is returned as is,
+// for the rules parser to report. It suits rewrites passed to WithRewrite.
+func RewriteDocs(data []byte, f func(doc *yaml.Node) (changed bool, err error)) ([]byte, error) {
+
+	var (
+		docs    []*yaml.Node
+		changed bool
+		dec     = yaml.NewDecoder(bytes.NewReader(data))
+	)
+
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return data, nil
+		}
+
+		c, err := f(&doc)
+		if err != nil {
+			return nil, err
+		}
+		changed = changed || c
+		docs = append(docs, &doc)
+	}
+
+	if !changed {
+		return data, nil
+	}
+
+	var (
+		buf bytes.Buffer
+		enc = yaml.NewEncoder(&buf)
+	)
+
+	enc.SetIndent(2)
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
 type readerOptsT struct {
 	multiDoc bool
 	genIds   bool
+	rewrite  []func([]byte) ([]byte, error)
+	keep     func(parser.ParseRuleT) bool
 }
 
 func readerOpts(opts ...ReaderOptT) *readerOptsT {
@@ -165,24 +326,67 @@ func ParseRulesPath(path string, opts ...ReaderOptT) (*parser.RulesT, error) {
 		if rulesBytes, err = ExtractSectionBytes(reader, sectionRules); err != nil {
 			return nil, err
 		}
-		return parser.Read(bytes.NewReader(rulesBytes))
+		if reader, err = o.rewritten(bytes.NewReader(rulesBytes)); err != nil {
+			return nil, err
+		}
+		return o.filtered(parser.Read(reader))
 	}
 
 	if o.genIds {
 		readOpts = append(readOpts, parser.WithGenIds())
 	}
 
-	return parser.Read(reader, readOpts...)
+	if reader, err = o.rewritten(reader); err != nil {
+		return nil, err
+	}
+
+	return o.filtered(parser.Read(reader, readOpts...))
 }
 
 func ParseRules(rdr io.Reader, opts ...ReaderOptT) (*parser.RulesT, error) {
 	o := readerOpts(opts...)
 
+	rdr, err := o.rewritten(rdr)
+	if err != nil {
+		return nil, err
+	}
+
 	if o.genIds {
-		return parser.Read(rdr, parser.WithGenIds())
+		return o.filtered(parser.Read(rdr, parser.WithGenIds()))
+	}
+
+	return o.filtered(parser.Read(rdr))
+}
+
+func (o *readerOptsT) filtered(rs *parser.RulesT, err error) (*parser.RulesT, error) {
+	if err != nil || o.keep == nil {
+		return rs, err
+	}
+
+	rs.Rules = slices.DeleteFunc(rs.Rules, func(rule parser.ParseRuleT) bool {
+		return !o.keep(rule)
+	})
+
+	return rs, nil
+}
+
+func (o *readerOptsT) rewritten(rdr io.Reader) (io.Reader, error) {
+	if len(o.rewrite) == 0 {
+		return rdr, nil
+	}
+
+	data, err := io.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rewrite := range o.rewrite {
+		if data, err = rewrite(data); err != nil {
+			return nil, err
+		}
 	}
 
-	return parser.Read(rdr)
+	return bytes.NewReader(data), nil
 }
 
 func GunzipBytes(path string) ([]byte, error) {
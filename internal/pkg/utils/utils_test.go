@@ -11,6 +11,8 @@ import (
 	"testing"
 
 	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/ulikunitz/xz"
+	"gopkg.in/yaml.v3"
 )
 
 func TestSha256Sum(t *testing.T) {
@@ -81,6 +83,38 @@ func TestCopyFileAndOpenRulesFile(t *testing.T) {
 	if string(data) != "gzipped" {
 		t.Fatalf("expected gzipped content")
 	}
+
+	// xz file, detected by magic rather than suffix
+	xzPath := dstPath + ".rules"
+	f, _ = os.Create(xzPath)
+	xw, _ := xz.NewWriter(f)
+	xw.Write([]byte("xz"))
+	xw.Close()
+	f.Close()
+
+	r, cleanup, err = utils.OpenRulesFile(xzPath)
+	if err != nil {
+		t.Fatalf("open xz failed: %v", err)
+	}
+	data, _ = io.ReadAll(r)
+	cleanup()
+	if string(data) != "xz" {
+		t.Fatalf("expected xz content, got %q", data)
+	}
+}
+
+func TestCompressionOf(t *testing.T) {
+	for fn, want := range map[string]string{
+		"app.log":       utils.CompressNone,
+		"app.log.gz":    utils.CompressGzip,
+		"app.log.GZIP":  utils.CompressGzip,
+		"app.log.1.bz2": utils.CompressBzip2,
+		"app.log.xz":    utils.CompressXz,
+	} {
+		if got := utils.CompressionOf(fn); got != want {
+			t.Errorf("%s: expected %q, got %q", fn, want, got)
+		}
+	}
 }
 
 func TestGunzipBytes(t *testing.T) {
@@ -122,6 +156,36 @@ func TestExtractSectionBytesNotFound(t *testing.T) {
 	}
 }
 
+func TestRewriteDocs(t *testing.T) {
+	data := []byte("a: 1\n---\nb: 2\n")
+
+	upper := func(doc *yaml.Node) (bool, error) {
+		m := doc.Content[0]
+		if m.Content[0].Value != "b" {
+			return false, nil
+		}
+		m.Content[0].Value = "B"
+		return true, nil
+	}
+
+	out, err := utils.RewriteDocs(data, upper)
+	if err != nil || string(out) != "a: 1\n---\nB: 2\n" {
+		t.Fatalf("unexpected rewrite %q (%v)", out, err)
+	}
+
+	// Unchanged and unparsable documents are returned as they are
+	for _, in := range []string{"a: 1\n", "a: [\n"} {
+		out, err := utils.RewriteDocs([]byte(in), upper)
+		if err != nil || string(out) != in {
+			t.Errorf("expected %q as is, got %q (%v)", in, out, err)
+		}
+	}
+
+	if _, err := utils.RewriteDocs(data, func(*yaml.Node) (bool, error) { return false, io.ErrUnexpectedEOF }); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected the error of f, got %v", err)
+	}
+}
+
 func TestGetOSInfoAndStopTime(t *testing.T) {
 	info := utils.GetOSInfo()
 	if !strings.Contains(info, runtime.GOOS) {
@@ -0,0 +1,227 @@
+package ux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+const (
+	ShellBash       = "bash"
+	ShellZsh        = "zsh"
+	ShellFish       = "fish"
+	ShellPowershell = "powershell"
+)
+
+var (
+	ErrUnknownShell = errors.New("cannot detect shell; use --shell")
+)
+
+// Completion scripts call back into the binary, which answers through
+// kongplete when COMP_LINE is set.
+var completionScripts = map[string]string{
+	ShellBash: `complete -o default -C %[1]q %[2]s
+`,
+	ShellZsh: `autoload -U +X bashcompinit && bashcompinit
+complete -o default -C %[1]q %[2]s
+`,
+	ShellFish: `complete -c %[2]s -f -a '(env COMP_LINE=(commandline -cp) %[1]q)'
+`,
+	ShellPowershell: `Register-ArgumentCompleter -Native -CommandName %[2]s -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $env:COMP_LINE = $commandAst.ToString()
+    $env:COMP_POINT = $cursorPosition
+    & '%[1]s' | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+    Remove-Item Env:\COMP_LINE, Env:\COMP_POINT
+}
+`,
+}
+
+// completionT describes where a shell's completion script goes. If rc is
+// set, a line sourcing the script is appended to it once.
+type completionT struct {
+	shell  string
+	bin    string
+	name   string
+	script string
+	rc     string
+	source string
+}
+
+// DetectShell guesses the user's shell from the environment.
+func DetectShell(getenv func(string) string) (string, error) {
+	if runtime.GOOS == "windows" {
+		return ShellPowershell, nil
+	}
+
+	sh := strings.TrimSuffix(filepath.Base(getenv("SHELL")), ".exe")
+	switch sh {
+	case ShellBash, ShellZsh, ShellFish:
+		return sh, nil
+	case "pwsh":
+		return ShellPowershell, nil
+	}
+
+	return "", ErrUnknownShell
+}
+
+func newCompletion(shell, bin, name, home string, getenv func(string) string) (*completionT, error) {
+
+	orDefault := func(key, def string) string {
+		if v := getenv(key); v != "" {
+			return v
+		}
+		return def
+	}
+
+	c := &completionT{shell: shell, bin: bin, name: name}
+
+	switch shell {
+	case ShellBash:
+		// Loaded on demand by bash-completion
+		dataHome := orDefault("XDG_DATA_HOME", filepath.Join(home, ".local", "share"))
+		c.script = filepath.Join(dataHome, "bash-completion", "completions", name)
+
+	case ShellZsh:
+		zdot := orDefault("ZDOTDIR", home)
+		c.script = filepath.Join(zdot, ".zsh", name+"-completion.zsh")
+		c.rc = filepath.Join(zdot, ".zshrc")
+		c.source = fmt.Sprintf("source %q", c.script)
+
+	case ShellFish:
+		// Loaded on demand by fish
+		configHome := orDefault("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+		c.script = filepath.Join(configHome, "fish", "completions", name+".fish")
+
+	case ShellPowershell:
+		dir := filepath.Join(home, ".config", "powershell")
+		if runtime.GOOS == "windows" {
+			dir = filepath.Join(home, "Documents", "PowerShell")
+		}
+		c.script = filepath.Join(dir, name+"-completion.ps1")
+		c.rc = filepath.Join(dir, "Microsoft.PowerShell_profile.ps1")
+		c.source = fmt.Sprintf(". '%s'", c.script)
+
+	default:
+		return nil, fmt.Errorf("unsupported shell %q", shell)
+	}
+
+	return c, nil
+}
+
+func (c *completionT) content() string {
+	return fmt.Sprintf(completionScripts[c.shell], c.bin, c.name)
+}
+
+func (c *completionT) install() error {
+
+	if err := os.MkdirAll(filepath.Dir(c.script), 0755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(c.script, []byte(c.content()), 0644); err != nil {
+		return err
+	}
+
+	if c.rc == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.rc)
+	switch {
+	case err == nil:
+		if slices.Contains(strings.Split(string(data), "\n"), c.source) {
+			return nil
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	fh, err := os.OpenFile(c.rc, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(fh, "\n# %s shell completion\n%s\n", c.name, c.source)
+	return errors.Join(err, fh.Close())
+}
+
+// pathHint returns a command that adds dir to PATH, or "" if the binary
+// is already found on PATH.
+func (c *completionT) pathHint(lookPath func(string) (string, error)) string {
+
+	if found, err := lookPath(c.name); err == nil {
+		if resolved, err := filepath.EvalSymlinks(found); err == nil && resolved == c.bin {
+			return ""
+		}
+	}
+
+	dir := filepath.Dir(c.bin)
+	switch c.shell {
+	case ShellFish:
+		return fmt.Sprintf("fish_add_path %q", dir)
+	case ShellPowershell:
+		return fmt.Sprintf("$env:Path += \"%c%s\"", os.PathListSeparator, dir)
+	}
+	return fmt.Sprintf("export PATH=\"$PATH%c%s\"", os.PathListSeparator, dir)
+}
+
+// InstallCompletions writes the completion script for shell (detected when
+// empty) and reports whether the binary is on PATH. With printOnly the
+// script is written to w instead.
+func InstallCompletions(w io.Writer, shell string, printOnly bool) error {
+
+	var err error
+	if shell == "" {
+		if shell, err = DetectShell(os.Getenv); err != nil {
+			return err
+		}
+	}
+
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if bin, err = filepath.EvalSymlinks(bin); err != nil {
+		return err
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	c, err := newCompletion(shell, bin, ProcessName(), home, os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	if printOnly {
+		_, err = io.WriteString(w, c.content())
+		return err
+	}
+
+	if err = c.install(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Installed %s completions to %s\n", shell, c.script)
+	if c.rc != "" {
+		fmt.Fprintf(w, "Loaded from %s\n", c.rc)
+	}
+
+	if hint := c.pathHint(exec.LookPath); hint != "" {
+		fmt.Fprintf(w, "\n%s is not on your PATH. Add it with:\n\n  %s\n", c.name, hint)
+	}
+
+	fmt.Fprintf(w, "\nRestart your shell to enable completions.\n")
+	return nil
+}
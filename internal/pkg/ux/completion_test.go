@@ -0,0 +1,103 @@
+package ux
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDetectShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("always powershell on windows")
+	}
+
+	for shell, want := range map[string]string{
+		"/bin/bash":           ShellBash,
+		"/usr/local/bin/zsh":  ShellZsh,
+		"/usr/bin/fish":       ShellFish,
+		"/opt/microsoft/pwsh": ShellPowershell,
+	} {
+		got, err := DetectShell(func(string) string { return shell })
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, %v; want %q", shell, got, err, want)
+		}
+	}
+
+	if _, err := DetectShell(func(string) string { return "/bin/tcsh" }); !errors.Is(err, ErrUnknownShell) {
+		t.Errorf("Expected ErrUnknownShell, got %v", err)
+	}
+}
+
+func TestInstallCompletion(t *testing.T) {
+	var (
+		home   = t.TempDir()
+		getenv = func(string) string { return "" }
+	)
+
+	c, err := newCompletion(ShellZsh, "/opt/preq/bin/preq", "preq", home, getenv)
+	if err != nil {
+		t.Fatalf("newCompletion: %v", err)
+	}
+
+	// Installing twice must not source the script twice
+	for range 2 {
+		if err := c.install(); err != nil {
+			t.Fatalf("install: %v", err)
+		}
+	}
+
+	script, err := os.ReadFile(filepath.Join(home, ".zsh", "preq-completion.zsh"))
+	if err != nil {
+		t.Fatalf("Failed to read script: %v", err)
+	}
+	if !strings.Contains(string(script), `complete -o default -C "/opt/preq/bin/preq" preq`) {
+		t.Errorf("Unexpected script: %s", script)
+	}
+
+	rc, err := os.ReadFile(filepath.Join(home, ".zshrc"))
+	if err != nil {
+		t.Fatalf("Failed to read rc: %v", err)
+	}
+	if n := strings.Count(string(rc), "source "); n != 1 {
+		t.Errorf("Expected one source line, got %d:\n%s", n, rc)
+	}
+
+	c, err = newCompletion(ShellFish, "/opt/preq/bin/preq", "preq", home, func(key string) string {
+		if key == "XDG_CONFIG_HOME" {
+			return "/xdg"
+		}
+		return ""
+	})
+	if err != nil {
+		t.Fatalf("newCompletion: %v", err)
+	}
+	if c.script != filepath.Join("/xdg", "fish", "completions", "preq.fish") || c.rc != "" {
+		t.Errorf("Unexpected fish location: %+v", c)
+	}
+
+	if _, err := newCompletion("csh", "/opt/preq/bin/preq", "preq", home, getenv); err == nil {
+		t.Error("Expected error for unsupported shell")
+	}
+}
+
+func TestPathHint(t *testing.T) {
+	c := &completionT{shell: ShellBash, bin: "/opt/preq/bin/preq", name: "preq"}
+
+	missing := func(string) (string, error) { return "", errors.New("not found") }
+	if hint := c.pathHint(missing); !strings.Contains(hint, "/opt/preq/bin") {
+		t.Errorf("Expected a PATH hint, got %q", hint)
+	}
+
+	bin := filepath.Join(t.TempDir(), "preq")
+	if err := os.WriteFile(bin, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	bin, _ = filepath.EvalSymlinks(bin)
+	c.bin = bin
+	if hint := c.pathHint(func(string) (string, error) { return bin, nil }); hint != "" {
+		t.Errorf("Expected no hint when on PATH, got %q", hint)
+	}
+}
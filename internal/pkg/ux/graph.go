@@ -0,0 +1,200 @@
+package ux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	GraphMermaid = "mermaid"
+	GraphDot     = "dot"
+)
+
+var (
+	ErrGraphFormat = errors.New("unsupported graph format")
+)
+
+type graphNodeT struct {
+	id    string
+	label string
+}
+
+type graphEdgeT struct {
+	from, to string
+	label    string
+	corr     bool // time proximity rather than membership
+}
+
+type graphT struct {
+	detections []graphNodeT
+	sources    []graphNodeT
+	edges      []graphEdgeT
+}
+
+// detectionSpan is the time range covered by a detection's hits.
+type detectionSpan struct {
+	start, end time.Time
+}
+
+func spanOf(e reportEntryT) detectionSpan {
+	var s detectionSpan
+	for _, h := range e.Hits {
+		if s.start.IsZero() || h.Timestamp.Before(s.start) {
+			s.start = h.Timestamp
+		}
+		if h.Timestamp.After(s.end) {
+			s.end = h.Timestamp
+		}
+	}
+	if s.start.IsZero() {
+		if ts, err := time.Parse(time.RFC3339Nano, e.Timestamp); err == nil {
+			s.start, s.end = ts, ts
+		}
+	}
+	return s
+}
+
+// gap is zero when the spans overlap.
+func (s detectionSpan) gap(o detectionSpan) time.Duration {
+	switch {
+	case s.end.Before(o.start):
+		return o.start.Sub(s.end)
+	case o.end.Before(s.start):
+		return s.start.Sub(o.end)
+	}
+	return 0
+}
+
+// buildGraph links each detection to the sources it was seen in, and pairs
+// of detections whose hits fall within window of each other.
+func buildGraph(entries []reportEntryT, window time.Duration) *graphT {
+
+	slices.SortFunc(entries, func(a, b reportEntryT) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+
+	var (
+		g       = &graphT{}
+		srcIds  = make(map[string]string)
+		spans   = make([]detectionSpan, len(entries))
+		nodeIds = make([]string, len(entries))
+	)
+
+	for i, e := range entries {
+		nodeIds[i] = fmt.Sprintf("d%d", i)
+		spans[i] = spanOf(e)
+
+		label := e.Id
+		if sev, err := getSeverity(e.Cre.Severity); err == nil {
+			label += " (" + sev.severity + ")"
+		}
+		if e.Cre.Title != "" {
+			label += "\n" + e.Cre.Title
+		}
+		g.detections = append(g.detections, graphNodeT{id: nodeIds[i], label: label})
+
+		for _, src := range e.Sources {
+			sid, ok := srcIds[src]
+			if !ok {
+				sid = fmt.Sprintf("s%d", len(srcIds))
+				srcIds[src] = sid
+				g.sources = append(g.sources, graphNodeT{id: sid, label: src})
+			}
+			g.edges = append(g.edges, graphEdgeT{from: nodeIds[i], to: sid})
+		}
+	}
+
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			if spans[i].start.IsZero() || spans[j].start.IsZero() {
+				continue
+			}
+			gap := spans[i].gap(spans[j])
+			if gap > window {
+				continue
+			}
+			label := "overlap"
+			if gap > 0 {
+				label = gap.String()
+			}
+			g.edges = append(g.edges, graphEdgeT{from: nodeIds[i], to: nodeIds[j], label: label, corr: true})
+		}
+	}
+
+	return g
+}
+
+func (g *graphT) mermaid(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("graph LR\n")
+	for _, n := range g.detections {
+		fmt.Fprintf(&b, "  %s[\"%s\"]\n", n.id, mermaidEscape(n.label))
+	}
+	for _, n := range g.sources {
+		fmt.Fprintf(&b, "  %s[(\"%s\")]\n", n.id, mermaidEscape(n.label))
+	}
+	for _, e := range g.edges {
+		if e.corr {
+			fmt.Fprintf(&b, "  %s -. \"%s\" .- %s\n", e.from, e.label, e.to)
+		} else {
+			fmt.Fprintf(&b, "  %s --- %s\n", e.from, e.to)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func mermaidEscape(s string) string {
+	s = strings.ReplaceAll(s, `"`, "#quot;")
+	return strings.ReplaceAll(s, "\n", "<br/>")
+}
+
+func (g *graphT) dot(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("graph preq {\n  rankdir=LR;\n")
+	for _, n := range g.detections {
+		fmt.Fprintf(&b, "  %s [shape=box, label=%q];\n", n.id, n.label)
+	}
+	for _, n := range g.sources {
+		fmt.Fprintf(&b, "  %s [shape=cylinder, label=%q];\n", n.id, n.label)
+	}
+	for _, e := range g.edges {
+		if e.corr {
+			fmt.Fprintf(&b, "  %s -- %s [style=dashed, label=%q];\n", e.from, e.to, e.label)
+		} else {
+			fmt.Fprintf(&b, "  %s -- %s;\n", e.from, e.to)
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// GraphReport renders a report written by preq as a Mermaid or DOT graph
+// of detections, their sources and same-window correlations.
+func GraphReport(w io.Writer, reportPath, format string, window time.Duration) error {
+
+	entries, err := readReportEntries(reportPath)
+	if err != nil {
+		return err
+	}
+
+	g := buildGraph(entries, window)
+
+	switch format {
+	case "", GraphMermaid:
+		return g.mermaid(w)
+	case GraphDot:
+		return g.dot(w)
+	}
+
+	return fmt.Errorf("%w: %s", ErrGraphFormat, format)
+}
@@ -0,0 +1,63 @@
+package ux
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const graphReport = `[
+  {"timestamp": "2024-01-01T00:00:00Z", "id": "CRE-1", "cre": {"id": "CRE-1", "severity": 0, "title": "Redis OOM"},
+   "hits": [{"timestamp": "2024-01-01T00:00:00Z", "entry": "a"}, {"timestamp": "2024-01-01T00:00:10Z", "entry": "b"}],
+   "sources": ["cre.log.redis"]},
+  {"timestamp": "2024-01-01T00:00:30Z", "id": "CRE-2", "cre": {"id": "CRE-2", "severity": 2},
+   "hits": [{"timestamp": "2024-01-01T00:00:30Z", "entry": "c"}],
+   "sources": ["cre.prequel.k8s", "cre.log.redis"]},
+  {"timestamp": "2024-01-01T05:00:00Z", "id": "CRE-3", "cre": {"id": "CRE-3", "severity": 3},
+   "hits": [{"timestamp": "2024-01-01T05:00:00Z", "entry": "d"}]}
+]`
+
+func TestGraphReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := os.WriteFile(path, []byte(graphReport), 0644); err != nil {
+		t.Fatalf("write report: %v", err)
+	}
+
+	var mermaid bytes.Buffer
+	if err := GraphReport(&mermaid, path, GraphMermaid, time.Minute); err != nil {
+		t.Fatalf("GraphReport mermaid: %v", err)
+	}
+
+	for _, want := range []string{
+		"graph LR",
+		`d0["CRE-1 (critical)<br/>Redis OOM"]`,
+		`s0[("cre.log.redis")]`,
+		"d1 --- s0",
+		`d0 -. "20s" .- d1`,
+	} {
+		if !strings.Contains(mermaid.String(), want) {
+			t.Errorf("mermaid output missing %q:\n%s", want, mermaid.String())
+		}
+	}
+
+	// CRE-3 is hours away from the others
+	if strings.Contains(mermaid.String(), ".- d2") {
+		t.Errorf("unexpected correlation with distant detection:\n%s", mermaid.String())
+	}
+
+	var dot bytes.Buffer
+	if err := GraphReport(&dot, path, GraphDot, time.Minute); err != nil {
+		t.Fatalf("GraphReport dot: %v", err)
+	}
+	if !strings.Contains(dot.String(), `d0 -- d1 [style=dashed, label="20s"];`) {
+		t.Errorf("dot output missing correlation edge:\n%s", dot.String())
+	}
+
+	if err := GraphReport(&dot, path, "svg", time.Minute); !errors.Is(err, ErrGraphFormat) {
+		t.Errorf("expected ErrGraphFormat, got %v", err)
+	}
+}
@@ -1,15 +1,20 @@
 package ux
 
 import (
+	"bytes"
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
-	"sort"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/prequel-dev/preq/internal/pkg/suppress"
 	"github.com/prequel-dev/prequel-compiler/pkg/parser"
 
 	"github.com/jedib0t/go-pretty/v6/progress"
@@ -33,6 +38,7 @@ const (
 	colorLow      = text.FgHiGreen
 	colorInfo     = text.FgHiBlue
 	reportFmt     = "preq-report-%d.json"
+	dedupSample   = 10 // hits kept in a deduplicated entry
 )
 
 var (
@@ -40,27 +46,193 @@ var (
 )
 
 type ReportT struct {
-	mux     sync.Mutex
-	CreHits map[string][]time.Time
-	Hits    map[string]map[time.Time]matchz.HitsT
-	Rules   map[string]parser.ParseRuleT
-	Pw      progress.Writer
+	mux      sync.Mutex
+	CreHits  map[string][]time.Time
+	Hits     map[string]map[time.Time][]matchz.HitsT
+	Rules    map[string]parser.ParseRuleT
+	Pw       progress.Writer
+	Env      map[string]string
+	Sampling *SamplingT
+	stream   bool
+	notify   func(ReportDocT)
+	observe  func(ReportDocT)
+	spill    *spillT
+	dedup    time.Duration
+	suppress *suppress.ListT
+	// cre -> hits held back by a suppression
+	suppressed map[string]*SuppressedT
+	// cre -> why the engine stopped matching its rule
+	disabled map[string]*DisabledT
+}
+
+// SuppressedT records in the report that a CRE was hit but suppressed.
+type SuppressedT struct {
+	Reason  string `json:"reason"`
+	Expires string `json:"expires,omitempty"`
+	Count   int    `json:"count"`
+
+	first   time.Time
+	sources map[string]struct{}
+}
+
+// DisabledT records in the report that the rule of a CRE was disabled
+// part way through the run, so its absence from later data proves nothing.
+type DisabledT struct {
+	Reason  string `json:"reason"`
+	Timeout string `json:"timeout,omitempty"`
+	Cost    string `json:"cost,omitempty"` // CPU time per line matched
+
+	at time.Time
+}
+
+// SamplingT records how the input was reduced, so results from a partial
+// scan are not mistaken for a full one.
+type SamplingT struct {
+	Rate              float64 `json:"rate,omitempty"`
+	MaxLinesPerSource int64   `json:"max_lines_per_source,omitempty"`
+}
+
+func (s *SamplingT) String() string {
+	var parts []string
+	if s.Rate > 0 && s.Rate < 1 {
+		parts = append(parts, fmt.Sprintf("%g%% of lines", s.Rate*100))
+	}
+	if s.MaxLinesPerSource > 0 {
+		parts = append(parts, fmt.Sprintf("at most %d lines per source", s.MaxLinesPerSource))
+	}
+	return strings.Join(parts, ", ")
 }
 
 func NewReport(pw progress.Writer) *ReportT {
 	return &ReportT{
-		CreHits: make(map[string][]time.Time),                // cre -> timestamps for each detection
-		Hits:    make(map[string]map[time.Time]matchz.HitsT), // cre -> timestamp -> matchz.HitsT
-		Rules:   make(map[string]parser.ParseRuleT),          // cre -> parser.ParseRuleT
+		CreHits: make(map[string][]time.Time),                  // cre -> timestamps for each detection
+		Hits:    make(map[string]map[time.Time][]matchz.HitsT), // cre -> timestamp -> hits, ordered by compareHits
+		Rules:   make(map[string]parser.ParseRuleT),            // cre -> parser.ParseRuleT
 		Pw:      pw,
 	}
 }
 
-func (r *ReportT) AddCreHit(cre *parser.ParseCreT, hit time.Time, m matchz.HitsT) bool {
+// SetEnvironment records platform context captured at scan time in the
+// report's metadata.
+func (r *ReportT) SetEnvironment(env map[string]string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.Env = env
+}
+
+// SetSampling notes in the report's metadata, and below the displayed
+// results, that only part of the input was scanned.
+func (r *ReportT) SetSampling(s SamplingT) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.Sampling = &s
+}
+
+// Stream displays each new detection as soon as it is added instead of
+// waiting for DisplayCREs. Used by follow mode where input is unbounded.
+func (r *ReportT) Stream() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.stream = true
+}
+
+// Notify hands every hit to cb as a one entry report and then drops it, so
+// a resident process does not grow with the detections it has seen. cb is
+// called outside the report lock.
+func (r *ReportT) Notify(cb func(ReportDocT)) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.notify = cb
+}
+
+// Observe hands every hit to cb as a one entry report as soon as it is
+// added. Unlike Notify the report keeps the hit. cb is called outside the
+// report lock and may be called from several sources at once.
+func (r *ReportT) Observe(cb func(ReportDocT)) {
 	r.mux.Lock()
 	defer r.mux.Unlock()
+	r.observe = cb
+}
 
-	var newDetection bool
+// SetDedupWindow collapses the detections of a CRE that follow one another
+// within window into a single report entry. The entry counts them, notes
+// the first and last, and keeps a sample of their hits.
+func (r *ReportT) SetDedupWindow(window time.Duration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.dedup = window
+}
+
+// SetSuppressions holds back the hits that l covers. They are not
+// detections but are noted in the report with the reason given.
+func (r *ReportT) SetSuppressions(l *suppress.ListT) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.suppress = l
+}
+
+// SetMemoryLimit caps the memory held by detection hits at limit bytes.
+// Beyond it, the hits of the least recently active detections are written
+// to a temporary file in dir, or the default temporary directory, and read
+// back when the report is built. Call Close to remove the file.
+func (r *ReportT) SetMemoryLimit(limit int64, dir string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.spill = newSpill(limit, dir)
+}
+
+// Occurrences returns the hits of each detection of id in time order.
+func (r *ReportT) Occurrences(id string) []matchz.HitsT {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	var (
+		hitAt = r.hitsOf(id)
+		out   = make([]matchz.HitsT, 0, len(r.CreHits[id]))
+	)
+
+	for _, ts := range uniqueTimes(r.CreHits[id]) {
+		out = append(out, hitAt(ts)...)
+	}
+	return out
+}
+
+// Spilled returns the bytes of hits moved to disk by the memory limit.
+func (r *ReportT) Spilled() int64 {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.spill == nil {
+		return 0
+	}
+	return r.spill.Spilled()
+}
+
+// Close removes any hits spilled to disk.
+func (r *ReportT) Close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.spill == nil {
+		return nil
+	}
+	return r.spill.Close()
+}
+
+func (r *ReportT) AddCreHit(cre *parser.ParseCreT, hit time.Time, m matchz.HitsT) bool {
+	r.mux.Lock()
+
+	if rule, ok := r.suppress.Match(cre, m, time.Now()); ok {
+		r.suppressHit(cre.Id, hit, m.Entity.FileName, rule)
+		r.mux.Unlock()
+		return false
+	}
+
+	var (
+		newDetection bool
+		doc          ReportDocT
+		observed     ReportDocT
+		notify       = r.notify
+		observe      = r.observe
+	)
 
 	if _, ok := r.CreHits[cre.Id]; !ok {
 		newDetection = true
@@ -69,14 +241,110 @@ func (r *ReportT) AddCreHit(cre *parser.ParseCreT, hit time.Time, m matchz.HitsT
 	r.CreHits[cre.Id] = append(r.CreHits[cre.Id], hit)
 
 	if _, ok := r.Hits[cre.Id]; !ok {
-		r.Hits[cre.Id] = make(map[time.Time]matchz.HitsT)
+		r.Hits[cre.Id] = make(map[time.Time][]matchz.HitsT)
+	}
+
+	// Hits of one CRE at the same time, from different sources or lines,
+	// race; keep them all in the same order whatever order they came in
+	r.Hits[cre.Id][hit] = insertHit(r.Hits[cre.Id][hit], m)
+
+	if r.spill != nil && notify == nil {
+		r.evict(cre.Id, m)
 	}
 
-	r.Hits[cre.Id][hit] = m
+	if newDetection && r.stream {
+		if rule, ok := r.Rules[cre.Id]; ok {
+			r.displayCre(rule, r.CreHits[cre.Id])
+		}
+	}
+
+	if observe != nil {
+		observed = ReportDocT{r.entry(cre.Id, []time.Time{hit})}
+	}
+
+	if notify != nil {
+		doc = ReportDocT{r.entry(cre.Id, r.CreHits[cre.Id])}
+		delete(r.CreHits, cre.Id)
+		delete(r.Hits, cre.Id)
+		if r.spill != nil {
+			r.spill.Forget(cre.Id)
+		}
+	}
+
+	r.mux.Unlock()
+
+	if observe != nil {
+		observe(observed)
+	}
+	if notify != nil {
+		notify(doc)
+	}
 
 	return newDetection
 }
 
+// suppressHit counts a hit of id in src held back by rule. Called with the
+// lock held.
+func (r *ReportT) suppressHit(id string, hit time.Time, src string, rule suppress.RuleT) {
+
+	log.Info().Str("cre", id).Str("reason", rule.Reason).Msg("Suppressed hit")
+
+	if r.suppressed == nil {
+		r.suppressed = make(map[string]*SuppressedT)
+	}
+
+	s, ok := r.suppressed[id]
+	if !ok {
+		s = &SuppressedT{Reason: rule.Reason, Expires: rule.Expires, first: hit}
+		r.suppressed[id] = s
+	}
+	if hit.Before(s.first) {
+		s.first = hit
+	}
+	if src != "" {
+		if s.sources == nil {
+			s.sources = make(map[string]struct{})
+		}
+		s.sources[src] = struct{}{}
+	}
+	s.Count++
+}
+
+// DisableRule notes that the rule of id was disabled at the time of the
+// line at.
+func (r *ReportT) DisableRule(id string, at time.Time, d DisabledT) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.disabled == nil {
+		r.disabled = make(map[string]*DisabledT)
+	}
+	if _, ok := r.disabled[id]; ok {
+		return
+	}
+	d.at = at
+	r.disabled[id] = &d
+}
+
+// evict spills detections until the hits in memory are within the limit.
+// Called with the lock held.
+func (r *ReportT) evict(id string, m matchz.HitsT) {
+	for _, victim := range r.spill.Add(id, m.Size()) {
+
+		if r.spill.Spilled() == 0 {
+			log.Warn().
+				Int64("limit", r.spill.Limit()).
+				Msg("Memory limit reached; spilling detection hits to disk")
+		}
+
+		if err := r.spill.write(victim, r.Hits[victim]); err != nil {
+			log.Error().Err(err).Str("cre", victim).Msg("Failed to spill detection hits. Keeping them in memory")
+			continue
+		}
+		delete(r.Hits, victim)
+	}
+}
+
 func (r *ReportT) AddRules(rules *parser.RulesT) {
 	r.mux.Lock()
 	defer r.mux.Unlock()
@@ -91,6 +359,17 @@ func (r *ReportT) AddRules(rules *parser.RulesT) {
 	}
 }
 
+// UpdateRules replaces the rules of the CREs in rules, as they are
+// reloaded. Rules no longer loaded are kept for the hits already reported.
+func (r *ReportT) UpdateRules(rules *parser.RulesT) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for _, rule := range rules.Rules {
+		r.Rules[rule.Cre.Id] = rule
+	}
+}
+
 func (r *ReportT) GetCre(creId string) parser.ParseRuleT {
 	r.mux.Lock()
 	defer r.mux.Unlock()
@@ -145,6 +424,33 @@ func getSeverity(severity uint) (*severityT, error) {
 	return nil, ErrInvalidSeverity
 }
 
+// ParseSeverity converts a severity name (critical, high, medium, low, info)
+// to its CRE value.
+func ParseSeverity(name string) (uint, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case sevCritical:
+		return parser.SeverityCritical, nil
+	case sevHigh:
+		return parser.SeverityHigh, nil
+	case sevMedium:
+		return parser.SeverityMedium, nil
+	case sevLow:
+		return parser.SeverityLow, nil
+	case sevInfo:
+		return parser.SeverityInfo, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidSeverity, name)
+}
+
+// SeverityName returns the name of a CRE severity value.
+func SeverityName(severity uint) string {
+	sev, err := getSeverity(severity)
+	if err != nil {
+		return fmt.Sprintf("unknown(%d)", severity)
+	}
+	return sev.severity
+}
+
 func (r *ReportT) DisplayCREs() error {
 	r.mux.Lock()
 	defer r.mux.Unlock()
@@ -157,8 +463,13 @@ func (r *ReportT) DisplayCREs() error {
 		rules = append(rules, rule)
 	}
 
-	sort.Slice(rules, func(i, j int) bool {
-		return rules[i].Cre.Severity > rules[j].Cre.Severity
+	// Most severe first, then in the order of the report
+	slices.SortFunc(rules, func(a, b parser.ParseRuleT) int {
+		return cmp.Or(
+			cmp.Compare(a.Cre.Severity, b.Cre.Severity),
+			firstHit(r.CreHits[a.Cre.Id]).Compare(firstHit(r.CreHits[b.Cre.Id])),
+			strings.Compare(a.Cre.Id, b.Cre.Id),
+		)
 	})
 
 	for _, rule := range rules {
@@ -171,24 +482,58 @@ func (r *ReportT) DisplayCREs() error {
 			continue
 		}
 
-		sev, err := getSeverity(rule.Cre.Severity)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to get severity")
-			continue
+		r.displayCre(rule, creHits)
+	}
+
+	if n := len(r.suppressed); n > 0 && r.Pw != nil {
+		var hits int
+		for _, s := range r.suppressed {
+			hits += s.Count
 		}
+		r.Pw.Log(text.FgHiBlack.Sprintf("Suppressed: %d hits of %d CREs", hits, n))
+	}
 
-		var (
-			count = getColorizedCount(len(creHits), creHits[0])
-			cre   = getColorizedCre(rule.Cre.Id, text.Colors{sev.color, text.Bold})
-			tmpl  = fmt.Sprintf("%%%ds", sevWidth)
-			sevS  = text.Colors{sev.color}.Sprintf(tmpl, sev.severity)
-		)
+	if n := len(r.disabled); n > 0 && r.Pw != nil {
+		ids := strings.Join(slices.Sorted(maps.Keys(r.disabled)), ", ")
+		r.Pw.Log(text.FgYellow.Sprintf("Disabled: %d CREs exceeded the rule timeout (%s)", n, ids))
+	}
 
-		r.Pw.Log(fmt.Sprintf("%s %s %s", cre, sevS, count))
+	if r.Sampling != nil && r.Pw != nil {
+		r.Pw.Log(text.FgYellow.Sprintf("Sampled input: scanned %s", r.Sampling))
 	}
 	return nil
 }
 
+func (r *ReportT) displayCre(rule parser.ParseRuleT, creHits []time.Time) {
+
+	if r.Pw == nil {
+		return
+	}
+
+	sev, err := getSeverity(rule.Cre.Severity)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get severity")
+		return
+	}
+
+	var (
+		count = getColorizedCount(len(creHits), firstHit(creHits))
+		cre   = getColorizedCre(rule.Cre.Id, text.Colors{sev.color, text.Bold})
+		tmpl  = fmt.Sprintf("%%%ds", sevWidth)
+		sevS  = text.Colors{sev.color}.Sprintf(tmpl, sev.severity)
+	)
+
+	r.Pw.Log(fmt.Sprintf("%s %s %s", cre, sevS, count))
+}
+
+// firstHit returns the earliest of creHits, or the zero time if none.
+func firstHit(creHits []time.Time) time.Time {
+	if len(creHits) == 0 {
+		return time.Time{}
+	}
+	return slices.MinFunc(creHits, time.Time.Compare)
+}
+
 func (r *ReportT) Write(path string) (string, error) {
 	r.mux.Lock()
 	defer r.mux.Unlock()
@@ -206,7 +551,7 @@ func (r *ReportT) Write(path string) (string, error) {
 		reportName = path
 	}
 
-	if o, err = r.createReport(); err != nil {
+	if o, err = r.document(); err != nil {
 		return "", err
 	}
 
@@ -233,7 +578,7 @@ func (r *ReportT) PrintReport() error {
 		err  error
 	)
 
-	if o, err = r.createReport(); err != nil {
+	if o, err = r.document(); err != nil {
 		return err
 	}
 
@@ -248,6 +593,27 @@ func (r *ReportT) PrintReport() error {
 	return nil
 }
 
+// Detections returns the CREs that were hit, ordered by id.
+func (r *ReportT) Detections() []parser.ParseCreT {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	out := make([]parser.ParseCreT, 0, len(r.CreHits))
+	for id := range r.CreHits {
+		cre := r.Rules[id].Cre
+		if cre.Id == "" {
+			cre.Id = id
+		}
+		out = append(out, cre)
+	}
+
+	slices.SortFunc(out, func(a, b parser.ParseCreT) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+
+	return out
+}
+
 func (r *ReportT) Size() int {
 	r.mux.Lock()
 	defer r.mux.Unlock()
@@ -256,6 +622,174 @@ func (r *ReportT) Size() int {
 
 type ReportDocT []map[string]any
 
+// reportMetaT describes the whole run rather than any one detection.
+type reportMetaT struct {
+	Environment map[string]string `json:"environment,omitempty"`
+	Sampling    *SamplingT        `json:"sampling,omitempty"`
+}
+
+// reportFileT is the shape of a written report that carries metadata; one
+// without metadata is written as the bare list of detections.
+type reportFileT struct {
+	Metadata   *reportMetaT `json:"metadata"`
+	Detections ReportDocT   `json:"detections"`
+}
+
+// HitEntryT is one matched log line in a report entry's "hits".
+type HitEntryT struct {
+	Timestamp time.Time          `json:"timestamp"`
+	Entry     string             `json:"entry"`
+	Context   *matchz.ContextT   `json:"context,omitempty"`
+	Values    map[string]float64 `json:"values,omitempty"`
+	Source    string             `json:"source,omitempty"` // where the hit was read
+	Labels    map[string]string  `json:"labels,omitempty"` // of its source
+}
+
+// MetricT aggregates the values a rule extracted from the hits of a
+// detection.
+type MetricT struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+}
+
+func (m *MetricT) add(v float64) {
+	if m.Count == 0 {
+		m.Min, m.Max = v, v
+	}
+	m.Min = min(m.Min, v)
+	m.Max = max(m.Max, v)
+	m.Avg += (v - m.Avg) / float64(m.Count+1)
+	m.Count++
+}
+
+// reportEntryT mirrors the typed values createReport puts in each entry so
+// that a marshalled entry can be restored with the same shape.
+type reportEntryT struct {
+	Timestamp  string             `json:"timestamp"`
+	Id         string             `json:"id"`
+	Cre        parser.ParseCreT   `json:"cre"`
+	RuleId     string             `json:"rule_id"`
+	RuleHash   string             `json:"rule_hash"`
+	Severity   string             `json:"severity,omitempty"`
+	Hits       []HitEntryT        `json:"hits"`
+	Count      int                `json:"count,omitempty"`
+	First      string             `json:"first,omitempty"`
+	Last       string             `json:"last,omitempty"`
+	Suppressed *SuppressedT       `json:"suppressed,omitempty"`
+	Disabled   *DisabledT         `json:"disabled,omitempty"`
+	Metrics    map[string]MetricT `json:"metrics,omitempty"`
+	Sources    []string           `json:"sources,omitempty"`
+	Labels     map[string]string  `json:"labels,omitempty"`
+}
+
+// DecodeReportEntry restores a JSON encoded report entry.
+func DecodeReportEntry(data []byte) (map[string]any, error) {
+	var e reportEntryT
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+
+	o := map[string]any{
+		"timestamp": e.Timestamp,
+		"id":        e.Id,
+		"cre":       e.Cre,
+		"rule_id":   e.RuleId,
+		"rule_hash": e.RuleHash,
+		"hits":      e.Hits,
+	}
+	if e.Severity != "" {
+		o["severity"] = e.Severity
+	}
+	if e.Suppressed != nil {
+		o["suppressed"] = e.Suppressed
+	}
+	if e.Disabled != nil {
+		o["disabled"] = e.Disabled
+	}
+	if e.Count > 0 {
+		o["count"] = e.Count
+		o["first"] = e.First
+		o["last"] = e.Last
+	}
+	if e.Metrics != nil {
+		o["metrics"] = e.Metrics
+	}
+	if e.Sources != nil {
+		o["sources"] = e.Sources
+	}
+	if e.Labels != nil {
+		o["labels"] = e.Labels
+	}
+	return o, nil
+}
+
+// ReportedCres returns the CREs detected in the report at path, with the
+// sources they were detected in. Suppressed entries were detected too, and
+// are included; disabled entries were not.
+func ReportedCres(path string) ([]suppress.KnownT, error) {
+	entries, err := readReportEntries(path)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[suppress.KnownT]struct{}, len(entries))
+	for _, e := range entries {
+		if e.Disabled != nil || e.Id == "" {
+			continue
+		}
+		if len(e.Sources) == 0 {
+			known[suppress.KnownT{Id: e.Id}] = struct{}{}
+		}
+		for _, src := range e.Sources {
+			known[suppress.KnownT{Id: e.Id, Source: src}] = struct{}{}
+		}
+	}
+
+	return slices.SortedFunc(maps.Keys(known), func(a, b suppress.KnownT) int {
+		return cmp.Or(cmp.Compare(a.Id, b.Id), cmp.Compare(a.Source, b.Source))
+	}), nil
+}
+
+func readReportEntries(path string) ([]reportEntryT, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '{' {
+		var f struct {
+			Detections json.RawMessage `json:"detections"`
+		}
+		if err = json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("invalid report %s: %w", path, err)
+		}
+		data = f.Detections
+	}
+
+	var entries []reportEntryT
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid report %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// document is what Write and PrintReport output: the detections, wrapped
+// with the metadata of the run when there is any.
+func (r *ReportT) document() (any, error) {
+
+	doc, err := r.createReport()
+	if err != nil || (r.Env == nil && r.Sampling == nil) {
+		return doc, err
+	}
+
+	return reportFileT{
+		Metadata:   &reportMetaT{Environment: r.Env, Sampling: r.Sampling},
+		Detections: doc,
+	}, nil
+}
+
 func (r *ReportT) CreateReport() (ReportDocT, error) {
 	r.mux.Lock()
 	defer r.mux.Unlock()
@@ -267,34 +801,242 @@ func (r *ReportT) createReport() (ReportDocT, error) {
 		out = make([]map[string]any, 0)
 	)
 
-	// timestamp, CRE, rule id and hash, hit data
 	for id, creHits := range r.CreHits {
+		if r.dedup <= 0 {
+			out = append(out, r.entry(id, creHits))
+			continue
+		}
+		for _, group := range groupHits(creHits, r.dedup) {
+			out = append(out, r.dedupEntry(id, group))
+		}
+	}
 
-		var o = make(map[string]any)
-		o["timestamp"] = creHits[0].Format(time.RFC3339Nano)
-		o["id"] = id
-		o["cre"] = r.Rules[id].Cre
-		o["rule_id"] = r.Rules[id].Metadata.Id
-		o["rule_hash"] = r.Rules[id].Metadata.Hash
+	for id, s := range r.suppressed {
+		o := map[string]any{
+			"timestamp":  s.first.Format(time.RFC3339Nano),
+			"id":         id,
+			"cre":        r.Rules[id].Cre,
+			"rule_id":    r.Rules[id].Metadata.Id,
+			"rule_hash":  r.Rules[id].Metadata.Hash,
+			"severity":   SeverityName(r.Rules[id].Cre.Severity),
+			"hits":       []HitEntryT{},
+			"suppressed": s,
+		}
+		if len(s.sources) > 0 {
+			o["sources"] = slices.Sorted(maps.Keys(s.sources))
+		}
+		out = append(out, o)
+	}
 
-		type entryT struct {
-			Timestamp time.Time `json:"timestamp"`
-			Entry     string    `json:"entry"`
+	for id, d := range r.disabled {
+		out = append(out, map[string]any{
+			"timestamp": d.at.Format(time.RFC3339Nano),
+			"id":        id,
+			"cre":       r.Rules[id].Cre,
+			"rule_id":   r.Rules[id].Metadata.Id,
+			"rule_hash": r.Rules[id].Metadata.Hash,
+			"severity":  SeverityName(r.Rules[id].Cre.Severity),
+			"hits":      []HitEntryT{},
+			"disabled":  d,
+		})
+	}
+
+	sortEntries(out)
+
+	return out, nil
+}
+
+// sortEntries orders report entries by severity, most severe first, then
+// by time, CRE id and source, so that identical runs write identical
+// reports however their sources were scheduled.
+func sortEntries(entries []map[string]any) {
+	slices.SortStableFunc(entries, func(a, b map[string]any) int {
+		ca, _ := a["cre"].(parser.ParseCreT)
+		cb, _ := b["cre"].(parser.ParseCreT)
+		return cmp.Or(
+			cmp.Compare(ca.Severity, cb.Severity),
+			entryTime(a).Compare(entryTime(b)),
+			strings.Compare(ca.Id, cb.Id),
+			strings.Compare(entrySource(a), entrySource(b)),
+		)
+	})
+}
+
+// entryTime returns the time of a report entry. The timestamps are not
+// compared as strings, since RFC 3339 trims trailing zeros.
+func entryTime(e map[string]any) time.Time {
+	s, _ := e["timestamp"].(string)
+	ts, _ := time.Parse(time.RFC3339Nano, s)
+	return ts
+}
+
+// entrySource returns the first source of a report entry, if any.
+func entrySource(e map[string]any) string {
+	if srcs, _ := e["sources"].([]string); len(srcs) > 0 {
+		return srcs[0]
+	}
+	return ""
+}
+
+// compareHits orders hits by source, then by their entries.
+func compareHits(a, b matchz.HitsT) int {
+	if c := strings.Compare(a.Entity.FileName, b.Entity.FileName); c != 0 {
+		return c
+	}
+	return slices.CompareFunc(a.Entries, b.Entries, func(x, y matchz.EntryT) int {
+		return cmp.Or(cmp.Compare(x.Timestamp, y.Timestamp), bytes.Compare(x.Entry, y.Entry))
+	})
+}
+
+// insertHit adds m to hits in the order of compareHits.
+func insertHit(hits []matchz.HitsT, m matchz.HitsT) []matchz.HitsT {
+	i, _ := slices.BinarySearchFunc(hits, m, compareHits)
+	return slices.Insert(hits, i, m)
+}
+
+// uniqueTimes returns the detection times in order, each once; the hits
+// at a time are looked up together.
+func uniqueTimes(creHits []time.Time) []time.Time {
+	sorted := slices.SortedFunc(slices.Values(creHits), time.Time.Compare)
+	return slices.CompactFunc(sorted, time.Time.Equal)
+}
+
+// groupHits splits the detection times into runs whose gaps are at most
+// window.
+func groupHits(creHits []time.Time, window time.Duration) [][]time.Time {
+
+	sorted := slices.SortedFunc(slices.Values(creHits), time.Time.Compare)
+
+	var (
+		groups [][]time.Time
+		start  int
+	)
+	for i := 1; i <= len(sorted); i++ {
+		if i == len(sorted) || sorted[i].Sub(sorted[i-1]) > window {
+			groups = append(groups, sorted[start:i])
+			start = i
+		}
+	}
+
+	return groups
+}
+
+// dedupEntry builds one report entry for a run of detections of id.
+func (r *ReportT) dedupEntry(id string, group []time.Time) map[string]any {
+
+	o := r.entry(id, group)
+
+	if hits, ok := o["hits"].([]HitEntryT); ok && len(hits) > dedupSample {
+		o["hits"] = hits[:dedupSample]
+	}
+
+	o["count"] = len(group)
+	o["first"] = group[0].Format(time.RFC3339Nano)
+	o["last"] = group[len(group)-1].Format(time.RFC3339Nano)
+
+	return o
+}
+
+// hitsOf returns a lookup of the hits of id at a time, including any
+// spilled to disk.
+func (r *ReportT) hitsOf(id string) func(time.Time) []matchz.HitsT {
+
+	inMem := r.Hits[id]
+	if r.spill == nil || !r.spill.Spills(id) {
+		return func(ts time.Time) []matchz.HitsT {
+			return inMem[ts]
+		}
+	}
+
+	spilled, err := r.spill.read(id)
+	if err != nil {
+		log.Error().Err(err).Str("cre", id).Msg("Failed to read spilled detection hits")
+	}
+
+	return func(ts time.Time) []matchz.HitsT {
+		hits := spilled[ts.UnixNano()]
+		for _, m := range inMem[ts] {
+			hits = insertHit(hits, m)
 		}
-		matchHits := make([]entryT, 0)
-		for _, hit := range creHits {
-
-			for _, e := range r.Hits[id][hit].Entries {
-				matchHits = append(matchHits, entryT{
-					Timestamp: time.Unix(0, e.Timestamp),
-					Entry:     string(e.Entry),
-				})
+		return hits
+	}
+}
+
+// entry builds the report entry of one detection: timestamp, CRE, rule id
+// and hash, and hit data.
+func (r *ReportT) entry(id string, creHits []time.Time) map[string]any {
+
+	// Detections are added as sources report them; list them in time order
+	creHits = slices.SortedFunc(slices.Values(creHits), time.Time.Compare)
+
+	var o = make(map[string]any)
+	o["timestamp"] = creHits[0].Format(time.RFC3339Nano)
+	o["id"] = id
+	o["cre"] = r.Rules[id].Cre
+	o["rule_id"] = r.Rules[id].Metadata.Id
+	o["rule_hash"] = r.Rules[id].Metadata.Hash
+	o["severity"] = SeverityName(r.Rules[id].Cre.Severity)
+
+	var (
+		matchHits = make([]HitEntryT, 0)
+		sources   = make(map[string]struct{})
+		labels    = make(map[string]map[string]struct{})
+		metrics   = make(map[string]MetricT)
+		hitAt     = r.hitsOf(id)
+		hits      []matchz.HitsT
+	)
+	for _, ts := range uniqueTimes(creHits) {
+		hits = append(hits, hitAt(ts)...)
+	}
+
+	for _, m := range hits {
+
+		if src := m.Entity.FileName; src != "" {
+			sources[src] = struct{}{}
+		}
+
+		for k, v := range m.Entity.Labels {
+			if labels[k] == nil {
+				labels[k] = make(map[string]struct{})
 			}
+			labels[k][v] = struct{}{}
 		}
 
-		o["hits"] = matchHits
-		out = append(out, o)
+		for _, e := range m.Entries {
+			matchHits = append(matchHits, HitEntryT{
+				Timestamp: time.Unix(0, e.Timestamp),
+				Entry:     string(e.Entry),
+				Context:   e.Context,
+				Values:    e.Values,
+				Source:    m.Entity.FileName,
+				Labels:    m.Entity.Labels,
+			})
+			for name, v := range e.Values {
+				mt := metrics[name]
+				mt.add(v)
+				metrics[name] = mt
+			}
+		}
 	}
 
-	return out, nil
+	o["hits"] = matchHits
+
+	if len(metrics) > 0 {
+		o["metrics"] = metrics
+	}
+
+	if len(sources) > 0 {
+		o["sources"] = slices.Sorted(maps.Keys(sources))
+	}
+
+	// Hits from differently labeled sources list every value
+	if len(labels) > 0 {
+		merged := make(map[string]string, len(labels))
+		for k, vals := range labels {
+			merged[k] = strings.Join(slices.Sorted(maps.Keys(vals)), ",")
+		}
+		o["labels"] = merged
+	}
+
+	return o
 }
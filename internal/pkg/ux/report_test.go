@@ -0,0 +1,563 @@
+package ux
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/prequel-dev/preq/internal/pkg/suppress"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+)
+
+func TestReportLabels(t *testing.T) {
+	var (
+		r   = NewReport(nil)
+		cre = parser.ParseCreT{Id: "CRE-2025-0001"}
+		ts  = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	)
+
+	hit := func(labels map[string]string) matchz.HitsT {
+		return matchz.HitsT{
+			Count:   1,
+			Entries: []matchz.EntryT{{Timestamp: ts.UnixNano(), Entry: []byte("boom")}},
+			Entity:  matchz.EntityMetadataT{FileName: "app", Labels: labels},
+		}
+	}
+
+	r.AddCreHit(&cre, ts, hit(map[string]string{"env": "prod", "service": "api"}))
+	r.AddCreHit(&cre, ts.Add(time.Second), hit(map[string]string{"env": "staging", "service": "api"}))
+
+	doc, err := r.CreateReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc) != 1 {
+		t.Fatalf("Expected 1 detection, got %d", len(doc))
+	}
+
+	labels, ok := doc[0]["labels"].(map[string]string)
+	if !ok {
+		t.Fatalf("Expected labels, got %v", doc[0]["labels"])
+	}
+	if labels["env"] != "prod,staging" || labels["service"] != "api" {
+		t.Errorf("Unexpected labels: %v", labels)
+	}
+}
+
+func TestReportSampling(t *testing.T) {
+	var (
+		r   = NewReport(nil)
+		cre = parser.ParseCreT{Id: "CRE-2025-0001"}
+		ts  = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	)
+
+	r.SetSampling(SamplingT{Rate: 0.1, MaxLinesPerSource: 500})
+	r.AddCreHit(&cre, ts, matchz.HitsT{
+		Count:   1,
+		Entries: []matchz.EntryT{{Timestamp: ts.UnixNano(), Entry: []byte("boom")}},
+	})
+
+	doc, err := r.CreateReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc[0]["sampling"]; ok {
+		t.Errorf("Expected sampling only in the report metadata, got it in %v", doc[0])
+	}
+
+	o, err := r.document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := o.(reportFileT)
+	if !ok || f.Metadata.Sampling == nil {
+		t.Fatalf("Expected sampling metadata, got %v", o)
+	}
+
+	s := f.Metadata.Sampling
+	if s.Rate != 0.1 || s.MaxLinesPerSource != 500 {
+		t.Fatalf("Expected sampling note, got %+v", s)
+	}
+	if got, want := s.String(), "10% of lines, at most 500 lines per source"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestReportEnvironment(t *testing.T) {
+	var (
+		r   = NewReport(nil)
+		cre = parser.ParseCreT{Id: "CRE-2025-0001"}
+		ts  = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	)
+
+	r.SetEnvironment(map[string]string{"os": "linux"})
+	for i := range 2 {
+		r.AddCreHit(&cre, ts.Add(time.Duration(i)*time.Hour), matchz.HitsT{
+			Count:   1,
+			Entries: []matchz.EntryT{{Timestamp: ts.UnixNano(), Entry: []byte("boom")}},
+		})
+	}
+
+	path, err := r.Write(filepath.Join(t.TempDir(), "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f struct {
+		Metadata   reportMetaT      `json:"metadata"`
+		Detections []map[string]any `json:"detections"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if f.Metadata.Environment["os"] != "linux" {
+		t.Errorf("Expected the environment in the report metadata, got %+v", f.Metadata)
+	}
+	if len(f.Detections) != 1 || f.Detections[0]["environment"] != nil {
+		t.Errorf("Expected one detection without the environment, got %v", f.Detections)
+	}
+
+	// Reports with metadata are still read back
+	known, err := ReportedCres(path)
+	if err != nil || len(known) != 1 || known[0].Id != cre.Id {
+		t.Errorf("Expected %s from the report, got %v, %v", cre.Id, known, err)
+	}
+}
+
+func TestReportMetrics(t *testing.T) {
+	var (
+		r   = NewReport(nil)
+		cre = parser.ParseCreT{Id: "CRE-2025-0001"}
+		ts  = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	)
+
+	hit := func(vals ...float64) matchz.HitsT {
+		m := matchz.HitsT{Count: 1}
+		for _, v := range vals {
+			m.Entries = append(m.Entries, matchz.EntryT{
+				Timestamp: ts.UnixNano(),
+				Entry:     []byte("slow"),
+				Values:    map[string]float64{"latency_ms": v},
+			})
+		}
+		return m
+	}
+
+	r.AddCreHit(&cre, ts, hit(120, 480))
+	r.AddCreHit(&cre, ts.Add(time.Second), hit(300))
+
+	doc, err := r.CreateReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics, ok := doc[0]["metrics"].(map[string]MetricT)
+	if !ok {
+		t.Fatalf("Expected metrics, got %v", doc[0]["metrics"])
+	}
+	if got, want := metrics["latency_ms"], (MetricT{Count: 3, Min: 120, Max: 480, Avg: 300}); got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestReportDedup(t *testing.T) {
+	var (
+		r   = NewReport(nil)
+		cre = parser.ParseCreT{Id: "CRE-2025-0001"}
+		ts  = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	)
+
+	r.SetDedupWindow(time.Minute)
+
+	// A burst of detections a few seconds apart, then one an hour later
+	add := func(at time.Time) {
+		r.AddCreHit(&cre, at, matchz.HitsT{
+			Count:   1,
+			Entries: []matchz.EntryT{{Timestamp: at.UnixNano(), Entry: []byte("boom")}},
+		})
+	}
+	for i := range 25 {
+		add(ts.Add(time.Duration(i) * 5 * time.Second))
+	}
+	add(ts.Add(time.Hour))
+
+	doc, err := r.CreateReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(doc))
+	}
+
+	slices.SortFunc(doc, func(a, b map[string]any) int {
+		return strings.Compare(a["first"].(string), b["first"].(string))
+	})
+
+	burst := doc[0]
+	if burst["count"] != 25 || burst["last"] != ts.Add(120*time.Second).Format(time.RFC3339Nano) {
+		t.Errorf("Unexpected burst entry: count=%v first=%v last=%v", burst["count"], burst["first"], burst["last"])
+	}
+	if hits := burst["hits"].([]HitEntryT); len(hits) != dedupSample {
+		t.Errorf("Expected %d sampled hits, got %d", dedupSample, len(hits))
+	}
+	if doc[1]["count"] != 1 {
+		t.Errorf("Expected the later detection on its own, got count=%v", doc[1]["count"])
+	}
+}
+
+func TestReportSuppress(t *testing.T) {
+	var (
+		r   = NewReport(nil)
+		cre = parser.ParseCreT{Id: "CRE-2025-0025"}
+		ts  = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	)
+
+	l, err := suppress.Parse([]byte("- id: CRE-2025-0025\n  reason: accepted\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSuppressions(l)
+
+	for i := range 3 {
+		at := ts.Add(time.Duration(i) * time.Second)
+		if r.AddCreHit(&cre, at, matchz.HitsT{Count: 1, Entries: []matchz.EntryT{{Timestamp: at.UnixNano(), Entry: []byte("boom")}}}) {
+			t.Fatalf("Expected a suppressed hit not to be a new detection")
+		}
+	}
+
+	if r.Size() != 0 || len(r.Detections()) != 0 {
+		t.Fatalf("Expected no detections, got %d", r.Size())
+	}
+
+	doc, err := r.CreateReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, ok := doc[0]["suppressed"].(*SuppressedT)
+	if len(doc) != 1 || !ok || s.Reason != "accepted" || s.Count != 3 {
+		t.Fatalf("Expected suppression noted in report, got %v", doc)
+	}
+	if doc[0]["timestamp"] != ts.Format(time.RFC3339Nano) {
+		t.Errorf("Expected first suppressed hit, got %v", doc[0]["timestamp"])
+	}
+}
+
+func TestReportSeverityOrder(t *testing.T) {
+	var (
+		r  = NewReport(nil)
+		ts = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	)
+
+	for _, cre := range []parser.ParseCreT{
+		{Id: "CRE-2025-0003", Severity: parser.SeverityLow},
+		{Id: "CRE-2025-0002", Severity: parser.SeverityCritical},
+		{Id: "CRE-2025-0001", Severity: parser.SeverityLow},
+	} {
+		r.Rules[cre.Id] = parser.ParseRuleT{Cre: cre}
+		r.AddCreHit(&cre, ts, matchz.HitsT{Count: 1, Entries: []matchz.EntryT{{Timestamp: ts.UnixNano(), Entry: []byte("boom")}}})
+	}
+
+	doc, err := r.CreateReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, e := range doc {
+		got = append(got, fmt.Sprintf("%s %s", e["severity"], e["id"]))
+	}
+
+	want := []string{"critical CRE-2025-0002", "low CRE-2025-0001", "low CRE-2025-0003"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestReportStableOrder(t *testing.T) {
+	var (
+		ts   = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+		low1 = parser.ParseCreT{Id: "CRE-2025-0001", Severity: parser.SeverityLow}
+		low2 = parser.ParseCreT{Id: "CRE-2025-0002", Severity: parser.SeverityLow}
+		crit = parser.ParseCreT{Id: "CRE-2025-0003", Severity: parser.SeverityCritical}
+	)
+
+	type addT struct {
+		cre *parser.ParseCreT
+		ts  time.Time
+		src string
+	}
+
+	adds := []addT{
+		{&low1, ts.Add(150 * time.Millisecond), "b.log"},
+		{&low1, ts.Add(100 * time.Millisecond), "a.log"},
+		{&low2, ts.Add(100 * time.Millisecond), "a.log"},
+		{&low2, ts.Add(100 * time.Millisecond), "b.log"},
+		{&low1, ts.Add(2 * time.Second), "c.log"},
+		{&crit, ts.Add(time.Hour), "c.log"},
+	}
+
+	report := func(adds []addT) (string, []string) {
+		r := NewReport(nil)
+		for _, cre := range []parser.ParseCreT{low1, low2, crit} {
+			r.Rules[cre.Id] = parser.ParseRuleT{Cre: cre}
+		}
+		for _, a := range adds {
+			r.AddCreHit(a.cre, a.ts, matchz.HitsT{
+				Count:   1,
+				Entries: []matchz.EntryT{{Timestamp: a.ts.UnixNano(), Entry: []byte("boom " + a.src)}},
+				Entity:  matchz.EntityMetadataT{FileName: a.src},
+			})
+		}
+		doc, err := r.CreateReport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, e := range doc {
+			ids = append(ids, fmt.Sprintf("%s %s", e["id"], e["timestamp"]))
+		}
+		return string(data), ids
+	}
+
+	want, ids := report(adds)
+
+	wantIds := []string{
+		"CRE-2025-0003 2024-05-01T11:00:00Z",
+		"CRE-2025-0001 2024-05-01T10:00:00.1Z",
+		"CRE-2025-0002 2024-05-01T10:00:00.1Z",
+	}
+	if !slices.Equal(ids, wantIds) {
+		t.Errorf("Expected %v, got %v", wantIds, ids)
+	}
+
+	for i := 0; i < 10; i++ {
+		shuffled := slices.Clone(adds)
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		if got, _ := report(shuffled); got != want {
+			t.Fatalf("Report depends on hit order:\n%s\n%s", want, got)
+		}
+	}
+}
+
+func TestReportedCres(t *testing.T) {
+	var (
+		prev = NewReport(nil)
+		ts   = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+		hit  = func(src string) matchz.HitsT {
+			return matchz.HitsT{
+				Count:   1,
+				Entries: []matchz.EntryT{{Timestamp: ts.UnixNano(), Entry: []byte("boom")}},
+				Entity:  matchz.EntityMetadataT{FileName: src},
+			}
+		}
+	)
+
+	l, err := suppress.Parse([]byte("- id: CRE-2025-0003\n  reason: accepted\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev.SetSuppressions(l)
+
+	for _, id := range []string{"CRE-2025-0002", "CRE-2025-0001", "CRE-2025-0002", "CRE-2025-0003"} {
+		cre := parser.ParseCreT{Id: id}
+		prev.AddCreHit(&cre, ts, hit("/var/log/a.log"))
+		ts = ts.Add(time.Second)
+	}
+
+	path, err := prev.Write(t.TempDir() + "/prev.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	known, err := ReportedCres(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Suppressed detections are known too
+	want := []suppress.KnownT{
+		{Id: "CRE-2025-0001", Source: "/var/log/a.log"},
+		{Id: "CRE-2025-0002", Source: "/var/log/a.log"},
+		{Id: "CRE-2025-0003", Source: "/var/log/a.log"},
+	}
+	if !slices.Equal(known, want) {
+		t.Errorf("Expected %v, got %v", want, known)
+	}
+
+	// A later run only reports the CREs the baseline does not hold for
+	// their source
+	cur := NewReport(nil)
+	cur.SetSuppressions(suppress.Known(known, "in baseline"))
+	for _, h := range []struct{ id, src string }{
+		{"CRE-2025-0001", "/var/log/a.log"},
+		{"CRE-2025-0003", "/var/log/a.log"},
+		{"CRE-2025-0001", "/var/log/b.log"},
+		{"CRE-2025-0004", "/var/log/a.log"},
+	} {
+		cre := parser.ParseCreT{Id: h.id}
+		cur.AddCreHit(&cre, ts, hit(h.src))
+		ts = ts.Add(time.Second)
+	}
+
+	var got []string
+	for _, d := range cur.Detections() {
+		got = append(got, d.Id)
+	}
+	slices.Sort(got)
+	if want := []string{"CRE-2025-0001", "CRE-2025-0004"}; !slices.Equal(got, want) {
+		t.Errorf("Expected only the new detections %v, got %v", want, got)
+	}
+}
+
+func TestReportObserve(t *testing.T) {
+	var (
+		r    = NewReport(nil)
+		cre  = parser.ParseCreT{Id: "CRE-2025-0001"}
+		ts   = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+		seen []ReportDocT
+	)
+
+	r.Observe(func(doc ReportDocT) {
+		seen = append(seen, doc)
+	})
+
+	for i := 0; i < 2; i++ {
+		at := ts.Add(time.Duration(i) * time.Second)
+		r.AddCreHit(&cre, at, matchz.HitsT{
+			Count:   1,
+			Entries: []matchz.EntryT{{Timestamp: at.UnixNano(), Entry: []byte("boom")}},
+		})
+	}
+
+	if len(seen) != 2 {
+		t.Fatalf("Expected 2 observed hits, got %d", len(seen))
+	}
+	for i, doc := range seen {
+		if len(doc) != 1 || doc[0]["id"] != cre.Id {
+			t.Fatalf("Unexpected observed doc %d: %v", i, doc)
+		}
+		if hits, ok := doc[0]["hits"].([]HitEntryT); !ok || len(hits) != 1 {
+			t.Errorf("Expected one hit in observed doc %d, got %v", i, doc[0]["hits"])
+		}
+	}
+
+	// The report keeps every hit
+	doc, err := r.CreateReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc) != 1 || len(r.CreHits[cre.Id]) != 2 {
+		t.Errorf("Expected report to keep both hits, got %v", doc)
+	}
+}
+
+func TestReportSameTime(t *testing.T) {
+	var (
+		cre = parser.ParseCreT{Id: "CRE-2025-0001"}
+		ts  = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	)
+
+	for _, limit := range []int64{0, 1} {
+		r := NewReport(nil)
+		if limit > 0 {
+			r.SetMemoryLimit(limit, t.TempDir())
+		}
+		for _, src := range []string{"b.log", "a.log"} {
+			r.AddCreHit(&cre, ts, matchz.HitsT{
+				Count:   1,
+				Entries: []matchz.EntryT{{Timestamp: ts.UnixNano(), Entry: []byte("boom " + src)}},
+				Entity:  matchz.EntityMetadataT{FileName: src},
+			})
+		}
+
+		doc, err := r.CreateReport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(doc) != 1 {
+			t.Fatalf("Expected 1 detection, got %d", len(doc))
+		}
+		var got []string
+		for _, h := range doc[0]["hits"].([]HitEntryT) {
+			got = append(got, h.Source)
+		}
+		if want := []string{"a.log", "b.log"}; !slices.Equal(got, want) {
+			t.Errorf("Expected hits from %v with limit %d, got %v", want, limit, got)
+		}
+		if err = r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReportSpill(t *testing.T) {
+	var (
+		r   = NewReport(nil)
+		dir = t.TempDir()
+		ts  = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	)
+
+	// Room for about one detection's hits
+	r.SetMemoryLimit(400, dir)
+
+	for i := 0; i < 12; i++ {
+		cre := parser.ParseCreT{Id: fmt.Sprintf("CRE-2025-000%d", i%3)}
+		at := ts.Add(time.Duration(i) * time.Second)
+		r.AddCreHit(&cre, at, matchz.HitsT{
+			Count:   1,
+			Entries: []matchz.EntryT{{Timestamp: at.UnixNano(), Entry: []byte(fmt.Sprintf("line %d", i))}},
+			Entity:  matchz.EntityMetadataT{FileName: "app"},
+		})
+	}
+
+	if r.Spilled() == 0 {
+		t.Fatal("Expected hits to be spilled")
+	}
+	if len(r.Hits) == 3 {
+		t.Errorf("Expected spilled detections to leave memory, got %d in memory", len(r.Hits))
+	}
+
+	doc, err := r.CreateReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc) != 3 {
+		t.Fatalf("Expected 3 detections, got %d", len(doc))
+	}
+	for _, e := range doc {
+		hits := e["hits"].([]HitEntryT)
+		if len(hits) != 4 {
+			t.Errorf("Expected 4 hits for %v, got %d", e["id"], len(hits))
+		}
+		for _, h := range hits {
+			if !strings.HasPrefix(h.Entry, "line ") {
+				t.Errorf("Unexpected hit %q", h.Entry)
+			}
+		}
+	}
+
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := os.ReadDir(dir); len(left) != 0 {
+		t.Errorf("Expected spill file removed, found %d files", len(left))
+	}
+}
@@ -0,0 +1,223 @@
+package ux
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/prequel-dev/preq/internal/pkg/catalog"
+	"gopkg.in/yaml.v3"
+)
+
+// RuleInfoT is the summary of a rule listed by the rules subcommands.
+type RuleInfoT struct {
+	Id           string   `json:"id"`
+	Title        string   `json:"title,omitempty"`
+	Severity     string   `json:"severity"`
+	Category     string   `json:"category,omitempty"`
+	Technology   string   `json:"technology,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	RuleId       string   `json:"rule_id,omitempty"`
+	RuleHash     string   `json:"rule_hash,omitempty"`
+	Path         string   `json:"path"`
+	Deprecated   bool     `json:"deprecated,omitempty"`
+	SupersededBy string   `json:"superseded_by,omitempty"`
+}
+
+func ruleInfo(e catalog.EntryT) RuleInfoT {
+	info := RuleInfoT{
+		Id:         e.Rule.Cre.Id,
+		Title:      e.Rule.Cre.Title,
+		Severity:   SeverityName(e.Rule.Cre.Severity),
+		Category:   e.Rule.Cre.Category,
+		Technology: e.Technology(),
+		Tags:       e.Rule.Cre.Tags,
+		RuleId:     e.Rule.Metadata.Id,
+		RuleHash:   e.Rule.Metadata.Hash,
+		Path:       e.Path,
+	}
+	if e.Deprecation != nil {
+		info.Deprecated, info.SupersededBy = true, e.Deprecation.SupersededBy
+	}
+	return info
+}
+
+// PrintRules lists rules as a table, or as JSON for scripts.
+func PrintRules(w io.Writer, entries []catalog.EntryT, asJson bool) error {
+
+	infos := make([]RuleInfoT, 0, len(entries))
+	for _, e := range entries {
+		infos = append(infos, ruleInfo(e))
+	}
+
+	if asJson {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(infos)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CRE\tSEVERITY\tCATEGORY\tTECHNOLOGY\tTITLE")
+	for _, i := range infos {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", i.Id, i.Severity, dash(i.Category), dash(i.Technology), dash(i.Title))
+	}
+	return tw.Flush()
+}
+
+// RuleChangeT is a CRE two rule sets define differently.
+type RuleChangeT struct {
+	Id          string   `json:"id"`
+	Title       string   `json:"title,omitempty"`
+	OldSeverity string   `json:"old_severity"`
+	NewSeverity string   `json:"new_severity"`
+	Changes     []string `json:"changes"`
+}
+
+// RulesDiffT is the difference between two rule sets.
+type RulesDiffT struct {
+	Old     string        `json:"old"`
+	New     string        `json:"new"`
+	Added   []RuleInfoT   `json:"added"`
+	Removed []RuleInfoT   `json:"removed"`
+	Changed []RuleChangeT `json:"changed"`
+}
+
+// PrintRulesDiff lists the CREs added, removed and changed from the rules
+// at oldPath to those at newPath, or prints them as JSON for scripts.
+func PrintRulesDiff(w io.Writer, d catalog.DiffT, oldPath, newPath string, asJson bool) error {
+
+	out := RulesDiffT{
+		Old:     oldPath,
+		New:     newPath,
+		Added:   make([]RuleInfoT, 0, len(d.Added)),
+		Removed: make([]RuleInfoT, 0, len(d.Removed)),
+		Changed: make([]RuleChangeT, 0, len(d.Changed)),
+	}
+	for _, e := range d.Added {
+		out.Added = append(out.Added, ruleInfo(e))
+	}
+	for _, e := range d.Removed {
+		out.Removed = append(out.Removed, ruleInfo(e))
+	}
+	for _, c := range d.Changed {
+		out.Changed = append(out.Changed, RuleChangeT{
+			Id:          c.New.Rule.Cre.Id,
+			Title:       c.New.Rule.Cre.Title,
+			OldSeverity: SeverityName(c.Old.Rule.Cre.Severity),
+			NewSeverity: SeverityName(c.New.Rule.Cre.Severity),
+			Changes:     c.Fields,
+		})
+	}
+
+	if asJson {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+
+	if d.Empty() {
+		fmt.Fprintln(w, "No CREs added, removed or changed")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANGE\tCRE\tSEVERITY\tTITLE\tFIELDS")
+	for _, i := range out.Added {
+		fmt.Fprintf(tw, "added\t%s\t%s\t%s\t-\n", i.Id, i.Severity, dash(i.Title))
+	}
+	for _, i := range out.Removed {
+		fmt.Fprintf(tw, "removed\t%s\t%s\t%s\t-\n", i.Id, i.Severity, dash(i.Title))
+	}
+	for _, c := range out.Changed {
+		sev := c.NewSeverity
+		if c.OldSeverity != c.NewSeverity {
+			sev = c.OldSeverity + " -> " + c.NewSeverity
+		}
+		fmt.Fprintf(tw, "changed\t%s\t%s\t%s\t%s\n", c.Id, sev, dash(c.Title), strings.Join(c.Changes, ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d added, %d removed, %d changed\n", len(out.Added), len(out.Removed), len(out.Changed))
+	return err
+}
+
+// PrintRule prints what a rule detects, how to mitigate it, and its full
+// definition.
+func PrintRule(w io.Writer, e catalog.EntryT) error {
+
+	var (
+		cre  = e.Rule.Cre
+		meta = e.Rule.Metadata
+		bold = text.Colors{text.Bold}
+	)
+
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "%s %s\n", bold.Sprintf("%-12s", name+":"), value)
+		}
+	}
+
+	section := func(name, value string) {
+		if value = strings.TrimSpace(value); value != "" {
+			fmt.Fprintf(w, "\n%s\n%s\n", bold.Sprint(name), value)
+		}
+	}
+
+	field("CRE", cre.Id)
+	field("Title", cre.Title)
+	field("Severity", SeverityName(cre.Severity))
+	field("Category", cre.Category)
+	field("Technology", e.Technology())
+	field("Tags", strings.Join(cre.Tags, ", "))
+	field("Author", cre.Author)
+	field("Rule id", meta.Id)
+	field("Rule hash", meta.Hash)
+	field("Path", e.Path)
+	if d := e.Deprecation; d != nil {
+		if d.SupersededBy != "" {
+			field("Deprecated", "superseded by "+d.SupersededBy)
+		} else {
+			field("Deprecated", "yes")
+		}
+	}
+
+	section("Description", cre.Description)
+	section("Cause", cre.Cause)
+	section("Impact", cre.Impact)
+	section("Mitigation", cre.Mitigation)
+
+	if len(cre.References) > 0 {
+		fmt.Fprintf(w, "\n%s\n", bold.Sprint("References"))
+		for _, ref := range cre.References {
+			fmt.Fprintf(w, "- %s\n", ref)
+		}
+	}
+
+	def, err := yaml.Marshal(e.Rule.Rule)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n%s\n%s", bold.Sprint("Rule"), def)
+
+	if terms := e.Terms(); len(terms) > 0 {
+		def, err := yaml.Marshal(terms)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\n%s\n%s", bold.Sprint("Terms"), def)
+	}
+
+	return nil
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
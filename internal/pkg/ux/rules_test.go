@@ -0,0 +1,95 @@
+package ux
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/prequel-dev/preq/internal/pkg/catalog"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+)
+
+func TestPrintRules(t *testing.T) {
+
+	entries := []catalog.EntryT{{
+		Rule: parser.ParseRuleT{Cre: parser.ParseCreT{
+			Id:           "CRE-2025-0002",
+			Severity:     parser.SeverityHigh,
+			Title:        "Broker unreachable",
+			Category:     "message-queue-problems",
+			Applications: []parser.ParseApplicationT{{Name: "rabbitmq"}},
+		}},
+		Path: "rules.yaml",
+	}, {
+		Rule: parser.ParseRuleT{Cre: parser.ParseCreT{Id: "CRE-2025-0003"}},
+		Path: "rules.yaml",
+	}}
+
+	var out bytes.Buffer
+	if err := PrintRules(&out, entries, false); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "CRE") {
+		t.Fatalf("Unexpected table:\n%s", out.String())
+	}
+	if f := strings.Fields(lines[1]); strings.Join(f, " ") != "CRE-2025-0002 high message-queue-problems rabbitmq Broker unreachable" {
+		t.Errorf("Unexpected row: %q", lines[1])
+	}
+	if f := strings.Fields(lines[2]); strings.Join(f, " ") != "CRE-2025-0003 critical - - -" {
+		t.Errorf("Unexpected row: %q", lines[2])
+	}
+
+	out.Reset()
+	if err := PrintRules(&out, entries, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var infos []RuleInfoT
+	if err := json.Unmarshal(out.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].Technology != "rabbitmq" || infos[0].Severity != "high" {
+		t.Errorf("Unexpected JSON: %+v", infos)
+	}
+}
+
+func TestPrintRule(t *testing.T) {
+
+	e := catalog.EntryT{
+		Rule: parser.ParseRuleT{
+			Cre: parser.ParseCreT{
+				Id:         "CRE-2025-0002",
+				Title:      "Broker unreachable",
+				Mitigation: "Restart the broker.\n",
+				References: []string{"https://example.com/broker"},
+			},
+			Metadata: parser.ParseRuleMetadataT{Hash: "Fj5Hs8Kd2Lq9Wx4Pz7Nm3R"},
+			Rule: parser.ParseRuleDataT{Set: &parser.ParseSetT{
+				Match: []parser.ParseTermT{{StrValue: "connection refused"}},
+			}},
+		},
+		Path: "rules.yaml",
+	}
+
+	var out bytes.Buffer
+	if err := PrintRule(&out, e); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"CRE-2025-0002",
+		"Broker unreachable",
+		"Fj5Hs8Kd2Lq9Wx4Pz7Nm3R",
+		"Mitigation",
+		"Restart the broker.",
+		"- https://example.com/broker",
+		"value: connection refused",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in:\n%s", want, out.String())
+		}
+	}
+}
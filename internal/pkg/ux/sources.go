@@ -0,0 +1,173 @@
+package ux
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	SourcesText = "text"
+	SourcesJson = "json"
+
+	unknownSource = "(unknown)"
+)
+
+var (
+	ErrSourcesFormat = errors.New("unsupported sources format")
+)
+
+// SourceDetectionsT is what one source of a report was found with.
+type SourceDetectionsT struct {
+	Source     string             `json:"source"`
+	Labels     map[string]string  `json:"labels,omitempty"`
+	Detections []SourceDetectionT `json:"detections"`
+}
+
+// SourceDetectionT counts the hits of a CRE in one source.
+type SourceDetectionT struct {
+	Id       string    `json:"id"`
+	Severity string    `json:"severity"`
+	Title    string    `json:"title,omitempty"`
+	Hits     int       `json:"hits"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+}
+
+// groupBySource regroups the detections of a report by the source of
+// each hit, most severe first within a source. Hits of reports written
+// before hits were tagged fall back to the source of their entry when it
+// has only one.
+func groupBySource(entries []reportEntryT) []SourceDetectionsT {
+
+	type keyT struct{ src, id string }
+
+	var (
+		dets   = make(map[keyT]*SourceDetectionT)
+		sevs   = make(map[string]uint)
+		labels = make(map[string]map[string]map[string]struct{})
+	)
+
+	for _, e := range entries {
+		if e.Suppressed != nil || e.Disabled != nil {
+			continue
+		}
+		sevs[e.Id] = e.Cre.Severity
+
+		for _, h := range e.Hits {
+			src := h.Source
+			if src == "" && len(e.Sources) == 1 {
+				src = e.Sources[0]
+			}
+			if src == "" {
+				src = unknownSource
+			}
+
+			for k, v := range h.Labels {
+				if labels[src] == nil {
+					labels[src] = make(map[string]map[string]struct{})
+				}
+				if labels[src][k] == nil {
+					labels[src][k] = make(map[string]struct{})
+				}
+				labels[src][k][v] = struct{}{}
+			}
+
+			key := keyT{src, e.Id}
+			d, ok := dets[key]
+			if !ok {
+				d = &SourceDetectionT{
+					Id:       e.Id,
+					Severity: SeverityName(e.Cre.Severity),
+					Title:    e.Cre.Title,
+				}
+				dets[key] = d
+			}
+			if d.First.IsZero() || h.Timestamp.Before(d.First) {
+				d.First = h.Timestamp
+			}
+			if h.Timestamp.After(d.Last) {
+				d.Last = h.Timestamp
+			}
+			d.Hits++
+		}
+	}
+
+	bySrc := make(map[string][]SourceDetectionT)
+	for key, d := range dets {
+		bySrc[key.src] = append(bySrc[key.src], *d)
+	}
+
+	out := make([]SourceDetectionsT, 0, len(bySrc))
+	for _, src := range slices.Sorted(maps.Keys(bySrc)) {
+		ds := bySrc[src]
+		slices.SortFunc(ds, func(a, b SourceDetectionT) int {
+			return cmp.Or(cmp.Compare(sevs[a.Id], sevs[b.Id]), strings.Compare(a.Id, b.Id))
+		})
+
+		sd := SourceDetectionsT{Source: src, Detections: ds}
+		if ls := labels[src]; len(ls) > 0 {
+			sd.Labels = make(map[string]string, len(ls))
+			for k, vals := range ls {
+				sd.Labels[k] = strings.Join(slices.Sorted(maps.Keys(vals)), ",")
+			}
+		}
+		out = append(out, sd)
+	}
+
+	return out
+}
+
+// SourcesReport prints the detections of a report written by preq grouped
+// by the source they were found in, as a table or JSON.
+func SourcesReport(w io.Writer, reportPath, format string) error {
+
+	entries, err := readReportEntries(reportPath)
+	if err != nil {
+		return err
+	}
+
+	groups := groupBySource(entries)
+
+	switch format {
+	case "", SourcesText:
+		return printSources(w, groups)
+	case SourcesJson:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(groups)
+	}
+
+	return fmt.Errorf("%w: %s", ErrSourcesFormat, format)
+}
+
+func printSources(w io.Writer, groups []SourceDetectionsT) error {
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	for i, g := range groups {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+
+		fmt.Fprint(tw, g.Source)
+		for _, k := range slices.Sorted(maps.Keys(g.Labels)) {
+			fmt.Fprintf(tw, " %s=%s", k, g.Labels[k])
+		}
+		fmt.Fprintln(tw)
+
+		for _, d := range g.Detections {
+			fmt.Fprintf(tw, "  %s\t%s\t%d hits\t%s\t%s\n", d.Id, d.Severity, d.Hits,
+				d.First.Format(time.RFC3339), d.Last.Format(time.RFC3339))
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,127 @@
+package ux
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+)
+
+func TestSourcesReport(t *testing.T) {
+	var (
+		r    = NewReport(nil)
+		ts   = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+		oom  = parser.ParseCreT{Id: "CRE-2025-0001", Severity: parser.SeverityCritical, Title: "OOM"}
+		slow = parser.ParseCreT{Id: "CRE-2025-0002", Severity: parser.SeverityLow}
+	)
+
+	hit := func(src, host string, at time.Time) matchz.HitsT {
+		return matchz.HitsT{
+			Count:   1,
+			Entries: []matchz.EntryT{{Timestamp: at.UnixNano(), Entry: []byte("boom")}},
+			Entity:  matchz.EntityMetadataT{FileName: src, Labels: map[string]string{"host": host}},
+		}
+	}
+
+	for _, cre := range []parser.ParseCreT{oom, slow} {
+		r.Rules[cre.Id] = parser.ParseRuleT{Cre: cre}
+	}
+	r.AddCreHit(&slow, ts, hit("api.log", "web-1", ts))
+	r.AddCreHit(&oom, ts.Add(time.Second), hit("api.log", "web-1", ts.Add(time.Second)))
+	r.AddCreHit(&oom, ts.Add(time.Minute), hit("db.log", "db-1", ts.Add(time.Minute)))
+	r.AddCreHit(&oom, ts.Add(time.Hour), hit("api.log", "web-1", ts.Add(time.Hour)))
+
+	doc, err := r.CreateReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every hit names its source
+	for _, e := range doc {
+		for _, h := range e["hits"].([]HitEntryT) {
+			if h.Source == "" || h.Labels["host"] == "" {
+				t.Errorf("Expected source and labels on hit, got %+v", h)
+			}
+		}
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := SourcesReport(&out, path, SourcesJson); err != nil {
+		t.Fatal(err)
+	}
+
+	var groups []SourceDetectionsT
+	if err := json.Unmarshal(out.Bytes(), &groups); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(groups) != 2 || groups[0].Source != "api.log" || groups[1].Source != "db.log" {
+		t.Fatalf("Unexpected groups: %+v", groups)
+	}
+
+	api := groups[0]
+	if api.Labels["host"] != "web-1" || len(api.Detections) != 2 {
+		t.Fatalf("Unexpected api.log group: %+v", api)
+	}
+	if d := api.Detections[0]; d.Id != oom.Id || d.Hits != 2 || !d.First.Equal(ts.Add(time.Second)) || !d.Last.Equal(ts.Add(time.Hour)) {
+		t.Errorf("Unexpected detection: %+v", d)
+	}
+	if d := api.Detections[1]; d.Id != slow.Id || d.Hits != 1 {
+		t.Errorf("Unexpected detection: %+v", d)
+	}
+
+	out.Reset()
+	if err := SourcesReport(&out, path, SourcesText); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"api.log host=web-1", "db.log host=db-1", "CRE-2025-0001  critical  2 hits"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in:\n%s", want, out.String())
+		}
+	}
+
+	if err := SourcesReport(&out, path, "yaml"); !errors.Is(err, ErrSourcesFormat) {
+		t.Errorf("Expected ErrSourcesFormat, got %v", err)
+	}
+}
+
+func TestSourcesUntagged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := os.WriteFile(path, []byte(graphReport), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readReportEntries(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, g := range groupBySource(entries) {
+		for _, d := range g.Detections {
+			got = append(got, g.Source+" "+d.Id)
+		}
+	}
+
+	// Untagged hits fall back to the only source of their entry
+	want := "(unknown) CRE-2.(unknown) CRE-3.cre.log.redis CRE-1"
+	if s := strings.Join(got, "."); s != want {
+		t.Errorf("Expected %s, got %s", want, s)
+	}
+}
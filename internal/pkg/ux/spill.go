@@ -0,0 +1,52 @@
+package ux
+
+import (
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/matchz"
+	"github.com/prequel-dev/preq/internal/pkg/spillz"
+)
+
+// spillT moves the hits of the least recently active detections to a
+// temporary file once the hits held in memory exceed a budget. Evicted hits
+// are read back when the report is built.
+type spillT struct {
+	*spillz.StoreT[spillRecT]
+}
+
+type spillRecT struct {
+	Ts   time.Time    `json:"ts"`
+	Hits matchz.HitsT `json:"hits"`
+}
+
+func newSpill(limit int64, dir string) *spillT {
+	return &spillT{spillz.New[spillRecT](limit, dir)}
+}
+
+// write appends the hits of id to the spill file.
+func (s *spillT) write(id string, hits map[time.Time][]matchz.HitsT) error {
+	var recs []spillRecT
+	for ts, ms := range hits {
+		for _, m := range ms {
+			recs = append(recs, spillRecT{Ts: ts, Hits: m})
+		}
+	}
+	return s.Write(id, recs)
+}
+
+// read returns the spilled hits of id by timestamp.
+func (s *spillT) read(id string) (map[int64][]matchz.HitsT, error) {
+
+	recs, err := s.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make(map[int64][]matchz.HitsT)
+	for _, rec := range recs {
+		ts := rec.Ts.UnixNano()
+		hits[ts] = insertHit(hits[ts], rec.Hits)
+	}
+
+	return hits, nil
+}
@@ -52,6 +52,7 @@ const (
 
 const (
 	authUrlFmt         = "Automatic updates of community CREs and new releases of preq are available to users for free.\nTo receive secure updates, complete the OAuth 2.0 device code process. You will not be prompted to do this again until the token expires in 3 months.\n\nAttempting to automatically open SSO authorization in your default browser.\nIf the browser does not open or you wish to use a different device to authorize this request, open the following URL: \n\n%s\n\n"
+	ssoAuthFmt         = "Log in to receive secure updates with your organization's identity provider.\nAttempting to automatically open it in your default browser.\nIf the browser does not open or you wish to use a different device, open the following URL and enter the code %s:\n\n%s\n\n"
 	emailVerifyTitle   = "\nYou're one step away! Please verify your email\n"
 	emailVerifyBodyFmt = "It looks like your email (%s) has not been verified yet. Check your inbox for a verification link from "
 	emailVerifyFooter  = " and click it to activate your account. If you do not see the email, check your spam folder.\n\nSee https://docs.prequel.dev/updates for more information.\n\n"
@@ -91,16 +92,124 @@ Happy hunting!`
 
 var (
 	HelpAction        = "Path to an automated action or runbook config file"
+	HelpBegin         = "Skip events before this time (RFC3339 or a duration ago, e.g. 24h; a leading minus needs --begin=-24h)"
+	HelpBaseline      = "Path to a previous report; detections of CREs it already holds for the same source are noted as known and only new ones are reported"
+	HelpCheckpoint    = "Resume from, and save progress to, a named checkpoint under the data directory ($XDG_DATA_HOME/preq or ~/.prequel)"
+	HelpCollapse      = "Collapse runs of identical lines before matching, keeping the first lines of each run that a rule can count and the last"
+	HelpConfigDir     = "Config directory, of config, login, registries and rules (default: $XDG_CONFIG_HOME/preq or ~/.config/preq)"
+	HelpContext       = "Capture N lines before and after each matched line in the report"
+	HelpCoverage      = "After the run, print which rules applied to the sources, which found nothing and which were skipped for want of their sources"
 	HelpCron          = "Generate Kubernetes cronjob template"
+	HelpDaemon        = "Run resident: follow data sources, reload rules as they change, and send each detection to the --action runbook as it is found"
+	HelpDedupWindow   = "Collapse detections of a CRE that follow one another within this window (e.g. 5m) into one report entry with a count"
 	HelpDisabled      = "Do not run community CREs"
+	HelpEnd           = "Stop at events after this time (RFC3339 or a duration ago, e.g. 1h)"
+	HelpExplain       = "For each detection of this CRE id, print which rule conditions matched which lines and whether the window and order held"
+	HelpFailOn        = "Exit with code 2 when a detection is at least this severe (critical, high, medium, low or info); operational errors exit with 1"
+	HelpFollow        = "Follow data sources and report problems as new lines are written"
 	HelpGenerate      = "Generate data sources template"
+	HelpHead          = "Only read the first N lines of each source"
 	HelpLevel         = "Print logs at this level to stderr"
+	HelpLookback      = "While following, keep this much history per source (e.g. 15m) and match rules reloaded in daemon mode against it"
+	HelpMaxLines      = "Stop reading each source after N lines"
+	HelpMemoryLimit   = "Memory budget in MiB shared by reorder buffers, correlation state and detection hits; state beyond it is spilled to a temporary file"
+	HelpMinSeverity   = "Only run the rules of CREs at least this severe: critical, high, medium, low or info"
+	HelpTags          = "Only run the rules of CREs with one of these tags or technologies, e.g. kafka,postgres"
+	HelpExcludeTags   = "Do not run the rules of CREs with any of these tags or technologies"
 	HelpName          = "Output name for reports, data source templates, or notifications"
+	HelpParallel      = "Parse up to N logs of a source at once, merged by time (default: number of CPUs, 1 to disable)"
+	HelpPolicy        = "Path to a policy file mapping detections to pass, warn or fail exit codes"
+	HelpProfile       = "Profile to run with, its own config, login and registries; PREQ_PROFILE also sets it (default: the one of preq profile use)"
+	HelpPprof         = "Serve pprof profiles and runtime metrics on this address during the run, e.g. :6060 (loopback only unless a host is named)"
+	HelpProfileRules  = "Print the time each rule spent matching and the events it examined, slowest first"
+	HelpQueuePolicy   = "What to do when a queue is full while following: block, drop-newest or drop-oldest"
+	HelpQueueSize     = "Lines queued per followed source between reading and matching"
 	HelpQuiet         = "Quiet mode, do not print progress"
-	HelpRules         = "Path to a CRE rules file"
-	HelpSource        = "Path to a data source Yaml file"
+	HelpReport        = "Work with preq reports"
+	HelpReportGraph   = "Print a Mermaid or DOT graph of correlated detections in a report"
+	HelpReportPath    = "Path to a preq report JSON file"
+	HelpRulesCmd      = "Work with the installed rules: the cached community rules and -r rule files"
+	HelpRulesList     = "List the installed rules with their CRE id, severity, category, technology and title"
+	HelpRulesShow     = "Print the description, mitigation, references and full rule definition of a CRE"
+	HelpRulesSearch   = "Search the installed rules by keyword, technology, category, tag and severity"
+	HelpSearchWords   = "Words that must all appear in the CRE id, title, description, cause, impact, mitigation, tags or references"
+	HelpSearchTech    = "Only rules for this technology, e.g. rabbitmq"
+	HelpSearchCat     = "Only rules in this category"
+	HelpSearchTag     = "Only rules with this tag; repeat to require several"
+	HelpSearchSev     = "Only rules at least this severe: critical, high, medium, low or info"
+	HelpRulesLint     = "Check rule files for schema errors, matchers that fail to compile, duplicate ids and missing metadata"
+	HelpLintPaths     = "Rule files or directories to check; defaults to the -r rules and the rule paths of the config file"
+	HelpRulesTest     = "Run rules over the sample logs of their test fixtures and check the CREs detected"
+	HelpTestPaths     = "Rule files or directories to test; a fixture of rules.yaml is rules.test.yaml next to it"
+	HelpRulesExport   = "Write the downloaded community and registry rules to a bundle for hosts without network access"
+	HelpExportBundle  = "Path of the bundle to write, e.g. rules.tgz"
+	HelpRulesImport   = "Check the checksums of a rules bundle made by preq rules export and install its rules"
+	HelpImportBundle  = "Path of the bundle to import"
+	HelpRulesUpdate   = "Install newer community rules and preq releases, and fetch the remote registries, now"
+	HelpUpdateCheck   = "Only report whether newer rules or releases exist; never prompt or install"
+	HelpRulesDiff     = "List the CREs added, removed and changed between two rule releases, such as before accepting an update"
+	HelpDiffOld       = "Older rules: a community rules version kept in the config directory, current, or a rules file or directory"
+	HelpDiffNew       = "Newer rules, given as for the older"
+	HelpRulesCache    = "Inspect and clean up the rules downloaded to the config directory"
+	HelpCacheInfo     = "List the cached rules releases, registry copies and leftovers, with their sizes and paths"
+	HelpCachePrune    = "Remove older rules releases, the copies of registries no longer configured, and leftovers"
+	HelpCacheClear    = "Remove all downloaded rules; the next run downloads them again"
+	HelpCacheKeep     = "Number of the newest rules releases to keep"
+	HelpRulesGet      = "Write the rule of one CRE, and the terms it uses, to a rules file of its own to scan for with -r"
+	HelpGetOutput     = "File to write the rule to, - for stdout; <id>.yaml in the working directory by default"
+	HelpGetForce      = "Overwrite the file if it exists"
+	HelpCreId         = "CRE id, e.g. CRE-2025-0025"
+	HelpJson          = "Print JSON for scripts"
+	HelpReportSources = "Print the detections in a report grouped by the source, and its labels, they were found in"
+	HelpSourcesFormat = "Output format: text or json"
+	HelpGraphFormat   = "Graph format: mermaid or dot"
+	HelpGraphWindow   = "Link detections whose hits are within this duration of each other"
+	HelpRules         = "Path to a CRE rules file, or its http(s) url; pin a url with #sha256=<hex>"
+	HelpRuleTimeout   = "Disable a rule for the rest of the run when it costs more CPU time than this to match a line (e.g. 10ms), on average over 1000 lines; the report notes the rules disabled"
+	HelpRotated       = "Also scan rotated siblings of each log file (app.log.1, app.log.2.gz)"
+	HelpSampleRate    = "Scan only this fraction of lines, evenly spaced (e.g. 0.1); the report notes the sampling"
+	HelpSource        = "Path to a data source Yaml file or a tar archive of logs"
+	HelpStdinFormat   = "Parse piped input as json, logfmt, plain or cri instead of detecting its format"
+	HelpTail          = "Only read the last N lines of each source"
+	HelpTrace         = "Record an execution trace of the run to this file, for go tool trace"
+	HelpTz            = "Timezone of timestamps written without one, e.g. America/New_York, Local or +02:00 (default UTC)"
 	HelpVersion       = "Print version and exit"
+	HelpYear          = "Year of timestamps written without one, e.g. RFC 3164 syslog (default: inferred from each file's modification time)"
 	HelpAcceptUpdates = "Accept updates to rules or new release"
+	HelpNoUpdateCheck = "Never check for updates or download rules; run the rules already installed"
+	HelpNoStrict      = "Ignore keys of the config file that are not settings, instead of refusing the config"
+	HelpNoProject     = "Ignore the .preq.yaml of the working directory or those above it"
+	HelpTrustProject  = "Trust the .preq.yaml to set data sources, which may run commands or read any file"
+	HelpOffline       = "Never reach the network: no login, update checks, downloads, stats pushes or webhooks; scan with the local rules of -r only"
+	HelpToken         = "API token for rule updates in CI and automation, instead of logging in; PREQ_API_TOKEN also sets it"
+
+	HelpLogin    = "Log in to receive rule updates, replacing any saved login"
+	HelpLoginSso = "Log in through your organization's identity provider, the sso issuer and clientId of the config"
+
+	HelpAuthCmd     = "Inspect, refresh or remove the login rule updates use"
+	HelpAuthStatus  = "Show the account, expiry and storage of the login in use"
+	HelpAuthLogout  = "Remove the saved login of the profile, from the keychain or file"
+	HelpAuthRefresh = "Get a new token for an sso login now, before it expires"
+
+	HelpProfileCmd  = "Work with profiles, each with its own config, login and registries"
+	HelpProfileList = "List the profiles, the current one marked"
+	HelpProfileUse  = "Run with a profile by default, creating it if new; default goes back to the main config"
+	HelpProfileName = "Profile name"
+
+	HelpConfigCmd   = "Write, read and change the config file of the profile"
+	HelpConfigInit  = "Write a commented default config file"
+	HelpConfigGet   = "Print the value of a setting a run uses, e.g. proxy.url"
+	HelpConfigSet   = "Set a setting in the config file, keeping its comments"
+	HelpConfigView  = "Print the configuration a run uses: the config file, with the environment and flags over it"
+	HelpConfigForce = "Overwrite the config file if it exists"
+	HelpConfigKey   = "Setting, by its dotted path in the config file, e.g. rules.disableCommunityRules"
+	HelpConfigValue = "Value, as YAML; lists and sections are set whole, e.g. '[a, b]'"
+	HelpSecretCmd   = "Store a secret, read from stdin, in the OS keychain, for !secret keychain:<name> in config files"
+	HelpSecretName  = "Name of the secret"
+
+	HelpInstallCompletions = "Install shell completions and check PATH setup"
+	HelpCompletionShell    = "Shell to install completions for: bash, zsh, fish or powershell (default: detect)"
+	HelpCompletionPrint    = "Print the completion script instead of installing it"
 )
 
 type StatsT map[string]int64
@@ -332,3 +441,7 @@ func WriteDataSourceTemplate(name string, ver *semver.Version, template []byte)
 func PrintDeviceAuthUrl(url string) {
 	fmt.Fprintf(os.Stdout, authUrlFmt, url)
 }
+
+func PrintSsoDeviceAuth(url, userCode string) {
+	fmt.Fprintf(os.Stdout, ssoAuthFmt, userCode, url)
+}
@@ -2,65 +2,88 @@ package eval
 
 import (
 	"context"
+	"encoding/json"
+	"io"
+	"strings"
 
 	"github.com/prequel-dev/preq/internal/pkg/config"
-	"github.com/prequel-dev/preq/internal/pkg/engine"
-	"github.com/prequel-dev/preq/internal/pkg/resolve"
-	"github.com/prequel-dev/preq/internal/pkg/utils"
 	"github.com/prequel-dev/preq/internal/pkg/ux"
-	"github.com/prequel-dev/prequel-logmatch/pkg/timez"
+	"github.com/prequel-dev/preq/pkg/preq"
 	"github.com/rs/zerolog/log"
 )
 
 func Detect(ctx context.Context, c *config.Config, data, rule string) (ux.ReportDocT, ux.StatsT, error) {
+	return DetectReader(ctx, c, strings.NewReader(data), rule)
+}
 
-	var (
-		run          *engine.RuntimeT
-		report       *ux.ReportT
-		ruleMatchers *engine.RuleMatchersT
-		sources      []*engine.LogData
-		reportData   ux.ReportDocT
-		stats        ux.StatsT
-		err          error
-	)
+// DetectReader runs the rules against a stream of log data. The input is
+// consumed incrementally so callers need not hold it in memory.
+func DetectReader(ctx context.Context, c *config.Config, rd io.Reader, rule string) (ux.ReportDocT, ux.StatsT, error) {
 
 	if c == nil {
 		c = config.DefaultConfig()
 	}
 
-	opts := c.ResolveOpts()
-	opts = append(opts, resolve.WithTimestampTries(timez.DefaultSkip))
+	opts := configOpts(c)
 
-	if sources, err = resolve.PipeEval([]byte(data), opts...); err != nil {
-		log.Error().Err(err).Msg("Failed to create pipe reader")
+	rules, err := preq.ParseRules([]byte(rule))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to compile rules")
 		return nil, nil, err
 	}
 
-	run = engine.New(utils.GetStopTime(), ux.NewUxEval())
-	defer run.Close()
-
-	report = ux.NewReport(nil)
-
-	if ruleMatchers, err = run.CompileRules([]byte(rule), report); err != nil {
-		log.Error().Err(err).Msg("Failed to compile rules")
+	sources, err := preq.ResolveReader(rd, opts...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create pipe reader")
 		return nil, nil, err
 	}
 
-	if err = run.Run(ctx, ruleMatchers, sources, report); err != nil {
+	report, err := preq.Run(ctx, rules, sources, opts...)
+	if err != nil {
 		log.Error().Err(err).Msg("Failed to run stdin")
 		return nil, nil, err
 	}
 
-	if reportData, err = report.CreateReport(); err != nil {
-		log.Error().Err(err).Msg("Failed to create report")
-		return nil, nil, err
+	reportData := make(ux.ReportDocT, 0, len(report.Detections))
+	for _, d := range report.Detections {
+		e, err := reportEntry(d)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to create report")
+			return nil, nil, err
+		}
+		reportData = append(reportData, e)
 	}
 
-	stats, err = run.Ux.FinalStats()
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to get final stats, continue...")
+	stats := ux.StatsT{
+		"rules":    report.Stats.Rules,
+		"problems": report.Stats.Detections,
+		"lines":    report.Stats.Lines,
+		"bytes":    report.Stats.Bytes,
 	}
 
 	return reportData, stats, nil
+}
+
+func configOpts(c *config.Config) []preq.OptT {
 
+	var opts []preq.OptT
+
+	for _, r := range c.TimestampRegexes {
+		opts = append(opts, preq.WithTimestamp(strings.TrimSpace(r.Pattern), strings.TrimSpace(r.Format)))
+	}
+	if len(c.SourceWindows) > 0 {
+		opts = append(opts, preq.WithSourceWindows(c.SourceWindows))
+	}
+
+	return opts
+}
+
+// reportEntry converts a detection to the entry the CLI writes, so callers
+// of eval see the same documents as before.
+func reportEntry(d preq.Detection) (map[string]any, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return ux.DecodeReportEntry(data)
 }
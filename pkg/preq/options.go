@@ -0,0 +1,166 @@
+package preq
+
+import (
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/resolve"
+	"github.com/prequel-dev/prequel-logmatch/pkg/timez"
+)
+
+// OptT configures Resolve and Run. Options that shape how logs are read
+// apply when resolving; WithEnd, WithParallel, WithContext and
+// WithDetections apply to Run.
+type OptT func(*optsT)
+
+type optsT struct {
+	window     time.Duration
+	srcWindows map[string]time.Duration
+	stamps     []resolve.FmtSpec // tried before the built-in formats
+	begin      time.Time
+	end        time.Time
+	loc        *time.Location
+	year       int
+	format     string
+	parallel   int
+	context    int
+	detections chan<- Detection
+	resolve    []resolve.OptT // applied last, over the options above
+}
+
+func parseOpts(opts ...OptT) *optsT {
+	o := &optsT{parallel: 1}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *optsT) resolveOpts() []resolve.OptT {
+
+	opts := []resolve.OptT{
+		resolve.WithStampRegex(append(o.stamps, defaultStamps()...)...),
+		resolve.WithTimestampTries(timez.DefaultSkip),
+	}
+
+	if o.window > 0 {
+		opts = append(opts, resolve.WithWindow(int64(o.window)))
+	}
+	if len(o.srcWindows) > 0 {
+		opts = append(opts, resolve.WithSourceWindows(o.srcWindows))
+	}
+	if !o.begin.IsZero() {
+		opts = append(opts, resolve.WithBegin(o.begin))
+	}
+	if o.loc != nil {
+		opts = append(opts, resolve.WithTimezone(o.loc))
+	}
+	if o.year != 0 {
+		opts = append(opts, resolve.WithYear(o.year))
+	}
+	if o.format != "" {
+		opts = append(opts, resolve.WithFormat(o.format))
+	}
+
+	return append(opts, o.resolve...)
+}
+
+// WithWindow lets entries arrive out of time order by up to window.
+func WithWindow(window time.Duration) OptT {
+	return func(o *optsT) {
+		o.window = window
+	}
+}
+
+// WithSourceWindows sets the reorder window per source type.
+func WithSourceWindows(windows map[string]time.Duration) OptT {
+	return func(o *optsT) {
+		o.srcWindows = windows
+	}
+}
+
+// WithTimestamp adds a timestamp to try, ahead of the built-in ones, when
+// detecting the format of a log: a regex whose first group captures the
+// timestamp, and a Go layout or one of rfc3339, unix, epochany or
+// dotnotation.
+func WithTimestamp(pattern, format string) OptT {
+	return func(o *optsT) {
+		o.stamps = append(o.stamps, resolve.FmtSpec{Pattern: pattern, Format: resolve.TimestampFmt(format)})
+	}
+}
+
+// WithBegin skips entries stamped before begin.
+func WithBegin(begin time.Time) OptT {
+	return func(o *optsT) {
+		o.begin = begin
+	}
+}
+
+// WithEnd stops each source at the first entry stamped after end.
+func WithEnd(end time.Time) OptT {
+	return func(o *optsT) {
+		o.end = end
+	}
+}
+
+// WithTimezone reads timestamps written without a zone in loc rather than
+// UTC.
+func WithTimezone(loc *time.Location) OptT {
+	return func(o *optsT) {
+		o.loc = loc
+	}
+}
+
+// WithYear assigns year to timestamps written without one.
+func WithYear(year int) OptT {
+	return func(o *optsT) {
+		o.year = year
+	}
+}
+
+// WithFormat parses a reader as json, logfmt, plain or cri instead of
+// detecting its format.
+func WithFormat(format string) OptT {
+	return func(o *optsT) {
+		o.format = format
+	}
+}
+
+// WithParallel parses up to n logs of a source at once, merged by time.
+func WithParallel(n int) OptT {
+	return func(o *optsT) {
+		o.parallel = n
+	}
+}
+
+// WithContext captures n lines before and after each hit.
+func WithContext(n int) OptT {
+	return func(o *optsT) {
+		o.context = n
+	}
+}
+
+// WithDetections sends each hit to ch as Run finds it, as a Detection
+// holding that hit, so callers can act before the run ends. Sends block
+// until ch is received from or the run's context is done; Run does not
+// close ch. The Report returned by Run still holds every detection.
+func WithDetections(ch chan<- Detection) OptT {
+	return func(o *optsT) {
+		o.detections = ch
+	}
+}
+
+// WithResolve passes options straight to the resolver, for front-ends
+// that read logs in ways the options above do not cover.
+func WithResolve(opts ...resolve.OptT) OptT {
+	return func(o *optsT) {
+		o.resolve = append(o.resolve, opts...)
+	}
+}
+
+func defaultStamps() []resolve.FmtSpec {
+	out := make([]resolve.FmtSpec, 0, len(timez.Defaults))
+	for _, r := range timez.Defaults {
+		out = append(out, resolve.FmtSpec{Pattern: r.Pattern, Format: r.Format})
+	}
+	return out
+}
@@ -0,0 +1,303 @@
+// Package preq embeds the preq detection engine. Rules are loaded once,
+// data sources are resolved, and Run matches the rules against them and
+// returns the detections:
+//
+//	rules, err := preq.LoadRules("rules.yaml")
+//	...
+//	sources, err := preq.ResolveFile("sources.yaml")
+//	...
+//	report, err := preq.Run(ctx, rules, sources)
+//	for _, d := range report.Detections {
+//		fmt.Println(d.Id, d.Cre.Title, len(d.Hits))
+//	}
+//
+// Run closes the sources it is given. The API follows semantic versioning
+// with the preq module.
+package preq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/prequel-dev/preq/internal/pkg/engine"
+	"github.com/prequel-dev/preq/internal/pkg/resolve"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+	"github.com/prequel-dev/prequel-compiler/pkg/datasrc"
+	"github.com/prequel-dev/prequel-compiler/pkg/parser"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrNoRules   = errors.New("no rules")
+	ErrNoSources = errors.New("no data sources")
+)
+
+// Rules is a validated set of CREs. It is safe to share between runs.
+type Rules struct {
+	paths []utils.RulePathT
+	data  []byte
+}
+
+// LoadRules reads rules files. Each may hold several documents, and rules
+// without an id are given one.
+func LoadRules(paths ...string) (*Rules, error) {
+
+	if len(paths) == 0 {
+		return nil, ErrNoRules
+	}
+
+	r := &Rules{}
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			return nil, err
+		}
+		r.paths = append(r.paths, utils.RulePathT{Path: p, Type: utils.RuleTypeUser})
+	}
+
+	return r, r.validate()
+}
+
+// ParseRules reads a rules document.
+func ParseRules(data []byte) (*Rules, error) {
+
+	if len(data) == 0 {
+		return nil, ErrNoRules
+	}
+
+	r := &Rules{data: data}
+	return r, r.validate()
+}
+
+func (r *Rules) validate() error {
+	_, err := r.compile(engine.New(utils.GetStopTime(), ux.NewUxEval()), ux.NewReport(nil))
+	return err
+}
+
+// compile binds the rules to report; matchers are stateful, so every run
+// compiles its own.
+func (r *Rules) compile(rt *engine.RuntimeT, report *ux.ReportT) (*engine.RuleMatchersT, error) {
+	if r.data != nil {
+		return rt.CompileRules(r.data, report)
+	}
+	return rt.LoadRulesPaths(report, r.paths)
+}
+
+// Source is a resolved data source: one or more logs read as a single
+// stream of the same type.
+type Source struct {
+	ld *resolve.LogData
+}
+
+func (s *Source) Name() string {
+	return s.ld.Name()
+}
+
+// Type is the source type rules select on, e.g. cre.log.kafka.
+func (s *Source) Type() string {
+	return s.ld.SrcType()
+}
+
+func (s *Source) Close() error {
+	return s.ld.Close()
+}
+
+func wrapSources(lds []*resolve.LogData) []*Source {
+	out := make([]*Source, 0, len(lds))
+	for _, ld := range lds {
+		out = append(out, &Source{ld: ld})
+	}
+	return out
+}
+
+// Resolve opens the sources described by a data sources document. Sources
+// whose locations cannot be opened are skipped.
+func Resolve(data []byte, opts ...OptT) ([]*Source, error) {
+
+	ds, err := resolve.ParseSources(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = datasrc.Validate(ds.Base()); err != nil {
+		return nil, err
+	}
+
+	return wrapSources(resolve.Resolve(ds, parseOpts(opts...).resolveOpts()...)), nil
+}
+
+// ResolveFile opens the sources described by a data sources file, or
+// every log in a tar archive.
+func ResolveFile(path string, opts ...OptT) ([]*Source, error) {
+
+	if resolve.IsArchive(path) {
+		lds, err := resolve.ResolveArchive(path, parseOpts(opts...).resolveOpts()...)
+		if err != nil {
+			return nil, err
+		}
+		return wrapSources(lds), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Resolve(data, opts...)
+}
+
+// ResolveReader reads a single log from rd, detecting its format unless
+// WithFormat is given. The source matches rules of any type.
+func ResolveReader(rd io.Reader, opts ...OptT) ([]*Source, error) {
+
+	lds, err := resolve.PipeReader(rd, parseOpts(opts...).resolveOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	return wrapSources(lds), nil
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Detections []Detection `json:"detections"`
+	Stats      Stats       `json:"stats"`
+}
+
+// Detection is one CRE found in the data. It marshals to the same JSON as
+// an entry of a report written by the preq CLI.
+type Detection struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Id        string            `json:"id"`
+	Cre       parser.ParseCreT  `json:"cre"`
+	RuleId    string            `json:"rule_id"`
+	RuleHash  string            `json:"rule_hash"`
+	Hits      []Hit             `json:"hits"`
+	Sources   []string          `json:"sources,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// Hit is one log entry that contributed to a detection.
+type Hit struct {
+	Timestamp time.Time `json:"timestamp"`
+	Entry     string    `json:"entry"`
+	Context   *Context  `json:"context,omitempty"`
+}
+
+// Context is the lines read around a hit, when requested with WithContext.
+type Context struct {
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+type Stats struct {
+	Rules      int64         `json:"rules"`
+	Detections int64         `json:"problems"`
+	Lines      int64         `json:"lines"`
+	Bytes      int64         `json:"bytes"`
+	Sources    int           `json:"sources"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// Run matches rules against sources until they are exhausted, ctx is done
+// or the WithEnd time is reached, then closes the sources.
+func Run(ctx context.Context, rules *Rules, sources []*Source, opts ...OptT) (*Report, error) {
+
+	if rules == nil {
+		return nil, ErrNoRules
+	}
+	if len(sources) == 0 {
+		return nil, ErrNoSources
+	}
+
+	var (
+		o      = parseOpts(opts...)
+		stop   = utils.GetStopTime()
+		uxEval = ux.NewUxEval()
+		report = ux.NewReport(nil)
+	)
+
+	if !o.end.IsZero() {
+		stop = o.end.UnixNano()
+	}
+
+	rt := engine.New(stop, uxEval)
+	defer rt.Close()
+
+	rt.SetParallel(o.parallel)
+	rt.SetContext(o.context)
+
+	if o.detections != nil {
+		rt.SetOnDetection(sendDetections(ctx, o.detections))
+	}
+
+	session, err := NewSession(rt, report, rules)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = session.Run(ctx, sources); err != nil {
+		return nil, err
+	}
+
+	doc, err := report.CreateReport()
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Report{Detections: make([]Detection, 0, len(doc))}
+	for _, e := range doc {
+		d, err := newDetection(e)
+		if err != nil {
+			return nil, err
+		}
+		out.Detections = append(out.Detections, d)
+	}
+
+	stats, _ := uxEval.FinalStats()
+	run := rt.Stats()
+
+	out.Stats = Stats{
+		Rules:      stats["rules"],
+		Detections: stats["problems"],
+		Lines:      stats["lines"],
+		Bytes:      stats["bytes"],
+		Sources:    run.Sources,
+		Duration:   run.Duration,
+	}
+
+	return out, nil
+}
+
+func sendDetections(ctx context.Context, ch chan<- Detection) func(ux.ReportDocT) {
+	return func(doc ux.ReportDocT) {
+		for _, e := range doc {
+			d, err := newDetection(e)
+			if err != nil {
+				log.Warn().Err(err).Msg("Failed to convert detection")
+				continue
+			}
+			select {
+			case ch <- d:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func newDetection(entry map[string]any) (Detection, error) {
+	var d Detection
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return d, err
+	}
+	if err = json.Unmarshal(data, &d); err != nil {
+		return d, fmt.Errorf("invalid report entry: %w", err)
+	}
+	return d, nil
+}
@@ -0,0 +1,223 @@
+package preq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prequel-dev/preq/internal/pkg/engine"
+	"github.com/prequel-dev/preq/internal/pkg/resolve"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+)
+
+func TestRun(t *testing.T) {
+
+	rules, err := LoadRules("../../examples/13-string-example.yaml")
+	if err != nil {
+		t.Fatalf("LoadRules: %v", err)
+	}
+
+	// Rules are reusable; each run compiles its own matchers
+	for i := 0; i < 2; i++ {
+		f, err := os.Open("../../examples/13-example.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sources, err := ResolveReader(f)
+		if err != nil {
+			t.Fatalf("ResolveReader: %v", err)
+		}
+
+		report, err := Run(context.Background(), rules, sources)
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+
+		if len(report.Detections) != 1 {
+			t.Fatalf("run %d: expected 1 detection, got %d", i, len(report.Detections))
+		}
+
+		d := report.Detections[0]
+		if d.Cre.Id != "string-example-1" || d.RuleId != "yGbWBUFtXu7R2hhuNJnJ4k" || len(d.Hits) == 0 {
+			t.Errorf("unexpected detection: %+v", d)
+		}
+		if report.Stats.Lines != 9 || report.Stats.Detections != 1 || report.Stats.Sources != 1 {
+			t.Errorf("unexpected stats: %+v", report.Stats)
+		}
+	}
+}
+
+func TestDetections(t *testing.T) {
+
+	rules, err := LoadRules("../../examples/13-string-example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open("../../examples/13-example.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := ResolveReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ch   = make(chan Detection)
+		done = make(chan []Detection)
+	)
+
+	go func() {
+		var got []Detection
+		for d := range ch {
+			got = append(got, d)
+		}
+		done <- got
+	}()
+
+	report, err := Run(context.Background(), rules, sources, WithDetections(ch))
+	close(ch)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	got := <-done
+	if len(got) != 1 || got[0].Cre.Id != "string-example-1" || len(got[0].Hits) != 1 {
+		t.Fatalf("unexpected streamed detections: %+v", got)
+	}
+	if len(report.Detections) != 1 {
+		t.Errorf("expected report to keep the detection, got %d", len(report.Detections))
+	}
+}
+
+func TestResolveFile(t *testing.T) {
+
+	var (
+		dir  = t.TempDir()
+		data = filepath.Join(dir, "sources.yaml")
+		logs = filepath.Join(dir, "nginx.log")
+	)
+
+	in, err := os.ReadFile("../../examples/13-example.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(logs, in, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := "version: 0.0.1\nsources:\n  - name: nginx\n    type: cre.log.kafka\n    locations:\n      - path: " + logs + "\n"
+	if err = os.WriteFile(data, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := ResolveFile(data)
+	if err != nil {
+		t.Fatalf("ResolveFile: %v", err)
+	}
+	if len(sources) != 1 || sources[0].Type() != "cre.log.kafka" {
+		t.Fatalf("unexpected sources: %d", len(sources))
+	}
+
+	rules, err := LoadRules("../../examples/13-string-example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Run(context.Background(), rules, sources)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(report.Detections) != 1 {
+		t.Fatalf("expected 1 detection, got %d", len(report.Detections))
+	}
+
+	// Detections marshal like entries of a CLI report
+	out, err := json.Marshal(report.Detections[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err = json.Unmarshal(out, &m); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"timestamp", "id", "cre", "rule_id", "rule_hash", "hits"} {
+		if _, ok := m[k]; !ok {
+			t.Errorf("missing %q in %s", k, out)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+
+	if _, err := LoadRules(); !errors.Is(err, ErrNoRules) {
+		t.Errorf("expected ErrNoRules, got %v", err)
+	}
+	if _, err := ParseRules([]byte("rules: [")); err == nil {
+		t.Error("expected error for invalid rules")
+	}
+
+	rules, err := ParseRules([]byte(`rules:
+  - cre:
+      id: test
+    metadata:
+      id: yGbWBUFtXu7R2hhuNJnJ4k
+      hash: r7AM3gA9zeaG3sA7ykCi72
+    rule:
+      set:
+        event:
+          source: cre.log.kafka
+        match:
+          - value: "still could not bind()"
+`))
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	if _, err = Run(context.Background(), rules, nil); !errors.Is(err, ErrNoSources) {
+		t.Errorf("expected ErrNoSources, got %v", err)
+	}
+}
+
+// The CLI builds its own runtime and report and runs them as a Session
+func TestSession(t *testing.T) {
+
+	var (
+		rt     = engine.New(utils.GetStopTime(), ux.NewUxEval())
+		report = ux.NewReport(nil)
+		paths  = []utils.RulePathT{{Path: "../../examples/13-string-example.yaml", Type: utils.RuleTypeUser}}
+	)
+	defer rt.Close()
+
+	session, err := NewSession(rt, report, RulesFromPaths(paths))
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+
+	f, err := os.Open("../../examples/13-example.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := ResolveReader(f, WithResolve(resolve.WithTimestampTries(1)))
+	if err != nil {
+		t.Fatalf("ResolveReader: %v", err)
+	}
+
+	if err = session.Run(context.Background(), sources); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Size() != 1 {
+		t.Errorf("expected 1 detection, got %d", report.Size())
+	}
+
+	if err = session.Run(context.Background(), nil); !errors.Is(err, ErrNoSources) {
+		t.Errorf("expected ErrNoSources, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package preq
+
+import (
+	"context"
+
+	"github.com/prequel-dev/preq/internal/pkg/engine"
+	"github.com/prequel-dev/preq/internal/pkg/resolve"
+	"github.com/prequel-dev/preq/internal/pkg/utils"
+	"github.com/prequel-dev/preq/internal/pkg/ux"
+)
+
+// Session runs rules on a runtime and report configured by the caller.
+// Run covers most uses; a Session serves preq's own front-ends, which tune
+// the engine beyond what the options offer, so that they compile, resolve
+// and run through the same steps as the SDK.
+type Session struct {
+	Runtime  *engine.RuntimeT
+	Report   *ux.ReportT
+	Matchers *engine.RuleMatchersT
+}
+
+// RulesFromPaths wraps rules files already gathered by the caller. They
+// are not validated until a Session compiles them.
+func RulesFromPaths(paths []utils.RulePathT) *Rules {
+	return &Rules{paths: paths}
+}
+
+// NewSession compiles rules for rt, binding their detections to report.
+func NewSession(rt *engine.RuntimeT, report *ux.ReportT, rules *Rules) (*Session, error) {
+
+	if rules == nil {
+		return nil, ErrNoRules
+	}
+
+	matchers, err := rules.compile(rt, report)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{Runtime: rt, Report: report, Matchers: matchers}, nil
+}
+
+// Run matches the session's rules against sources until they are
+// exhausted or ctx is done, then closes the sources.
+func (s *Session) Run(ctx context.Context, sources []*Source) error {
+
+	if len(sources) == 0 {
+		return ErrNoSources
+	}
+
+	lds := make([]*resolve.LogData, 0, len(sources))
+	for _, src := range sources {
+		lds = append(lds, src.ld)
+	}
+
+	return s.Runtime.Run(ctx, s.Matchers, lds, s.Report)
+}
+
+// ResolveStdin reads a single log piped to stdin, as ResolveReader does.
+// It returns no sources when stdin is a terminal.
+func ResolveStdin(opts ...OptT) ([]*Source, error) {
+
+	lds, err := resolve.PipeStdin(parseOpts(opts...).resolveOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	return wrapSources(lds), nil
+}
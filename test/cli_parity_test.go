@@ -32,6 +32,10 @@ func cliFlagSet() map[string]struct{} {
 	flags := map[string]struct{}{}
 	t := reflect.TypeOf(cli.Options)
 	for i := 0; i < t.NumField(); i++ {
+		// Subcommands are not scan flags and have no krew equivalent
+		if _, ok := t.Field(i).Tag.Lookup("cmd"); ok {
+			continue
+		}
 		name := flagNameFromField(t.Field(i).Name)
 		flags[name] = struct{}{}
 	}
@@ -122,6 +122,47 @@ func TestSuccessExamples(t *testing.T) {
 			rulePath: "../examples/29-negate-slide-anchor-1-window.yaml",
 			dataPath: "../examples/29-example-fp-moved.log",
 		},
+		"Example33": {
+			rulePath: "../examples/33-absence-example.yaml",
+			dataPath: "../examples/33-example.log",
+		},
+		"Example33-elected": {
+			rulePath: "../examples/33-absence-example.yaml",
+			dataPath: "../examples/33-example-elected.log",
+			negative: true,
+		},
+		// The data ends before the window closes, so absence is unknown
+		"Example33-open": {
+			rulePath: "../examples/33-absence-example.yaml",
+			dataPath: "../examples/33-example-open.log",
+			negative: true,
+		},
+		"Example43": {
+			rulePath: "../examples/43-cel-example.yaml",
+			dataPath: "../examples/43-example.log",
+		},
+		"Example43-logfmt": {
+			rulePath: "../examples/43-cel-example.yaml",
+			dataPath: "../examples/43-example-logfmt.log",
+		},
+		"Example43-fast": {
+			rulePath: "../examples/43-cel-example.yaml",
+			dataPath: "../examples/43-example-fast.log",
+			negative: true,
+		},
+		"Example44": {
+			rulePath: "../examples/44-rate-example.yaml",
+			dataPath: "../examples/44-example.log",
+		},
+		"Example44-steady": {
+			rulePath: "../examples/44-rate-example.yaml",
+			dataPath: "../examples/44-example-steady.log",
+			negative: true,
+		},
+		"Example45": {
+			rulePath: "../examples/45-extract-example.yaml",
+			dataPath: "../examples/45-example.log",
+		},
 		"Missing-IDs": {
 			rulePath: "missing-ids.yaml",
 			dataPath: "missing-ids.log",